@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// useASNLists 在测试期间使用 -exclude-asn 和 -asn-allowlist 解析出的列表
+func useASNLists(t *testing.T, excluded, allowed string) {
+	t.Helper()
+	previousExcluded, previousAllowed := excludedASNs, allowedASNs
+	t.Cleanup(func() { excludedASNs, allowedASNs = previousExcluded, previousAllowed })
+	var err error
+	if excludedASNs, err = parseASNList(excluded); err != nil {
+		t.Fatal(err)
+	}
+	if allowedASNs, err = parseASNList(allowed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseASNList(t *testing.T) {
+	asns, err := parseASNList(" AS9009, as212238,13335,, ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asns) != 3 || !asns[9009] || !asns[212238] || !asns[13335] {
+		t.Errorf("parseASNList = %v", asns)
+	}
+	if asns, err := parseASNList(""); err != nil || len(asns) != 0 {
+		t.Errorf("empty list = %v, %v", asns, err)
+	}
+	for _, list := range []string{"AS", "ASN9009", "-1", "9009,x"} {
+		if _, err := parseASNList(list); err == nil {
+			t.Errorf("parseASNList(%q) accepted", list)
+		}
+	}
+}
+
+func TestIsASNAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		excluded string
+		allowed  string
+		asn      int
+		want     bool
+	}{
+		{"no lists", "", "", 9009, true},
+		{"excluded", "9009", "", 9009, false},
+		{"not excluded", "9009", "", 13335, true},
+		// 未知 ASN 不会被排除，但无法通过白名单
+		{"unknown not excluded", "9009", "", 0, true},
+		{"allowed", "", "13335", 13335, true},
+		{"not allowed", "", "13335", 9009, false},
+		{"unknown not allowed", "", "13335", 0, false},
+		{"excluded wins", "13335", "13335", 13335, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useASNLists(t, tt.excluded, tt.allowed)
+			if got := isASNAllowed(tt.asn); got != tt.want {
+				t.Errorf("isASNAllowed(%d) = %v, want %v", tt.asn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestASNUsable(t *testing.T) {
+	useASNLists(t, "AS9009", "")
+	result := &speedtester.Result{
+		ProxyName:            "M247",
+		Latency:              100 * time.Millisecond,
+		DownloadSpeed:        5 * 1024 * 1024,
+		ExtraURLConnectivity: true,
+		ExitASN:              9009,
+	}
+	if isProxyUsable(result) || isProxyGood(result) {
+		t.Error("excluded asn usable")
+	}
+	result.ExitASN = 13335
+	if !isProxyUsable(result) {
+		t.Error("other asn not usable")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestPickCCWinners(t *testing.T) {
+	variant := func(name, source, cc string, speed float64, latency time.Duration) *speedtester.Result {
+		return &speedtester.Result{
+			ProxyName:         name + " [" + cc + "]",
+			ProxyConfig:       map[string]any{"name": name, "congestion-controller": cc},
+			Source:            source,
+			CongestionControl: cc,
+			DownloadSpeed:     speed,
+			Latency:           latency,
+		}
+	}
+	plain := &speedtester.Result{ProxyName: "S", ProxyConfig: map[string]any{"name": "S"}, DownloadSpeed: 1}
+	tBBR := variant("T", "a.yaml", "bbr", 10, 50*time.Millisecond)
+	tCubic := variant("T", "a.yaml", "cubic", 20, 80*time.Millisecond)
+	// 速度相同时延迟低的胜出
+	hBBR := variant("H", "a.yaml", "bbr", 5, 90*time.Millisecond)
+	hBrutal := variant("H", "a.yaml", "brutal", 5, 40*time.Millisecond)
+	// 另一个来源里的同名节点单独分组
+	otherT := variant("T", "b.yaml", "bbr", 1, 50*time.Millisecond)
+
+	got := pickCCWinners([]*speedtester.Result{tBBR, plain, tCubic, hBBR, hBrutal, otherT})
+	want := []*speedtester.Result{plain, tCubic, hBrutal, otherT}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %s (%s), want %s (%s)", i, got[i].ProxyName, got[i].Source, want[i].ProxyName, want[i].Source)
+		}
+	}
+	if cc := got[1].ProxyConfig["congestion-controller"]; cc != "cubic" {
+		t.Errorf("winner saved with congestion-controller %v, want cubic", cc)
+	}
+}
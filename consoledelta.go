@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// -console 的取值
+const (
+	consoleFull  = "full"
+	consoleDelta = "delta"
+)
+
+// speedChange 是一个两次运行都可用、速度（-fast 时为延迟）变化超过 -delta-threshold 的节点
+type speedChange struct {
+	name     string
+	previous float64
+	current  float64
+}
+
+// consoleDiff 是本次结果相对上一次运行的变化，节点按 NodeKey 对应
+type consoleDiff struct {
+	// becameUsable 是上次不可用或没有测到、这次可用的节点
+	becameUsable []*speedtester.Result
+	// droppedOut 是上次可用、这次不可用或没有测到的节点名称和原因
+	droppedOut []string
+	changed    []speedChange
+	unchanged  int
+	usable     int
+	previous   int
+}
+
+// diffConsole 比较上一次运行的记录和本次全部结果。threshold 是百分比，
+// -fast 时比较延迟，否则比较下载速度，任意一次为 0 时不算变化
+func diffConsole(previous map[string]historyRecord, allResults []*speedtester.Result, threshold float64) *consoleDiff {
+	diff := &consoleDiff{}
+	for _, record := range previous {
+		if record.Usable {
+			diff.previous++
+		}
+	}
+	missing := matchByNodeKey(previous, allResults, func(result *speedtester.Result, prev historyRecord, ok bool) {
+		if !isProxyUsable(result) {
+			if ok && prev.Usable {
+				diff.droppedOut = append(diff.droppedOut, result.ProxyName+": "+unusableReason(result))
+			}
+			return
+		}
+		diff.usable++
+		if !ok || !prev.Usable {
+			diff.becameUsable = append(diff.becameUsable, result)
+			return
+		}
+		before, now := prev.DownloadSpeed, result.DownloadSpeed
+		if *fastMode {
+			before, now = float64(prev.LatencyMs), float64(result.Latency.Milliseconds())
+		}
+		if before > 0 && now > 0 && math.Abs(now-before)/before*100 > threshold {
+			diff.changed = append(diff.changed, speedChange{name: result.ProxyName, previous: before, current: now})
+		} else {
+			diff.unchanged++
+		}
+	})
+	for _, key := range missing {
+		if record := previous[key]; record.Usable {
+			diff.droppedOut = append(diff.droppedOut, record.Name+": not tested this run")
+		}
+	}
+	sort.Strings(diff.droppedOut)
+	sort.SliceStable(diff.changed, func(i, j int) bool {
+		return math.Abs(diff.changed[i].current/diff.changed[i].previous-1) > math.Abs(diff.changed[j].current/diff.changed[j].previous-1)
+	})
+	return diff
+}
+
+// printConsoleDiff 只输出变化的节点和一行汇总，没有变化时只有汇总
+func printConsoleDiff(diff *consoleDiff) {
+	fmt.Fprintln(console)
+	for _, result := range diff.becameUsable {
+		fmt.Fprintf(console, "%s+ %s%s\t%s\t%s\n", colorGreen, result.ProxyName, colorReset, result.FormatLatency(), result.FormatDownloadSpeed())
+	}
+	for _, dropped := range diff.droppedOut {
+		fmt.Fprintf(console, "%s- %s%s\n", colorRed, dropped, colorReset)
+	}
+	for _, change := range diff.changed {
+		// 速度变小、延迟变大是变差
+		worse := change.current < change.previous
+		if *fastMode {
+			worse = !worse
+		}
+		color := colorGreen
+		if worse {
+			color = colorRed
+		}
+		fmt.Fprintf(console, "%s~ %s%s\t%s -> %s (%+.0f%%)\n", color, change.name, colorReset,
+			formatDeltaValue(change.previous), formatDeltaValue(change.current), (change.current/change.previous-1)*100)
+	}
+	fmt.Fprintf(console, "%s\n\n", diff)
+}
+
+func formatDeltaValue(v float64) string {
+	if *fastMode {
+		return fmt.Sprintf("%.0fms", v)
+	}
+	return speedtester.FormatSpeed(v)
+}
+
+// String 输出形如 "usable 42 (was 40): +3 usable, -1 dropped, ~2 changed, 37 unchanged" 的汇总
+func (d *consoleDiff) String() string {
+	parts := []string{
+		fmt.Sprintf("+%d usable", len(d.becameUsable)),
+		fmt.Sprintf("-%d dropped", len(d.droppedOut)),
+		fmt.Sprintf("~%d changed", len(d.changed)),
+		fmt.Sprintf("%d unchanged", d.unchanged),
+	}
+	return fmt.Sprintf("usable %d (was %d): %s", d.usable, d.previous, strings.Join(parts, ", "))
+}
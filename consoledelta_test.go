@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// deltaRuns 把 runs 依次记进历史，返回最后一次和上一次比较的结果。
+// 每次运行是节点名到下载速度（MB/s）的映射，负数表示连不上
+func deltaRuns(t *testing.T, threshold float64, runs ...map[string]float64) *consoleDiff {
+	t.Helper()
+	history := &historyFile{Version: historyVersion}
+	var diff *consoleDiff
+	for i, run := range runs {
+		var results []*speedtester.Result
+		for _, name := range slices.Sorted(func(yield func(string) bool) {
+			for name := range run {
+				if !yield(name) {
+					return
+				}
+			}
+		}) {
+			result := capResult(name, max(run[name], 0))
+			if run[name] < 0 {
+				result.Latency = 0
+				result.Error = "timeout"
+			}
+			results = append(results, result)
+		}
+		diff = diffConsole(history.lastRunRecords(), results, threshold)
+		history.appendRun(time.Now().Add(time.Duration(i)*time.Hour), results, 0)
+	}
+	return diff
+}
+
+func TestDiffConsole(t *testing.T) {
+	setFlags(t, "min-speed", "1")
+	diff := deltaRuns(t, 20,
+		map[string]float64{"A": 10, "B": 10, "C": 0.5, "D": 10, "F": 10, "G": 10},
+		map[string]float64{"A": 11.5, "B": 5, "C": 8, "E": 8, "F": -1, "G": 18},
+	)
+	var became []string
+	for _, result := range diff.becameUsable {
+		became = append(became, result.ProxyName)
+	}
+	if !slices.Equal(became, []string{"C", "E"}) {
+		t.Errorf("became usable %v", became)
+	}
+	if !slices.Equal(diff.droppedOut, []string{"D: not tested this run", "F: unreachable: timeout"}) {
+		t.Errorf("dropped out %q", diff.droppedOut)
+	}
+	// 变化最大的排在前面
+	var changed []string
+	for _, change := range diff.changed {
+		changed = append(changed, change.name)
+	}
+	if !slices.Equal(changed, []string{"G", "B"}) || diff.changed[1].previous != 10*1024*1024 || diff.changed[1].current != 5*1024*1024 {
+		t.Errorf("changed %+v", diff.changed)
+	}
+	if diff.unchanged != 1 || diff.usable != 5 || diff.previous != 5 {
+		t.Errorf("unchanged %d, usable %d, previous %d", diff.unchanged, diff.usable, diff.previous)
+	}
+	if want := "usable 5 (was 5): +2 usable, -2 dropped, ~2 changed, 1 unchanged"; diff.String() != want {
+		t.Errorf("summary %q, want %q", diff.String(), want)
+	}
+
+	out := captureConsole(t, func() { printConsoleDiff(diff) })
+	for _, want := range []string{"+ C", "+ E", "- D: not tested this run", "- F: unreachable: timeout", "~ G", "10.00MB/s -> 18.00MB/s (+80%)", "~ B", "(-50%)", diff.String()} {
+		if !strings.Contains(out, want) {
+			t.Errorf("delta output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "A") {
+		t.Errorf("unchanged node printed:\n%s", out)
+	}
+}
+
+// 没有变化时只有汇总行，阈值按百分比比较
+func TestDiffConsoleThreshold(t *testing.T) {
+	setFlags(t, "min-speed", "1")
+	runs := []map[string]float64{{"A": 10, "B": 10}, {"A": 10.4, "B": 9.6}}
+	if diff := deltaRuns(t, 5, runs...); len(diff.changed) != 0 || diff.unchanged != 2 {
+		t.Errorf("threshold 5%%: %+v", diff)
+	}
+	if diff := deltaRuns(t, 3, runs...); len(diff.changed) != 2 {
+		t.Errorf("threshold 3%%: %+v", diff)
+	}
+	out := captureConsole(t, func() { printConsoleDiff(deltaRuns(t, 5, runs...)) })
+	if got := strings.TrimSpace(out); got != "usable 2 (was 2): +0 usable, -0 dropped, ~0 changed, 2 unchanged" {
+		t.Errorf("output %q", got)
+	}
+}
+
+// -fast 时比较延迟，延迟变大是变差
+func TestDiffConsoleFast(t *testing.T) {
+	setFlags(t, "fast", "true")
+	history := &historyFile{Version: historyVersion}
+	result := capResult("A", 0)
+	history.appendRun(time.Now(), []*speedtester.Result{result}, 0)
+
+	result = capResult("A", 0)
+	result.Latency = 300 * time.Millisecond
+	diff := diffConsole(history.lastRunRecords(), []*speedtester.Result{result}, 20)
+	if len(diff.changed) != 1 || diff.changed[0].previous != 100 || diff.changed[0].current != 300 {
+		t.Fatalf("changed %+v", diff.changed)
+	}
+	out := captureConsole(t, func() { printConsoleDiff(diff) })
+	if !strings.Contains(out, colorRed+"~ A"+colorReset+"\t100ms -> 300ms (+200%)") {
+		t.Errorf("latency increase not shown as worse:\n%q", out)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unknownFlag 是国家代码缺失或无法识别时使用的旗帜
+const unknownFlag = "🏳️"
+
+// countryFlagOverrides 是不能直接由代码推导出旗帜的特例，例如 UK 不是 ISO 3166 代码
+var countryFlagOverrides = map[string]string{
+	"UK": "🇬🇧",
+}
+
+// countryFlag 把两位字母的国家代码转换成对应的区域指示符号旗帜，
+// 大小写不敏感，空代码、"??" 等无法识别的代码返回白旗
+func countryFlag(countryCode string) string {
+	code := strings.ToUpper(strings.TrimSpace(countryCode))
+	if flag, ok := countryFlagOverrides[code]; ok {
+		return flag
+	}
+	if len(code) != 2 {
+		return unknownFlag
+	}
+	runes := make([]rune, 0, 2)
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return unknownFlag
+		}
+		// 区域指示符号 🇦 到 🇿 与 A 到 Z 一一对应
+		runes = append(runes, '🇦'+(c-'A'))
+	}
+	return string(runes)
+}
+
+// generateNodeName 生成形如 "🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s" 的节点名称，城市未知时省略
+func generateNodeName(countryCode, city string, downloadSpeed float64) string {
+	location := strings.ToUpper(countryCode)
+	if location == "" {
+		location = "??"
+	}
+	if city != "" {
+		location += " " + city
+	}
+	speedMBps := downloadSpeed / (1024 * 1024)
+	return fmt.Sprintf("%s %s | ⬇️ %.2f MB/s", countryFlag(countryCode), location, speedMBps)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+// TestCountryFlagAllCodes 检查 AA 到 ZZ 的每个代码都得到两个区域指示符号，且不同代码的旗帜不同
+func TestCountryFlagAllCodes(t *testing.T) {
+	seen := make(map[string]string)
+	for a := 'A'; a <= 'Z'; a++ {
+		for b := 'A'; b <= 'Z'; b++ {
+			code := string([]rune{a, b})
+			flag := countryFlag(code)
+			if code == "UK" {
+				continue
+			}
+			runes := []rune(flag)
+			if !utf8.ValidString(flag) || len(runes) != 2 || runes[0] != '🇦'+(a-'A') || runes[1] != '🇦'+(b-'A') {
+				t.Errorf("countryFlag(%q) = %q", code, flag)
+			}
+			if other, ok := seen[flag]; ok {
+				t.Errorf("%s and %s share the flag %s", code, other, flag)
+			}
+			seen[flag] = code
+		}
+	}
+}
+
+func TestCountryFlagSpecialCodes(t *testing.T) {
+	tests := map[string]string{
+		"JP":   "🇯🇵",
+		"jp":   "🇯🇵",
+		" tw ": "🇹🇼",
+		"UK":   "🇬🇧",
+		"GB":   "🇬🇧",
+		"":     unknownFlag,
+		"??":   unknownFlag,
+		"J":    unknownFlag,
+		"JPN":  unknownFlag,
+		"J1":   unknownFlag,
+		"ÄB":   unknownFlag,
+	}
+	for code, want := range tests {
+		if got := countryFlag(code); got != want {
+			t.Errorf("countryFlag(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestGenerateNodeName(t *testing.T) {
+	tests := []struct {
+		country, city string
+		speed         float64
+		want          string
+	}{
+		{"jp", "Tokyo", 12.4 * 1024 * 1024, "🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s"},
+		{"US", "", 1024 * 1024, "🇺🇸 US | ⬇️ 1.00 MB/s"},
+		{"", "", 0, "🏳️ ?? | ⬇️ 0.00 MB/s"},
+	}
+	for _, tt := range tests {
+		if got := generateNodeName(tt.country, tt.city, tt.speed); got != tt.want {
+			t.Errorf("generateNodeName(%q, %q) = %q, want %q", tt.country, tt.city, got, tt.want)
+		}
+	}
+}
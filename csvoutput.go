@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// csvColumn 是 -csv 的一列。前面的列和表格一一对应，表头也和表格相同；
+// 后面以 _ms、_pct、_bytes_per_sec 结尾的列是不带单位的原始数值，方便表格软件直接画图；
+// 最后的 exit_asn、exit_as_org 是出口 IP 所属的 AS，未知时为空
+type csvColumn struct {
+	key    string
+	header string
+	value  func(i int, result *speedtester.Result) string
+}
+
+func csvFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var csvColumns = []csvColumn{
+	{"id", "序号", func(i int, _ *speedtester.Result) string { return strconv.Itoa(i + 1) }},
+	{"name", "节点名称", func(_ int, r *speedtester.Result) string { return r.ProxyName }},
+	{"type", "类型", func(_ int, r *speedtester.Result) string { return r.ProxyType }},
+	{"latency", "延迟", func(_ int, r *speedtester.Result) string { return r.FormatLatency() }},
+	{"jitter", "抖动", func(_ int, r *speedtester.Result) string { return r.FormatJitter() }},
+	{"packet_loss", "丢包率", func(_ int, r *speedtester.Result) string { return r.FormatPacketLoss() }},
+	{"download_speed", "下载速度", func(_ int, r *speedtester.Result) string { return r.FormatDownloadSpeed() }},
+	{"upload_speed", "上传速度", func(_ int, r *speedtester.Result) string { return r.FormatUploadSpeed() }},
+	{"extra_url_connectivity", "自定义网站连通性", func(_ int, r *speedtester.Result) string { return r.FormatExtraURLConnectivity() }},
+	{"extra_url_open_speed", "自定义网站打开速度", func(_ int, r *speedtester.Result) string { return r.FormatExtraURLOpenSpeed() }},
+	{"extra_download_speed", "自定义资源下载速度", func(_ int, r *speedtester.Result) string { return r.FormatExtraDownloadSpeed() }},
+	{"latency_ms", "latency_ms", func(_ int, r *speedtester.Result) string {
+		return csvFloat(float64(r.Latency.Microseconds()) / 1000)
+	}},
+	{"jitter_ms", "jitter_ms", func(_ int, r *speedtester.Result) string {
+		return csvFloat(float64(r.Jitter.Microseconds()) / 1000)
+	}},
+	{"packet_loss_pct", "packet_loss_pct", func(_ int, r *speedtester.Result) string { return csvFloat(r.PacketLoss) }},
+	{"download_bytes_per_sec", "download_bytes_per_sec", func(_ int, r *speedtester.Result) string { return csvFloat(r.DownloadSpeed) }},
+	{"upload_bytes_per_sec", "upload_bytes_per_sec", func(_ int, r *speedtester.Result) string { return csvFloat(r.UploadSpeed) }},
+	{"extra_download_bytes_per_sec", "extra_download_bytes_per_sec", func(_ int, r *speedtester.Result) string {
+		return csvFloat(r.ExtraDownloadSpeed)
+	}},
+	{"exit_asn", "exit_asn", func(_ int, r *speedtester.Result) string {
+		if r.ExitASN == 0 {
+			return ""
+		}
+		return strconv.Itoa(r.ExitASN)
+	}},
+	{"exit_as_org", "exit_as_org", func(_ int, r *speedtester.Result) string { return r.ExitASOrg }},
+}
+
+// csvColumnsNeedExitIP 只有 -csv-columns 明确选了 exit_ 开头的列时才额外查询出口 IP，默认的全部列不会触发，
+// 这时出口 ASN 只在其他选项已经查询过时才有值
+func csvColumnsNeedExitIP(spec string) bool {
+	if strings.TrimSpace(spec) == "" {
+		return false
+	}
+	columns, _ := parseCSVColumns(spec)
+	for _, column := range columns {
+		if strings.HasPrefix(column.key, "exit_") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCSVColumns 解析 -csv-columns，列可以用英文名或表格里的表头指定，为空时输出全部列
+func parseCSVColumns(spec string) ([]csvColumn, error) {
+	if strings.TrimSpace(spec) == "" {
+		return csvColumns, nil
+	}
+	var columns []csvColumn
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range csvColumns {
+			if column.key == name || column.header == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			keys := make([]string, 0, len(csvColumns))
+			for _, column := range csvColumns {
+				keys = append(keys, column.key)
+			}
+			return nil, fmt.Errorf("unknown column %q, supported: %s", name, strings.Join(keys, ", "))
+		}
+	}
+	return columns, nil
+}
+
+// formatCSVOutput 按表格的顺序输出结果，没有可用节点时只有表头
+func formatCSVOutput(results []*speedtester.Result, columns []csvColumn) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		row[i] = column.header
+	}
+	w.Write(row)
+	for i, result := range results {
+		for j, column := range columns {
+			row[j] = column.value(i, result)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestParseCSVColumns(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+		err  string
+	}{
+		{"", nil, ""},
+		{"name, latency_ms,download_bytes_per_sec", []string{"name", "latency_ms", "download_bytes_per_sec"}, ""},
+		// 表格里的表头也可以用
+		{"节点名称,下载速度", []string{"name", "download_speed"}, ""},
+		{"name,exit_asn,exit_as_org", []string{"name", "exit_asn", "exit_as_org"}, ""},
+		{"name,speed", nil, `unknown column "speed", supported: id, name, type`},
+	}
+	for _, tt := range tests {
+		columns, err := parseCSVColumns(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseCSVColumns(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCSVColumns(%q): %v", tt.spec, err)
+			continue
+		}
+		var keys []string
+		for _, column := range columns {
+			keys = append(keys, column.key)
+		}
+		if tt.want == nil {
+			if len(columns) != len(csvColumns) {
+				t.Errorf("parseCSVColumns(%q) = %v, want all columns", tt.spec, keys)
+			}
+		} else if !slices.Equal(keys, tt.want) {
+			t.Errorf("parseCSVColumns(%q) = %v, want %v", tt.spec, keys, tt.want)
+		}
+	}
+}
+
+// -csv-columns 的帮助里列出了全部列名，新增列时不能忘记改
+func TestCSVColumnsHelp(t *testing.T) {
+	var keys []string
+	for _, column := range csvColumns {
+		keys = append(keys, column.key)
+	}
+	if usage := flag.Lookup("csv-columns").Usage; !strings.Contains(usage, "(default all: "+strings.Join(keys, ", ")+")") {
+		t.Errorf("-csv-columns help does not list %v:\n%s", keys, usage)
+	}
+}
+
+func TestFormatCSVOutput(t *testing.T) {
+	columns, err := parseCSVColumns("id,name,latency,download_speed,latency_ms,packet_loss_pct,download_bytes_per_sec,exit_asn,exit_as_org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := capResult(`HK "01", BGP`, 12.5)
+	a.Latency = 123456 * time.Microsecond
+	b := capResult("JP 01", 0)
+	b.Latency = 0
+	b.PacketLoss = 100
+	a.ExitASN, a.ExitASOrg = 9009, "M247 Europe SRL"
+
+	data, err := formatCSVOutput([]*speedtester.Result{a, b}, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 名称里的逗号和引号按 csv 规则转义
+	if !strings.Contains(string(data), `"HK ""01"", BGP"`) {
+		t.Errorf("name not quoted:\n%s", data)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"序号", "节点名称", "延迟", "下载速度", "latency_ms", "packet_loss_pct", "download_bytes_per_sec", "exit_asn", "exit_as_org"},
+		{"1", `HK "01", BGP`, "123ms", "12.50MB/s", "123.456", "0", "13107200", "9009", "M247 Europe SRL"},
+		// 未知的 ASN 留空，不写 0
+		{"2", "JP 01", "N/A", "0.00B/s", "0", "100", "0", "", ""},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("rows\n%q\nwant\n%q", rows, want)
+	}
+
+	// 没有可用节点时只有表头
+	data, err = formatCSVOutput(nil, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "\n"); got != 1 || !strings.HasPrefix(string(data), "序号,节点名称") {
+		t.Errorf("empty csv %q", data)
+	}
+}
+
+func TestCSVColumnsNeedExitIP(t *testing.T) {
+	for spec, want := range map[string]bool{"": false, "name,latency_ms": false, "name,exit_asn": true, "exit_as_org": true} {
+		if got := csvColumnsNeedExitIP(spec); got != want {
+			t.Errorf("csvColumnsNeedExitIP(%q) = %v, want %v", spec, got, want)
+		}
+	}
+}
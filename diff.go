@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// outputDiff 描述本次输出文件相对上一次的变化，节点按 NodeKey 对应
+type outputDiff struct {
+	Added          []string       `json:"added"`
+	Removed        []string       `json:"removed"`
+	Changed        []string       `json:"changed"`
+	Unchanged      int            `json:"unchanged"`
+	AddedCountries map[string]int `json:"added_countries,omitempty"`
+}
+
+// loadPreviousProxies 读取上一次写出的配置，文件不存在时返回 nil。
+// 旧版本写出的文件同样只有 proxies 列表，可以直接兼容
+func loadPreviousProxies(path string) ([]map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rawCfg := &speedtester.RawConfig{}
+	if err := yaml.Unmarshal(data, rawCfg); err != nil {
+		return nil, err
+	}
+	return rawCfg.Proxies, nil
+}
+
+// matchByNodeKey 按 NodeKey 把本次的每个结果和上一次的节点对应起来，上一次没有这个节点时 ok 为 false，
+// 返回上一次有、本次没有的节点的 key。-output 的变化摘要和 -console delta 都用它对比两次运行
+func matchByNodeKey[T any](previous map[string]T, results []*speedtester.Result, match func(result *speedtester.Result, prev T, ok bool)) []string {
+	seen := make(map[string]bool, len(results))
+	for _, result := range results {
+		key := speedtester.NodeKey(result.ProxyConfig)
+		seen[key] = true
+		prev, ok := previous[key]
+		match(result, prev, ok)
+	}
+	var missing []string
+	for key := range previous {
+		if !seen[key] {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+func diffOutput(previous []map[string]any, results []*speedtester.Result) *outputDiff {
+	diff := &outputDiff{AddedCountries: make(map[string]int)}
+	previousByKey := make(map[string]map[string]any, len(previous))
+	for _, proxy := range previous {
+		previousByKey[speedtester.NodeKey(proxy)] = proxy
+	}
+
+	removed := matchByNodeKey(previousByKey, results, func(result *speedtester.Result, old map[string]any, ok bool) {
+		name, _ := result.ProxyConfig["name"].(string)
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+			country := result.CountryCode
+			if country == "" {
+				country = "??"
+			}
+			diff.AddedCountries[country]++
+		case !sameOutputProxy(old, result):
+			diff.Changed = append(diff.Changed, name)
+		default:
+			diff.Unchanged++
+		}
+	})
+	for _, key := range removed {
+		name, _ := previousByKey[key]["name"].(string)
+		diff.Removed = append(diff.Removed, name)
+	}
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// sameOutputProxy 判断上一次输出里的节点和本次结果是否相同。上一次的输出可能是没有 -name-prefix 的旧版本写的，
+// 名称只差前缀时不算修改；-rename 写出的名称带着每次都会变的速度，只比较名称以外的字段
+func sameOutputProxy(old map[string]any, result *speedtester.Result) bool {
+	if *renameNodes {
+		return sameProxyConfig(withoutName(old), withoutName(result.ProxyConfig))
+	}
+	return sameProxyConfig(old, result.ProxyConfig) || sameProxyConfig(old, result.SourceConfig())
+}
+
+func withoutName(proxy map[string]any) map[string]any {
+	proxy = maps.Clone(proxy)
+	delete(proxy, "name")
+	return proxy
+}
+
+// sameProxyConfig 比较两个节点配置，忽略 -volatile-fields
+func sameProxyConfig(a, b map[string]any) bool {
+	return reflect.DeepEqual(normalizeYAMLValue(volatileFields.Strip(a)), normalizeYAMLValue(volatileFields.Strip(b)))
+}
+
+// normalizeYAMLValue 通过一次 yaml 编解码抹平 int/uint64、[]string/[]any 之类的类型差异
+func normalizeYAMLValue(v any) any {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// String 输出形如 "+ 5 nodes (JP x3, US x2), - 2 nodes, ~ 1 changed, 12 unchanged" 的摘要
+func (d *outputDiff) String() string {
+	parts := make([]string, 0, 4)
+	added := fmt.Sprintf("+ %d nodes", len(d.Added))
+	if len(d.AddedCountries) > 0 {
+		countries := make([]string, 0, len(d.AddedCountries))
+		for country := range d.AddedCountries {
+			countries = append(countries, country)
+		}
+		sort.Slice(countries, func(i, j int) bool {
+			if d.AddedCountries[countries[i]] == d.AddedCountries[countries[j]] {
+				return countries[i] < countries[j]
+			}
+			return d.AddedCountries[countries[i]] > d.AddedCountries[countries[j]]
+		})
+		for i, country := range countries {
+			countries[i] = fmt.Sprintf("%s x%d", country, d.AddedCountries[country])
+		}
+		added += " (" + strings.Join(countries, ", ") + ")"
+	}
+	parts = append(parts, added, fmt.Sprintf("- %d nodes", len(d.Removed)))
+	if len(d.Changed) > 0 {
+		parts = append(parts, fmt.Sprintf("~ %d changed", len(d.Changed)))
+	}
+	parts = append(parts, fmt.Sprintf("%d unchanged", d.Unchanged))
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadPreviousProxies(t *testing.T) {
+	dir := t.TempDir()
+	if proxies, err := loadPreviousProxies(filepath.Join(dir, "missing.yaml")); err != nil || proxies != nil {
+		t.Fatalf("missing file: %v %v", proxies, err)
+	}
+
+	// 旧版本写出的文件键的顺序不固定
+	legacy := filepath.Join(dir, "legacy.yaml")
+	os.WriteFile(legacy, []byte("proxies:\n  - {port: 443, name: a, server: 1.1.1.1, type: ss, password: p, cipher: aes-128-gcm}\n"), 0o644)
+	proxy := map[string]any{"name": "a", "type": "ss", "server": "1.1.1.1", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+	data, err := yaml.Marshal(&speedtester.RawConfig{Proxies: []map[string]any{proxy}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "current.yaml")
+	os.WriteFile(current, data, 0o644)
+
+	// 带 -min-countries 注释的文件
+	data, err = marshalAnnotatedProxies([]map[string]any{proxy}, []string{"diversity pick: JP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotated := filepath.Join(dir, "annotated.yaml")
+	os.WriteFile(annotated, data, 0o644)
+
+	for _, path := range []string{legacy, current, annotated} {
+		previous, err := loadPreviousProxies(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		result := &speedtester.Result{ProxyConfig: proxy}
+		if diff := diffOutput(previous, []*speedtester.Result{result}); diff.Unchanged != 1 {
+			t.Errorf("%s: same node reported as %+v", filepath.Base(path), diff)
+		}
+	}
+
+	broken := filepath.Join(dir, "broken.yaml")
+	os.WriteFile(broken, []byte("proxies: [\n"), 0o644)
+	if _, err := loadPreviousProxies(broken); err == nil {
+		t.Error("broken yaml loaded without error")
+	}
+}
+
+func TestDiffOutput(t *testing.T) {
+	node := func(name, server string, extra ...any) map[string]any {
+		m := map[string]any{"name": name, "type": "ss", "server": server, "port": 443, "password": "p"}
+		for i := 0; i+1 < len(extra); i += 2 {
+			m[extra[i].(string)] = extra[i+1]
+		}
+		return m
+	}
+	previous := []map[string]any{
+		node("kept", "1.1.1.1"),
+		node("changed", "2.2.2.2"),
+		node("removed", "3.3.3.3"),
+	}
+	results := []*speedtester.Result{
+		{ProxyConfig: node("kept", "1.1.1.1")},
+		{ProxyConfig: node("changed", "2.2.2.2", "udp", true)},
+		{ProxyConfig: node("new jp 1", "4.4.4.4"), CountryCode: "JP"},
+		{ProxyConfig: node("new jp 2", "5.5.5.5"), CountryCode: "JP"},
+		{ProxyConfig: node("new unknown", "6.6.6.6")},
+	}
+	diff := diffOutput(previous, results)
+	if diff.Unchanged != 1 || !slices.Equal(diff.Changed, []string{"changed"}) || !slices.Equal(diff.Removed, []string{"removed"}) {
+		t.Errorf("unexpected diff %+v", diff)
+	}
+	if !slices.Equal(diff.Added, []string{"new jp 1", "new jp 2", "new unknown"}) {
+		t.Errorf("added %q", diff.Added)
+	}
+	if got, want := diff.String(), "+ 3 nodes (JP x2, ?? x1), - 1 nodes, ~ 1 changed, 1 unchanged"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	// 第一次运行没有上一次的输出，全部是新增
+	if diff := diffOutput(nil, results[:1]); len(diff.Added) != 1 || diff.String() != "+ 1 nodes (?? x1), - 0 nodes, 0 unchanged" {
+		t.Errorf("first run diff %q", diff)
+	}
+}
+
+// -rename 写出的名称每次都不同，只有名称以外的字段变化才算修改
+func TestDiffOutputRename(t *testing.T) {
+	setFlags(t, "rename", "true")
+	previous := []map[string]any{
+		{"name": "🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s", "type": "ss", "server": "1.1.1.1", "port": 443, "password": "p"},
+		{"name": "🇺🇸 US | ⬇️ 3.10 MB/s", "type": "ss", "server": "2.2.2.2", "port": 443, "password": "p", "udp": true},
+	}
+	results := []*speedtester.Result{
+		{ProxyConfig: map[string]any{"name": "tokyo", "type": "ss", "server": "1.1.1.1", "port": 443, "password": "p"}},
+		{ProxyConfig: map[string]any{"name": "us", "type": "ss", "server": "2.2.2.2", "port": 443, "password": "p"}},
+	}
+	diff := diffOutput(previous, results)
+	if diff.Unchanged != 1 || !slices.Equal(diff.Changed, []string{"us"}) {
+		t.Errorf("diff %+v, want tokyo unchanged and us changed", diff)
+	}
+}
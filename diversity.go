@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// pickDiversity 在优质节点覆盖的出口国家少于 minCountries 时，逐步放宽优质阈值（最低到可用阈值），
+// 从其余可用节点中为每个新国家挑出最快的一个，直到国家数达标或没有候选节点。
+// 候选节点按下载速度从高到低、速度相同按 NodeKey 排序，结果是确定的
+func pickDiversity(results []*speedtester.Result, minCountries int) []*speedtester.Result {
+	countries := make(map[string]bool)
+	var candidates []*speedtester.Result
+	for _, result := range results {
+		if isProxyGood(result) {
+			if result.CountryCode != "" {
+				countries[result.CountryCode] = true
+			}
+		} else if isProxyUsable(result) && result.CountryCode != "" {
+			candidates = append(candidates, result)
+		}
+	}
+	if len(countries) >= minCountries {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].DownloadSpeed != candidates[j].DownloadSpeed {
+			return candidates[i].DownloadSpeed > candidates[j].DownloadSpeed
+		}
+		return speedtester.NodeKey(candidates[i].ProxyConfig) < speedtester.NodeKey(candidates[j].ProxyConfig)
+	})
+	var picks []*speedtester.Result
+	for _, candidate := range candidates {
+		if len(countries) >= minCountries {
+			break
+		}
+		if countries[candidate.CountryCode] {
+			continue
+		}
+		countries[candidate.CountryCode] = true
+		picks = append(picks, candidate)
+	}
+	return picks
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestPickDiversity(t *testing.T) {
+	setFlags(t)
+	// 默认可用阈值 0.1MB/s，优质阈值 1MB/s
+	node := func(name, country string, speed float64) *speedtester.Result {
+		return &speedtester.Result{
+			ProxyName: name, CountryCode: country, DownloadSpeed: speed * 1024 * 1024,
+			Latency: 100 * time.Millisecond, ExtraURLConnectivity: true,
+			ProxyConfig: map[string]any{"name": name, "type": "ss", "server": name + ".example.com", "port": 443, "password": "p"},
+		}
+	}
+	results := []*speedtester.Result{
+		node("hk1", "HK", 5),
+		node("hk2", "HK", 3),
+		node("jp1", "JP", 0.5),
+		node("jp2", "JP", 0.8),
+		node("us1", "US", 0.5),
+		node("sg1", "SG", 0.5),
+		node("kr1", "KR", 0.05),
+		node("unknown", "", 0.9),
+	}
+	// us1 和 sg1 速度相同，按 NodeKey 决定先后
+	tied := []string{"us1", "sg1"}
+	if speedtester.NodeKey(results[5].ProxyConfig) < speedtester.NodeKey(results[4].ProxyConfig) {
+		tied = []string{"sg1", "us1"}
+	}
+
+	tests := []struct {
+		minCountries int
+		want         []string
+	}{
+		{1, nil},
+		{2, []string{"jp2"}},
+		{3, []string{"jp2", tied[0]}},
+		// 不可用的 KR 和没有国家的节点不会被选中
+		{10, []string{"jp2", tied[0], tied[1]}},
+	}
+	names := func(picks []*speedtester.Result) []string {
+		var names []string
+		for _, pick := range picks {
+			names = append(names, pick.ProxyName)
+		}
+		return names
+	}
+	for _, tt := range tests {
+		if got := names(pickDiversity(results, tt.minCountries)); !slices.Equal(got, tt.want) {
+			t.Errorf("minCountries %d: picks %v, want %v", tt.minCountries, got, tt.want)
+		}
+		// 输入顺序不影响结果
+		reversed := slices.Clone(results)
+		slices.Reverse(reversed)
+		if got := names(pickDiversity(reversed, tt.minCountries)); !slices.Equal(got, tt.want) {
+			t.Errorf("minCountries %d, reversed input: picks %v, want %v", tt.minCountries, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/faceair/clash-speedtest/speedtester"
 )
 
 func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	flag.Parse()
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusOK)
@@ -48,5 +56,49 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	http.ListenAndServe(":8080", nil)
+	// 返回收到的请求体的 sha256，供测速端校验节点是否损坏了上传的数据
+	http.HandleFunc("/__hash", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(hex.EncodeToString(hasher.Sum(nil))))
+	})
+
+	// 返回固定的 HTML 页面，供测速端的 -tamper-check 检测节点是否篡改明文 HTTP 响应
+	http.HandleFunc("/__known", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store, no-transform")
+		w.WriteHeader(http.StatusOK)
+		w.Write(speedtester.KnownContent)
+	})
+
+	// 与 Cloudflare 的 trace 接口格式一致，供测速端获取节点出口 IP。
+	// 额外回显代理会加上的请求头，测速端据此判断出口后面是否还串了别的代理
+	http.HandleFunc("/cdn-cgi/trace", func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "ip=%s\n", ip)
+		for _, header := range []string{"X-Forwarded-For", "X-Real-Ip", "Forwarded", "Via"} {
+			if values := r.Header.Values(header); len(values) > 0 {
+				fmt.Fprintf(w, "%s=%s\n", strings.ToLower(header), strings.Join(values, ", "))
+			}
+		}
+	})
+
+	http.ListenAndServe(*listen, nil)
 }
@@ -0,0 +1,394 @@
+//go:build e2e
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/adapter"
+	"gopkg.in/yaml.v3"
+)
+
+// e2eEnv 是一次端到端测试用到的二进制和本地服务
+type e2eEnv struct {
+	bin      string
+	speedURL string
+	subURL   string
+}
+
+// TestE2E 编译 clash-speedtest 和 download-server，用本地的测速服务器、订阅服务器和 socks5 节点跑完整的命令行。
+// 需要 go 工具链，运行方式：go test -tags e2e -run E2E .
+func TestE2E(t *testing.T) {
+	env := setupE2E(t)
+	healthy, broken := env.subURL+"/healthy.yaml", env.subURL+"/broken.yaml"
+
+	t.Run("table, output and results json", func(t *testing.T) {
+		dir := t.TempDir()
+		// 可用节点少于 -min-usable 时以失败退出，输出文件照常写出
+		stdout, code := env.run(t, dir, "-c", healthy+","+broken, "-min-usable", "3")
+		if code == 0 {
+			t.Fatalf("exit code 0 with 2 usable nodes and -min-usable 3\n%s", stdout)
+		}
+		for _, name := range []string{"healthy_HK 01", "healthy_JP 01"} {
+			if !strings.Contains(stdout, name) {
+				t.Errorf("console table does not list %s\n%s", name, stdout)
+			}
+		}
+
+		if got := parseOutput(t, filepath.Join(dir, "useable.yaml")); !slices.Equal(got, []string{"healthy_HK 01", "healthy_JP 01"}) {
+			t.Errorf("useable.yaml has %q, want exactly the healthy nodes", got)
+		}
+		checkGolden(t, filepath.Join(dir, "results.json"), filepath.Join("testdata", "e2e", "results.golden.json"))
+
+		if !strings.Contains(stdout, "::warning title=source failed::"+broken) {
+			t.Errorf("missing source failed annotation\n%s", stdout)
+		}
+		if !strings.Contains(stdout, "::error title=not enough usable nodes::2 usable nodes, fewer than -min-usable 3") {
+			t.Errorf("missing -min-usable annotation\n%s", stdout)
+		}
+	})
+
+	t.Run("min-usable satisfied", func(t *testing.T) {
+		stdout, code := env.run(t, t.TempDir(), "-c", healthy, "-min-usable", "2")
+		if code != 0 {
+			t.Fatalf("exit code %d\n%s", code, stdout)
+		}
+		if strings.Contains(stdout, "::error") {
+			t.Errorf("error annotation with enough usable nodes\n%s", stdout)
+		}
+	})
+
+	t.Run("min-usable ignores pinned nodes", func(t *testing.T) {
+		dir := t.TempDir()
+		pinFile := filepath.Join(dir, "pin.txt")
+		if err := os.WriteFile(pinFile, []byte("/US 01$/\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// 失败的 US 01 被固定保留在输出里，但可用的只有两个节点
+		stdout, code := env.run(t, dir, "-c", healthy, "-pin", pinFile, "-min-usable", "3")
+		if code == 0 {
+			t.Fatalf("exit code 0 with 2 usable nodes, a pinned failing node and -min-usable 3\n%s", stdout)
+		}
+		if got := parseOutput(t, filepath.Join(dir, "useable.yaml")); !slices.Equal(got, []string{"healthy_HK 01", "healthy_JP 01", "healthy_US 01"}) {
+			t.Errorf("useable.yaml has %q, want the healthy nodes and the pinned one", got)
+		}
+		if !strings.Contains(stdout, "::error title=not enough usable nodes::2 usable nodes, fewer than -min-usable 3") {
+			t.Errorf("missing -min-usable annotation\n%s", stdout)
+		}
+	})
+
+	t.Run("output to stdout", func(t *testing.T) {
+		dir := t.TempDir()
+		stdout, stderr, code := env.runStreams(t, dir, "-c", healthy, "-output", "-")
+		if code != 0 {
+			t.Fatalf("exit code %d\n%s", code, stderr)
+		}
+		// 标准输出里只有配置，表格和进度都在标准错误里
+		piped := filepath.Join(dir, "piped.yaml")
+		os.WriteFile(piped, []byte(stdout), 0o644)
+		if got := parseOutput(t, piped); !slices.Equal(got, []string{"healthy_HK 01", "healthy_JP 01"}) {
+			t.Errorf("stdout has %q, want exactly the healthy nodes\n%s", got, stdout)
+		}
+		if !strings.HasPrefix(stdout, "proxies:") && !strings.HasPrefix(stdout, "#") {
+			t.Errorf("stdout does not start with the config\n%s", stdout)
+		}
+		if !strings.Contains(stderr, "healthy_HK 01") {
+			t.Errorf("console table is not on stderr\n%s", stderr)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "useable.yaml")); !os.IsNotExist(err) {
+			t.Errorf("useable.yaml written with -output -: %v", err)
+		}
+	})
+
+	t.Run("only broken source", func(t *testing.T) {
+		stdout, code := env.run(t, t.TempDir(), "-c", broken, "-min-usable", "1")
+		if code == 0 {
+			t.Fatalf("exit code 0 without any usable source\n%s", stdout)
+		}
+	})
+}
+
+// run 在 dir 里运行 clash-speedtest，输出文件都写在 dir 里，返回合并的标准输出、标准错误和退出码
+func (env *e2eEnv) run(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	var out bytes.Buffer
+	code := env.exec(t, dir, &out, &out, args...)
+	return out.String(), code
+}
+
+// runStreams 和 run 相同，但分别返回标准输出和标准错误
+func (env *e2eEnv) runStreams(t *testing.T, dir string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := env.exec(t, dir, &stdout, &stderr, args...)
+	return stdout.String(), stderr.String(), code
+}
+
+func (env *e2eEnv) exec(t *testing.T, dir string, stdout, stderr io.Writer, args ...string) int {
+	t.Helper()
+	args = append([]string{
+		"-server-url", env.speedURL,
+		"-download-size", "1MB",
+		"-upload-size", "256KB",
+		"-timeout", "3s",
+		"-max-latency", "2s",
+		"-min-download-speed", "0.1",
+		"-min-upload-speed", "0",
+		// 本地下载几毫秒就结束，不当作测量错误
+		"-min-download-duration", "1ms",
+		"-concurrent", "1",
+		"-vantage-name", "e2e",
+		"-output", filepath.Join(dir, "useable.yaml"),
+		// 所有可用节点都写进 -output，不再分出优质节点
+		"-good-output", "",
+		"-results-json", filepath.Join(dir, "results.json"),
+		"-gh-summary=" + filepath.Join(dir, "summary.md"),
+	}, args...)
+	cmd := exec.Command(env.bin, args...)
+	// 不在仓库目录里运行，避免自动加载 ./clash-speedtest.yaml
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+func setupE2E(t *testing.T) *e2eEnv {
+	t.Helper()
+	binDir := t.TempDir()
+	env := &e2eEnv{bin: filepath.Join(binDir, "clash-speedtest")}
+	serverBin := filepath.Join(binDir, "download-server")
+	for _, build := range [][]string{{env.bin, "."}, {serverBin, "./download-server"}} {
+		cmd := exec.Command("go", "build", "-o", build[0], build[1])
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go build %s: %v\n%s", build[1], err, out)
+		}
+	}
+
+	addr := freeAddr(t)
+	server := exec.Command(serverBin, "-listen", addr)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Process.Kill()
+		server.Wait()
+	})
+	env.speedURL = "http://" + addr
+	waitHTTP(t, env.speedURL)
+
+	// 健康的订阅里有两个可用的 socks5 节点和一个端口上没有服务的节点
+	deadPort, _ := strconv.Atoi(strings.Split(freeAddr(t), ":")[1])
+	healthy := map[string][]map[string]any{"proxies": {
+		{"name": "HK 01", "type": "socks5", "server": "127.0.0.1", "port": serveSocks5(t)},
+		{"name": "JP 01", "type": "socks5", "server": "127.0.0.1", "port": serveSocks5(t)},
+		{"name": "US 01", "type": "socks5", "server": "127.0.0.1", "port": deadPort},
+	}}
+	healthyYAML, err := yaml.Marshal(healthy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthy.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(healthyYAML)
+	})
+	mux.HandleFunc("/broken.yaml", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "subscription expired", http.StatusInternalServerError)
+	})
+	sub := httptest.NewServer(mux)
+	t.Cleanup(sub.Close)
+	env.subURL = sub.URL
+	return env
+}
+
+// freeAddr 返回本机一个当前空闲的端口
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func waitHTTP(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not ready: %v", url, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// serveSocks5 启动一个只支持无认证 CONNECT 的 socks5 服务器，返回端口
+func serveSocks5(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleSocks5(conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func handleSocks5(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 256)
+	// 问候：版本、方法数和方法列表，回复不需要认证
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// 请求：版本、命令、保留字节、地址类型、地址和端口
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		ip := make(net.IP, map[byte]int{1: net.IPv4len, 4: net.IPv6len}[buf[3]])
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		name := buf[1 : 1+buf[0]]
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	port := int(buf[0])<<8 | int(buf[1])
+
+	target, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 5*time.Second)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// parseOutput 用 mihomo 解析输出文件里的每个节点，返回排好序的节点名称
+func parseOutput(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := &speedtester.RawConfig{}
+	if err := yaml.Unmarshal(data, raw); err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	var names []string
+	for _, config := range raw.Proxies {
+		proxy, err := adapter.ParseProxy(config)
+		if err != nil {
+			t.Errorf("mihomo rejects %v: %v", config, err)
+			continue
+		}
+		names = append(names, proxy.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkGolden 比较 -results-json 的结构：每个节点都有测试数据的字段，名称、类型和判定与 golden 文件一致。
+// 延迟、速度、时间和端口每次都不同，不参与比较
+func checkGolden(t *testing.T, path, goldenPath string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file map[string]any
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("parse %s: %v", path, err)
+	}
+	results, _ := file["results"].([]any)
+	type entry struct {
+		ProxyName string `json:"proxy_name"`
+		ProxyType string `json:"proxy_type"`
+		Usable    bool   `json:"usable"`
+	}
+	got := struct {
+		Version any     `json:"version"`
+		Vantage any     `json:"vantage"`
+		Results []entry `json:"results"`
+	}{Version: file["version"], Vantage: file["vantage"]}
+	for _, r := range results {
+		result, _ := r.(map[string]any)
+		for _, key := range []string{"proxy_config", "source", "latency", "download_speed", "upload_speed", "tested_at", "usable", "good"} {
+			if _, ok := result[key]; !ok {
+				t.Errorf("%s: result %v has no %q", path, result["proxy_name"], key)
+			}
+		}
+		name, _ := result["proxy_name"].(string)
+		proxyType, _ := result["proxy_type"].(string)
+		usable, _ := result["usable"].(bool)
+		got.Results = append(got.Results, entry{name, proxyType, usable})
+	}
+	sort.Slice(got.Results, func(i, j int) bool { return got.Results[i].ProxyName < got.Results[j].ProxyName })
+
+	gotJSON, _ := json.MarshalIndent(got, "", "  ")
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes.TrimSpace(want)) != string(gotJSON) {
+		t.Errorf("%s does not match %s\ngot:\n%s\nwant:\n%s", path, goldenPath, gotJSON, want)
+	}
+}
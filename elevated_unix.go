@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// isElevated 判断当前进程是否以 root 运行
+func isElevated() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// isElevated 判断当前进程是否以管理员权限（UAC 提升后的令牌）运行，Windows 上 Geteuid 总是返回 -1
+func isElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// 订阅导出支持的格式
+const (
+	formatClash   = "clash"
+	formatURI     = "uri"
+	formatSingBox = "singbox"
+)
+
+// exportProxies 把节点配置转换成指定格式的订阅内容，无法转换的节点会被跳过
+func exportProxies(proxies []map[string]any, format string) ([]byte, error) {
+	switch format {
+	case formatClash:
+		return marshalProxies(proxies)
+	case formatURI:
+		lines := make([]string, 0, len(proxies))
+		for _, proxy := range proxies {
+			if uri, ok := proxyURI(proxy); ok {
+				lines = append(lines, uri)
+			}
+		}
+		// 大多数客户端要求 uri 订阅整体做一次 base64
+		return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\n")))), nil
+	case formatSingBox:
+		outbounds := make([]map[string]any, 0, len(proxies))
+		for _, proxy := range proxies {
+			if outbound, ok := speedtester.SingBoxOutbound(proxy); ok {
+				outbounds = append(outbounds, outbound)
+			}
+		}
+		return json.MarshalIndent(map[string]any{"outbounds": outbounds}, "", "  ")
+	}
+	return nil, fmt.Errorf("unknown format %q, supported: clash, uri, singbox", format)
+}
+
+func str(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func subMap(config map[string]any, key string) map[string]any {
+	m, _ := config[key].(map[string]any)
+	return m
+}
+
+func boolOf(v any) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+// proxyURI 把节点转换成分享链接，目前支持 ss、vmess、vless、trojan 和 hysteria2
+func proxyURI(config map[string]any) (string, bool) {
+	name := str(config["name"])
+	host := net.JoinHostPort(str(config["server"]), str(config["port"]))
+	query := url.Values{}
+	switch str(config["type"]) {
+	case "ss":
+		// 带插件的节点各家客户端的写法不统一，不导出
+		if str(config["plugin"]) != "" {
+			return "", false
+		}
+		userinfo := base64.RawURLEncoding.EncodeToString([]byte(str(config["cipher"]) + ":" + str(config["password"])))
+		return "ss://" + userinfo + "@" + host + "#" + url.PathEscape(name), true
+	case "vmess":
+		network := str(config["network"])
+		if network == "" {
+			network = "tcp"
+		}
+		v := map[string]any{
+			"v":    "2",
+			"ps":   name,
+			"add":  str(config["server"]),
+			"port": str(config["port"]),
+			"id":   str(config["uuid"]),
+			"aid":  str(config["alterId"]),
+			"scy":  str(config["cipher"]),
+			"net":  network,
+			"type": "none",
+			"sni":  str(config["servername"]),
+		}
+		if boolOf(config["tls"]) {
+			v["tls"] = "tls"
+		}
+		path, hostHeader := transportPathHost(config)
+		v["path"], v["host"] = path, hostHeader
+		data, _ := json.Marshal(v)
+		return "vmess://" + base64.StdEncoding.EncodeToString(data), true
+	case "vless":
+		query.Set("encryption", "none")
+		if flow := str(config["flow"]); flow != "" {
+			query.Set("flow", flow)
+		}
+		if reality := subMap(config, "reality-opts"); reality != nil {
+			query.Set("security", "reality")
+			query.Set("pbk", str(reality["public-key"]))
+			query.Set("sid", str(reality["short-id"]))
+		} else if boolOf(config["tls"]) {
+			query.Set("security", "tls")
+		}
+		if sni := str(config["servername"]); sni != "" {
+			query.Set("sni", sni)
+		}
+		if fp := str(config["client-fingerprint"]); fp != "" {
+			query.Set("fp", fp)
+		}
+		addTransportQuery(config, query)
+		return "vless://" + url.PathEscape(str(config["uuid"])) + "@" + host + "?" + query.Encode() + "#" + url.PathEscape(name), true
+	case "trojan":
+		if sni := str(config["sni"]); sni != "" {
+			query.Set("sni", sni)
+		}
+		if boolOf(config["skip-cert-verify"]) {
+			query.Set("allowInsecure", "1")
+		}
+		addTransportQuery(config, query)
+		return "trojan://" + url.PathEscape(str(config["password"])) + "@" + host + "?" + query.Encode() + "#" + url.PathEscape(name), true
+	case "hysteria2":
+		if sni := str(config["sni"]); sni != "" {
+			query.Set("sni", sni)
+		}
+		if obfs := str(config["obfs"]); obfs != "" {
+			query.Set("obfs", obfs)
+			query.Set("obfs-password", str(config["obfs-password"]))
+		}
+		if boolOf(config["skip-cert-verify"]) {
+			query.Set("insecure", "1")
+		}
+		return "hysteria2://" + url.PathEscape(str(config["password"])) + "@" + host + "?" + query.Encode() + "#" + url.PathEscape(name), true
+	}
+	return "", false
+}
+
+// transportPathHost 返回 ws/h2/grpc 传输的 path（grpc 为 service name）和 Host
+func transportPathHost(config map[string]any) (string, string) {
+	switch str(config["network"]) {
+	case "ws":
+		opts := subMap(config, "ws-opts")
+		return str(opts["path"]), str(subMap(opts, "headers")["Host"])
+	case "grpc":
+		return str(subMap(config, "grpc-opts")["grpc-service-name"]), ""
+	}
+	return "", ""
+}
+
+func addTransportQuery(config map[string]any, query url.Values) {
+	network := str(config["network"])
+	if network == "" {
+		return
+	}
+	query.Set("type", network)
+	path, host := transportPathHost(config)
+	switch network {
+	case "ws":
+		query.Set("path", path)
+		if host != "" {
+			query.Set("host", host)
+		}
+	case "grpc":
+		query.Set("serviceName", path)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// stringList 是可以重复指定的字符串 flag，例如 -inject a -inject b
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *stringList) Get() any {
+	return []string(*l)
+}
+
+// byteSize 是接受 "50MB"、"1.5GiB" 这类写法的大小 flag，String 仍然输出字节数
+type byteSize int
+
+func (s *byteSize) String() string {
+	return strconv.Itoa(int(*s))
+}
+
+func (s *byteSize) Set(value string) error {
+	size, err := speedtester.ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*s = byteSize(size)
+	return nil
+}
+
+func (s *byteSize) Get() any {
+	return int(*s)
+}
+
+// saveEvery 是 -save-every 的取值，可以写成时间间隔（10m）或节点数（50）
+type saveEvery struct {
+	interval time.Duration
+	nodes    int
+}
+
+func (s *saveEvery) String() string {
+	switch {
+	case s.interval > 0:
+		return s.interval.String()
+	case s.nodes > 0:
+		return strconv.Itoa(s.nodes)
+	}
+	return ""
+}
+
+func (s *saveEvery) Set(value string) error {
+	*s = saveEvery{}
+	if value == "" || value == "0" {
+		return nil
+	}
+	if nodes, err := strconv.Atoi(value); err == nil {
+		if nodes < 0 {
+			return fmt.Errorf("node count must not be negative")
+		}
+		s.nodes = nodes
+		return nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("expected a duration like 10m or a node count like 50")
+	}
+	if interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	s.interval = interval
+	return nil
+}
+
+func (s *saveEvery) enabled() bool {
+	return s.interval > 0 || s.nodes > 0
+}
+
+// msDuration 是允许省略单位的时间 flag，不带单位的数字按毫秒处理，例如 -timeout 5000
+type msDuration time.Duration
+
+func (d *msDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *msDuration) Set(value string) error {
+	if ms, err := strconv.ParseFloat(value, 64); err == nil {
+		*d = msDuration(ms * float64(time.Millisecond))
+		return nil
+	}
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = msDuration(v)
+	return nil
+}
+
+func (d *msDuration) Get() any {
+	return time.Duration(*d)
+}
+
+// durationFlag 和 flag.Duration 一样，只是不带单位的数字按毫秒处理
+func durationFlag(name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+	flag.Var((*msDuration)(p), name, usage)
+	return p
+}
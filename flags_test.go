@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMsDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{"5000", 5 * time.Second, false},
+		{"800", 800 * time.Millisecond, false},
+		{"0.5", 500 * time.Microsecond, false},
+		{"0", 0, false},
+		{"1.5s", 1500 * time.Millisecond, false},
+		{"800ms", 800 * time.Millisecond, false},
+		{"5000ns", 5 * time.Microsecond, false},
+		{"5 seconds", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		var d msDuration
+		err := d.Set(tt.value)
+		if (err != nil) != tt.err || time.Duration(d) != tt.want {
+			t.Errorf("Set(%q) = %s, %v", tt.value, time.Duration(d), err)
+		}
+	}
+}
+
+func TestByteSizeFlag(t *testing.T) {
+	var s byteSize
+	if err := s.Set("1.5MB"); err != nil || s.String() != "1572864" || s.Get() != 1572864 {
+		t.Errorf("Set(1.5MB) = %s, %v", s.String(), err)
+	}
+	if err := s.Set("lots"); err == nil {
+		t.Error("Set(lots) accepted")
+	}
+}
+
+func TestValidateDurations(t *testing.T) {
+	tests := []struct {
+		flags []string
+		err   string
+	}{
+		{[]string{"max-latency", "800"}, ""},
+		{[]string{"timeout", "5000"}, ""},
+		{[]string{"max-latency", "800ns"}, "-max-latency 800ns is suspiciously small, did you mean 800ms?"},
+		{[]string{"exec-timeout", "10ns"}, "-exec-timeout 10ns is suspiciously small"},
+		{[]string{"timeout", "5000ns", "allow-tiny-durations", "true"}, ""},
+		{[]string{"max-close-latency", "-1s"}, "-max-close-latency must not be negative"},
+		{[]string{"min-speed", "2000000"}, "-min-speed 2000000 is in MB/s, did you mean 1.91"},
+	}
+	for _, tt := range tests {
+		errs, _ := validate(t, tt.flags...)
+		if tt.err == "" && len(errs) > 0 {
+			t.Errorf("%v: unexpected errors %v", tt.flags, errs)
+		}
+		if tt.err != "" && !containsMessage(errs, tt.err) {
+			t.Errorf("%v: errors %v, want %q", tt.flags, errs, tt.err)
+		}
+	}
+}
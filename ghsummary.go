@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// ghSummaryMaxRows 是 Markdown 汇总里最多列出的节点数，GitHub 限制每个步骤的汇总不超过 1MiB
+const ghSummaryMaxRows = 200
+
+// ghSummary 是 -gh-summary 的取值：单独写 -gh-summary 时写到 GITHUB_STEP_SUMMARY，也可以写成 -gh-summary=summary.md
+type ghSummary struct {
+	enabled bool
+	path    string
+}
+
+func (s *ghSummary) String() string {
+	if s.path != "" {
+		return s.path
+	}
+	if s.enabled {
+		return "true"
+	}
+	return ""
+}
+
+func (s *ghSummary) Set(value string) error {
+	switch value {
+	case "", "false":
+		*s = ghSummary{}
+	case "true":
+		*s = ghSummary{enabled: true}
+	default:
+		*s = ghSummary{enabled: true, path: value}
+	}
+	return nil
+}
+
+func (s *ghSummary) IsBoolFlag() bool {
+	return true
+}
+
+// summaryPath 返回 Markdown 汇总的写入位置，没有指定路径也不在 GitHub Actions 里运行时为空
+func (s *ghSummary) summaryPath() string {
+	if s.path != "" {
+		return s.path
+	}
+	return os.Getenv("GITHUB_STEP_SUMMARY")
+}
+
+// formatMarkdownReport 生成 Markdown 格式的测试报告，表格的列和终端里的结果表格相同，
+// cards 非空（同时用了 -scorecard）时在结果表格前加上各订阅的评分卡表格
+func formatMarkdownReport(reports []*sourceReport, allResults, results []*speedtester.Result, cards []*scorecard, now time.Time) []byte {
+	var b strings.Builder
+	good := 0
+	for _, result := range results {
+		if isProxyGood(result) {
+			good++
+		}
+	}
+	fmt.Fprintf(&b, "## clash-speedtest %s\n\n", now.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "tested **%d** nodes from %d sources, **%d** usable, **%d** good\n\n", len(allResults), len(reports), countUsable(allResults), good)
+
+	for _, report := range reports {
+		if report.SourceError != "" {
+			fmt.Fprintf(&b, "- :warning: source `%s` failed: %s\n", report.Path, markdownCell(report.SourceError))
+		}
+	}
+	if slices.ContainsFunc(reports, func(report *sourceReport) bool { return report.SourceError != "" }) {
+		b.WriteString("\n")
+	}
+	writeScorecardTable(&b, cards)
+	if len(results) == 0 {
+		return []byte(b.String())
+	}
+
+	showRegion := slices.ContainsFunc(results, func(result *speedtester.Result) bool { return result.CountryCode != "" })
+	headers := resultHeaders(showRegion)
+	b.WriteString("| " + strings.Join(headers, " | ") + " |\n")
+	b.WriteString(strings.Repeat("| --- ", len(headers)) + "|\n")
+	for i, result := range results {
+		if i == ghSummaryMaxRows {
+			fmt.Fprintf(&b, "\n%d more nodes are not listed\n", len(results)-ghSummaryMaxRows)
+			break
+		}
+		row := resultRow(result, fmt.Sprintf("%d.", i+1), showRegion, now)
+		for j, cell := range row {
+			row[j] = markdownCell(ansiPattern.ReplaceAllString(cell, ""))
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+	}
+	return []byte(b.String())
+}
+
+// writeScorecardTable 按综合评分从高到低输出评分卡表格，没有的指标显示为 -
+func writeScorecardTable(b *strings.Builder, cards []*scorecard) {
+	if len(cards) == 0 {
+		return
+	}
+	sorted := slices.Clone(cards)
+	slices.SortStableFunc(sorted, func(a, b *scorecard) int { return cmp.Compare(b.Score, a.Score) })
+	b.WriteString("| source | score | usable | good | median speed | countries | stability | remaining | expire |\n")
+	b.WriteString(strings.Repeat("| --- ", 9) + "|\n")
+	for _, card := range sorted {
+		stability, remaining, expire := "-", "-", "-"
+		if card.Stability != nil {
+			stability = fmt.Sprintf("%.0f%%", *card.Stability*100)
+		}
+		if card.RemainingBytes != nil {
+			remaining = speedtester.FormatByteSize(int(*card.RemainingBytes))
+		}
+		if card.Expire != nil {
+			expire = card.Expire.Format("2006-01-02")
+		}
+		row := []string{
+			card.Source,
+			fmt.Sprintf("%.1f", card.Score),
+			fmt.Sprintf("%d/%d", card.Usable, card.Tested),
+			fmt.Sprintf("%d/%d", card.Good, card.Tested),
+			speedtester.FormatSpeed(card.MedianSpeed),
+			fmt.Sprint(len(card.Countries)),
+			stability,
+			remaining,
+			expire,
+		}
+		for i, cell := range row {
+			row[i] = markdownCell(cell)
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+	}
+	b.WriteString("\n")
+}
+
+// markdownCell 转义表格单元格里会破坏表格结构的字符
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// workflowEscape 按 GitHub Actions 工作流命令的规则转义消息
+func workflowEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// writeGitHubSummary 追加 Markdown 汇总，并输出加载失败的来源（::warning::）和可用节点不足（::error::）的工作流命令
+func writeGitHubSummary(w io.Writer, reports []*sourceReport, allResults, results []*speedtester.Result, cards []*scorecard, minUsable int) error {
+	for _, report := range reports {
+		if report.SourceError != "" {
+			fmt.Fprintf(w, "::warning title=source failed::%s\n", workflowEscape(report.Path+": "+report.SourceError))
+		}
+	}
+	if usable := countUsable(allResults); usable < minUsable {
+		fmt.Fprintf(w, "::error title=not enough usable nodes::%d usable nodes, fewer than -min-usable %d\n", usable, minUsable)
+	}
+
+	path := ghSummaryFlag.summaryPath()
+	if path == "" {
+		return fmt.Errorf("-gh-summary: GITHUB_STEP_SUMMARY is not set, give a path like -gh-summary=summary.md")
+	}
+	// GitHub 约定同一步骤的多次写入都追加到汇总文件
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(formatMarkdownReport(reports, allResults, results, cards, time.Now())); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
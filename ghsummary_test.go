@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestWriteGitHubSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.md")
+	setFlags(t, "gh-summary", path)
+	reports := []*sourceReport{{Path: "broken.yaml", LoadReport: &speedtester.LoadReport{SourceError: "status 404"}}}
+	// b 不可用，只是被 -pin 保留在表格里，不算进 -min-usable
+	results := []*speedtester.Result{
+		{ProxyName: "a", Latency: 100 * time.Millisecond, DownloadSpeed: 10 * 1024 * 1024, ExtraURLConnectivity: true},
+		{ProxyName: "b"},
+	}
+
+	var annotations strings.Builder
+	if err := writeGitHubSummary(&annotations, reports, results, results, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"::warning title=source failed::broken.yaml: status 404\n",
+		"::error title=not enough usable nodes::1 usable nodes, fewer than -min-usable 2\n",
+	} {
+		if !strings.Contains(annotations.String(), want) {
+			t.Errorf("annotations %q missing %q", annotations.String(), want)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("summary not written: %v", err)
+	}
+	for _, want := range []string{
+		"tested **2** nodes from 1 sources, **1** usable",
+		"- :warning: source `broken.yaml` failed: status 404\n",
+		"| a |",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("summary %q missing %q", data, want)
+		}
+	}
+}
+
+// 单独写 -gh-summary 时追加到 GITHUB_STEP_SUMMARY，同一步骤的多次写入都保留
+func TestWriteGitHubSummaryStepSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "step_summary")
+	t.Setenv("GITHUB_STEP_SUMMARY", path)
+	setFlags(t, "gh-summary", "true")
+	results := []*speedtester.Result{{ProxyName: "a", Latency: 100 * time.Millisecond, DownloadSpeed: 10 * 1024 * 1024, ExtraURLConnectivity: true}}
+	for range 2 {
+		if err := writeGitHubSummary(io.Discard, nil, results, results, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "tested **1** nodes from 0 sources, **1** usable"); got != 2 {
+		t.Errorf("summary has %d reports, want 2:\n%s", got, data)
+	}
+
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+	if err := writeGitHubSummary(io.Discard, nil, results, results, nil, 0); err == nil || !strings.Contains(err.Error(), "GITHUB_STEP_SUMMARY is not set") {
+		t.Errorf("missing GITHUB_STEP_SUMMARY: %v", err)
+	}
+}
+
+// 用了 -scorecard 时汇总里按评分从高到低列出各订阅的评分卡
+func TestFormatMarkdownReportScorecard(t *testing.T) {
+	stability := 0.75
+	remaining := int64(5 << 30)
+	expire := time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	cards := []*scorecard{
+		{Source: "b.yaml", Tested: 4, Usable: 1, Score: 30.5, Countries: []string{}},
+		{Source: "a|1.yaml", Tested: 4, Usable: 3, Good: 2, MedianSpeed: 10 * 1024 * 1024, Countries: []string{"JP", "US"},
+			Stability: &stability, RemainingBytes: &remaining, Expire: &expire, Score: 82.1},
+	}
+	report := string(formatMarkdownReport(nil, nil, nil, cards, time.Now()))
+	want := "| a\\|1.yaml | 82.1 | 3/4 | 2/4 | 10.00MB/s | 2 | 75% | 5.00GiB | 2026-12-31 |\n" +
+		"| b.yaml | 30.5 | 1/4 | 0/4 | 0.00B/s | 0 | - | - | - |\n"
+	if !strings.Contains(report, want) {
+		t.Errorf("report %q missing rows %q", report, want)
+	}
+
+	if report := string(formatMarkdownReport(nil, nil, nil, nil, time.Now())); strings.Contains(report, "| source |") {
+		t.Errorf("scorecard table without -scorecard:\n%s", report)
+	}
+}
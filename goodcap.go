@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// demotedNodes 是达到优质标准、但因为 -max-good-nodes 被放进 -output 的节点
+var demotedNodes map[*speedtester.Result]bool
+
+// capGoodNodes 按 results 当前的顺序最多保留 maxGood 个优质节点，返回超出的节点。
+// 固定的节点最先占用名额，其次是为国家多样性挑选的节点，剩下的名额按顺序分配，
+// results 的顺序确定时结果也是确定的。名额按输出的条目计算，-cc-sweep 的每个变体各占一个名额
+func capGoodNodes(results []*speedtester.Result, maxGood int, pinned func(*speedtester.Result) bool) []*speedtester.Result {
+	var good []*speedtester.Result
+	for _, result := range results {
+		if isProxyGood(result) {
+			good = append(good, result)
+		}
+	}
+	kept := make(map[*speedtester.Result]bool, maxGood)
+	for _, priority := range []func(*speedtester.Result) bool{
+		pinned,
+		func(result *speedtester.Result) bool { return result.DiversityPick },
+		func(*speedtester.Result) bool { return true },
+	} {
+		for _, result := range good {
+			if len(kept) >= maxGood {
+				break
+			}
+			if !kept[result] && priority(result) {
+				kept[result] = true
+			}
+		}
+	}
+	var demoted []*speedtester.Result
+	for _, result := range good {
+		if !kept[result] {
+			demoted = append(demoted, result)
+		}
+	}
+	return demoted
+}
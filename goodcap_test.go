@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// resetDemoted 在测试结束后清掉全局的 demotedNodes
+func resetDemoted(t *testing.T) {
+	t.Cleanup(func() { demotedNodes = nil })
+	demotedNodes = nil
+}
+
+func TestCapGoodNodes(t *testing.T) {
+	setFlags(t, "good-download-speed-threshold", "5")
+	resetDemoted(t)
+	// 已经按速度排好序，G 不是优质节点
+	results := []*speedtester.Result{
+		capResult("A", 60), capResult("B", 50), capResult("C", 40),
+		capResult("G", 3), capResult("D", 30), capResult("E", 20), capResult("F", 10),
+	}
+	results[6].DiversityPick = true
+	pinned := func(result *speedtester.Result) bool { return result.ProxyName == "E" }
+
+	// 固定的 E 先占名额，其次是多样性挑选的 F，最后按顺序是 A
+	demoted := capGoodNodes(results, 3, pinned)
+	if got, want := resultNames(demoted), []string{"B", "C", "D"}; !slices.Equal(got, want) {
+		t.Errorf("demoted %v, want %v", got, want)
+	}
+	// 同样的输入总是得到同样的结果
+	for range 5 {
+		if got := resultNames(capGoodNodes(results, 3, pinned)); !slices.Equal(got, []string{"B", "C", "D"}) {
+			t.Fatalf("selection not deterministic: %v", got)
+		}
+	}
+
+	// 名额比固定的节点少时只保留排在前面的固定节点
+	allPinned := func(*speedtester.Result) bool { return true }
+	if got, want := resultNames(capGoodNodes(results, 1, allPinned)), []string{"B", "C", "D", "E", "F"}; !slices.Equal(got, want) {
+		t.Errorf("cap 1 with every node pinned: demoted %v, want %v", got, want)
+	}
+	if demoted := capGoodNodes(results, 6, pinned); len(demoted) != 0 {
+		t.Errorf("cap above the good count demoted %v", resultNames(demoted))
+	}
+}
+
+// TestCapGoodNodesCCVariants -cc-sweep 的每个变体各占一个名额，只有超出的变体被标成降级
+func TestCapGoodNodesCCVariants(t *testing.T) {
+	resetDemoted(t)
+	bbr := capResult("A", 60)
+	cubic := &speedtester.Result{ProxyName: "A", ProxyConfig: bbr.ProxyConfig, Latency: bbr.Latency, DownloadSpeed: 55 * 1024 * 1024, ExtraURLConnectivity: true}
+	results := []*speedtester.Result{bbr, cubic, capResult("B", 50), capResult("C", 40)}
+
+	demoted := capGoodNodes(results, 2, func(*speedtester.Result) bool { return false })
+	if got := resultNames(demoted); !slices.Equal(got, []string{"B", "C"}) {
+		t.Errorf("demoted %v, want [B C]", got)
+	}
+
+	demoted = capGoodNodes(results, 1, func(*speedtester.Result) bool { return false })
+	demotedNodes = map[*speedtester.Result]bool{}
+	for _, result := range demoted {
+		demotedNodes[result] = true
+	}
+	if !isProxyGood(bbr) || isProxyGood(cubic) {
+		t.Errorf("good: kept variant %v, demoted variant %v", isProxyGood(bbr), isProxyGood(cubic))
+	}
+}
+
+func TestDemotedAnnotations(t *testing.T) {
+	resetDemoted(t)
+	kept, demoted := capResult("A", 60), capResult("B", 50)
+	demotedNodes = map[*speedtester.Result]bool{demoted: true}
+
+	if !isProxyGood(kept) || isProxyGood(demoted) {
+		t.Errorf("good: kept %v, demoted %v", isProxyGood(kept), isProxyGood(demoted))
+	}
+
+	data, err := marshalResults([]*speedtester.Result{kept, demoted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "# good, demoted by cap") != 1 {
+		t.Errorf("output yaml without exactly one demoted comment:\n%s", data)
+	}
+
+	out := captureConsole(t, func() { printResults([]*speedtester.Result{kept, demoted}) })
+	if strings.Count(out, "(demoted by cap)") != 1 {
+		t.Errorf("table without exactly one demoted mark:\n%s", out)
+	}
+
+	data, err = marshalResultsFile("", time.Now(), []*speedtester.Result{kept, demoted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Results []struct {
+			Name         string `json:"proxy_name"`
+			Good         bool   `json:"good"`
+			DemotedByCap bool   `json:"demoted_by_cap"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Results) != 2 || !file.Results[0].Good || file.Results[0].DemotedByCap || file.Results[1].Good || !file.Results[1].DemotedByCap {
+		t.Errorf("results json %+v", file.Results)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// nearMissMargin 是判断差一点达标时放宽阈值的比例
+const nearMissMargin = 0.2
+
+// goodMeta 是没有优质节点时写在 -good-output 旁边的说明，让下游程序区分“运气不好”和“节点真的都坏了”
+type goodMeta struct {
+	GeneratedAt    time.Time `json:"generated_at"`
+	GoodOutput     string    `json:"good_output"`
+	Tested         int       `json:"tested"`
+	Usable         int       `json:"usable"`
+	NearMissMargin float64   `json:"near_miss_margin"`
+	// NearMisses 是阈值放宽 NearMissMargin 后能达标的节点数
+	NearMisses int `json:"near_misses"`
+	// LimitingCriteria 按未达标的第一个条件统计节点数
+	LimitingCriteria map[string]int `json:"limiting_criteria"`
+	BestCandidate    *goodCandidate `json:"best_candidate,omitempty"`
+}
+
+type goodCandidate struct {
+	Name                string  `json:"name"`
+	Reason              string  `json:"reason"`
+	NearMiss            bool    `json:"near_miss"`
+	LatencyMs           float64 `json:"latency_ms"`
+	JitterMs            float64 `json:"jitter_ms"`
+	PacketLossPct       float64 `json:"packet_loss_pct"`
+	DownloadBytesPerSec float64 `json:"download_bytes_per_sec"`
+	UploadBytesPerSec   float64 `json:"upload_bytes_per_sec"`
+}
+
+// goodScenario 把节点所在来源的阈值转换成场景，margin 大于 0 时按比例放宽，
+// 这样近似达标的分析和 -scenarios 用的是同一个判定
+func goodScenario(result *speedtester.Result, margin float64) *scenario {
+	t := thresholdsFor(result)
+	minSpeed := t.minSpeed * (1 - margin)
+	goodSpeed := t.goodSpeed * (1 - margin)
+	s := &scenario{
+		Name:       "good",
+		MinSpeed:   &minSpeed,
+		GoodSpeed:  &goodSpeed,
+		maxLatency: time.Duration(float64(t.maxLatency) * (1 + margin)),
+	}
+	if *minSustainedSpeed > 0 {
+		sustained := *minSustainedSpeed * (1 - margin)
+		s.MinSustainedSpeed = &sustained
+	}
+	return s
+}
+
+// criterionOf 从判定原因里去掉具体数值，得到用于统计的条件名，例如 "latency 812ms > 800ms" 得到 "latency"
+func criterionOf(reason string) string {
+	if name, _, ok := strings.Cut(reason, ":"); ok {
+		return name
+	}
+	words := strings.Fields(reason)
+	for i, word := range words {
+		if unicode.IsDigit(rune(word[0])) || word == "N/A" {
+			return strings.Join(words[:i], " ")
+		}
+	}
+	return reason
+}
+
+// buildGoodMeta 用严格和放宽的阈值分别判定每个测试过的节点
+func buildGoodMeta(now time.Time, goodOutput string, results []*speedtester.Result) *goodMeta {
+	meta := &goodMeta{
+		GeneratedAt:      now,
+		GoodOutput:       goodOutput,
+		Tested:           len(results),
+		NearMissMargin:   nearMissMargin,
+		LimitingCriteria: make(map[string]int),
+	}
+	var best *speedtester.Result
+	var bestVerdict speedtester.Verdict
+	bestNearMiss := false
+	for _, result := range results {
+		verdict := goodScenario(result, 0).evaluate(result)
+		if verdict.Usable {
+			meta.Usable++
+		}
+		reason := verdict.Reason
+		if verdict.Usable {
+			reason = "good download speed " + result.FormatDownloadSpeed()
+		}
+		meta.LimitingCriteria[criterionOf(reason)]++
+		verdict.Reason = reason
+
+		relaxed := goodScenario(result, nearMissMargin).evaluate(result)
+		nearMiss := relaxed.Usable && relaxed.Good
+		if nearMiss {
+			meta.NearMisses++
+		}
+		if best == nil || betterCandidate(result, verdict, nearMiss, best, bestVerdict, bestNearMiss) {
+			best, bestVerdict, bestNearMiss = result, verdict, nearMiss
+		}
+	}
+	if best != nil {
+		meta.BestCandidate = &goodCandidate{
+			Name:                best.ProxyName,
+			Reason:              bestVerdict.Reason,
+			NearMiss:            bestNearMiss,
+			LatencyMs:           float64(best.Latency.Microseconds()) / 1000,
+			JitterMs:            float64(best.Jitter.Microseconds()) / 1000,
+			PacketLossPct:       best.PacketLoss,
+			DownloadBytesPerSec: best.DownloadSpeed,
+			UploadBytesPerSec:   best.UploadSpeed,
+		}
+	}
+	return meta
+}
+
+// betterCandidate 依次比较：差一点达标的优先，其次可用的，再次下载速度快的
+func betterCandidate(a *speedtester.Result, av speedtester.Verdict, aNear bool, b *speedtester.Result, bv speedtester.Verdict, bNear bool) bool {
+	if aNear != bNear {
+		return aNear
+	}
+	if av.Usable != bv.Usable {
+		return av.Usable
+	}
+	return a.DownloadSpeed > b.DownloadSpeed
+}
+
+// goodMetaPath 返回优质节点输出文件旁边的说明文件路径，good.yaml 对应 good.meta.json
+func goodMetaPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".meta.json"
+}
+
+func marshalGoodMeta(meta *goodMeta) ([]byte, error) {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestCriterionOf(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   string
+	}{
+		{"latency 812ms > 800ms", "latency"},
+		{"close latency 1.2s > 1s", "close latency"},
+		{"download speed 3.00MB/s", "download speed"},
+		{"download speed N/A", "download speed"},
+		{"invalid measurement: clock jump", "invalid measurement"},
+		{"websocket: ", "websocket"},
+		{"unreachable", "unreachable"},
+		{"direct leak", "direct leak"},
+	}
+	for _, tt := range tests {
+		if got := criterionOf(tt.reason); got != tt.want {
+			t.Errorf("criterionOf(%q) = %q, want %q", tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestGoodMetaPath(t *testing.T) {
+	tests := map[string]string{
+		"/tmp/good.yaml": "/tmp/good.meta.json",
+		"/tmp/good":      "/tmp/good.meta.json",
+		"/tmp/a.b.yml":   "/tmp/a.b.meta.json",
+	}
+	for path, want := range tests {
+		if got := goodMetaPath(path); got != want {
+			t.Errorf("goodMetaPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// metaResult 构造一个节点结果，speed 单位 MB/s，latency 为 0 表示不可达
+func metaResult(name string, latency time.Duration, speed float64) *speedtester.Result {
+	return &speedtester.Result{
+		ProxyName:     name,
+		ProxyConfig:   map[string]any{"name": name, "type": "ss", "server": name + ".example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"},
+		Latency:       latency,
+		DownloadSpeed: speed * 1024 * 1024,
+	}
+}
+
+func TestGoodScenarioMargin(t *testing.T) {
+	setFlags(t, "min-speed", "5", "good-download-speed-threshold", "10", "max-latency", "800ms", "min-sustained-speed", "2")
+	result := metaResult("A", 900*time.Millisecond, 9)
+	result.SustainedSpeed = 1.7 * 1024 * 1024
+
+	strict := goodScenario(result, 0)
+	if strict.maxLatency != 800*time.Millisecond || *strict.MinSpeed != 5 || *strict.GoodSpeed != 10 || *strict.MinSustainedSpeed != 2 {
+		t.Errorf("strict scenario %+v", strict)
+	}
+	if v := strict.evaluate(result); v.Usable {
+		t.Errorf("strict verdict %+v, want unusable", v)
+	}
+
+	// 放宽 20%：延迟上限 960ms，下载 4MB/s，优质 8MB/s，持续 1.6MB/s
+	relaxed := goodScenario(result, nearMissMargin)
+	if relaxed.maxLatency != 960*time.Millisecond || *relaxed.MinSpeed != 4 || *relaxed.GoodSpeed != 8 || *relaxed.MinSustainedSpeed != 1.6 {
+		t.Errorf("relaxed scenario %+v", relaxed)
+	}
+	if v := relaxed.evaluate(result); !v.Usable || !v.Good {
+		t.Errorf("relaxed verdict %+v, want good", v)
+	}
+
+	setFlags(t, "min-sustained-speed", "0")
+	if s := goodScenario(result, nearMissMargin); s.MinSustainedSpeed != nil {
+		t.Error("min-sustained-speed 0 still checked")
+	}
+}
+
+func TestBuildGoodMeta(t *testing.T) {
+	setFlags(t, "min-speed", "5", "good-download-speed-threshold", "10", "max-latency", "800ms")
+	results := []*speedtester.Result{
+		// 可用，放宽后优质
+		metaResult("A", 100*time.Millisecond, 9),
+		// 延迟超标，放宽后可用且优质，下载最快但不如可用的 A
+		metaResult("B", 900*time.Millisecond, 20),
+		// 下载太慢，放宽后仍不可用
+		metaResult("C", 100*time.Millisecond, 3),
+		metaResult("D", 0, 0),
+		// 可用，放宽后仍不优质
+		metaResult("E", 100*time.Millisecond, 7),
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta := buildGoodMeta(now, "/tmp/good.yaml", results)
+
+	if meta.Tested != 5 || meta.Usable != 2 || meta.NearMisses != 2 {
+		t.Errorf("tested %d usable %d near misses %d, want 5 2 2", meta.Tested, meta.Usable, meta.NearMisses)
+	}
+	want := map[string]int{"good download speed": 2, "latency": 1, "download speed": 1, "unreachable": 1}
+	if len(meta.LimitingCriteria) != len(want) {
+		t.Errorf("limiting criteria %v, want %v", meta.LimitingCriteria, want)
+	}
+	for criterion, n := range want {
+		if meta.LimitingCriteria[criterion] != n {
+			t.Errorf("limiting criteria %v, want %v", meta.LimitingCriteria, want)
+			break
+		}
+	}
+	best := meta.BestCandidate
+	if best == nil || best.Name != "A" || !best.NearMiss || best.LatencyMs != 100 || best.DownloadBytesPerSec != 9*1024*1024 {
+		t.Fatalf("best candidate %+v, want A", best)
+	}
+	if criterionOf(best.Reason) != "good download speed" {
+		t.Errorf("best candidate reason %q", best.Reason)
+	}
+
+	if meta := buildGoodMeta(now, "/tmp/good.yaml", nil); meta.BestCandidate != nil || meta.Tested != 0 {
+		t.Errorf("empty run meta %+v", meta)
+	}
+}
+
+func TestSaveGoodConfig(t *testing.T) {
+	setFlags(t, "min-speed", "5", "good-download-speed-threshold", "10")
+	path := filepath.Join(t.TempDir(), "good.yaml")
+	metaPath := goodMetaPath(path)
+	previous := []byte("proxies:\n- name: old\n")
+	if err := os.WriteFile(path, previous, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results := []*speedtester.Result{metaResult("A", 100*time.Millisecond, 9)}
+
+	// 没有优质节点：保留原来的文件，写出说明
+	captureConsole(t, func() { saveGoodConfig(nil, path, results) })
+	if data, _ := os.ReadFile(path); string(data) != string(previous) {
+		t.Errorf("good output overwritten: %q", data)
+	}
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta goodMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Tested != 1 || meta.NearMisses != 1 || meta.GoodOutput != path {
+		t.Errorf("meta %+v", meta)
+	}
+
+	// -allow-empty-good 恢复旧行为，真的写出空文件
+	setFlags(t, "min-speed", "5", "good-download-speed-threshold", "10", "allow-empty-good", "true")
+	captureConsole(t, func() { saveGoodConfig(nil, path, results) })
+	if data, _ := os.ReadFile(path); string(data) != "proxies: []\n" {
+		t.Errorf("-allow-empty-good output %q, want an empty proxies list", data)
+	}
+
+	// 重新有优质节点后写出节点并删除过时的说明
+	good := metaResult("B", 100*time.Millisecond, 20)
+	captureConsole(t, func() { saveGoodConfig([]*speedtester.Result{good}, path, results) })
+	if proxies, err := loadPreviousProxies(path); err != nil || len(proxies) != 1 {
+		t.Errorf("good output: %v, %v", proxies, err)
+	}
+	if _, err := os.Stat(metaPath); !os.IsNotExist(err) {
+		t.Errorf("stale meta file not removed: %v", err)
+	}
+}
+
+// -save-every、-stream-output 中途写过 good.yaml，最终没有优质节点时恢复成运行前的内容
+func TestSaveGoodConfigAfterPartialSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "good.yaml")
+	setFlags(t, "min-speed", "5", "good-download-speed-threshold", "10", "good-output", path, "output", "")
+	outputsBeforeRun = map[string][]map[string]any{}
+	outputBackups = map[string]partialBackup{}
+	t.Cleanup(func() {
+		outputsBeforeRun = map[string][]map[string]any{}
+		outputBackups = map[string]partialBackup{}
+	})
+	previous := []byte("proxies:\n- name: old\n")
+	if err := os.WriteFile(path, previous, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	savePartialConfig([]*speedtester.Result{capResult("B", 20)})
+	if data, _ := os.ReadFile(path); string(data) == string(previous) {
+		t.Fatal("partial save did not write good output")
+	}
+
+	// B 在最终过滤时被排除，剩下的 A 不是优质节点
+	results := []*speedtester.Result{capResult("A", 9)}
+	captureConsole(t, func() { saveConfig(results, results) })
+	if data, _ := os.ReadFile(path); string(data) != string(previous) {
+		t.Errorf("good output after the run %q, want the content from before the run", data)
+	}
+	if _, err := os.Stat(goodMetaPath(path)); err != nil {
+		t.Errorf("meta file not written: %v", err)
+	}
+}
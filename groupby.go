@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/olekukonko/tablewriter"
+)
+
+// 支持的 -group-by 取值
+const (
+	groupByType    = "type"
+	groupByCountry = "country"
+	groupBySource  = "source"
+)
+
+// groupKeyFunc 返回 -group-by 对应的分组函数，by 为空时返回 nil
+func groupKeyFunc(by string) (func(*speedtester.Result) string, error) {
+	switch by {
+	case "":
+		return nil, nil
+	case groupByType:
+		return func(result *speedtester.Result) string { return result.ProxyType }, nil
+	case groupByCountry:
+		return func(result *speedtester.Result) string {
+			if result.CountryCode == "" {
+				return "??"
+			}
+			return result.CountryCode
+		}, nil
+	case groupBySource:
+		return func(result *speedtester.Result) string { return result.Source }, nil
+	}
+	return nil, fmt.Errorf("unknown value %q, supported: type, country, source", by)
+}
+
+// groupStats 是一个分组的汇总，延迟取所有连通节点，下载速度只取可用节点
+type groupStats struct {
+	Key           string        `json:"key"`
+	Tested        int           `json:"tested"`
+	Usable        int           `json:"usable"`
+	UsableRatio   float64       `json:"usable_ratio"`
+	MedianLatency time.Duration `json:"median_latency"`
+	MedianSpeed   float64       `json:"median_download_speed"`
+}
+
+// buildGroupStats 按 key 汇总 results，结果按 key 排序
+func buildGroupStats(results []*speedtester.Result, key func(*speedtester.Result) string) []*groupStats {
+	groups := make(map[string]*groupStats)
+	latencies := make(map[string][]float64)
+	speeds := make(map[string][]float64)
+	for _, result := range results {
+		k := key(result)
+		stats, ok := groups[k]
+		if !ok {
+			stats = &groupStats{Key: k}
+			groups[k] = stats
+		}
+		stats.Tested++
+		if result.Latency > 0 {
+			latencies[k] = append(latencies[k], float64(result.Latency))
+		}
+		if isProxyUsable(result) {
+			stats.Usable++
+			speeds[k] = append(speeds[k], result.DownloadSpeed)
+		}
+	}
+
+	sorted := make([]*groupStats, 0, len(groups))
+	for k, stats := range groups {
+		stats.UsableRatio = float64(stats.Usable) / float64(stats.Tested)
+		stats.MedianLatency = time.Duration(median(latencies[k]))
+		stats.MedianSpeed = median(speeds[k])
+		sorted = append(sorted, stats)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// sortByGroup 把结果按分组排在一起，组内保持原来的顺序
+func sortByGroup(results []*speedtester.Result, key func(*speedtester.Result) string) []*speedtester.Result {
+	sorted := append([]*speedtester.Result(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return key(sorted[i]) < key(sorted[j])
+	})
+	return sorted
+}
+
+// printGroupStats 在结果表格后面输出每个分组的汇总
+func printGroupStats(groups []*groupStats) {
+	table := tablewriter.NewWriter(console)
+	table.SetHeader([]string{"分组", "测试节点", "可用节点", "可用率", "延迟中位数", "下载速度中位数"})
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+	for _, stats := range groups {
+		latencyStr, speedStr := "N/A", "N/A"
+		if stats.MedianLatency > 0 {
+			latencyStr = fmt.Sprintf("%dms", stats.MedianLatency.Milliseconds())
+		}
+		if stats.Usable > 0 {
+			speedStr = speedtester.FormatSpeed(stats.MedianSpeed)
+		}
+		table.Append([]string{
+			stats.Key,
+			fmt.Sprintf("%d", stats.Tested),
+			fmt.Sprintf("%d", stats.Usable),
+			fmt.Sprintf("%.0f%%", math.Round(stats.UsableRatio*100)),
+			latencyStr,
+			speedStr,
+		})
+	}
+	table.Render()
+	fmt.Fprintln(console)
+}
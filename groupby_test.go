@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// captureConsole 把 fn 运行期间写到 console 的内容返回
+func captureConsole(t *testing.T, fn func()) string {
+	t.Helper()
+	out, err := os.Create(filepath.Join(t.TempDir(), "console"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := console
+	console = out
+	defer func() { console = previous }()
+	fn()
+	out.Close()
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func groupTestResults() []*speedtester.Result {
+	usable := func(name, proxyType, country string, latency time.Duration, speed float64) *speedtester.Result {
+		return &speedtester.Result{
+			ProxyName:            name,
+			ProxyType:            proxyType,
+			CountryCode:          country,
+			Source:               "sub-" + strings.ToLower(proxyType) + ".yaml",
+			Latency:              latency,
+			DownloadSpeed:        speed * 1024 * 1024,
+			ExtraURLConnectivity: true,
+		}
+	}
+	return []*speedtester.Result{
+		usable("V1", "Vmess", "HK", 100*time.Millisecond, 10),
+		usable("H1", "Hysteria2", "JP", 50*time.Millisecond, 40),
+		usable("V2", "Vmess", "", 300*time.Millisecond, 20),
+		// 连通但太慢，算延迟不算速度
+		usable("V3", "Vmess", "HK", 200*time.Millisecond, 0.1),
+		{ProxyName: "H2", ProxyType: "Hysteria2", CountryCode: "JP", Source: "sub-hysteria2.yaml"},
+	}
+}
+
+func TestGroupKeyFunc(t *testing.T) {
+	result := &speedtester.Result{ProxyType: "Vmess", Source: "a.yaml"}
+	for by, want := range map[string]string{"type": "Vmess", "country": "??", "source": "a.yaml"} {
+		key, err := groupKeyFunc(by)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := key(result); got != want {
+			t.Errorf("group by %s = %q, want %q", by, got, want)
+		}
+	}
+	if key, err := groupKeyFunc(""); key != nil || err != nil {
+		t.Errorf("groupKeyFunc(\"\") = non-nil %v, %v", key != nil, err)
+	}
+	if _, err := groupKeyFunc("colour"); err == nil {
+		t.Error("groupKeyFunc accepted colour")
+	}
+}
+
+func TestBuildGroupStats(t *testing.T) {
+	setFlags(t, "min-speed", "1")
+	key, _ := groupKeyFunc(groupByType)
+	groups := buildGroupStats(groupTestResults(), key)
+	if len(groups) != 2 || groups[0].Key != "Hysteria2" || groups[1].Key != "Vmess" {
+		t.Fatalf("groups %+v", groups)
+	}
+
+	hy2, vmess := groups[0], groups[1]
+	if hy2.Tested != 2 || hy2.Usable != 1 || hy2.UsableRatio != 0.5 {
+		t.Errorf("Hysteria2 %+v", hy2)
+	}
+	// 不可达的节点不参与延迟中位数
+	if hy2.MedianLatency != 50*time.Millisecond || hy2.MedianSpeed != 40*1024*1024 {
+		t.Errorf("Hysteria2 medians %s, %v", hy2.MedianLatency, hy2.MedianSpeed)
+	}
+	if vmess.Tested != 3 || vmess.Usable != 2 {
+		t.Errorf("Vmess %+v", vmess)
+	}
+	// 延迟取三个连通节点，速度只取两个可用节点
+	if vmess.MedianLatency != 200*time.Millisecond || vmess.MedianSpeed != 15*1024*1024 {
+		t.Errorf("Vmess medians %s, %v", vmess.MedianLatency, vmess.MedianSpeed)
+	}
+}
+
+func TestSortByGroup(t *testing.T) {
+	results := groupTestResults()
+	key, _ := groupKeyFunc(groupByCountry)
+	var names []string
+	for _, result := range sortByGroup(results, key) {
+		names = append(names, result.ProxyName)
+	}
+	// 组内保持原来的顺序
+	if want := []string{"V2", "V1", "V3", "H1", "H2"}; !slices.Equal(names, want) {
+		t.Errorf("sorted %v, want %v", names, want)
+	}
+	if results[0].ProxyName != "V1" {
+		t.Error("sortByGroup reordered its input")
+	}
+}
+
+func TestPrintResultsGrouped(t *testing.T) {
+	setFlags(t, "group-by", "type", "fast", "true")
+	var printed []*speedtester.Result
+	out := captureConsole(t, func() { printed = printResults(groupTestResults()) })
+
+	hy2 := strings.Index(out, "── Hysteria2 ──")
+	vmess := strings.Index(out, "── Vmess ──")
+	if hy2 < 0 || vmess < 0 || hy2 > vmess {
+		t.Fatalf("group separators missing or out of order:\n%s", out)
+	}
+	if strings.Count(out, "──") != 4 {
+		t.Errorf("want exactly 2 separator rows:\n%s", out)
+	}
+	if h2, v1 := strings.Index(out, "H2"), strings.Index(out, "V1"); h2 > vmess || v1 < vmess {
+		t.Errorf("rows not under their group:\n%s", out)
+	}
+	// 序号不算分隔行
+	if !strings.Contains(out, "5.") || strings.Contains(out, "6.") {
+		t.Errorf("row numbers wrong:\n%s", out)
+	}
+	if printed[0].ProxyName != "H1" {
+		t.Errorf("printResults returned %s first, want the grouped order", printed[0].ProxyName)
+	}
+}
+
+func TestPrintGroupStats(t *testing.T) {
+	setFlags(t, "min-speed", "1")
+	key, _ := groupKeyFunc(groupBySource)
+	out := captureConsole(t, func() { printGroupStats(buildGroupStats(groupTestResults(), key)) })
+	for _, want := range []string{"sub-vmess.yaml", "sub-hysteria2.yaml", "67%", "50%", "200ms", "50ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("group summary missing %q:\n%s", want, out)
+		}
+	}
+
+	out = captureConsole(t, func() {
+		printGroupStats([]*groupStats{{Key: "dead", Tested: 1}})
+	})
+	if strings.Count(out, "N/A") != 2 {
+		t.Errorf("group without usable nodes:\n%s", out)
+	}
+}
+
+func TestResultsFileGroupStats(t *testing.T) {
+	setFlags(t, "min-speed", "1", "group-by", "type")
+	data, err := marshalResultsFile("tokyo", time.Now(), groupTestResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		ByType    []groupStats    `json:"by_type"`
+		ByCountry json.RawMessage `json:"by_country"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.ByType) != 2 || file.ByType[0].Key != "Hysteria2" || file.ByType[1].Key != "Vmess" {
+		t.Fatalf("by_type %+v", file.ByType)
+	}
+	if vmess := file.ByType[1]; vmess.Tested != 3 || vmess.Usable != 2 || vmess.MedianSpeed != 15*1024*1024 {
+		t.Errorf("Vmess %+v", vmess)
+	}
+	if file.ByCountry != nil {
+		t.Errorf("by_country written when grouping by type: %s", file.ByCountry)
+	}
+
+	// 没有 -group-by 时不写分组汇总
+	setFlags(t, "group-by", "")
+	data, err = marshalResultsFile("tokyo", time.Now(), groupTestResults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "by_type") {
+		t.Errorf("by_type written without -group-by:\n%s", data)
+	}
+}
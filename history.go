@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// historyVersion 在历史文件格式出现不兼容变化时递增
+const historyVersion = 1
+
+// historyFile 保存最近若干次运行的测试结果，节点按 NodeKey 对应
+type historyFile struct {
+	Version int          `json:"version"`
+	Runs    []historyRun `json:"runs"`
+	// Latest 按 NodeKey 保存每个节点最近一次实际测试的完整结果，供 -only-changed 复用
+	Latest map[string]*cachedResult `json:"latest,omitempty"`
+	// FailStreaks 是节点连续不可用的次数，供 -drop-after 使用
+	FailStreaks map[string]int `json:"fail_streaks,omitempty"`
+	// Seen 按 NodeKey 记录节点第一次和最后一次出现的时间，供 -min-age 使用
+	Seen map[string]*seenRecord `json:"seen,omitempty"`
+}
+
+type historyRun struct {
+	Time    time.Time       `json:"time"`
+	Records []historyRecord `json:"records"`
+}
+
+type historyRecord struct {
+	NodeKey       string  `json:"node_key"`
+	Name          string  `json:"name"`
+	LatencyMs     int64   `json:"latency_ms"`
+	DownloadSpeed float64 `json:"download_speed"`
+	UploadSpeed   float64 `json:"upload_speed"`
+	Usable        bool    `json:"usable"`
+}
+
+// loadHistory 读取历史文件，文件不存在时返回空历史
+func loadHistory(path string) (*historyFile, error) {
+	history := &historyFile{Version: historyVersion}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, fmt.Errorf("parse history %s: %w", path, err)
+	}
+	if history.Version > historyVersion {
+		return nil, fmt.Errorf("history %s is written by a newer version (%d > %d)", path, history.Version, historyVersion)
+	}
+	history.Version = historyVersion
+	return history, nil
+}
+
+// appendRun 记录本次运行的全部结果（包括不可用的节点），并清理超出保留时间的记录
+func (h *historyFile) appendRun(now time.Time, results []*speedtester.Result, retention time.Duration) {
+	run := historyRun{Time: now, Records: make([]historyRecord, 0, len(results))}
+	for _, result := range results {
+		name, _ := result.ProxyConfig["name"].(string)
+		run.Records = append(run.Records, historyRecord{
+			NodeKey:       speedtester.NodeKey(result.ProxyConfig),
+			Name:          name,
+			LatencyMs:     result.Latency.Milliseconds(),
+			DownloadSpeed: result.DownloadSpeed,
+			UploadSpeed:   result.UploadSpeed,
+			Usable:        isProxyUsable(result),
+		})
+	}
+	h.Runs = append(h.Runs, run)
+	if retention <= 0 {
+		return
+	}
+	kept := h.Runs[:0]
+	for _, r := range h.Runs {
+		if now.Sub(r.Time) <= retention {
+			kept = append(kept, r)
+		}
+	}
+	h.Runs = kept
+}
+
+// lastRecords 按 NodeKey 返回每个节点最近一次运行的记录，用于和本次结果对比
+func (h *historyFile) lastRecords() map[string]historyRecord {
+	return recordsByKey(h.Runs)
+}
+
+// lastRunRecords 按 NodeKey 返回最近一次运行的记录，没有运行记录时返回 nil
+func (h *historyFile) lastRunRecords() map[string]historyRecord {
+	if len(h.Runs) == 0 {
+		return nil
+	}
+	return recordsByKey(h.Runs[len(h.Runs)-1:])
+}
+
+// recordsByKey 按 NodeKey 索引 runs 里的记录，同一节点以后面的运行为准
+func recordsByKey(runs []historyRun) map[string]historyRecord {
+	records := make(map[string]historyRecord)
+	for _, run := range runs {
+		for _, record := range run.Records {
+			records[record.NodeKey] = record
+		}
+	}
+	return records
+}
+
+// save 先写临时文件再重命名，避免中途退出留下损坏的历史文件。
+// Latest 里带有完整的节点配置，所以文件只对当前用户可读
+func (h *historyFile) save(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o600)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// usePreviousRecords 在测试期间把 history 的记录作为上一次运行的结果
+func usePreviousRecords(t *testing.T, history *historyFile) {
+	t.Helper()
+	previous := previousRecords
+	previousRecords = history.lastRecords()
+	t.Cleanup(func() { previousRecords = previous })
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	setFlags(t, "min-speed", "1")
+	path := filepath.Join(t.TempDir(), "history.json")
+	history, err := loadHistory(path)
+	if err != nil || len(history.Runs) != 0 || history.Version != historyVersion {
+		t.Fatalf("missing history file: %+v, %v", history, err)
+	}
+
+	now := time.Now().Round(0)
+	history.appendRun(now.Add(-10*24*time.Hour), []*speedtester.Result{capResult("A", 1)}, 0)
+	history.appendRun(now, []*speedtester.Result{capResult("A", 5), capResult("B", 0.5)}, 7*24*time.Hour)
+	if len(history.Runs) != 1 {
+		t.Fatalf("%d runs kept, want the one inside the retention", len(history.Runs))
+	}
+	if err := history.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("history file mode %v, %v", info.Mode(), err)
+	}
+
+	loaded, err := loadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := loaded.Runs[0].Records
+	if !loaded.Runs[0].Time.Equal(now) || len(records) != 2 {
+		t.Fatalf("loaded %+v", loaded.Runs)
+	}
+	if a := records[0]; a.Name != "A" || a.LatencyMs != 100 || a.DownloadSpeed != 5*1024*1024 || !a.Usable {
+		t.Errorf("record A %+v", a)
+	}
+	if records[1].Usable {
+		t.Error("record B usable below -min-speed")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHistory(path); err == nil {
+		t.Error("corrupt history file loaded")
+	}
+}
+
+// lastRecords 取每个节点最近一次出现的记录，上一次没有测到的节点用更早的记录
+func TestHistoryLastRecords(t *testing.T) {
+	setFlags(t)
+	history := &historyFile{}
+	now := time.Now()
+	history.appendRun(now.Add(-2*time.Hour), []*speedtester.Result{capResult("A", 1), capResult("B", 2)}, 0)
+	history.appendRun(now.Add(-time.Hour), []*speedtester.Result{capResult("A", 3)}, 0)
+	records := history.lastRecords()
+	if len(records) != 2 {
+		t.Fatalf("%d records", len(records))
+	}
+	if a := records[speedtester.NodeKey(capResult("A", 0).ProxyConfig)]; a.DownloadSpeed != 3*1024*1024 {
+		t.Errorf("A from an older run: %+v", a)
+	}
+	if b := records[speedtester.NodeKey(capResult("B", 0).ProxyConfig)]; b.DownloadSpeed != 2*1024*1024 {
+		t.Errorf("B %+v", b)
+	}
+	if records := (&historyFile{}).lastRecords(); records == nil || len(records) != 0 {
+		t.Errorf("empty history records %v", records)
+	}
+}
+
+func TestFormatHistoryDelta(t *testing.T) {
+	setFlags(t)
+	history := &historyFile{}
+	prevA, prevB, prevC := capResult("A", 10), capResult("B", 10), capResult("C", 0)
+	prevC.Latency = 0
+	history.appendRun(time.Now(), []*speedtester.Result{prevA, prevB, prevC}, 0)
+	usePreviousRecords(t, history)
+
+	faster := capResult("A", 12)
+	faster.Latency = 80 * time.Millisecond
+	slower := capResult("B", 7.5)
+	slower.Latency = 150 * time.Millisecond
+	recovered := capResult("C", 5)
+	tests := []struct {
+		name   string
+		result *speedtester.Result
+		want   string
+	}{
+		{"better", faster, colorGreen + "+2.00MB/s" + colorReset + " / " + colorGreen + "-20ms" + colorReset},
+		{"worse", slower, colorRed + "-2.50MB/s" + colorReset + " / " + colorRed + "+50ms" + colorReset},
+		{"unchanged", capResult("A", 10), "+0.00B/s / +0ms"},
+		// 上次没有测出结果的项没有可比的值
+		{"previously unreachable", recovered, "N/A / N/A"},
+		{"new", capResult("D", 10), colorGreen + "new" + colorReset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatHistoryDelta(tt.result); got != tt.want {
+				t.Errorf("formatHistoryDelta = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// -fast 只比较延迟
+	setFlags(t, "fast", "true")
+	if got := formatHistoryDelta(slower); got != colorRed+"+50ms"+colorReset {
+		t.Errorf("fast mode delta %q", got)
+	}
+}
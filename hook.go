@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+)
+
+// resultHook 对每个测试完成的节点执行一次外部命令，Result 的 JSON 从 stdin 传入。
+// 命令在后台并发执行，wait 之后才能读取否决结果
+type resultHook struct {
+	command string
+	timeout time.Duration
+	veto    bool
+
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	vetoed map[*speedtester.Result]string
+}
+
+func newResultHook(command string, timeout time.Duration, concurrency int, veto bool) *resultHook {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &resultHook{
+		command: command,
+		timeout: timeout,
+		veto:    veto,
+		sem:     make(chan struct{}, concurrency),
+		vetoed:  make(map[*speedtester.Result]string),
+	}
+}
+
+// resultVerdict 返回传给外部命令的节点结论：good、usable 或 unusable
+func resultVerdict(result *speedtester.Result) string {
+	switch {
+	case isProxyGood(result):
+		return "good"
+	case isProxyUsable(result):
+		return "usable"
+	}
+	return "unusable"
+}
+
+// run 在后台执行命令，并发数超过上限时会阻塞等待
+func (h *resultHook) run(result *speedtester.Result) {
+	input, err := json.Marshal(result)
+	if err != nil {
+		log.Warnln("exec-per-result: marshal %s failed: %v", result.ProxyName, err)
+		return
+	}
+	env := append(os.Environ(),
+		"NODE_NAME="+result.ProxyName,
+		"VERDICT="+resultVerdict(result),
+		fmt.Sprintf("DOWNLOAD_MBPS=%.2f", result.DownloadSpeed/(1024*1024)),
+	)
+
+	h.sem <- struct{}{}
+	h.wg.Add(1)
+	go func() {
+		defer func() {
+			<-h.sem
+			h.wg.Done()
+		}()
+		if err := h.exec(result.ProxyName, input, env); err != nil {
+			log.Warnln("exec-per-result: %s: %v", result.ProxyName, err)
+			if h.veto {
+				h.mu.Lock()
+				h.vetoed[result] = err.Error()
+				h.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (h *resultHook) exec(name string, input []byte, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.command)
+	}
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	// 超时只会杀掉 shell，脚本里启动的子进程还拿着 stderr，不设置 WaitDelay 的话要等子进程自己退出
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	scanner := bufio.NewScanner(&stderr)
+	for scanner.Scan() {
+		log.Infoln("exec-per-result: %s: %s", name, scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", h.timeout)
+	}
+	return err
+}
+
+// wait 等待所有已经启动的命令结束
+func (h *resultHook) wait() {
+	h.wg.Wait()
+}
+
+// filter 去掉被外部命令否决的节点，keep 返回 true 的节点不受影响
+func (h *resultHook) filter(results []*speedtester.Result, keep func(*speedtester.Result) bool) []*speedtester.Result {
+	if len(h.vetoed) == 0 {
+		return results
+	}
+	kept := make([]*speedtester.Result, 0, len(results))
+	for _, result := range results {
+		if reason, ok := h.vetoed[result]; ok && !keep(result) {
+			fmt.Fprintf(os.Stderr, "%svetoed by -exec-per-result: %s: %s%s\n", colorYellow, result.ProxyName, reason, colorReset)
+			continue
+		}
+		kept = append(kept, result)
+	}
+	return kept
+}
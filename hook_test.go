@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// writeHookScript 在临时目录里写一个 sh 脚本，返回执行它的命令
+func writeHookScript(t *testing.T, body string) (command, dir string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts are sh scripts")
+	}
+	dir = t.TempDir()
+	script := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncd "+dir+"\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return script, dir
+}
+
+func hookResult(name string, speed float64) *speedtester.Result {
+	result := &speedtester.Result{
+		ProxyName: name, DownloadSpeed: speed * 1024 * 1024, ExtraURLConnectivity: true,
+		ProxyConfig: map[string]any{"name": name, "type": "ss", "server": name + ".example.com", "port": 443},
+	}
+	if speed > 0 {
+		result.Latency = 100 * time.Millisecond
+	}
+	return result
+}
+
+func TestResultHookInput(t *testing.T) {
+	setFlags(t)
+	command, dir := writeHookScript(t, `echo "$NODE_NAME $VERDICT $DOWNLOAD_MBPS" > "env-$NODE_NAME"; cat > "stdin-$NODE_NAME"`)
+	hook := newResultHook(command, 5*time.Second, 2, false)
+	hook.run(hookResult("fast", 2.5))
+	hook.run(hookResult("slow", 0.5))
+	hook.run(hookResult("dead", 0))
+	hook.wait()
+
+	for name, want := range map[string]string{"fast": "fast good 2.50", "slow": "slow usable 0.50", "dead": "dead unusable 0.00"} {
+		env, err := os.ReadFile(filepath.Join(dir, "env-"+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(env)); got != want {
+			t.Errorf("env %q, want %q", got, want)
+		}
+		stdin, _ := os.ReadFile(filepath.Join(dir, "stdin-"+name))
+		if !strings.Contains(string(stdin), `"name":"`+name+`"`) {
+			t.Errorf("%s: stdin is not the result json: %s", name, stdin)
+		}
+	}
+}
+
+func TestResultHookConcurrency(t *testing.T) {
+	setFlags(t)
+	command, dir := writeHookScript(t, "echo start >> log\nsleep 0.2\necho end >> log\n")
+	hook := newResultHook(command, 5*time.Second, 2, false)
+	for i := range 5 {
+		hook.run(hookResult(string(rune('a'+i)), 1))
+	}
+	hook.wait()
+
+	data, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, peak, starts := 0, 0, 0
+	for _, line := range strings.Fields(string(data)) {
+		if line == "start" {
+			running++
+			starts++
+			peak = max(peak, running)
+		} else {
+			running--
+		}
+	}
+	if starts != 5 || peak > 2 {
+		t.Errorf("%d runs, %d at once with concurrency 2", starts, peak)
+	}
+}
+
+func TestResultHookVeto(t *testing.T) {
+	setFlags(t)
+	command, _ := writeHookScript(t, `echo "rejecting $NODE_NAME" >&2; [ "$NODE_NAME" != bad ] && [ "$NODE_NAME" != pinned ]`)
+	results := []*speedtester.Result{hookResult("good", 1), hookResult("bad", 1), hookResult("pinned", 1)}
+
+	hook := newResultHook(command, 5*time.Second, 1, false)
+	for _, result := range results {
+		hook.run(result)
+	}
+	hook.wait()
+	if got := hook.filter(results, func(*speedtester.Result) bool { return false }); len(got) != 3 {
+		t.Errorf("without -exec-veto %d nodes kept, want 3", len(got))
+	}
+
+	hook = newResultHook(command, 5*time.Second, 1, true)
+	for _, result := range results {
+		hook.run(result)
+	}
+	hook.wait()
+	kept := hook.filter(results, func(result *speedtester.Result) bool { return result.ProxyName == "pinned" })
+	if len(kept) != 2 || kept[0].ProxyName != "good" || kept[1].ProxyName != "pinned" {
+		t.Errorf("kept %d nodes, want good and pinned", len(kept))
+	}
+}
+
+func TestResultHookTimeout(t *testing.T) {
+	setFlags(t)
+	// 命令里的子进程继承了 stderr，超时后不能一直等它退出
+	command, _ := writeHookScript(t, "sleep 5\ntrue\n")
+	hook := newResultHook(command, 200*time.Millisecond, 1, true)
+	result := hookResult("slow", 1)
+	start := time.Now()
+	hook.run(result)
+	hook.wait()
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("hook took %s with a 200ms timeout", elapsed)
+	}
+	if reason := hook.vetoed[result]; !strings.Contains(reason, "timed out") {
+		t.Errorf("veto reason %q, want a timeout", reason)
+	}
+}
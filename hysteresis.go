@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// hysteresis 让处在阈值附近的节点不会每次运行都在输出文件里进进出出：
+// 已经在上次输出里的节点只有明显不达标或者连续失败 dropAfter 次才会被移除，
+// 新节点需要超出阈值 margin 才会被加入
+type hysteresis struct {
+	margin    float64
+	dropAfter int
+	// previous 是上次输出文件中的节点，按 NodeKey 索引
+	previous map[string]bool
+	// streaks 是节点连续不可用的次数，保存在历史文件里
+	streaks map[string]int
+	// held 是本次不达标但因为滞后被保留的节点
+	held map[string]bool
+}
+
+func newHysteresis(margin float64, dropAfter int, streaks map[string]int, outputs ...string) *hysteresis {
+	h := &hysteresis{
+		margin:    margin,
+		dropAfter: dropAfter,
+		previous:  make(map[string]bool),
+		streaks:   streaks,
+		held:      make(map[string]bool),
+	}
+	if h.streaks == nil {
+		h.streaks = make(map[string]int)
+	}
+	for _, output := range outputs {
+		if output == "" {
+			continue
+		}
+		// 中途保存已经改写过的文件按运行前的内容计算
+		proxies, ok := outputsBeforeRun[artifactPath(output)]
+		if !ok {
+			proxies, _ = loadPreviousProxies(output)
+		}
+		for _, proxy := range proxies {
+			h.previous[speedtester.NodeKey(proxy)] = true
+		}
+	}
+	return h
+}
+
+// reconcile 根据测试结论和上次的输出决定本次输出哪些节点，results 是按测试结论筛选出的节点
+func (h *hysteresis) reconcile(allResults, results []*speedtester.Result) []*speedtester.Result {
+	selected := make(map[*speedtester.Result]bool, len(results))
+	for _, result := range results {
+		selected[result] = true
+	}
+
+	reconciled := make([]*speedtester.Result, 0, len(results))
+	for _, result := range allResults {
+		key := speedtester.NodeKey(result.ProxyConfig)
+		if isProxyUsable(result) {
+			delete(h.streaks, key)
+		} else {
+			h.streaks[key]++
+		}
+
+		switch {
+		case !h.previous[key]:
+			// 新节点需要明显达标，固定的节点不受影响
+			if selected[result] && (!isProxyUsable(result) || h.clearsMargin(result)) {
+				reconciled = append(reconciled, result)
+			}
+		case selected[result]:
+			reconciled = append(reconciled, result)
+		case h.holds(result, key):
+			h.held[key] = true
+			reconciled = append(reconciled, result)
+		}
+	}
+	return reconciled
+}
+
+// holds 判断上次输出里、本次不达标的节点是否保留：配置了 margin 时必须只是略微不达标，
+// 配置了 dropAfter 时连续失败的次数必须少于 dropAfter，两个条件都配置时都要满足
+func (h *hysteresis) holds(result *speedtester.Result, key string) bool {
+	if h.margin > 0 && !h.withinMargin(result) {
+		return false
+	}
+	if h.dropAfter > 1 && h.streaks[key] >= h.dropAfter {
+		return false
+	}
+	return h.margin > 0 || h.dropAfter > 1
+}
+
+// withinMargin 判断不可用的节点是否只是在延迟或速度上略微不达标
+func (h *hysteresis) withinMargin(result *speedtester.Result) bool {
+	if h.margin <= 0 {
+		return false
+	}
+	reason := unusableReason(result)
+	if !strings.HasPrefix(reason, "latency ") && !strings.HasPrefix(reason, "download speed ") {
+		return false
+	}
+	t := thresholdsFor(result)
+	if t.maxLatency != 0 && result.Latency > time.Duration(float64(t.maxLatency)*(1+h.margin)) {
+		return false
+	}
+	return result.DownloadSpeed >= t.minSpeed*1024*1024*(1-h.margin)
+}
+
+// clearsMargin 判断可用的节点是否超出阈值 margin
+func (h *hysteresis) clearsMargin(result *speedtester.Result) bool {
+	if h.margin <= 0 {
+		return true
+	}
+	t := thresholdsFor(result)
+	if t.maxLatency != 0 && result.Latency > time.Duration(float64(t.maxLatency)*(1-h.margin)) {
+		return false
+	}
+	return result.DownloadSpeed >= t.minSpeed*1024*1024*(1+h.margin)
+}
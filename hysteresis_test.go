@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// simulateCycles 模拟多次运行：节点一开始就在输出文件里，speeds 是每次测到的下载速度（MB/s），
+// 0 表示不可达。每次运行的输出写回文件作为下一次的 previous，返回每次运行后节点是否在输出里
+func simulateCycles(t *testing.T, margin float64, dropAfter int, speeds []float64) []bool {
+	t.Helper()
+	config := map[string]any{"name": "A", "type": "ss", "server": "a.example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+	output := filepath.Join(t.TempDir(), "useable.yaml")
+	writeOutput := func(proxies []map[string]any) {
+		data, err := marshalProxies(proxies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(output, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeOutput([]map[string]any{config})
+
+	streaks := map[string]int{}
+	var present []bool
+	for _, speed := range speeds {
+		result := &speedtester.Result{ProxyName: "A", ProxyConfig: config, DownloadSpeed: speed * 1024 * 1024, ExtraURLConnectivity: true}
+		if speed > 0 {
+			result.Latency = 100 * time.Millisecond
+		}
+		var selected []*speedtester.Result
+		if isProxyUsable(result) {
+			selected = append(selected, result)
+		}
+		h := newHysteresis(margin, dropAfter, streaks, output)
+		reconciled := h.reconcile([]*speedtester.Result{result}, selected)
+		streaks = h.streaks
+
+		var proxies []map[string]any
+		for _, r := range reconciled {
+			proxies = append(proxies, r.ProxyConfig)
+		}
+		writeOutput(proxies)
+		present = append(present, len(reconciled) == 1)
+	}
+	return present
+}
+
+func TestHysteresisMargin(t *testing.T) {
+	setFlags(t, "min-speed", "10")
+	// 阈值 10MB/s，margin 0.2：已有节点低于 8 才移除，新节点高于 12 才加入
+	speeds := []float64{12, 9, 8.5, 7, 9, 11, 11.9, 12.5, 9.5}
+	want := []bool{true, true, true, false, false, false, false, true, true}
+	if got := simulateCycles(t, 0.2, 1, speeds); !slices.Equal(got, want) {
+		t.Errorf("speeds %v: present %v, want %v", speeds, got, want)
+	}
+}
+
+func TestHysteresisDropAfter(t *testing.T) {
+	setFlags(t, "min-speed", "10")
+	// 连续失败 2 次才移除，中间成功一次会重新计数，margin 为 0 时恢复后立即加回
+	speeds := []float64{0, 20, 5, 20, 0, 5, 0, 20}
+	want := []bool{true, true, true, true, true, false, false, true}
+	if got := simulateCycles(t, 0, 2, speeds); !slices.Equal(got, want) {
+		t.Errorf("speeds %v: present %v, want %v", speeds, got, want)
+	}
+}
+
+func TestHysteresisMarginAndDropAfter(t *testing.T) {
+	setFlags(t, "min-speed", "10")
+	// 一直只差一点也只保留 drop-after 次，明显不达标的节点立即移除
+	speeds := []float64{12, 9, 9, 9, 12.5, 7}
+	want := []bool{true, true, false, false, true, false}
+	if got := simulateCycles(t, 0.2, 2, speeds); !slices.Equal(got, want) {
+		t.Errorf("speeds %v: present %v, want %v", speeds, got, want)
+	}
+}
+
+func TestHysteresisMarginDoesNotHoldUnreachable(t *testing.T) {
+	setFlags(t, "min-speed", "10")
+	// 不可达不是略微不达标，margin 再大也不保留
+	if got := simulateCycles(t, 0.5, 1, []float64{0}); got[0] {
+		t.Error("unreachable node held by -hysteresis-margin")
+	}
+}
+
+func TestHysteresisLatencyMargin(t *testing.T) {
+	setFlags(t, "max-latency", "80ms")
+	config := map[string]any{"name": "B", "type": "ss", "server": "b.example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+	result := &speedtester.Result{ProxyConfig: config, Latency: 90 * time.Millisecond, DownloadSpeed: 20 * 1024 * 1024, ExtraURLConnectivity: true}
+	h := newHysteresis(0.2, 1, nil)
+	h.previous[speedtester.NodeKey(config)] = true
+	if got := h.reconcile([]*speedtester.Result{result}, nil); len(got) != 1 || !h.held[speedtester.NodeKey(config)] {
+		t.Errorf("node 90ms over an 80ms limit with margin 0.2 not held: %v", got)
+	}
+
+	result.Latency = 100 * time.Millisecond
+	h = newHysteresis(0.2, 1, nil)
+	h.previous[speedtester.NodeKey(config)] = true
+	if got := h.reconcile([]*speedtester.Result{result}, nil); len(got) != 0 {
+		t.Error("node 100ms over an 80ms limit with margin 0.2 held")
+	}
+}
+
+func TestHysteresisSourceThresholds(t *testing.T) {
+	setFlags(t, "min-speed", "1")
+	strict := 10.0
+	sourceOverrides = map[string]*sourceEntry{"strict": {MinSpeed: &strict}}
+	t.Cleanup(func() { sourceOverrides = nil })
+
+	// 来源的阈值是 10MB/s，9MB/s 在 margin 0.2 以内，按命令行的 1MB/s 算则根本不会不可用
+	config := map[string]any{"name": "C", "type": "ss", "server": "c.example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+	result := &speedtester.Result{Source: "strict", ProxyConfig: config, Latency: 50 * time.Millisecond, DownloadSpeed: 9 * 1024 * 1024, ExtraURLConnectivity: true}
+	h := newHysteresis(0.2, 1, nil)
+	h.previous[speedtester.NodeKey(config)] = true
+	if got := h.reconcile([]*speedtester.Result{result}, nil); len(got) != 1 {
+		t.Error("node within the source's margin not held")
+	}
+
+	// 新节点要超过来源阈值的 1.2 倍才加入
+	result.DownloadSpeed = 11 * 1024 * 1024
+	h = newHysteresis(0.2, 1, nil)
+	if got := h.reconcile([]*speedtester.Result{result}, []*speedtester.Result{result}); len(got) != 0 {
+		t.Error("new node below the source's margin added")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// cachedResult 是节点最近一次实际测试的结果，ConfigHash 用来判断节点配置是否被修改过，不包括 -volatile-fields
+type cachedResult struct {
+	ConfigHash string              `json:"config_hash"`
+	Result     *speedtester.Result `json:"result"`
+}
+
+// UnmarshalJSON 兼容旧版本写出的缓存：Result.ExtraURLConnectivity 的 json tag 曾经缺少引号，
+// 字段按 Go 的字段名写出，新的 tag 读不到它
+func (c *cachedResult) UnmarshalJSON(data []byte) error {
+	type plain cachedResult
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	var legacy struct {
+		Result struct {
+			ExtraURLConnectivity *bool
+		} `json:"result"`
+	}
+	if c.Result != nil && json.Unmarshal(data, &legacy) == nil && legacy.Result.ExtraURLConnectivity != nil {
+		c.Result.ExtraURLConnectivity = *legacy.Result.ExtraURLConnectivity
+	}
+	return nil
+}
+
+// reuseResults 从 proxies 中取出配置没有变化、结果未超过 maxAge 的节点并返回它们上次的结果，
+// 留在 proxies 里的是新增、修改过或结果过期需要重新测试的节点。名称按本次的 -name-prefix 重新生成，
+// 哈希按订阅里的原名计算，修改 -name-prefix 或者从没有这个参数的版本升级后缓存仍然有效
+func (h *historyFile) reuseResults(st *speedtester.SpeedTester, proxies map[string]*speedtester.CProxy, now time.Time, maxAge time.Duration) []*speedtester.Result {
+	var reused []*speedtester.Result
+	for name, proxy := range proxies {
+		cached := h.Latest[speedtester.NodeKey(proxy.Config)]
+		if cached == nil || cached.Result == nil || cached.ConfigHash != speedtester.ConfigHash(volatileFields.Strip(proxy.Config)) {
+			continue
+		}
+		if maxAge > 0 && now.Sub(cached.Result.TestedAt) > maxAge {
+			continue
+		}
+		result := *cached.Result
+		result.SourceName = name
+		result.ProxyName, result.ProxyConfig = st.NameProxy(name, proxy)
+		result.Source = proxy.Source
+		reused = append(reused, &result)
+		delete(proxies, name)
+	}
+	return reused
+}
+
+// updateLatest 用本次的结果更新 Latest，并清理超出保留时间的节点
+func (h *historyFile) updateLatest(now time.Time, results []*speedtester.Result, retention time.Duration) {
+	if h.Latest == nil {
+		h.Latest = make(map[string]*cachedResult, len(results))
+	}
+	for _, result := range results {
+		h.Latest[speedtester.NodeKey(result.ProxyConfig)] = &cachedResult{
+			ConfigHash: speedtester.ConfigHash(volatileFields.Strip(result.SourceConfig())),
+			Result:     result,
+		}
+	}
+	if retention <= 0 {
+		return
+	}
+	for key, cached := range h.Latest {
+		if cached.Result == nil || now.Sub(cached.Result.TestedAt) > retention {
+			delete(h.Latest, key)
+		}
+	}
+}
+
+// formatResultAge 把结果的测试时间格式化成 "刚刚"、"35m前"、"3h20m前"
+func formatResultAge(now, testedAt time.Time) string {
+	if testedAt.IsZero() {
+		return "N/A"
+	}
+	age := now.Sub(testedAt)
+	if age < time.Minute {
+		return "刚刚"
+	}
+	age = age.Round(time.Minute)
+	if age < time.Hour {
+		return fmt.Sprintf("%dm前", int(age.Minutes()))
+	}
+	return fmt.Sprintf("%dh%dm前", int(age.Hours()), int(age.Minutes())%60)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// cacheProxy 返回一个没有前缀的节点，以及它 age 之前测出的结果
+func cacheProxy(name string, age time.Duration, now time.Time) (*speedtester.CProxy, *speedtester.Result) {
+	config := map[string]any{"name": name, "type": "ss", "server": strings.ToLower(name) + ".example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+	proxy := &speedtester.CProxy{Config: config, Source: "sub.yaml"}
+	result := &speedtester.Result{ProxyName: name, SourceName: name, ProxyConfig: maps.Clone(config), DownloadSpeed: 1, TestedAt: now.Add(-age)}
+	return proxy, result
+}
+
+// -only-changed 只复用配置没变、没有过期的节点，其余留在 proxies 里重新测试
+func TestReuseResults(t *testing.T) {
+	setFlags(t)
+	now := time.Now()
+	st := speedtester.New(&speedtester.Config{NamePrefix: speedtester.NamePrefixNone})
+	same, sameResult := cacheProxy("Same", time.Hour, now)
+	changed, changedResult := cacheProxy("Changed", time.Hour, now)
+	old, oldResult := cacheProxy("Old", 3*time.Hour, now)
+	added, _ := cacheProxy("Added", 0, now)
+
+	h := &historyFile{}
+	h.updateLatest(now, []*speedtester.Result{sameResult, changedResult, oldResult}, 0)
+	// 插件参数变了，NodeKey 不变但配置哈希变了
+	changed.Config["plugin"] = "obfs"
+	proxies := map[string]*speedtester.CProxy{"Same": same, "Changed": changed, "Old": old, "Added": added}
+	reused := h.reuseResults(st, proxies, now, 2*time.Hour)
+	if len(reused) != 1 || reused[0].ProxyName != "Same" || reused[0].Source != "sub.yaml" || reused[0].DownloadSpeed != 1 {
+		t.Fatalf("reused %+v", reused)
+	}
+	if got := slices.Sorted(maps.Keys(proxies)); !slices.Equal(got, []string{"Added", "Changed", "Old"}) {
+		t.Errorf("left to test %v", got)
+	}
+	// 复用的是副本，缓存里的结果不变
+	reused[0].DownloadSpeed = 2
+	if sameResult.DownloadSpeed != 1 {
+		t.Error("reused result shares the cached result")
+	}
+
+	// maxAge 为 0 时不过期
+	proxies = map[string]*speedtester.CProxy{"Old": old}
+	if reused := h.reuseResults(st, proxies, now, 0); len(reused) != 1 || len(proxies) != 0 {
+		t.Errorf("old result without max age: %d reused, %d left", len(reused), len(proxies))
+	}
+}
+
+// 只在 -volatile-fields 上不同的节点仍然复用缓存
+func TestReuseResultsVolatile(t *testing.T) {
+	setFlags(t)
+	useVolatileFields(t, "default")
+	now := time.Now()
+	st := speedtester.New(&speedtester.Config{NamePrefix: speedtester.NamePrefixNone})
+	proxy, result := cacheProxy("HK 2025-01-02", time.Hour, now)
+	h := &historyFile{}
+	h.updateLatest(now, []*speedtester.Result{result}, 0)
+	proxy.Config["name"] = "HK 2025-01-03"
+	if reused := h.reuseResults(st, map[string]*speedtester.CProxy{"HK 2025-01-03": proxy}, now, 0); len(reused) != 1 || reused[0].ProxyName != "HK 2025-01-03" {
+		t.Errorf("reused %+v", reused)
+	}
+}
+
+func TestUpdateLatestRetention(t *testing.T) {
+	setFlags(t)
+	now := time.Now()
+	_, fresh := cacheProxy("Fresh", time.Hour, now)
+	_, stale := cacheProxy("Stale", 48*time.Hour, now)
+	h := &historyFile{}
+	h.updateLatest(now, []*speedtester.Result{fresh, stale}, 0)
+	if len(h.Latest) != 2 {
+		t.Fatalf("%d cached without retention", len(h.Latest))
+	}
+	h.updateLatest(now, nil, 24*time.Hour)
+	if len(h.Latest) != 1 || h.Latest[speedtester.NodeKey(fresh.ProxyConfig)] == nil {
+		t.Errorf("cached after retention: %v", slices.Collect(maps.Keys(h.Latest)))
+	}
+}
+
+func TestFormatResultAge(t *testing.T) {
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		testedAt time.Time
+		want     string
+	}{
+		{time.Time{}, "N/A"},
+		{now.Add(-30 * time.Second), "刚刚"},
+		{now.Add(-35 * time.Minute), "35m前"},
+		{now.Add(-(3*time.Hour + 20*time.Minute + 20*time.Second)), "3h20m前"},
+		{now.Add(-(59*time.Minute + 40*time.Second)), "1h0m前"},
+	}
+	for _, tt := range tests {
+		if got := formatResultAge(now, tt.testedAt); got != tt.want {
+			t.Errorf("formatResultAge(%s) = %q, want %q", now.Sub(tt.testedAt), got, tt.want)
+		}
+	}
+}
+
+func TestResultExtraURLConnectivityTag(t *testing.T) {
+	data, err := json.Marshal(&speedtester.Result{ExtraURLConnectivity: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"extra_url_connectivity":true`) {
+		t.Fatalf("extra_url_connectivity missing from %s", data)
+	}
+}
+
+func TestCachedResultLegacyExtraURLConnectivity(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		json string
+		want bool
+	}{
+		{"legacy key", `{"config_hash":"h","result":{"ExtraURLConnectivity":true}}`, true},
+		{"new key", `{"config_hash":"h","result":{"extra_url_connectivity":true}}`, true},
+		{"missing", `{"config_hash":"h","result":{}}`, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cached cachedResult
+			if err := json.Unmarshal([]byte(tc.json), &cached); err != nil {
+				t.Fatal(err)
+			}
+			if cached.ConfigHash != "h" || cached.Result == nil {
+				t.Fatalf("unexpected %+v", cached)
+			}
+			if cached.Result.ExtraURLConnectivity != tc.want {
+				t.Fatalf("ExtraURLConnectivity = %v, want %v", cached.Result.ExtraURLConnectivity, tc.want)
+			}
+		})
+	}
+}
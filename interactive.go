@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+const selectHelp = `commands:
+  drop 3,7-9      exclude nodes by the numbers in the table
+  keep 3,7-9      undo drop
+  good>=8MB/s     change the good node threshold (MB/s)
+  preview         show how many nodes would be saved
+  save            save the selected nodes
+  quit            exit without saving`
+
+// selectCommand 是 -interactive-save 提示符下的一条命令
+type selectCommand struct {
+	op        string
+	indices   []int
+	goodSpeed float64
+}
+
+// parseSelectCommand 解析一行输入，空行按 preview 处理
+func parseSelectCommand(line string, max int) (*selectCommand, error) {
+	line = strings.TrimSpace(line)
+	if rest, ok := strings.CutPrefix(line, "good>="); ok {
+		rest = strings.TrimSuffix(strings.TrimSpace(rest), "MB/s")
+		speed, err := strconv.ParseFloat(strings.TrimSpace(rest), 64)
+		if err != nil || speed < 0 {
+			return nil, fmt.Errorf("invalid speed %q", rest)
+		}
+		return &selectCommand{op: "good", goodSpeed: speed}, nil
+	}
+	op, args, _ := strings.Cut(line, " ")
+	switch op {
+	case "", "preview", "p":
+		return &selectCommand{op: "preview"}, nil
+	case "save", "y", "quit", "q", "help", "h", "?":
+		return &selectCommand{op: op[:1]}, nil
+	case "drop", "keep":
+		indices, err := parseIndexRanges(args, max)
+		if err != nil {
+			return nil, err
+		}
+		return &selectCommand{op: op, indices: indices}, nil
+	}
+	return nil, fmt.Errorf("unknown command %q, type help for the list", op)
+}
+
+// parseIndexRanges 解析 "3,7-9" 这样的序号列表，序号从 1 开始，不能超过 max
+func parseIndexRanges(spec string, max int) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err != nil || first < 1 || last > max || first > last {
+			return nil, fmt.Errorf("invalid range %q, nodes are numbered 1-%d", part, max)
+		}
+		for i := first; i <= last; i++ {
+			seen[i] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("no node numbers given")
+	}
+	indices := make([]int, 0, len(seen))
+	for i := range seen {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices, nil
+}
+
+// selection 是保存前的挑选状态，序号与结果表格一致
+type selection struct {
+	results   []*speedtester.Result
+	dropped   map[int]bool
+	goodSpeed float64
+}
+
+func newSelection(results []*speedtester.Result, goodSpeed float64) *selection {
+	return &selection{results: results, dropped: make(map[int]bool), goodSpeed: goodSpeed}
+}
+
+func (s *selection) apply(cmd *selectCommand) {
+	switch cmd.op {
+	case "drop":
+		for _, i := range cmd.indices {
+			s.dropped[i] = true
+		}
+	case "keep":
+		for _, i := range cmd.indices {
+			delete(s.dropped, i)
+		}
+	case "good":
+		s.goodSpeed = cmd.goodSpeed
+	}
+}
+
+// selected 返回没有被排除的节点，保持表格中的顺序
+func (s *selection) selected() []*speedtester.Result {
+	kept := make([]*speedtester.Result, 0, len(s.results)-len(s.dropped))
+	for i, result := range s.results {
+		if !s.dropped[i+1] {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+func (s *selection) summary(isGood func(*speedtester.Result) bool) string {
+	kept := s.selected()
+	good := 0
+	for _, result := range kept {
+		if isGood(result) {
+			good++
+		}
+	}
+	return fmt.Sprintf("%d nodes selected, %d good (>= %gMB/s), %d dropped", len(kept), good, s.goodSpeed, len(s.dropped))
+}
+
+// interactiveSelect 在保存前读取用户的命令挑选节点，返回要保存的节点，用户放弃或输入结束时返回 false。
+// good>= 会直接修改 -good-download-speed-threshold
+func interactiveSelect(in io.Reader, out io.Writer, results []*speedtester.Result) ([]*speedtester.Result, bool) {
+	s := newSelection(results, *goodDownloadSpeedThreshold)
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintln(out, selectHelp)
+	fmt.Fprintln(out, s.summary(isProxyGood))
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return nil, false
+		}
+		cmd, err := parseSelectCommand(scanner.Text(), len(results))
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		switch cmd.op {
+		case "s", "y":
+			return s.selected(), true
+		case "q":
+			return nil, false
+		case "h", "?":
+			fmt.Fprintln(out, selectHelp)
+			continue
+		}
+		s.apply(cmd)
+		*goodDownloadSpeedThreshold = s.goodSpeed
+		fmt.Fprintln(out, s.summary(isProxyGood))
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestParseIndexRanges(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int
+		err  string
+	}{
+		{"3", []int{3}, ""},
+		{"3,7-9", []int{3, 7, 8, 9}, ""},
+		{" 9 , 2 - 3,3,", []int{2, 3, 9}, ""},
+		{"10-10", []int{10}, ""},
+		{"", nil, "no node numbers given"},
+		{",,", nil, "no node numbers given"},
+		{"0", nil, `invalid range "0", nodes are numbered 1-10`},
+		{"11", nil, `invalid range "11"`},
+		{"9-11", nil, `invalid range "9-11"`},
+		{"5-3", nil, `invalid range "5-3"`},
+		{"-3", nil, `invalid range "-3"`},
+		{"a-b", nil, `invalid range "a-b"`},
+	}
+	for _, tt := range tests {
+		got, err := parseIndexRanges(tt.spec, 10)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseIndexRanges(%q) error %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parseIndexRanges(%q) = %v, %v, want %v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSelectCommand(t *testing.T) {
+	tests := []struct {
+		line    string
+		op      string
+		indices []int
+		speed   float64
+		err     string
+	}{
+		{"", "preview", nil, 0, ""},
+		{"  preview ", "preview", nil, 0, ""},
+		{"p", "preview", nil, 0, ""},
+		{"save", "s", nil, 0, ""},
+		{"y", "y", nil, 0, ""},
+		{"quit", "q", nil, 0, ""},
+		{"?", "?", nil, 0, ""},
+		{"drop 3,7-9", "drop", []int{3, 7, 8, 9}, 0, ""},
+		{"keep 1", "keep", []int{1}, 0, ""},
+		{"drop", "", nil, 0, "no node numbers given"},
+		{"drop 42", "", nil, 0, `invalid range "42"`},
+		{"good>=8MB/s", "good", nil, 8, ""},
+		{"good>= 2.5 MB/s", "good", nil, 2.5, ""},
+		{"good>=0", "good", nil, 0, ""},
+		{"good>=-1", "", nil, 0, "invalid speed"},
+		{"good>=fast", "", nil, 0, `invalid speed "fast"`},
+		{"delete 3", "", nil, 0, `unknown command "delete"`},
+	}
+	for _, tt := range tests {
+		cmd, err := parseSelectCommand(tt.line, 10)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseSelectCommand(%q) error %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSelectCommand(%q): %v", tt.line, err)
+			continue
+		}
+		if cmd.op != tt.op || !slices.Equal(cmd.indices, tt.indices) || cmd.goodSpeed != tt.speed {
+			t.Errorf("parseSelectCommand(%q) = %+v", tt.line, cmd)
+		}
+	}
+}
+
+func selectionTestResults() []*speedtester.Result {
+	var results []*speedtester.Result
+	for i, speed := range []float64{20, 5, 12, 9} {
+		results = append(results, &speedtester.Result{
+			ProxyName:            string(rune('A' + i)),
+			Latency:              100 * time.Millisecond,
+			DownloadSpeed:        speed * 1024 * 1024,
+			ExtraURLConnectivity: true,
+		})
+	}
+	return results
+}
+
+func TestSelection(t *testing.T) {
+	setFlags(t, "min-speed", "1", "good-download-speed-threshold", "10")
+	s := newSelection(selectionTestResults(), 10)
+	names := func() string {
+		var names []string
+		for _, result := range s.selected() {
+			names = append(names, result.ProxyName)
+		}
+		return strings.Join(names, "")
+	}
+
+	s.apply(&selectCommand{op: "drop", indices: []int{2, 4}})
+	s.apply(&selectCommand{op: "drop", indices: []int{2}})
+	if got := names(); got != "AC" {
+		t.Errorf("after drop 2,4: %s", got)
+	}
+	if got := s.summary(isProxyGood); got != "2 nodes selected, 2 good (>= 10MB/s), 2 dropped" {
+		t.Errorf("summary = %q", got)
+	}
+	s.apply(&selectCommand{op: "keep", indices: []int{4, 3}})
+	if got := names(); got != "ACD" {
+		t.Errorf("after keep 4: %s", got)
+	}
+	s.apply(&selectCommand{op: "good", goodSpeed: 15})
+	if s.goodSpeed != 15 || len(s.dropped) != 1 {
+		t.Errorf("good changed the drops: %+v", s)
+	}
+	// 预览不改变状态
+	s.apply(&selectCommand{op: "preview"})
+	if got := names(); got != "ACD" {
+		t.Errorf("after preview: %s", got)
+	}
+}
+
+func TestInteractiveSelect(t *testing.T) {
+	setFlags(t, "min-speed", "1", "good-download-speed-threshold", "10")
+	in := strings.NewReader("drop 2-3\nbogus\ngood>=8\n\nsave\ndrop 1\n")
+	var out bytes.Buffer
+	selected, ok := interactiveSelect(in, &out, selectionTestResults())
+	if !ok || len(selected) != 2 || selected[0].ProxyName != "A" || selected[1].ProxyName != "D" {
+		t.Fatalf("selected %v, %v", selected, ok)
+	}
+	if *goodDownloadSpeedThreshold != 8 {
+		t.Errorf("-good-download-speed-threshold = %v after good>=8", *goodDownloadSpeedThreshold)
+	}
+	for _, want := range []string{
+		"4 nodes selected, 2 good (>= 10MB/s), 0 dropped",
+		"2 nodes selected, 1 good (>= 10MB/s), 2 dropped",
+		`unknown command "bogus"`,
+		"2 nodes selected, 2 good (>= 8MB/s), 2 dropped",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	for _, input := range []string{"drop 1\nquit\n", "drop 1\n"} {
+		if selected, ok := interactiveSelect(strings.NewReader(input), &bytes.Buffer{}, selectionTestResults()); ok || selected != nil {
+			t.Errorf("input %q: selected %v, %v", input, selected, ok)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+)
+
+// 任务状态
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobCanceled = "canceled"
+	jobFailed   = "failed"
+)
+
+// maxFinishedJobs 是内存里最多保留的已结束任务数，超过时丢弃最早的
+const maxFinishedJobs = 100
+
+// maxPendingJobs 是排队和运行中的任务总数上限，超过时拒绝新任务
+const maxPendingJobs = 20
+
+// jobRequest 是 POST /test 的请求体，阈值不填时使用命令行参数，速度的单位是 MB/s。
+// Config 只能是 http(s) 订阅地址，多个用逗号分隔，不能读取服务器上的本地文件
+type jobRequest struct {
+	Config         string   `json:"config"`
+	Filter         string   `json:"filter"`
+	MaxLatency     string   `json:"max_latency"`
+	MinSpeed       *float64 `json:"min_speed"`
+	MinUploadSpeed *float64 `json:"min_upload_speed"`
+}
+
+// apiJob 是 -listen 任务模式下的一次测试
+type apiJob struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Tested    int       `json:"tested"`
+	Total     int       `json:"total"`
+	Usable    int       `json:"usable"`
+	// Results 是已经测完的所有节点，包括不可用的
+	Results []*speedtester.Result `json:"results"`
+
+	config speedtester.Config
+	// overrides 是请求里填了的阈值，没填的项和命令行一样由 thresholdsFor 决定
+	overrides jobThresholds
+	usable    []*speedtester.Result
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// jobServer 通过 HTTP 接收测试任务，同时运行的任务数不超过 -max-jobs，多出的排队等待
+type jobServer struct {
+	token  string
+	base   speedtester.Config
+	slots  chan struct{}
+	mu     sync.Mutex
+	jobs   map[string]*apiJob
+	finish []string
+	// pending 是排队和运行中的任务数
+	pending int
+}
+
+func newJobServer(base speedtester.Config, token string, maxJobs int) *jobServer {
+	return &jobServer{
+		token: token,
+		base:  base,
+		slots: make(chan struct{}, max(maxJobs, 1)),
+		jobs:  make(map[string]*apiJob),
+	}
+}
+
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /test", s.handleCreate)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/config.yaml", s.handleConfig)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+	return mux
+}
+
+func (s *jobServer) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if !authorizeToken(w, r, s.token) {
+		return
+	}
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, err := s.newJob(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	if s.pending >= maxPendingJobs {
+		s.mu.Unlock()
+		job.cancel()
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, fmt.Sprintf("too many jobs, %d are queued or running", maxPendingJobs), http.StatusTooManyRequests)
+		return
+	}
+	s.pending++
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	go s.run(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": job.ID})
+}
+
+// jobThresholds 是任务请求覆盖的阈值，为空的项不覆盖
+type jobThresholds struct {
+	maxLatency     time.Duration
+	minSpeed       *float64
+	minUploadSpeed *float64
+}
+
+// thresholds 返回任务判定节点时的阈值，除了请求覆盖的项以外和命令行的判定完全相同
+func (job *apiJob) thresholds(result *speedtester.Result) thresholds {
+	t := thresholdsFor(result)
+	if job.overrides.maxLatency > 0 {
+		t.maxLatency = job.overrides.maxLatency
+	}
+	if job.overrides.minSpeed != nil {
+		t.minSpeed = *job.overrides.minSpeed
+	}
+	if job.overrides.minUploadSpeed != nil {
+		t.minUploadSpeed = *job.overrides.minUploadSpeed
+	}
+	return t
+}
+
+func (job *apiJob) usableResult(result *speedtester.Result) bool {
+	return unusableReasonWith(result, job.thresholds(result)) == ""
+}
+
+func (job *apiJob) goodResult(result *speedtester.Result) bool {
+	return isProxyGoodWith(result, job.thresholds(result))
+}
+
+// newJob 按请求覆盖命令行的配置和阈值，判定方式和命令行相同
+func (s *jobServer) newJob(req *jobRequest) (*apiJob, error) {
+	if req.Config == "" {
+		return nil, fmt.Errorf("config is required")
+	}
+	for _, source := range strings.Split(req.Config, ",") {
+		u, err := url.Parse(strings.TrimSpace(source))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("config must be http(s) subscription urls, got %q", source)
+		}
+	}
+	config := s.base
+	config.ConfigPaths = req.Config
+	config.FilterRegex = *filterRegexConfig
+	if req.Filter != "" {
+		if _, err := regexp.Compile(req.Filter); err != nil {
+			return nil, fmt.Errorf("invalid filter: %w", err)
+		}
+		config.FilterRegex = req.Filter
+	}
+
+	// 和命令行的 -min-speed、-min-upload-speed 一样不接受负数
+	for _, field := range []struct {
+		name  string
+		speed *float64
+	}{{"min_speed", req.MinSpeed}, {"min_upload_speed", req.MinUploadSpeed}} {
+		if v := field.speed; v != nil && (*v < 0 || math.IsNaN(*v) || math.IsInf(*v, 0)) {
+			return nil, fmt.Errorf("invalid %s %g, must be a non-negative number", field.name, *v)
+		}
+	}
+	overrides := jobThresholds{minSpeed: req.MinSpeed, minUploadSpeed: req.MinUploadSpeed}
+	if req.MaxLatency != "" {
+		d, err := time.ParseDuration(req.MaxLatency)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid max_latency %q", req.MaxLatency)
+		}
+		overrides.maxLatency = d
+		config.MaxLatency = d
+		// 请求的延迟阈值对这个任务的所有节点生效，不再按 -sources 的来源覆盖
+		config.SourceMaxLatency = nil
+	}
+	if req.MinSpeed != nil {
+		config.MinDownloadSpeed = *req.MinSpeed * 1024 * 1024
+		config.MinSpeed = *req.MinSpeed * 1024 * 1024
+		config.SourceMinSpeed = nil
+	}
+	if req.MinUploadSpeed != nil {
+		config.MinUploadSpeed = *req.MinUploadSpeed * 1024 * 1024
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	ctx, cancel := context.WithCancel(context.Background())
+	return &apiJob{
+		ID:        hex.EncodeToString(id),
+		Status:    jobQueued,
+		CreatedAt: time.Now(),
+		Results:   []*speedtester.Result{},
+		config:    config,
+		overrides: overrides,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}, nil
+}
+
+func (s *jobServer) run(job *apiJob) {
+	defer close(job.done)
+	defer job.cancel()
+	defer s.finished(job)
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-job.ctx.Done():
+		return
+	}
+	s.mu.Lock()
+	if job.Status != jobQueued {
+		// 拿到名额前已经被取消
+		s.mu.Unlock()
+		return
+	}
+	job.Status = jobRunning
+	s.mu.Unlock()
+
+	tester := speedtester.New(&job.config)
+	report, err := tester.LoadProxies(*stashCompatible)
+	if err == nil && report.SourceError != "" {
+		err = fmt.Errorf("%s", report.SourceError)
+	}
+	if err != nil {
+		s.mu.Lock()
+		job.Error = err.Error()
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	job.Total = len(report.Proxies)
+	s.mu.Unlock()
+
+	tester.TestProxies(job.ctx, report.Proxies, nil, func(result *speedtester.Result) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		job.Tested++
+		job.Results = append(job.Results, result)
+		if job.usableResult(result) {
+			job.usable = append(job.usable, result)
+			job.Usable++
+		}
+	})
+}
+
+// finished 记录任务的最终状态，并清理太早的已结束任务
+func (s *jobServer) finished(job *apiJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case job.Error != "":
+		job.Status = jobFailed
+	case job.Status == jobCanceled:
+	default:
+		job.Status = jobDone
+	}
+	log.Infoln("job %s %s: %d/%d tested, %d usable", job.ID, job.Status, job.Tested, job.Total, job.Usable)
+	s.pending--
+	s.finish = append(s.finish, job.ID)
+	if len(s.finish) > maxFinishedJobs {
+		delete(s.jobs, s.finish[0])
+		s.finish = s.finish[1:]
+	}
+}
+
+func (s *jobServer) lookup(w http.ResponseWriter, r *http.Request) *apiJob {
+	if !authorizeToken(w, r, s.token) {
+		return nil
+	}
+	s.mu.Lock()
+	job := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if job == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+	}
+	return job
+}
+
+// handleStatus 返回任务进度和已经测完的节点结果
+func (s *jobServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+	s.mu.Lock()
+	data, err := json.Marshal(job)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// handleConfig 返回可用节点的配置，内容和 -output 写出的文件相同，任务没有结束时是目前为止的结果
+func (s *jobServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+	s.mu.Lock()
+	results := append([]*speedtester.Result(nil), job.usable...)
+	s.mu.Unlock()
+	if len(results) == 0 {
+		http.Error(w, "no usable nodes", http.StatusNotFound)
+		return
+	}
+	good := job.goodResult
+	sort.SliceStable(results, func(i, j int) bool {
+		if good(results[i]) != good(results[j]) {
+			return good(results[i])
+		}
+		if results[i].DownloadSpeed != results[j].DownloadSpeed {
+			return results[i].DownloadSpeed > results[j].DownloadSpeed
+		}
+		return speedtester.NodeKey(results[i].ProxyConfig) < speedtester.NodeKey(results[j].ProxyConfig)
+	})
+	data, err := marshalResults(results)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	w.Write(data)
+}
+
+// handleCancel 停止排队或运行中的任务，已经测完的结果保留
+func (s *jobServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+	s.mu.Lock()
+	if job.Status == jobQueued || job.Status == jobRunning {
+		job.Status = jobCanceled
+		job.cancel()
+	}
+	s.mu.Unlock()
+	<-job.done
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+const testJobToken = "secret"
+
+// jobAPI 启动任务接口，返回的函数带着 token 发请求
+func jobAPI(t *testing.T, maxJobs int) (*jobServer, func(method, path, body string) *http.Response) {
+	t.Helper()
+	s := newJobServer(speedtester.Config{Timeout: time.Second}, testJobToken, maxJobs)
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	return s, func(method, path, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testJobToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+}
+
+func createJob(t *testing.T, do func(method, path, body string) *http.Response, config string) (string, int) {
+	t.Helper()
+	body, _ := json.Marshal(jobRequest{Config: config})
+	resp := do(http.MethodPost, "/test", string(body))
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	return created.ID, resp.StatusCode
+}
+
+func TestJobAPIRequiresToken(t *testing.T) {
+	s := newJobServer(speedtester.Config{}, testJobToken, 1)
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/test", "application/json", strings.NewReader(`{"config":"https://example.com/sub"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d without token, want 401", resp.StatusCode)
+	}
+}
+
+func TestJobRejectsLocalConfig(t *testing.T) {
+	_, do := jobAPI(t, 1)
+	for _, config := range []string{"", "/etc/passwd", "config.yaml", "file:///etc/passwd", "ftp://example.com/sub", "https://example.com/sub,/etc/passwd", "http:///sub"} {
+		if _, status := createJob(t, do, config); status != http.StatusBadRequest {
+			t.Errorf("config %q: status %d, want 400", config, status)
+		}
+	}
+}
+
+func TestJobRejectsInvalidThresholds(t *testing.T) {
+	_, do := jobAPI(t, 1)
+	for _, body := range []string{
+		`{"config":"https://example.com/sub","min_speed":-1}`,
+		`{"config":"https://example.com/sub","min_upload_speed":-0.5}`,
+		`{"config":"https://example.com/sub","min_speed":1e999}`,
+	} {
+		if resp := do(http.MethodPost, "/test", body); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, resp.StatusCode)
+		}
+	}
+	s := newJobServer(speedtester.Config{}, testJobToken, 1)
+	for _, speed := range []float64{math.NaN(), math.Inf(1)} {
+		if _, err := s.newJob(&jobRequest{Config: "https://example.com/sub", MinSpeed: &speed}); err == nil {
+			t.Errorf("min_speed %g accepted", speed)
+		}
+	}
+}
+
+func TestJobQueueLimit(t *testing.T) {
+	s, do := jobAPI(t, 1)
+	// 占住唯一的名额，提交的任务都停在排队状态
+	s.slots <- struct{}{}
+	var ids []string
+	for i := range maxPendingJobs {
+		id, status := createJob(t, do, fmt.Sprintf("https://example.com/sub%d", i))
+		if status != http.StatusAccepted {
+			t.Fatalf("job %d: status %d, want 202", i, status)
+		}
+		ids = append(ids, id)
+	}
+	if _, status := createJob(t, do, "https://example.com/sub"); status != http.StatusTooManyRequests {
+		t.Fatalf("status %d with a full queue, want 429", status)
+	}
+
+	if resp := do(http.MethodDelete, "/jobs/"+ids[0], ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("cancel: status %d", resp.StatusCode)
+	}
+	if _, status := createJob(t, do, "https://example.com/sub"); status != http.StatusAccepted {
+		t.Fatalf("status %d after canceling a job, want 202", status)
+	}
+
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		job.cancel()
+	}
+	s.mu.Unlock()
+}
+
+func TestJobRun(t *testing.T) {
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "proxies: []\n")
+	}))
+	defer sub.Close()
+	_, do := jobAPI(t, 1)
+	id, status := createJob(t, do, sub.URL)
+	if status != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", status)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var job apiJob
+		resp := do(http.MethodGet, "/jobs/"+id, "")
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == jobDone || job.Status == jobFailed {
+			if job.Tested != job.Total {
+				t.Errorf("finished with %d/%d tested", job.Tested, job.Total)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp := do(http.MethodGet, "/jobs/"+id+"/config.yaml", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("config of a job without nodes: status %d, want 404", resp.StatusCode)
+	}
+	if resp := do(http.MethodGet, "/jobs/unknown", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestJobVerdictMatchesCLI(t *testing.T) {
+	setFlags(t, "max-latency", "800ms", "min-speed", "1", "max-new-conn-latency", "500ms")
+	s := newJobServer(speedtester.Config{}, testJobToken, 1)
+	minSpeed := 5.0
+	job, err := s.newJob(&jobRequest{Config: "https://example.com/sub", MaxLatency: "2s", MinSpeed: &minSpeed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.cancel()
+
+	node := func(modify func(r *speedtester.Result)) *speedtester.Result {
+		result := capResult("A", 10)
+		result.Latency = 1500 * time.Millisecond
+		result.LatencyNewConn = 200 * time.Millisecond
+		modify(result)
+		return result
+	}
+	tests := []struct {
+		name   string
+		result *speedtester.Result
+		usable bool
+	}{
+		// 请求的 max_latency 覆盖了命令行的 800ms
+		{"request max latency", node(func(r *speedtester.Result) {}), true},
+		{"request min speed", node(func(r *speedtester.Result) { r.DownloadSpeed = 3 * 1024 * 1024 }), false},
+		// 请求没有覆盖的阈值和命令行一样检查
+		{"new connection latency", node(func(r *speedtester.Result) { r.LatencyNewConn = time.Second }), false},
+		{"extra url", node(func(r *speedtester.Result) { r.ExtraURLConnectivity = false }), false},
+		{"invalid", node(func(r *speedtester.Result) { r.Invalid = "clock jump" }), false},
+	}
+	for _, tt := range tests {
+		if got := job.usableResult(tt.result); got != tt.usable {
+			t.Errorf("%s: usable %v, want %v", tt.name, got, tt.usable)
+		}
+	}
+	if !job.goodResult(node(func(r *speedtester.Result) {})) {
+		t.Error("10MB/s node not good with the default good threshold")
+	}
+}
+
+// 订阅里的 file provider 不能让任务读取服务器上的文件
+func TestJobIgnoresFileProviders(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret.yaml")
+	os.WriteFile(secret, []byte("proxies:\n  - {name: Secret, type: ss, server: 127.0.0.1, port: 1, cipher: aes-128-gcm, password: hunter2}\n"), 0o644)
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "proxies: []\nproxy-providers:\n  local: {type: file, path: %s}\n", secret)
+	}))
+	defer sub.Close()
+	_, do := jobAPI(t, 1)
+	id, status := createJob(t, do, sub.URL)
+	if status != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", status)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp := do(http.MethodGet, "/jobs/"+id, "")
+		body, _ := io.ReadAll(resp.Body)
+		if strings.Contains(string(body), "hunter2") || strings.Contains(string(body), "Secret") {
+			t.Fatalf("job exposed the local provider:\n%s", body)
+		}
+		var job apiJob
+		if err := json.Unmarshal(body, &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == jobDone || job.Status == jobFailed {
+			if job.Total != 0 {
+				t.Errorf("job loaded %d nodes from a local file provider", job.Total)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/mattn/go-runewidth"
+)
+
+// ansiPattern 匹配表格里用到的颜色转义序列
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// liveTable 是 -live 的逐行输出：每测完一个可用节点就输出一行，列和颜色与 printResults 相同。
+// 列宽在开始时就固定下来，过长的节点名称会被截断；输出不是终端时去掉颜色，避免转义序列写进文件
+type liveTable struct {
+	w          io.Writer
+	color      bool
+	showRegion bool
+	widths     []int
+	total      int
+}
+
+func newLiveTable(w io.Writer, color bool, total int, showRegion bool) *liveTable {
+	t := &liveTable{w: w, color: color, showRegion: showRegion, total: total}
+	headers := resultHeaders(showRegion)
+	minWidths := resultColMinWidths()
+	t.widths = make([]int, len(headers))
+	for i, header := range headers {
+		t.widths[i] = runewidth.StringWidth(header)
+		if i < len(minWidths) {
+			t.widths[i] = max(t.widths[i], minWidths[i])
+		}
+	}
+	// 序号列显示 "已测试/总数"，代替进度条
+	t.widths[0] = max(t.widths[0], len(fmt.Sprintf("%d/%d", total, total)))
+	fmt.Fprintln(w)
+	t.writeRow(headers)
+	return t
+}
+
+// add 输出一个节点的结果，tested 是包括这个节点在内已经测完的节点数
+func (t *liveTable) add(result *speedtester.Result, tested int) {
+	row := resultRow(result, fmt.Sprintf("%d/%d", tested, t.total), t.showRegion, time.Now())
+	// 名称列固定宽度，截断后不再保留颜色
+	if name := ansiPattern.ReplaceAllString(row[1], ""); runewidth.StringWidth(name) > t.widths[1] {
+		row[1] = runewidth.Truncate(name, t.widths[1], "…")
+	}
+	t.writeRow(row)
+}
+
+func (t *liveTable) writeRow(cells []string) {
+	var line strings.Builder
+	for i, cell := range cells {
+		if !t.color {
+			cell = ansiPattern.ReplaceAllString(cell, "")
+		}
+		line.WriteString(cell)
+		if i == len(cells)-1 {
+			break
+		}
+		// 按去掉颜色后的显示宽度补齐，转义序列不占宽度
+		if pad := t.widths[i] - runewidth.StringWidth(ansiPattern.ReplaceAllString(cell, "")); pad > 0 {
+			line.WriteString(strings.Repeat(" ", pad))
+		}
+		line.WriteString("\t")
+	}
+	fmt.Fprintln(t.w, strings.TrimRight(line.String(), " "))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// cellStarts 返回每一列在行里的显示宽度起点，列之间用制表符分隔
+func cellStarts(line string) []int {
+	var starts []int
+	width := 0
+	for i, cell := range strings.Split(line, "\t") {
+		if i > 0 {
+			width++
+		}
+		starts = append(starts, width)
+		width += runewidth.StringWidth(cell)
+	}
+	return starts
+}
+
+func TestLiveTable(t *testing.T) {
+	setFlags(t, "fast", "true")
+	var buf bytes.Buffer
+	table := newLiveTable(&buf, false, 12, false)
+	table.add(capResult("A", 0), 1)
+	long := capResult(strings.Repeat("香港", 20), 0)
+	table.add(long, 12)
+
+	lines := strings.Split(strings.TrimPrefix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 || lines[3] != "" {
+		t.Fatalf("output:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("colors written to a non-terminal:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "1/12") || !strings.HasPrefix(lines[2], "12/12") {
+		t.Errorf("progress column: %q, %q", lines[1], lines[2])
+	}
+	if name := strings.Split(lines[2], "\t")[1]; !strings.HasSuffix(strings.TrimSpace(name), "…") || runewidth.StringWidth(name) != 20 {
+		t.Errorf("long name not truncated to the column: %q", name)
+	}
+	header := cellStarts(lines[0])
+	for _, line := range lines[1:3] {
+		if got := cellStarts(line); len(got) != len(header) || got[len(got)-1] != header[len(header)-1] {
+			t.Errorf("columns of %q start at %v, header at %v", line, got, header)
+		}
+	}
+}
+
+func TestLiveTableColor(t *testing.T) {
+	setFlags(t, "fast", "true")
+	var buf bytes.Buffer
+	table := newLiveTable(&buf, true, 1, false)
+	table.add(capResult("A", 0), 1)
+	if !strings.Contains(buf.String(), colorGreen) {
+		t.Errorf("terminal output without colors:\n%s", buf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	plain := ansiPattern.ReplaceAllString(lines[1], "")
+	if got, want := cellStarts(plain), cellStarts(lines[0]); got[len(got)-1] != want[len(want)-1] {
+		t.Errorf("colored row columns %v, header %v", got, want)
+	}
+}
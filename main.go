@@ -1,14 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io"
+	"math"
 	"net/http"
 	"os"
-	"sort"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"io/fs"
@@ -18,8 +20,6 @@ import (
 	"github.com/faceair/clash-speedtest/speedtester"
 	"github.com/metacubex/mihomo/log"
 	"github.com/olekukonko/tablewriter"
-	"github.com/schollz/progressbar/v3"
-	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -27,14 +27,24 @@ var (
 	filterRegexConfig 			= flag.String("f", ".+", "filter proxies by name, use regexp")
 	blockKeywords     			= flag.String("b", "", "block proxies by keywords, use | to separate multiple keywords (example: -b 'rate|x1|1x')")
 	serverURL        		    = flag.String("server-url", "https://speed.cloudflare.com", "server url")
-	downloadSize      			= flag.Int("download-size", 50*1024*1024, "download size for testing proxies")
-	uploadSize        			= flag.Int("upload-size", 20*1024*1024, "upload size for testing proxies")
-	timeout           			= flag.Duration("timeout", time.Second*5, "timeout for testing proxies")
+	downloadServerURL 			= flag.String("download-server-url", "", "server url for latency and download tests (default: -server-url)")
+	uploadServerURL   			= flag.String("upload-server-url", "", "server url for upload tests (default: -server-url)")
+	uploadFallbackURL 			= flag.String("upload-fallback-server-url", "", "server url to retry the upload test once when the download works but every upload stream fails")
+	rateLimit         			= flag.String("rate-limit", "", "cap the total bandwidth of all download and upload tests (example: 100Mbps, 10MB/s), measured speeds are then marked as rate-limited")
+	allowUploadBlocked			= flag.Bool("allow-upload-blocked", false, "keep nodes whose uploads are all blocked instead of filtering them by -min-upload-speed")
+	timeout           			= durationFlag("timeout", time.Second*5, "timeout for testing proxies, a number without unit is in milliseconds")
+	downloadTimeout   			= durationFlag("download-timeout", 30*time.Second, "timeout of each download and upload request, a transfer cut off by it still counts with the bytes moved so far, a number without unit is in milliseconds")
 	concurrent        			= flag.Int("concurrent", 4, "download concurrent size")
+	retries           			= flag.Int("retries", 0, "retest a node up to this many extra times when it is unreachable or slower than -min-speed (or its source's min-speed in -sources), with a short backoff, keeping the best result (auth and tls errors are not retried)")
+	sourceBanStreak   			= flag.Int("source-ban-streak", 10, "when this many nodes of a source fail in a row after some of its nodes worked, suspect the source banned this ip and pause it, 0 to disable")
+	sourceCooldown    			= flag.Duration("source-cooldown", 5*time.Minute, "how long a source suspected of a ban is paused before one of its nodes is tried again, the rest are skipped if that also fails")
+	uploadConcurrent  			= flag.Int("upload-concurrent", 0, "upload concurrent size, 0 to use -concurrent")
+	streamStagger     			= durationFlag("stream-stagger", 0, "delay between the start of two download or upload connections of a node instead of starting them together, a number without unit is in milliseconds")
+	nodeConcurrent    			= flag.Int("node-concurrent", 1, "number of proxies tested at the same time, each still uses -concurrent download connections")
 	outputPath       			= flag.String("output", "./useable.yaml", "output config file path")
 	goodOutputPath				= flag.String("good-output", "./good.yaml", "output good config file path")
-	stashCompatible   			= flag.Bool("stash-compatible", false, "enable stash compatible mode")
-	maxLatency        			= flag.Duration("max-latency", 800*time.Millisecond, "filter latency greater than this value")
+	stashCompatible   			= flag.Bool("stash-compatible", false, "only keep nodes that stash can import, rewrite options stash writes differently (empty flow, network: tcp, ws-path) and log why other nodes are skipped")
+	maxLatency        			= durationFlag("max-latency", 800*time.Millisecond, "filter latency greater than this value, a number without unit is in milliseconds")
 	minSpeed         			= flag.Float64("min-speed", 0.1, "filter speed less than this value(unit: MB/s)")
 	skipPaths		  			= flag.String("skip-paths", "", "filter unwanted yaml file if specify direcotry")
 	extraConnectURL   			= flag.String("extra-connect-url", "", "must connect urls, ',' split multiple urls")
@@ -43,9 +53,152 @@ var (
 	goodDownloadSpeedThreshold	= flag.Float64("good-download-speed-threshold", 1, "确定为优质节点的资源下载速度(单位: MB/s)")
 	showLog						= flag.Bool("debug", false, "是否显示日志")
 	minDownloadSpeed  			= flag.Float64("min-download-speed", 5, "filter download speed less than this value(unit: MB/s)")
-	minUploadSpeed    			= flag.Float64("min-upload-speed", 2, "filter upload speed less than this value(unit: MB/s)")
-	renameNodes       			= flag.Bool("rename", false, "rename nodes with IP location and speed")
+	minUploadSpeed    			= flag.Float64("min-upload-speed", 2, "filter nodes whose measured upload speed is less than this value, nodes whose uploads are all blocked are filtered too unless -allow-upload-blocked (unit: MB/s)")
+	renameNodes       			= flag.Bool("rename", false, "rename nodes in the output files with the country of their exit ip and the download speed (example: 🇯🇵 JP | ⬇️ 12.40 MB/s)")
+	renameCity        			= flag.Bool("rename-city", false, "with -rename, add the city of the exit ip after the country when the geo provider reports it (example: 🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s)")
 	fastMode          			= flag.Bool("fast", false, "fast mode, only test latency")
+	sshKnownHosts     			= flag.String("ssh-known-hosts", "", "known_hosts file used to verify ssh proxies without host-key")
+	requireSSHVerified			= flag.Bool("require-ssh-verified", false, "exclude ssh proxies whose host key is not verified")
+	presetName        			= flag.String("preset", "", "start from a bundle of options, individual flags and the profile still override it: quick (latency and 10MB download, no upload, 8 nodes at a time) or thorough (50MB download, upload, 30s sustained test, one node at a time)")
+	profilePath       			= flag.String("profile", "", "yaml file holding default options, command line flags take precedence (default ./clash-speedtest.yaml if exists)")
+	printConfig       			= flag.Bool("print-config", false, "print the effective configuration and exit")
+	excludeASN        			= flag.String("exclude-asn", "", "exclude nodes whose exit ip belongs to these ASNs, ',' split multiple ASNs (example: -exclude-asn 9009,212238)")
+	asnAllowlist      			= flag.String("asn-allowlist", "", "only keep nodes whose exit ip belongs to these ASNs, ',' split multiple ASNs")
+	injectFilter      			= flag.String("inject-filter", "", "only apply -inject transforms to proxies whose name matches this regexp")
+	saveOriginalConfig			= flag.Bool("save-original-config", false, "save the original proxy config instead of the -inject transformed one")
+	strictParse       			= flag.Bool("strict-parse", false, "parse proxies as written instead of fixing common broken fields (string ports, missing ws path slash, empty sni, ...)")
+	proxyTypes        			= flag.String("type", "", "only test nodes of these proxy types, ',' split multiple types (example: -type vless,hysteria2,trojan)")
+	typeOverrides     			= flag.String("type-overrides", "", "per proxy type test options, ';' split types (example: -type-overrides 'hysteria2:download-size=100MB,timeout=20s;ssh:latency-timeout=10s'), keys: download-size, upload-size, timeout, download-timeout, max-latency (alias latency-timeout), concurrent, upload-concurrent")
+	maxGoodNodes      			= flag.Int("max-good-nodes", 0, "keep at most this many good entries in -good-output by the active sort, each -cc-sweep variant counts, the rest go to -output marked as demoted by the cap, pinned nodes take the slots first, 0 for no limit")
+	printThresholds   			= flag.Bool("threshold-report", false, "after the run, print how many nodes fail only one threshold and how many would be usable if each threshold were relaxed, also written to -results-json as threshold_report")
+	dedup             			= flag.Bool("dedup", false, "test each physical node once when it appears in several sources or under several names (same type, server, port, uuid/password, username and network), the first one by source order and name is kept")
+	streamOutput      			= flag.Bool("stream-output", false, "rewrite the output files every time a node passes, so they always hold the nodes usable so far, the final save still sorts and filters them and restores the files from before the run when no node is left")
+	consoleMode       			= flag.String("console", consoleFull, "full prints the result table, delta only prints the nodes that became usable, dropped out or changed speed by more than -delta-threshold since the previous run in -history-file, and a totals line (full | delta)")
+	deltaThreshold    			= flag.Float64("delta-threshold", 20, "with -console delta, list nodes whose download speed (latency with -fast) changed by more than this percentage")
+	onelineOutput     			= flag.Bool("oneline", false, "print one tab separated line per node as soon as it is tested instead of the table")
+	liveOutput        			= flag.Bool("live", false, "print a table row for each usable node as soon as it is tested instead of the progress bar, the sorted table is still printed at the end")
+	uploadIntegritySize			= flag.Int("upload-integrity-size", 0, "upload this many pseudo-random bytes to <server-url>/__hash to verify the node does not corrupt uploads, 0 to disable (only supported by download-server)")
+	tamperCheck       			= flag.Bool("tamper-check", false, "fetch a known page over plain http through nodes that pass the speed test and exclude nodes that alter it from good, the page is fetched once without a proxy first and the check is skipped when that copy does not match (default page: <download-server-url>/__known, only supported by download-server)")
+	tamperCheckURL    			= flag.String("tamper-check-url", "", "plain http url fetched by -tamper-check instead of <download-server-url>/__known")
+	tamperCheckSHA256 			= flag.String("tamper-check-sha256", "", "expected sha256 of -tamper-check-url, needed unless the url serves the download-server /__known page")
+	requireUploadIntegrity		= flag.Bool("require-upload-integrity", false, "exclude nodes whose upload integrity is not verified")
+	historyFilePath   			= flag.String("history-file", "", "json file keeping results of previous runs, the table shows changes versus the previous run")
+	historyRetention  			= flag.Duration("history-retention", 7*24*time.Hour, "drop history records older than this value")
+	peakHours         			= flag.String("peak-hours", "", "peak hours in local time used to profile nodes from history (example: -peak-hours 19-23)")
+	dohURL            			= flag.String("doh", "", "resolve every local lookup (subscriptions, geo ip, server locations, proxy server hostnames, ...) with this DNS-over-HTTPS endpoint instead of the system resolver, proxies still resolve the hosts they connect to themselves (example: -doh https://1.1.1.1/dns-query)")
+	impersonate       			= flag.String("impersonate", "none", "send test requests with the headers and tls fingerprint of a browser, for providers that throttle benchmark traffic (chrome, safari, none)")
+	clashDelay        			= flag.Bool("clash-delay", false, "also measure the delay the way clash clients show it (one url-test request through mihomo) and show it next to the latency")
+	clashDelayURL     			= flag.String("clash-delay-url", speedtester.DefaultClashDelayURL, "url used by -clash-delay")
+	scenariosPath     			= flag.String("scenarios", "", "yaml file of named scenarios, each with its own test phases and thresholds, every node is tested once and judged per scenario")
+	interactiveSave   			= flag.Bool("interactive-save", false, "after the table is printed, pick the nodes to save at a prompt (drop 3,7-9 / keep / good>=8MB/s / preview / save / quit), needs a terminal")
+	autoConcurrent    			= flag.Bool("auto-concurrent", false, "start the download test with 1 connection and add connections while the throughput improves by more than 10%, up to -concurrent; ignored with -max-download-bytes or -rate-limit")
+	groupBy           			= flag.String("group-by", "", "show the result table grouped by type, country or source, followed by a summary of each group (tested, usable %, median latency, median download speed), also written to -results-json as by_type, by_country or by_source")
+	sortBy            			= flag.String("sort", "", "sort the table and saved configs by: download, upload, latency, jitter, loss, name or peak-speed, with an optional :asc or :desc suffix (default: good first, then download speed)")
+	volatileFieldsSpec			= flag.String("volatile-fields", "default", "fields that subscriptions regenerate on every download and are ignored when comparing nodes across runs, ',' split dotted paths (example: ws-opts.headers.X-Ts) and name-suffix-regex:<regexp> entries, default expands to the built-in date and timestamp name suffixes, empty to disable")
+	stripVolatile     			= flag.Bool("strip-volatile", false, "also remove -volatile-fields from -output and -good-output, trimmed names that collide with another node keep their original name")
+	onlyChanged       			= flag.Bool("only-changed", false, "only test nodes that are new or changed since the previous run, reuse the other results from -history-file")
+	maxResultAge      			= flag.Duration("max-result-age", 24*time.Hour, "with -only-changed, re-test nodes whose previous result is older than this value")
+	maxPlausibleSpeed 			= flag.Float64("max-plausible-speed", 1280, "download speed above this value is treated as a measurement error and retested once(unit: MB/s)")
+	minSampleDuration 			= durationFlag("min-sample-duration", 2*time.Second, "with -max-download-bytes, keep downloading for at least this long so fast nodes are not measured during tcp slow start")
+	minDownloadDuration			= durationFlag("min-download-duration", 300*time.Millisecond, "download finished faster than this value is treated as a measurement error and retested once")
+	filterFile        			= flag.String("filter-file", "", "yaml file of ordered include/exclude rules applied after -f and -b, the first matching rule wins")
+	explainFilter     			= flag.String("explain-filter", "", "print which filter decided the fate of the node with this name and exit")
+	listenAddr        			= flag.String("listen", "", "after testing, serve the surviving nodes as a subscription at http://<listen>/sub (example: -listen :8090); without -c and -sources, serve the job api (POST /test, GET /jobs/{id}) instead of testing once")
+	subToken          			= flag.String("sub-token", "", "token required to access /sub and the job api (mandatory for the job api), passed as ?token= or an Authorization: Bearer header")
+	maxJobs           			= flag.Int("max-jobs", 1, "with -listen and no -c, the number of test jobs that run at the same time, the rest wait in a queue")
+	subUpdateInterval 			= flag.Int("sub-update-interval", 12, "profile-update-interval (unit: hours) sent to subscription clients, 0 to omit")
+	testWebSocketURL  			= flag.String("test-websocket", "", "open a websocket to this echo server through each node and check one echo round trip (example: -test-websocket wss://echo.websocket.events)")
+	requireWebSocket  			= flag.Bool("require-websocket", false, "exclude nodes that fail the -test-websocket check")
+	outputPerSource   			= flag.String("output-per-source", "", "also write usable nodes of each source to a separate file in this directory")
+	preserveSource    			= flag.Bool("preserve-source-content", false, "with -output-per-source, keep everything but the proxies of the original file (rules, dns, proxy-groups, comments)")
+	pingIntervalJitter			= flag.Float64("ping-interval-jitter", 0.3, "randomize the interval between latency probes by this fraction (0.3 = ±30%) and stagger the start of concurrent nodes, 0 for strict timing")
+	badOutputPath     			= flag.String("bad-output", "", "write unusable nodes grouped by failure class to this file, for subscription maintainers")
+	latencyConnection 			= flag.String("latency-connection", "reuse", "latency probes: reuse a warm connection, open a new connection for every probe, or both (reuse|new|both)")
+	maxNewConnLatency 			= durationFlag("max-new-conn-latency", 0, "filter nodes whose new connection latency is greater than this value, 0 to disable (needs -latency-connection new or both)")
+	serverCountries   			= flag.String("server-countries", "", "only test nodes whose server address is located in these countries, ',' split multiple country codes (example: -server-countries JP,SG)")
+	serverCountriesStrict		= flag.Bool("server-countries-strict", false, "with -server-countries, also drop nodes whose server cannot be resolved or located")
+	scorecardPath     			= flag.String("scorecard", "", "write a json scorecard per source (usable/good ratio, median speed, countries, remaining traffic, stability, composite score)")
+	scoreWeights      			= flag.String("score-weights", defaultScoreWeights, "weights of the scorecard composite score, components: usable, speed, stability, diversity")
+	hysteresisMargin  			= flag.Float64("hysteresis-margin", 0, "nodes already in the previous output are only dropped when they miss -max-latency/-min-speed by more than this fraction, and new nodes must beat them by this fraction (example: 0.1)")
+	dropAfter         			= flag.Int("drop-after", 1, "drop a node from the output only after it fails this many consecutive runs, with -hysteresis-margin also as soon as it misses the thresholds by more than the margin (needs -history-file)")
+	minCountries      			= flag.Int("min-countries", 0, "when good nodes span fewer exit countries than this value, add the fastest usable node of other countries to the good output")
+	maxPerSubnet      			= flag.Int("max-per-subnet", 0, "keep at most this many of the fastest nodes whose exit ip is in the same /24 (/48 for IPv6) in the output, 0 to disable")
+	minUsable         			= flag.Int("min-usable", 0, "exit with status 1 after writing the outputs when fewer nodes than this value are usable (pinned nodes that fail do not count), -gh-summary also reports an error annotation; with -listen only print the error and keep serving")
+	minAge            			= flag.Int("min-age", 0, "only output nodes that appeared in at least this many runs (needs -history-file)")
+	execPerResult     			= flag.String("exec-per-result", "", "run this shell command for every tested node with the result json on stdin and NODE_NAME, VERDICT, DOWNLOAD_MBPS in the environment")
+	execVeto          			= flag.Bool("exec-veto", false, "with -exec-per-result, exclude nodes for which the command exits non-zero")
+	execTimeout       			= durationFlag("exec-timeout", 10*time.Second, "timeout of each -exec-per-result command")
+	execConcurrency   			= flag.Int("exec-concurrency", 4, "maximum number of -exec-per-result commands running at the same time")
+	execAllowRoot     			= flag.Bool("exec-allow-root", false, "allow -exec-per-result when running as root")
+	sourcesPath       			= flag.String("sources", "", "yaml file of subscriptions with per-source url, headers, ua, name and filter/threshold overrides, merged with -c")
+	checkDirectLeak   			= flag.Bool("check-direct-leak", false, "exclude nodes whose exit ip equals this machine's public ip, which means the traffic did not go through the node, off by default because nodes that genuinely share this machine's egress would be excluded")
+	myIP              			= flag.String("my-ip", "", "public ip of this machine used by -check-direct-leak instead of detecting it (example: when some nodes genuinely share the egress)")
+	allowTinyDurations			= flag.Bool("allow-tiny-durations", false, "allow -timeout, -max-latency and similar flags below 1ms, which are usually a missing unit")
+	shareURL          			= flag.String("share-url", "", "after testing, post an anonymized summary (node key hash, type, country, latency, speeds) to this url")
+	shareToken        			= flag.String("share-token", "", "bearer token sent with -share-url")
+	shareDryRun       			= flag.Bool("share-dry-run", false, "print the exact -share-url payload instead of posting it")
+	sustained         			= durationFlag("sustained", 0, "after the download test, keep downloading from usable nodes for this duration to detect throttling after an initial burst, 0 to disable, a number without unit is in milliseconds (example: -sustained 30s)")
+	minSustainedSpeed 			= flag.Float64("min-sustained-speed", 0, "with -sustained, good nodes must keep at least this speed(unit: MB/s), 0 to disable")
+	allowEmptyGood    			= flag.Bool("allow-empty-good", false, "write -good-output even when no node is good, by default the file is left unchanged and a .meta.json with the reason is written next to it")
+	ipFamilyFallback  			= flag.Bool("ip-family-fallback", false, "when every latency probe of a node times out or the network is unreachable and the download server has both A and AAAA records, probe again over each family with the server ip pinned and test the node over the one that works")
+	namePrefix        			= flag.String("name-prefix", speedtester.NamePrefixFile, "name of the nodes in the results and output files: file prefixes the config file name (sub1_HK 01), none keeps the original name and numbers duplicates across files, any other value is used as a literal prefix")
+	vantageName       			= flag.String("vantage-name", "", "name of the place this test runs from (example: tokyo), recorded in every result and in -results-json for the merge subcommand")
+	resultsJSONPath   			= flag.String("results-json", "", "write every tested node with its result and usable/good verdict as json to this file, the input of 'clash-speedtest merge'")
+	csvPath           			= flag.String("csv", "", "also write the result table as csv to this file, without colors and with raw numeric columns, written even when no node is usable")
+	csvColumnsSpec    			= flag.String("csv-columns", "", "',' split columns written by -csv, by name or table header (default all: id, name, type, latency, jitter, packet_loss, download_speed, upload_speed, extra_url_connectivity, extra_url_open_speed, extra_download_speed, latency_ms, jitter_ms, packet_loss_pct, download_bytes_per_sec, upload_bytes_per_sec, extra_download_bytes_per_sec, exit_asn, exit_as_org)")
+	outputTxtPath     			= flag.String("output-txt", "", "also write usable nodes as tab separated lines of name, exit ip, country and download speed(MB/s) to this file")
+	closeLatency      			= flag.Bool("close-latency", false, "measure how long a node takes to close a finished connection, slow closes hurt clients opening many short connections")
+	maxCloseLatency   			= durationFlag("max-close-latency", 2*time.Second, "with -close-latency, mark nodes whose close latency is greater than this value")
+	geoProvider       			= flag.String("geo-provider", "ip-api", "exit ip geolocation providers tried in this order until one answers, ',' split multiple providers (ip-api, ipinfo, ipsb)")
+	myRegion          			= flag.String("my-region", "", "country code of this machine, nodes whose latency is below ~70% of the physical minimum to their exit (or claimed) country are marked with ⚠, detected from the public ip when empty")
+	providerDepth     			= flag.Int("provider-depth", 1, "how many levels of proxy-providers to expand, providers declared inside a provider's content count as the next level, 0 to ignore providers")
+	maxProviders      			= flag.Int("max-providers", 20, "maximum number of proxy-providers expanded per source, 0 for no limit")
+	ccSweep           			= flag.String("cc-sweep", "", "test tuic and hysteria2 nodes once per congestion control in this list and only output the fastest variant, multiplies their test time, ',' split multiple values (bbr, cubic, new_reno, brutal; example: bbr,cubic)")
+	pinPath           			= flag.String("pin", "", "file of node names, /regexps/ or node keys that are always kept in the output even if they fail the tests")
+	injectSpecs       			stringList
+	scenarioOutputSpecs			stringList
+	downloadSize      			= byteSize(50 * 1024 * 1024)
+	uploadSize        			= byteSize(20 * 1024 * 1024)
+	partialSaveEvery  			saveEvery
+	ghSummaryFlag     			ghSummary
+	sustainedMaxSize  			= byteSize(200 * 1024 * 1024)
+	maxDownloadBytes  			byteSize
+	uploadChunkSize   			= byteSize(1024 * 1024)
+)
+
+// peakSpeeds 是根据历史记录统计出的节点高峰时段速度，按 NodeKey 索引
+var peakSpeeds map[string]*peakStats
+
+// previousRecords 是 -history-file 里每个节点上一次的结果，按 NodeKey 索引，用于显示变化
+var previousRecords map[string]historyRecord
+
+// previousRun 是 -history-file 里最近一次运行的记录，按 NodeKey 索引，-console delta 和它比较
+var previousRun map[string]historyRecord
+
+// pins 是 -pin 文件中固定保留的节点
+var pins *pinList
+
+// volatileFields 是 -volatile-fields 解析后的结果，比较两次运行的节点配置时忽略这些字段
+var volatileFields *speedtester.VolatileFields
+
+// heldNodes 是本次不达标、但因为 -hysteresis-margin/-drop-after 仍然保留在输出里的节点
+var heldNodes map[string]bool
+
+func init() {
+	flag.Var(&downloadSize, "download-size", "download size for testing proxies, accepts units like 50MB or 1.5GiB")
+	flag.Var(&uploadSize, "upload-size", "upload size for testing proxies, accepts units like 20MB or 1GiB")
+	flag.Var(&uploadChunkSize, "upload-chunk-size", "size of each POST of the upload test, -upload-size is sent as several sequential requests, sent chunked, with a Content-Length after a 411, halved once when the server answers 413, accepts units like 512KB")
+	flag.Var(&maxDownloadBytes, "max-download-bytes", "stop the download test of a node once its speed is stable or all connections downloaded this many bytes, accepts units like 10MB, 0 to always download -download-size")
+	flag.Var(&sustainedMaxSize, "sustained-max-size", "maximum bytes downloaded per node by -sustained, accepts units like 200MB")
+	flag.Var(&ghSummaryFlag, "gh-summary", "write a markdown summary to $GITHUB_STEP_SUMMARY (or -gh-summary=path) and print GitHub Actions annotations for failed sources and -min-usable")
+	flag.Var(&partialSaveEvery, "save-every", "while testing, write the nodes usable so far to the output files every this duration (10m) or this many tested nodes (50), the files from before the run are restored when the final filters leave no node")
+	flag.Var(&scenarioOutputSpecs, "scenario-output", "write the nodes usable in a -scenarios scenario to a file, can be repeated (example: -scenario-output streaming=streaming.yaml)")
+	flag.Var(&injectSpecs, "inject", "transform proxy configs before testing, can be repeated (example: -inject 'shadow-tls:{\"host\":\"cloud.tencent.com\",\"password\":\"x\",\"version\":3}')")
+}
+
+var (
+	excludedASNs map[int]bool
+	allowedASNs  map[int]bool
 )
 
 const (
@@ -56,96 +209,722 @@ const (
 )
 
 func main() {
+	// merge 子命令有自己的参数，不经过下面的 flag 解析和校验
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		runMerge(os.Args[2:])
+		return
+	}
 	flag.Parse()
+	if err := loadProfile(flag.CommandLine, *profilePath); err != nil {
+		log.Fatalln("%v", err)
+	}
+	var invalid []string
+	for _, err := range validateOptions(flag.CommandLine) {
+		if isOptionWarning(err) {
+			fmt.Fprintf(os.Stderr, "%swarning: %v%s\n", colorYellow, err, colorReset)
+		} else {
+			invalid = append(invalid, err.Error())
+		}
+	}
+	if len(invalid) > 0 {
+		log.Fatalln("invalid options:\n  %s", strings.Join(invalid, "\n  "))
+	}
+	for _, name := range stdoutArtifacts {
+		if flag.Lookup(name).Value.String() == stdoutPath {
+			claimStdout()
+		}
+	}
+	if *interactiveSave && !isTerminal(os.Stdin) {
+		log.Fatalln("-interactive-save needs an interactive terminal, stdin is not a tty")
+	}
+	if *printConfig {
+		if err := printEffectiveConfig(flag.CommandLine); err != nil {
+			log.Fatalln("print config failed: %v", err)
+		}
+		return
+	}
 	if *showLog {
 		log.SetLevel(log.INFO)
 	} else {
 		log.SetLevel(log.SILENT)
 	}
+	speedtester.AllowIPv6()
+	if *dohURL != "" {
+		// 默认的 Dialer 和 http.DefaultTransport 每次拨号都会读取 net.DefaultResolver，
+		// mihomo 拨号节点服务器时用它自己的解析器，UseDoH 把两者都替换掉
+		if err := speedtester.UseDoH(*dohURL); err != nil {
+			log.Fatalln("-doh: %v", err)
+		}
+	}
 		
 
-	if *configPathsConfig == "" {
+	if *configPathsConfig == "" && *sourcesPath == "" && *listenAddr == "" {
 		log.Fatalln("please specify the configuration file")
 	}
 	config := speedtester.Config{
 		//ConfigPaths:  		*configPathsConfig,
 		FilterRegex:  		*filterRegexConfig,
 		ServerURL:    		*serverURL,
+		DownloadServerURL: 	*downloadServerURL,
+		UploadServerURL:   	*uploadServerURL,
+		UploadFallbackURL:  *uploadFallbackURL,
 		BlockRegex:       	*blockKeywords,
-		DownloadSize: 		*downloadSize,
-		UploadSize:   		*uploadSize,
+		DownloadSize: 		int(downloadSize),
+		UploadSize:   		int(uploadSize),
+		UploadChunkSize:    int(uploadChunkSize),
 		Timeout:      		*timeout,
+		DownloadTimeout:  	*downloadTimeout,
 		Concurrent:   		*concurrent,
+		NodeConcurrent:   	*nodeConcurrent,
+		UploadConcurrent:   *uploadConcurrent,
+		StreamStagger:      *streamStagger,
+		SourceBanStreak:  	*sourceBanStreak,
+		Retries:          	*retries,
+		SourceCooldown:   	*sourceCooldown,
 		ExtraDownloadURL: 	*extraDownloadURL,
 		MaxLatency:       *maxLatency,
 		MinDownloadSpeed: *minDownloadSpeed * 1024 * 1024,
+		MinSpeed:         *minSpeed * 1024 * 1024,
 		MinUploadSpeed:   *minUploadSpeed * 1024 * 1024,
 		FastMode:         *fastMode,
+		SSHKnownHosts:    *sshKnownHosts,
+		UploadIntegritySize: *uploadIntegritySize,
+		TamperCheck:         *tamperCheck,
+		TamperCheckURL:      *tamperCheckURL,
+		TamperCheckSHA256:   *tamperCheckSHA256,
+		MaxPlausibleSpeed:   *maxPlausibleSpeed * 1024 * 1024,
+		MinDownloadDuration: *minDownloadDuration,
+		PingIntervalJitter:  *pingIntervalJitter,
+		LatencyConnection:   *latencyConnection,
+		SustainedDuration:   *sustained,
+		SustainedMaxSize:    int(sustainedMaxSize),
+		MaxDownloadBytes:    int(maxDownloadBytes),
+		MinSampleDuration:   *minSampleDuration,
+		CloseLatency:        *closeLatency,
+	}
+	excludedASNs, _ = parseASNList(*excludeASN)
+	allowedASNs, _ = parseASNList(*asnAllowlist)
+	config.DetectExitIP = *renameNodes || len(excludedASNs) > 0 || len(allowedASNs) > 0 || *minCountries > 0 || *maxPerSubnet > 0 || *outputTxtPath != "" || *groupBy == groupByCountry || (*csvPath != "" && csvColumnsNeedExitIP(*csvColumnsSpec))
+	for _, spec := range injectSpecs {
+		injection, err := speedtester.ParseInjection(spec)
+		if err != nil {
+			log.Fatalln("invalid -inject: %v", err)
+		}
+		config.Injections = append(config.Injections, injection)
+	}
+	geoResolver, err := speedtester.ParseGeoProviders(*geoProvider)
+	if err != nil {
+		log.Fatalln("invalid -geo-provider: %v", err)
+	}
+	config.GeoResolver = speedtester.NewCachedResolver(geoResolver)
+	// 本机的公网 IP 用于 -check-direct-leak，以及没有指定 -my-region 时定位本机
+	localIP := *myIP
+	if localIP == "" && !*fastMode && (*checkDirectLeak || *myRegion == "") {
+		traceServerURL := *downloadServerURL
+		if traceServerURL == "" {
+			traceServerURL = *serverURL
+		}
+		ip, err := speedtester.DetectLocalIP(traceServerURL, *timeout)
+		if err != nil && *checkDirectLeak {
+			fmt.Fprintf(os.Stderr, "%sdetect public ip failed, direct leak check is disabled: %v%s\n", colorYellow, err, colorReset)
+		}
+		localIP = ip
+	}
+	if *checkDirectLeak && !*fastMode {
+		config.LocalIP = localIP
+	}
+	config.AutoConcurrent = *autoConcurrent
+	if *rateLimit != "" {
+		rate, _ := speedtester.ParseRate(*rateLimit)
+		config.RateLimiter = speedtester.NewRateLimiter(rate)
+	}
+	config.Impersonate = *impersonate
+	config.AllowedTypes, _ = speedtester.ParseProxyTypes(*proxyTypes)
+	config.TypeOverrides, _ = speedtester.ParseTypeOverrides(*typeOverrides)
+	config.VantageName = *vantageName
+	config.NamePrefix = *namePrefix
+	config.IPFamilyFallback = *ipFamilyFallback
+	if *clashDelay {
+		config.ClashDelayURL = *clashDelayURL
+	}
+	config.WebSocketURL = *testWebSocketURL
+	var scenarios []*scenario
+	var scenarioOutputs map[string]string
+	if *scenariosPath != "" {
+		if scenarios, err = loadScenarios(*scenariosPath); err != nil {
+			log.Fatalln("load scenarios failed: %v", err)
+		}
+		if scenarioOutputs, err = parseScenarioOutputs(scenarioOutputSpecs, scenarios); err != nil {
+			log.Fatalln("invalid -scenario-output: %v", err)
+		}
+		if err := applyScenarioPhases(scenarios, &config); err != nil {
+			log.Fatalln("invalid -scenarios: %v", err)
+		}
+	}
+	config.ProviderLimits = speedtester.ProviderLimits{MaxDepth: *providerDepth, MaxCount: *maxProviders}
+	if config.CCSweep, err = speedtester.ParseCCSweep(*ccSweep); err != nil {
+		log.Fatalln("invalid -cc-sweep: %v", err)
+	}
+	config.MyRegion = strings.ToUpper(*myRegion)
+	if config.MyRegion == "" && localIP != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		info, err := config.GeoResolver.Lookup(ctx, localIP)
+		cancel()
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "%slocate this machine failed, set -my-region to enable the latency floor check: %v%s\n", colorYellow, err, colorReset)
+		case speedtester.KnownRegion(info.CountryCode):
+			config.MyRegion = info.CountryCode
+		}
+	}
+	if *filterFile != "" {
+		if config.Filter, err = speedtester.LoadFilterSet(*filterFile); err != nil {
+			log.Fatalln("invalid -filter-file: %v", err)
+		}
+	}
+	config.ExplainFilter = *explainFilter
+	if *serverCountries != "" {
+		config.ServerCountries = strings.Split(*serverCountries, ",")
 	}
+	config.ServerCountriesStrict = *serverCountriesStrict
+	config.InjectFilter = *injectFilter
+	config.SaveOriginalConfig = *saveOriginalConfig
+	config.StrictParse = *strictParse
 	if *extraConnectURL != "" {
 		config.ExtraConnectURL = strings.Split(*extraConnectURL, ",")
 	}
+	if *configPathsConfig == "" && *sourcesPath == "" {
+		// 只有 -listen 时不测试，等待通过 POST /test 提交的任务
+		fmt.Fprintf(os.Stderr, "serving test jobs at http://%s/test\n", *listenAddr)
+		if err := http.ListenAndServe(*listenAddr, newJobServer(config, *subToken, *maxJobs).handler()); err != nil {
+			log.Fatalln("listen %s failed: %v", *listenAddr, err)
+		}
+		return
+	}
 
-	actualPaths, _ := getAllConfigPath(*configPathsConfig, *skipPaths)
-	if len(actualPaths) == 0 {
+	// -c 的配置文件和 -sources 中的订阅合并在一起加载
+	var targets []*sourceEntry
+	if *configPathsConfig != "" {
+		paths, _ := getAllConfigPath(*configPathsConfig, *skipPaths)
+		for _, path := range paths {
+			targets = append(targets, &sourceEntry{URL: path})
+		}
+	}
+	if *sourcesPath != "" {
+		entries, err := loadSources(*sourcesPath)
+		if err != nil {
+			log.Fatalln("load sources failed: %v", err)
+		}
+		sourceOverrides = make(map[string]*sourceEntry, len(entries))
+		config.SourceMaxLatency = make(map[string]time.Duration)
+		config.SourceMinSpeed = make(map[string]float64)
+		for _, entry := range entries {
+			sourceOverrides[entry.tag()] = entry
+			if entry.maxLatency != nil {
+				config.SourceMaxLatency[entry.tag()] = *entry.maxLatency
+			}
+			if entry.MinSpeed != nil {
+				config.SourceMinSpeed[entry.tag()] = *entry.MinSpeed * 1024 * 1024
+			}
+		}
+		targets = append(targets, entries...)
+	}
+	if len(targets) == 0 {
 		log.Fatalln("cannot find yaml paths")
 	}
 
 	speedTester := speedtester.New(&config)
 	results := make([]*speedtester.Result, 0)
 
-	for _, actualPath := range actualPaths {
-		config.ConfigPaths = actualPath
-		title := filepath.Base(actualPath)
-		allProxies, err := speedTester.LoadProxies(*stashCompatible)
+	// 先加载并过滤全部配置文件，确定最终要测试的节点数后再创建进度条
+	sources := make([]map[string]*speedtester.CProxy, 0, len(targets))
+	reports := make([]*sourceReport, 0, len(targets))
+	for _, target := range targets {
+		config.ConfigPaths = target.URL
+		config.FilterRegex = *filterRegexConfig
+		if target.Filter != "" {
+			config.FilterRegex = target.Filter
+		}
+		config.FetchHeaders = target.Headers
+		config.UserAgent = target.UA
+		config.SourceName = target.Name
+		report, err := speedTester.LoadProxies(*stashCompatible)
 		if err != nil {
-			log.Warnln("load proxies failed: %v, %v, ", actualPath, err)
+			log.Warnln("load proxies failed: %v, %v, ", target.tag(), err)
+			report = &speedtester.LoadReport{SourceError: err.Error()}
+		}
+		reports = append(reports, &sourceReport{Path: target.tag(), LoadReport: report})
+		printLoadReport(target.tag(), report)
+		sources = append(sources, report.Proxies)
+	}
+
+	failedSources := 0
+	for _, report := range reports {
+		if report.SourceError != "" {
+			failedSources++
+			fmt.Fprintf(os.Stderr, "%ssource %s failed: %s%s\n", colorRed, report.Path, report.SourceError, colorReset)
+		}
+	}
+	if failedSources > 0 {
+		if failedSources == len(reports) {
+			log.Fatalln("all %d sources failed to load", failedSources)
+		}
+		fmt.Fprintf(os.Stderr, "%d of %d sources failed to load\n", failedSources, len(reports))
+	}
+	if *dedup {
+		var dropped int
+		sources, dropped = speedtester.DeduplicateProxies(sources)
+		fmt.Fprintf(os.Stderr, "dropped %d duplicate nodes\n", dropped)
+	}
+	if *namePrefix != speedtester.NamePrefixFile {
+		// 不加文件名时不同文件里的同名节点会重名
+		var renamed int
+		if sources, renamed = speedtester.RenameDuplicates(sources); renamed > 0 {
+			fmt.Fprintf(os.Stderr, "renamed %d nodes whose name is used in an earlier source\n", renamed)
+		}
+	}
+
+	if *explainFilter != "" {
+		found := false
+		for _, report := range reports {
+			for _, explanation := range report.Explanations {
+				fmt.Printf("%s: %s\n", filepath.Base(report.Path), explanation)
+				found = true
+			}
+		}
+		if !found {
+			fmt.Printf("node %q is not found in any source (or was dropped before filtering as unsupported)\n", *explainFilter)
+		}
+		return
+	}
+
+	if *pinPath != "" {
+		if pins, err = loadPinList(*pinPath); err != nil {
+			log.Fatalln("load pin file failed: %v", err)
+		}
+		for _, allProxies := range sources {
+			for _, proxy := range allProxies {
+				pins.match(proxy.Config)
+			}
+		}
+		for _, rule := range pins.unmatched() {
+			fmt.Fprintf(os.Stderr, "%swarning: pinned node %q is not found in any source%s\n", colorYellow, rule, colorReset)
+		}
+	}
+
+	volatileFields, _ = speedtester.ParseVolatileFields(*volatileFieldsSpec)
+	warnVolatileFields(sources)
+
+	var history *historyFile
+	if *historyFilePath != "" {
+		if history, err = loadHistory(*historyFilePath); err != nil {
+			log.Fatalln("load history failed: %v", err)
+		}
+		previousRecords = history.lastRecords()
+		previousRun = history.lastRunRecords()
+	}
+	runStart := time.Now()
+	var reusedResults []*speedtester.Result
+	if *onlyChanged {
+		for _, allProxies := range sources {
+			reusedResults = append(reusedResults, history.reuseResults(speedTester, allProxies, runStart, *maxResultAge)...)
+		}
+	}
+	total := countProxies(sources)
+	if *onlyChanged {
+		fmt.Fprintf(os.Stderr, "only changed: reuse %d previous results, test %d nodes\n", len(reusedResults), total)
+	}
+
+	title := filepath.Base(targets[0].tag())
+	if len(targets) > 1 {
+		title = fmt.Sprintf("%d sources", len(targets))
+	}
+	var bar progress
+	onelineColor := isTerminal(console)
+	var live *liveTable
+	if *onelineOutput {
+		bar = nopProgress{}
+	} else if *liveOutput {
+		// 进度条和逐行输出在同一个终端里会互相覆盖，改为在序号列显示进度
+		bar = nopProgress{}
+		live = newLiveTable(console, isTerminal(console), total+len(reusedResults), config.DetectExitIP)
+	} else {
+		bar = newProgress(total, title)
+	}
+	var hook *resultHook
+	if *execPerResult != "" {
+		if isElevated() && !*execAllowRoot {
+			fmt.Fprintf(os.Stderr, "%s-exec-per-result is disabled when running as root, use -exec-allow-root to enable it%s\n", colorYellow, colorReset)
+		} else {
+			hook = newResultHook(*execPerResult, *execTimeout, *execConcurrency, *execVeto)
+		}
+	}
+	var saver *partialSaver
+	if *outputPath != "" || *goodOutputPath != "" {
+		if *streamOutput {
+			saver = newStreamSaver(savePartialConfig)
+		} else if partialSaveEvery.enabled() {
+			saver = newPartialSaver(partialSaveEvery, speedtester.SystemClock, savePartialConfig)
+		}
+	}
+	tested := 0
+	allResults := make([]*speedtester.Result, 0, total+len(reusedResults))
+	banSkipped := make(map[string]int)
+	collect := func(result *speedtester.Result) {
+		allResults = append(allResults, result)
+		if result.ErrorClass == speedtester.ErrorClassSourceBan {
+			banSkipped[result.Source]++
+		}
+		if result.Suspect != "" {
+			fmt.Fprintf(os.Stderr, "%ssuspect measurement: %s: %s%s\n", colorYellow, result.ProxyName, result.Suspect, colorReset)
+		}
+		if result.Invalid != "" {
+			fmt.Fprintf(os.Stderr, "%sinvalid measurement: %s: %s%s\n", colorYellow, result.ProxyName, result.Invalid, colorReset)
 		}
-		bar := progressbar.Default(int64(len(allProxies)), title)
-		speedTester.TestProxies(allProxies, func(name string) {
-			//bar.Describe(title + " " + name)
-		},
+		if *onelineOutput {
+			fmt.Fprintln(console, formatOnelineResult(result, onelineColor))
+		}
+		if isProxyUsable(result) {
+			results = append(results, result)
+		} else if pins.match(result.ProxyConfig) {
+			// 固定的节点照常测试和展示，只是不受可用性过滤
+			results = append(results, result)
+			log.Infoln("%s is not useable but pinned, %v", result.ProxyName, result)
+		} else {
+			log.Infoln("%s is not useable, %v", result.ProxyName, result)
+			return
+		}
+		if live != nil {
+			live.add(result, len(allResults))
+		}
+	}
+	for _, result := range reusedResults {
+		collect(result)
+	}
+	// Ctrl-C 或 SIGTERM 时中断测试，已经测完的节点照常输出和保存；再按一次 Ctrl-C 直接退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	for _, allProxies := range sources {
+		speedTester.TestProxies(ctx, allProxies, bar,
 		func(result *speedtester.Result) {
-			bar.Add(1)
-			if isProxyUsable(result) {
-				results = append(results, result)
-			} else {
-				log.Infoln("%s is not useable, %v", result.ProxyName, result)
+			bar.Advance()
+			tested++
+			collect(result)
+			if hook != nil {
+				hook.run(result)
+			}
+			if saver != nil {
+				saver.observe(results)
 			}
 		})
-		bar.Finish()
-		fmt.Println("")
+	}
+	if ctx.Err() != nil {
+		bar.Complete("interrupted")
+		fmt.Fprintf(os.Stderr, "%sinterrupted, saving partial results of %d tested nodes%s\n", colorYellow, tested, colorReset)
+	} else {
+		bar.Complete("")
+	}
+	for source, n := range banSkipped {
+		fmt.Fprintf(os.Stderr, "%s%s: %d nodes skipped, source ban suspected%s\n", colorYellow, source, n, colorReset)
+	}
+	if saver != nil {
+		saver.stop()
+	}
+	if hook != nil {
+		hook.wait()
+		results = hook.filter(results, func(result *speedtester.Result) bool {
+			return pins.match(result.ProxyConfig)
+		})
 	}
 	log.Infoln("所有yaml文件测试完成✅")
+	if len(scenarios) > 0 {
+		evaluateScenarios(scenarios, allResults)
+	}
 	
-	sort.Slice(results, func(i, j int) bool {
-		if isProxyGood(results[i]) == isProxyGood(results[j]) {
-			return results[i].DownloadSpeed > results[j].DownloadSpeed
+	if *hysteresisMargin > 0 || *dropAfter > 1 {
+		var streaks map[string]int
+		if history != nil {
+			streaks = history.FailStreaks
 		}
-		return isProxyGood(results[i])
-	})
+		h := newHysteresis(*hysteresisMargin, *dropAfter, streaks, *outputPath, *goodOutputPath)
+		results = h.reconcile(allResults, results)
+		heldNodes = h.held
+		if history != nil {
+			history.FailStreaks = h.streaks
+		}
+	}
 
-	printResults(results)
+	if history != nil {
+		// 复用的结果已经在之前的运行里记录过，只记录本次实际测试的节点
+		freshResults := allResults[len(reusedResults):]
+		history.appendRun(runStart, freshResults, *historyRetention)
+		history.updateLatest(runStart, freshResults, *historyRetention)
+		history.updateSeen(runStart, allResults, *historyRetention)
+		appeared, disappeared := history.seenChurn(runStart, allResults)
+		fmt.Fprintf(os.Stderr, "%d nodes are new this week, %d disappeared\n", appeared, disappeared)
+		if err := history.save(*historyFilePath); err != nil {
+			log.Warnln("save history %s failed: %v", *historyFilePath, err)
+		}
+		if *peakHours != "" {
+			peak, _ := parseHourRange(*peakHours)
+			peakSpeeds = peakProfiles(history.Runs, peak, time.Local)
+		}
+	}
+
+	if *minAge > 1 && history != nil {
+		results = filterMinAge(results, *minAge, func(result *speedtester.Result) bool {
+			return pins.match(result.ProxyConfig)
+		})
+	}
+	speedtester.AssignSubnetPeers(allResults)
+	if *maxPerSubnet > 0 {
+		results = speedtester.LimitPerSubnet(results, *maxPerSubnet, func(result *speedtester.Result) bool {
+			return pins.match(result.ProxyConfig)
+		})
+	}
+	if *minCountries > 0 {
+		for _, result := range pickDiversity(results, *minCountries) {
+			result.DiversityPick = true
+		}
+	}
 
+	sortResults(results)
+	if *maxGoodNodes > 0 {
+		demoted := capGoodNodes(results, *maxGoodNodes, func(result *speedtester.Result) bool {
+			return pins.match(result.ProxyConfig)
+		})
+		demotedNodes = make(map[*speedtester.Result]bool, len(demoted))
+		for _, result := range demoted {
+			demotedNodes[result] = true
+		}
+		if len(demoted) > 0 {
+			fmt.Fprintf(os.Stderr, "%d good nodes demoted to -output by -max-good-nodes %d\n", len(demoted), *maxGoodNodes)
+		}
+	}
+
+	displayed := results
+	if *consoleMode == consoleDelta && previousRun != nil && !*onelineOutput && !*interactiveSave {
+		// 第一次运行没有可以比较的记录，仍然输出完整的表格
+		printConsoleDiff(diffConsole(previousRun, allResults, *deltaThreshold))
+	} else if !*onelineOutput {
+		displayed = printResults(results)
+		if groupKey, _ := groupKeyFunc(*groupBy); groupKey != nil {
+			printGroupStats(buildGroupStats(allResults, groupKey))
+		}
+		if *interactiveSave {
+			selected, ok := interactiveSelect(os.Stdin, os.Stderr, displayed)
+			if !ok {
+				fmt.Fprintln(os.Stderr, "nothing saved")
+				restorePartialOutputs()
+				return
+			}
+			results = selected
+		}
+	}
+	printSummary(allResults, results)
+	printScenarioSummary(scenarios, allResults)
+	if *printThresholds {
+		printThresholdReport(os.Stderr, buildThresholdReport(allResults))
+	}
+	if len(config.CCSweep) > 0 {
+		results = pickCCWinners(results)
+	}
+
+	if *badOutputPath != "" {
+		saveBadConfig(*badOutputPath, allResults)
+	}
+	var cards []*scorecard
+	if *scorecardPath != "" {
+		weights, _ := parseScoreWeights(*scoreWeights)
+		cards = buildScorecards(reports, allResults, history, weights)
+		if err := saveScorecards(*scorecardPath, cards); err != nil {
+			log.Fatalln("save scorecard %s failed: %v", *scorecardPath, err)
+		}
+		fmt.Fprintf(console, "save scorecard to: %s\n", *scorecardPath)
+	}
+	if *shareURL != "" || *shareDryRun {
+		if err := shareResults(*shareURL, *shareToken, *shareDryRun, allResults); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", colorYellow, err, colorReset)
+		}
+	}
+	// 没有可用节点时也写一个只有表头的文件，说明这次运行确实发生过
+	if *csvPath != "" {
+		columns, _ := parseCSVColumns(*csvColumnsSpec)
+		data, err := formatCSVOutput(displayed, columns)
+		if err == nil {
+			err = writeArtifact(*csvPath, data, 0o644)
+		}
+		if err != nil {
+			log.Fatalln("save %s failed: %v", *csvPath, err)
+		}
+		fmt.Fprintf(console, "save csv to: %s\n", *csvPath)
+	}
+	if *resultsJSONPath != "" {
+		data, err := marshalResultsFile(*vantageName, runStart, allResults)
+		if err == nil {
+			err = writeArtifact(*resultsJSONPath, data, 0o644)
+		}
+		if err != nil {
+			log.Fatalln("save %s failed: %v", *resultsJSONPath, err)
+		}
+		fmt.Fprintf(console, "save results to: %s\n", *resultsJSONPath)
+	}
+	if ghSummaryFlag.enabled {
+		// annotation 和表格一样写到 console：标准输出被输出文件占用时写到标准错误，GitHub Actions 两边都会识别
+		if err := writeGitHubSummary(console, reports, allResults, displayed, cards, *minUsable); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v%s\n", colorYellow, err, colorReset)
+		}
+	}
 	if len(results) == 0 {
+		saveEmptyRun(allResults)
+		printFunnel(reports, tested)
 		log.Fatalln("测试结束没有找到任何可用节点")
 	}
+	var server *subServer
+	if *listenAddr != "" {
+		server = &subServer{token: *subToken, updateInterval: *subUpdateInterval}
+		userinfos := make([]string, 0, len(reports))
+		for _, report := range reports {
+			userinfos = append(userinfos, report.SubscriptionUserinfo)
+		}
+		// saveConfig 会原地重排 results，这里先复制一份
+		server.update(append([]*speedtester.Result(nil), results...), mergeSubscriptionUserinfo(userinfos))
+	}
+	if *outputTxtPath != "" {
+		if err := writeArtifact(*outputTxtPath, formatTxtOutput(time.Now(), results), 0o644); err != nil {
+			log.Fatalln("save %s failed: %v", *outputTxtPath, err)
+		}
+		fmt.Fprintf(console, "save node list to: %s\n", *outputTxtPath)
+	}
+	if len(scenarioOutputs) > 0 {
+		saveScenarioOutputs(scenarioOutputs, allResults)
+	}
+	if *outputPerSource != "" {
+		saveConfigPerSource(*outputPerSource, reports, results, *preserveSource)
+	}
 	if *outputPath != "" || *goodOutputPath != "" {
-		saveConfig(results)
+		saveConfig(results, allResults)
+	}
+	// 输出文件照常写出，定时任务仍然可以用这次的结果，只是以失败结束。
+	// 固定保留和迟滞保留的节点不算可用；-listen 时照常提供订阅，只输出错误
+	if usable := countUsable(allResults); usable < *minUsable {
+		if server == nil {
+			log.Fatalln("%d usable nodes, fewer than -min-usable %d", usable, *minUsable)
+		}
+		fmt.Fprintf(os.Stderr, "%s%d usable nodes, fewer than -min-usable %d%s\n", colorRed, usable, *minUsable, colorReset)
+	}
+	if server != nil {
+		fmt.Fprintf(os.Stderr, "serving subscription at http://%s/sub\n", *listenAddr)
+		if err := http.ListenAndServe(*listenAddr, server.handler()); err != nil {
+			log.Fatalln("listen %s failed: %v", *listenAddr, err)
+		}
 	}
 }
 
 func isProxyUsable(result *speedtester.Result) bool {
-	return (result.Latency <= *maxLatency || *maxLatency == 0) && result.ExtraURLConnectivity && 
-	(result.ExtraURLOpenSpeed >= *openSpeedThreshold * 1024 * 1024 || *extraConnectURL == "") &&
-	result.DownloadSpeed >= *minSpeed * 1024 * 1024 && 
-	(result.ExtraDownloadSpeed >= *minSpeed * 1024 * 1024 || *extraDownloadURL == "")
+	return unusableReason(result) == ""
 }
 
+// countUsable 统计通过测试的节点数，不包括只是被 -pin 或迟滞保留的节点
+func countUsable(results []*speedtester.Result) int {
+	n := 0
+	for _, result := range results {
+		if isProxyUsable(result) {
+			n++
+		}
+	}
+	return n
+}
+
+// unusableReason 返回节点不可用的第一个原因，可用时返回空字符串
+func unusableReason(result *speedtester.Result) string {
+	return unusableReasonWith(result, thresholdsFor(result))
+}
+
+// unusableReasonWith 按给定的阈值判定节点，任务接口和 -threshold-report 用它替换部分阈值
+func unusableReasonWith(result *speedtester.Result, t thresholds) string {
+	switch {
+	case result.Invalid != "":
+		return "invalid measurement: " + result.Invalid
+	case result.DirectLeak:
+		return "direct leak: exit ip " + result.ExitIP + " is this machine's public ip"
+	case result.Latency == 0 || result.PacketLoss == 100:
+		if result.Error != "" {
+			return "unreachable: " + result.Error
+		}
+		return "unreachable"
+	case result.Latency > t.maxLatency && t.maxLatency != 0:
+		return fmt.Sprintf("latency %s > %s", result.FormatLatency(), t.maxLatency)
+	case t.maxNewConnLatency != 0 && (result.LatencyNewConn == 0 || result.LatencyNewConn > t.maxNewConnLatency):
+		return fmt.Sprintf("new connection latency %dms > %s", result.LatencyNewConn.Milliseconds(), t.maxNewConnLatency)
+	case !result.ExtraURLConnectivity:
+		return "extra url blocked"
+	case result.ExtraURLOpenSpeed < *openSpeedThreshold * 1024 * 1024 && *extraConnectURL != "":
+		return "extra url open speed " + result.FormatExtraURLOpenSpeed()
+	case result.DownloadSpeed < t.minSpeed * 1024 * 1024 && !*fastMode:
+		// -fast 不测下载，速度阈值对它没有意义
+		return "download speed " + result.FormatDownloadSpeed()
+	case result.ExtraDownloadSpeed < t.minSpeed * 1024 * 1024 && *extraDownloadURL != "":
+		return "extra download speed " + result.FormatExtraDownloadSpeed()
+	case result.UploadBlocked && t.minUploadSpeed > 0 && !*allowUploadBlocked:
+		return "upload blocked"
+	case !result.UploadBlocked && result.UploadSize > 0 && result.UploadSpeed < t.minUploadSpeed * 1024 * 1024:
+		// 上传被封锁的节点速度是 0，慢到不达标的节点同样过滤，没有测上传（-fast、下载不达标）的不检查
+		return "upload speed " + result.FormatUploadSpeed()
+	case *requireSSHVerified && result.ProxyType == "Ssh" && !result.SSHVerified:
+		return "ssh host key not verified"
+	case !isASNAllowed(result.ExitASN):
+		return fmt.Sprintf("asn AS%d not allowed", result.ExitASN)
+	case *requireUploadIntegrity && !result.UploadIntegrity:
+		return "upload integrity " + result.UploadIntegrityStatus
+	case *requireWebSocket && !result.WebSocketOK:
+		return "websocket: " + result.WebSocketError
+	}
+	return ""
+}
+
+// isASNAllowed 未知 ASN 的节点不会被 -exclude-asn 排除，但无法通过 -asn-allowlist
+func isASNAllowed(asn int) bool {
+	if excludedASNs[asn] {
+		return false
+	}
+	return len(allowedASNs) == 0 || allowedASNs[asn]
+}
 
+func parseASNList(list string) (map[int]bool, error) {
+	asns := make(map[int]bool)
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		asn, err := speedtester.ParseASN(item)
+		if err != nil {
+			return nil, err
+		}
+		asns[asn] = true
+	}
+	return asns, nil
+}
+
+
+// isProxyGood 测量结果可疑的节点不会被判定为优质节点，为了国家多样性挑选的节点总是优质节点
 func isProxyGood(result *speedtester.Result) bool {
-	return isProxyUsable(result) && result.DownloadSpeed >= *goodDownloadSpeedThreshold &&
-	(result.ExtraDownloadSpeed >= *goodDownloadSpeedThreshold || *extraDownloadURL == "")
+	return isProxyGoodWith(result, thresholdsFor(result))
+}
+
+func isProxyGoodWith(result *speedtester.Result, t thresholds) bool {
+	if demotedNodes != nil && demotedNodes[result] {
+		return false
+	}
+	if result.DiversityPick {
+		return true
+	}
+	if *minSustainedSpeed > 0 && result.SustainedSpeed < *minSustainedSpeed * 1024 * 1024 {
+		return false
+	}
+	return unusableReasonWith(result, t) == "" && result.Suspect == "" && !result.ContentTampering && result.DownloadSpeed >= t.goodSpeed * 1024 * 1024 &&
+	(result.ExtraDownloadSpeed >= t.goodSpeed * 1024 * 1024 || *extraDownloadURL == "")
 }
 
 
@@ -206,7 +985,7 @@ func getAllConfigPath(configPaths string, skipPaths string) ([]string, error) {
 		})
 
 		if err != nil {
-			log.Fatalln("error walking directory: %w", err)
+			log.Fatalln("error walking directory: %v", err)
 		}
 	}
 
@@ -236,9 +1015,53 @@ func isSkipped(path string, skipPaths []string) bool {
 }
 
 
-func printResults(results []*speedtester.Result) {
-	table := tablewriter.NewWriter(os.Stdout)
+// printResults 输出结果表格，返回表格中的节点顺序，与序号一一对应
+func printResults(results []*speedtester.Result) []*speedtester.Result {
+	table := tablewriter.NewWriter(console)
+
+	// 查询了出口 IP 的地理位置时显示地区
+	showRegion := slices.ContainsFunc(results, func(result *speedtester.Result) bool { return result.CountryCode != "" })
+	headers := resultHeaders(showRegion)
+	table.SetHeader(headers)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+	for i, width := range resultColMinWidths() {
+		table.SetColMinWidth(i, width)
+	}
+
+	groupKey, _ := groupKeyFunc(*groupBy)
+	if groupKey != nil {
+		results = sortByGroup(results, groupKey)
+	}
+	lastGroup := ""
+	now := time.Now()
+	for i, result := range results {
+		idStr := fmt.Sprintf("%d.", i+1)
+		if groupKey != nil && (i == 0 || groupKey(result) != lastGroup) {
+			lastGroup = groupKey(result)
+			separator := make([]string, len(headers))
+			separator[1] = "── " + lastGroup + " ──"
+			table.Append(separator)
+		}
+		table.Append(resultRow(result, idStr, showRegion, now))
+	}
+	fmt.Fprintln(console)
+	table.Render()
+	fmt.Fprintln(console)
+	return results
+}
 
+// resultHeaders 返回结果表格的列名，-live 的逐行输出使用相同的列
+func resultHeaders(showRegion bool) []string {
 	var headers []string
 	if *fastMode {
 		headers = []string{
@@ -262,179 +1085,423 @@ func printResults(results []*speedtester.Result) {
 			"自定义资源下载速度",
 		}
 	}
-	table.SetHeader(headers)
-	table.SetAutoWrapText(false)
-	table.SetAutoFormatHeaders(true)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetCenterSeparator("")
-	table.SetColumnSeparator("")
-	table.SetRowSeparator("")
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetTablePadding("\t")
-	table.SetNoWhiteSpace(true)
-	table.SetColMinWidth(0, 4)  // 序号
-	table.SetColMinWidth(1, 20) // 节点名称
-	table.SetColMinWidth(2, 8)  // 类型
-	table.SetColMinWidth(3, 8)  // 延迟
-	if !*fastMode {
-		table.SetColMinWidth(4, 8)  // 抖动
-		table.SetColMinWidth(5, 8)  // 丢包率
-		table.SetColMinWidth(6, 12) // 下载速度
-		table.SetColMinWidth(7, 12) // 上传速度
+	if showRegion {
+		headers = append(headers, "地区")
+	}
+	if peakSpeeds != nil {
+		headers = append(headers, "高峰速度")
+	}
+	if *latencyConnection == speedtester.LatencyConnBoth {
+		headers = append(headers, "新建连接延迟")
+	}
+	if *maxPerSubnet > 0 {
+		headers = append(headers, "同网段节点")
+	}
+	if *sustained > 0 {
+		headers = append(headers, "持续速度")
+	}
+	if *closeLatency {
+		headers = append(headers, "关闭延迟")
 	}
+	if *clashDelay {
+		headers = append(headers, "Clash延迟")
+	}
+	if *onlyChanged {
+		headers = append(headers, "结果时间")
+	}
+	if previousRecords != nil {
+		headers = append(headers, "较上次")
+	}
+	return headers
+}
 
-	for i, result := range results {
-		idStr := fmt.Sprintf("%d.", i+1)
+// resultColMinWidths 返回结果表格前几列的最小宽度
+func resultColMinWidths() []int {
+	widths := []int{
+		4,  // 序号
+		20, // 节点名称
+		8,  // 类型
+		8,  // 延迟
+	}
+	if !*fastMode {
+		widths = append(widths,
+			8,  // 抖动
+			8,  // 丢包率
+			12, // 下载速度
+			12, // 上传速度
+		)
+	}
+	return widths
+}
 
-		// 延迟颜色
-		latencyStr := result.FormatLatency()
-		if result.Latency > 0 {
-			if result.Latency < 800*time.Millisecond {
-				latencyStr = colorGreen + latencyStr + colorReset
-			} else if result.Latency < 1500*time.Millisecond {
-				latencyStr = colorYellow + latencyStr + colorReset
-			} else {
-				latencyStr = colorRed + latencyStr + colorReset
-			}
+// resultRow 返回一个节点在结果表格中的一行，带颜色
+func resultRow(result *speedtester.Result, idStr string, showRegion bool, now time.Time) []string {
+	// 延迟颜色
+	latencyStr := result.FormatLatency()
+	if result.Latency > 0 {
+		if result.Latency < 800*time.Millisecond {
+			latencyStr = colorGreen + latencyStr + colorReset
+		} else if result.Latency < 1500*time.Millisecond {
+			latencyStr = colorYellow + latencyStr + colorReset
 		} else {
 			latencyStr = colorRed + latencyStr + colorReset
 		}
+	} else {
+		latencyStr = colorRed + latencyStr + colorReset
+	}
+	if result.GeoSuspect {
+		latencyStr = colorYellow + result.FormatLatency() + " ⚠" + colorReset
+	}
 
-
-		jitterStr := result.FormatJitter()
-		if result.Jitter > 0 {
-			if result.Jitter < 800*time.Millisecond {
-				jitterStr = colorGreen + jitterStr + colorReset
-			} else if result.Jitter < 1500*time.Millisecond {
-				jitterStr = colorYellow + jitterStr + colorReset
-			} else {
-				jitterStr = colorRed + jitterStr + colorReset
-			}
+	jitterStr := result.FormatJitter()
+	if result.Jitter > 0 {
+		if result.Jitter < 800*time.Millisecond {
+			jitterStr = colorGreen + jitterStr + colorReset
+		} else if result.Jitter < 1500*time.Millisecond {
+			jitterStr = colorYellow + jitterStr + colorReset
 		} else {
 			jitterStr = colorRed + jitterStr + colorReset
 		}
+	} else {
+		jitterStr = colorRed + jitterStr + colorReset
+	}
 
-		// 丢包率颜色
-		packetLossStr := result.FormatPacketLoss()
-		if result.PacketLoss < 10 {
-			packetLossStr = colorGreen + packetLossStr + colorReset
-		} else if result.PacketLoss < 20 {
-			packetLossStr = colorYellow + packetLossStr + colorReset
-		} else {
-			packetLossStr = colorRed + packetLossStr + colorReset
-		}
+	// 丢包率颜色
+	packetLossStr := result.FormatPacketLoss()
+	if result.PacketLoss < 10 {
+		packetLossStr = colorGreen + packetLossStr + colorReset
+	} else if result.PacketLoss < 20 {
+		packetLossStr = colorYellow + packetLossStr + colorReset
+	} else {
+		packetLossStr = colorRed + packetLossStr + colorReset
+	}
 
-		// 下载速度颜色 (以MB/s为单位判断)
-		downloadSpeed := result.DownloadSpeed / (1024 * 1024)
-		downloadSpeedStr := result.FormatDownloadSpeed()
-		if downloadSpeed >= *goodDownloadSpeedThreshold {
-			downloadSpeedStr = colorGreen + downloadSpeedStr + colorReset
-		} else if downloadSpeed >= *minSpeed + 0.1 {
-			downloadSpeedStr = colorYellow + downloadSpeedStr + colorReset
-		} else {
-			downloadSpeedStr = colorRed + downloadSpeedStr + colorReset
-		}
+	// 下载速度颜色 (以MB/s为单位判断)，阈值和判定一样考虑 -sources 的覆盖
+	t := thresholdsFor(result)
+	downloadSpeed := result.DownloadSpeed / (1024 * 1024)
+	downloadSpeedStr := result.FormatDownloadSpeed()
+	if result.DownloadStreams > 0 {
+		downloadSpeedStr += fmt.Sprintf(" (%dx)", result.DownloadStreams)
+	}
+	if result.RateLimited {
+		// 限速时测出的速度不代表节点的能力
+		downloadSpeedStr += " (rate-limited)"
+	}
+	if downloadSpeed >= t.goodSpeed {
+		downloadSpeedStr = colorGreen + downloadSpeedStr + colorReset
+	} else if downloadSpeed >= t.minSpeed + 0.1 {
+		downloadSpeedStr = colorYellow + downloadSpeedStr + colorReset
+	} else {
+		downloadSpeedStr = colorRed + downloadSpeedStr + colorReset
+	}
 
-		// 上传速度颜色
-		uploadSpeed := result.UploadSpeed / (1024 * 1024)
-		uploadSpeedStr := result.FormatUploadSpeed()
-		if uploadSpeed >= 0.5 {
-			uploadSpeedStr = colorGreen + uploadSpeedStr + colorReset
-		} else if uploadSpeed >= 0.2 {
-			uploadSpeedStr = colorYellow + uploadSpeedStr + colorReset
-		} else {
-			uploadSpeedStr = colorRed + uploadSpeedStr + colorReset
-		}
+	// 上传速度颜色
+	uploadSpeed := result.UploadSpeed / (1024 * 1024)
+	uploadSpeedStr := result.FormatUploadSpeed()
+	if result.RateLimited {
+		uploadSpeedStr += " (rate-limited)"
+	}
+	if result.UploadBlocked {
+		uploadSpeedStr = colorRed + "BLOCKED" + colorReset
+	} else if uploadSpeed >= 0.5 {
+		uploadSpeedStr = colorGreen + uploadSpeedStr + colorReset
+	} else if uploadSpeed >= 0.2 {
+		uploadSpeedStr = colorYellow + uploadSpeedStr + colorReset
+	} else {
+		uploadSpeedStr = colorRed + uploadSpeedStr + colorReset
+	}
 
-		//自定义网站连通性
-		extraURLConnectivityStr := result.FormatExtraURLConnectivity()
-		if result.ExtraURLConnectivity {
-			extraURLConnectivityStr = colorGreen + extraURLConnectivityStr + colorReset
-		} else {
-			extraURLConnectivityStr = colorRed + extraURLConnectivityStr + colorReset
-		}
+	//自定义网站连通性
+	extraURLConnectivityStr := result.FormatExtraURLConnectivity()
+	if result.ExtraURLConnectivity {
+		extraURLConnectivityStr = colorGreen + extraURLConnectivityStr + colorReset
+	} else {
+		extraURLConnectivityStr = colorRed + extraURLConnectivityStr + colorReset
+	}
 
-		
-		urlOpenSpeed := result.ExtraURLOpenSpeed / (1024 * 1024)
-		extraURLOpenSpeedStr := result.FormatExtraURLOpenSpeed()
-		if urlOpenSpeed >= *openSpeedThreshold * 3 {
-			extraURLOpenSpeedStr = colorGreen + extraURLOpenSpeedStr + colorReset
-		} else if urlOpenSpeed >= *openSpeedThreshold * 2 {
-			extraURLOpenSpeedStr = colorYellow + extraURLOpenSpeedStr + colorReset
-		} else {
-			extraURLOpenSpeedStr = colorRed + extraURLOpenSpeedStr + colorReset
-		}
+	
+	urlOpenSpeed := result.ExtraURLOpenSpeed / (1024 * 1024)
+	extraURLOpenSpeedStr := result.FormatExtraURLOpenSpeed()
+	if urlOpenSpeed >= *openSpeedThreshold * 3 {
+		extraURLOpenSpeedStr = colorGreen + extraURLOpenSpeedStr + colorReset
+	} else if urlOpenSpeed >= *openSpeedThreshold * 2 {
+		extraURLOpenSpeedStr = colorYellow + extraURLOpenSpeedStr + colorReset
+	} else {
+		extraURLOpenSpeedStr = colorRed + extraURLOpenSpeedStr + colorReset
+	}
 
-		extraDownloadSpeed := result.ExtraDownloadSpeed / (1024 * 1024)
-		extraDownloadSpeedStr := result.FormatExtraDownloadSpeed()
-		if extraDownloadSpeed >= *goodDownloadSpeedThreshold {
-			extraDownloadSpeedStr = colorGreen + extraDownloadSpeedStr + colorReset
-		} else if extraDownloadSpeed >= *minSpeed + 0.1 {
-			extraDownloadSpeedStr = colorYellow + extraDownloadSpeedStr + colorReset
-		} else {
-			extraDownloadSpeedStr = colorRed + extraDownloadSpeedStr + colorReset
-		}
+	extraDownloadSpeed := result.ExtraDownloadSpeed / (1024 * 1024)
+	extraDownloadSpeedStr := result.FormatExtraDownloadSpeed()
+	if extraDownloadSpeed >= t.goodSpeed {
+		extraDownloadSpeedStr = colorGreen + extraDownloadSpeedStr + colorReset
+	} else if extraDownloadSpeed >= t.minSpeed + 0.1 {
+		extraDownloadSpeedStr = colorYellow + extraDownloadSpeedStr + colorReset
+	} else {
+		extraDownloadSpeedStr = colorRed + extraDownloadSpeedStr + colorReset
+	}
+
+	nameStr := result.ProxyName
+	if pins.match(result.ProxyConfig) {
+		nameStr += " (pinned)"
+	}
+	if result.Suspect != "" {
+		nameStr += " (suspect)"
+	}
+	if heldNodes[speedtester.NodeKey(result.ProxyConfig)] {
+		nameStr += " (held)"
+	}
+	if result.DiversityPick {
+		nameStr += " (diversity pick)"
+	}
+	if demotedNodes[result] {
+		nameStr += " (demoted by cap)"
+	}
+	if result.Attempts > 1 {
+		nameStr += fmt.Sprintf(" (%d tries)", result.Attempts)
+	}
+	if result.ExtraHops > 0 {
+		nameStr += colorYellow + fmt.Sprintf(" (⚠ +%d hops)", result.ExtraHops) + colorReset
+	}
+	if result.DirectLeak {
+		nameStr = colorRed + nameStr + " (direct leak)" + colorReset
+	}
 
-		var row []string
-		if *fastMode {
-			row = []string{
-				idStr,
-				result.ProxyName,
-				result.ProxyType,
-				latencyStr,
+	var row []string
+	if *fastMode {
+		row = []string{
+			idStr,
+			nameStr,
+			result.ProxyType,
+			latencyStr,
+		}
+	} else {
+		row = []string{
+			idStr,
+			nameStr,
+			result.ProxyType,
+			latencyStr,
+			jitterStr,
+			packetLossStr,
+			downloadSpeedStr,
+			uploadSpeedStr,
+			extraURLConnectivityStr,
+			extraURLOpenSpeedStr,
+			extraDownloadSpeedStr,
+		}
+	}
+	if showRegion {
+		row = append(row, formatRegion(result))
+	}
+	if peakSpeeds != nil {
+		peakSpeedStr := "N/A"
+		if stats := peakSpeeds[speedtester.NodeKey(result.ProxyConfig)]; stats != nil && stats.PeakSamples > 0 {
+			peakSpeedStr = fmt.Sprintf("%s (%d)", speedtester.FormatSpeed(stats.PeakSpeed), stats.PeakSamples)
+		}
+		row = append(row, peakSpeedStr)
+	}
+	if *latencyConnection == speedtester.LatencyConnBoth {
+		newConnLatencyStr := "N/A"
+		if result.LatencyNewConn > 0 {
+			newConnLatencyStr = fmt.Sprintf("%dms", result.LatencyNewConn.Milliseconds())
+		}
+		row = append(row, newConnLatencyStr)
+	}
+	if *maxPerSubnet > 0 {
+		subnetPeersStr := "N/A"
+		if result.ExitIP != "" {
+			subnetPeersStr = fmt.Sprintf("%d", result.ExitSubnetPeers)
+		}
+		row = append(row, subnetPeersStr)
+	}
+	if *sustained > 0 {
+		sustainedStr := "N/A"
+		if result.SustainedSpeed > 0 {
+			sustainedStr = fmt.Sprintf("%s (%.0f%%)", speedtester.FormatSpeed(result.SustainedSpeed), result.ThrottleRatio*100)
+			// 持续速度不到突发速度一半的节点基本可以确定被限速了
+			if result.ThrottleRatio < 0.5 {
+				sustainedStr = colorRed + sustainedStr + colorReset
 			}
-		} else {
-			row = []string{
-				idStr,
-				result.ProxyName,
-				result.ProxyType,
-				latencyStr,
-				jitterStr,
-				packetLossStr,
-				downloadSpeedStr,
-				uploadSpeedStr,
-				extraURLConnectivityStr,
-				extraURLOpenSpeedStr,
-				extraDownloadSpeedStr,
+		}
+		row = append(row, sustainedStr)
+	}
+	if *closeLatency {
+		closeLatencyStr := "N/A"
+		switch {
+		case result.CloseTimedOut:
+			closeLatencyStr = colorRed + "> " + result.CloseLatency.String() + " ⚠" + colorReset
+		case result.CloseLatency > *maxCloseLatency:
+			closeLatencyStr = colorYellow + fmt.Sprintf("%dms ⚠", result.CloseLatency.Milliseconds()) + colorReset
+		case result.CloseLatency > 0:
+			closeLatencyStr = fmt.Sprintf("%dms", result.CloseLatency.Milliseconds())
+		}
+		row = append(row, closeLatencyStr)
+	}
+	if *clashDelay {
+		clashDelayStr := "N/A"
+		if result.ClashDelay > 0 {
+			clashDelayStr = fmt.Sprintf("%dms", result.ClashDelay.Milliseconds())
+		}
+		row = append(row, clashDelayStr)
+	}
+	if *onlyChanged {
+		row = append(row, formatResultAge(now, result.TestedAt))
+	}
+	if previousRecords != nil {
+		row = append(row, formatHistoryDelta(result))
+	}
+	return row
+}
+
+// formatHistoryDelta 返回下载速度和延迟相对上次运行的变化，变好为绿色、变差为红色。
+// 上次没有出现的节点显示 new，任意一次没有测出结果的项显示 N/A
+func formatHistoryDelta(result *speedtester.Result) string {
+	prev, ok := previousRecords[speedtester.NodeKey(result.ProxyConfig)]
+	if !ok {
+		return colorGreen + "new" + colorReset
+	}
+	colored := func(s string, better, worse bool) string {
+		switch {
+		case better:
+			return colorGreen + s + colorReset
+		case worse:
+			return colorRed + s + colorReset
+		}
+		return s
+	}
+	var parts []string
+	if !*fastMode {
+		downloadDelta := "N/A"
+		if result.DownloadSpeed > 0 && prev.DownloadSpeed > 0 {
+			diff := result.DownloadSpeed - prev.DownloadSpeed
+			sign := "+"
+			if diff < 0 {
+				sign = "-"
 			}
-			table.Append(row)
+			downloadDelta = colored(sign+speedtester.FormatSpeed(math.Abs(diff)), diff > 0, diff < 0)
 		}
+		parts = append(parts, downloadDelta)
 	}
-	fmt.Println()
-	table.Render()
-	fmt.Println()
+	latencyDelta := "N/A"
+	if result.Latency > 0 && prev.LatencyMs > 0 {
+		diff := result.Latency.Milliseconds() - prev.LatencyMs
+		latencyDelta = colored(fmt.Sprintf("%+dms", diff), diff < 0, diff > 0)
+	}
+	parts = append(parts, latencyDelta)
+	return strings.Join(parts, " / ")
 }
 
-func doSaveConfig(results []*speedtester.Result, absPath string) {
-	if len(results) == 0 {
-		log.Warnln("%s 无任何有效节点信息", absPath)
-		return
+// peakSpeedOf 返回节点在高峰时段的平均下载速度，没有高峰时段的样本时返回 0
+func peakSpeedOf(result *speedtester.Result) float64 {
+	if stats := peakSpeeds[speedtester.NodeKey(result.ProxyConfig)]; stats != nil {
+		return stats.PeakSpeed
+	}
+	return 0
+}
+
+// pickCCWinners 对 -cc-sweep 复制出来的变体，每个节点只保留下载速度最快的一个，速度相同时取延迟低的。
+// 变体的来源和配置里的节点名都与原节点相同，以此分组
+func pickCCWinners(results []*speedtester.Result) []*speedtester.Result {
+	winners := make(map[string]*speedtester.Result)
+	group := func(result *speedtester.Result) string {
+		name, _ := result.ProxyConfig["name"].(string)
+		return result.Source + "\x00" + name
+	}
+	for _, result := range results {
+		if result.CongestionControl == "" {
+			continue
+		}
+		best, ok := winners[group(result)]
+		if !ok || result.DownloadSpeed > best.DownloadSpeed ||
+			(result.DownloadSpeed == best.DownloadSpeed && result.Latency < best.Latency) {
+			winners[group(result)] = result
+		}
 	}
-	proxies := make([]map[string]any, 0)
+	picked := make([]*speedtester.Result, 0, len(results))
 	for _, result := range results {
-		proxies = append(proxies, result.ProxyConfig)
+		if result.CongestionControl == "" || winners[group(result)] == result {
+			picked = append(picked, result)
+		}
 	}
+	return picked
+}
 
-	config := &speedtester.RawConfig{
-		Proxies: proxies,
+func doSaveConfig(results []*speedtester.Result, absPath string) {
+	if len(results) == 0 {
+		log.Warnln("%s 无任何有效节点信息", absPath)
+		if err := restorePartialOutput(absPath); err != nil {
+			log.Fatalln("restore %s failed: %v", absPath, err)
+		}
+		return
 	}
-	yamlData, err := yaml.Marshal(config)
+	yamlData, err := marshalResults(results)
 	if err != nil {
 		log.Fatalln("convert yaml: %s failed: %v", absPath, err)
 	}
-	err = os.WriteFile(absPath, yamlData, 0o644)
+	previous, ok := outputsBeforeRun[absPath]
+	if !ok && absPath != stdoutPath {
+		previous, err = loadPreviousProxies(absPath)
+		if err != nil {
+			log.Warnln("parse previous config %s failed, skip diff: %v", absPath, err)
+		}
+	}
+	err = writeArtifact(absPath, yamlData, 0o644)
 	if err == nil {
-		fmt.Printf("\nsave good config file to: %s\n", absPath)
+		delete(outputBackups, absPath)
+		fmt.Fprintf(console, "\nsave good config file to: %s\n", absPath)
+		if previous != nil {
+			fmt.Fprintf(console, "changes since last run: %s\n", diffOutput(previous, results))
+		}
 	} else {
 		log.Fatalln("save config file: %s failed: %v", absPath, err)
 	}
 }
 
-func saveConfig(results []*speedtester.Result) {
+// marshalResults 生成输出文件的内容，为国家多样性挑选的节点带上注释
+func marshalResults(results []*speedtester.Result) ([]byte, error) {
+	proxies, comments := outputProxyConfigs(results)
+	return marshalAnnotatedProxies(proxies, comments)
+}
+
+// outputProxyConfigs 返回写进输出文件的节点配置和每个节点的注释，按 -rename 改名、按 -strip-volatile 去掉易变字段。
+// /sub 也用它生成订阅，保证和输出文件里的节点一致
+func outputProxyConfigs(results []*speedtester.Result) ([]map[string]any, []string) {
+	proxies := make([]map[string]any, 0, len(results))
+	comments := make([]string, 0, len(results))
+	names := make(map[string]int, len(results))
+	var taken map[string]bool
+	if *stripVolatile && !*renameNodes {
+		taken = make(map[string]bool, len(results))
+		for _, result := range results {
+			name, _ := result.ProxyConfig["name"].(string)
+			taken[name] = true
+		}
+	}
+	for _, result := range results {
+		proxy := result.ProxyConfig
+		if *renameNodes {
+			proxy = renameProxy(result, names)
+		}
+		if *stripVolatile {
+			proxy = stripVolatileProxy(proxy, taken)
+		}
+		proxies = append(proxies, proxy)
+		comment := ""
+		if result.DiversityPick {
+			comment = "diversity pick: " + result.CountryCode
+		}
+		if demotedNodes[result] {
+			comment = "good, demoted by cap"
+		}
+		comments = append(comments, comment)
+	}
+	return proxies, comments
+}
+
+// saveConfig 把优质节点和其余可用节点分别写到 -good-output 和 -output。
+// 没有优质节点时在 -good-output 旁边写一份说明，默认不覆盖原来的优质节点文件，allResults 用于说明里的统计
+func saveConfig(results []*speedtester.Result, allResults []*speedtester.Result) {
 	if *goodOutputPath != "" {
-		absGoodOutputPath, _ := filepath.Abs(*goodOutputPath)
+		absGoodOutputPath := artifactPath(*goodOutputPath)
 		goodResults := make([]*speedtester.Result, 0)
 		i := 0
 		for _, result := range results {
@@ -445,70 +1512,84 @@ func saveConfig(results []*speedtester.Result) {
 				i++
 			}
 		}
-		doSaveConfig(goodResults, absGoodOutputPath)
+		saveGoodConfig(goodResults, absGoodOutputPath, allResults)
 		for j := i; j < len(results); j++ {
 			results[j] = nil
 		}
 		results = results[:i]
 	}
 	if *outputPath != "" {
-		absOutputPath, _ := filepath.Abs(*outputPath)
+		absOutputPath := artifactPath(*outputPath)
 		doSaveConfig(results, absOutputPath)
 	}
 }
 
-type IPLocation struct {
-	Country     string `json:"country"`
-	CountryCode string `json:"countryCode"`
-}
-
-var countryFlags = map[string]string{
-	"US": "🇺🇸", "CN": "🇨🇳", "GB": "🇬🇧", "UK": "🇬🇧", "JP": "🇯🇵", "DE": "🇩🇪", "FR": "🇫🇷", "RU": "🇷🇺",
-	"SG": "🇸🇬", "HK": "🇭🇰", "TW": "🇹🇼", "KR": "🇰🇷", "CA": "🇨🇦", "AU": "🇦🇺", "NL": "🇳🇱", "IT": "🇮🇹",
-	"ES": "🇪🇸", "SE": "🇸🇪", "NO": "🇳🇴", "DK": "🇩🇰", "FI": "🇫🇮", "CH": "🇨🇭", "AT": "🇦🇹", "BE": "🇧🇪",
-	"BR": "🇧🇷", "IN": "🇮🇳", "TH": "🇹🇭", "MY": "🇲🇾", "VN": "🇻🇳", "PH": "🇵🇭", "ID": "🇮🇩", "UA": "🇺🇦",
-	"TR": "🇹🇷", "IL": "🇮🇱", "AE": "🇦🇪", "SA": "🇸🇦", "EG": "🇪🇬", "ZA": "🇿🇦", "NG": "🇳🇬", "KE": "🇰🇪",
-	"RO": "🇷🇴", "PL": "🇵🇱", "CZ": "🇨🇿", "HU": "🇭🇺", "BG": "🇧🇬", "HR": "🇭🇷", "SI": "🇸🇮", "SK": "🇸🇰",
-	"LT": "🇱🇹", "LV": "🇱🇻", "EE": "🇪🇪", "PT": "🇵🇹", "GR": "🇬🇷", "IE": "🇮🇪", "LU": "🇱🇺", "MT": "🇲🇹",
-	"CY": "🇨🇾", "IS": "🇮🇸", "MX": "🇲🇽", "AR": "🇦🇷", "CL": "🇨🇱", "CO": "🇨🇴", "PE": "🇵🇪", "VE": "🇻🇪",
-	"EC": "🇪🇨", "UY": "🇺🇾", "PY": "🇵🇾", "BO": "🇧🇴", "CR": "🇨🇷", "PA": "🇵🇦", "GT": "🇬🇹", "HN": "🇭🇳",
-	"SV": "🇸🇻", "NI": "🇳🇮", "BZ": "🇧🇿", "JM": "🇯🇲", "TT": "🇹🇹", "BB": "🇧🇧", "GD": "🇬🇩", "LC": "🇱🇨",
-	"VC": "🇻🇨", "AG": "🇦🇬", "DM": "🇩🇲", "KN": "🇰🇳", "BS": "🇧🇸", "CU": "🇨🇺", "DO": "🇩🇴", "HT": "🇭🇹",
-	"PR": "🇵🇷", "VI": "🇻🇮", "GU": "🇬🇺", "AS": "🇦🇸", "MP": "🇲🇵", "PW": "🇵🇼", "FM": "🇫🇲", "MH": "🇲🇭",
-	"KI": "🇰🇮", "TV": "🇹🇻", "NR": "🇳🇷", "WS": "🇼🇸", "TO": "🇹🇴", "FJ": "🇫🇯", "VU": "🇻🇺", "SB": "🇸🇧",
-	"PG": "🇵🇬", "NC": "🇳🇨", "PF": "🇵🇫", "WF": "🇼🇫", "CK": "🇨🇰", "NU": "🇳🇺", "TK": "🇹🇰", "SC": "🇸🇨",
+// saveEmptyRun 在过滤后没有任何节点可写时处理输出文件：-good-output 按 saveGoodConfig 的规则写说明，
+// 中途保存改写过的 -output 恢复成运行前的内容
+func saveEmptyRun(allResults []*speedtester.Result) {
+	if *goodOutputPath != "" {
+		saveGoodConfig(nil, artifactPath(*goodOutputPath), allResults)
+	}
+	restorePartialOutputs()
 }
 
-func getIPLocation(ip string) (*IPLocation, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://ip-api.com/json/%s?fields=country,countryCode", ip))
-	if err != nil {
-		return nil, err
+// saveGoodConfig 写出优质节点。没有优质节点时写 good.meta.json 说明原因，
+// 除非指定了 -allow-empty-good，否则保留原来的优质节点文件，避免下游把空文件当成“删除全部节点”
+func saveGoodConfig(goodResults []*speedtester.Result, absPath string, allResults []*speedtester.Result) {
+	if absPath == stdoutPath {
+		if len(goodResults) > 0 {
+			doSaveConfig(goodResults, absPath)
+		} else if *allowEmptyGood {
+			saveEmptyConfig(absPath)
+		}
+		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get location for IP %s", ip)
+	metaPath := goodMetaPath(absPath)
+	if len(goodResults) > 0 {
+		doSaveConfig(goodResults, absPath)
+		// 上一次运行留下的说明已经过时
+		os.Remove(metaPath)
+		return
+	}
+	data, err := marshalGoodMeta(buildGoodMeta(time.Now(), absPath, allResults))
+	if err == nil {
+		err = writeArtifact(metaPath, data, 0o644)
 	}
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		log.Fatalln("save %s failed: %v", metaPath, err)
 	}
-
-	var location IPLocation
-	if err := json.Unmarshal(body, &location); err != nil {
-		return nil, err
+	if *allowEmptyGood {
+		saveEmptyConfig(absPath)
+		return
+	}
+	// -save-every、-stream-output 中途写过的文件先恢复，文件才和运行前一样
+	if err := restorePartialOutput(absPath); err != nil {
+		log.Fatalln("restore %s failed: %v", absPath, err)
 	}
-	return &location, nil
+	fmt.Fprintf(os.Stderr, "%sno good nodes, %s is left unchanged, see %s%s\n", colorYellow, absPath, metaPath, colorReset)
 }
 
-func generateNodeName(countryCode string, downloadSpeed float64) string {
-	flag, exists := countryFlags[strings.ToUpper(countryCode)]
-	if !exists {
-		flag = "🏳️"
+// saveEmptyConfig 写出没有任何节点的输出文件。doSaveConfig 遇到空结果只警告不写，-allow-empty-good 需要真的清空
+func saveEmptyConfig(absPath string) {
+	data, err := marshalProxies(nil)
+	if err == nil {
+		err = writeArtifact(absPath, data, 0o644)
 	}
+	if err != nil {
+		log.Fatalln("save config file: %s failed: %v", absPath, err)
+	}
+	delete(outputBackups, absPath)
+	fmt.Fprintf(console, "\nsave empty good config file to: %s\n", absPath)
+}
 
-	speedMBps := downloadSpeed / (1024 * 1024)
-	return fmt.Sprintf("%s %s | ⬇️ %.2f MB/s", flag, strings.ToUpper(countryCode), speedMBps)
+// saveBadConfig 写出按失败分类分组的不可用节点
+func saveBadConfig(path string, results []*speedtester.Result) {
+	data, err := marshalBadProxies(results)
+	if err != nil {
+		log.Fatalln("convert yaml: %s failed: %v", path, err)
+	}
+	if err := writeArtifact(path, data, 0o644); err != nil {
+		log.Fatalln("save config file: %s failed: %v", path, err)
+	}
+	fmt.Fprintf(console, "save unusable nodes to: %s\n", path)
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+	"github.com/olekukonko/tablewriter"
+)
+
+// 合并多个位置的结果时判定节点可用的方式
+const (
+	mergePolicyAny      = "any"
+	mergePolicyAll      = "all"
+	mergePolicyMajority = "majority"
+)
+
+// vantageResult 是一个节点在某个位置的测试结果摘要
+type vantageResult struct {
+	Usable        bool    `json:"usable"`
+	Good          bool    `json:"good"`
+	LatencyMs     int64   `json:"latency_ms"`
+	DownloadSpeed float64 `json:"download_speed"`
+	UploadSpeed   float64 `json:"upload_speed"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// mergedNode 是按 NodeKey 合并后的一个节点，Vantages 里没有的位置表示那次没有测到这个节点
+type mergedNode struct {
+	NodeKey     string                    `json:"node_key"`
+	Name        string                    `json:"name"`
+	Type        string                    `json:"type"`
+	Vantages    map[string]*vantageResult `json:"vantages"`
+	UsableCount int                       `json:"usable_vantages"`
+	Usable      bool                      `json:"usable"`
+	ProxyConfig map[string]any            `json:"proxy_config"`
+}
+
+// mergedReport 是 merge 子命令的 JSON 输出
+type mergedReport struct {
+	Policy   string        `json:"policy"`
+	Vantages []string      `json:"vantages"`
+	Nodes    []*mergedNode `json:"nodes"`
+}
+
+func checkMergePolicy(policy string) error {
+	switch policy {
+	case mergePolicyAny, mergePolicyAll, mergePolicyMajority:
+		return nil
+	}
+	return fmt.Errorf("unknown merge policy %q, supported: any, all, majority", policy)
+}
+
+// mergeVerdict 按策略判定节点是否可用：any 至少一个位置可用，all 每个位置都可用，
+// majority 超过一半的位置可用。没有测到这个节点的位置算作不可用
+func mergeVerdict(policy string, usable, vantages int) bool {
+	switch policy {
+	case mergePolicyAll:
+		return usable == vantages
+	case mergePolicyMajority:
+		return usable*2 > vantages
+	}
+	return usable > 0
+}
+
+// mergeResultsFiles 按 NodeKey 合并多个位置的结果，位置名不能重复。
+// 节点按可用、可用位置数、各位置中最高的下载速度排序，最后按 NodeKey 保证顺序稳定
+func mergeResultsFiles(files []*resultsFile, policy string) (*mergedReport, error) {
+	report := &mergedReport{Policy: policy}
+	nodes := make(map[string]*mergedNode)
+	for _, file := range files {
+		for _, vantage := range report.Vantages {
+			if vantage == file.Vantage {
+				return nil, fmt.Errorf("vantage %q is given more than once", vantage)
+			}
+		}
+		report.Vantages = append(report.Vantages, file.Vantage)
+		for _, entry := range file.Results {
+			if entry == nil || entry.Result == nil {
+				continue
+			}
+			key := speedtester.NodeKey(entry.ProxyConfig)
+			node := nodes[key]
+			if node == nil {
+				name, _ := entry.ProxyConfig["name"].(string)
+				node = &mergedNode{
+					NodeKey:     key,
+					Name:        name,
+					Type:        entry.ProxyType,
+					Vantages:    make(map[string]*vantageResult),
+					ProxyConfig: entry.ProxyConfig,
+				}
+				nodes[key] = node
+				report.Nodes = append(report.Nodes, node)
+			}
+			if _, exist := node.Vantages[file.Vantage]; exist {
+				// 同一个位置里重复的节点只取第一个
+				continue
+			}
+			node.Vantages[file.Vantage] = &vantageResult{
+				Usable:        entry.Usable,
+				Good:          entry.Good,
+				LatencyMs:     entry.Latency.Milliseconds(),
+				DownloadSpeed: entry.DownloadSpeed,
+				UploadSpeed:   entry.UploadSpeed,
+				Error:         entry.Error,
+			}
+			if entry.Usable {
+				node.UsableCount++
+			}
+		}
+	}
+	for _, node := range report.Nodes {
+		node.Usable = mergeVerdict(policy, node.UsableCount, len(report.Vantages))
+	}
+	sort.SliceStable(report.Nodes, func(i, j int) bool {
+		a, b := report.Nodes[i], report.Nodes[j]
+		if a.Usable != b.Usable {
+			return a.Usable
+		}
+		if a.UsableCount != b.UsableCount {
+			return a.UsableCount > b.UsableCount
+		}
+		if sa, sb := a.bestDownload(), b.bestDownload(); sa != sb {
+			return sa > sb
+		}
+		return a.NodeKey < b.NodeKey
+	})
+	return report, nil
+}
+
+func (n *mergedNode) bestDownload() float64 {
+	best := 0.0
+	for _, v := range n.Vantages {
+		best = max(best, v.DownloadSpeed)
+	}
+	return best
+}
+
+// runMerge 实现 merge 子命令：clash-speedtest merge [-merge-policy any] [-output merged.yaml] [-json merged.json] a.json b.json
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	policy := fs.String("merge-policy", mergePolicyAny, "a node is usable when it is usable from: any vantage, all vantages, or a majority of them")
+	outputPath := fs.String("output", "", "write the nodes usable by -merge-policy to this yaml file")
+	jsonPath := fs.String("json", "", "write the merged per-vantage results as json to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: clash-speedtest merge [options] results.json results.json ...")
+		fmt.Fprintln(fs.Output(), "merge -results-json files written with different -vantage-name by node")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := checkMergePolicy(*policy); err != nil {
+		log.Fatalln("%v", err)
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *outputPath == stdoutPath && *jsonPath == stdoutPath {
+		log.Fatalln("only one output can be written to stdout, got -output, -json")
+	}
+	if *outputPath == stdoutPath || *jsonPath == stdoutPath {
+		claimStdout()
+	}
+
+	files := make([]*resultsFile, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		file, err := loadResultsFile(path)
+		if err != nil {
+			log.Fatalln("load results failed: %v", err)
+		}
+		files = append(files, file)
+	}
+	report, err := mergeResultsFiles(files, *policy)
+	if err != nil {
+		log.Fatalln("merge failed: %v", err)
+	}
+	printMergedTable(report)
+
+	var usable []map[string]any
+	for _, node := range report.Nodes {
+		if node.Usable {
+			usable = append(usable, node.ProxyConfig)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d nodes from %d vantages, %d usable by policy %s\n", len(report.Nodes), len(report.Vantages), len(usable), *policy)
+	if *jsonPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = writeArtifact(*jsonPath, data, 0o644)
+		}
+		if err != nil {
+			log.Fatalln("save %s failed: %v", *jsonPath, err)
+		}
+	}
+	if *outputPath != "" {
+		data, err := marshalProxies(usable)
+		if err == nil {
+			err = writeArtifact(*outputPath, data, 0o644)
+		}
+		if err != nil {
+			log.Fatalln("save %s failed: %v", *outputPath, err)
+		}
+		fmt.Fprintf(os.Stderr, "save config file to: %s\n", *outputPath)
+	}
+}
+
+// printMergedTable 输出每个节点在各个位置的延迟和下载速度，以及按策略得出的结论
+func printMergedTable(report *mergedReport) {
+	headers := []string{"序号", "节点名称", "类型"}
+	for _, vantage := range report.Vantages {
+		headers = append(headers, vantage+" 延迟", vantage+" 下载")
+	}
+	headers = append(headers, "可用位置", "结论")
+
+	table := tablewriter.NewWriter(console)
+	table.SetHeader(headers)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+	for i, node := range report.Nodes {
+		row := []string{fmt.Sprintf("%d.", i+1), truncateName(node.Name, 20), node.Type}
+		for _, vantage := range report.Vantages {
+			v := node.Vantages[vantage]
+			switch {
+			case v == nil:
+				row = append(row, "-", "-")
+			case !v.Usable:
+				row = append(row, colorRed+formatMergedLatency(v)+colorReset, colorRed+speedtester.FormatSpeed(v.DownloadSpeed)+colorReset)
+			default:
+				row = append(row, formatMergedLatency(v), speedtester.FormatSpeed(v.DownloadSpeed))
+			}
+		}
+		verdict := colorRed + "✗" + colorReset
+		if node.Usable {
+			verdict = colorGreen + "✓" + colorReset
+		}
+		row = append(row, fmt.Sprintf("%d/%d", node.UsableCount, len(report.Vantages)), verdict)
+		table.Append(row)
+	}
+	fmt.Fprintln(console)
+	table.Render()
+	fmt.Fprintln(console)
+}
+
+func formatMergedLatency(v *vantageResult) string {
+	if v.LatencyMs <= 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%dms", v.LatencyMs)
+}
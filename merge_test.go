@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// vantageEntry 生成一个位置里的节点结果，speed 为 0 表示不可用。节点名加上 suffix，
+// 用来模拟不同订阅里改过名的同一个节点
+func vantageEntry(server, suffix string, speed float64) *resultEntry {
+	config := map[string]any{"name": server + suffix, "type": "ss", "server": server + ".example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+	entry := &resultEntry{Result: &speedtester.Result{ProxyName: server + suffix, ProxyType: "Shadowsocks", ProxyConfig: config}}
+	if speed > 0 {
+		entry.Latency = 100 * time.Millisecond
+		entry.DownloadSpeed = speed * 1024 * 1024
+		entry.Usable = true
+	} else {
+		entry.Error = "timeout"
+	}
+	return entry
+}
+
+func mergedNames(report *mergedReport) []string {
+	var names []string
+	for _, node := range report.Nodes {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+func TestMergeVerdict(t *testing.T) {
+	tests := []struct {
+		policy   string
+		usable   int
+		vantages int
+		want     bool
+	}{
+		{mergePolicyAny, 0, 3, false},
+		{mergePolicyAny, 1, 3, true},
+		{mergePolicyAll, 2, 3, false},
+		{mergePolicyAll, 3, 3, true},
+		{mergePolicyMajority, 1, 2, false},
+		{mergePolicyMajority, 2, 3, true},
+		{mergePolicyMajority, 2, 4, false},
+		{mergePolicyMajority, 3, 4, true},
+	}
+	for _, tt := range tests {
+		if got := mergeVerdict(tt.policy, tt.usable, tt.vantages); got != tt.want {
+			t.Errorf("mergeVerdict(%s, %d, %d) = %v, want %v", tt.policy, tt.usable, tt.vantages, got, tt.want)
+		}
+	}
+	for _, policy := range []string{mergePolicyAny, mergePolicyAll, mergePolicyMajority} {
+		if err := checkMergePolicy(policy); err != nil {
+			t.Errorf("checkMergePolicy(%s): %v", policy, err)
+		}
+	}
+	if err := checkMergePolicy("most"); err == nil || !strings.Contains(err.Error(), `unknown merge policy "most"`) {
+		t.Errorf("checkMergePolicy(most) = %v", err)
+	}
+}
+
+func TestMergeResultsFiles(t *testing.T) {
+	files := []*resultsFile{
+		{Vantage: "tokyo", Results: []*resultEntry{
+			vantageEntry("a", "", 5),
+			vantageEntry("b", "", 0),
+			vantageEntry("c", "", 20),
+			vantageEntry("d", "", 0),
+			// 同一个位置里重复的节点只取第一个
+			vantageEntry("a", " copy", 50),
+			nil,
+		}},
+		{Vantage: "home", Results: []*resultEntry{
+			vantageEntry("a", " renamed", 8),
+			vantageEntry("b", "", 3),
+			vantageEntry("d", "", 0),
+			vantageEntry("e", "", 30),
+		}},
+		{Vantage: "vps", Results: []*resultEntry{
+			vantageEntry("a", "", 1),
+			vantageEntry("c", "", 0),
+		}},
+	}
+
+	tests := []struct {
+		policy string
+		usable []string
+	}{
+		{mergePolicyAny, []string{"a", "b", "c", "e"}},
+		{mergePolicyAll, []string{"a"}},
+		// b 在三个位置里只有一个可用，不算多数
+		{mergePolicyMajority, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			report, err := mergeResultsFiles(files, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(report.Vantages, []string{"tokyo", "home", "vps"}) {
+				t.Errorf("vantages %v", report.Vantages)
+			}
+			var usable []string
+			for _, node := range report.Nodes {
+				if node.Usable {
+					usable = append(usable, node.Name)
+				}
+			}
+			slices.Sort(usable)
+			if !slices.Equal(usable, tt.usable) {
+				t.Errorf("usable %v, want %v", usable, tt.usable)
+			}
+			if got := mergedNames(report); len(got) != 5 {
+				t.Errorf("nodes %v, want 5 joined by node key", got)
+			}
+		})
+	}
+
+	report, err := mergeResultsFiles(files, mergePolicyAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 可用的排在前面，再按可用位置数、各位置最高的下载速度排序：a 三个位置都可用，
+	// e、c、b 各在一个位置可用，按 30、20、3MB/s 排列，d 都不可用
+	if got := mergedNames(report); !slices.Equal(got, []string{"a", "e", "c", "b", "d"}) {
+		t.Errorf("order %v", got)
+	}
+	a := report.Nodes[0]
+	if a.UsableCount != 3 || len(a.Vantages) != 3 || a.Vantages["tokyo"].DownloadSpeed != 5*1024*1024 || a.Vantages["home"].LatencyMs != 100 {
+		t.Errorf("node a %+v, tokyo %+v", a, a.Vantages["tokyo"])
+	}
+	e := report.Nodes[1]
+	if _, ok := e.Vantages["tokyo"]; ok || e.UsableCount != 1 {
+		t.Errorf("node e measured from tokyo: %+v", e.Vantages)
+	}
+	if d := report.Nodes[4]; d.Usable || d.Vantages["tokyo"].Error != "timeout" {
+		t.Errorf("node d %+v", d)
+	}
+
+	out := captureConsole(t, func() { printMergedTable(report) })
+	for _, want := range []string{"tokyo 延迟", "home 下载", "vps 下载", "3/3", "0/3"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("merged table does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMergeResultsFilesDuplicateVantage(t *testing.T) {
+	files := []*resultsFile{{Vantage: "tokyo"}, {Vantage: "home"}, {Vantage: "tokyo"}}
+	if _, err := mergeResultsFiles(files, mergePolicyAny); err == nil || !strings.Contains(err.Error(), `vantage "tokyo" is given more than once`) {
+		t.Errorf("mergeResultsFiles = %v", err)
+	}
+}
+
+// -results-json 写出的文件能被 merge 读回，没有位置名时用文件名
+func TestResultsFileRoundTrip(t *testing.T) {
+	setFlags(t, "min-speed", "5")
+	dir := t.TempDir()
+	fast, slow := capResult("Fast", 20), capResult("Slow", 1)
+	for _, vantage := range []string{"tokyo", ""} {
+		data, err := marshalResultsFile(vantage, time.Now(), []*speedtester.Result{fast, slow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "home.json"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := loadResultsFile(filepath.Join(dir, "home.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := vantage
+		if want == "" {
+			want = "home"
+		}
+		if file.Vantage != want || file.Version != resultsVersion || len(file.Results) != 2 {
+			t.Fatalf("vantage %q, version %d, %d results", file.Vantage, file.Version, len(file.Results))
+		}
+		if r := file.Results[0]; !r.Usable || r.ProxyName != "Fast" || r.DownloadSpeed != fast.DownloadSpeed || r.ProxyConfig["server"] != "fast.example.com" {
+			t.Errorf("fast entry %+v", r)
+		}
+		if file.Results[1].Usable {
+			t.Error("slow entry usable")
+		}
+	}
+
+	newer := filepath.Join(dir, "newer.json")
+	if err := os.WriteFile(newer, []byte(`{"version": 99, "results": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadResultsFile(newer); err == nil || !strings.Contains(err.Error(), "newer version") {
+		t.Errorf("loadResultsFile(newer) = %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// 从没有 -name-prefix 的版本升级后，旧的输出和缓存里都是订阅里的原名
+func TestNamePrefixUpgrade(t *testing.T) {
+	config := map[string]any{"name": "HK 01", "type": "ss", "server": "1.2.3.4", "port": 443, "password": "p"}
+	proxy := &speedtester.CProxy{Config: config, Source: "sub1.yaml"}
+	st := speedtester.New(&speedtester.Config{NamePrefix: speedtester.NamePrefixFile})
+	name, prefixed := st.NameProxy("HK 01", proxy)
+	result := &speedtester.Result{ProxyName: name, SourceName: "HK 01", ProxyConfig: prefixed, TestedAt: time.Now()}
+
+	if diff := diffOutput([]map[string]any{config}, []*speedtester.Result{result}); diff.Unchanged != 1 || len(diff.Changed) > 0 {
+		t.Errorf("output of the previous version reported as changed: %+v", diff)
+	}
+	if diff := diffOutput([]map[string]any{prefixed}, []*speedtester.Result{result}); diff.Unchanged != 1 {
+		t.Errorf("prefixed output reported as changed: %+v", diff)
+	}
+	modified := map[string]any{"name": "HK 01", "type": "ss", "server": "1.2.3.4", "port": 443, "password": "p", "udp": true}
+	if diff := diffOutput([]map[string]any{modified}, []*speedtester.Result{result}); len(diff.Changed) != 1 {
+		t.Errorf("modified node not reported: %+v", diff)
+	}
+
+	// 旧版本缓存的是原名配置的哈希
+	legacy := &historyFile{Latest: map[string]*cachedResult{
+		speedtester.NodeKey(config): {ConfigHash: speedtester.ConfigHash(config), Result: &speedtester.Result{ProxyName: "sub1_HK 01", TestedAt: time.Now()}},
+	}}
+	if reused := legacy.reuseResults(st, map[string]*speedtester.CProxy{"HK 01": proxy}, time.Now(), 0); len(reused) != 1 {
+		t.Fatal("cached result of the previous version not reused")
+	} else if reused[0].ProxyName != "sub1_HK 01" || reused[0].ProxyConfig["name"] != "sub1_HK 01" {
+		t.Errorf("reused result not renamed: %s %v", reused[0].ProxyName, reused[0].ProxyConfig["name"])
+	}
+
+	// 本版本写入的缓存换一个 -name-prefix 仍然有效
+	h := &historyFile{}
+	h.updateLatest(time.Now(), []*speedtester.Result{result}, 0)
+	none := speedtester.New(&speedtester.Config{NamePrefix: speedtester.NamePrefixNone})
+	if reused := h.reuseResults(none, map[string]*speedtester.CProxy{"HK 01": proxy}, time.Now(), 0); len(reused) != 1 {
+		t.Fatal("cached result not reused after changing -name-prefix")
+	} else if reused[0].ProxyName != "HK 01" {
+		t.Errorf("reused result named %q, want HK 01", reused[0].ProxyName)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// formatOnelineResult 把单个节点的结果格式化成一行 tab 分隔的文本，方便 grep/awk 处理：
+//
+//	OK	12ms	14.20MB/s	3.10MB/s	🇯🇵 JP	sub1.yaml	NodeName
+//	FAIL	latency 1200ms > 800ms	sub1.yaml	NodeName
+func formatOnelineResult(result *speedtester.Result, color bool) string {
+	source := "-"
+	if result.Source != "" {
+		source = filepath.Base(result.Source)
+	}
+	name := strings.NewReplacer("\t", " ", "\n", " ").Replace(result.ProxyName)
+
+	if reason := unusableReason(result); reason != "" {
+		status := "FAIL"
+		if color {
+			status = colorRed + status + colorReset
+		}
+		return strings.Join([]string{status, reason, source, name}, "\t")
+	}
+
+	status := "OK"
+	if isProxyGood(result) {
+		status = "GOOD"
+	}
+	if color {
+		if status == "GOOD" {
+			status = colorGreen + status + colorReset
+		} else {
+			status = colorYellow + status + colorReset
+		}
+	}
+	country := "-"
+	if result.CountryCode != "" {
+		country = countryFlag(result.CountryCode) + " " + result.CountryCode
+	}
+	upload := result.FormatUploadSpeed()
+	if result.UploadBlocked {
+		upload = "BLOCKED"
+	}
+	return strings.Join([]string{
+		status,
+		result.FormatLatency(),
+		result.FormatDownloadSpeed(),
+		upload,
+		country,
+		source,
+		name,
+	}, "\t")
+}
+
+// printSummary 输出测试汇总和连接失败原因的分类统计，写到 stderr 以免混入 -oneline 的结果流
+func printSummary(allResults, results []*speedtester.Result) {
+	good := 0
+	for _, result := range results {
+		if isProxyGood(result) {
+			good++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "tested %d nodes, %d usable, %d good\n", len(allResults), len(results), good)
+
+	classes := make(map[string]int)
+	uploadBlocked, tampering := 0, 0
+	families := make(map[string]int)
+	for _, result := range allResults {
+		if result.ContentTampering {
+			tampering++
+		}
+		if result.IPFamilyUsed != "" {
+			families[result.IPFamilyUsed]++
+		}
+		if result.ErrorClass == speedtester.ErrorClassUploadBlocked {
+			// 上传被屏蔽的节点是连得上的，单独统计
+			uploadBlocked++
+		} else if result.ErrorClass != "" {
+			classes[result.ErrorClass]++
+		}
+	}
+	if len(classes) > 0 {
+		fmt.Fprintf(os.Stderr, "unreachable: %s\n", formatSkipped(classes))
+	}
+	if uploadBlocked > 0 {
+		fmt.Fprintf(os.Stderr, "upload blocked: %d nodes\n", uploadBlocked)
+	}
+	if tampering > 0 {
+		fmt.Fprintf(os.Stderr, "%scontent tampering: %d nodes alter plain http responses%s\n", colorYellow, tampering, colorReset)
+	}
+	if len(families) > 0 {
+		// 节点在出口选错了协议族，换成固定地址才能连通
+		fmt.Fprintf(os.Stderr, "ip family fallback saved %d nodes: %d only work over ipv4, %d only over ipv6\n",
+			families[speedtester.IPFamilyIPv4]+families[speedtester.IPFamilyIPv6], families[speedtester.IPFamilyIPv4], families[speedtester.IPFamilyIPv6])
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
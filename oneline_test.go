@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestFormatOnelineResult(t *testing.T) {
+	flag.Set("good-download-speed-threshold", "10")
+	t.Cleanup(func() { flag.Set("good-download-speed-threshold", "1") })
+	result := func(name string, speed float64) *speedtester.Result {
+		return &speedtester.Result{
+			ProxyName:            name,
+			Latency:              100 * time.Millisecond,
+			DownloadSpeed:        speed * 1024 * 1024,
+			ExtraURLConnectivity: true,
+		}
+	}
+	good := result("JP 01", 14.2)
+	good.UploadSpeed = 3.1 * 1024 * 1024
+	good.CountryCode = "JP"
+	good.Source = "/etc/subs/sub1.yaml"
+	slow := result("Slow", 5)
+	slow.Latency = 1200 * time.Millisecond
+	slow.Source = "sub2.yaml"
+	dead := result("Dead\tNode", 0)
+	dead.Latency = 0
+	dead.Error = "dial timeout"
+
+	tests := []struct {
+		name   string
+		result *speedtester.Result
+		want   string
+	}{
+		{"good", good, "GOOD\t100ms\t14.20MB/s\t3.10MB/s\t🇯🇵 JP\tsub1.yaml\tJP 01"},
+		{"latency", slow, "FAIL\tlatency 1200ms > 800ms\tsub2.yaml\tSlow"},
+		// 名称里的 tab 会打乱列，换成空格
+		{"unreachable", dead, "FAIL\tunreachable: dial timeout\t-\tDead Node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatOnelineResult(tt.result, false); got != tt.want {
+				t.Errorf("formatOnelineResult =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+
+	if got := formatOnelineResult(good, true); !strings.HasPrefix(got, colorGreen+"GOOD"+colorReset+"\t") {
+		t.Errorf("colored good line %q", got)
+	}
+	if got := formatOnelineResult(dead, true); !strings.HasPrefix(got, colorRed+"FAIL"+colorReset+"\t") {
+		t.Errorf("colored failed line %q", got)
+	}
+}
+
+func TestUploadSpeedFilter(t *testing.T) {
+	setFlags(t, "min-upload-speed", "1")
+	node := func(name string, upload float64) *speedtester.Result {
+		result := &speedtester.Result{ProxyName: name, Latency: 100 * time.Millisecond, DownloadSpeed: 20 * 1024 * 1024, ExtraURLConnectivity: true}
+		if upload > 0 {
+			result.UploadSize = 1024 * 1024
+			result.UploadSpeed = upload * 1024 * 1024
+		}
+		return result
+	}
+	blocked := node("HK 01", 0)
+	blocked.UploadBlocked = true
+	// 上传被封锁和上传很慢都按 -min-upload-speed 过滤，没有测上传的节点不检查
+	for _, tt := range []struct {
+		result *speedtester.Result
+		want   string
+	}{
+		{node("Fast", 3), "GOOD\t100ms\t20.00MB/s\t3.00MB/s\t-\t-\tFast"},
+		{node("Slow", 0.01), "FAIL\tupload speed 10.24KB/s\t-\tSlow"},
+		{blocked, "FAIL\tupload blocked\t-\tHK 01"},
+		{node("Untested", 0), "GOOD\t100ms\t20.00MB/s\t0.00B/s\t-\t-\tUntested"},
+	} {
+		if got := formatOnelineResult(tt.result, false); got != tt.want {
+			t.Errorf("formatOnelineResult =\n%q\nwant\n%q", got, tt.want)
+		}
+	}
+
+	// -allow-upload-blocked 只保留上传被封锁的节点，上传列显示 BLOCKED
+	*allowUploadBlocked = true
+	if got, want := formatOnelineResult(blocked, false), "GOOD\t100ms\t20.00MB/s\tBLOCKED\t-\t-\tHK 01"; got != want {
+		t.Errorf("formatOnelineResult =\n%q\nwant\n%q", got, want)
+	}
+	if isProxyUsable(node("Slow", 0.01)) {
+		t.Error("-allow-upload-blocked kept a slow upload")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// proxyKeyOrder 是节点配置输出时排在最前面的字段，其余字段按字母序输出
+var proxyKeyOrder = []string{"name", "type", "server", "port"}
+
+// marshalProxies 以固定的字段顺序输出 proxies 列表，相同的节点集合总是得到完全相同的文件，
+// 方便把输出文件提交到 git 里对比
+func marshalProxies(proxies []map[string]any) ([]byte, error) {
+	return marshalAnnotatedProxies(proxies, nil)
+}
+
+// marshalAnnotatedProxies 与 marshalProxies 相同，comments[i] 非空时作为第 i 个节点上方的注释
+func marshalAnnotatedProxies(proxies []map[string]any, comments []string) ([]byte, error) {
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	for i, proxy := range proxies {
+		node, err := orderedMapNode(proxy, proxyKeyOrder)
+		if err != nil {
+			return nil, err
+		}
+		if i < len(comments) {
+			node.HeadComment = comments[i]
+		}
+		list.Content = append(list.Content, node)
+	}
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "proxies"}, list)
+	return yaml.Marshal(doc)
+}
+
+func orderedMapNode(m map[string]any, first []string) (*yaml.Node, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	rank := make(map[string]int, len(first))
+	for i, key := range first {
+		rank[key] = i + 1
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := rank[keys[i]], rank[keys[j]]
+		if ri != 0 || rj != 0 {
+			return ri != 0 && (rj == 0 || ri < rj)
+		}
+		return keys[i] < keys[j]
+	})
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range keys {
+		value, err := orderedValueNode(m[key])
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+	return node, nil
+}
+
+func orderedValueNode(v any) (*yaml.Node, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		return orderedMapNode(v, nil)
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v {
+			child, err := orderedValueNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(v); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// badClassBelowThreshold 是能连通但没有达到延迟、速度等要求的节点
+const badClassBelowThreshold = "below-threshold"
+
+// marshalBadProxies 按失败分类输出不可用的节点，分类按字母序排列：
+//
+//	auth-failed:
+//	  - name: ...
+//	dial-timeout:
+//	  - name: ...
+func marshalBadProxies(results []*speedtester.Result) ([]byte, error) {
+	groups := make(map[string][]map[string]any)
+	for _, result := range results {
+		if isProxyUsable(result) {
+			continue
+		}
+		class := result.ErrorClass
+		if class == "" {
+			class = badClassBelowThreshold
+		}
+		groups[class] = append(groups[class], result.ProxyConfig)
+	}
+	classes := make([]string, 0, len(groups))
+	for class := range groups {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, class := range classes {
+		list := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, proxy := range groups[class] {
+			node, err := orderedMapNode(proxy, proxyKeyOrder)
+			if err != nil {
+				return nil, err
+			}
+			list.Content = append(list.Content, node)
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: class}, list)
+	}
+	return yaml.Marshal(doc)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+func outputTestProxies() []map[string]any {
+	return []map[string]any{
+		{"name": "HK 01", "type": "vmess", "server": "hk.example.com", "port": 443, "uuid": "u1", "alterId": 0, "cipher": "auto", "tls": true,
+			"network": "ws", "ws-opts": map[string]any{"path": "/ws", "headers": map[string]any{"Host": "hk.example.com", "A": "b"}}},
+		{"name": "JP 01", "type": "ss", "server": "jp.example.com", "port": 8388, "cipher": "aes-128-gcm", "password": "p"},
+		{"name": "US 01", "type": "trojan", "server": "us.example.com", "port": 443, "password": "p", "sni": "us.example.com",
+			"alpn": []any{"h2", "http/1.1"}},
+		{"name": "SG 01", "type": "ss", "server": "sg.example.com", "port": 8388, "cipher": "aes-128-gcm", "password": "p"},
+	}
+}
+
+func TestMarshalProxiesStable(t *testing.T) {
+	first, err := marshalProxies(outputTestProxies())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		again, err := marshalProxies(outputTestProxies())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(first) {
+			t.Fatalf("two marshals differ:\n%s\n---\n%s", first, again)
+		}
+	}
+
+	want := `proxies:
+    - name: HK 01
+      type: vmess
+      server: hk.example.com
+      port: 443
+      alterId: 0
+      cipher: auto
+      network: ws
+      tls: true
+      uuid: u1
+      ws-opts:
+        headers:
+            A: b
+            Host: hk.example.com
+        path: /ws
+`
+	if !strings.HasPrefix(string(first), want) {
+		t.Errorf("unexpected key order:\n%s", first)
+	}
+
+	var decoded struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(first, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Proxies, outputTestProxies()) {
+		t.Errorf("round trip changed the proxies: %v", decoded.Proxies)
+	}
+}
+
+// TestMarshalProxiesOneHunk 检查只改一个节点时输出文件只有一处连续的差异
+func TestMarshalProxiesOneHunk(t *testing.T) {
+	before, err := marshalProxies(outputTestProxies())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := outputTestProxies()
+	changed[2] = maps.Clone(changed[2])
+	changed[2]["sni"] = "cdn.example.com"
+	after, err := marshalProxies(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hunks := countHunks(string(before), string(after)); hunks != 1 {
+		t.Errorf("one node change produced %d hunks:\n%s\n---\n%s", hunks, before, after)
+	}
+}
+
+// countHunks 数两个行数相同的文本里连续不同的行块有几处
+func countHunks(a, b string) int {
+	linesA, linesB := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(linesA) != len(linesB) {
+		return -1
+	}
+	hunks := 0
+	inHunk := false
+	for i := range linesA {
+		differs := linesA[i] != linesB[i]
+		if differs && !inHunk {
+			hunks++
+		}
+		inHunk = differs
+	}
+	return hunks
+}
+
+func TestMarshalAnnotatedProxies(t *testing.T) {
+	proxies := outputTestProxies()[1:3]
+	data, err := marshalAnnotatedProxies(proxies, []string{"", "US 01 was renamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# US 01 was renamed\n    - name: US 01") {
+		t.Errorf("comment not above the node:\n%s", data)
+	}
+	if strings.Count(string(data), "#") != 1 {
+		t.Errorf("empty comment written:\n%s", data)
+	}
+}
+
+func TestMarshalBadProxies(t *testing.T) {
+	configs := outputTestProxies()
+	results := []*speedtester.Result{
+		{ProxyName: "HK 01", ProxyConfig: configs[0], ErrorClass: "dial-timeout"},
+		{ProxyName: "JP 01", ProxyConfig: configs[1], ErrorClass: "auth-failed"},
+		{ProxyName: "US 01", ProxyConfig: configs[2], Latency: 100e6, DownloadSpeed: 0},
+		{ProxyName: "SG 01", ProxyConfig: configs[3], ErrorClass: "dial-timeout"},
+	}
+	data, err := marshalBadProxies(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups map[string][]map[string]any
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		t.Fatal(err)
+	}
+	names := func(class string) []string {
+		var names []string
+		for _, proxy := range groups[class] {
+			names = append(names, proxy["name"].(string))
+		}
+		return names
+	}
+	if got := names("dial-timeout"); !reflect.DeepEqual(got, []string{"HK 01", "SG 01"}) {
+		t.Errorf("dial-timeout = %v", got)
+	}
+	if got := names("auth-failed"); !reflect.DeepEqual(got, []string{"JP 01"}) {
+		t.Errorf("auth-failed = %v", got)
+	}
+	if got := names(badClassBelowThreshold); !reflect.DeepEqual(got, []string{"US 01"}) {
+		t.Errorf("%s = %v", badClassBelowThreshold, got)
+	}
+	if !strings.HasPrefix(string(data), "auth-failed:") {
+		t.Errorf("classes not sorted:\n%s", data)
+	}
+}
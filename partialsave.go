@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+)
+
+// outputsBeforeRun 是第一次中途保存之前输出文件里的节点，按绝对路径索引。
+// 最终保存时用它计算 "changes since last run"，而不是和本次中途写出的文件比较
+var outputsBeforeRun = map[string][]map[string]any{}
+
+// partialBackup 是第一次中途保存之前输出文件的原始内容，existed 为 false 表示文件原来不存在
+type partialBackup struct {
+	data    []byte
+	existed bool
+}
+
+// outputBackups 按绝对路径记录被中途保存改写过、还没有最终写出的输出文件。
+// 最终的节点经过否决、-min-age、-max-per-subnet 等过滤后没有可写的节点时，用它把文件恢复成运行前的样子，
+// 不把中途写出、后来又被排除的节点留在输出文件里
+var outputBackups = map[string]partialBackup{}
+
+// partialSaver 在测试过程中按时间间隔或测试节点数把目前可用的节点写到输出文件，
+// 这样运行中途崩溃或被中断时也能留下一份完整可用的配置
+type partialSaver struct {
+	every saveEvery
+	clock speedtester.Clock
+	// write 写出一次快照，返回写入的节点数
+	write func(results []*speedtester.Result) int
+
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	saving    bool
+	stopped   bool
+	lastSave  time.Time
+	sinceSave int
+	// stream 为 true 时每出现一个新的可用节点就在 observe 里同步写出，written 是上次写出的节点数
+	stream  bool
+	written int
+}
+
+func newPartialSaver(every saveEvery, clock speedtester.Clock, write func([]*speedtester.Result) int) *partialSaver {
+	return &partialSaver{
+		every:    every,
+		clock:    clock,
+		write:    write,
+		lastSave: clock.Now(),
+	}
+}
+
+// newStreamSaver 返回 -stream-output 使用的 partialSaver，不按间隔保存，而是每通过一个节点就写一次
+func newStreamSaver(write func([]*speedtester.Result) int) *partialSaver {
+	return &partialSaver{stream: true, write: write}
+}
+
+// observe 在每个节点测试完成后调用，results 是目前可用的节点。
+// 到了保存时间且没有正在进行的保存时，复制一份快照在后台写出
+func (s *partialSaver) observe(results []*speedtester.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream {
+		// 同步写出，写完之前下一个结果不会进来，文件里总是包含到目前为止通过的所有节点
+		if !s.stopped && len(results) > s.written {
+			s.written = len(results)
+			s.write(append([]*speedtester.Result(nil), results...))
+		}
+		return
+	}
+	s.sinceSave++
+	if s.stopped || s.saving || !s.due() {
+		return
+	}
+	s.saving = true
+	s.sinceSave = 0
+	s.lastSave = s.clock.Now()
+	snapshot := append([]*speedtester.Result(nil), results...)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		count := s.write(snapshot)
+		fmt.Fprintf(os.Stderr, "saved %d usable nodes so far\n", count)
+		s.mu.Lock()
+		s.saving = false
+		s.mu.Unlock()
+	}()
+}
+
+func (s *partialSaver) due() bool {
+	if s.every.nodes > 0 {
+		return s.sinceSave >= s.every.nodes
+	}
+	return s.clock.Now().Sub(s.lastSave) >= s.every.interval
+}
+
+// stop 等待正在进行的保存结束，之后不再中途保存，调用后才能进行最终保存
+func (s *partialSaver) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// savePartialConfig 按最终保存的规则把快照写到 -good-output 和 -output，出错时只记录日志
+func savePartialConfig(results []*speedtester.Result) int {
+	sortResults(results)
+	var good, usable []*speedtester.Result
+	for _, result := range results {
+		if isProxyGood(result) && *goodOutputPath != "" {
+			good = append(good, result)
+		} else {
+			usable = append(usable, result)
+		}
+	}
+	writePartial(*goodOutputPath, good)
+	writePartial(*outputPath, usable)
+	return len(results)
+}
+
+func writePartial(path string, results []*speedtester.Result) {
+	// 标准输出只能写一次，中途保存跳过写到标准输出的文件
+	if path == "" || path == stdoutPath || len(results) == 0 {
+		return
+	}
+	absPath, _ := filepath.Abs(path)
+	if _, ok := outputBackups[absPath]; !ok {
+		data, err := os.ReadFile(absPath)
+		if err != nil && !os.IsNotExist(err) {
+			// 无法备份的文件中途不改写，最终保存时照常写出
+			log.Warnln("partial save %s skipped: %v", absPath, err)
+			return
+		}
+		outputBackups[absPath] = partialBackup{data: data, existed: err == nil}
+	}
+	if _, ok := outputsBeforeRun[absPath]; !ok {
+		previous, err := loadPreviousProxies(absPath)
+		if err != nil {
+			log.Warnln("parse previous config %s failed, skip diff: %v", absPath, err)
+		}
+		outputsBeforeRun[absPath] = previous
+	}
+	data, err := marshalResults(results)
+	if err == nil {
+		err = writeFileAtomic(absPath, data, 0o644)
+	}
+	if err != nil {
+		log.Warnln("partial save %s failed: %v", absPath, err)
+	}
+}
+
+// restorePartialOutput 把被中途保存改写过的输出文件恢复成运行前的内容，原来不存在的文件会被删除。
+// 没有被中途保存改写过的文件不做任何改动
+func restorePartialOutput(absPath string) error {
+	backup, ok := outputBackups[absPath]
+	if !ok {
+		return nil
+	}
+	delete(outputBackups, absPath)
+	if !backup.existed {
+		if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeFileAtomic(absPath, backup.data, 0o644)
+}
+
+// restorePartialOutputs 恢复所有被中途保存改写过、还没有最终写出的输出文件
+func restorePartialOutputs() {
+	for absPath := range outputBackups {
+		if err := restorePartialOutput(absPath); err != nil {
+			log.Fatalln("restore %s failed: %v", absPath, err)
+		}
+		fmt.Fprintf(os.Stderr, "%sno nodes left for %s, restored the file from before this run%s\n", colorYellow, absPath, colorReset)
+	}
+}
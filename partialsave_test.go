@@ -0,0 +1,329 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// manualClock 是只在测试里手动前进的 Clock
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// recordingWriter 记录每次保存的节点数，block 不为空时写入会一直等到 block 被关闭
+type recordingWriter struct {
+	mu     sync.Mutex
+	counts []int
+	block  chan struct{}
+}
+
+func (w *recordingWriter) write(results []*speedtester.Result) int {
+	if w.block != nil {
+		<-w.block
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.counts = append(w.counts, len(results))
+	return len(results)
+}
+
+func (w *recordingWriter) saved() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]int(nil), w.counts...)
+}
+
+// feed 模拟测试过程，每调用一次就多一个可用节点
+type feed struct{ results []*speedtester.Result }
+
+func (f *feed) next() []*speedtester.Result {
+	f.results = append(f.results, &speedtester.Result{ProxyName: "node"})
+	return f.results
+}
+
+func TestPartialSaverInterval(t *testing.T) {
+	clock := &manualClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	writer := &recordingWriter{}
+	saver := newPartialSaver(saveEvery{interval: 10 * time.Minute}, clock, writer.write)
+	f := &feed{}
+
+	for _, step := range []time.Duration{0, 9 * time.Minute, time.Minute, 5 * time.Minute, 10 * time.Minute} {
+		clock.advance(step)
+		saver.observe(f.next())
+		saver.wg.Wait()
+	}
+	saver.stop()
+
+	if got := writer.saved(); len(got) != 2 || got[0] != 3 || got[1] != 5 {
+		t.Errorf("saved %v, want [3 5]", got)
+	}
+}
+
+func TestPartialSaverNodeCount(t *testing.T) {
+	clock := &manualClock{}
+	writer := &recordingWriter{}
+	saver := newPartialSaver(saveEvery{nodes: 2}, clock, writer.write)
+	f := &feed{}
+	for range 5 {
+		saver.observe(f.next())
+		// 等这次保存写完，避免被当成正在保存而跳过
+		saver.wg.Wait()
+	}
+	saver.stop()
+	if got := writer.saved(); len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("saved %v, want [2 4]", got)
+	}
+}
+
+func TestPartialSaverSkipsWhileSaving(t *testing.T) {
+	writer := &recordingWriter{block: make(chan struct{})}
+	saver := newPartialSaver(saveEvery{nodes: 1}, &manualClock{}, writer.write)
+	f := &feed{}
+	saver.observe(f.next())
+	// 第一次保存还没有写完，这几次都不会再启动新的保存
+	saver.observe(f.next())
+	saver.observe(f.next())
+
+	stopped := make(chan struct{})
+	go func() {
+		saver.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop returned while a save was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(writer.block)
+	<-stopped
+
+	// stop 之后不再保存，最终保存不会和中途保存同时写文件
+	saver.observe(f.next())
+	saver.wg.Wait()
+	if got := writer.saved(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("saved %v, want [1]", got)
+	}
+}
+
+func TestStreamSaver(t *testing.T) {
+	writer := &recordingWriter{}
+	saver := newStreamSaver(writer.write)
+	f := &feed{}
+	saver.observe(f.next())
+	// 可用节点数没有变化时不重复写
+	saver.observe(f.results)
+	saver.observe(f.next())
+	saver.stop()
+	saver.observe(f.next())
+	if got := writer.saved(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("saved %v, want [1 2]", got)
+	}
+}
+
+func TestSaveEveryFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  saveEvery
+		err   bool
+	}{
+		{"", saveEvery{}, false},
+		{"0", saveEvery{}, false},
+		{"50", saveEvery{nodes: 50}, false},
+		{"10m", saveEvery{interval: 10 * time.Minute}, false},
+		{"-5", saveEvery{}, true},
+		{"-1m", saveEvery{}, true},
+		{"soon", saveEvery{}, true},
+	}
+	for _, tt := range tests {
+		s := saveEvery{nodes: 7}
+		err := s.Set(tt.value)
+		if (err != nil) != tt.err || !tt.err && s != tt.want {
+			t.Errorf("Set(%q) = %+v, %v", tt.value, s, err)
+		}
+		// String 的结果可以重新解析成同样的值
+		var parsed saveEvery
+		if !tt.err && (parsed.Set(s.String()) != nil || parsed != s) {
+			t.Errorf("Set(%q).String() = %q does not round-trip", tt.value, s.String())
+		}
+	}
+}
+
+// readOutput 读取输出文件里的节点名，文件必须是合法的 yaml；文件不存在时返回 nil
+func readOutput(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatalf("%s is not valid yaml: %v\n%s", path, err, data)
+	}
+	var names []string
+	for _, proxy := range config.Proxies {
+		names = append(names, proxy["name"].(string))
+	}
+	return names
+}
+
+func TestStreamOutputFiles(t *testing.T) {
+	dir := t.TempDir()
+	usablePath, goodPath := filepath.Join(dir, "useable.yaml"), filepath.Join(dir, "good.yaml")
+	setFlags(t, "output", usablePath, "good-output", goodPath, "stream-output", "true", "good-download-speed-threshold", "10")
+	outputsBeforeRun = map[string][]map[string]any{}
+	t.Cleanup(func() { outputsBeforeRun = map[string][]map[string]any{} })
+
+	saver := newStreamSaver(savePartialConfig)
+	var results []*speedtester.Result
+	var held *os.File
+	steps := []struct {
+		result       *speedtester.Result
+		usable, good []string
+	}{
+		{capResult("Slow", 2), []string{"Slow"}, nil},
+		{capResult("Fast", 20), []string{"Slow"}, []string{"Fast"}},
+		{capResult("Mid", 5), []string{"Mid", "Slow"}, []string{"Fast"}},
+		{capResult("Fastest", 30), []string{"Mid", "Slow"}, []string{"Fastest", "Fast"}},
+	}
+	for i, step := range steps {
+		results = append(results, step.result)
+		saver.observe(results)
+
+		if got := readOutput(t, usablePath); !slices.Equal(got, step.usable) {
+			t.Errorf("step %d: -output %v, want %v", i, got, step.usable)
+		}
+		if got := readOutput(t, goodPath); !slices.Equal(got, step.good) {
+			t.Errorf("step %d: -good-output %v, want %v", i, got, step.good)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if name := entry.Name(); name != "useable.yaml" && name != "good.yaml" {
+				t.Errorf("step %d: leftover file %s", i, name)
+			}
+		}
+
+		switch i {
+		case 0:
+			if held, err = os.Open(usablePath); err != nil {
+				t.Fatal(err)
+			}
+			defer held.Close()
+		case 2:
+			// 改写是写临时文件再改名：打开着的旧文件不受影响，路径指向一个新文件
+			old, _ := held.Stat()
+			current, _ := os.Stat(usablePath)
+			if os.SameFile(old, current) {
+				t.Error("-output was rewritten in place")
+			}
+			data, _ := io.ReadAll(held)
+			if !strings.Contains(string(data), "name: Slow") || strings.Contains(string(data), "Mid") {
+				t.Errorf("old file changed under a reader:\n%s", data)
+			}
+		}
+	}
+	saver.stop()
+
+	// 最终保存和 main 一样先整体排序，再按下载速度写出
+	slices.Reverse(results)
+	captureConsole(t, func() {
+		sortResults(results)
+		saveConfig(results, results)
+	})
+	if got, want := readOutput(t, goodPath), []string{"Fastest", "Fast"}; !slices.Equal(got, want) {
+		t.Errorf("final -good-output %v, want %v", got, want)
+	}
+	if got, want := readOutput(t, usablePath), []string{"Mid", "Slow"}; !slices.Equal(got, want) {
+		t.Errorf("final -output %v, want %v", got, want)
+	}
+}
+
+// 中途保存写出的节点最后全部被否决时，-output 和 -good-output 恢复成运行前的内容，
+// 运行前不存在的文件被删掉
+func TestPartialSaveRestoredWhenAllVetoed(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		saver func() *partialSaver
+	}{
+		{"save every", func() *partialSaver { return newPartialSaver(saveEvery{nodes: 1}, &manualClock{}, savePartialConfig) }},
+		{"stream output", func() *partialSaver { return newStreamSaver(savePartialConfig) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			usablePath, goodPath := filepath.Join(dir, "useable.yaml"), filepath.Join(dir, "good.yaml")
+			before := []byte("# kept from the last run\nproxies:\n  - {name: Old, type: ss, server: old.example.com, port: 443, password: p, cipher: aes-128-gcm}\n")
+			if err := os.WriteFile(usablePath, before, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			setFlags(t, "output", usablePath, "good-output", goodPath, "good-download-speed-threshold", "10")
+			outputsBeforeRun = map[string][]map[string]any{}
+			outputBackups = map[string]partialBackup{}
+			t.Cleanup(func() {
+				outputsBeforeRun = map[string][]map[string]any{}
+				outputBackups = map[string]partialBackup{}
+			})
+
+			saver := tc.saver()
+			hook := newResultHook("true", time.Second, 1, true)
+			var results []*speedtester.Result
+			for _, result := range []*speedtester.Result{capResult("Slow", 2), capResult("Fast", 20)} {
+				results = append(results, result)
+				hook.vetoed[result] = "rejected"
+				saver.observe(results)
+				saver.wg.Wait()
+			}
+			saver.stop()
+			if got := readOutput(t, usablePath); !slices.Equal(got, []string{"Slow"}) {
+				t.Fatalf("partial -output %v, want [Slow]", got)
+			}
+			if got := readOutput(t, goodPath); !slices.Equal(got, []string{"Fast"}) {
+				t.Fatalf("partial -good-output %v, want [Fast]", got)
+			}
+
+			allResults := results
+			results = hook.filter(results, func(*speedtester.Result) bool { return false })
+			if len(results) != 0 {
+				t.Fatalf("%d results left after vetoing every node", len(results))
+			}
+			captureConsole(t, func() { saveEmptyRun(allResults) })
+
+			if data, err := os.ReadFile(usablePath); err != nil || string(data) != string(before) {
+				t.Errorf("-output after the run: %v\n%s", err, data)
+			}
+			if _, err := os.Stat(goodPath); !os.IsNotExist(err) {
+				t.Errorf("-good-output did not exist before the run but is left behind: %v", err)
+			}
+			if _, err := os.Stat(goodMetaPath(goodPath)); err != nil {
+				t.Errorf("good.meta.json not written: %v", err)
+			}
+		})
+	}
+}
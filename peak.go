@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hourRange 是一天中的小时区间，两端都包含，Start > End 时表示跨越午夜，例如 22-2
+type hourRange struct {
+	Start int
+	End   int
+}
+
+func parseHourRange(s string) (hourRange, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return hourRange{}, fmt.Errorf("invalid hour range %q, expected e.g. 19-23", s)
+	}
+	var r hourRange
+	var err error
+	if r.Start, err = strconv.Atoi(strings.TrimSpace(start)); err != nil || r.Start < 0 || r.Start > 23 {
+		return hourRange{}, fmt.Errorf("invalid start hour in %q", s)
+	}
+	if r.End, err = strconv.Atoi(strings.TrimSpace(end)); err != nil || r.End < 0 || r.End > 23 {
+		return hourRange{}, fmt.Errorf("invalid end hour in %q", s)
+	}
+	return r, nil
+}
+
+// contains 判断时间 t 在 loc 时区下是否落在区间内
+func (r hourRange) contains(t time.Time, loc *time.Location) bool {
+	hour := t.In(loc).Hour()
+	if r.Start <= r.End {
+		return hour >= r.Start && hour <= r.End
+	}
+	return hour >= r.Start || hour <= r.End
+}
+
+// peakStats 是一个节点在高峰和非高峰时段的平均下载速度
+type peakStats struct {
+	PeakSpeed      float64
+	PeakSamples    int
+	OffPeakSpeed   float64
+	OffPeakSamples int
+}
+
+// peakProfiles 按 NodeKey 汇总历史记录，只统计测试成功（速度大于 0）的样本
+func peakProfiles(runs []historyRun, peak hourRange, loc *time.Location) map[string]*peakStats {
+	profiles := make(map[string]*peakStats)
+	for _, run := range runs {
+		isPeak := peak.contains(run.Time, loc)
+		for _, record := range run.Records {
+			if record.DownloadSpeed <= 0 {
+				continue
+			}
+			stats, ok := profiles[record.NodeKey]
+			if !ok {
+				stats = &peakStats{}
+				profiles[record.NodeKey] = stats
+			}
+			if isPeak {
+				stats.PeakSpeed += record.DownloadSpeed
+				stats.PeakSamples++
+			} else {
+				stats.OffPeakSpeed += record.DownloadSpeed
+				stats.OffPeakSamples++
+			}
+		}
+	}
+	for _, stats := range profiles {
+		if stats.PeakSamples > 0 {
+			stats.PeakSpeed /= float64(stats.PeakSamples)
+		}
+		if stats.OffPeakSamples > 0 {
+			stats.OffPeakSpeed /= float64(stats.OffPeakSamples)
+		}
+	}
+	return profiles
+}
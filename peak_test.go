@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHourRange(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want hourRange
+		ok   bool
+	}{
+		{"19-23", hourRange{19, 23}, true},
+		{" 22 - 2 ", hourRange{22, 2}, true},
+		{"0-0", hourRange{0, 0}, true},
+		{"19", hourRange{}, false},
+		{"19-24", hourRange{}, false},
+		{"-1-3", hourRange{}, false},
+		{"a-3", hourRange{}, false},
+	} {
+		got, err := parseHourRange(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseHourRange(%q) = %v, %v; want %v, ok %v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestHourRangeContains(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2026, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+	evening := hourRange{19, 23}
+	overnight := hourRange{22, 2}
+	for _, tc := range []struct {
+		r    hourRange
+		t    time.Time
+		want bool
+	}{
+		{evening, day(18, 59), false},
+		{evening, day(19, 0), true},
+		{evening, day(23, 59), true},
+		{evening, day(0, 0), false},
+		{overnight, day(21, 59), false},
+		{overnight, day(22, 0), true},
+		{overnight, day(0, 0), true},
+		{overnight, day(2, 59), true},
+		{overnight, day(3, 0), false},
+	} {
+		if got := tc.r.contains(tc.t, time.UTC); got != tc.want {
+			t.Errorf("%v contains %s = %v, want %v", tc.r, tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+}
+
+// TestHourRangeTimezone 检查高峰时段按指定时区的本地时间判断，同一时刻在不同时区可能落在不同的日期和时段
+func TestHourRangeTimezone(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	newYork := time.FixedZone("EST", -5*3600)
+	// UTC 3 月 1 日 12:00 是上海 20:00、纽约 07:00
+	noon := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	evening := hourRange{19, 23}
+	if !evening.contains(noon, shanghai) {
+		t.Errorf("20:00 in Shanghai is not peak")
+	}
+	if evening.contains(noon, newYork) {
+		t.Errorf("07:00 in New York is peak")
+	}
+	// UTC 16:30 是上海次日 00:30，跨午夜的区间要按本地小时判断
+	lateUTC := time.Date(2026, 3, 1, 16, 30, 0, 0, time.UTC)
+	if !(hourRange{22, 1}).contains(lateUTC, shanghai) {
+		t.Errorf("00:30 next day in Shanghai is not in 22-1")
+	}
+	if (hourRange{22, 1}).contains(lateUTC, time.UTC) {
+		t.Errorf("16:30 UTC is in 22-1")
+	}
+	// 记录的时间带着写入时的时区，按 loc 换算后判断
+	recorded := time.Date(2026, 3, 1, 20, 0, 0, 0, shanghai)
+	if evening.contains(recorded, time.UTC) {
+		t.Errorf("12:00 UTC is peak")
+	}
+}
+
+func TestPeakProfiles(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, 3, day, hour, 0, 0, 0, time.UTC)
+	}
+	runs := []historyRun{
+		{Time: at(1, 20), Records: []historyRecord{{NodeKey: "a", DownloadSpeed: 10}, {NodeKey: "b", DownloadSpeed: 40}}},
+		{Time: at(1, 23), Records: []historyRecord{{NodeKey: "a", DownloadSpeed: 20}, {NodeKey: "b", DownloadSpeed: 0}}},
+		{Time: at(2, 0), Records: []historyRecord{{NodeKey: "a", DownloadSpeed: 90}}},
+		{Time: at(2, 10), Records: []historyRecord{{NodeKey: "a", DownloadSpeed: 30}, {NodeKey: "b", DownloadSpeed: 50}}},
+	}
+	profiles := peakProfiles(runs, hourRange{19, 23}, time.UTC)
+	a, b := profiles["a"], profiles["b"]
+	if a == nil || a.PeakSpeed != 15 || a.PeakSamples != 2 || a.OffPeakSpeed != 60 || a.OffPeakSamples != 2 {
+		t.Errorf("profile a = %+v", a)
+	}
+	// 速度为 0 的失败样本不计入平均
+	if b == nil || b.PeakSpeed != 40 || b.PeakSamples != 1 || b.OffPeakSpeed != 50 || b.OffPeakSamples != 1 {
+		t.Errorf("profile b = %+v", b)
+	}
+
+	// 同样的记录换到 UTC+8，UTC 20:00、23:00 变成次日 04:00、07:00，UTC 0:00 和 10:00 变成 08:00 和 18:00，都不是高峰
+	shifted := peakProfiles(runs, hourRange{19, 23}, time.FixedZone("CST", 8*3600))
+	if a := shifted["a"]; a.PeakSamples != 0 || a.PeakSpeed != 0 || a.OffPeakSamples != 4 || a.OffPeakSpeed != 37.5 {
+		t.Errorf("shifted profile a = %+v", a)
+	}
+	if len(peakProfiles(nil, hourRange{19, 23}, time.UTC)) != 0 {
+		t.Errorf("profiles from empty history")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+	"gopkg.in/yaml.v3"
+)
+
+// saveConfigPerSource 把可用节点按来源分别写到 dir 下，文件名沿用原始文件名，订阅地址使用 source-N.yaml
+func saveConfigPerSource(dir string, reports []*sourceReport, results []*speedtester.Result, preserve bool) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalln("create %s failed: %v", dir, err)
+	}
+	bySource := make(map[string][]map[string]any)
+	for _, result := range results {
+		bySource[result.Source] = append(bySource[result.Source], result.ProxyConfig)
+	}
+
+	used := make(map[string]bool)
+	for i, report := range reports {
+		name := perSourceFileName(report.Path, i)
+		if used[name] {
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, filepath.Ext(name)), i+1, filepath.Ext(name))
+		}
+		used[name] = true
+
+		proxies := bySource[report.Path]
+		var data []byte
+		var err error
+		if preserve && len(report.Raw) > 0 {
+			data, err = replaceProxies(report.Raw, proxies)
+		} else {
+			data, err = marshalProxies(proxies)
+		}
+		if err != nil {
+			log.Warnln("write %s for %s failed: %v", name, report.Path, err)
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := writeFileAtomic(path, data, 0o644); err != nil {
+			log.Fatalln("save config file: %s failed: %v", path, err)
+		}
+		fmt.Fprintf(console, "save %d nodes of %s to: %s\n", len(proxies), report.Path, path)
+	}
+}
+
+var httpSourcePattern = regexp.MustCompile(`^https?://`)
+
+func perSourceFileName(path string, index int) string {
+	if httpSourcePattern.MatchString(path) {
+		return fmt.Sprintf("source-%d.yaml", index+1)
+	}
+	// -sources 里起了名字的订阅用名字作为文件名
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".yaml" && ext != ".yml" {
+		return filepath.Base(path) + ".yaml"
+	}
+	return filepath.Base(path)
+}
+
+// replaceProxies 在原始配置的 yaml.Node 上只替换 proxies 列表，并从 proxy-groups 中删掉被淘汰的节点，
+// 其余内容（包括注释和锚点）尽量原样保留
+func replaceProxies(raw []byte, survivors []map[string]any) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level is not a mapping")
+	}
+	root := doc.Content[0]
+
+	kept := make(map[string]bool, len(survivors))
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, proxy := range survivors {
+		node, err := orderedMapNode(proxy, proxyKeyOrder)
+		if err != nil {
+			return nil, err
+		}
+		list.Content = append(list.Content, node)
+		if name, ok := proxy["name"].(string); ok {
+			kept[name] = true
+		}
+	}
+
+	removed := make(map[string]bool)
+	if old := mappingValue(root, "proxies"); old != nil {
+		for _, item := range old.Content {
+			if nameNode := mappingValue(item, "name"); nameNode != nil && !kept[nameNode.Value] {
+				removed[nameNode.Value] = true
+			}
+		}
+		// 保留原来 proxies 节点上的注释
+		list.HeadComment, list.LineComment, list.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		setMappingValue(root, "proxies", list)
+	} else {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "proxies"}, list)
+	}
+
+	if groups := mappingValue(root, "proxy-groups"); groups != nil && groups.Kind == yaml.SequenceNode {
+		for _, group := range groups.Content {
+			members := mappingValue(group, "proxies")
+			if members == nil || members.Kind != yaml.SequenceNode {
+				continue
+			}
+			filtered := members.Content[:0]
+			for _, member := range members.Content {
+				if !removed[member.Value] {
+					filtered = append(filtered, member)
+				}
+			}
+			members.Content = filtered
+			if len(filtered) == 0 && mappingValue(group, "use") == nil {
+				if name := mappingValue(group, "name"); name != nil {
+					log.Warnln("proxy group %s has no proxies left", name.Value)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestReplaceProxiesRoundTrip(t *testing.T) {
+	raw, err := os.ReadFile("testdata/persource/source.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	survivors := []map[string]any{
+		{"name": "HK 01", "type": "ss", "server": "1.1.1.1", "port": 8388, "cipher": "aes-128-gcm", "password": "p"},
+	}
+	data, err := replaceProxies(raw, survivors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{
+		"# 机场订阅，更新于 2026-10-01",
+		"mixed-port: 7891 # 本地混合端口",
+		"dns: &dns",
+		"x-group-defaults: &group-defaults",
+		"<<: *group-defaults",
+		"# 节点列表",
+		"- DOMAIN-SUFFIX,google.com,Auto # 谷歌走自动选择",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lost %q:\n%s", want, out)
+		}
+	}
+
+	var config struct {
+		Port    int              `yaml:"port"`
+		DNS     map[string]any   `yaml:"dns"`
+		Proxies []map[string]any `yaml:"proxies"`
+		Groups  []struct {
+			Name     string   `yaml:"name"`
+			URL      string   `yaml:"url"`
+			Proxies  []string `yaml:"proxies"`
+			Use      []string `yaml:"use"`
+			Interval int      `yaml:"interval"`
+		} `yaml:"proxy-groups"`
+		Rules []string `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatalf("output is not valid yaml: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(config.Proxies, survivors) {
+		t.Errorf("proxies = %v", config.Proxies)
+	}
+	if config.Port != 7890 || config.DNS["enable"] != true || len(config.Rules) != 2 {
+		t.Errorf("non-proxy content changed: port %d, dns %v, rules %v", config.Port, config.DNS, config.Rules)
+	}
+	wantGroups := map[string][]string{"Auto": {"HK 01"}, "Japan": {"DIRECT"}, "Provider": {}}
+	for _, group := range config.Groups {
+		if want := wantGroups[group.Name]; !reflect.DeepEqual(group.Proxies, want) && !(len(want) == 0 && len(group.Proxies) == 0) {
+			t.Errorf("group %s proxies = %v, want %v", group.Name, group.Proxies, want)
+		}
+	}
+	if auto := config.Groups[0]; auto.URL != "http://www.gstatic.com/generate_204" || auto.Interval != 300 {
+		t.Errorf("merge key not preserved: %+v", auto)
+	}
+	if provider := config.Groups[2]; !reflect.DeepEqual(provider.Use, []string{"remote"}) {
+		t.Errorf("provider group use = %v", provider.Use)
+	}
+
+	// 对输出再做一次替换应该得到完全相同的内容
+	again, err := replaceProxies(data, survivors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != out {
+		t.Errorf("second round trip differs:\n%s\n---\n%s", out, again)
+	}
+}
+
+func TestReplaceProxiesErrors(t *testing.T) {
+	if _, err := replaceProxies([]byte("- a\n- b\n"), nil); err == nil {
+		t.Errorf("top level sequence accepted")
+	}
+	data, err := replaceProxies([]byte("port: 7890\n"), []map[string]any{{"name": "HK 01", "type": "ss"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "port: 7890\nproxies:\n  - name: HK 01\n    type: ss\n"; string(data) != want {
+		t.Errorf("config without proxies = %q, want %q", data, want)
+	}
+}
+
+func TestPerSourceFileName(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		index int
+		want  string
+	}{
+		{"https://example.com/sub?token=x", 0, "source-1.yaml"},
+		{"http://example.com/a.yaml", 2, "source-3.yaml"},
+		{"/etc/clash/airport.yml", 0, "airport.yml"},
+		{"configs/airport.yaml", 1, "airport.yaml"},
+		{"my airport", 0, "my airport.yaml"},
+	} {
+		if got := perSourceFileName(tc.path, tc.index); got != tc.want {
+			t.Errorf("perSourceFileName(%q, %d) = %q, want %q", tc.path, tc.index, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+var nodeKeyPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// pinRule 是 -pin 文件中的一行，可以是节点名称、/正则/ 或者 NodeKey
+type pinRule struct {
+	raw     string
+	name    string
+	key     string
+	re      *regexp.Regexp
+	matched bool
+}
+
+// pinList 中的节点即使本次测试不达标也会写入输出文件
+type pinList struct {
+	rules []*pinRule
+	// keys 是匹配过的节点的 NodeKey，-name-prefix 给结果改名后按名称写的规则仍然能对应上
+	keys map[string]bool
+}
+
+// loadPinList 读取 -pin 文件，每行一条规则，空行和 # 开头的行会被忽略：
+//
+//	我的家宽节点
+//	/^Home-/
+//	3f2a9c0d1e4b5a67
+func loadPinList(path string) (*pinList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pins := &pinList{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := &pinRule{raw: line}
+		switch {
+		case len(line) > 2 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+			re, err := regexp.Compile(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			rule.re = re
+		case nodeKeyPattern.MatchString(line):
+			rule.key = line
+		default:
+			rule.name = line
+		}
+		pins.rules = append(pins.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// match 判断节点是否被固定，并记录命中的规则
+func (p *pinList) match(config map[string]any) bool {
+	if p == nil {
+		return false
+	}
+	name, _ := config["name"].(string)
+	key := speedtester.NodeKey(config)
+	pinned := p.keys[key]
+	for _, rule := range p.rules {
+		if (rule.name != "" && rule.name == name) ||
+			(rule.key != "" && rule.key == key) ||
+			(rule.re != nil && rule.re.MatchString(name)) {
+			rule.matched = true
+			pinned = true
+		}
+	}
+	if pinned {
+		if p.keys == nil {
+			p.keys = make(map[string]bool)
+		}
+		p.keys[key] = true
+	}
+	return pinned
+}
+
+// unmatched 返回没有匹配到任何节点的规则
+func (p *pinList) unmatched() []string {
+	if p == nil {
+		return nil
+	}
+	var rules []string
+	for _, rule := range p.rules {
+		if !rule.matched {
+			rules = append(rules, rule.raw)
+		}
+	}
+	return rules
+}
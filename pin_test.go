@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func writePinFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pin.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// pinConfig 返回一个名为 name 的节点配置
+func pinConfig(name string) map[string]any {
+	return map[string]any{"name": name, "type": "ss", "server": strings.ToLower(name) + ".example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"}
+}
+
+func TestPinList(t *testing.T) {
+	home := pinConfig("Home-1")
+	office := pinConfig("Office")
+	keyed := pinConfig("Keyed")
+	other := pinConfig("Other")
+
+	pins, err := loadPinList(writePinFile(t, strings.Join([]string{
+		"# 家宽",
+		"/^Home-/",
+		"",
+		"  Office  ",
+		speedtester.NodeKey(keyed),
+		"Missing",
+		"/^Gone-/",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		config map[string]any
+		want   bool
+	}{
+		{home, true},
+		{office, true},
+		{keyed, true},
+		{other, false},
+	} {
+		if got := pins.match(tc.config); got != tc.want {
+			t.Errorf("match(%s) = %v, want %v", tc.config["name"], got, tc.want)
+		}
+	}
+	if got := pins.unmatched(); !slices.Equal(got, []string{"Missing", "/^Gone-/"}) {
+		t.Errorf("unmatched %v", got)
+	}
+
+	// 改名后按 NodeKey 仍然认得出来
+	renamed := map[string]any{}
+	for k, v := range office {
+		renamed[k] = v
+	}
+	renamed["name"] = "🇭🇰 Office"
+	if !pins.match(renamed) {
+		t.Error("renamed pinned node no longer matches")
+	}
+
+	var nilPins *pinList
+	if nilPins.match(home) || nilPins.unmatched() != nil {
+		t.Error("nil pin list matches")
+	}
+}
+
+func TestLoadPinListErrors(t *testing.T) {
+	_, err := loadPinList(writePinFile(t, "Office\n/[/\n"))
+	if err == nil || !strings.Contains(err.Error(), "pin.txt:2:") {
+		t.Errorf("invalid regexp: %v", err)
+	}
+	if _, err := loadPinList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing pin file accepted")
+	}
+	// 单独的 "/" 和 "//" 不是正则，按名称匹配
+	pins, err := loadPinList(writePinFile(t, "/\n//\n"))
+	if err != nil || len(pins.rules) != 2 || pins.rules[0].name != "/" || pins.rules[1].name != "//" {
+		t.Errorf("slash names: %v", err)
+	}
+}
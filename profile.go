@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultProfilePath = "./clash-speedtest.yaml"
+
+const (
+	sourceDefault = "default"
+	sourcePreset  = "preset"
+	sourceProfile = "profile"
+	sourceFlag    = "flag"
+)
+
+// presets 是 -preset 可选的几组常用选项，优先级介于默认值和 profile 之间。
+// 只列出与默认值不同或者需要固定下来的选项
+var presets = map[string]map[string]string{
+	// quick 只测延迟和 10MB 下载，不测上传和额外网站，同时测多个节点
+	"quick": {
+		"download-size":      "10MB",
+		"upload-size":        "0",
+		"extra-connect-url":  "",
+		"extra-download-url": "",
+		"node-concurrent":    "8",
+	},
+	// thorough 下载 50MB、测上传并做持续下载的限速检测，一次只测一个节点，避免节点之间抢带宽
+	"thorough": {
+		"download-size":   "50MB",
+		"upload-size":     "20MB",
+		"sustained":       "30s",
+		"node-concurrent": "1",
+	},
+}
+
+// presetNames 返回按名称排序的 preset 列表，用于错误提示
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 这些选项只能在命令行里指定，不允许写进 profile
+var profileReservedKeys = map[string]bool{
+	"profile":      true,
+	"print-config": true,
+}
+
+// optionSources 记录每个选项最终的取值来源，供 -print-config 展示
+var optionSources = map[string]string{}
+
+// loadProfile 按 默认值 < preset < profile 文件 < 命令行 的优先级合并选项。
+// path 为空时尝试自动加载当前目录下的 clash-speedtest.yaml。preset 可以在命令行或 profile 里指定
+func loadProfile(fs *flag.FlagSet, path string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	fs.VisitAll(func(f *flag.Flag) {
+		optionSources[f.Name] = sourceDefault
+		if explicit[f.Name] {
+			optionSources[f.Name] = sourceFlag
+		}
+	})
+
+	options := make(map[string]any)
+	if path == "" {
+		if _, err := os.Stat(defaultProfilePath); err == nil {
+			path = defaultProfilePath
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read profile %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(data, &options); err != nil {
+			return fmt.Errorf("parse profile %s: %w", path, err)
+		}
+	}
+
+	preset := ""
+	if f := fs.Lookup("preset"); f != nil {
+		preset = f.Value.String()
+		if !explicit["preset"] && options["preset"] != nil {
+			preset, _ = profileValue(options["preset"])
+		}
+	}
+	if err := applyPreset(fs, preset, explicit); err != nil {
+		return err
+	}
+	return mergeProfile(fs, options, explicit)
+}
+
+// applyPreset 把 preset 的选项写入尚未在命令行中显式设置的 flag，之后 profile 里的选项还会再覆盖一次
+func applyPreset(fs *flag.FlagSet, name string, explicit map[string]bool) error {
+	if name == "" {
+		return nil
+	}
+	options, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, supported: %s", name, strings.Join(presetNames(), ", "))
+	}
+	for key, value := range options {
+		if explicit[key] {
+			continue
+		}
+		f := fs.Lookup(key)
+		if f == nil {
+			return fmt.Errorf("preset %s: unknown option %q", name, key)
+		}
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("preset %s: option %q: invalid value %q: %w", name, key, value, err)
+		}
+		optionSources[key] = sourcePreset
+	}
+	return nil
+}
+
+// mergeProfile 把 profile 中的选项写入尚未在命令行中显式设置的 flag，未知的 key 直接报错
+func mergeProfile(fs *flag.FlagSet, options map[string]any, explicit map[string]bool) error {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if profileReservedKeys[key] {
+			errs = append(errs, fmt.Errorf("profile: option %q can only be set on the command line", key))
+			continue
+		}
+		f := fs.Lookup(key)
+		if f == nil {
+			errs = append(errs, fmt.Errorf("profile: unknown option %q", key))
+			continue
+		}
+		if explicit[key] {
+			continue
+		}
+		// 可重复的 flag 按列表逐项设置，避免值里本身带逗号时被拼接错
+		if list, ok := f.Value.(*stringList); ok {
+			if items, ok := options[key].([]any); ok {
+				for _, item := range items {
+					value, err := profileValue(item)
+					if err == nil {
+						err = list.Set(value)
+					}
+					if err != nil {
+						errs = append(errs, fmt.Errorf("profile: option %q: %w", key, err))
+					}
+				}
+				optionSources[key] = sourceProfile
+				continue
+			}
+		}
+		value, err := profileValue(options[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("profile: option %q: %w", key, err))
+			continue
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("profile: option %q: invalid value %q: %w", key, value, err))
+			continue
+		}
+		optionSources[key] = sourceProfile
+	}
+	return errors.Join(errs...)
+}
+
+// profileValue 把 yaml 里的值转换成 flag 能接受的字符串，列表用逗号拼接
+func profileValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return expandHome(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := profileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("nested maps are not supported")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// expandHome 把逗号分隔的每一项开头的 ~/ 换成用户主目录。命令行里由 shell 展开，profile 里需要自己处理
+func expandHome(value string) string {
+	if !strings.Contains(value, "~") {
+		return value
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return value
+	}
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		if item == "~" || strings.HasPrefix(item, "~/") {
+			items[i] = filepath.Join(home, item[1:])
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// printEffectiveConfig 以 yaml 格式输出合并后的全部选项，行尾注释标明取值来源
+func printEffectiveConfig(fs *flag.FlagSet) error {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	fs.VisitAll(func(f *flag.Flag) {
+		if profileReservedKeys[f.Name] {
+			return
+		}
+		var value any = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+			if s, ok := value.(fmt.Stringer); ok {
+				value = s.String()
+			}
+		}
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(value); err != nil {
+			valueNode = &yaml.Node{Kind: yaml.ScalarNode, Value: f.Value.String()}
+		}
+		valueNode.LineComment = optionSources[f.Name]
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: f.Name},
+			valueNode,
+		)
+	})
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
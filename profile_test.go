@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// profileFlags 返回只有测试用到的几个选项的 FlagSet，args 是命令行参数
+func profileFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	t.Cleanup(func() { clear(optionSources) })
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("c", "", "")
+	fs.String("max-latency", "800ms", "")
+	fs.String("download-size", "50MB", "")
+	fs.String("upload-size", "20MB", "")
+	fs.String("extra-connect-url", "", "")
+	fs.String("extra-download-url", "", "")
+	fs.String("node-concurrent", "1", "")
+	fs.String("sustained", "", "")
+	fs.String("preset", "", "")
+	fs.String("profile", "", "")
+	var inject stringList
+	fs.Var(&inject, "inject", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func writeProfile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "clash-speedtest.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfilePrecedence(t *testing.T) {
+	path := writeProfile(t, `
+preset: quick
+max-latency: 500ms
+download-size: 20MB
+extra-connect-url:
+  - https://www.google.com
+  - https://www.youtube.com
+inject:
+  - "a: 1, b: 2"
+  - "c: 3"
+`)
+	fs := profileFlags(t, "-max-latency", "300ms")
+	if err := loadProfile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, value, source string
+	}{
+		{"max-latency", "300ms", sourceFlag},
+		{"download-size", "20MB", sourceProfile},
+		{"upload-size", "0", sourcePreset},
+		{"node-concurrent", "8", sourcePreset},
+		{"extra-connect-url", "https://www.google.com,https://www.youtube.com", sourceProfile},
+		{"sustained", "", sourceDefault},
+	} {
+		if got := fs.Lookup(tc.name).Value.String(); got != tc.value {
+			t.Errorf("-%s = %q, want %q", tc.name, got, tc.value)
+		}
+		if optionSources[tc.name] != tc.source {
+			t.Errorf("-%s comes from %s, want %s", tc.name, optionSources[tc.name], tc.source)
+		}
+	}
+	if got := fs.Lookup("inject").Value.(*stringList); len(*got) != 2 || (*got)[0] != "a: 1, b: 2" {
+		t.Errorf("-inject = %q, want two items", *got)
+	}
+}
+
+func TestLoadProfileExpandsHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	path := writeProfile(t, "c: ~/.config/clash/config.yaml,~/sub.yaml,https://example.com/~/sub\n")
+	fs := profileFlags(t)
+	if err := loadProfile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(home, ".config/clash/config.yaml") + "," + filepath.Join(home, "sub.yaml") + ",https://example.com/~/sub"
+	if got := fs.Lookup("c").Value.String(); got != want {
+		t.Errorf("-c = %q, want %q", got, want)
+	}
+}
+
+func TestLoadProfileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		profile string
+		want    string
+	}{
+		{"unknown-option: 1\n", `unknown option "unknown-option"`},
+		{"print-config: true\n", `option "print-config" can only be set on the command line`},
+		{"max-latency:\n  a: 1\n", "nested maps are not supported"},
+		{"preset: fastest\n", `unknown preset "fastest"`},
+		{"max-latency: [1, 2\n", "parse profile"},
+	} {
+		fs := profileFlags(t)
+		err := loadProfile(fs, writeProfile(t, tc.profile))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("profile %q: error %v, want %q", tc.profile, err, tc.want)
+		}
+	}
+}
+
+func TestPresetsEffectiveOptions(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		preset string
+		check  func() bool
+	}{
+		{"quick", func() bool {
+			return downloadSize == 10*mb && uploadSize == 0 && *nodeConcurrent == 8 &&
+				*extraConnectURL == "" && *extraDownloadURL == "" && *sustained == 0
+		}},
+		{"thorough", func() bool {
+			return downloadSize == 50*mb && uploadSize == 20*mb && *nodeConcurrent == 1 && *sustained == 30*time.Second
+		}},
+	}
+	if len(tests) != len(presets) {
+		t.Fatalf("%d presets, %d tested", len(presets), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			setFlags(t)
+			if err := applyPreset(flag.CommandLine, tt.preset, nil); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check() {
+				t.Errorf("options after -preset %s: download %d, upload %d, node-concurrent %d, sustained %s",
+					tt.preset, downloadSize, uploadSize, *nodeConcurrent, *sustained)
+			}
+			for key := range presets[tt.preset] {
+				if optionSources[key] != sourcePreset {
+					t.Errorf("-%s comes from %q", key, optionSources[key])
+				}
+			}
+			// preset 本身的组合不会触发选项检查的错误
+			for _, err := range validateOptions(flag.CommandLine) {
+				if !isOptionWarning(err) {
+					t.Errorf("-preset %s: %v", tt.preset, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPresetOverrides(t *testing.T) {
+	// 命令行的单个选项覆盖 preset
+	setFlags(t, "node-concurrent", "3")
+	if err := applyPreset(flag.CommandLine, "quick", map[string]bool{"node-concurrent": true}); err != nil {
+		t.Fatal(err)
+	}
+	if *nodeConcurrent != 3 || optionSources["node-concurrent"] != sourceFlag || downloadSize != 10*1024*1024 {
+		t.Errorf("node-concurrent %d from %s, download %d", *nodeConcurrent, optionSources["node-concurrent"], downloadSize)
+	}
+
+	// 命令行的 -preset 覆盖 profile 里的 preset，profile 的选项仍然覆盖 preset
+	path := writeProfile(t, "preset: quick\nupload-size: 5MB\n")
+	fs := profileFlags(t, "-preset", "thorough")
+	if err := loadProfile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, value, source string
+	}{
+		{"download-size", "50MB", sourcePreset},
+		{"sustained", "30s", sourcePreset},
+		{"node-concurrent", "1", sourcePreset},
+		{"upload-size", "5MB", sourceProfile},
+		{"extra-connect-url", "", sourceDefault},
+	} {
+		if got := fs.Lookup(tc.name).Value.String(); got != tc.value || optionSources[tc.name] != tc.source {
+			t.Errorf("-%s = %q from %s, want %q from %s", tc.name, got, optionSources[tc.name], tc.value, tc.source)
+		}
+	}
+}
+
+func TestPrintEffectiveConfig(t *testing.T) {
+	path := writeProfile(t, "upload-size: 5MB\n")
+	fs := profileFlags(t, "-max-latency", "300ms", "-preset", "quick")
+	if err := loadProfile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = out
+	err = printEffectiveConfig(fs)
+	os.Stdout = stdout
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out.Name())
+	for _, want := range []string{
+		"max-latency: 300ms # flag",
+		"download-size: 10MB # preset",
+		"upload-size: 5MB # profile",
+		"sustained: \"\" # default",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in:\n%s", want, data)
+		}
+	}
+	// 只能在命令行指定的选项不输出
+	if strings.Contains(string(data), "profile:") {
+		t.Errorf("-profile printed:\n%s", data)
+	}
+}
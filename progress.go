@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/schollz/progressbar/v3"
+)
+
+// progress 是测试进度的展示接口，总数在所有节点加载、过滤完成后才确定
+type progress interface {
+	speedtester.Progress
+	// Advance 标记一个节点测试完成
+	Advance()
+	// Complete 结束进度展示，reason 非空时说明为什么有节点没有被测试
+	Complete(reason string)
+}
+
+// maxInFlightNames 是进度条上最多同时展示的正在测试的节点数
+const maxInFlightNames = 3
+
+// maxNodeNameWidth 是进度条上单个节点名称的最大显示宽度（按字符计）
+const maxNodeNameWidth = 24
+
+type barProgress struct {
+	bar      *progressbar.ProgressBar
+	out      io.Writer
+	title    string
+	total    int
+	done     int
+	mu       sync.Mutex
+	inFlight []string
+}
+
+func newProgress(total int, title string) progress {
+	return &barProgress{
+		bar:   progressbar.Default(int64(total), title),
+		out:   os.Stderr,
+		title: title,
+		total: total,
+	}
+}
+
+func (p *barProgress) NodeStarted(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight = append(p.inFlight, name)
+	p.describe()
+}
+
+func (p *barProgress) NodeFinished(name string, _ *speedtester.Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, n := range p.inFlight {
+		if n == name {
+			p.inFlight = append(p.inFlight[:i], p.inFlight[i+1:]...)
+			break
+		}
+	}
+	p.describe()
+}
+
+// describe 在标题后面展示正在测试的节点，超过 maxInFlightNames 个时只显示数量
+func (p *barProgress) describe() {
+	names := make([]string, 0, maxInFlightNames)
+	for i, name := range p.inFlight {
+		if i == maxInFlightNames {
+			names = append(names, fmt.Sprintf("+%d", len(p.inFlight)-maxInFlightNames))
+			break
+		}
+		names = append(names, truncateName(name, maxNodeNameWidth))
+	}
+	description := p.title
+	if len(names) > 0 {
+		description += " " + strings.Join(names, ", ")
+	}
+	p.bar.Describe(description)
+}
+
+func truncateName(name string, width int) string {
+	runes := []rune(name)
+	if len(runes) <= width {
+		return name
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func (p *barProgress) Advance() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.bar.Add(1)
+}
+
+func (p *barProgress) Complete(reason string) {
+	skipped := p.total - p.done
+	p.bar.Describe(p.title)
+	p.bar.Finish()
+	fmt.Fprintln(p.out)
+	if skipped > 0 {
+		if reason == "" {
+			reason = "not tested"
+		}
+		fmt.Fprintf(p.out, "%d nodes skipped: %s\n", skipped, reason)
+	}
+}
+
+// countProxies 返回所有来源加载、过滤、去重之后要测试的节点总数，也就是进度条的总数
+func countProxies(sources []map[string]*speedtester.CProxy) int {
+	total := 0
+	for _, proxies := range sources {
+		total += len(proxies)
+	}
+	return total
+}
+
+// nopProgress 用于不需要进度条的输出模式，例如 -oneline
+type nopProgress struct{}
+
+func (nopProgress) NodeStarted(string) {}
+
+func (nopProgress) NodeFinished(string, *speedtester.Result) {}
+
+func (nopProgress) Advance() {}
+
+func (nopProgress) Complete(string) {}
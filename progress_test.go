@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/schollz/progressbar/v3"
+)
+
+func silentProgress(total int, out *bytes.Buffer) *barProgress {
+	return &barProgress{bar: progressbar.DefaultSilent(int64(total), "test"), out: out, title: "test", total: total}
+}
+
+// TestProgressTotalAfterFilters 检查进度条总数是过滤之后的节点数，测完全部节点时没有跳过的提示
+func TestProgressTotalAfterFilters(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := write("a.yaml", `proxies:
+  - {name: HK 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: HK 02, type: ss, server: 1.1.1.2, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: US 01, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: 剩余流量 10G, type: ss, server: 4.4.4.4, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	second := write("b.yaml", `proxies:
+  - {name: 香港 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: HK 03, type: ss, server: 1.1.1.3, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	var sources []map[string]*speedtester.CProxy
+	for _, path := range []string{first, second} {
+		st := speedtester.New(&speedtester.Config{ConfigPaths: path, FilterRegex: "HK|香港|流量", BlockRegex: "流量"})
+		report, err := st.LoadProxies(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, report.Proxies)
+	}
+	total := countProxies(sources)
+	if total != 4 {
+		t.Fatalf("total = %d, want 4", total)
+	}
+
+	var out bytes.Buffer
+	p := silentProgress(total, &out)
+	for _, proxies := range sources {
+		for name := range proxies {
+			p.NodeStarted(name)
+			p.NodeFinished(name, nil)
+			p.Advance()
+		}
+	}
+	p.Complete("")
+	if state := p.bar.State(); state.CurrentNum != state.Max || state.Max != 4 {
+		t.Errorf("bar at %d of %d, want 4 of 4", state.CurrentNum, state.Max)
+	}
+	if bytes.Contains(out.Bytes(), []byte("skipped")) {
+		t.Errorf("unexpected skip line: %q", out.String())
+	}
+}
+
+// TestProgressTotalAfterDedup 检查进度条总数是 -dedup 去重之后的节点数
+func TestProgressTotalAfterDedup(t *testing.T) {
+	node := func(name, server string) *speedtester.CProxy {
+		return &speedtester.CProxy{Config: map[string]any{"name": name, "type": "ss", "server": server, "port": 443, "cipher": "aes-128-gcm", "password": "p"}}
+	}
+	sources := []map[string]*speedtester.CProxy{
+		{"HK 01": node("HK 01", "1.1.1.1"), "HK 02": node("HK 02", "1.1.1.2")},
+		{"香港 01": node("香港 01", "1.1.1.1"), "HK 03": node("HK 03", "1.1.1.3")},
+	}
+	sources, dropped := speedtester.DeduplicateProxies(sources)
+	total := countProxies(sources)
+	if total != 3 || dropped != 1 {
+		t.Fatalf("total after dedup = %d dropped %d, want 3 and 1", total, dropped)
+	}
+
+	var out bytes.Buffer
+	p := silentProgress(total, &out)
+	for _, proxies := range sources {
+		for name := range proxies {
+			p.NodeStarted(name)
+			p.NodeFinished(name, nil)
+			p.Advance()
+		}
+	}
+	p.Complete("")
+	if state := p.bar.State(); state.CurrentNum != state.Max || state.Max != 3 {
+		t.Errorf("bar at %d of %d, want 3 of 3", state.CurrentNum, state.Max)
+	}
+	if bytes.Contains(out.Bytes(), []byte("skipped")) {
+		t.Errorf("unexpected skip line: %q", out.String())
+	}
+}
+
+func TestProgressCompleteReason(t *testing.T) {
+	for _, tc := range []struct {
+		reason string
+		want   string
+	}{
+		{"interrupted", "2 nodes skipped: interrupted\n"},
+		{"", "2 nodes skipped: not tested\n"},
+	} {
+		var out bytes.Buffer
+		p := silentProgress(5, &out)
+		for range 3 {
+			p.Advance()
+		}
+		p.Complete(tc.reason)
+		if got := out.String(); got != "\n"+tc.want {
+			t.Errorf("Complete(%q) printed %q, want %q", tc.reason, got, "\n"+tc.want)
+		}
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	if got := truncateName("香港 01", 24); got != "香港 01" {
+		t.Errorf("short name changed to %q", got)
+	}
+	if got := truncateName("日本东京 IPLC 专线 01 x2 倍率 流媒体解锁", 10); got != "日本东京 IPLC…" || len([]rune(got)) != 10 {
+		t.Errorf("truncated to %q", got)
+	}
+}
+
+func TestProgressInFlightNames(t *testing.T) {
+	p := silentProgress(5, &bytes.Buffer{})
+	description := func() string { return p.bar.State().Description }
+
+	p.NodeStarted("香港 01")
+	p.NodeStarted("日本东京 IPLC 专线 01 x2 倍率 流媒体解锁")
+	if got, want := description(), "test 香港 01, 日本东京 IPLC 专线 01 x2 倍率 流…"; got != want {
+		t.Errorf("description %q, want %q", got, want)
+	}
+	p.NodeStarted("US 01")
+	p.NodeStarted("SG 01")
+	p.NodeStarted("TW 01")
+	if got, want := description(), "test 香港 01, 日本东京 IPLC 专线 01 x2 倍率 流…, US 01, +2"; got != want {
+		t.Errorf("description %q, want %q", got, want)
+	}
+	p.NodeFinished("香港 01", nil)
+	p.NodeFinished("US 01", nil)
+	if got, want := description(), "test 日本东京 IPLC 专线 01 x2 倍率 流…, SG 01, TW 01"; got != want {
+		t.Errorf("description %q, want %q", got, want)
+	}
+	for _, name := range []string{"日本东京 IPLC 专线 01 x2 倍率 流媒体解锁", "SG 01", "TW 01"} {
+		p.NodeFinished(name, nil)
+	}
+	if got := description(); got != "test" {
+		t.Errorf("description %q after all nodes finished, want the bare title", got)
+	}
+}
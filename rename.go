@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+)
+
+// formatRegion 是表格里地区一列的内容，例如 "🇯🇵 JP Tokyo"
+func formatRegion(result *speedtester.Result) string {
+	if result.CountryCode == "" {
+		return "N/A"
+	}
+	region := countryFlag(result.CountryCode) + " " + result.CountryCode
+	if result.City != "" {
+		region += " " + result.City
+	}
+	return region
+}
+
+// stripVolatileProxy 返回删掉 -volatile-fields 字段、去掉名称后缀的节点配置副本。
+// taken 是输出文件里已经用过的名字，去掉后缀后和别的节点重名时保留原名；taken 为 nil 时不改名称
+func stripVolatileProxy(proxy map[string]any, taken map[string]bool) map[string]any {
+	stripped := volatileFields.StripPaths(proxy)
+	name, _ := proxy["name"].(string)
+	if trimmed := volatileFields.TrimName(name); taken != nil && trimmed != name && !taken[trimmed] {
+		taken[trimmed] = true
+		stripped = maps.Clone(stripped)
+		stripped["name"] = trimmed
+	}
+	return stripped
+}
+
+// warnVolatileFields 统计带有 -volatile-fields 字段的节点。没有 -strip-volatile 时这些字段仍会写进输出，
+// 每次运行输出文件都会有差异
+func warnVolatileFields(sources []map[string]*speedtester.CProxy) {
+	if volatileFields.Empty() {
+		return
+	}
+	counts := make(map[string]int)
+	nodes := 0
+	for _, proxies := range sources {
+		for _, proxy := range proxies {
+			present := volatileFields.Present(proxy.Config)
+			for _, field := range present {
+				counts[field]++
+			}
+			if len(present) > 0 {
+				nodes++
+			}
+		}
+	}
+	if nodes == 0 {
+		return
+	}
+	fields := make([]string, 0, len(counts))
+	for field, count := range counts {
+		fields = append(fields, fmt.Sprintf("%s x%d", field, count))
+	}
+	sort.Strings(fields)
+	if *stripVolatile {
+		log.Infoln("%d nodes have volatile fields (%s), they are removed from the output", nodes, strings.Join(fields, ", "))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%swarning: %d nodes have volatile fields (%s), they are ignored when comparing runs but still written to the output, add -strip-volatile to remove them%s\n",
+		colorYellow, nodes, strings.Join(fields, ", "), colorReset)
+}
+
+// renameProxy 返回按 generateNodeName 改名后的节点配置副本，result 里的配置不变，-rename-city 时名字里带上城市。
+// names 记录同一个输出文件里已经用过的名字，重名时加上序号，clash 要求节点名唯一
+func renameProxy(result *speedtester.Result, names map[string]int) map[string]any {
+	city := ""
+	if *renameCity {
+		city = result.City
+	}
+	base := generateNodeName(result.CountryCode, city, result.DownloadSpeed)
+	name := base
+	for i := 2; names[name] > 0; i++ {
+		name = fmt.Sprintf("%s %d", base, i)
+	}
+	names[name]++
+	proxy := maps.Clone(result.ProxyConfig)
+	proxy["name"] = name
+	return proxy
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestRenameProxy(t *testing.T) {
+	setFlags(t, "rename-city", "true")
+	names := map[string]int{}
+	a := capResult("A", 12.4)
+	a.CountryCode, a.City = "JP", "Tokyo"
+	b := capResult("B", 12.4)
+	b.CountryCode, b.City = "JP", "Tokyo"
+	c := capResult("C", 12.4)
+	c.CountryCode, c.City = "JP", "Tokyo"
+
+	var got []string
+	for _, result := range []*speedtester.Result{a, b, c} {
+		got = append(got, renameProxy(result, names)["name"].(string))
+	}
+	want := []string{"🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s", "🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s 2", "🇯🇵 JP Tokyo | ⬇️ 12.40 MB/s 3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("name %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	// 结果里的配置不变
+	if a.ProxyConfig["name"] != "A" {
+		t.Errorf("renameProxy modified the result config: %v", a.ProxyConfig["name"])
+	}
+	// 没有 -rename-city 时只有国家
+	*renameCity = false
+	if got := renameProxy(a, map[string]int{})["name"]; got != "🇯🇵 JP | ⬇️ 12.40 MB/s" {
+		t.Errorf("name without -rename-city = %q", got)
+	}
+}
+
+func TestFormatRegion(t *testing.T) {
+	tests := []struct {
+		country, city string
+		want          string
+	}{
+		{"JP", "Tokyo", "🇯🇵 JP Tokyo"},
+		{"US", "", "🇺🇸 US"},
+		{"", "Tokyo", "N/A"},
+	}
+	for _, tt := range tests {
+		result := &speedtester.Result{CountryCode: tt.country, City: tt.city}
+		if got := formatRegion(result); got != tt.want {
+			t.Errorf("formatRegion(%q, %q) = %q, want %q", tt.country, tt.city, got, tt.want)
+		}
+	}
+}
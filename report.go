@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// sourceReport 是单个配置文件或订阅的加载结果
+type sourceReport struct {
+	Path string
+	*speedtester.LoadReport
+}
+
+// formatSkipped 把按类型统计的跳过数量格式化成 "anytls x3, mieru x2"
+func formatSkipped(skipped map[string]int) string {
+	types := make([]string, 0, len(skipped))
+	for t := range skipped {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%s x%d", t, skipped[t]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printLoadReport 输出单个来源的加载摘要，只有出现跳过或解析错误时才输出
+func printLoadReport(path string, report *speedtester.LoadReport) {
+	if len(report.Skipped) == 0 && len(report.ParseErrors) == 0 && report.StashIncompatible == 0 && report.TypeExcluded == 0 &&
+		report.ServerCountryFiltered == 0 && report.ServerCountryUnknown == 0 && len(report.Normalized) == 0 {
+		return
+	}
+	parts := []string{fmt.Sprintf("%d proxies loaded", len(report.Proxies))}
+	if len(report.Skipped) > 0 {
+		parts = append(parts, "skipped unsupported types: "+formatSkipped(report.Skipped))
+	}
+	if report.StashIncompatible > 0 {
+		parts = append(parts, fmt.Sprintf("%d stash incompatible", report.StashIncompatible))
+	}
+	if report.TypeExcluded > 0 {
+		parts = append(parts, fmt.Sprintf("%d excluded by type", report.TypeExcluded))
+	}
+	if report.ServerCountryFiltered > 0 {
+		parts = append(parts, fmt.Sprintf("%d dropped by server country", report.ServerCountryFiltered))
+	}
+	if report.ServerCountryUnknown > 0 {
+		parts = append(parts, fmt.Sprintf("%d dropped with unknown server country", report.ServerCountryUnknown))
+	}
+	if len(report.Normalized) > 0 {
+		parts = append(parts, "normalized fields: "+formatSkipped(report.Normalized))
+	}
+	if len(report.ParseErrors) > 0 {
+		parts = append(parts, fmt.Sprintf("%d parse errors (first: %v)", len(report.ParseErrors), report.ParseErrors[0]))
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(path), strings.Join(parts, "; "))
+}
+
+// printFunnel 在没有任何可用节点时输出各环节的节点数，帮助定位节点是在哪一步被丢弃的
+func printFunnel(reports []*sourceReport, tested int) {
+	var total, parseErrors, stash, typeExcluded, blocked, filtered, serverCountry, loaded int
+	skipped := make(map[string]int)
+	for _, report := range reports {
+		total += report.Total
+		parseErrors += len(report.ParseErrors)
+		stash += report.StashIncompatible
+		typeExcluded += report.TypeExcluded
+		blocked += report.Blocked
+		filtered += report.FilteredOut
+		serverCountry += report.ServerCountryFiltered + report.ServerCountryUnknown
+		loaded += len(report.Proxies)
+		for t, n := range report.Skipped {
+			skipped[t] += n
+		}
+	}
+	skippedCount := 0
+	for _, n := range skipped {
+		skippedCount += n
+	}
+
+	fmt.Fprintln(os.Stderr, "no usable nodes, where the nodes went:")
+	fmt.Fprintf(os.Stderr, "  %6d nodes in %d sources\n", total, len(reports))
+	if parseErrors > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d failed to parse\n", -parseErrors)
+	}
+	if skippedCount > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d unsupported types (%s)\n", -skippedCount, formatSkipped(skipped))
+	}
+	if stash > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d stash incompatible\n", -stash)
+	}
+	if typeExcluded > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d excluded by -type\n", -typeExcluded)
+	}
+	if blocked > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d blocked by -b\n", -blocked)
+	}
+	if filtered > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d filtered by -f\n", -filtered)
+	}
+	if serverCountry > 0 {
+		fmt.Fprintf(os.Stderr, "  %6d dropped by -server-countries\n", -serverCountry)
+	}
+	fmt.Fprintf(os.Stderr, "  %6d tested (of %d loaded)\n", tested, loaded)
+	fmt.Fprintf(os.Stderr, "  %6d usable\n", 0)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// sortKeys 是 -sort 可以使用的排序项和它们的默认方向，true 表示降序
+var sortKeys = map[string]bool{
+	"peak-speed": true,
+	"download":   true,
+	"upload":     true,
+	"latency":    false,
+	"jitter":     false,
+	"loss":       false,
+	"name":       false,
+}
+
+// resultSort 是 -sort 的取值，key 为空时使用默认顺序：优质节点在前，然后按下载速度从高到低
+type resultSort struct {
+	key  string
+	desc bool
+}
+
+// parseResultSort 解析 "latency"、"download:asc" 这样的写法
+func parseResultSort(spec string) (resultSort, error) {
+	if spec == "" {
+		return resultSort{}, nil
+	}
+	key, direction, _ := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), ":")
+	desc, ok := sortKeys[key]
+	if !ok {
+		return resultSort{}, fmt.Errorf("unknown value %q, supported: download, upload, latency, jitter, loss, name, peak-speed, with an optional :asc or :desc suffix", spec)
+	}
+	switch direction {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return resultSort{}, fmt.Errorf("unknown direction %q in %q, use asc or desc", direction, spec)
+	}
+	return resultSort{key: key, desc: desc}, nil
+}
+
+// value 返回排序用的数值，name 不使用这个值
+func (s resultSort) value(result *speedtester.Result) float64 {
+	switch s.key {
+	case "download":
+		return result.DownloadSpeed
+	case "upload":
+		return result.UploadSpeed
+	case "latency":
+		return float64(result.Latency)
+	case "jitter":
+		return float64(result.Jitter)
+	case "loss":
+		return result.PacketLoss
+	}
+	return 0
+}
+
+// sortResults 按 -sort 排序，表格和保存的配置都使用这个顺序。
+// 没有测出延迟的节点不论方向都排在最后，其余相同时按节点名和 NodeKey 排序，保证每次输出的顺序稳定
+func sortResults(results []*speedtester.Result) {
+	s, _ := parseResultSort(*sortBy)
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch s.key {
+		case "peak-speed":
+			if pa, pb := peakSpeedOf(a), peakSpeedOf(b); pa != pb {
+				return pa < pb != s.desc
+			}
+			// 高峰速度相同时沿用默认顺序
+			fallthrough
+		case "":
+			if isProxyGood(a) != isProxyGood(b) {
+				return isProxyGood(a)
+			}
+			if a.DownloadSpeed != b.DownloadSpeed {
+				return a.DownloadSpeed > b.DownloadSpeed
+			}
+		default:
+			if (a.Latency == 0) != (b.Latency == 0) {
+				return a.Latency != 0
+			}
+			if s.key == "name" {
+				if a.ProxyName != b.ProxyName {
+					return a.ProxyName < b.ProxyName != s.desc
+				}
+			} else if va, vb := s.value(a), s.value(b); va != vb {
+				return va < vb != s.desc
+			}
+		}
+		if a.ProxyName != b.ProxyName {
+			return a.ProxyName < b.ProxyName
+		}
+		return speedtester.NodeKey(a.ProxyConfig) < speedtester.NodeKey(b.ProxyConfig)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestParseResultSort(t *testing.T) {
+	tests := []struct {
+		spec string
+		want resultSort
+		err  string
+	}{
+		{"", resultSort{}, ""},
+		{"download", resultSort{"download", true}, ""},
+		{"Latency", resultSort{"latency", false}, ""},
+		{"latency:desc", resultSort{"latency", true}, ""},
+		{" download:asc ", resultSort{"download", false}, ""},
+		{"speed", resultSort{}, `unknown value "speed"`},
+		{"name:up", resultSort{}, `unknown direction "up" in "name:up"`},
+	}
+	for _, tt := range tests {
+		got, err := parseResultSort(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseResultSort(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseResultSort(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+// sortTestResults 返回打乱顺序的节点：Dead 没有测出延迟，Twin 和 B 的下载速度相同
+func sortTestResults() []*speedtester.Result {
+	node := func(name string, latency time.Duration, speed float64) *speedtester.Result {
+		result := capResult(name, speed)
+		result.Latency = latency * time.Millisecond
+		result.UploadSpeed = 10*1024*1024 - result.DownloadSpeed
+		return result
+	}
+	return []*speedtester.Result{
+		node("Dead", 0, 0),
+		node("C", 300, 2),
+		node("Twin", 50, 8),
+		node("A", 100, 20),
+		node("B", 200, 8),
+	}
+}
+
+func TestSortResults(t *testing.T) {
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		// 默认优质节点在前，再按下载速度，速度相同时按名称
+		{"", []string{"A", "B", "Twin", "C", "Dead"}},
+		{"download", []string{"A", "B", "Twin", "C", "Dead"}},
+		{"download:asc", []string{"C", "B", "Twin", "A", "Dead"}},
+		{"upload", []string{"C", "B", "Twin", "A", "Dead"}},
+		// 没有测出延迟的节点不论方向都排在最后
+		{"latency", []string{"Twin", "A", "B", "C", "Dead"}},
+		{"latency:desc", []string{"C", "B", "A", "Twin", "Dead"}},
+		{"name", []string{"A", "B", "C", "Twin", "Dead"}},
+		{"name:desc", []string{"Twin", "C", "B", "A", "Dead"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			setFlags(t, "sort", tt.sort, "good-download-speed-threshold", "10")
+			results := sortTestResults()
+			sortResults(results)
+			if got := resultNames(results); !slices.Equal(got, tt.want) {
+				t.Errorf("-sort %q: %v, want %v", tt.sort, got, tt.want)
+			}
+		})
+	}
+}
+
+// 值相同的节点按名称排序，每次运行的输出顺序不受测试完成先后影响
+func TestSortResultsStable(t *testing.T) {
+	want := []string{"A", "B", "C"}
+	for _, sortBy := range []string{"", "download"} {
+		setFlags(t, "sort", sortBy)
+		for _, order := range [][]string{{"A", "B", "C"}, {"C", "B", "A"}, {"B", "C", "A"}} {
+			var results []*speedtester.Result
+			for _, name := range order {
+				results = append(results, capResult(name, 5))
+			}
+			sortResults(results)
+			if got := resultNames(results); !slices.Equal(got, want) {
+				t.Errorf("-sort %q from %v: %v, want %v", sortBy, order, got, want)
+			}
+		}
+	}
+}
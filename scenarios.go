@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// scenario 是 -scenarios 文件中的一个使用场景，声明需要的测试阶段和判定阈值。
+// 每个节点只测试一次，测试阶段取所有场景的并集，然后按各个场景的阈值分别判定
+type scenario struct {
+	Name string `yaml:"name"`
+	// 需要的测试阶段
+	Sustained    string `yaml:"sustained"`
+	CloseLatency bool   `yaml:"close-latency"`
+	WebSocket    bool   `yaml:"websocket"`
+	// 判定阈值，速度的单位是 MB/s，不填的不检查
+	MaxLatency        string   `yaml:"max-latency"`
+	MaxJitter         string   `yaml:"max-jitter"`
+	MaxPacketLoss     *float64 `yaml:"max-packet-loss"`
+	MaxCloseLatency   string   `yaml:"max-close-latency"`
+	MinSpeed          *float64 `yaml:"min-speed"`
+	MinUploadSpeed    *float64 `yaml:"min-upload-speed"`
+	MinSustainedSpeed *float64 `yaml:"min-sustained-speed"`
+	GoodSpeed         *float64 `yaml:"good-download-speed-threshold"`
+
+	sustained       time.Duration
+	maxLatency      time.Duration
+	maxJitter       time.Duration
+	maxCloseLatency time.Duration
+}
+
+func loadScenarios(path string) ([]*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenarios, err := parseScenarios(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return scenarios, nil
+}
+
+// parseScenarios 解析场景列表，除 name 以外都是可选的：
+//
+//	# scenarios.yaml
+//	- name: streaming
+//	  sustained: 30s
+//	  max-latency: 800ms
+//	  min-speed: 5
+//	  min-sustained-speed: 3
+//	  good-download-speed-threshold: 10
+//	- name: browsing
+//	  max-latency: 300ms
+//	  max-jitter: 50ms
+//	  max-packet-loss: 0
+//	  min-speed: 0.5
+func parseScenarios(data []byte) ([]*scenario, error) {
+	var scenarios []*scenario
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&scenarios); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	names := make(map[string]bool, len(scenarios))
+	for i, s := range scenarios {
+		if s.Name == "" {
+			errs = append(errs, fmt.Errorf("scenario %d: name is required", i+1))
+			continue
+		}
+		if names[s.Name] {
+			errs = append(errs, fmt.Errorf("scenario %d: duplicate name %q", i+1, s.Name))
+		}
+		names[s.Name] = true
+		for _, d := range []struct {
+			key   string
+			value string
+			dst   *time.Duration
+		}{
+			{"sustained", s.Sustained, &s.sustained},
+			{"max-latency", s.MaxLatency, &s.maxLatency},
+			{"max-jitter", s.MaxJitter, &s.maxJitter},
+			{"max-close-latency", s.MaxCloseLatency, &s.maxCloseLatency},
+		} {
+			if d.value == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.value)
+			if err != nil || v < 0 {
+				errs = append(errs, fmt.Errorf("scenario %s: invalid %s %q", s.Name, d.key, d.value))
+				continue
+			}
+			*d.dst = v
+		}
+	}
+	return scenarios, errors.Join(errs...)
+}
+
+// applyScenarioPhases 打开所有场景需要的测试阶段，sustained 取最长的时长。
+// WebSocket 测试需要 -test-websocket 指定的 echo 服务器，min-sustained-speed 需要有场景或 -sustained 打开持续下载，
+// 缺少时所有节点都会在这个场景中不可用，直接报错
+func applyScenarioPhases(scenarios []*scenario, config *speedtester.Config) error {
+	for _, s := range scenarios {
+		if s.WebSocket && config.WebSocketURL == "" {
+			return fmt.Errorf("scenario %s needs -test-websocket for its websocket check", s.Name)
+		}
+		config.SustainedDuration = max(config.SustainedDuration, s.sustained)
+		config.CloseLatency = config.CloseLatency || s.CloseLatency || s.maxCloseLatency > 0
+	}
+	for _, s := range scenarios {
+		if s.MinSustainedSpeed != nil && config.SustainedDuration == 0 {
+			return fmt.Errorf("scenario %s sets min-sustained-speed but no scenario or -sustained enables the sustained download", s.Name)
+		}
+	}
+	return nil
+}
+
+// evaluate 按场景的阈值判定节点，测试本身失败的节点在所有场景中都不可用
+func (s *scenario) evaluate(result *speedtester.Result) speedtester.Verdict {
+	const mb = 1024 * 1024
+	unusable := func(format string, args ...any) speedtester.Verdict {
+		return speedtester.Verdict{Reason: fmt.Sprintf(format, args...)}
+	}
+	switch {
+	case result.Invalid != "":
+		return unusable("invalid measurement: %s", result.Invalid)
+	case result.DirectLeak:
+		return unusable("direct leak")
+	case result.Latency == 0 || result.PacketLoss == 100:
+		return unusable("unreachable")
+	case s.maxLatency > 0 && result.Latency > s.maxLatency:
+		return unusable("latency %s > %s", result.FormatLatency(), s.maxLatency)
+	case s.maxJitter > 0 && result.Jitter > s.maxJitter:
+		return unusable("jitter %s > %s", result.FormatJitter(), s.maxJitter)
+	case s.MaxPacketLoss != nil && result.PacketLoss > *s.MaxPacketLoss:
+		return unusable("packet loss %s > %g%%", result.FormatPacketLoss(), *s.MaxPacketLoss)
+	case s.maxCloseLatency > 0 && (result.CloseTimedOut || result.CloseLatency > s.maxCloseLatency):
+		return unusable("close latency %s > %s", result.CloseLatency, s.maxCloseLatency)
+	case s.MinSpeed != nil && result.DownloadSpeed < *s.MinSpeed*mb:
+		return unusable("download speed %s", result.FormatDownloadSpeed())
+	case s.MinUploadSpeed != nil && result.UploadSpeed < *s.MinUploadSpeed*mb && !(result.UploadBlocked && *allowUploadBlocked):
+		return unusable("upload speed %s", result.FormatUploadSpeed())
+	case s.MinSustainedSpeed != nil && result.SustainedSpeed < *s.MinSustainedSpeed*mb:
+		return unusable("sustained speed %s", speedtester.FormatSpeed(result.SustainedSpeed))
+	case s.WebSocket && !result.WebSocketOK:
+		return unusable("websocket: %s", result.WebSocketError)
+	}
+	return speedtester.Verdict{
+		Usable: true,
+		Good:   s.GoodSpeed == nil || result.DownloadSpeed >= *s.GoodSpeed*mb,
+	}
+}
+
+// evaluateScenarios 把每个场景的判定写入 Result.Scenarios
+func evaluateScenarios(scenarios []*scenario, results []*speedtester.Result) {
+	for _, result := range results {
+		result.Scenarios = make(map[string]speedtester.Verdict, len(scenarios))
+		for _, s := range scenarios {
+			result.Scenarios[s.Name] = s.evaluate(result)
+		}
+	}
+}
+
+// printScenarioSummary 输出每个场景的可用、优质节点数
+func printScenarioSummary(scenarios []*scenario, results []*speedtester.Result) {
+	for _, s := range scenarios {
+		usable, good := 0, 0
+		for _, result := range results {
+			if v := result.Scenarios[s.Name]; v.Usable {
+				usable++
+				if v.Good {
+					good++
+				}
+			}
+		}
+		fmt.Fprintf(os.Stderr, "scenario %s: %d usable, %d good\n", s.Name, usable, good)
+	}
+}
+
+// parseScenarioOutputs 解析 -scenario-output 的 name=path，场景名必须在 -scenarios 文件中
+func parseScenarioOutputs(specs []string, scenarios []*scenario) (map[string]string, error) {
+	known := make(map[string]bool, len(scenarios))
+	for _, s := range scenarios {
+		known[s.Name] = true
+	}
+	outputs := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || path == "" {
+			return nil, fmt.Errorf("invalid %q, expected name=path", spec)
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown scenario %q", name)
+		}
+		outputs[name] = path
+	}
+	return outputs, nil
+}
+
+// saveScenarioOutputs 把每个场景中可用的节点按下载速度排序写到对应的文件
+func saveScenarioOutputs(outputs map[string]string, results []*speedtester.Result) {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var selected []*speedtester.Result
+		for _, result := range results {
+			if result.Scenarios[name].Usable {
+				selected = append(selected, result)
+			}
+		}
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].DownloadSpeed > selected[j].DownloadSpeed
+		})
+		path := outputs[name]
+		if path != stdoutPath {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+		}
+		doSaveConfig(selected, path)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+const scenariosYAML = `- name: streaming
+  sustained: 30s
+  max-latency: 800ms
+  min-speed: 5
+  min-sustained-speed: 3
+  good-download-speed-threshold: 10
+- name: browsing
+  max-latency: 300ms
+  max-jitter: 50ms
+  max-packet-loss: 0
+  min-speed: 0.5
+- name: chat
+  websocket: true
+  max-close-latency: 1s
+`
+
+func TestParseScenarios(t *testing.T) {
+	scenarios, err := parseScenarios([]byte(scenariosYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) != 3 {
+		t.Fatalf("got %d scenarios", len(scenarios))
+	}
+	streaming, browsing, chat := scenarios[0], scenarios[1], scenarios[2]
+	if streaming.sustained != 30*time.Second || streaming.maxLatency != 800*time.Millisecond || *streaming.MinSustainedSpeed != 3 {
+		t.Errorf("streaming %+v", streaming)
+	}
+	if browsing.maxJitter != 50*time.Millisecond || browsing.MaxPacketLoss == nil || *browsing.MaxPacketLoss != 0 || browsing.GoodSpeed != nil {
+		t.Errorf("browsing %+v", browsing)
+	}
+	if !chat.WebSocket || chat.maxCloseLatency != time.Second || chat.MinSpeed != nil {
+		t.Errorf("chat %+v", chat)
+	}
+}
+
+func TestParseScenariosErrors(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{"- name: a\n  max-latancy: 1s\n", "field max-latancy not found"},
+		{"- max-latency: 1s\n", "scenario 1: name is required"},
+		{"- name: a\n- name: a\n", `scenario 2: duplicate name "a"`},
+		{"- name: a\n  max-jitter: fast\n", `scenario a: invalid max-jitter "fast"`},
+		{"- name: a\n  sustained: -1s\n", `scenario a: invalid sustained "-1s"`},
+		{"name: a\n", "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		if _, err := parseScenarios([]byte(tt.yaml)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseScenarios(%q) error %v, want %q", tt.yaml, err, tt.want)
+		}
+	}
+
+	// 所有错误一起报告
+	_, err := parseScenarios([]byte("- name: a\n  max-latency: x\n  max-jitter: y\n- {}\n"))
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("want three errors, got %v", err)
+	}
+}
+
+func TestApplyScenarioPhases(t *testing.T) {
+	scenarios, _ := parseScenarios([]byte(scenariosYAML))
+	config := &speedtester.Config{SustainedDuration: 10 * time.Second, WebSocketURL: "wss://echo.example.com"}
+	if err := applyScenarioPhases(scenarios, config); err != nil {
+		t.Fatal(err)
+	}
+	if config.SustainedDuration != 30*time.Second || !config.CloseLatency {
+		t.Errorf("config sustained %s, close latency %v", config.SustainedDuration, config.CloseLatency)
+	}
+
+	// 已经更长的 -sustained 不会被缩短
+	config = &speedtester.Config{SustainedDuration: time.Minute, WebSocketURL: "wss://echo.example.com"}
+	applyScenarioPhases(scenarios, config)
+	if config.SustainedDuration != time.Minute {
+		t.Errorf("sustained shortened to %s", config.SustainedDuration)
+	}
+
+	if err := applyScenarioPhases(scenarios, &speedtester.Config{}); err == nil || !strings.Contains(err.Error(), "scenario chat needs -test-websocket") {
+		t.Errorf("websocket scenario without -test-websocket: %v", err)
+	}
+	noPhase, _ := parseScenarios([]byte("- name: a\n  min-sustained-speed: 1\n"))
+	if err := applyScenarioPhases(noPhase, &speedtester.Config{}); err == nil || !strings.Contains(err.Error(), "scenario a sets min-sustained-speed") {
+		t.Errorf("min-sustained-speed without sustained: %v", err)
+	}
+}
+
+func scenarioTestResult(mutate func(*speedtester.Result)) *speedtester.Result {
+	result := &speedtester.Result{
+		ProxyName:      "A",
+		ProxyConfig:    map[string]any{"name": "A", "type": "ss", "server": "a.example.com", "port": 443},
+		Latency:        200 * time.Millisecond,
+		Jitter:         10 * time.Millisecond,
+		DownloadSpeed:  8 * 1024 * 1024,
+		UploadSpeed:    2 * 1024 * 1024,
+		SustainedSpeed: 4 * 1024 * 1024,
+		CloseLatency:   100 * time.Millisecond,
+		WebSocketOK:    true,
+	}
+	if mutate != nil {
+		mutate(result)
+	}
+	return result
+}
+
+func TestScenarioEvaluate(t *testing.T) {
+	setFlags(t)
+	scenarios, _ := parseScenarios([]byte(scenariosYAML))
+	streaming, browsing, chat := scenarios[0], scenarios[1], scenarios[2]
+	minUpload := 1.0
+	upload := &scenario{Name: "upload", MinUploadSpeed: &minUpload}
+
+	tests := []struct {
+		name     string
+		scenario *scenario
+		mutate   func(*speedtester.Result)
+		usable   bool
+		good     bool
+		reason   string
+	}{
+		{"streaming usable not good", streaming, nil, true, false, ""},
+		{"streaming good", streaming, func(r *speedtester.Result) { r.DownloadSpeed = 12 * 1024 * 1024 }, true, true, ""},
+		{"streaming throttled", streaming, func(r *speedtester.Result) { r.SustainedSpeed = 1024 * 1024 }, false, false, "sustained speed 1.00MB/s"},
+		{"browsing good without threshold", browsing, nil, true, true, ""},
+		{"browsing latency", browsing, func(r *speedtester.Result) { r.Latency = 400 * time.Millisecond }, false, false, "latency 400ms > 300ms"},
+		{"browsing jitter", browsing, func(r *speedtester.Result) { r.Jitter = 60 * time.Millisecond }, false, false, "jitter"},
+		{"browsing packet loss", browsing, func(r *speedtester.Result) { r.PacketLoss = 10 }, false, false, "packet loss"},
+		{"chat websocket", chat, func(r *speedtester.Result) { r.WebSocketOK, r.WebSocketError = false, "handshake failed" }, false, false, "websocket: handshake failed"},
+		{"chat close timeout", chat, func(r *speedtester.Result) { r.CloseTimedOut = true }, false, false, "close latency"},
+		{"upload", upload, func(r *speedtester.Result) { r.UploadSpeed = 0 }, false, false, "upload speed"},
+		{"unreachable in every scenario", browsing, func(r *speedtester.Result) { r.Latency = 0 }, false, false, "unreachable"},
+		{"all packets lost", chat, func(r *speedtester.Result) { r.PacketLoss = 100 }, false, false, "unreachable"},
+		{"invalid", chat, func(r *speedtester.Result) { r.Invalid = "too fast" }, false, false, "invalid measurement: too fast"},
+		{"direct leak", chat, func(r *speedtester.Result) { r.DirectLeak = true }, false, false, "direct leak"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.scenario.evaluate(scenarioTestResult(tt.mutate))
+			if v.Usable != tt.usable || v.Good != tt.good || !strings.Contains(v.Reason, tt.reason) {
+				t.Errorf("verdict %+v, want usable %v good %v reason %q", v, tt.usable, tt.good, tt.reason)
+			}
+		})
+	}
+
+	// 上传被封锁且 -allow-upload-blocked 时不检查上传速度
+	setFlags(t, "allow-upload-blocked", "true")
+	if v := upload.evaluate(scenarioTestResult(func(r *speedtester.Result) { r.UploadSpeed, r.UploadBlocked = 0, true })); !v.Usable {
+		t.Errorf("blocked upload with -allow-upload-blocked: %+v", v)
+	}
+}
+
+func TestEvaluateScenarios(t *testing.T) {
+	setFlags(t)
+	scenarios, _ := parseScenarios([]byte(scenariosYAML))
+	slow := scenarioTestResult(func(r *speedtester.Result) { r.DownloadSpeed = 1024 * 1024 })
+	results := []*speedtester.Result{scenarioTestResult(nil), slow}
+	evaluateScenarios(scenarios, results)
+	for _, result := range results {
+		if len(result.Scenarios) != 3 {
+			t.Errorf("%d verdicts", len(result.Scenarios))
+		}
+	}
+	if slow.Scenarios["streaming"].Usable || !slow.Scenarios["browsing"].Usable || !slow.Scenarios["chat"].Usable {
+		t.Errorf("slow node verdicts %+v", slow.Scenarios)
+	}
+}
+
+func TestParseScenarioOutputs(t *testing.T) {
+	scenarios, _ := parseScenarios([]byte(scenariosYAML))
+	outputs, err := parseScenarioOutputs([]string{"streaming=s.yaml", "chat=c=1.yaml"}, scenarios)
+	if err != nil || len(outputs) != 2 || outputs["streaming"] != "s.yaml" || outputs["chat"] != "c=1.yaml" {
+		t.Errorf("parseScenarioOutputs = %v, %v", outputs, err)
+	}
+	for spec, want := range map[string]string{
+		"streaming":     "expected name=path",
+		"=s.yaml":       "expected name=path",
+		"streaming=":    "expected name=path",
+		"gaming=g.yaml": `unknown scenario "gaming"`,
+	} {
+		if _, err := parseScenarioOutputs([]string{spec}, scenarios); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseScenarioOutputs(%q) error %v, want %q", spec, err, want)
+		}
+	}
+}
+
+func TestSaveScenarioOutputs(t *testing.T) {
+	setFlags(t)
+	dir := t.TempDir()
+	scenarios, _ := parseScenarios([]byte(scenariosYAML))
+	named := func(name string, speed float64) *speedtester.Result {
+		return scenarioTestResult(func(r *speedtester.Result) {
+			r.ProxyName = name
+			r.ProxyConfig = map[string]any{"name": name, "type": "ss", "server": strings.ToLower(name) + ".example.com", "port": 443}
+			r.DownloadSpeed = speed * 1024 * 1024
+		})
+	}
+	results := []*speedtester.Result{named("Slow", 1), named("Fast", 20), named("Mid", 8)}
+	evaluateScenarios(scenarios, results)
+	outputs := map[string]string{
+		"streaming": filepath.Join(dir, "streaming.yaml"),
+		"browsing":  filepath.Join(dir, "browsing.yaml"),
+	}
+	captureConsole(t, func() { saveScenarioOutputs(outputs, results) })
+
+	for name, want := range map[string]string{"streaming": "Fast,Mid", "browsing": "Fast,Mid,Slow"} {
+		proxies, err := loadPreviousProxies(outputs[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, proxy := range proxies {
+			names = append(names, proxy["name"].(string))
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("%s output %s, want %s", name, got, want)
+		}
+	}
+}
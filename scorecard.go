@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// scoreSpeedReference 是速度分满分对应的中位下载速度
+const scoreSpeedReference = 20 * 1024 * 1024
+
+// scoreDiversityReference 是国家多样性满分对应的国家数
+const scoreDiversityReference = 5
+
+// scoreComponents 是综合评分的组成部分，-score-weights 只能使用这些名字
+var scoreComponents = []string{"usable", "speed", "stability", "diversity"}
+
+var defaultScoreWeights = "usable=0.4,speed=0.3,stability=0.2,diversity=0.1"
+
+// scorecard 是单个订阅的综合评分卡
+type scorecard struct {
+	Source         string             `json:"source"`
+	Tested         int                `json:"tested"`
+	Usable         int                `json:"usable"`
+	Good           int                `json:"good"`
+	UsableRatio    float64            `json:"usable_ratio"`
+	GoodRatio      float64            `json:"good_ratio"`
+	MedianSpeed    float64            `json:"median_download_speed"`
+	Countries      []string           `json:"countries"`
+	Stability      *float64           `json:"stability,omitempty"`
+	RemainingBytes *int64             `json:"remaining_bytes,omitempty"`
+	Expire         *time.Time         `json:"expire,omitempty"`
+	Components     map[string]float64 `json:"components"`
+	Score          float64            `json:"score"`
+}
+
+// parseScoreWeights 解析 "usable=0.4,speed=0.3" 形式的权重，未出现的组成部分权重为 0
+func parseScoreWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(scoreComponents))
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid weight %q, use name=value", item)
+		}
+		known := false
+		for _, component := range scoreComponents {
+			known = known || component == key
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown score component %q, supported: %s", key, strings.Join(scoreComponents, ", "))
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q for %s", value, key)
+		}
+		weights[key] = weight
+	}
+	return weights, nil
+}
+
+// compositeScore 计算 0-100 的综合评分：各组成部分先归一化到 [0, 1]
+//
+//	usable     可用节点占测试节点的比例
+//	speed      可用节点中位下载速度 / 20MB/s，封顶为 1
+//	stability  历史记录中本订阅节点被判定为可用的比例
+//	diversity  可用节点出口国家数 / 5，封顶为 1
+//
+// 再按权重加权平均。缺失的组成部分（例如没有历史记录时的 stability）不参与计算，
+// 其余部分的权重按比例放大，因此同样的输入总是得到同样的分数
+func compositeScore(components map[string]float64, weights map[string]float64) float64 {
+	var sum, totalWeight float64
+	for _, name := range scoreComponents {
+		value, ok := components[name]
+		if !ok || weights[name] == 0 {
+			continue
+		}
+		sum += value * weights[name]
+		totalWeight += weights[name]
+	}
+	if totalWeight == 0 {
+		return 0
+	}
+	return math.Round(sum/totalWeight*1000) / 10
+}
+
+// buildScorecards 为每个来源生成评分卡，history 为 nil 时不计算 stability
+func buildScorecards(reports []*sourceReport, allResults []*speedtester.Result, history *historyFile, weights map[string]float64) []*scorecard {
+	bySource := make(map[string][]*speedtester.Result)
+	for _, result := range allResults {
+		bySource[result.Source] = append(bySource[result.Source], result)
+	}
+
+	cards := make([]*scorecard, 0, len(reports))
+	for _, report := range reports {
+		results := bySource[report.Path]
+		card := &scorecard{
+			Source:     report.Path,
+			Tested:     len(results),
+			Countries:  []string{},
+			Components: make(map[string]float64),
+		}
+		var speeds []float64
+		countries := make(map[string]bool)
+		keys := make(map[string]bool)
+		for _, result := range results {
+			keys[speedtester.NodeKey(result.ProxyConfig)] = true
+			if !isProxyUsable(result) {
+				continue
+			}
+			card.Usable++
+			if isProxyGood(result) {
+				card.Good++
+			}
+			speeds = append(speeds, result.DownloadSpeed)
+			if result.CountryCode != "" {
+				countries[result.CountryCode] = true
+			}
+		}
+		for country := range countries {
+			card.Countries = append(card.Countries, country)
+		}
+		sort.Strings(card.Countries)
+
+		if card.Tested > 0 {
+			card.UsableRatio = float64(card.Usable) / float64(card.Tested)
+			card.GoodRatio = float64(card.Good) / float64(card.Tested)
+			card.Components["usable"] = card.UsableRatio
+		}
+		if len(speeds) > 0 {
+			card.MedianSpeed = median(speeds)
+			card.Components["speed"] = math.Min(card.MedianSpeed/scoreSpeedReference, 1)
+		}
+		if len(countries) > 0 {
+			card.Components["diversity"] = math.Min(float64(len(countries))/scoreDiversityReference, 1)
+		}
+		if history != nil {
+			if stability, ok := historyStability(history, keys); ok {
+				card.Stability = &stability
+				card.Components["stability"] = stability
+			}
+		}
+		if fields := parseSubscriptionUserinfo(report.SubscriptionUserinfo); len(fields) > 0 {
+			if total, ok := fields["total"]; ok && total > 0 {
+				remaining := total - fields["upload"] - fields["download"]
+				card.RemainingBytes = &remaining
+			}
+			if expire := fields["expire"]; expire > 0 {
+				t := time.Unix(expire, 0).UTC()
+				card.Expire = &t
+			}
+		}
+		card.Score = compositeScore(card.Components, weights)
+		cards = append(cards, card)
+	}
+	return cards
+}
+
+// historyStability 统计历史记录中这些节点被判定为可用的比例
+func historyStability(history *historyFile, keys map[string]bool) (float64, bool) {
+	var usable, total int
+	for _, run := range history.Runs {
+		for _, record := range run.Records {
+			if !keys[record.NodeKey] {
+				continue
+			}
+			total++
+			if record.Usable {
+				usable++
+			}
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(usable) / float64(total), true
+}
+
+func median(values []float64) float64 {
+	return percentile(values, 0.5)
+}
+
+// percentile 返回 values 的 p 分位数（p 取 0 到 1），两个样本之间线性插值，values 为空时返回 0
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(pos-float64(lower))
+}
+
+// saveScorecards 把评分卡写成 JSON 数组
+func saveScorecards(path string, cards []*scorecard) error {
+	data, err := json.MarshalIndent(cards, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeArtifact(path, data, 0o644)
+}
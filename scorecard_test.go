@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestParseScoreWeights(t *testing.T) {
+	weights, err := parseScoreWeights(defaultScoreWeights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]float64{"usable": 0.4, "speed": 0.3, "stability": 0.2, "diversity": 0.1}; !reflect.DeepEqual(weights, want) {
+		t.Errorf("default weights = %v", weights)
+	}
+	if weights, err := parseScoreWeights(" speed = 1 ,"); err != nil || !reflect.DeepEqual(weights, map[string]float64{"speed": 1}) {
+		t.Errorf("partial weights = %v, %v", weights, err)
+	}
+	for spec, want := range map[string]string{
+		"speed":       `invalid weight "speed"`,
+		"latency=0.5": `unknown score component "latency"`,
+		"usable=-1":   `invalid weight "-1" for usable`,
+		"usable=lots": `invalid weight "lots" for usable`,
+	} {
+		if _, err := parseScoreWeights(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseScoreWeights(%q) error = %v, want %q", spec, err, want)
+		}
+	}
+}
+
+func TestCompositeScore(t *testing.T) {
+	weights, _ := parseScoreWeights(defaultScoreWeights)
+	full := map[string]float64{"usable": 1, "speed": 1, "stability": 1, "diversity": 1}
+	if got := compositeScore(full, weights); got != 100 {
+		t.Errorf("perfect score = %v", got)
+	}
+	components := map[string]float64{"usable": 0.5, "speed": 0.25, "stability": 0.8, "diversity": 0.4}
+	// (0.5*0.4 + 0.25*0.3 + 0.8*0.2 + 0.4*0.1) * 100 = 47.5
+	if got := compositeScore(components, weights); got != 47.5 {
+		t.Errorf("score = %v, want 47.5", got)
+	}
+	// 没有 stability 时其余权重按比例放大：(0.2 + 0.075 + 0.04) / 0.8 * 100 = 39.375，保留一位小数
+	delete(components, "stability")
+	if got := compositeScore(components, weights); got != 39.4 {
+		t.Errorf("score without stability = %v, want 39.4", got)
+	}
+	if got := compositeScore(components, map[string]float64{"stability": 1}); got != 0 {
+		t.Errorf("score with only missing components weighted = %v", got)
+	}
+	for range 10 {
+		if got := compositeScore(components, weights); got != 39.4 {
+			t.Fatalf("score is not deterministic: %v", got)
+		}
+	}
+}
+
+func TestBuildScorecards(t *testing.T) {
+	setFlags(t)
+	mb := 1024.0 * 1024
+	node := func(source, name, country string, speed float64) *speedtester.Result {
+		result := &speedtester.Result{
+			Source: source, ProxyName: name, CountryCode: country, DownloadSpeed: speed * mb, ExtraURLConnectivity: true,
+			ProxyConfig: map[string]any{"name": name, "type": "ss", "server": name + ".example.com", "port": 443, "password": "p"},
+		}
+		if speed > 0 {
+			result.Latency = 100 * time.Millisecond
+		}
+		return result
+	}
+	results := []*speedtester.Result{
+		node("a.yaml", "HK 01", "HK", 40),
+		node("a.yaml", "JP 01", "JP", 10),
+		node("a.yaml", "US 01", "US", 0.5),
+		node("a.yaml", "SG 01", "", 0),
+		node("b.yaml", "TW 01", "TW", 0),
+	}
+	reports := []*sourceReport{
+		{Path: "a.yaml", LoadReport: &speedtester.LoadReport{SubscriptionUserinfo: "upload=100; download=400; total=1000; expire=1800000000"}},
+		{Path: "b.yaml", LoadReport: &speedtester.LoadReport{}},
+	}
+	history := &historyFile{Runs: []historyRun{
+		{Records: []historyRecord{
+			{NodeKey: speedtester.NodeKey(results[0].ProxyConfig), Usable: true},
+			{NodeKey: speedtester.NodeKey(results[3].ProxyConfig), Usable: false},
+			{NodeKey: "other", Usable: true},
+		}},
+	}}
+	weights, _ := parseScoreWeights(defaultScoreWeights)
+	cards := buildScorecards(reports, results, history, weights)
+	if len(cards) != 2 {
+		t.Fatalf("%d cards, want 2", len(cards))
+	}
+
+	a := cards[0]
+	if a.Tested != 4 || a.Usable != 3 || a.Good != 2 || a.UsableRatio != 0.75 || a.GoodRatio != 0.5 {
+		t.Errorf("a counts: tested %d usable %d good %d, ratios %v %v", a.Tested, a.Usable, a.Good, a.UsableRatio, a.GoodRatio)
+	}
+	if a.MedianSpeed != 10*mb || !reflect.DeepEqual(a.Countries, []string{"HK", "JP", "US"}) {
+		t.Errorf("a median %v, countries %v", a.MedianSpeed, a.Countries)
+	}
+	if a.Stability == nil || *a.Stability != 0.5 {
+		t.Errorf("a stability = %v", a.Stability)
+	}
+	if a.RemainingBytes == nil || *a.RemainingBytes != 500 || a.Expire == nil || a.Expire.Unix() != 1800000000 {
+		t.Errorf("a remaining %v, expire %v", a.RemainingBytes, a.Expire)
+	}
+	// usable 0.75, speed 0.5, stability 0.5, diversity 0.6
+	want := math.Round((0.75*0.4+0.5*0.3+0.5*0.2+0.6*0.1)*1000) / 10
+	if a.Score != want {
+		t.Errorf("a score = %v, want %v", a.Score, want)
+	}
+
+	b := cards[1]
+	if b.Tested != 1 || b.Usable != 0 || b.Score != 0 || b.Stability != nil || b.RemainingBytes != nil {
+		t.Errorf("b card = %+v", b)
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"countries":[]`) || strings.Contains(string(data), "stability") {
+		t.Errorf("b json = %s", data)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{40, 10, 30, 20}
+	for p, want := range map[float64]float64{0: 10, 0.5: 25, 0.9: 37, 1: 40} {
+		if got := percentile(values, p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if !reflect.DeepEqual(values, []float64{40, 10, 30, 20}) {
+		t.Errorf("percentile sorted its input: %v", values)
+	}
+	if got := median(nil); got != 0 {
+		t.Errorf("median of nothing = %v", got)
+	}
+	if got := median([]float64{7}); got != 7 {
+		t.Errorf("median of one = %v", got)
+	}
+}
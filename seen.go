@@ -0,0 +1,89 @@
+package main
+
+import (
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/metacubex/mihomo/log"
+)
+
+// seenWindow 是 "本周新增/消失" 统计的时间范围
+const seenWindow = 7 * 24 * time.Hour
+
+// seenRecord 记录节点在订阅里第一次和最后一次出现的时间，以及出现过的运行次数
+type seenRecord struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	SeenCount int       `json:"seen_count"`
+}
+
+// updateSeen 把本次运行出现的节点记入 Seen，并把记录写回 results。
+// 系统时间被往回调时 FirstSeen 只会提前、LastSeen 只会推后，不会出现 LastSeen 早于 FirstSeen 的记录
+func (h *historyFile) updateSeen(now time.Time, results []*speedtester.Result, retention time.Duration) {
+	if h.Seen == nil {
+		h.Seen = make(map[string]*seenRecord, len(results))
+	}
+	counted := make(map[string]bool, len(results))
+	for _, result := range results {
+		key := speedtester.NodeKey(result.ProxyConfig)
+		record := h.Seen[key]
+		if record == nil {
+			record = &seenRecord{FirstSeen: now, LastSeen: now}
+			h.Seen[key] = record
+		}
+		// 同一个节点出现在多个订阅里时只计一次
+		if !counted[key] {
+			counted[key] = true
+			record.SeenCount++
+			if now.Before(record.FirstSeen) {
+				record.FirstSeen = now
+			}
+			if now.After(record.LastSeen) {
+				record.LastSeen = now
+			}
+		}
+		result.FirstSeen = record.FirstSeen
+		result.LastSeen = record.LastSeen
+		result.SeenCount = record.SeenCount
+	}
+	if retention <= 0 {
+		return
+	}
+	for key, record := range h.Seen {
+		if now.Sub(record.LastSeen) > retention {
+			delete(h.Seen, key)
+		}
+	}
+}
+
+// seenChurn 统计最近 seenWindow 内新出现的节点数，以及在这段时间内出现过但本次没有出现的节点数
+func (h *historyFile) seenChurn(now time.Time, results []*speedtester.Result) (appeared, disappeared int) {
+	present := make(map[string]bool, len(results))
+	for _, result := range results {
+		present[speedtester.NodeKey(result.ProxyConfig)] = true
+	}
+	for key, record := range h.Seen {
+		switch {
+		case present[key]:
+			if now.Sub(record.FirstSeen) <= seenWindow {
+				appeared++
+			}
+		case now.Sub(record.LastSeen) <= seenWindow:
+			disappeared++
+		}
+	}
+	return appeared, disappeared
+}
+
+// filterMinAge 去掉出现次数少于 minAge 次运行的节点，keep 返回 true 的节点不受影响
+func filterMinAge(results []*speedtester.Result, minAge int, keep func(*speedtester.Result) bool) []*speedtester.Result {
+	kept := make([]*speedtester.Result, 0, len(results))
+	for _, result := range results {
+		if result.SeenCount < minAge && !keep(result) {
+			log.Infoln("%s is too new (seen in %d runs, -min-age %d)", result.ProxyName, result.SeenCount, minAge)
+			continue
+		}
+		kept = append(kept, result)
+	}
+	return kept
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func capResult(name string, speed float64) *speedtester.Result {
+	return &speedtester.Result{
+		ProxyName:            name,
+		ProxyConfig:          map[string]any{"name": name, "type": "ss", "server": strings.ToLower(name) + ".example.com", "port": 443, "password": "p", "cipher": "aes-128-gcm"},
+		Latency:              100 * time.Millisecond,
+		DownloadSpeed:        speed * 1024 * 1024,
+		ExtraURLConnectivity: true,
+	}
+}
+
+func resultNames(results []*speedtester.Result) []string {
+	var names []string
+	for _, result := range results {
+		names = append(names, result.ProxyName)
+	}
+	return names
+}
+
+func TestUpdateSeen(t *testing.T) {
+	setFlags(t)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	history := &historyFile{}
+
+	a, b := capResult("A", 5), capResult("B", 5)
+	// 同一个节点出现在两个订阅里只计一次
+	aCopy := capResult("A", 5)
+	aCopy.ProxyName = "A copy"
+	history.updateSeen(start, []*speedtester.Result{a, aCopy, b}, 0)
+	if a.SeenCount != 1 || aCopy.SeenCount != 1 || !a.FirstSeen.Equal(start) || !a.LastSeen.Equal(start) {
+		t.Errorf("first run: A seen %d, %s - %s", a.SeenCount, a.FirstSeen, a.LastSeen)
+	}
+
+	a = capResult("A", 5)
+	history.updateSeen(start.Add(time.Hour), []*speedtester.Result{a}, 0)
+	if a.SeenCount != 2 || !a.FirstSeen.Equal(start) || !a.LastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("second run: A seen %d, %s - %s", a.SeenCount, a.FirstSeen, a.LastSeen)
+	}
+
+	// 时钟往回调时 FirstSeen 提前，LastSeen 不倒退
+	a = capResult("A", 5)
+	history.updateSeen(start.Add(-time.Hour), []*speedtester.Result{a}, 0)
+	if a.SeenCount != 3 || !a.FirstSeen.Equal(start.Add(-time.Hour)) || !a.LastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("clock moved back: A seen %d, %s - %s", a.SeenCount, a.FirstSeen, a.LastSeen)
+	}
+
+	// 超过保留时间没有出现的节点被清理
+	history.updateSeen(start.Add(30*24*time.Hour), []*speedtester.Result{capResult("A", 5)}, 7*24*time.Hour)
+	if len(history.Seen) != 1 || history.Seen[speedtester.NodeKey(b.ProxyConfig)] != nil {
+		t.Errorf("seen after retention: %d records", len(history.Seen))
+	}
+}
+
+func TestSeenChurn(t *testing.T) {
+	setFlags(t)
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	history := &historyFile{}
+	// Old 一直在，Gone 三天前还在，Ancient 十天前消失，New 今天第一次出现
+	history.updateSeen(now.Add(-20*24*time.Hour), []*speedtester.Result{capResult("Old", 5), capResult("Ancient", 5)}, 0)
+	history.updateSeen(now.Add(-10*24*time.Hour), []*speedtester.Result{capResult("Ancient", 5)}, 0)
+	history.updateSeen(now.Add(-3*24*time.Hour), []*speedtester.Result{capResult("Gone", 5)}, 0)
+	current := []*speedtester.Result{capResult("Old", 5), capResult("New", 5)}
+	history.updateSeen(now, current, 0)
+
+	appeared, disappeared := history.seenChurn(now, current)
+	// Gone 三天前第一次出现，本次没有，只算消失
+	if appeared != 1 || disappeared != 1 {
+		t.Errorf("appeared %d, disappeared %d, want 1, 1", appeared, disappeared)
+	}
+}
+
+func TestFilterMinAge(t *testing.T) {
+	node := func(name string, seen int) *speedtester.Result {
+		result := capResult(name, 5)
+		result.SeenCount = seen
+		return result
+	}
+	results := []*speedtester.Result{node("Fresh", 1), node("Known", 3), node("Pinned", 1), node("Edge", 2)}
+	kept := filterMinAge(results, 2, func(result *speedtester.Result) bool { return result.ProxyName == "Pinned" })
+	if got := resultNames(kept); !slices.Equal(got, []string{"Known", "Pinned", "Edge"}) {
+		t.Errorf("kept %v", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// subServer 通过 /sub 把测试后留下的节点作为订阅提供给客户端
+type subServer struct {
+	token          string
+	updateInterval int
+	mu             sync.RWMutex
+	results        []*speedtester.Result
+	userinfo       string
+}
+
+// update 替换当前提供的节点，results 应当已经按可用性筛选并排好序
+func (s *subServer) update(results []*speedtester.Result, userinfo string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = results
+	s.userinfo = userinfo
+}
+
+func (s *subServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sub", s.handleSub)
+	return mux
+}
+
+// authorizeToken 检查 ?token= 或 Authorization: Bearer 里的 token，不对时直接返回 401。token 为空时不检查
+func authorizeToken(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	got := r.URL.Query().Get("token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		got = bearer
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="clash-speedtest"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// handleSub 支持 ?good=1 只返回优质节点，?format=clash|uri|singbox 选择导出格式。
+// 节点和 -output、-good-output 里的一样经过 -rename 和 -strip-volatile 处理
+func (s *subServer) handleSub(w http.ResponseWriter, r *http.Request) {
+	if !authorizeToken(w, r, s.token) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatClash
+	}
+	onlyGood := r.URL.Query().Get("good") == "1"
+
+	s.mu.RLock()
+	results := make([]*speedtester.Result, 0, len(s.results))
+	for _, result := range s.results {
+		if !onlyGood || isProxyGood(result) {
+			results = append(results, result)
+		}
+	}
+	userinfo := s.userinfo
+	s.mu.RUnlock()
+	proxies, _ := outputProxyConfigs(results)
+
+	body, err := exportProxies(proxies, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch format {
+	case formatClash:
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	case formatSingBox:
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if userinfo != "" {
+		w.Header().Set("Subscription-Userinfo", userinfo)
+	}
+	if s.updateInterval > 0 {
+		w.Header().Set("Profile-Update-Interval", strconv.Itoa(s.updateInterval))
+	}
+	w.Write(body)
+}
+
+// parseSubscriptionUserinfo 解析形如 "upload=1; download=2; total=3; expire=4" 的 subscription-userinfo
+func parseSubscriptionUserinfo(header string) map[string]int64 {
+	fields := make(map[string]int64)
+	for _, part := range strings.Split(header, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = n
+	}
+	return fields
+}
+
+// mergeSubscriptionUserinfo 合并多个订阅的流量信息：流量累加，过期时间取最早的一个
+func mergeSubscriptionUserinfo(headers []string) string {
+	var upload, download, total, expire int64
+	found := false
+	for _, header := range headers {
+		fields := parseSubscriptionUserinfo(header)
+		if len(fields) == 0 {
+			continue
+		}
+		found = true
+		upload += fields["upload"]
+		download += fields["download"]
+		total += fields["total"]
+		if n := fields["expire"]; n > 0 && (expire == 0 || n < expire) {
+			expire = n
+		}
+	}
+	if !found {
+		return ""
+	}
+	return fmt.Sprintf("upload=%d; download=%d; total=%d; expire=%d", upload, download, total, expire)
+}
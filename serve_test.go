@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// subTestServer 提供一个优质节点 HK 01 和一个只是可用的节点 JP 01
+func subTestServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	setFlags(t)
+	s := &subServer{token: token, updateInterval: 6}
+	s.update([]*speedtester.Result{
+		{ProxyName: "HK 01", Latency: 100 * time.Millisecond, DownloadSpeed: 5 * 1024 * 1024, ExtraURLConnectivity: true,
+			ProxyConfig: map[string]any{"name": "HK 01", "type": "ss", "server": "1.1.1.1", "port": 8388, "cipher": "aes-128-gcm", "password": "p"}},
+		{ProxyName: "JP 01", Latency: 200 * time.Millisecond, DownloadSpeed: 512 * 1024, ExtraURLConnectivity: true,
+			ProxyConfig: map[string]any{"name": "JP 01", "type": "trojan", "server": "2.2.2.2", "port": 443, "password": "p", "sni": "jp.example.com"}},
+	}, "upload=1; download=2; total=3; expire=4")
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getSub(t *testing.T, server *httptest.Server, query, authorization string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/sub"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestSubAuth(t *testing.T) {
+	server := subTestServer(t, "secret")
+	for _, tc := range []struct {
+		name          string
+		query         string
+		authorization string
+		status        int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"wrong token", "?token=guess", "", http.StatusUnauthorized},
+		{"token prefix", "?token=secre", "", http.StatusUnauthorized},
+		{"query token", "?token=secret", "", http.StatusOK},
+		{"bearer token", "", "Bearer secret", http.StatusOK},
+		{"wrong bearer", "", "Bearer guess", http.StatusUnauthorized},
+		{"basic auth", "", "Basic c2VjcmV0", http.StatusUnauthorized},
+		// 请求头优先于 URL 参数
+		{"wrong bearer with query token", "?token=secret", "Bearer guess", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := getSub(t, server, tc.query, tc.authorization)
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tc.status)
+			}
+			if tc.status == http.StatusUnauthorized {
+				if resp.Header.Get("WWW-Authenticate") == "" || strings.Contains(body, "HK 01") {
+					t.Errorf("401 response leaks nodes or lacks WWW-Authenticate: %q", body)
+				}
+			}
+		})
+	}
+
+	open := subTestServer(t, "")
+	if resp, _ := getSub(t, open, "", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("server without -sub-token returned %d", resp.StatusCode)
+	}
+}
+
+func TestSubFormats(t *testing.T) {
+	server := subTestServer(t, "secret")
+
+	t.Run("clash", func(t *testing.T) {
+		resp, body := getSub(t, server, "?token=secret", "")
+		if got := resp.Header.Get("Content-Type"); got != "text/yaml; charset=utf-8" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		if got := resp.Header.Get("Subscription-Userinfo"); got != "upload=1; download=2; total=3; expire=4" {
+			t.Errorf("Subscription-Userinfo = %q", got)
+		}
+		if got := resp.Header.Get("Profile-Update-Interval"); got != "6" {
+			t.Errorf("Profile-Update-Interval = %q", got)
+		}
+		var config struct {
+			Proxies []map[string]any `yaml:"proxies"`
+		}
+		if err := yaml.Unmarshal([]byte(body), &config); err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Proxies) != 2 || config.Proxies[0]["name"] != "HK 01" || config.Proxies[1]["name"] != "JP 01" {
+			t.Errorf("proxies = %v", config.Proxies)
+		}
+	})
+
+	t.Run("good only", func(t *testing.T) {
+		_, body := getSub(t, server, "?token=secret&format=clash&good=1", "")
+		if !strings.Contains(body, "HK 01") || strings.Contains(body, "JP 01") {
+			t.Errorf("good=1 body:\n%s", body)
+		}
+	})
+
+	t.Run("uri", func(t *testing.T) {
+		resp, body := getSub(t, server, "?token=secret&format=uri", "")
+		if got := resp.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			t.Fatalf("body is not base64: %v", err)
+		}
+		lines := strings.Split(string(decoded), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "ss://") || !strings.HasPrefix(lines[1], "trojan://") {
+			t.Errorf("uri lines = %q", lines)
+		}
+	})
+
+	t.Run("singbox", func(t *testing.T) {
+		resp, body := getSub(t, server, "?token=secret&format=singbox&good=1", "")
+		if got := resp.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var config struct {
+			Outbounds []map[string]any `json:"outbounds"`
+		}
+		if err := json.Unmarshal([]byte(body), &config); err != nil {
+			t.Fatal(err)
+		}
+		if len(config.Outbounds) != 1 || config.Outbounds[0]["type"] != "shadowsocks" || config.Outbounds[0]["tag"] != "HK 01" {
+			t.Errorf("outbounds = %v", config.Outbounds)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		resp, body := getSub(t, server, "?token=secret&format=v2rayn", "")
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, `unknown format "v2rayn"`) {
+			t.Errorf("status %d, body %q", resp.StatusCode, body)
+		}
+	})
+}
+
+// /sub 的节点和输出文件一样按 -rename 改名，被 -max-good-nodes 降级的节点不算优质
+func TestSubMatchesOutput(t *testing.T) {
+	setFlags(t, "rename", "true")
+	results := []*speedtester.Result{
+		{ProxyName: "HK 01", CountryCode: "HK", Latency: 100 * time.Millisecond, DownloadSpeed: 6 * 1024 * 1024, ExtraURLConnectivity: true,
+			ProxyConfig: map[string]any{"name": "HK 01", "type": "ss", "server": "1.1.1.1", "port": 8388, "cipher": "aes-128-gcm", "password": "p"}},
+		{ProxyName: "HK 02", CountryCode: "HK", Latency: 100 * time.Millisecond, DownloadSpeed: 5 * 1024 * 1024, ExtraURLConnectivity: true,
+			ProxyConfig: map[string]any{"name": "HK 02", "type": "ss", "server": "3.3.3.3", "port": 8388, "cipher": "aes-128-gcm", "password": "p"}},
+	}
+	demotedNodes = map[*speedtester.Result]bool{results[1]: true}
+	t.Cleanup(func() { demotedNodes = nil })
+	s := &subServer{}
+	s.update(results, "")
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+
+	for _, tc := range []struct {
+		query string
+		want  []*speedtester.Result
+	}{
+		{"", results},
+		{"?good=1", results[:1]},
+	} {
+		_, body := getSub(t, server, tc.query, "")
+		var config struct {
+			Proxies []map[string]any `yaml:"proxies"`
+		}
+		if err := yaml.Unmarshal([]byte(body), &config); err != nil {
+			t.Fatal(err)
+		}
+		got, want := proxyNames(config.Proxies), proxyNames(outputProxies(t, tc.want))
+		if strings.Join(got, ",") != strings.Join(want, ",") || strings.Contains(body, "HK 01") {
+			t.Errorf("/sub%s names = %q, want %q as in the output file", tc.query, got, want)
+		}
+	}
+}
+
+func TestMergeSubscriptionUserinfo(t *testing.T) {
+	got := mergeSubscriptionUserinfo([]string{
+		"upload=100; download=200; total=1000; expire=1800000000",
+		"",
+		"not a userinfo header",
+		"upload=1;download=2;total=10;expire=1700000000",
+		"upload=5; download=5; total=5",
+	})
+	if want := "upload=106; download=207; total=1015; expire=1700000000"; got != want {
+		t.Errorf("merged = %q, want %q", got, want)
+	}
+	if got := mergeSubscriptionUserinfo([]string{"", "garbage"}); got != "" {
+		t.Errorf("merged without any userinfo = %q", got)
+	}
+}
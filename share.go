@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// shareVersion 在共享数据格式出现不兼容变化时递增
+const shareVersion = 1
+
+// sharePayload 是上传到 -share-url 的匿名汇总，只包含测量数据，
+// 不包含节点配置、名称、服务器地址和密码等任何能定位或使用节点的信息
+type sharePayload struct {
+	Version int         `json:"version"`
+	Time    time.Time   `json:"time"`
+	Nodes   []shareNode `json:"nodes"`
+}
+
+type shareNode struct {
+	// NodeKey 本身是节点关键字段的哈希，不同成员测到的同一个节点可以对应起来
+	NodeKey       string  `json:"node_key"`
+	Type          string  `json:"type"`
+	Country       string  `json:"country,omitempty"`
+	LatencyMs     int64   `json:"latency_ms"`
+	DownloadSpeed float64 `json:"download_speed"`
+	UploadSpeed   float64 `json:"upload_speed"`
+	Usable        bool    `json:"usable"`
+}
+
+// buildSharePayload 从测试结果中逐个挑出可以公开的字段，新增的 Result 字段不会被自动带上
+func buildSharePayload(now time.Time, results []*speedtester.Result) *sharePayload {
+	payload := &sharePayload{Version: shareVersion, Time: now.UTC(), Nodes: make([]shareNode, 0, len(results))}
+	for _, result := range results {
+		payload.Nodes = append(payload.Nodes, shareNode{
+			NodeKey:       speedtester.NodeKey(result.ProxyConfig),
+			Type:          result.ProxyType,
+			Country:       result.CountryCode,
+			LatencyMs:     result.Latency.Milliseconds(),
+			DownloadSpeed: result.DownloadSpeed,
+			UploadSpeed:   result.UploadSpeed,
+			Usable:        isProxyUsable(result),
+		})
+	}
+	return payload
+}
+
+// shareAttempts 是上传失败时的最大尝试次数
+const shareAttempts = 3
+
+// shareResults 上传匿名汇总，dryRun 时只把完整内容打印出来供检查
+func shareResults(url, token string, dryRun bool, results []*speedtester.Result) error {
+	data, err := json.MarshalIndent(buildSharePayload(time.Now(), results), "", "  ")
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Fprintf(console, "%s\n", data)
+		return nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for attempt := 1; ; attempt++ {
+		status, body, err := postShare(client, url, token, data)
+		// 4xx 说明请求本身有问题，重试也没用
+		if err == nil && status < 500 {
+			if status >= 300 {
+				return fmt.Errorf("share: %d %s", status, body)
+			}
+			fmt.Fprintf(os.Stderr, "shared %d nodes to %s: %d %s\n", len(results), url, status, body)
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%d %s", status, body)
+		}
+		if attempt == shareAttempts {
+			return fmt.Errorf("share: %w", err)
+		}
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+func postShare(client *http.Client, url, token string, data []byte) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, string(bytes.TrimSpace(body)), nil
+}
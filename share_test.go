@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// shareTestResults 的节点配置里每个敏感字段都带有 secret 字样，方便检查有没有泄露
+func shareTestResults() []*speedtester.Result {
+	return []*speedtester.Result{{
+		ProxyName:            "secret-name 香港 01",
+		SourceName:           "secret-source-name",
+		Source:               "https://secret-sub.example.com/api?token=secret-token",
+		ProxyType:            "Vmess",
+		CountryCode:          "HK",
+		ExitIP:               "203.0.113.99",
+		Latency:              120 * time.Millisecond,
+		DownloadSpeed:        5 * 1024 * 1024,
+		UploadSpeed:          1024 * 1024,
+		ExtraURLConnectivity: true,
+		ProxyConfig: map[string]any{
+			"name": "secret-name 香港 01", "type": "vmess", "server": "secret-server.example.com", "port": 44301,
+			"uuid": "secret-uuid", "password": "secret-password", "ws-opts": map[string]any{"path": "/secret-path"},
+		},
+	}}
+}
+
+func TestBuildSharePayload(t *testing.T) {
+	setFlags(t)
+	results := shareTestResults()
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.FixedZone("CST", 8*3600))
+	data, err := json.Marshal(buildSharePayload(now, results))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"secret", "44301", "203.0.113.99", "proxy_config", "香港"} {
+		if strings.Contains(string(data), leak) {
+			t.Errorf("payload contains %q: %s", leak, data)
+		}
+	}
+
+	var payload sharePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Version != shareVersion || !payload.Time.Equal(now) || payload.Time.Location() != time.UTC {
+		t.Errorf("payload header: %+v", payload)
+	}
+	want := shareNode{
+		NodeKey: speedtester.NodeKey(results[0].ProxyConfig), Type: "Vmess", Country: "HK",
+		LatencyMs: 120, DownloadSpeed: 5 * 1024 * 1024, UploadSpeed: 1024 * 1024, Usable: true,
+	}
+	if len(payload.Nodes) != 1 || payload.Nodes[0] != want {
+		t.Errorf("nodes %+v, want %+v", payload.Nodes, want)
+	}
+}
+
+func TestShareResults(t *testing.T) {
+	setFlags(t)
+	var requests atomic.Int64
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !json.Valid(body) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(statuses[min(int(n), len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+
+	// 5xx 重试后成功
+	if err := shareResults(server.URL, "token", false, shareTestResults()); err != nil || requests.Load() != 2 {
+		t.Errorf("after %d requests: %v", requests.Load(), err)
+	}
+
+	// 4xx 不重试
+	requests.Store(0)
+	if err := shareResults(server.URL, "wrong", false, shareTestResults()); err == nil || !strings.Contains(err.Error(), "401") || requests.Load() != 1 {
+		t.Errorf("after %d requests: %v", requests.Load(), err)
+	}
+}
+
+func TestShareDryRun(t *testing.T) {
+	setFlags(t)
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := console
+	console = out
+	t.Cleanup(func() { console = previous })
+
+	// dry run 不发请求
+	if err := shareResults("http://127.0.0.1:1", "", true, shareTestResults()); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	data, _ := os.ReadFile(out.Name())
+	var payload sharePayload
+	if err := json.Unmarshal(data, &payload); err != nil || len(payload.Nodes) != 1 {
+		t.Errorf("dry run printed %s: %v", data, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// sourceEntry 是 -sources 文件中的一个订阅，可以单独指定下载订阅时的请求头和筛选、判定阈值
+type sourceEntry struct {
+	// Name 非空时代替订阅地址作为节点的来源，用于 -output-per-source、-scorecard 等
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	UA      string            `yaml:"ua"`
+	// 以下为覆盖全局选项的设置，为空时使用命令行的值
+	Filter                     string   `yaml:"filter"`
+	MaxLatency                 string   `yaml:"max-latency"`
+	MinSpeed                   *float64 `yaml:"min-speed"`
+	GoodDownloadSpeedThreshold *float64 `yaml:"good-download-speed-threshold"`
+
+	maxLatency *time.Duration
+}
+
+// tag 是节点结果里记录的来源
+func (e *sourceEntry) tag() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.URL
+}
+
+// sourceOverrides 按来源索引 -sources 中带有覆盖设置的订阅
+var sourceOverrides map[string]*sourceEntry
+
+func loadSources(path string) ([]*sourceEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseSources(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// parseSources 解析订阅列表，除 url 以外都是可选的：
+//
+//	# sources.yaml
+//	- name: airport-a
+//	  url: https://example.com/sub
+//	  headers: {Authorization: Bearer xxx}
+//	  ua: clash.meta
+//	  filter: 香港|日本
+//	  max-latency: 500ms
+//	  min-speed: 1
+//	  good-download-speed-threshold: 5
+func parseSources(data []byte) ([]*sourceEntry, error) {
+	var entries []*sourceEntry
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	tags := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if entry.URL == "" {
+			errs = append(errs, fmt.Errorf("source %d: url is required", i+1))
+			continue
+		}
+		if tags[entry.tag()] {
+			errs = append(errs, fmt.Errorf("source %d: duplicate source %q", i+1, entry.tag()))
+		}
+		tags[entry.tag()] = true
+		if entry.Filter != "" {
+			if _, err := regexp.Compile(entry.Filter); err != nil {
+				errs = append(errs, fmt.Errorf("source %s: invalid filter: %w", entry.tag(), err))
+			}
+		}
+		if entry.MaxLatency != "" {
+			d, err := time.ParseDuration(entry.MaxLatency)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("source %s: invalid max-latency: %w", entry.tag(), err))
+			} else {
+				entry.maxLatency = &d
+			}
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+// thresholds 是判定节点是否可用、是否优质时使用的阈值
+type thresholds struct {
+	maxLatency        time.Duration
+	maxNewConnLatency time.Duration
+	minSpeed          float64
+	minUploadSpeed    float64
+	goodSpeed         float64
+}
+
+// thresholdsFor 返回节点所在来源的阈值，-sources 中的覆盖设置优先于命令行选项。
+// -type-overrides 的 max-latency 在测试时已经用于这一类型的延迟探测，判定时也优先于来源和命令行的 -max-latency
+func thresholdsFor(result *speedtester.Result) thresholds {
+	t := thresholds{
+		maxLatency:        *maxLatency,
+		maxNewConnLatency: *maxNewConnLatency,
+		minSpeed:          *minSpeed,
+		minUploadSpeed:    *minUploadSpeed,
+		goodSpeed:         *goodDownloadSpeedThreshold,
+	}
+	if entry := sourceOverrides[result.Source]; entry != nil {
+		t.applySource(entry)
+	}
+	if result.TypeOverride != "" {
+		if override, err := speedtester.ParseTypeOverride(result.TypeOverride); err == nil && override.MaxLatency != nil {
+			t.maxLatency = *override.MaxLatency
+		}
+	}
+	return t
+}
+
+func (t *thresholds) applySource(entry *sourceEntry) {
+	if entry.maxLatency != nil {
+		t.maxLatency = *entry.maxLatency
+	}
+	if entry.MinSpeed != nil {
+		t.minSpeed = *entry.MinSpeed
+	}
+	if entry.GoodDownloadSpeedThreshold != nil {
+		t.goodSpeed = *entry.GoodDownloadSpeedThreshold
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestParseSources(t *testing.T) {
+	entries, err := parseSources([]byte(`
+- name: airport-a
+  url: https://a.example.com/sub
+  headers: {Authorization: Bearer xxx}
+  ua: clash.meta
+  filter: 香港|日本
+  max-latency: 500ms
+  min-speed: 1
+  good-download-speed-threshold: 5
+- url: https://b.example.com/sub
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	a, b := entries[0], entries[1]
+	if a.tag() != "airport-a" || a.Headers["Authorization"] != "Bearer xxx" || a.UA != "clash.meta" || a.Filter != "香港|日本" {
+		t.Errorf("entry a: %+v", a)
+	}
+	if a.maxLatency == nil || *a.maxLatency != 500*time.Millisecond || *a.MinSpeed != 1 || *a.GoodDownloadSpeedThreshold != 5 {
+		t.Errorf("entry a overrides: %+v", a)
+	}
+	if b.tag() != "https://b.example.com/sub" || b.maxLatency != nil || b.MinSpeed != nil || b.GoodDownloadSpeedThreshold != nil {
+		t.Errorf("entry b: %+v", b)
+	}
+}
+
+func TestParseSourcesErrors(t *testing.T) {
+	tests := map[string]string{
+		"- url: https://a.example.com\n  token: x\n": "field token not found",
+		"- name: a\n": "source 1: url is required",
+		"- {name: a, url: https://a.example.com}\n- {name: a, url: https://b}\n": `source 2: duplicate source "a"`,
+		"- {url: https://a.example.com}\n- {url: https://a.example.com}\n":       "duplicate source",
+		"- {url: https://a.example.com, filter: '('}\n":                          "invalid filter",
+		"- {url: https://a.example.com, max-latency: 500}\n":                     "invalid max-latency",
+		"- {url: https://a.example.com, min-speed: fast}\n":                      "cannot unmarshal",
+		"url: https://a.example.com\n":                                           "cannot unmarshal",
+	}
+	for data, want := range tests {
+		if _, err := parseSources([]byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", data, err, want)
+		}
+	}
+}
+
+func TestThresholdsFor(t *testing.T) {
+	setFlags(t, "max-latency", "800ms", "min-speed", "0.5", "good-download-speed-threshold", "2")
+	entries, err := parseSources([]byte(`
+- {name: strict, url: https://a.example.com, max-latency: 300ms, min-speed: 3}
+- {name: lenient, url: https://b.example.com, good-download-speed-threshold: 1}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceOverrides = map[string]*sourceEntry{}
+	for _, entry := range entries {
+		sourceOverrides[entry.tag()] = entry
+	}
+	t.Cleanup(func() { sourceOverrides = nil })
+
+	tests := map[string]thresholds{
+		"strict":  {maxLatency: 300 * time.Millisecond, minSpeed: 3, minUploadSpeed: 2, goodSpeed: 2},
+		"lenient": {maxLatency: 800 * time.Millisecond, minSpeed: 0.5, minUploadSpeed: 2, goodSpeed: 1},
+		"other":   {maxLatency: 800 * time.Millisecond, minSpeed: 0.5, minUploadSpeed: 2, goodSpeed: 2},
+	}
+	for source, want := range tests {
+		if got := thresholdsFor(&speedtester.Result{Source: source}); got != want {
+			t.Errorf("%s: %+v, want %+v", source, got, want)
+		}
+	}
+
+	// 同样 1.5MB/s、400ms 的节点，在不同来源下结论不同
+	node := func(source string) *speedtester.Result {
+		return &speedtester.Result{Source: source, Latency: 400 * time.Millisecond, DownloadSpeed: 1.5 * 1024 * 1024, ExtraURLConnectivity: true}
+	}
+	if isProxyUsable(node("strict")) {
+		t.Error("node usable under the strict source's thresholds")
+	}
+	if !isProxyGood(node("lenient")) {
+		t.Error("node not good under the lenient source's threshold")
+	}
+	if !isProxyUsable(node("other")) || isProxyGood(node("other")) {
+		t.Error("node without overrides not judged by the command line thresholds")
+	}
+}
+
+func TestThresholdsForTypeOverride(t *testing.T) {
+	setFlags(t, "max-latency", "800ms")
+	strict := 300 * time.Millisecond
+	sourceOverrides = map[string]*sourceEntry{"strict": {maxLatency: &strict}}
+	t.Cleanup(func() { sourceOverrides = nil })
+
+	// ssh:latency-timeout=10s 的节点延迟探测放宽到了 10s，判定时也不再按 -max-latency 过滤
+	ssh := &speedtester.Result{ProxyType: "Ssh", TypeOverride: "latency-timeout=10s,timeout=20s", Latency: 3 * time.Second, DownloadSpeed: 1024 * 1024, ExtraURLConnectivity: true}
+	if got := thresholdsFor(ssh).maxLatency; got != 10*time.Second {
+		t.Errorf("max latency %s, want 10s", got)
+	}
+	if reason := unusableReason(ssh); reason != "" {
+		t.Errorf("overridden node unusable: %s", reason)
+	}
+	ssh.Source = "strict"
+	if got := thresholdsFor(ssh).maxLatency; got != 10*time.Second {
+		t.Errorf("type override should win over the source: %s", got)
+	}
+	// 没有覆盖 max-latency 的类型仍然使用来源的阈值
+	ssh.TypeOverride = "timeout=20s"
+	if got := thresholdsFor(ssh).maxLatency; got != 300*time.Millisecond {
+		t.Errorf("max latency %s, want the source's 300ms", got)
+	}
+}
@@ -0,0 +1,49 @@
+package speedtester
+
+import (
+	"maps"
+	"net/netip"
+	"strings"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+)
+
+// AllowIPv6 打开 mihomo 的 IPv6 解析。mihomo 默认关闭，server 是 IPv6 字面量的节点和 IPv6 的测速服务器
+// 都会因为 ip version error 连不上。这是 mihomo 的全局设置，由命令行启动时调用，作为库使用时由调用方决定
+func AllowIPv6() {
+	resolver.DisableIPv6 = false
+}
+
+// normalizeServer 规范化节点的 server 字段：去掉 IPv6 字面量两边的方括号，
+// IPv4 映射的 IPv6 地址转换成 IPv4，域名保持原样
+func normalizeServer(server string) string {
+	server = strings.TrimSpace(server)
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	addr, err := netip.ParseAddr(trimmed)
+	if err != nil {
+		return server
+	}
+	return addr.Unmap().String()
+}
+
+// withNormalizedServer 返回 server 字段规范化后的节点配置，需要修改时复制一份，传入的配置保持不变
+func withNormalizedServer(config map[string]any) map[string]any {
+	server, ok := config["server"].(string)
+	if !ok || normalizeServer(server) == server {
+		return config
+	}
+	config = maps.Clone(config)
+	config["server"] = normalizeServer(server)
+	return config
+}
+
+// dialMetadata 根据 host:port 构造拨号用的 Metadata，IP 字面量（包括 [IPv6]:port）填到 DstIP，
+// 域名填到 Host，交给节点的服务端解析
+func dialMetadata(address string) (*constant.Metadata, error) {
+	metadata := &constant.Metadata{}
+	if err := metadata.SetRemoteAddress(address); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
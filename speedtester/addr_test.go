@@ -0,0 +1,179 @@
+package speedtester
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/constant"
+)
+
+func TestNormalizeServer(t *testing.T) {
+	tests := map[string]string{
+		"[2001:db8::1]":      "2001:db8::1",
+		"2001:DB8::1":        "2001:db8::1",
+		" [::1] ":            "::1",
+		"::ffff:203.0.113.7": "203.0.113.7",
+		"203.0.113.7":        "203.0.113.7",
+		"example.com":        "example.com",
+		"[example.com]":      "[example.com]",
+	}
+	for server, want := range tests {
+		if got := normalizeServer(server); got != want {
+			t.Errorf("normalizeServer(%q) = %q, want %q", server, got, want)
+		}
+	}
+}
+
+func TestDialMetadata(t *testing.T) {
+	tests := []struct {
+		address string
+		host    string
+		ip      string
+		port    uint16
+	}{
+		{"[2001:db8::1]:443", "", "2001:db8::1", 443},
+		{"203.0.113.7:80", "", "203.0.113.7", 80},
+		{"example.com:8443", "example.com", "invalid IP", 8443},
+	}
+	for _, tt := range tests {
+		metadata, err := dialMetadata(tt.address)
+		if err != nil {
+			t.Errorf("%s: %v", tt.address, err)
+			continue
+		}
+		if metadata.Host != tt.host || metadata.DstIP.String() != tt.ip || metadata.DstPort != tt.port {
+			t.Errorf("%s: host %q, ip %s, port %d", tt.address, metadata.Host, metadata.DstIP, metadata.DstPort)
+		}
+	}
+	for _, address := range []string{"2001:db8::1:443", "example.com"} {
+		if _, err := dialMetadata(address); err == nil {
+			t.Errorf("%s: no error", address)
+		}
+	}
+}
+
+func TestLoadProxiesIPv6Server(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: v6, type: ss, server: "[2001:db8::1]", port: 443, cipher: aes-128-gcm, password: p}
+  - {name: v6 bare, type: ss, server: "2001:db8::2", port: 443, cipher: aes-128-gcm, password: p}
+  - {name: mapped, type: ss, server: "::ffff:203.0.113.7", port: 443, cipher: aes-128-gcm, password: p}
+`)
+	resolver := &fakeGeoResolver{countries: map[string]string{"2001:db8::1": "JP", "2001:db8::2": "JP", "203.0.113.7": "US"}}
+	st := New(&Config{ConfigPaths: path, ServerCountries: []string{"JP"}, ServerCountriesStrict: true, GeoResolver: resolver})
+	report, err := st.LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Proxies) != 2 {
+		t.Fatalf("kept %d proxies, want v6 and v6 bare", len(report.Proxies))
+	}
+	if got := report.Proxies["v6"].Config["server"]; got != "2001:db8::1" {
+		t.Errorf("bracketed server saved as %v", got)
+	}
+	// 去掉方括号之后地理位置查询用的是裸地址
+	if resolver.lookups["2001:db8::1"] != 1 || resolver.lookups["203.0.113.7"] != 1 {
+		t.Errorf("geo lookups %v", resolver.lookups)
+	}
+}
+
+// -save-original-config 时保存的仍然是带方括号的原始写法，测试用的是规范化后的地址
+func TestLoadProxiesIPv6ServerOriginal(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: v6, type: ss, server: "[2001:db8::1]", port: 443, cipher: aes-128-gcm, password: p}
+`)
+	report, err := New(&Config{ConfigPaths: path, SaveOriginalConfig: true}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := report.Proxies["v6"]
+	if proxy == nil {
+		t.Fatal("bracketed server not parsed")
+	}
+	if got := proxy.Config["server"]; got != "[2001:db8::1]" {
+		t.Errorf("original server saved as %v", got)
+	}
+	if got := proxy.Addr(); got != "[2001:db8::1]:443" {
+		t.Errorf("proxy dials %s", got)
+	}
+}
+
+// ipv6Server 在 [::1] 上启动 httptest 服务器，本机没有 IPv6 回环地址时跳过测试
+func ipv6Server(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("ipv6 loopback unavailable: %v", err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// connectProxy 是只支持 CONNECT 的 HTTP 代理
+func connectProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+		return
+	}
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	w.WriteHeader(http.StatusOK)
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+// allowIPv6 在测试期间打开 mihomo 的 IPv6 解析，结束后恢复原来的设置
+func allowIPv6(t *testing.T) {
+	disableIPv6 := resolver.DisableIPv6
+	AllowIPv6()
+	t.Cleanup(func() { resolver.DisableIPv6 = disableIPv6 })
+}
+
+// 测速服务器和节点的 server 都是 IPv6 字面量
+func TestCreateClientIPv6(t *testing.T) {
+	allowIPv6(t)
+	target := ipv6Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	proxyServer := ipv6Server(t, http.HandlerFunc(connectProxy))
+	_, port, _ := net.SplitHostPort(proxyServer.Listener.Addr().String())
+	path := writeTestConfig(t, `proxies:
+  - {name: v6 http, type: http, server: "[::1]", port: `+port+`}
+`)
+	report, err := New(&Config{ConfigPaths: path}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := New(&Config{})
+	for name, proxy := range map[string]constant.Proxy{"direct": directProxy(t), "v6 http": report.Proxies["v6 http"]} {
+		client := st.createClient(proxy, 5*time.Second)
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, target.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s: GET %s: %v", name, target.URL, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: GET %s: %s", name, target.URL, resp.Status)
+		}
+	}
+}
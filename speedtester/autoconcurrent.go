@@ -0,0 +1,142 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// autoConcurrentWindow 是自动调整并发时每个流数下测量吞吐量的时长
+const autoConcurrentWindow = 2 * time.Second
+
+// autoConcurrentGain 是增加一个流后吞吐量至少要提高的比例，达不到就认为已经饱和
+const autoConcurrentGain = 0.1
+
+// streamController 根据每个测量窗口的总吞吐量决定下载使用的流数：
+// 从 1 个流开始，吞吐量提高超过 autoConcurrentGain 就再加一个流，直到不再提高或达到 max
+type streamController struct {
+	max     int
+	streams int
+	best    float64
+	bestAt  int
+	done    bool
+}
+
+func newStreamController(max int) *streamController {
+	return &streamController{max: max, streams: 1}
+}
+
+// observe 记录当前流数下一个窗口的吞吐量，返回下一个窗口应该使用的流数
+func (c *streamController) observe(throughput float64) int {
+	if c.done {
+		return c.streams
+	}
+	if c.bestAt == 0 || throughput > c.best*(1+autoConcurrentGain) {
+		c.best, c.bestAt = throughput, c.streams
+		if c.streams < c.max {
+			c.streams++
+			return c.streams
+		}
+	}
+	c.done = true
+	return c.streams
+}
+
+// measureDownloadAuto 在 size 字节的总量内下载，按 streamController 逐步增加并发流。
+// 下载速度取最好的测量窗口，DownloadStreams 记录达到这个速度用的流数。
+// 总量不够测完一个窗口时按实际下载的字节数和耗时计算
+func (st *SpeedTester) measureDownloadAuto(parent context.Context, proxy constant.Proxy, size int, result *Result) {
+	controller := newStreamController(st.config.Concurrent)
+	ctx, cancel := context.WithTimeout(parent, st.config.DownloadTimeout+autoConcurrentWindow*time.Duration(st.config.Concurrent))
+	defer cancel()
+	client := st.createClient(proxy, st.config.DownloadTimeout+autoConcurrentWindow*time.Duration(st.config.Concurrent))
+	defer client.CloseIdleConnections()
+
+	chunk := size / st.config.Concurrent
+	if max := st.maxDownloadRequest(); max > 0 && chunk > max {
+		chunk = max
+	}
+	var remaining, downloaded atomic.Int64
+	remaining.Store(int64(size))
+	counter := writerFunc(func(p []byte) (int, error) {
+		downloaded.Add(int64(len(p)))
+		return len(p), nil
+	})
+	var wg sync.WaitGroup
+	finished := make(chan struct{})
+	startStream := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n := min(int64(chunk), remaining.Add(-int64(chunk))+int64(chunk))
+				if n <= 0 {
+					return
+				}
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/__down?bytes=%d", st.config.DownloadServerURL, n), nil)
+				if err != nil {
+					return
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					return
+				}
+				written, _ := readBody(resp.Body, 0, st.measureSinks(counter)...)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || written == 0 {
+					return
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	startStream()
+	streams := 1
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	ticker := time.NewTicker(autoConcurrentWindow)
+	defer ticker.Stop()
+	var last int64
+loop:
+	for {
+		select {
+		case <-finished:
+			break loop
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			now := downloaded.Load()
+			next := controller.observe(float64(now-last) / autoConcurrentWindow.Seconds())
+			last = now
+			for ; streams < next; streams++ {
+				startStream()
+			}
+		}
+	}
+	cancel()
+	<-finished
+
+	result.DownloadSize = float64(downloaded.Load())
+	result.DownloadTime = time.Since(start)
+	result.DownloadSpeed, result.DownloadStreams = 0, 0
+	switch {
+	case controller.bestAt > 0:
+		result.DownloadSpeed, result.DownloadStreams = controller.best, controller.bestAt
+	case result.DownloadSize > 0:
+		result.DownloadSpeed, result.DownloadStreams = result.DownloadSize/result.DownloadTime.Seconds(), streams
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
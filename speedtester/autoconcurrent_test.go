@@ -0,0 +1,144 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runController 用合成的吞吐量曲线驱动 streamController，curve 返回给定流数下的总吞吐量，
+// 返回每个窗口使用的流数
+func runController(max int, curve func(streams int) float64) (*streamController, []int) {
+	c := newStreamController(max)
+	var windows []int
+	streams := 1
+	for !c.done {
+		windows = append(windows, streams)
+		streams = c.observe(curve(streams))
+	}
+	return c, windows
+}
+
+func TestStreamController(t *testing.T) {
+	tests := []struct {
+		name        string
+		max         int
+		curve       func(streams int) float64
+		wantStreams int
+		wantBest    float64
+		windows     int
+	}{
+		// 每个流 10，本地带宽 32 时第 4 个流只提高 ~7%
+		{"saturates", 8, func(n int) float64 { return min(float64(n)*10, 32) }, 3, 30, 4},
+		{"linear up to max", 4, func(n int) float64 { return float64(n) * 10 }, 4, 40, 4},
+		{"flat", 8, func(n int) float64 { return 50 }, 1, 50, 2},
+		// 多开流反而变慢，结果取最好的窗口
+		{"degrades", 8, func(n int) float64 { return []float64{0, 10, 20, 12}[min(n, 3)] }, 2, 20, 3},
+		// 恰好 10% 不算提高
+		{"gain at threshold", 8, func(n int) float64 { return []float64{0, 100, 110, 200}[min(n, 3)] }, 1, 100, 2},
+		{"single stream", 1, func(n int) float64 { return 10 }, 1, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, windows := runController(tt.max, tt.curve)
+			if c.bestAt != tt.wantStreams || c.best != tt.wantBest {
+				t.Errorf("best %v at %d streams, want %v at %d", c.best, c.bestAt, tt.wantBest, tt.wantStreams)
+			}
+			if len(windows) != tt.windows {
+				t.Errorf("windows %v, want %d", windows, tt.windows)
+			}
+			for _, streams := range windows {
+				if streams > tt.max {
+					t.Errorf("used %d streams, max %d", streams, tt.max)
+				}
+			}
+		})
+	}
+
+	// 结束后不再改变流数
+	c, _ := runController(8, func(n int) float64 { return 10 })
+	if got := c.observe(1000); got != 2 || c.best != 10 {
+		t.Errorf("observe after done = %d, best %v", got, c.best)
+	}
+}
+
+// throttledServer 每个连接限速 perStream 字节/秒，所有连接合计限速 total 字节/秒
+func throttledServer(t *testing.T, perStream, total int) *httptest.Server {
+	t.Helper()
+	const block = 8 * 1024
+	var mu sync.Mutex
+	next := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		buf := make([]byte, block)
+		for sent := 0; sent < n; sent += block {
+			mu.Lock()
+			next = maxTime(next, time.Now()).Add(time.Duration(block) * time.Second / time.Duration(total))
+			wait := time.Until(next)
+			mu.Unlock()
+			time.Sleep(max(wait, time.Duration(block)*time.Second/time.Duration(perStream)))
+			if _, err := w.Write(buf[:min(block, n-sent)]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func TestMeasureDownloadAuto(t *testing.T) {
+	if testing.Short() {
+		t.Skip("measures three 2s windows")
+	}
+	// 第二个流带来 50% 提高，第三个流受总带宽限制没有提高
+	server := throttledServer(t, 256*1024, 384*1024)
+	st := New(&Config{DownloadServerURL: server.URL, DownloadTimeout: time.Second, Concurrent: 3})
+	result := &Result{}
+	st.measureDownloadAuto(context.Background(), directProxy(t), 100<<20, result)
+	if result.DownloadStreams != 2 {
+		t.Errorf("picked %d streams, want 2", result.DownloadStreams)
+	}
+	if speed := result.DownloadSpeed; speed < 300*1024 || speed > 420*1024 {
+		t.Errorf("download speed %s, want about 384KB/s", FormatSpeed(speed))
+	}
+}
+
+func TestMeasureDownloadAutoShort(t *testing.T) {
+	// 总量不够测完一个窗口时按实际下载的字节数和耗时计算
+	server, requested := downloadServer(t)
+	st := New(&Config{DownloadServerURL: server.URL, DownloadTimeout: 5 * time.Second, Concurrent: 4})
+	result := &Result{}
+	st.measureDownloadAuto(context.Background(), directProxy(t), 4<<20, result)
+	if result.DownloadStreams != 1 || result.DownloadSize != 4<<20 || result.DownloadSpeed <= 0 {
+		t.Errorf("result: %d streams, %v bytes, speed %v", result.DownloadStreams, result.DownloadSize, result.DownloadSpeed)
+	}
+	if got := requested(); len(got) != 4 || got[0] != 1<<20 {
+		t.Errorf("requests = %v, want four 1MB chunks", got)
+	}
+}
+
+func TestMeasureDownloadAutoFallback(t *testing.T) {
+	// -max-download-bytes 的下载撑不满测量窗口，退回固定并发，由计量器提前结束
+	server, _ := downloadServer(t)
+	st := New(&Config{DownloadServerURL: server.URL, DownloadTimeout: 5 * time.Second, Concurrent: 4, AutoConcurrent: true, MaxDownloadBytes: 1 << 20})
+	result := &Result{}
+	st.measureDownload(context.Background(), directProxy(t), 1<<20, result)
+	if result.DownloadStreams != 0 {
+		t.Errorf("DownloadStreams = %d, want 0 for a fixed-concurrency download", result.DownloadStreams)
+	}
+	if result.DownloadStop != DownloadStopCap {
+		t.Errorf("DownloadStop = %q, want %q", result.DownloadStop, DownloadStopCap)
+	}
+}
@@ -0,0 +1,50 @@
+package speedtester
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// readBufferSize 与 io.Copy 默认的缓冲区大小相同
+const readBufferSize = 32 * 1024
+
+var readBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, readBufferSize)
+		return &buf
+	},
+}
+
+// readBody 是所有下载路径共用的读取方式：用 readBufferPool 中的缓冲区把 body 读完丢弃，
+// 每读到一段就依次交给 sinks（按秒采样的 rateMeter、计数器、哈希等），整个过程只有一次拷贝。
+// limit 大于 0 时最多读取 limit 字节。返回读到的字节数，读到 EOF 不算错误
+func readBody(body io.Reader, limit int64, sinks ...io.Writer) (int64, error) {
+	bufp := readBufferPool.Get().(*[]byte)
+	defer readBufferPool.Put(bufp)
+	buf := *bufp
+
+	var total int64
+	for limit <= 0 || total < limit {
+		chunk := buf
+		if limit > 0 && limit-total < int64(len(chunk)) {
+			chunk = chunk[:limit-total]
+		}
+		n, err := body.Read(chunk)
+		if n > 0 {
+			total += int64(n)
+			for _, sink := range sinks {
+				if _, werr := sink.Write(chunk[:n]); werr != nil {
+					return total, werr
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,126 @@
+package speedtester
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReadBody(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		name  string
+		limit int64
+		want  int64
+	}{
+		{"no limit", 0, int64(len(data))},
+		{"limit", 12345, 12345},
+		{"limit over a buffer", readBufferSize + 1, readBufferSize + 1},
+		{"limit beyond body", int64(len(data)) * 2, int64(len(data))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sink1, sink2 bytes.Buffer
+			// HalfReader 每次只返回请求长度的一半，缓冲区不会被填满
+			for _, body := range []io.Reader{bytes.NewReader(data), iotest.HalfReader(bytes.NewReader(data))} {
+				sink1.Reset()
+				sink2.Reset()
+				n, err := readBody(body, tt.limit, &sink1, &sink2)
+				if err != nil || n != tt.want {
+					t.Fatalf("readBody = %d, %v, want %d", n, err, tt.want)
+				}
+				if !bytes.Equal(sink1.Bytes(), data[:n]) || !bytes.Equal(sink2.Bytes(), data[:n]) {
+					t.Error("sinks did not receive the bytes read")
+				}
+			}
+		})
+	}
+}
+
+func TestReadBodyErrors(t *testing.T) {
+	readErr := errors.New("connection reset")
+	body := io.MultiReader(bytes.NewReader(make([]byte, 100)), iotest.ErrReader(readErr))
+	if n, err := readBody(body, 0); n != 100 || !errors.Is(err, readErr) {
+		t.Errorf("read error: %d, %v", n, err)
+	}
+
+	// 数据和 EOF 一起返回时不丢数据
+	if n, err := readBody(iotest.DataErrReader(bytes.NewReader(make([]byte, 100))), 0); n != 100 || err != nil {
+		t.Errorf("data with EOF: %d, %v", n, err)
+	}
+
+	writeErr := errors.New("sink full")
+	failing := writerFunc(func(p []byte) (int, error) { return 0, writeErr })
+	var after bytes.Buffer
+	if _, err := readBody(bytes.NewReader(make([]byte, 100)), 0, failing, &after); !errors.Is(err, writeErr) || after.Len() != 0 {
+		t.Errorf("sink error: %v, later sink got %d bytes", err, after.Len())
+	}
+}
+
+func TestReadBodyAllocs(t *testing.T) {
+	data := make([]byte, 1<<20)
+	body := bytes.NewReader(data)
+	// 缓冲区来自 readBufferPool，稳定后每次读取不再分配 32KB
+	allocs := testing.AllocsPerRun(100, func() {
+		body.Reset(data)
+		readBody(body, 0, io.Discard)
+	})
+	if allocs > 2 {
+		t.Errorf("readBody allocates %v times per call", allocs)
+	}
+}
+
+// 对比 readBody 与各个包装各自 io.Copy 的开销，用 -bench ReadBody -benchmem 运行
+func benchmarkBody(b *testing.B, read func(io.Reader)) {
+	data := make([]byte, 4<<20)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		body := bytes.NewReader(data)
+		for pb.Next() {
+			body.Reset(data)
+			read(body)
+		}
+	})
+}
+
+func BenchmarkIOCopy(b *testing.B) {
+	benchmarkBody(b, func(body io.Reader) {
+		// bytes.Reader 实现了 WriterTo，包一层才和真实的 http body 一样走缓冲区
+		io.Copy(io.Discard, struct{ io.Reader }{body})
+	})
+}
+
+func BenchmarkReadBody(b *testing.B) {
+	benchmarkBody(b, func(body io.Reader) {
+		readBody(body, 0)
+	})
+}
+
+func BenchmarkReadBodySinks(b *testing.B) {
+	benchmarkBody(b, func(body io.Reader) {
+		meter := &rateMeter{start: time.Now()}
+		var counter int64
+		readBody(body, 0, meter, writerFunc(func(p []byte) (int, error) {
+			counter += int64(len(p))
+			return len(p), nil
+		}))
+	})
+}
+
+func BenchmarkReadBodyHash(b *testing.B) {
+	benchmarkBody(b, func(body io.Reader) {
+		readBody(body, 0, sha256.New())
+	})
+}
+
+// BenchmarkIOCopyHash 是改动前哈希再包一层 io.Copy 的写法
+func BenchmarkIOCopyHash(b *testing.B) {
+	benchmarkBody(b, func(body io.Reader) {
+		io.Copy(io.Discard, io.TeeReader(body, sha256.New()))
+	})
+}
@@ -0,0 +1,90 @@
+package speedtester
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/metacubex/mihomo/adapter"
+)
+
+// CCBrutal 是 hysteria2 按 up/down 固定带宽发送的模式，其余取值与 tuic 的 congestion-controller 相同
+const CCBrutal = "brutal"
+
+var ccVariants = map[string]bool{"bbr": true, "cubic": true, "new_reno": true, CCBrutal: true}
+
+// ParseCCSweep 解析逗号分隔的拥塞控制算法列表，支持 bbr、cubic、new_reno 和 brutal
+func ParseCCSweep(spec string) ([]string, error) {
+	var variants []string
+	seen := make(map[string]bool)
+	for _, cc := range strings.Split(spec, ",") {
+		cc = strings.ToLower(strings.TrimSpace(cc))
+		if cc == "" || seen[cc] {
+			continue
+		}
+		if !ccVariants[cc] {
+			return nil, fmt.Errorf("unknown congestion control %q, supported: bbr, cubic, new_reno, brutal", cc)
+		}
+		seen[cc] = true
+		variants = append(variants, cc)
+	}
+	return variants, nil
+}
+
+// ccVariantConfig 返回使用指定拥塞控制算法的节点配置副本，节点不支持这个算法时返回 false。
+// tuic 直接设置 congestion-controller；mihomo 的 hysteria2 没有这个选项，
+// 设置了 up 时走 brutal，不设置带宽时走 bbr，其他算法不支持
+func ccVariantConfig(config map[string]any, cc string) (map[string]any, bool) {
+	switch toString(config["type"]) {
+	case "tuic":
+		if cc == CCBrutal {
+			return nil, false
+		}
+		return DeepMerge(config, map[string]any{"congestion-controller": cc}), true
+	case "hysteria2":
+		switch cc {
+		case "bbr":
+			variant := DeepMerge(config, nil)
+			delete(variant, "up")
+			delete(variant, "down")
+			return variant, true
+		case CCBrutal:
+			if toString(config["up"]) == "" {
+				return nil, false
+			}
+			return DeepMerge(config, nil), true
+		}
+	}
+	return nil, false
+}
+
+// expandCCVariants 把 tuic 和 hysteria2 节点按 Config.CCSweep 复制成多个拥塞控制算法不同的节点，
+// 节点名后面加上 [算法名]。一个算法都不支持的节点保持原样
+func (st *SpeedTester) expandCCVariants(proxies map[string]*CProxy, report *LoadReport) map[string]*CProxy {
+	expanded := make(map[string]*CProxy, len(proxies))
+	for name, proxy := range proxies {
+		variants := 0
+		for _, cc := range st.config.CCSweep {
+			config, ok := ccVariantConfig(proxy.Config, cc)
+			if !ok {
+				continue
+			}
+			parsed, err := adapter.ParseProxy(config)
+			if err != nil {
+				report.ParseErrors = append(report.ParseErrors, fmt.Errorf("proxy %s with %s: %w", name, cc, err))
+				continue
+			}
+			expanded[name+" ["+cc+"]"] = &CProxy{
+				Proxy:             parsed,
+				Config:            config,
+				SSHVerified:       proxy.SSHVerified,
+				Source:            proxy.Source,
+				CongestionControl: cc,
+			}
+			variants++
+		}
+		if variants == 0 {
+			expanded[name] = proxy
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,103 @@
+package speedtester
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestParseCCSweep(t *testing.T) {
+	got, err := ParseCCSweep(" BBR, cubic,,bbr ,brutal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bbr", "cubic", CCBrutal}; !slices.Equal(got, want) {
+		t.Errorf("ParseCCSweep = %v, want %v", got, want)
+	}
+	if got, err := ParseCCSweep(""); err != nil || len(got) != 0 {
+		t.Errorf("ParseCCSweep(\"\") = %v, %v", got, err)
+	}
+	if _, err := ParseCCSweep("bbr,reno"); err == nil {
+		t.Error("ParseCCSweep accepted unknown congestion control reno")
+	}
+}
+
+func TestCCVariantConfig(t *testing.T) {
+	tuic := map[string]any{"name": "T", "type": "tuic", "server": "t.example.com", "port": 443, "uuid": "u", "password": "p", "congestion-controller": "cubic"}
+	hy2 := map[string]any{"name": "H", "type": "hysteria2", "server": "h.example.com", "port": 443, "password": "p", "up": "30 Mbps", "down": "100 Mbps"}
+	hy2NoUp := map[string]any{"name": "H", "type": "hysteria2", "server": "h.example.com", "port": 443, "password": "p"}
+	ss := map[string]any{"name": "S", "type": "ss", "server": "s.example.com", "port": 443, "cipher": "aes-128-gcm", "password": "p"}
+
+	tests := []struct {
+		name   string
+		config map[string]any
+		cc     string
+		ok     bool
+		check  func(map[string]any) bool
+	}{
+		{"tuic bbr", tuic, "bbr", true, func(c map[string]any) bool { return c["congestion-controller"] == "bbr" }},
+		{"tuic new_reno", tuic, "new_reno", true, func(c map[string]any) bool { return c["congestion-controller"] == "new_reno" }},
+		{"tuic brutal", tuic, CCBrutal, false, nil},
+		{"hysteria2 bbr drops bandwidth", hy2, "bbr", true, func(c map[string]any) bool { return c["up"] == nil && c["down"] == nil }},
+		{"hysteria2 brutal keeps bandwidth", hy2, CCBrutal, true, func(c map[string]any) bool { return c["up"] == "30 Mbps" && c["down"] == "100 Mbps" }},
+		{"hysteria2 brutal without up", hy2NoUp, CCBrutal, false, nil},
+		{"hysteria2 cubic", hy2, "cubic", false, nil},
+		{"ss", ss, "bbr", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ccVariantConfig(tt.config, tt.cc)
+			if ok != tt.ok {
+				t.Fatalf("ccVariantConfig ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !tt.check(got) {
+				t.Errorf("ccVariantConfig = %v", got)
+			}
+		})
+	}
+
+	// 变体是副本，原配置不变
+	if tuic["congestion-controller"] != "cubic" || hy2["up"] != "30 Mbps" {
+		t.Errorf("ccVariantConfig modified the original config: %v %v", tuic, hy2)
+	}
+}
+
+func TestLoadProxiesCCSweep(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: T, type: tuic, server: 1.1.1.1, port: 443, uuid: 00000000-0000-0000-0000-000000000000, password: p}
+  - {name: H, type: hysteria2, server: 2.2.2.2, port: 443, password: p, up: 30 Mbps, down: 100 Mbps}
+  - {name: S, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	st := New(&Config{ConfigPaths: path, CCSweep: []string{"bbr", "cubic", CCBrutal}})
+	report, err := st.LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range report.Proxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{"H [bbr]", "H [brutal]", "S", "T [bbr]", "T [cubic]"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("proxies %v, want %v", names, want)
+	}
+
+	variant := report.Proxies["T [cubic]"]
+	if variant.CongestionControl != "cubic" || variant.Config["congestion-controller"] != "cubic" || variant.Config["name"] != "T" {
+		t.Errorf("T [cubic] = cc %q, config %v", variant.CongestionControl, variant.Config)
+	}
+	if variant.Proxy == report.Proxies["T [bbr]"].Proxy {
+		t.Error("variants share the same parsed proxy")
+	}
+	if report.Proxies["S"].CongestionControl != "" {
+		t.Error("ss node marked as a cc variant")
+	}
+	// 变体的 NodeKey 和原节点相同，去重时按拥塞控制算法区分
+	if NodeKey(report.Proxies["T [bbr]"].Config) != NodeKey(variant.Config) {
+		t.Error("T [bbr] and T [cubic] have different node keys")
+	}
+	if dedupKey(report.Proxies["T [bbr]"]) == dedupKey(variant) {
+		t.Error("T [bbr] and T [cubic] have the same dedup key")
+	}
+}
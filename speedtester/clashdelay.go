@@ -0,0 +1,24 @@
+package speedtester
+
+import (
+	"context"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// DefaultClashDelayURL 是 clash 客户端 url-test 默认使用的测试地址
+const DefaultClashDelayURL = "http://www.gstatic.com/generate_204"
+
+// testClashDelay 调用 mihomo 适配器自带的 URLTest 测一次延迟，与 clash 客户端面板上显示的数字口径一致：
+// 单次请求、包含建立连接的时间。适配器测不了时只记录错误，不影响其他测试
+func (st *SpeedTester) testClashDelay(ctx context.Context, proxy constant.Proxy, result *Result) {
+	ctx, cancel := context.WithTimeout(ctx, st.config.Timeout)
+	defer cancel()
+	delay, err := proxy.URLTest(ctx, st.config.ClashDelayURL, nil)
+	if err != nil {
+		result.ClashDelayError = err.Error()
+		return
+	}
+	result.ClashDelay = time.Duration(delay) * time.Millisecond
+}
@@ -0,0 +1,37 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTestClashDelay(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	st := New(&Config{Timeout: 5 * time.Second, ClashDelayURL: server.URL + "/generate_204"})
+
+	result := &Result{}
+	st.testClashDelay(context.Background(), directProxy(t), result)
+	if result.ClashDelayError != "" || result.ClashDelay < 20*time.Millisecond {
+		t.Errorf("clash delay %s, error %q", result.ClashDelay, result.ClashDelayError)
+	}
+	if requests.Load() == 0 {
+		t.Error("url-test request did not reach the server")
+	}
+
+	// 适配器连不上时只记录错误
+	result = &Result{}
+	st.testClashDelay(context.Background(), deadSocks5(t), result)
+	if result.ClashDelayError == "" || result.ClashDelay != 0 {
+		t.Errorf("dead node: clash delay %s, error %q", result.ClashDelay, result.ClashDelayError)
+	}
+}
@@ -0,0 +1,49 @@
+package speedtester
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock 是测速计时使用的时间源，默认使用系统时间，测试时可以替换成可控的实现
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock 是使用系统时间的 Clock
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// suspendThreshold 是墙上时钟比单调时钟多走的时间超过多少时认为系统睡眠过或时钟被调整过
+const suspendThreshold = 30 * time.Second
+
+// detectClockJump 检查一个测试阶段是否跨越了系统睡眠或时钟跳变，正常时返回空字符串。
+// 单调时钟在睡眠期间不走，墙上时钟会走，两者相差太多就说明中途睡眠过；
+// 另外阶段耗时超过 expected（大于 0 时）也视为异常，这种情况下测出来的延迟和速度都不可信
+func detectClockJump(start, end time.Time, expected time.Duration) string {
+	elapsed := end.Sub(start)
+	wall := end.Round(0).Sub(start.Round(0))
+	if drift := wall - elapsed; drift > suspendThreshold || drift < -suspendThreshold {
+		return fmt.Sprintf("clock jumped %s between %s and %s (system suspended?)",
+			drift.Round(time.Second), start.Format(time.TimeOnly), end.Format(time.TimeOnly))
+	}
+	if expected > 0 && elapsed > expected {
+		return fmt.Sprintf("phase took %s, expected at most %s (system suspended?)",
+			elapsed.Round(time.Second), expected)
+	}
+	return ""
+}
+
+// latencyPhaseBound 是 6 次延迟探测最多可能花费的时间
+func (st *SpeedTester) latencyPhaseBound() time.Duration {
+	if st.config.MaxLatency <= 0 {
+		return 0
+	}
+	probe := st.config.MaxLatency + time.Duration(float64(pingInterval)*(1+st.config.PingIntervalJitter))
+	return 6*probe + 5*time.Second
+}
@@ -0,0 +1,145 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jumpClock 是可以手动往前拨的 Clock，返回的时间不带单调时钟读数
+type jumpClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *jumpClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Round(0).Add(c.offset)
+}
+
+func (c *jumpClock) jump(d time.Duration) {
+	c.mu.Lock()
+	c.offset += d
+	c.mu.Unlock()
+}
+
+func TestDetectClockJump(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		end      time.Time
+		expected time.Duration
+		want     string
+	}{
+		{"normal", start.Add(2 * time.Second), 10 * time.Second, ""},
+		{"no bound", start.Add(time.Hour), 0, ""},
+		{"too long", start.Add(40 * time.Minute), 10 * time.Second, "phase took 40m0s, expected at most 10s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectClockJump(start, tt.end, tt.expected)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("detectClockJump = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 带单调时钟读数、没有睡眠过的时间不会被误判
+	now := time.Now()
+	if got := detectClockJump(now, time.Now(), time.Minute); got != "" {
+		t.Errorf("detectClockJump on monotonic times = %q", got)
+	}
+}
+
+func TestLatencyPhaseBound(t *testing.T) {
+	st := New(&Config{MaxLatency: time.Second, PingIntervalJitter: 0.5})
+	want := 6*(time.Second+150*time.Millisecond) + 5*time.Second
+	if got := st.latencyPhaseBound(); got != want {
+		t.Errorf("latencyPhaseBound = %s, want %s", got, want)
+	}
+	if got := New(&Config{}).latencyPhaseBound(); got != 0 {
+		t.Errorf("latencyPhaseBound without MaxLatency = %s, want 0", got)
+	}
+}
+
+// 延迟阶段中途时钟跳了一小时的节点不交出结果，等全部节点测完后重测一次
+func TestSuspendRetest(t *testing.T) {
+	clock := &jumpClock{}
+	var probes atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("bytes") == "0" && probes.Add(1) == 1 {
+			clock.jump(time.Hour)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	st := New(&Config{
+		ServerURL:      server.URL,
+		FastMode:       true,
+		Timeout:        5 * time.Second,
+		MaxLatency:     5 * time.Second,
+		Concurrent:     1,
+		NodeConcurrent: 1,
+		Clock:          clock,
+	})
+	var results []*Result
+	st.TestProxies(context.Background(), map[string]*CProxy{"direct": {Proxy: directProxy(t)}}, nil, func(result *Result) {
+		results = append(results, result)
+	})
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if results[0].Invalid != "" || results[0].Latency <= 0 {
+		t.Errorf("retested result: invalid %q, latency %s", results[0].Invalid, results[0].Latency)
+	}
+	if got := probes.Load(); got != 12 {
+		t.Errorf("got %d latency probes, want 12 (tested twice)", got)
+	}
+}
+
+// 要重测的节点只报告一次开始和一次结束，结束时带的是重测的结果
+func TestSuspendRetestProgress(t *testing.T) {
+	clock := &jumpClock{}
+	var probes atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("bytes") == "0" && probes.Add(1) == 1 {
+			clock.jump(time.Hour)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	st := New(&Config{
+		ServerURL:      server.URL,
+		FastMode:       true,
+		Timeout:        5 * time.Second,
+		MaxLatency:     5 * time.Second,
+		Concurrent:     1,
+		NodeConcurrent: 1,
+		Clock:          clock,
+	})
+	recorder := &progressRecorder{started: map[string]int{}, finished: map[string]*Result{}}
+	var results []*Result
+	st.TestProxies(context.Background(), map[string]*CProxy{"direct": {Proxy: directProxy(t)}}, recorder, func(result *Result) {
+		results = append(results, result)
+	})
+	for _, err := range recorder.errors {
+		t.Error(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if recorder.started["direct"] != 1 || recorder.finished["direct"] != results[0] {
+		t.Errorf("started %d times, finished with %p, want once with %p", recorder.started["direct"], recorder.finished["direct"], results[0])
+	}
+	if recorder.inFlight != 0 {
+		t.Errorf("%d nodes still in flight", recorder.inFlight)
+	}
+}
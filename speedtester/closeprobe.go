@@ -0,0 +1,82 @@
+package speedtester
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// testCloseLatency 在一条单独的连接上发送 Connection: close 的请求，读完响应后计时，
+// 直到服务器的关闭穿过隧道传回本地（读到 EOF）并且本地 Close 返回。
+// 有的节点在响应结束后很久才关闭连接，会拖垮大量使用短连接的客户端
+func (st *SpeedTester) testCloseLatency(ctx context.Context, proxy constant.Proxy, result *Result) {
+	u, err := url.Parse(st.config.DownloadServerURL + "/__down?bytes=0")
+	if err != nil {
+		return
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	metadata, err := dialMetadata(net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, st.config.Timeout)
+	defer cancel()
+	proxyConn, err := proxy.DialContext(ctx, metadata)
+	if err != nil {
+		return
+	}
+	var conn net.Conn = proxyConn
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(st.config.Timeout))
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return
+		}
+		conn = tlsConn
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// 响应读完后开始计时，超时视为连接没有被关闭
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(st.config.Timeout))
+	_, err = io.Copy(io.Discard, reader)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		result.CloseLatency = st.config.Timeout
+		result.CloseTimedOut = true
+		return
+	}
+	conn.Close()
+	result.CloseLatency = time.Since(start)
+}
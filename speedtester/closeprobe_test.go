@@ -0,0 +1,69 @@
+package speedtester
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// lingeringServer 回复 Connection: close 的空响应之后等 linger 才关闭连接，模拟 FIN 迟迟不到的节点
+func lingeringServer(t *testing.T, linger time.Duration) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+				time.Sleep(linger)
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func TestTestCloseLatency(t *testing.T) {
+	tests := []struct {
+		name     string
+		linger   time.Duration
+		min, max time.Duration
+		timedOut bool
+	}{
+		{"prompt close", 0, 0, 200 * time.Millisecond, false},
+		{"slow close", 300 * time.Millisecond, 250 * time.Millisecond, time.Second, false},
+		{"never closes", 3 * time.Second, time.Second, time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := New(&Config{DownloadServerURL: lingeringServer(t, tt.linger), Timeout: time.Second})
+			result := &Result{}
+			st.testCloseLatency(context.Background(), directProxy(t), result)
+			if result.CloseTimedOut != tt.timedOut || result.CloseLatency < tt.min || result.CloseLatency > tt.max {
+				t.Errorf("close latency %s, timed out %v", result.CloseLatency, result.CloseTimedOut)
+			}
+		})
+	}
+}
+
+func TestTestCloseLatencyUnreachable(t *testing.T) {
+	st := New(&Config{DownloadServerURL: "http://127.0.0.1:1", Timeout: time.Second})
+	result := &Result{}
+	st.testCloseLatency(context.Background(), directProxy(t), result)
+	if result.CloseLatency != 0 || result.CloseTimedOut {
+		t.Errorf("unreachable server recorded close latency %s, timed out %v", result.CloseLatency, result.CloseTimedOut)
+	}
+}
@@ -0,0 +1,59 @@
+package speedtester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// snippetLength 是错误信息里附带的内容片段长度
+const snippetLength = 120
+
+// checkConfigContent 检查下载到的内容是不是 clash 配置。过期的订阅经常返回 200 的 HTML 页面或 JSON 错误，
+// 这些内容能被 yaml 解析成一个空配置，不检查的话这个来源会悄无声息地贡献 0 个节点
+func checkConfigContent(contentType string, body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return fmt.Errorf("empty response")
+	}
+	lower := strings.ToLower(string(trimmed[:min(len(trimmed), 64)]))
+	if strings.Contains(strings.ToLower(contentType), "text/html") ||
+		strings.HasPrefix(lower, "<!doctype") || strings.HasPrefix(lower, "<html") {
+		return fmt.Errorf("got an html page instead of a config: %q", contentSnippet(trimmed))
+	}
+	if countShareLinks(decodeSubscription(trimmed)) > 0 {
+		return nil
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal(trimmed, &doc); err != nil {
+		// 交给后面的解析报出具体的 yaml 错误
+		return nil
+	}
+	if _, ok := doc["proxies"]; ok {
+		return nil
+	}
+	if _, ok := doc["proxy-providers"]; ok {
+		return nil
+	}
+	if _, ok := doc["outbounds"]; ok && json.Valid(trimmed) {
+		// sing-box 配置
+		return nil
+	}
+	if json.Valid(trimmed) {
+		return fmt.Errorf("got a json response without proxies: %q", contentSnippet(trimmed))
+	}
+	return fmt.Errorf("no proxies or proxy-providers found: %q", contentSnippet(trimmed))
+}
+
+// contentSnippet 返回内容开头的一小段，连续空白压缩成一个空格
+func contentSnippet(body []byte) string {
+	snippet := strings.Join(strings.Fields(string(body)), " ")
+	if runes := []rune(snippet); len(runes) > snippetLength {
+		snippet = string(runes[:snippetLength]) + "..."
+	}
+	return snippet
+}
@@ -0,0 +1,76 @@
+package speedtester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		err         string
+	}{
+		{"clash config", "", "proxies:\n  - {name: a, type: ss}\n", ""},
+		{"providers only", "", "proxy-providers:\n  a: {type: http, url: https://example.com}\n", ""},
+		{"share links", "", "c3M6Ly9ZV1Z6TFRFeU9DMW5ZMjA2Y0FAMS4xLjEuMTo0NDMjYQ==", ""},
+		{"sing-box", "", `{"outbounds": [{"type": "direct"}]}`, ""},
+		{"broken yaml left to the parser", "", "proxies: [\n", ""},
+		{"empty", "", " \n\t", "empty response"},
+		{"html content type", "text/html; charset=utf-8", "proxies: []", "got an html page"},
+		{"html doctype", "text/plain", "  <!DOCTYPE html><p>expired</p>", "got an html page"},
+		{"html tag", "", "<HTML><body>expired</body></HTML>", "got an html page"},
+		{"json error", "application/json", `{"error": "invalid token"}`, `got a json response without proxies: "{\"error\": \"invalid token\"}"`},
+		{"other yaml", "", "mixed-port: 7890\nmode: rule\n", "no proxies or proxy-providers found"},
+	}
+	for _, tt := range tests {
+		err := checkConfigContent(tt.contentType, []byte(tt.body))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestContentSnippet(t *testing.T) {
+	if got := contentSnippet([]byte("<p>\n  a \t b\n</p>")); got != "<p> a b </p>" {
+		t.Errorf("whitespace not collapsed: %q", got)
+	}
+	long := strings.Repeat("过", snippetLength+10)
+	if got := contentSnippet([]byte(long)); got != strings.Repeat("过", snippetLength)+"..." {
+		t.Errorf("long snippet %q", got)
+	}
+}
+
+// 本地文件和订阅地址返回的非配置内容都记为来源失败，而不是 0 个节点
+func TestLoadProxiesContentFixtures(t *testing.T) {
+	fixtures := map[string]string{
+		"expired.html": "got an html page instead of a config: \"<!DOCTYPE html> <html> <head><title>订阅已过期</title>",
+		"empty.yaml":   "empty response",
+		"error.json":   "got a json response without proxies",
+	}
+	for name, want := range fixtures {
+		path := filepath.Join("testdata", "content", name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		}))
+		for _, source := range []string{path, server.URL} {
+			report, err := New(&Config{ConfigPaths: source}).LoadProxies(false)
+			if err != nil {
+				t.Fatalf("%s: %v", source, err)
+			}
+			if !strings.Contains(report.SourceError, want) || len(report.Proxies) != 0 {
+				t.Errorf("%s from %s: source error %q, want %q", name, source, report.SourceError, want)
+			}
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,49 @@
+package speedtester
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+// dedupKey 返回判断两个节点是不是同一个物理节点的标识，和 NodeKey 使用相同的字段。
+// 没有原始配置的节点只能用解析后的类型和地址，CC 扫描复制出的变体按拥塞控制算法区分
+func dedupKey(proxy *CProxy) string {
+	key := NodeKey(proxy.Config)
+	if proxy.Config == nil {
+		key = "proxy:" + strings.ToLower(proxy.Type().String()) + ";" + strings.ToLower(proxy.Addr())
+	}
+	if proxy.CongestionControl != "" {
+		key += ";cc=" + proxy.CongestionControl
+	}
+	return key
+}
+
+// DeduplicateProxies 去掉所有来源之间重复的节点，按来源顺序、来源内按名称顺序保留第一次出现的节点，
+// 返回去重后的来源和丢弃的节点数。保留的节点原样返回，Config 不做任何修改，保存的配置和原来一致
+func DeduplicateProxies(sources []map[string]*CProxy) ([]map[string]*CProxy, int) {
+	seen := make(map[string]string)
+	deduped := make([]map[string]*CProxy, 0, len(sources))
+	dropped := 0
+	for _, proxies := range sources {
+		names := make([]string, 0, len(proxies))
+		for name := range proxies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		kept := make(map[string]*CProxy, len(proxies))
+		for _, name := range names {
+			key := dedupKey(proxies[name])
+			if first, ok := seen[key]; ok {
+				log.Infoln("%s is a duplicate of %s, skip", name, first)
+				dropped++
+				continue
+			}
+			seen[key] = name
+			kept[name] = proxies[name]
+		}
+		deduped = append(deduped, kept)
+	}
+	return deduped, dropped
+}
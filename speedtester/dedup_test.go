@@ -0,0 +1,70 @@
+package speedtester
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/metacubex/mihomo/adapter"
+)
+
+func dedupProxy(t *testing.T, config map[string]any) *CProxy {
+	t.Helper()
+	proxy, err := adapter.ParseProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CProxy{Proxy: proxy, Config: config}
+}
+
+func ssConfig(name, server, password string) map[string]any {
+	return map[string]any{"name": name, "type": "ss", "server": server, "port": 443, "cipher": "aes-128-gcm", "password": password}
+}
+
+func TestDeduplicateProxies(t *testing.T) {
+	hk := ssConfig("HK 01", "hk.example.com", "p")
+	first := map[string]*CProxy{
+		"HK 01": dedupProxy(t, hk),
+		// 名称不同、服务器大小写不同，仍是同一个节点，按名称顺序保留 "HK 01"
+		"HK 02": dedupProxy(t, ssConfig("HK 02", "HK.example.com", "p")),
+		// 密码不同是另一个节点
+		"HK 03": dedupProxy(t, ssConfig("HK 03", "hk.example.com", "q")),
+	}
+	second := map[string]*CProxy{
+		"香港 A":  dedupProxy(t, ssConfig("香港 A", "hk.example.com", "p")),
+		"JP 01": dedupProxy(t, ssConfig("JP 01", "jp.example.com", "p")),
+	}
+	original := maps.Clone(hk)
+
+	deduped, dropped := DeduplicateProxies([]map[string]*CProxy{first, second})
+	if dropped != 2 || len(deduped) != 2 {
+		t.Fatalf("dropped %d, %d sources", dropped, len(deduped))
+	}
+	if got := slices.Sorted(maps.Keys(deduped[0])); !slices.Equal(got, []string{"HK 01", "HK 03"}) {
+		t.Errorf("first source kept %v", got)
+	}
+	if got := slices.Sorted(maps.Keys(deduped[1])); !slices.Equal(got, []string{"JP 01"}) {
+		t.Errorf("second source kept %v", got)
+	}
+	if kept := deduped[0]["HK 01"]; kept != first["HK 01"] || !maps.Equal(kept.Config, original) {
+		t.Errorf("kept proxy changed: %v", kept.Config)
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	a := dedupProxy(t, ssConfig("A", "a.example.com", "p"))
+	// 没有原始配置的节点按类型和地址区分
+	providerA := &CProxy{Proxy: a.Proxy}
+	providerB := &CProxy{Proxy: dedupProxy(t, ssConfig("B", "b.example.com", "p")).Proxy}
+	if dedupKey(providerA) == dedupKey(providerB) {
+		t.Error("proxies without config at different addresses share a key")
+	}
+	if dedupKey(providerA) == dedupKey(a) {
+		t.Error("proxy without config shares a key with a configured proxy")
+	}
+	// CC 扫描的变体不算重复
+	bbr := &CProxy{Proxy: a.Proxy, Config: a.Config, CongestionControl: "bbr"}
+	if dedupKey(bbr) == dedupKey(a) {
+		t.Error("congestion control variant deduplicated")
+	}
+}
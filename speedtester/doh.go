@@ -0,0 +1,292 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/miekg/dns"
+)
+
+// DoH 缓存的有效期范围，应答里没有记录（例如 NXDOMAIN）时按最短时间缓存
+const (
+	dohMinTTL = 30 * time.Second
+	dohMaxTTL = time.Hour
+)
+
+// NewDoHResolver 返回通过 DNS over HTTPS（RFC 8484，POST wireformat）查询的 net.Resolver。
+// 替换 net.DefaultResolver 后，本地发起的所有解析（下载订阅、定位服务器、获取本机 IP 等）都走 DoH，
+// 代理服务器那一端的远程解析不受影响。endpoint 本身是域名时用系统 DNS 解析，所以最好直接写 IP
+func NewDoHResolver(endpoint string) (*net.Resolver, error) {
+	c, err := newDoHClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return c.netResolver(), nil
+}
+
+// UseDoH 让本程序发起的解析和 mihomo 解析节点服务器地址都通过 endpoint 查询。
+// mihomo 拨号时不读 net.DefaultResolver，而是用 resolver.ProxyServerHostResolver，
+// 没有设置时会直接使用系统 DNS，所以两边都要替换
+func UseDoH(endpoint string) error {
+	c, err := newDoHClient(endpoint)
+	if err != nil {
+		return err
+	}
+	net.DefaultResolver = c.netResolver()
+	r := &dohResolver{client: c, lookup: c.netResolver()}
+	resolver.DefaultResolver = r
+	resolver.ProxyServerHostResolver = r
+	resolver.DirectHostResolver = r
+	return nil
+}
+
+func newDoHClient(endpoint string) (*dohClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("doh endpoint must be an http(s) url, got %q", endpoint)
+	}
+	// 解析 endpoint 不能再走 DoH，否则会无限递归
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Resolver: &net.Resolver{}}
+	return &dohClient{
+		endpoint: endpoint,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext, ForceAttemptHTTP2: true},
+		},
+		cache: make(map[string]dohEntry),
+	}, nil
+}
+
+type dohEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+type dohClient struct {
+	endpoint string
+	client   *http.Client
+	mu       sync.Mutex
+	cache    map[string]dohEntry
+}
+
+func (c *dohClient) netResolver() *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: c.dial}
+}
+
+func (c *dohClient) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	return &dohConn{ctx: ctx, client: c}, nil
+}
+
+// exchange 发送一个 DNS 查询并返回应答。缓存的 key 去掉了两字节的 ID，命中时把 ID 换成本次查询的
+func (c *dohClient) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, errors.New("doh: short dns query")
+	}
+	key := string(query[2:])
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		response := bytes.Clone(entry.response)
+		copy(response, query[:2])
+		return response, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: %s", resp.Status)
+	}
+	response, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+	if len(response) < 12 {
+		return nil, errors.New("doh: short dns response")
+	}
+	// RFC 8484 建议查询的 ID 填 0 以便缓存，服务器会原样返回，这里统一换回本次查询的 ID
+	copy(response, query[:2])
+
+	c.mu.Lock()
+	c.cache[key] = dohEntry{response: bytes.Clone(response), expires: time.Now().Add(responseTTL(response))}
+	c.mu.Unlock()
+	return response, nil
+}
+
+// dohResolver 把 dohClient 包装成 mihomo 的 resolver.Resolver，和 net.DefaultResolver 共用同一个缓存
+type dohResolver struct {
+	client *dohClient
+	lookup *net.Resolver
+}
+
+var _ resolver.Resolver = (*dohResolver)(nil)
+
+func (r *dohResolver) LookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	return r.lookupNetIP(ctx, "ip", host)
+}
+
+func (r *dohResolver) LookupIPv4(ctx context.Context, host string) ([]netip.Addr, error) {
+	return r.lookupNetIP(ctx, "ip4", host)
+}
+
+func (r *dohResolver) LookupIPv6(ctx context.Context, host string) ([]netip.Addr, error) {
+	return r.lookupNetIP(ctx, "ip6", host)
+}
+
+func (r *dohResolver) lookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, err := r.lookup.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	if len(addrs) == 0 {
+		return nil, resolver.ErrIPNotFound
+	}
+	return addrs, nil
+}
+
+// ResolveECH 只在节点配置了 ech-opts 却没有给出 config 时调用，DoH 解析器不支持查询 HTTPS 记录
+func (r *dohResolver) ResolveECH(ctx context.Context, host string) ([]byte, error) {
+	return nil, fmt.Errorf("doh: ech config lookup of %s is not supported", host)
+}
+
+func (r *dohResolver) ExchangeContext(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	query, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.client.exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(response); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (r *dohResolver) Invalid() bool {
+	return true
+}
+
+func (r *dohResolver) ClearCache() {
+	r.client.mu.Lock()
+	defer r.client.mu.Unlock()
+	clear(r.client.cache)
+}
+
+func (r *dohResolver) ResetConnection() {
+	r.client.client.CloseIdleConnections()
+}
+
+// responseTTL 返回应答中所有回答记录里最小的 TTL，限制在 dohMinTTL 和 dohMaxTTL 之间
+func responseTTL(msg []byte) time.Duration {
+	ttl := dohMaxTTL
+	qdCount := int(binary.BigEndian.Uint16(msg[4:6]))
+	anCount := int(binary.BigEndian.Uint16(msg[6:8]))
+	if anCount == 0 {
+		return dohMinTTL
+	}
+	off := 12
+	for range qdCount {
+		if off = skipName(msg, off); off < 0 || off+4 > len(msg) {
+			return dohMinTTL
+		}
+		off += 4
+	}
+	for range anCount {
+		if off = skipName(msg, off); off < 0 || off+10 > len(msg) {
+			return dohMinTTL
+		}
+		recordTTL := time.Duration(binary.BigEndian.Uint32(msg[off+4:off+8])) * time.Second
+		ttl = min(ttl, recordTTL)
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:off+10]))
+	}
+	return max(ttl, dohMinTTL)
+}
+
+// skipName 跳过 off 处的域名，返回之后的偏移，格式错误时返回 -1
+func skipName(msg []byte, off int) int {
+	for off < len(msg) {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1
+		case n&0xc0 == 0xc0:
+			// 压缩指针占两个字节，指向的内容不需要读
+			return off + 2
+		}
+		off += 1 + n
+	}
+	return -1
+}
+
+// dohConn 是交给 net.Resolver 的假连接：写入一个查询，读出对应的应答。
+// 它没有实现 net.PacketConn，所以 net.Resolver 总是按 tcp 的格式在报文前面加两字节长度
+type dohConn struct {
+	ctx      context.Context
+	client   *dohClient
+	pending  bytes.Buffer
+	response bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.pending.Write(b)
+	query := c.pending.Bytes()
+	if len(query) < 2 || len(query) < 2+int(binary.BigEndian.Uint16(query)) {
+		return len(b), nil
+	}
+	response, err := c.client.exchange(c.ctx, query[2:2+int(binary.BigEndian.Uint16(query))])
+	c.pending.Reset()
+	if err != nil {
+		return 0, err
+	}
+	c.response.Write(binary.BigEndian.AppendUint16(nil, uint16(len(response))))
+	c.response.Write(response)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.response.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.response.Read(b)
+}
+
+func (c *dohConn) Close() error                     { return nil }
+func (c *dohConn) LocalAddr() net.Addr              { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr             { return dohAddr{} }
+func (c *dohConn) SetDeadline(time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(time.Time) error { return nil }
+
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+func (dohAddr) String() string  { return "doh" }
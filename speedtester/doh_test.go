@@ -0,0 +1,269 @@
+package speedtester
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/component/resolver"
+)
+
+// dnsRecord 是罐头应答里的一条 A 或 AAAA 记录
+type dnsRecord struct {
+	ip  net.IP
+	ttl uint32
+}
+
+// parseQuestion 返回查询里第一个问题的域名（不带结尾的点）、类型和问题段结束的偏移
+func parseQuestion(t *testing.T, query []byte) (string, uint16, int) {
+	t.Helper()
+	var labels []string
+	off := 12
+	for off < len(query) && query[off] != 0 {
+		n := int(query[off])
+		labels = append(labels, string(query[off+1:off+1+n]))
+		off += 1 + n
+	}
+	off++
+	if off+4 > len(query) {
+		t.Fatalf("malformed dns query %x", query)
+	}
+	return strings.Join(labels, "."), binary.BigEndian.Uint16(query[off:]), off + 4
+}
+
+// dnsAnswer 按查询构造应答，records 里只有类型匹配的记录会写进回答段
+func dnsAnswer(t *testing.T, query []byte, rcode byte, records []dnsRecord) []byte {
+	t.Helper()
+	_, qtype, end := parseQuestion(t, query)
+	var answers [][]byte
+	for _, record := range records {
+		rdata := record.ip.To4()
+		rtype := uint16(1)
+		if rdata == nil {
+			rdata, rtype = record.ip.To16(), 28
+		}
+		if rtype != qtype {
+			continue
+		}
+		// 0xc00c 是指向问题段域名的压缩指针
+		rr := []byte{0xc0, 0x0c}
+		rr = binary.BigEndian.AppendUint16(rr, rtype)
+		rr = binary.BigEndian.AppendUint16(rr, 1)
+		rr = binary.BigEndian.AppendUint32(rr, record.ttl)
+		rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+		answers = append(answers, append(rr, rdata...))
+	}
+	msg := make([]byte, 12, 512)
+	copy(msg, query[:2])
+	msg[2] = 0x81 // QR、RD
+	msg[3] = 0x80 | rcode
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	msg = append(msg, query[12:end]...)
+	for _, answer := range answers {
+		msg = append(msg, answer...)
+	}
+	return msg
+}
+
+// dohServer 返回按 zone 应答的 DoH 服务器和收到的请求数，zone 中没有的域名返回 NXDOMAIN
+func dohServer(t *testing.T, zone map[string][]dnsRecord) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		name, _, _ := parseQuestion(t, query)
+		records, ok := zone[strings.ToLower(name)]
+		rcode := byte(0)
+		if !ok {
+			rcode = 3
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(t, query, rcode, records))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestDoHResolver(t *testing.T) {
+	server, requests := dohServer(t, map[string][]dnsRecord{
+		"speed.example.test": {{net.ParseIP("203.0.113.7"), 300}, {net.ParseIP("2001:db8::7"), 300}},
+	})
+	resolver, err := NewDoHResolver(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	addrs, err := resolver.LookupHost(ctx, "speed.example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(addrs)
+	if want := []string{"2001:db8::7", "203.0.113.7"}; !slices.Equal(addrs, want) {
+		t.Errorf("LookupHost = %v, want %v", addrs, want)
+	}
+
+	// 第二次查询命中缓存，不再请求服务器
+	sent := requests.Load()
+	if sent == 0 {
+		t.Fatal("doh server never queried")
+	}
+	if _, err := resolver.LookupHost(ctx, "speed.example.test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests.Load(); got != sent {
+		t.Errorf("cached lookup sent %d more requests", got-sent)
+	}
+
+	_, err = resolver.LookupHost(ctx, "missing.example.test")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("lookup of missing host: %v, want not found", err)
+	}
+}
+
+func TestDoHResolverServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream down", http.StatusBadGateway)
+	}))
+	t.Cleanup(server.Close)
+	resolver, err := NewDoHResolver(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if addrs, err := resolver.LookupHost(ctx, "speed.example.test"); err == nil {
+		t.Errorf("LookupHost through a failing doh server = %v", addrs)
+	}
+}
+
+func TestDoHExchangeCache(t *testing.T) {
+	server, requests := dohServer(t, map[string][]dnsRecord{
+		"a.example.test": {{net.ParseIP("203.0.113.1"), 60}},
+	})
+	c := &dohClient{endpoint: server.URL, client: server.Client(), cache: make(map[string]dohEntry)}
+	query := func(id uint16) []byte {
+		q := binary.BigEndian.AppendUint16(nil, id)
+		q = append(q, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0)
+		for _, label := range []string{"a", "example", "test"} {
+			q = append(append(q, byte(len(label))), label...)
+		}
+		return append(q, 0, 0, 1, 0, 1)
+	}
+
+	first, err := c.exchange(context.Background(), query(0x1234))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 缓存不区分 ID，命中时换成本次查询的 ID
+	second, err := c.exchange(context.Background(), query(0xabcd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("got %d requests, want 1", requests.Load())
+	}
+	if binary.BigEndian.Uint16(first) != 0x1234 || binary.BigEndian.Uint16(second) != 0xabcd {
+		t.Errorf("response ids %x %x", first[:2], second[:2])
+	}
+	if string(first[2:]) != string(second[2:]) {
+		t.Error("cached response differs from the original")
+	}
+
+	// 过期后重新请求
+	for key, entry := range c.cache {
+		entry.expires = time.Now().Add(-time.Second)
+		c.cache[key] = entry
+	}
+	if _, err := c.exchange(context.Background(), query(1)); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expired entry not refreshed, got %d requests", requests.Load())
+	}
+
+	if _, err := c.exchange(context.Background(), []byte{1, 2, 3}); err == nil {
+		t.Error("short query accepted")
+	}
+}
+
+func TestResponseTTL(t *testing.T) {
+	query := []byte{0, 1, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 1, 'a', 4, 't', 'e', 's', 't', 0, 0, 1, 0, 1}
+	tests := []struct {
+		name    string
+		records []dnsRecord
+		rcode   byte
+		want    time.Duration
+	}{
+		{"minimum of answers", []dnsRecord{{net.ParseIP("203.0.113.1"), 600}, {net.ParseIP("203.0.113.2"), 120}}, 0, 120 * time.Second},
+		{"clamped up", []dnsRecord{{net.ParseIP("203.0.113.1"), 5}}, 0, dohMinTTL},
+		{"clamped down", []dnsRecord{{net.ParseIP("203.0.113.1"), 86400}}, 0, dohMaxTTL},
+		{"nxdomain", nil, 3, dohMinTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := responseTTL(dnsAnswer(t, query, tt.rcode, tt.records)); got != tt.want {
+				t.Errorf("responseTTL = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// 回答数和实际内容不符时按最短时间缓存
+	truncated := dnsAnswer(t, query, 0, []dnsRecord{{net.ParseIP("203.0.113.1"), 600}})
+	if got := responseTTL(truncated[:len(truncated)-8]); got != dohMinTTL {
+		t.Errorf("responseTTL of truncated response = %s, want %s", got, dohMinTTL)
+	}
+}
+
+func TestNewDoHResolverInvalid(t *testing.T) {
+	for _, endpoint := range []string{"1.1.1.1", "tls://1.1.1.1", "https://", "://bad"} {
+		if _, err := NewDoHResolver(endpoint); err == nil {
+			t.Errorf("NewDoHResolver(%q) accepted", endpoint)
+		}
+	}
+}
+
+// UseDoH 之后 mihomo 解析节点服务器地址也走 DoH
+func TestUseDoH(t *testing.T) {
+	server, requests := dohServer(t, map[string][]dnsRecord{
+		"node.example.test": {{net.ParseIP("203.0.113.9"), 300}},
+	})
+	defaultResolver, proxyResolver, directResolver, netResolver := resolver.DefaultResolver, resolver.ProxyServerHostResolver, resolver.DirectHostResolver, net.DefaultResolver
+	t.Cleanup(func() {
+		resolver.DefaultResolver, resolver.ProxyServerHostResolver, resolver.DirectHostResolver, net.DefaultResolver = defaultResolver, proxyResolver, directResolver, netResolver
+	})
+	if err := UseDoH(server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := resolver.ResolveIPv4WithResolver(context.Background(), "node.example.test", resolver.ProxyServerHostResolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != netip.MustParseAddr("203.0.113.9") {
+		t.Errorf("resolved %s, want 203.0.113.9", addr)
+	}
+	if requests.Load() == 0 {
+		t.Error("proxy server host was not resolved through the doh server")
+	}
+	if _, err := resolver.ProxyServerHostResolver.LookupIP(context.Background(), "missing.example.test"); err == nil {
+		t.Error("lookup of a missing host succeeded")
+	}
+}
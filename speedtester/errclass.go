@@ -0,0 +1,103 @@
+package speedtester
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// 失败原因的分类：前几类说明节点配置或节点本身有问题，target-blocked 说明节点能用但出口访问不了目标
+const (
+	ErrorClassDialTimeout   = "dial-timeout"
+	ErrorClassRefused       = "connection-refused"
+	ErrorClassAuthFailed    = "auth-failed"
+	ErrorClassTLS           = "tls-error"
+	ErrorClassReset         = "reset-by-peer"
+	ErrorClassDNS           = "dns-failure"
+	ErrorClassTargetBlocked = "target-blocked"
+	ErrorClassUnknown       = "unknown"
+)
+
+// ErrorClassUploadBlocked 表示下载正常但所有上传连接都失败，多半是节点屏蔽了发往不常见地址的 POST 请求体。
+// 节点的其他测试结果照常保留
+const ErrorClassUploadBlocked = "upload-blocked"
+
+// StatusError 表示经过代理拿到了响应，但状态码不对
+type StatusError struct {
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+var authFailureMessages = []string{
+	"unable to authenticate", "authentication failed", "auth failed", "invalid user",
+	"wrong password", "invalid password", "407 proxy authentication required", "401 unauthorized",
+}
+
+var targetFailureMessages = []string{
+	"host unreachable", "network unreachable", "ttl expired", "connection not allowed by ruleset",
+	"502 bad gateway", "503 service unavailable", "504 gateway timeout",
+}
+
+// ClassifyError 根据代理拨号和 HTTP 请求返回的错误链判断失败原因
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := strings.ToLower(err.Error())
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return ErrorClassTargetBlocked
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || strings.Contains(msg, "no such host") {
+		return ErrorClassDNS
+	}
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	if errors.As(err, &alertErr) || errors.As(err, &recordErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &certErr) || strings.Contains(msg, "tls:") {
+		return ErrorClassTLS
+	}
+	for _, m := range authFailureMessages {
+		if strings.Contains(msg, m) {
+			return ErrorClassAuthFailed
+		}
+	}
+	for _, m := range targetFailureMessages {
+		if strings.Contains(msg, m) {
+			return ErrorClassTargetBlocked
+		}
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(msg, "connection reset") || strings.HasSuffix(msg, "eof") {
+		return ErrorClassReset
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(msg, "connection refused") {
+		return ErrorClassRefused
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) || strings.Contains(msg, "timeout") {
+		return ErrorClassDialTimeout
+	}
+	return ErrorClassUnknown
+}
+
+// newStatusError 用于延迟探测拿到非 200 响应的情况
+func newStatusError(status string) error {
+	return fmt.Errorf("latency probe: %w", &StatusError{Status: status})
+}
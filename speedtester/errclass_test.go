@@ -0,0 +1,67 @@
+package speedtester
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	opErr := func(op string, err error) error {
+		return &net.OpError{Op: op, Net: "tcp", Err: err}
+	}
+	// 模拟 http.Client 包装后的错误链
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://speed.cloudflare.com/__down", Err: err}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"dial timeout", urlErr(opErr("dial", os.ErrDeadlineExceeded)), ErrorClassDialTimeout},
+		{"context deadline", fmt.Errorf("dial: %w", context.DeadlineExceeded), ErrorClassDialTimeout},
+		{"client timeout message", errors.New("Client.Timeout exceeded while awaiting headers"), ErrorClassDialTimeout},
+		{"refused", urlErr(opErr("dial", os.NewSyscallError("connect", syscall.ECONNREFUSED))), ErrorClassRefused},
+		{"refused message", errors.New("dial tcp 1.2.3.4:443: connect: connection refused"), ErrorClassRefused},
+		{"reset", urlErr(opErr("read", os.NewSyscallError("read", syscall.ECONNRESET))), ErrorClassReset},
+		{"eof", urlErr(io.EOF), ErrorClassReset},
+		{"unexpected eof", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), ErrorClassReset},
+		{"eof message", errors.New("vmess: read response header: EOF"), ErrorClassReset},
+		{"dns error", urlErr(opErr("dial", &net.DNSError{Err: "no such host", Name: "node.example.com"})), ErrorClassDNS},
+		{"dns message", errors.New("lookup node.example.com: no such host"), ErrorClassDNS},
+		{"tls alert", urlErr(tls.AlertError(40)), ErrorClassTLS},
+		{"tls record header", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ErrorClassTLS},
+		{"unknown authority", urlErr(x509.UnknownAuthorityError{}), ErrorClassTLS},
+		{"hostname mismatch", urlErr(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}), ErrorClassTLS},
+		{"expired certificate", x509.CertificateInvalidError{Reason: x509.Expired}, ErrorClassTLS},
+		{"tls message", errors.New("remote error: tls: handshake failure"), ErrorClassTLS},
+		{"ssh auth", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password]"), ErrorClassAuthFailed},
+		{"socks auth", errors.New("socks5 authentication failed"), ErrorClassAuthFailed},
+		{"http proxy auth", errors.New("HTTP 407 Proxy Authentication Required"), ErrorClassAuthFailed},
+		{"trojan password", errors.New("trojan: invalid password"), ErrorClassAuthFailed},
+		{"status error", newStatusError("403 Forbidden"), ErrorClassTargetBlocked},
+		{"bad gateway", errors.New("unexpected status 502 Bad Gateway"), ErrorClassTargetBlocked},
+		{"host unreachable", errors.New("socks5 reply: host unreachable"), ErrorClassTargetBlocked},
+		{"ruleset", errors.New("connection not allowed by ruleset"), ErrorClassTargetBlocked},
+		// 504 的文字里有 timeout，但说明节点已经连上，是出口访问不了目标
+		{"gateway timeout", errors.New("unexpected status 504 Gateway Timeout"), ErrorClassTargetBlocked},
+		{"unknown", errors.New("something odd happened"), ErrorClassUnknown},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ClassifyError(tc.err); got != tc.want {
+				t.Errorf("ClassifyError(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package speedtester
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer 返回 status 和 bodySize 字节的响应体，并统计建立过的连接数
+func countingServer(t *testing.T, status, bodySize int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	body := strings.Repeat("x", bodySize)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	conns := &atomic.Int64{}
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, conns
+}
+
+// TestFetchExtraURLDrainsErrors 请求几百次返回 403 的地址，响应体读完后连接应该一直复用，goroutine 数不增长
+func TestFetchExtraURLDrainsErrors(t *testing.T) {
+	server, conns := countingServer(t, http.StatusForbidden, 16*1024)
+	st := New(&Config{})
+	client := st.createClient(directProxy(t), 5*time.Second)
+	before := runtime.NumGoroutine()
+	for i := range 300 {
+		_, _, _, ok, err := fetchExtraURL(context.Background(), client, server.URL)
+		if err != nil || ok {
+			t.Fatalf("attempt %d: ok %v, err %v", i, ok, err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("300 attempts opened %d connections, want 1 reused connection", n)
+	}
+	client.CloseIdleConnections()
+	// 关闭的连接对应的 goroutine 退出需要一点时间
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before+2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before+2 {
+		t.Errorf("goroutines grew from %d to %d", before, after)
+	}
+}
+
+// TestFetchExtraURLEndlessErrorBody 错误响应最多读 maxDrainBytes，不会一直读一个没有尽头的响应体
+func TestFetchExtraURLEndlessErrorBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		chunk := []byte(strings.Repeat("x", 32*1024))
+		for r.Context().Err() == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := New(&Config{}).createClient(directProxy(t), 10*time.Second)
+	defer client.CloseIdleConnections()
+	start := time.Now()
+	if _, _, _, ok, err := fetchExtraURL(context.Background(), client, server.URL); ok || err != nil {
+		t.Fatalf("ok %v, err %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("draining an endless error body took %s", elapsed)
+	}
+}
+
+func TestTestExtraLatencyAndSpeed(t *testing.T) {
+	ok, okConns := countingServer(t, http.StatusOK, 32*1024)
+	forbidden, _ := countingServer(t, http.StatusForbidden, 1024)
+
+	st := New(&Config{ExtraConnectURL: []string{ok.URL}})
+	latency, open, _ := st.testExtraLatencyAndSpeed(context.Background(), directProxy(t), 5*time.Second)
+	if result := latency[ok.URL]; result == nil || result.packetLoss != 0 || result.avgLatency <= 0 {
+		t.Fatalf("latency result = %+v", result)
+	}
+	if open == nil || open.bytes != 6*32*1024 {
+		t.Errorf("open result = %+v, want 6 reads of 32KiB", open)
+	}
+	if n := okConns.Load(); n != 1 {
+		t.Errorf("6 attempts opened %d connections, want 1", n)
+	}
+
+	st = New(&Config{ExtraConnectURL: []string{forbidden.URL, ok.URL}})
+	latency, open, _ = st.testExtraLatencyAndSpeed(context.Background(), directProxy(t), 5*time.Second)
+	if result := latency[forbidden.URL]; result == nil || result.packetLoss != 100 {
+		t.Errorf("403 url result = %+v, want 100%% loss", result)
+	}
+	if _, tested := latency[ok.URL]; tested || open != nil {
+		t.Errorf("kept testing after the first url failed")
+	}
+}
+
+// pacedServer 先等 headerDelay 再发响应头，之后每隔 interval 发一块 chunkSize 字节，共 chunks 块
+func pacedServer(t *testing.T, headerDelay, interval time.Duration, chunks, chunkSize int) *httptest.Server {
+	t.Helper()
+	chunk := []byte(strings.Repeat("x", chunkSize))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(headerDelay)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for range chunks {
+			time.Sleep(interval)
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestFetchExtraURLReadDuration 响应头前的等待只算进延迟，读响应体的时间才用来算打开速度
+func TestFetchExtraURLReadDuration(t *testing.T) {
+	const (
+		headerDelay = 300 * time.Millisecond
+		interval    = 25 * time.Millisecond
+		chunks      = 8
+		chunkSize   = 32 * 1024
+	)
+	server := pacedServer(t, headerDelay, interval, chunks, chunkSize)
+	client := New(&Config{}).createClient(directProxy(t), 5*time.Second)
+	defer client.CloseIdleConnections()
+
+	latency, downloadBytes, readDuration, ok, err := fetchExtraURL(context.Background(), client, server.URL)
+	if err != nil || !ok {
+		t.Fatalf("ok %v, err %v", ok, err)
+	}
+	if latency < headerDelay {
+		t.Errorf("latency %s shorter than the %s header delay", latency, headerDelay)
+	}
+	if downloadBytes != chunks*chunkSize {
+		t.Errorf("read %d bytes, want %d", downloadBytes, chunks*chunkSize)
+	}
+	// 真实吞吐是 256KiB / 200ms，把响应头前的 300ms 算进去会少一半以上
+	want := float64(chunks*chunkSize) / (chunks * interval).Seconds()
+	got := float64(downloadBytes) / readDuration.Seconds()
+	if got < want*0.7 || got > want*1.1 {
+		t.Errorf("open speed %.0f B/s over %s, want within tolerance of %.0f B/s", got, readDuration, want)
+	}
+}
+
+// TestFetchExtraURLPartialBody 读到一半连接断开时，已经读到的字节和花的时间仍然算数
+func TestFetchExtraURLPartialBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "65536")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strings.Repeat("x", 16*1024)))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := New(&Config{}).createClient(directProxy(t), 5*time.Second)
+	defer client.CloseIdleConnections()
+
+	_, downloadBytes, readDuration, ok, err := fetchExtraURL(context.Background(), client, server.URL)
+	if err != nil || !ok {
+		t.Fatalf("ok %v, err %v", ok, err)
+	}
+	if downloadBytes != 16*1024 || readDuration < 50*time.Millisecond {
+		t.Errorf("partial body: %d bytes in %s, want 16384 bytes in at least 50ms", downloadBytes, readDuration)
+	}
+}
+
+// TestTestExtraLatencyAndSpeedOpenSpeed 多次探测汇总的打开速度不包含响应头前的等待和探测之间的间隔
+func TestTestExtraLatencyAndSpeedOpenSpeed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("six paced probes take about 3s")
+	}
+	const (
+		interval  = 20 * time.Millisecond
+		chunks    = 5
+		chunkSize = 16 * 1024
+	)
+	server := pacedServer(t, 200*time.Millisecond, interval, chunks, chunkSize)
+	st := New(&Config{ExtraConnectURL: []string{server.URL}})
+	_, open, _ := st.testExtraLatencyAndSpeed(context.Background(), directProxy(t), 5*time.Second)
+	if open == nil || open.bytes != 6*chunks*chunkSize {
+		t.Fatalf("open result = %+v, want 6 reads of %d bytes", open, chunks*chunkSize)
+	}
+	want := float64(chunks*chunkSize) / (chunks * interval).Seconds()
+	got := float64(open.bytes) / open.duration.Seconds()
+	if got < want*0.7 || got > want*1.1 {
+		t.Errorf("open speed %.0f B/s over %s, want within tolerance of %.0f B/s", got, open.duration, want)
+	}
+}
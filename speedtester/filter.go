@@ -0,0 +1,217 @@
+package speedtester
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FilterInclude = "include"
+	FilterExclude = "exclude"
+)
+
+// FilterRule 是 -filter-file 中的一条规则，例如
+//
+//	rules:
+//	  - {action: exclude, field: name, op: regex, value: "到期|流量"}
+//	  - {action: include, field: type, op: in, value: [hysteria2, tuic]}
+//	  - {action: exclude, field: port, op: range, value: 1-1024}
+type FilterRule struct {
+	Action string `yaml:"action"`
+	Field  string `yaml:"field"`
+	Op     string `yaml:"op"`
+	Value  any    `yaml:"value"`
+
+	re       *regexp.Regexp
+	values   []string
+	min, max int
+}
+
+// FilterSet 按顺序匹配规则，第一条命中的规则决定节点去留，都不命中时使用 Default
+type FilterSet struct {
+	Default string        `yaml:"default"`
+	Rules   []*FilterRule `yaml:"rules"`
+}
+
+func LoadFilterSet(path string) (*FilterSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := ParseFilterSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return set, nil
+}
+
+func ParseFilterSet(data []byte) (*FilterSet, error) {
+	set := &FilterSet{}
+	if err := yaml.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	switch set.Default {
+	case "":
+		set.Default = FilterInclude
+	case FilterInclude, FilterExclude:
+	default:
+		return nil, fmt.Errorf("default: unknown action %q, use include or exclude", set.Default)
+	}
+	for i, rule := range set.Rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return set, nil
+}
+
+func (r *FilterRule) compile() error {
+	if r.Action != FilterInclude && r.Action != FilterExclude {
+		return fmt.Errorf("unknown action %q, use include or exclude", r.Action)
+	}
+	switch r.Field {
+	case "name", "server", "type", "port":
+	default:
+		return fmt.Errorf("unknown field %q, use name, server, type or port", r.Field)
+	}
+	if r.Value == nil {
+		return fmt.Errorf("value is required")
+	}
+
+	switch r.Op {
+	case "regex":
+		re, err := regexp.Compile(toString(r.Value))
+		if err != nil {
+			return err
+		}
+		r.re = re
+	case "equals":
+		if _, ok := r.Value.([]any); ok {
+			return fmt.Errorf("op equals needs a single value, use op in for a list")
+		}
+		r.values = []string{toString(r.Value)}
+	case "in":
+		items, ok := r.Value.([]any)
+		if !ok {
+			return fmt.Errorf("op in needs a list value")
+		}
+		for _, item := range items {
+			r.values = append(r.values, toString(item))
+		}
+	case "range":
+		if r.Field != "port" {
+			return fmt.Errorf("op range only supports field port")
+		}
+		min, max, err := parseRange(r.Value)
+		if err != nil {
+			return err
+		}
+		r.min, r.max = min, max
+	default:
+		return fmt.Errorf("unknown op %q, use regex, equals, in or range", r.Op)
+	}
+	if r.Field == "type" {
+		for i, v := range r.values {
+			r.values[i] = strings.ToLower(v)
+		}
+	}
+	return nil
+}
+
+// parseRange 支持 "1000-2000" 和 [1000, 2000] 两种写法，两端都包含在内
+func parseRange(v any) (int, int, error) {
+	var lo, hi string
+	switch v := v.(type) {
+	case []any:
+		if len(v) != 2 {
+			return 0, 0, fmt.Errorf("range needs exactly 2 values")
+		}
+		lo, hi = toString(v[0]), toString(v[1])
+	default:
+		var ok bool
+		if lo, hi, ok = strings.Cut(toString(v), "-"); !ok {
+			return 0, 0, fmt.Errorf("invalid range %q, use min-max", toString(v))
+		}
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range start %q", lo)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range end %q", hi)
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("range start %d is greater than end %d", min, max)
+	}
+	return min, max, nil
+}
+
+func (r *FilterRule) match(fields map[string]string) bool {
+	value := fields[r.Field]
+	switch r.Op {
+	case "regex":
+		return r.re.MatchString(value)
+	case "equals", "in":
+		for _, v := range r.values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	case "range":
+		port, err := strconv.Atoi(value)
+		return err == nil && port >= r.min && port <= r.max
+	}
+	return false
+}
+
+func (r *FilterRule) String() string {
+	value := toString(r.Value)
+	if items, ok := r.Value.([]any); ok {
+		parts := make([]string, 0, len(items))
+		for _, item := range items {
+			parts = append(parts, toString(item))
+		}
+		value = "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprintf("%s %s %s %q", r.Action, r.Field, r.Op, value)
+}
+
+// Decide 返回节点是否保留以及决定结果的规则序号（从 1 开始），0 表示使用了默认动作。
+// type 按配置里的写法（ss、ssr）匹配，没有原始配置时才用 proxyType（Shadowsocks、ShadowsocksR）
+func (s *FilterSet) Decide(name, proxyType string, config map[string]any) (bool, int) {
+	if configType := toString(config["type"]); configType != "" {
+		proxyType = configType
+	}
+	fields := map[string]string{
+		"name":   name,
+		"server": toString(config["server"]),
+		"type":   strings.ToLower(proxyType),
+		"port":   toString(config["port"]),
+	}
+	for i, rule := range s.Rules {
+		if rule.match(fields) {
+			return rule.Action == FilterInclude, i + 1
+		}
+	}
+	return s.Default == FilterInclude, 0
+}
+
+// Explain 描述是哪条规则决定了节点的去留
+func (s *FilterSet) Explain(name, proxyType string, config map[string]any) string {
+	include, index := s.Decide(name, proxyType, config)
+	action := FilterExclude
+	if include {
+		action = FilterInclude
+	}
+	if index == 0 {
+		return fmt.Sprintf("%s by default (no rule matched)", action)
+	}
+	return fmt.Sprintf("%s by rule %d: %s", action, index, s.Rules[index-1])
+}
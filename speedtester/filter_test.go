@@ -0,0 +1,131 @@
+package speedtester
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFilterSetErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec string
+		err  string
+	}{
+		{"bad yaml", "rules: [", "yaml"},
+		{"unknown default", "default: drop", `default: unknown action "drop"`},
+		{"unknown action", "rules:\n  - {action: keep, field: name, op: regex, value: HK}", `rule 1: unknown action "keep"`},
+		{"unknown field", "rules:\n  - {action: include, field: name, op: regex, value: HK}\n  - {action: exclude, field: country, op: equals, value: RU}", `rule 2: unknown field "country"`},
+		{"missing value", "rules:\n  - {action: include, field: name, op: regex}", "rule 1: value is required"},
+		{"invalid regex", "rules:\n  - {action: include, field: name, op: regex, value: '(HK'}", "rule 1: error parsing regexp"},
+		{"equals with list", "rules:\n  - {action: include, field: type, op: equals, value: [ss, vmess]}", "rule 1: op equals needs a single value"},
+		{"in without list", "rules:\n  - {action: include, field: type, op: in, value: ss}", "rule 1: op in needs a list value"},
+		{"range on name", "rules:\n  - {action: include, field: name, op: range, value: 1-2}", "rule 1: op range only supports field port"},
+		{"range without dash", "rules:\n  - {action: include, field: port, op: range, value: 443}", `rule 1: invalid range "443"`},
+		{"range reversed", "rules:\n  - {action: include, field: port, op: range, value: 2000-1000}", "rule 1: range start 2000 is greater than end 1000"},
+		{"range list length", "rules:\n  - {action: include, field: port, op: range, value: [1, 2, 3]}", "rule 1: range needs exactly 2 values"},
+		{"range not a number", "rules:\n  - {action: include, field: port, op: range, value: a-2}", `rule 1: invalid range start "a"`},
+		{"unknown op", "rules:\n  - {action: include, field: name, op: contains, value: HK}", `rule 1: unknown op "contains"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseFilterSet([]byte(tc.spec))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestFilterSetDecide(t *testing.T) {
+	set, err := ParseFilterSet([]byte(`default: exclude
+rules:
+  - {action: exclude, field: name, op: regex, value: "到期|流量"}
+  - {action: exclude, field: server, op: regex, value: '\.ru$'}
+  - {action: include, field: type, op: in, value: [Hysteria2, tuic]}
+  - {action: exclude, field: port, op: range, value: [1, 1024]}
+  - {action: include, field: server, op: equals, value: 1.1.1.1}
+  - {action: include, field: port, op: range, value: 8000-9000}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		typ     string
+		server  string
+		port    any
+		include bool
+		rule    int
+	}{
+		{"剩余流量 10G", "Hysteria2", "hk.example.com", 443, false, 1},
+		{"RU 01", "Tuic", "node.example.ru", 443, false, 2},
+		{"HK hy2", "Hysteria2", "hk.example.com", 443, true, 3},
+		{"HK tuic", "Tuic", "hk.example.com", 80, true, 3},
+		{"HK ss low port", "Shadowsocks", "1.1.1.1", 443, false, 4},
+		{"HK ss", "Shadowsocks", "1.1.1.1", 10443, true, 5},
+		{"JP ss port string", "Shadowsocks", "jp.example.com", "8388", true, 6},
+		{"JP ss range end", "Shadowsocks", "jp.example.com", 9000, true, 6},
+		{"US vmess", "Vmess", "us.example.com", 9001, false, 0},
+	} {
+		config := map[string]any{"server": tc.server, "port": tc.port}
+		include, rule := set.Decide(tc.name, tc.typ, config)
+		if include != tc.include || rule != tc.rule {
+			t.Errorf("%s: Decide = %v by rule %d, want %v by rule %d", tc.name, include, rule, tc.include, tc.rule)
+		}
+	}
+
+	empty, err := ParseFilterSet([]byte("rules: []"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if include, rule := empty.Decide("any", "Vmess", map[string]any{}); !include || rule != 0 {
+		t.Errorf("empty set with default include: %v by rule %d", include, rule)
+	}
+}
+
+func TestFilterSetExplain(t *testing.T) {
+	set, err := ParseFilterSet([]byte(`rules:
+  - {action: exclude, field: type, op: in, value: [ssr, snell]}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]any{"type": "ssr", "server": "1.1.1.1", "port": 443}
+	if got, want := set.Explain("HK 01", "ShadowsocksR", config), `exclude by rule 1: exclude type in "[ssr, snell]"`; got != want {
+		t.Errorf("Explain = %q, want %q", got, want)
+	}
+	if got, want := set.Explain("HK 02", "Vmess", map[string]any{"type": "vmess"}), "include by default (no rule matched)"; got != want {
+		t.Errorf("Explain = %q, want %q", got, want)
+	}
+}
+
+func TestLoadProxiesFilterSet(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: HK 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: HK 02, type: ss, server: hk.example.ru, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: HK 03, type: ss, server: 1.1.1.3, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: HK 04, type: vmess, server: 1.1.1.4, port: 443, uuid: b831381d-6324-4d53-ad4f-8cda48b30811, alterId: 0, cipher: auto}
+`)
+	set, err := ParseFilterSet([]byte(`rules:
+  - {action: exclude, field: server, op: regex, value: '\.ru$'}
+  - {action: exclude, field: name, op: equals, value: HK 03}
+  - {action: exclude, field: type, op: equals, value: ss}
+  - {action: include, field: type, op: equals, value: vmess}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := New(&Config{ConfigPaths: path, Filter: set, ExplainFilter: "HK 02"}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Proxies) != 1 || report.Proxies["HK 04"] == nil {
+		t.Errorf("kept %d proxies, want only HK 04", len(report.Proxies))
+	}
+	if report.FilteredOut != 3 {
+		t.Errorf("FilteredOut = %d, want 3", report.FilteredOut)
+	}
+	explained := strings.Join(report.Explanations, "\n")
+	if !strings.Contains(explained, `HK 02: exclude by rule 1: exclude server regex "\\.ru$"`) || strings.Contains(explained, "HK 03") {
+		t.Errorf("Explanations = %q", report.Explanations)
+	}
+}
@@ -0,0 +1,426 @@
+package speedtester
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+// GeoInfo 是出口 IP 的地理位置和归属 AS 信息
+type GeoInfo struct {
+	Country     string `json:"country"`
+	CountryCode string `json:"country_code"`
+	City        string `json:"city,omitempty"`
+	ASN         int    `json:"asn"`
+	ASOrg       string `json:"as_org"`
+}
+
+// GeoResolver 根据 IP 查询地理位置和 ASN，国家和 ASN 共用同一个查询结果
+type GeoResolver interface {
+	Lookup(ctx context.Context, ip string) (*GeoInfo, error)
+}
+
+// cachedResolver 按 IP 缓存查询结果，同一台服务器上的多个节点只查询一次
+type cachedResolver struct {
+	resolver GeoResolver
+	mu       sync.Mutex
+	cache    map[string]*GeoInfo
+}
+
+func NewCachedResolver(resolver GeoResolver) GeoResolver {
+	return &cachedResolver{
+		resolver: resolver,
+		cache:    make(map[string]*GeoInfo),
+	}
+}
+
+func (r *cachedResolver) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	r.mu.Lock()
+	info, ok := r.cache[ip]
+	r.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+	info, err := r.resolver.Lookup(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cache[ip] = info
+	r.mu.Unlock()
+	return info, nil
+}
+
+const (
+	// ipAPIFreeInterval 是 ip-api.com 免费接口两次查询的最小间隔，限制是每分钟 45 次
+	ipAPIFreeInterval = time.Minute / 45
+	// ipAPIBatchInterval 是批量接口两次请求的最小间隔，限制是每分钟 15 次，每次最多 ipAPIBatchSize 个 IP
+	ipAPIBatchInterval = time.Minute / 15
+	ipAPIBatchSize     = 100
+	// ipAPIBatchWindow 是有查询到达后等待更多查询凑成一批的时间
+	ipAPIBatchWindow = 200 * time.Millisecond
+)
+
+const ipAPIFields = "status,message,query,country,countryCode,city,as,asname,org"
+
+// ipAPIResolver 使用 ip-api.com 的免费接口查询，超过频率限制会被封 IP。
+// 有其他查询正在进行时，新的查询会等待 ipAPIBatchWindow 凑成一批通过批量接口发送，
+// 只有一个 IP、批量请求失败或者结果里缺少某个 IP 时再单独查询
+type ipAPIResolver struct {
+	client    *http.Client
+	singleURL string
+	batchURL  string
+
+	mu   sync.Mutex
+	next time.Time
+	// pending 是等待批量查询的 IP，flushing 表示已经有 goroutine 负责发送它们
+	pending   []*ipAPIWaiter
+	flushing  bool
+	batchNext time.Time
+	// active 是正在进行的 Lookup 数
+	active int
+}
+
+type ipAPIWaiter struct {
+	ctx  context.Context
+	ip   string
+	done chan ipAPIAnswer
+}
+
+// ipAPIAnswer 是批量查询里一个 IP 的结果，fallback 表示没有得到结果，需要单独查询
+type ipAPIAnswer struct {
+	info     *GeoInfo
+	err      error
+	fallback bool
+}
+
+func NewIPAPIResolver() GeoResolver {
+	return &ipAPIResolver{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		singleURL: "http://ip-api.com/json/",
+		batchURL:  "http://ip-api.com/batch",
+	}
+}
+
+// wait 预约下一个查询时间并等到那个时候，ctx 先被取消时返回错误
+func (r *ipAPIResolver) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(ipAPIFreeInterval)
+	r.mu.Unlock()
+	if !sleepContext(ctx, at.Sub(now)) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+type ipAPIResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Query       string `json:"query"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"`
+	City        string `json:"city"`
+	AS          string `json:"as"`
+	ASName      string `json:"asname"`
+	Org         string `json:"org"`
+}
+
+func (r *ipAPIResolver) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	r.mu.Lock()
+	alone := r.active == 0
+	r.active++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.active--
+		r.mu.Unlock()
+	}()
+	// 没有其他查询在进行时（例如 -node-concurrent 1）凑不成批，不用等 ipAPIBatchWindow
+	if alone {
+		return r.lookupSingle(ctx, ip)
+	}
+
+	waiter := &ipAPIWaiter{ctx: ctx, ip: ip, done: make(chan ipAPIAnswer, 1)}
+	r.mu.Lock()
+	r.pending = append(r.pending, waiter)
+	if !r.flushing {
+		r.flushing = true
+		go r.flushLoop()
+	}
+	r.mu.Unlock()
+
+	select {
+	case answer := <-waiter.done:
+		if answer.fallback {
+			return r.lookupSingle(ctx, ip)
+		}
+		return answer.info, answer.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flushLoop 把等待中的查询分批发送，没有等待的查询时退出
+func (r *ipAPIResolver) flushLoop() {
+	for {
+		time.Sleep(ipAPIBatchWindow)
+		r.mu.Lock()
+		r.dropCanceled()
+		switch len(r.pending) {
+		case 0:
+			r.flushing = false
+			r.mu.Unlock()
+			return
+		case 1:
+			// 只有一个 IP 时单独查询的频率限制更宽松
+			waiter := r.pending[0]
+			r.pending = nil
+			r.mu.Unlock()
+			waiter.done <- ipAPIAnswer{fallback: true}
+			continue
+		}
+		wait := time.Until(r.batchNext)
+		r.mu.Unlock()
+		time.Sleep(wait)
+
+		r.mu.Lock()
+		r.dropCanceled()
+		n := min(len(r.pending), ipAPIBatchSize)
+		batch := r.pending[:n]
+		r.pending = append([]*ipAPIWaiter(nil), r.pending[n:]...)
+		r.batchNext = time.Now().Add(ipAPIBatchInterval)
+		r.mu.Unlock()
+		if n > 0 {
+			r.sendBatch(batch)
+		}
+	}
+}
+
+// dropCanceled 去掉已经放弃等待的查询，调用时需要持有 r.mu
+func (r *ipAPIResolver) dropCanceled() {
+	r.pending = slices.DeleteFunc(r.pending, func(waiter *ipAPIWaiter) bool {
+		return waiter.ctx.Err() != nil
+	})
+}
+
+// sendBatch 发送一批查询并把结果分发给等待的 Lookup
+func (r *ipAPIResolver) sendBatch(batch []*ipAPIWaiter) {
+	ips := make([]string, len(batch))
+	for i, waiter := range batch {
+		ips[i] = waiter.ip
+	}
+	answers, err := r.queryBatch(ips)
+	if err != nil {
+		log.Debugln("ip-api batch lookup of %d ips failed, falling back to single lookups: %v", len(ips), err)
+	}
+	for _, waiter := range batch {
+		answer, ok := answers[waiter.ip]
+		if !ok {
+			answer = ipAPIAnswer{fallback: true}
+		}
+		waiter.done <- answer
+	}
+}
+
+// queryBatch 通过批量接口查询，按响应里的 query 字段对应 IP，没有 query 字段时按请求顺序对应
+func (r *ipAPIResolver) queryBatch(ips []string) (map[string]ipAPIAnswer, error) {
+	body, err := json.Marshal(ips)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.batchURL+"?fields="+ipAPIFields, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("batch: %s", resp.Status)
+	}
+	var data []ipAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	answers := make(map[string]ipAPIAnswer, len(data))
+	for i := range data {
+		item := &data[i]
+		ip := item.Query
+		if ip == "" && i < len(ips) {
+			ip = ips[i]
+		}
+		if ip == "" {
+			continue
+		}
+		if item.Status != "" && item.Status != "success" {
+			answers[ip] = ipAPIAnswer{err: fmt.Errorf("failed to get location for IP %s: %s", ip, item.Message)}
+			continue
+		}
+		answers[ip] = ipAPIAnswer{info: item.geoInfo()}
+	}
+	return answers, nil
+}
+
+// lookupSingle 通过单个 IP 的接口查询，查询之间至少间隔 ipAPIFreeInterval
+func (r *ipAPIResolver) lookupSingle(ctx context.Context, ip string) (*GeoInfo, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	url := r.singleURL + ip + "?fields=" + ipAPIFields
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get location for IP %s: %s", ip, resp.Status)
+	}
+	var data ipAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.Status != "" && data.Status != "success" {
+		return nil, fmt.Errorf("failed to get location for IP %s: %s", ip, data.Message)
+	}
+	return data.geoInfo(), nil
+}
+
+func (data *ipAPIResponse) geoInfo() *GeoInfo {
+	info := &GeoInfo{
+		Country:     data.Country,
+		CountryCode: strings.ToUpper(data.CountryCode),
+		City:        data.City,
+	}
+	info.ASN, info.ASOrg = parseASField(data.AS)
+	if data.ASName != "" {
+		info.ASOrg = data.ASName
+	} else if info.ASOrg == "" {
+		info.ASOrg = data.Org
+	}
+	return info
+}
+
+// parseASField 解析形如 "AS15169 Google LLC" 的字段
+func parseASField(as string) (int, string) {
+	as = strings.TrimSpace(as)
+	if as == "" {
+		return 0, ""
+	}
+	number, org, _ := strings.Cut(as, " ")
+	asn, err := ParseASN(number)
+	if err != nil {
+		return 0, as
+	}
+	return asn, strings.TrimSpace(org)
+}
+
+// ParseASN 解析 "AS9009" 或 "9009" 形式的 AS 号
+func ParseASN(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	asn, err := strconv.Atoi(s)
+	if err != nil || asn <= 0 {
+		return 0, fmt.Errorf("invalid ASN %q", s)
+	}
+	return asn, nil
+}
+
+// detectExitIP 通过代理访问测速服务器的 trace 接口获取出口 IP 和 trace 的全部字段，
+// 测速服务器不支持时退回到 api.ipify.org，这时没有 trace 字段
+func (st *SpeedTester) detectExitIP(ctx context.Context, proxy constant.Proxy) (string, map[string]string, error) {
+	return fetchExitIP(ctx, st.createClient(proxy, st.config.Timeout), st.config.DownloadServerURL)
+}
+
+func fetchExitIP(ctx context.Context, client *http.Client, serverURL string) (string, map[string]string, error) {
+	if fields, err := fetchTrace(ctx, client, serverURL+"/cdn-cgi/trace"); err == nil {
+		return fields["ip"], fields, nil
+	}
+	resp, err := getContext(ctx, client, "https://api.ipify.org")
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", nil, err
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", nil, fmt.Errorf("invalid exit ip %q", ip)
+	}
+	return ip, nil, nil
+}
+
+// fetchTrace 读取 trace 接口返回的 key=value 字段，ip 字段缺失或不是合法 IP 时返回错误
+func fetchTrace(ctx context.Context, client *http.Client, url string) (map[string]string, error) {
+	resp, err := getContext(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("trace: %s", resp.Status)
+	}
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 4096))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			fields[key] = value
+		}
+	}
+	if net.ParseIP(fields["ip"]) == nil {
+		return nil, fmt.Errorf("trace: no ip field")
+	}
+	return fields, nil
+}
+
+// resolveExitGeo 获取节点出口 IP 并查询其国家和 ASN
+func (st *SpeedTester) resolveExitGeo(ctx context.Context, proxy constant.Proxy, result *Result) {
+	ip, trace, err := st.detectExitIP(ctx, proxy)
+	if err != nil {
+		return
+	}
+	result.ExitIP = ip
+	result.ForwardedChain, result.ExtraHops = ParseForwardedHops(trace)
+	if st.geoResolver == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	info, err := st.geoResolver.Lookup(ctx, ip)
+	if err != nil {
+		return
+	}
+	result.CountryCode = info.CountryCode
+	result.City = info.City
+	result.ExitASN = info.ASN
+	result.ExitASOrg = info.ASOrg
+}
@@ -0,0 +1,228 @@
+package speedtester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ipAPIServer 是假的 ip-api.com。batch 根据请求的 IP 列表返回响应，nil 时批量接口返回 500；
+// 单个查询总是成功，城市名是 "single"。返回服务器和每次批量请求的 IP 列表、单个查询的 IP
+type ipAPIServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	batches [][]string
+	singles []string
+}
+
+func newIPAPIServer(t *testing.T, batch func(ips []string) []map[string]any) *ipAPIServer {
+	t.Helper()
+	s := &ipAPIServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fields") != ipAPIFields {
+			t.Errorf("%s without fields", r.URL.Path)
+		}
+		if ip, ok := strings.CutPrefix(r.URL.Path, "/json/"); ok {
+			s.mu.Lock()
+			s.singles = append(s.singles, ip)
+			s.mu.Unlock()
+			json.NewEncoder(w).Encode(map[string]any{"status": "success", "query": ip, "countryCode": "us", "city": "single", "as": "AS64500 Single"})
+			return
+		}
+		var ips []string
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&ips) != nil {
+			http.Error(w, "bad batch", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.batches = append(s.batches, ips)
+		s.mu.Unlock()
+		if batch == nil {
+			http.Error(w, "quota", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(batch(ips))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *ipAPIServer) resolver() *ipAPIResolver {
+	return &ipAPIResolver{client: s.Client(), singleURL: s.URL + "/json/", batchURL: s.URL + "/batch"}
+}
+
+// busy 模拟另一个正在进行的查询，之后同时到达的查询都会等待凑批
+func busy(r *ipAPIResolver) *ipAPIResolver {
+	r.active = 1
+	return r
+}
+
+func (s *ipAPIServer) requests() ([][]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.batches), slices.Clone(s.singles)
+}
+
+func batchAnswer(ip string) map[string]any {
+	return map[string]any{"status": "success", "query": ip, "countryCode": "jp", "city": "batch " + ip, "as": "AS64501 Batch"}
+}
+
+// lookupAll 同时查询 ips，返回每个 IP 的结果和错误
+func lookupAll(r GeoResolver, ips []string) ([]*GeoInfo, []error) {
+	infos := make([]*GeoInfo, len(ips))
+	errs := make([]error, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			infos[i], errs[i] = r.Lookup(ctx, ip)
+		}()
+	}
+	wg.Wait()
+	return infos, errs
+}
+
+// TestIPAPIBatchOrder 同时到达的查询合成一次批量请求，响应顺序打乱也能按 query 字段分给对应的查询
+func TestIPAPIBatchOrder(t *testing.T) {
+	server := newIPAPIServer(t, func(ips []string) []map[string]any {
+		var answers []map[string]any
+		for _, ip := range slices.Backward(ips) {
+			answers = append(answers, batchAnswer(ip))
+		}
+		return answers
+	})
+	ips := []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4"}
+	infos, errs := lookupAll(busy(server.resolver()), ips)
+	for i, ip := range ips {
+		if errs[i] != nil || infos[i].City != "batch "+ip || infos[i].CountryCode != "JP" || infos[i].ASN != 64501 {
+			t.Errorf("%s: %+v, %v", ip, infos[i], errs[i])
+		}
+	}
+	batches, singles := server.requests()
+	if len(batches) != 1 || len(batches[0]) != 4 || len(singles) != 0 {
+		t.Errorf("batches %v, singles %v, want one batch of 4", batches, singles)
+	}
+}
+
+// TestIPAPIBatchPositional 响应里没有 query 字段时按请求顺序对应
+func TestIPAPIBatchPositional(t *testing.T) {
+	server := newIPAPIServer(t, func(ips []string) []map[string]any {
+		var answers []map[string]any
+		for _, ip := range ips {
+			answer := batchAnswer(ip)
+			delete(answer, "query")
+			answers = append(answers, answer)
+		}
+		return answers
+	})
+	ips := []string{"203.0.113.1", "203.0.113.2"}
+	infos, errs := lookupAll(busy(server.resolver()), ips)
+	for i, ip := range ips {
+		if errs[i] != nil || infos[i].City != "batch "+ip {
+			t.Errorf("%s: %+v, %v", ip, infos[i], errs[i])
+		}
+	}
+}
+
+// TestIPAPIBatchPartialFailure 批量结果里失败的 IP 返回错误，缺少的 IP 单独再查一次
+func TestIPAPIBatchPartialFailure(t *testing.T) {
+	server := newIPAPIServer(t, func(ips []string) []map[string]any {
+		var answers []map[string]any
+		for _, ip := range ips {
+			switch ip {
+			case "10.0.0.1":
+				answers = append(answers, map[string]any{"status": "fail", "message": "private range", "query": ip})
+			case "203.0.113.9":
+				// 结果里缺少这个 IP
+			default:
+				answers = append(answers, batchAnswer(ip))
+			}
+		}
+		return answers
+	})
+	ips := []string{"203.0.113.1", "10.0.0.1", "203.0.113.9"}
+	infos, errs := lookupAll(busy(server.resolver()), ips)
+	if errs[0] != nil || infos[0].City != "batch 203.0.113.1" {
+		t.Errorf("batched ip: %+v, %v", infos[0], errs[0])
+	}
+	if errs[1] == nil || !strings.Contains(errs[1].Error(), "private range") {
+		t.Errorf("failed ip: %+v, %v", infos[1], errs[1])
+	}
+	if errs[2] != nil || infos[2].City != "single" {
+		t.Errorf("missing ip not looked up alone: %+v, %v", infos[2], errs[2])
+	}
+	if _, singles := server.requests(); !slices.Equal(singles, []string{"203.0.113.9"}) {
+		t.Errorf("single lookups %v", singles)
+	}
+}
+
+// TestIPAPIBatchError 整个批量请求失败时每个 IP 都单独查询
+func TestIPAPIBatchError(t *testing.T) {
+	server := newIPAPIServer(t, nil)
+	ips := []string{"203.0.113.1", "203.0.113.2"}
+	infos, errs := lookupAll(busy(server.resolver()), ips)
+	for i, ip := range ips {
+		if errs[i] != nil || infos[i].City != "single" {
+			t.Errorf("%s: %+v, %v", ip, infos[i], errs[i])
+		}
+	}
+	batches, singles := server.requests()
+	slices.Sort(singles)
+	if len(batches) != 1 || !slices.Equal(singles, ips) {
+		t.Errorf("batches %v, singles %v", batches, singles)
+	}
+}
+
+// TestIPAPISingleLookup 只有一个查询时直接用单个查询的接口，不等待凑批
+func TestIPAPISingleLookup(t *testing.T) {
+	server := newIPAPIServer(t, func(ips []string) []map[string]any {
+		t.Errorf("batch request for %v", ips)
+		return nil
+	})
+	start := time.Now()
+	info, err := server.resolver().Lookup(context.Background(), "203.0.113.1")
+	if err != nil || info.City != "single" || info.ASN != 64500 {
+		t.Errorf("info %+v, %v", info, err)
+	}
+	if elapsed := time.Since(start); elapsed >= ipAPIBatchWindow {
+		t.Errorf("lone lookup took %s, waited for the batch window", elapsed)
+	}
+}
+
+// TestIPAPIBatchSize 超过批量上限的查询拆成多次批量请求，每次最多 ipAPIBatchSize 个
+func TestIPAPIBatchSize(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the second batch waits for the batch rate limit")
+	}
+	server := newIPAPIServer(t, func(ips []string) []map[string]any {
+		var answers []map[string]any
+		for _, ip := range ips {
+			answers = append(answers, batchAnswer(ip))
+		}
+		return answers
+	})
+	ips := make([]string, ipAPIBatchSize+5)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("198.51.%d.%d", i/250, i%250+1)
+	}
+	_, errs := lookupAll(busy(server.resolver()), ips)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("%s: %v", ips[i], err)
+		}
+	}
+	batches, singles := server.requests()
+	if len(batches) != 2 || len(batches[0]) != ipAPIBatchSize || len(batches[1]) != 5 || len(singles) != 0 {
+		t.Errorf("got %d batches, %d singles", len(batches), len(singles))
+	}
+}
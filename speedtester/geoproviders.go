@@ -0,0 +1,149 @@
+package speedtester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+// GeoProviders 是 ParseGeoProviders 支持的查询服务
+var GeoProviders = []string{"ip-api", "ipinfo", "ipsb"}
+
+// ParseGeoProviders 按逗号分隔的优先级顺序创建查询服务链，例如 "ip-api,ipinfo,ipsb"
+func ParseGeoProviders(spec string) (GeoResolver, error) {
+	chain := &chainResolver{failures: make(map[string]int)}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		var resolver GeoResolver
+		switch name {
+		case "ip-api":
+			resolver = NewIPAPIResolver()
+		case "ipinfo":
+			resolver = &ipinfoResolver{client: &http.Client{Timeout: 10 * time.Second}, baseURL: "https://ipinfo.io/"}
+		case "ipsb":
+			resolver = &ipsbResolver{client: &http.Client{Timeout: 10 * time.Second}, baseURL: "https://api.ip.sb/geoip/"}
+		default:
+			return nil, fmt.Errorf("unknown geo provider %q, supported: %s", name, strings.Join(GeoProviders, ", "))
+		}
+		chain.names = append(chain.names, name)
+		chain.resolvers = append(chain.resolvers, resolver)
+	}
+	if len(chain.resolvers) == 0 {
+		return nil, fmt.Errorf("no geo provider specified")
+	}
+	return chain, nil
+}
+
+// chainResolver 按顺序尝试各个查询服务，全部失败时返回最后一个错误。
+// 调用方遇到错误时不填地区，测试照常继续；cachedResolver 不缓存错误，下次查询同一 IP 会重试
+type chainResolver struct {
+	names     []string
+	resolvers []GeoResolver
+	mu        sync.Mutex
+	failures  map[string]int
+}
+
+func (r *chainResolver) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	var lastErr error
+	for i, resolver := range r.resolvers {
+		info, err := resolver.Lookup(ctx, ip)
+		if err == nil {
+			return info, nil
+		}
+		lastErr = err
+		r.mu.Lock()
+		r.failures[r.names[i]]++
+		failures := r.failures[r.names[i]]
+		r.mu.Unlock()
+		log.Debugln("geo provider %s failed for %s (%d failures): %v", r.names[i], ip, failures, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("every geo provider failed for %s: %w", ip, lastErr)
+}
+
+// getGeoJSON 请求 url 并把 JSON 响应解析到 v
+func getGeoJSON(ctx context.Context, client *http.Client, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "clash-speedtest")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ipinfoResolver 使用 ipinfo.io 的免费接口查询，不返回国家全称
+type ipinfoResolver struct {
+	client  *http.Client
+	baseURL string
+}
+
+func (r *ipinfoResolver) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	var data struct {
+		Country string `json:"country"`
+		City    string `json:"city"`
+		Org     string `json:"org"`
+	}
+	if err := getGeoJSON(ctx, r.client, r.baseURL+url.PathEscape(ip)+"/json", &data); err != nil {
+		return nil, err
+	}
+	if data.Country == "" {
+		return nil, fmt.Errorf("no country for %s", ip)
+	}
+	info := &GeoInfo{
+		Country:     strings.ToUpper(data.Country),
+		CountryCode: strings.ToUpper(data.Country),
+		City:        data.City,
+	}
+	info.ASN, info.ASOrg = parseASField(data.Org)
+	return info, nil
+}
+
+// ipsbResolver 使用 api.ip.sb 的接口查询
+type ipsbResolver struct {
+	client  *http.Client
+	baseURL string
+}
+
+func (r *ipsbResolver) Lookup(ctx context.Context, ip string) (*GeoInfo, error) {
+	var data struct {
+		Country         string `json:"country"`
+		CountryCode     string `json:"country_code"`
+		City            string `json:"city"`
+		ASN             int    `json:"asn"`
+		ASNOrganization string `json:"asn_organization"`
+	}
+	if err := getGeoJSON(ctx, r.client, r.baseURL+url.PathEscape(ip), &data); err != nil {
+		return nil, err
+	}
+	if data.CountryCode == "" {
+		return nil, fmt.Errorf("no country for %s", ip)
+	}
+	return &GeoInfo{
+		Country:     data.Country,
+		CountryCode: strings.ToUpper(data.CountryCode),
+		City:        data.City,
+		ASN:         data.ASN,
+		ASOrg:       data.ASNOrganization,
+	}, nil
+}
@@ -0,0 +1,137 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureServer 把请求路径映射到 testdata/geo 下录制的响应，没有对应文件时返回 404，并记录请求的路径
+func fixtureServer(t *testing.T, routes map[string]string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		fixture, ok := routes[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", "geo", fixture))
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server, &paths
+}
+
+func TestIPInfoResolver(t *testing.T) {
+	server, _ := fixtureServer(t, map[string]string{
+		"/203.0.113.7/json": "ipinfo.json",
+		"/10.0.0.1/json":    "ipinfo-bogon.json",
+	})
+	r := &ipinfoResolver{client: server.Client(), baseURL: server.URL + "/"}
+	info, err := r.Lookup(context.Background(), "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GeoInfo{Country: "JP", CountryCode: "JP", City: "Tokyo", ASN: 2516, ASOrg: "KDDI CORPORATION"}
+	if *info != want {
+		t.Errorf("info %+v, want %+v", *info, want)
+	}
+	if _, err := r.Lookup(context.Background(), "10.0.0.1"); err == nil || !strings.Contains(err.Error(), "no country") {
+		t.Errorf("bogon: %v", err)
+	}
+	if _, err := r.Lookup(context.Background(), "198.51.100.1"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing: %v", err)
+	}
+}
+
+func TestIPSBResolver(t *testing.T) {
+	server, paths := fixtureServer(t, map[string]string{"/2001:db8::1": "ipsb.json"})
+	r := &ipsbResolver{client: server.Client(), baseURL: server.URL + "/"}
+	info, err := r.Lookup(context.Background(), "2001:db8::1")
+	if err != nil {
+		t.Fatalf("%v, requested %v", err, *paths)
+	}
+	want := GeoInfo{Country: "United States", CountryCode: "US", City: "San Jose", ASN: 6939, ASOrg: "Hurricane Electric LLC"}
+	if *info != want {
+		t.Errorf("info %+v, want %+v", *info, want)
+	}
+}
+
+// stubResolver 按顺序返回固定的结果，记录被调用的次数
+type stubResolver struct {
+	info  *GeoInfo
+	err   error
+	calls int
+}
+
+func (r *stubResolver) Lookup(context.Context, string) (*GeoInfo, error) {
+	r.calls++
+	return r.info, r.err
+}
+
+func TestChainResolver(t *testing.T) {
+	down := &stubResolver{err: errors.New("connection refused")}
+	up := &stubResolver{info: &GeoInfo{CountryCode: "JP"}}
+	never := &stubResolver{info: &GeoInfo{CountryCode: "US"}}
+	chain := &chainResolver{names: []string{"a", "b", "c"}, resolvers: []GeoResolver{down, up, never}, failures: map[string]int{}}
+
+	for range 2 {
+		info, err := chain.Lookup(context.Background(), "203.0.113.7")
+		if err != nil || info.CountryCode != "JP" {
+			t.Fatalf("info %+v, err %v", info, err)
+		}
+	}
+	if down.calls != 2 || up.calls != 2 || never.calls != 0 || chain.failures["a"] != 2 || chain.failures["b"] != 0 {
+		t.Errorf("calls %d/%d/%d, failures %v", down.calls, up.calls, never.calls, chain.failures)
+	}
+
+	// 全部失败时返回错误，缓存不记录失败，服务恢复后同一 IP 可以查到
+	up.info, up.err = nil, errors.New("rate limited")
+	never.info, never.err = nil, errors.New("timeout")
+	cached := NewCachedResolver(chain)
+	info, err := cached.Lookup(context.Background(), "203.0.113.7")
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("all providers failed: info %+v, err %v", info, err)
+	}
+	up.info, up.err = &GeoInfo{CountryCode: "JP"}, nil
+	if info, err := cached.Lookup(context.Background(), "203.0.113.7"); err != nil || info.CountryCode != "JP" {
+		t.Errorf("lookup after recovery: info %+v, err %v", info, err)
+	}
+	up.info, up.err = nil, errors.New("rate limited")
+
+	// ctx 取消后不再尝试后面的服务
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	never.calls = 0
+	chain.Lookup(ctx, "203.0.113.7")
+	if never.calls != 0 {
+		t.Error("lookup continued after the context was canceled")
+	}
+}
+
+func TestParseGeoProviders(t *testing.T) {
+	resolver, err := ParseGeoProviders(" ipinfo, IP-API ,,ipsb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := resolver.(*chainResolver)
+	if got := strings.Join(chain.names, ","); got != "ipinfo,ip-api,ipsb" {
+		t.Errorf("chain %s", got)
+	}
+	for spec, want := range map[string]string{"": "no geo provider", " , ": "no geo provider", "ipinfo,maxmind": `unknown geo provider "maxmind"`} {
+		if _, err := ParseGeoProviders(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: %v, want %q", spec, err, want)
+		}
+	}
+}
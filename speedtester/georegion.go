@@ -0,0 +1,182 @@
+package speedtester
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// geoPoint 是一个网络枢纽的大致坐标
+type geoPoint struct {
+	lat, lon float64
+}
+
+// regionHubs 是各国主要网络枢纽的坐标，国土较大的国家列出多个，计算距离时取最近的一对。
+// 只用来估算物理上限，精度到城市级别就够了
+var regionHubs = map[string][]geoPoint{
+	"US": {{39.0, -77.5}, {41.9, -87.6}, {32.8, -96.8}, {34.0, -118.2}, {47.6, -122.3}, {25.8, -80.2}},
+	"CA": {{43.7, -79.4}, {45.5, -73.6}, {49.3, -123.1}},
+	"MX": {{19.4, -99.1}},
+	"BR": {{-23.5, -46.6}, {-3.7, -38.5}},
+	"AR": {{-34.6, -58.4}},
+	"CL": {{-33.4, -70.6}},
+	"GB": {{51.5, -0.1}},
+	"IE": {{53.3, -6.3}},
+	"FR": {{48.9, 2.4}, {43.3, 5.4}},
+	"DE": {{50.1, 8.7}, {52.5, 13.4}},
+	"NL": {{52.4, 4.9}},
+	"BE": {{50.8, 4.4}},
+	"CH": {{47.4, 8.5}},
+	"AT": {{48.2, 16.4}},
+	"IT": {{45.5, 9.2}, {41.9, 12.5}},
+	"ES": {{40.4, -3.7}},
+	"PT": {{38.7, -9.1}},
+	"SE": {{59.3, 18.1}},
+	"NO": {{59.9, 10.8}},
+	"FI": {{60.2, 24.9}},
+	"DK": {{55.7, 12.6}},
+	"PL": {{52.2, 21.0}},
+	"CZ": {{50.1, 14.4}},
+	"RO": {{44.4, 26.1}},
+	"BG": {{42.7, 23.3}},
+	"UA": {{50.5, 30.5}},
+	"TR": {{41.0, 29.0}},
+	"RU": {{55.8, 37.6}, {59.9, 30.3}, {55.0, 82.9}, {43.1, 131.9}},
+	"KZ": {{43.2, 76.9}},
+	"IL": {{32.1, 34.8}},
+	"AE": {{25.2, 55.3}},
+	"SA": {{24.7, 46.7}},
+	"IN": {{19.1, 72.9}, {13.1, 80.3}, {28.6, 77.2}},
+	"PK": {{24.9, 67.0}},
+	"CN": {{31.2, 121.5}, {39.9, 116.4}, {23.1, 113.3}, {30.7, 104.1}},
+	"HK": {{22.3, 114.2}},
+	"MO": {{22.2, 113.5}},
+	"TW": {{25.0, 121.5}},
+	"JP": {{35.7, 139.7}, {34.7, 135.5}},
+	"KR": {{37.6, 127.0}},
+	"SG": {{1.35, 103.8}},
+	"MY": {{3.1, 101.7}},
+	"TH": {{13.8, 100.5}},
+	"VN": {{21.0, 105.8}, {10.8, 106.7}},
+	"PH": {{14.6, 121.0}},
+	"ID": {{-6.2, 106.8}},
+	"AU": {{-33.9, 151.2}, {-37.8, 145.0}, {-31.95, 115.9}},
+	"NZ": {{-36.8, 174.8}},
+	"ZA": {{-26.2, 28.0}, {-33.9, 18.4}},
+	"EG": {{30.0, 31.2}},
+	"NG": {{6.5, 3.4}},
+	"KE": {{-1.3, 36.8}},
+}
+
+const earthRadiusKm = 6371
+
+// fiberKmPerMs 是光在光纤中的传播速度，约为真空光速的 2/3
+const fiberKmPerMs = 200
+
+// geoSuspectRatio 延迟低于理论下限的这个比例时认为节点标注的地区不可信。
+// 理论下限按两地直线距离算，实际线路只会更长，留出的余量用来吸收坐标误差
+const geoSuspectRatio = 0.7
+
+// KnownRegion 判断国家代码是否在 RTT 下限表中
+func KnownRegion(countryCode string) bool {
+	_, ok := regionHubs[strings.ToUpper(countryCode)]
+	return ok
+}
+
+// MinRTT 返回两个国家之间按光纤光速估算的最小往返时间，任意一方不在表中时返回 0
+func MinRTT(from, to string) time.Duration {
+	a, b := regionHubs[strings.ToUpper(from)], regionHubs[strings.ToUpper(to)]
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	distance := math.Inf(1)
+	for _, p := range a {
+		for _, q := range b {
+			distance = math.Min(distance, greatCircleKm(p, q))
+		}
+	}
+	return time.Duration(2 * distance / fiberKmPerMs * float64(time.Millisecond))
+}
+
+func greatCircleKm(p, q geoPoint) float64 {
+	rad := math.Pi / 180
+	dLat := (q.lat - p.lat) * rad
+	dLon := (q.lon - p.lon) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(p.lat*rad)*math.Cos(q.lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// IsGeoSuspect 判断从 myRegion 测到 nodeRegion 的节点延迟是否低得不可能，
+// 这种节点要么地区标错了，要么流量在半路被透明代理截走了
+func IsGeoSuspect(myRegion, nodeRegion string, latency time.Duration) bool {
+	floor := MinRTT(myRegion, nodeRegion)
+	return latency > 0 && floor > 0 && float64(latency) < float64(floor)*geoSuspectRatio
+}
+
+// regionKeywords 是节点名里常见的地区写法，按顺序匹配，较长的写法要排在它的前缀前面
+var regionKeywords = []struct {
+	keyword string
+	country string
+}{
+	{"香港", "HK"}, {"澳门", "MO"}, {"台湾", "TW"}, {"日本", "JP"}, {"东京", "JP"}, {"大阪", "JP"},
+	{"韩国", "KR"}, {"首尔", "KR"}, {"新加坡", "SG"}, {"狮城", "SG"}, {"美国", "US"}, {"洛杉矶", "US"},
+	{"硅谷", "US"}, {"英国", "GB"}, {"伦敦", "GB"}, {"德国", "DE"}, {"法兰克福", "DE"}, {"法国", "FR"},
+	{"荷兰", "NL"}, {"俄罗斯", "RU"}, {"加拿大", "CA"}, {"澳大利亚", "AU"}, {"澳洲", "AU"},
+	{"印度尼西亚", "ID"}, {"印尼", "ID"}, {"印度", "IN"}, {"土耳其", "TR"}, {"巴西", "BR"},
+	{"阿根廷", "AR"}, {"马来西亚", "MY"}, {"泰国", "TH"}, {"越南", "VN"}, {"菲律宾", "PH"},
+	{"阿联酋", "AE"}, {"迪拜", "AE"},
+	{"Hong Kong", "HK"}, {"Taiwan", "TW"}, {"Japan", "JP"}, {"Tokyo", "JP"}, {"Korea", "KR"},
+	{"Singapore", "SG"}, {"United States", "US"}, {"USA", "US"}, {"Los Angeles", "US"},
+	{"United Kingdom", "GB"}, {"London", "GB"}, {"UK", "GB"}, {"Germany", "DE"}, {"Frankfurt", "DE"},
+}
+
+// ClaimedRegion 从节点名中猜测它声称的国家：优先看国旗 emoji，其次是常见的中英文地名，
+// 最后是单独出现的两位大写国家代码。猜不出来时返回空字符串
+func ClaimedRegion(name string) string {
+	runes := []rune(name)
+	for i := 0; i+1 < len(runes); i++ {
+		if isRegionalIndicator(runes[i]) && isRegionalIndicator(runes[i+1]) {
+			code := string([]rune{runes[i] - 0x1F1E6 + 'A', runes[i+1] - 0x1F1E6 + 'A'})
+			if code == "UK" {
+				code = "GB"
+			}
+			return code
+		}
+	}
+	for _, k := range regionKeywords {
+		if strings.Contains(name, k.keyword) {
+			return k.country
+		}
+	}
+	// 紧跟在数字后面的字母是单位，例如 "剩余流量 100GB" 里的 GB 不是英国
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && unicode.IsLetter(runes[j]) {
+			j++
+		}
+		token := string(runes[i:j])
+		if j-i == 2 && token == strings.ToUpper(token) && KnownRegion(token) && (i == 0 || !unicode.IsDigit(runes[i-1])) {
+			return token
+		}
+		i = j
+	}
+	return ""
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// checkGeoSuspect 用出口 IP 的国家（没有时用节点名里声称的国家）检查延迟是否低于物理下限
+func (st *SpeedTester) checkGeoSuspect(name string, result *Result) {
+	region := result.CountryCode
+	if region == "" {
+		region = ClaimedRegion(name)
+	}
+	result.GeoSuspect = IsGeoSuspect(st.config.MyRegion, region, result.Latency)
+}
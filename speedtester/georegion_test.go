@@ -0,0 +1,108 @@
+package speedtester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegionHubs(t *testing.T) {
+	for code, hubs := range regionHubs {
+		if len(code) != 2 || len(hubs) == 0 {
+			t.Errorf("%q: %d hubs", code, len(hubs))
+		}
+		for _, p := range hubs {
+			if p.lat < -90 || p.lat > 90 || p.lon < -180 || p.lon > 180 {
+				t.Errorf("%s: hub %+v out of range", code, p)
+			}
+		}
+	}
+	// 节点名里能识别出的地区都要能算出下限
+	for _, k := range regionKeywords {
+		if !KnownRegion(k.country) {
+			t.Errorf("keyword %s maps to %s, which has no hub", k.keyword, k.country)
+		}
+	}
+}
+
+func TestMinRTT(t *testing.T) {
+	tests := []struct {
+		from, to string
+		min, max time.Duration
+	}{
+		// 法兰克福到美国东海岸约 6200km
+		{"DE", "US", 55 * time.Millisecond, 70 * time.Millisecond},
+		{"hk", "jp", 20 * time.Millisecond, 30 * time.Millisecond},
+		{"DE", "DE", 0, 0},
+		{"DE", "XX", 0, 0},
+		{"", "US", 0, 0},
+	}
+	for _, tt := range tests {
+		got := MinRTT(tt.from, tt.to)
+		if got < tt.min || got > tt.max {
+			t.Errorf("MinRTT(%s, %s) = %s, want between %s and %s", tt.from, tt.to, got, tt.min, tt.max)
+		}
+		if back := MinRTT(tt.to, tt.from); back != got {
+			t.Errorf("MinRTT(%s, %s) = %s but MinRTT(%s, %s) = %s", tt.from, tt.to, got, tt.to, tt.from, back)
+		}
+	}
+}
+
+func TestIsGeoSuspect(t *testing.T) {
+	tests := []struct {
+		my, node string
+		latency  time.Duration
+		want     bool
+	}{
+		{"DE", "US", 3 * time.Millisecond, true},
+		{"DE", "US", 80 * time.Millisecond, false},
+		{"DE", "DE", time.Millisecond, false},
+		{"DE", "", time.Millisecond, false},
+		{"", "US", time.Millisecond, false},
+		// 没有测到延迟的节点不算
+		{"DE", "US", 0, false},
+	}
+	for _, tt := range tests {
+		if got := IsGeoSuspect(tt.my, tt.node, tt.latency); got != tt.want {
+			t.Errorf("IsGeoSuspect(%s, %s, %s) = %v", tt.my, tt.node, tt.latency, got)
+		}
+	}
+}
+
+func TestClaimedRegion(t *testing.T) {
+	tests := map[string]string{
+		"🇺🇸 US 01":             "US",
+		"🇬🇧 London":            "GB",
+		"\U0001F1FA\U0001F1F0": "GB",
+		"香港 IPLC 01":           "HK",
+		"印度尼西亚 01":             "ID",
+		"印度 孟买":                "IN",
+		"Tokyo-02":             "JP",
+		"节点 SG 03":             "SG",
+		"Premium JP":           "JP",
+		"sg lowercase":         "",
+		"XX 01":                "",
+		"剩余流量 100GB":           "",
+		"HK01 标准":              "HK",
+		"5TB 套餐 JP":            "JP",
+	}
+	for name, want := range tests {
+		if got := ClaimedRegion(name); got != want {
+			t.Errorf("ClaimedRegion(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCheckGeoSuspect(t *testing.T) {
+	st := New(&Config{MyRegion: "DE"})
+	// 有出口国家时以出口国家为准
+	result := &Result{CountryCode: "DE", Latency: 3 * time.Millisecond}
+	st.checkGeoSuspect("🇺🇸 US 01", result)
+	if result.GeoSuspect {
+		t.Error("node exiting in DE flagged from its US name")
+	}
+	result = &Result{Latency: 3 * time.Millisecond}
+	st.checkGeoSuspect("🇺🇸 US 01", result)
+	if !result.GeoSuspect {
+		t.Error("3ms node claiming US not flagged")
+	}
+}
@@ -0,0 +1,65 @@
+package speedtester
+
+import (
+	"net"
+	"strings"
+)
+
+// ParseForwardedHops 从 trace 字段里找出节点出口之后还经过了几层代理。
+// trace 的 ip 是测速服务器看到的 TCP 来源地址，x-forwarded-for、x-real-ip、forwarded 是中间代理
+// 替上一跳加上的客户端地址，via 里每一项是一层代理。返回 ip 以外的转发地址（按出现顺序去重）
+// 和推断出的额外层数，两类证据取较大的一个。Cloudflare 的 trace 不回显这些请求头，这时总是返回 0
+func ParseForwardedHops(trace map[string]string) ([]string, int) {
+	if trace == nil {
+		return nil, 0
+	}
+	exitIP := trace["ip"]
+	var chain []string
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		ip := forwardedIP(addr)
+		if ip == "" || seen[ip] || sameIP(ip, exitIP) {
+			return
+		}
+		seen[ip] = true
+		chain = append(chain, ip)
+	}
+	for _, addr := range splitHeaderList(trace["x-forwarded-for"]) {
+		add(addr)
+	}
+	add(trace["x-real-ip"])
+	for _, element := range splitHeaderList(trace["forwarded"]) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				add(value)
+			}
+		}
+	}
+	return chain, max(len(chain), len(splitHeaderList(trace["via"])))
+}
+
+// splitHeaderList 拆分逗号分隔的请求头，去掉空项
+func splitHeaderList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// forwardedIP 从转发头里的一项地址中取出 IP，兼容引号、IPv6 方括号和端口，
+// "unknown" 和混淆过的 _xxx 标识不是 IP，返回空字符串
+func forwardedIP(addr string) string {
+	addr = strings.Trim(strings.TrimSpace(addr), `"`)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	if net.ParseIP(addr) == nil {
+		return ""
+	}
+	return addr
+}
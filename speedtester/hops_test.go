@@ -0,0 +1,109 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseForwardedHopsFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		chain   []string
+		hops    int
+	}{
+		// Cloudflare 的 trace 不回显转发头
+		{"cloudflare.txt", nil, 0},
+		{"direct.txt", nil, 0},
+		{"squid-chain.txt", []string{"203.0.113.7"}, 1},
+		// unknown、混淆标识和出口自己的地址都不算，重复的地址只算一次
+		{"double-chain.txt", []string{"203.0.113.7", "192.0.2.44", "2001:db8::1"}, 3},
+		{"via-only.txt", nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "trace", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			ip, trace, err := fetchExitIP(context.Background(), &http.Client{Timeout: 5 * time.Second}, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ip != trace["ip"] || ip == "" {
+				t.Errorf("exit ip %q, trace ip %q", ip, trace["ip"])
+			}
+			chain, hops := ParseForwardedHops(trace)
+			if !slices.Equal(chain, tt.chain) || hops != tt.hops {
+				t.Errorf("ParseForwardedHops = %v, %d, want %v, %d", chain, hops, tt.chain, tt.hops)
+			}
+		})
+	}
+}
+
+func TestParseForwardedHops(t *testing.T) {
+	if chain, hops := ParseForwardedHops(nil); chain != nil || hops != 0 {
+		t.Errorf("nil trace: %v, %d", chain, hops)
+	}
+	// 出口是 IPv6 时 XFF 里写法不同的同一个地址不算
+	chain, hops := ParseForwardedHops(map[string]string{"ip": "2001:db8::20", "x-forwarded-for": "2001:DB8:0::20, [2001:db8::21]:443"})
+	if !slices.Equal(chain, []string{"2001:db8::21"}) || hops != 1 {
+		t.Errorf("ipv6 exit: %v, %d", chain, hops)
+	}
+}
+
+func TestForwardedIP(t *testing.T) {
+	for addr, want := range map[string]string{
+		"203.0.113.7":            "203.0.113.7",
+		" 203.0.113.7:8080 ":     "203.0.113.7",
+		`"[2001:db8::1]:4711"`:   "2001:db8::1",
+		"[2001:db8::1]":          "2001:db8::1",
+		"2001:db8::1":            "2001:db8::1",
+		"unknown":                "",
+		"_hidden":                "",
+		"proxy.example.com:3128": "",
+		"":                       "",
+	} {
+		if got := forwardedIP(addr); got != want {
+			t.Errorf("forwardedIP(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestFetchTraceErrors(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"no ip":     func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("colo=HKG\n")) },
+		"bad ip":    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ip=not-an-ip\n")) },
+		"not found": func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+	} {
+		server := httptest.NewServer(handler)
+		if _, err := fetchTrace(context.Background(), &http.Client{Timeout: 5 * time.Second}, server.URL+"/cdn-cgi/trace"); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		server.Close()
+	}
+}
+
+// 节点测试时从测速服务器的 trace 里读出转发链
+func TestCheckDirectLeakForwardedChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ip=127.0.0.1\nx-forwarded-for=203.0.113.7\nvia=1.1 squid\n"))
+	}))
+	t.Cleanup(server.Close)
+	st := New(&Config{DownloadServerURL: server.URL, Timeout: 5 * time.Second, LocalIP: "192.0.2.1"})
+	result := &Result{}
+	st.checkDirectLeak(context.Background(), directProxy(t), result)
+	if result.ExitIP != "127.0.0.1" || result.DirectLeak || result.ExtraHops != 1 || !slices.Equal(result.ForwardedChain, []string{"203.0.113.7"}) {
+		t.Errorf("result exit %q, leak %v, hops %d, chain %v", result.ExitIP, result.DirectLeak, result.ExtraHops, result.ForwardedChain)
+	}
+}
@@ -0,0 +1,123 @@
+package speedtester
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	tlsC "github.com/metacubex/mihomo/component/tls"
+)
+
+// 支持的 Config.Impersonate 取值，none 保持 Go 默认的请求头和 TLS 指纹
+const (
+	ImpersonateNone   = "none"
+	ImpersonateChrome = "chrome"
+	ImpersonateSafari = "safari"
+)
+
+// browserProfile 是模拟一个浏览器时使用的请求头（按浏览器发送的顺序）和 mihomo 的 uTLS 指纹名
+type browserProfile struct {
+	fingerprint string
+	headers     [][2]string
+}
+
+var browserProfiles = map[string]*browserProfile{
+	ImpersonateChrome: {
+		fingerprint: "chrome",
+		headers: [][2]string{
+			{"sec-ch-ua", `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`},
+			{"sec-ch-ua-mobile", "?0"},
+			{"sec-ch-ua-platform", `"Windows"`},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"},
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-User", "?1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+		},
+	},
+	ImpersonateSafari: {
+		fingerprint: "safari",
+		headers: [][2]string{
+			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"Sec-Fetch-Site", "none"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+			{"Sec-Fetch-Dest", "document"},
+		},
+	},
+}
+
+// CheckImpersonation 检查 Config.Impersonate 的取值，空字符串等同于 none
+func CheckImpersonation(name string) error {
+	if name == "" || name == ImpersonateNone || browserProfiles[name] != nil {
+		return nil
+	}
+	return fmt.Errorf("unknown browser %q, supported: chrome, safari, none", name)
+}
+
+// impersonatingTransport 给请求补上浏览器的请求头，已经设置过的头（例如上传的 Content-Type）保持不变
+type impersonatingTransport struct {
+	base    *http.Transport
+	profile *browserProfile
+}
+
+func (t *impersonatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for _, header := range t.profile.headers {
+		if req.Header.Get(header[0]) == "" {
+			req.Header.Set(header[0], header[1])
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *impersonatingTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// impersonate 让 transport 的 https 请求使用浏览器的 TLS 指纹。
+// ALPN 只保留 http/1.1，自定义 TLS 连接的 http.Transport 不会走 h2
+func (p *browserProfile) impersonate(transport *http.Transport) http.RoundTripper {
+	if fingerprint, ok := tlsC.GetFingerprint(p.fingerprint); ok {
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := transport.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			host, _, _ := net.SplitHostPort(addr)
+			uconn := tlsC.UClient(conn, tlsC.UConfig(&tls.Config{ServerName: host}), fingerprint)
+			if err := tlsC.BuildWebsocketHandshakeState(uconn); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			if err := uconn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return uconn, nil
+		}
+	}
+	return &impersonatingTransport{base: transport, profile: p}
+}
+
+// httpTransport 返回 client 底层的 *http.Transport
+func httpTransport(client *http.Client) *http.Transport {
+	if t, ok := client.Transport.(*impersonatingTransport); ok {
+		return t.base
+	}
+	return client.Transport.(*http.Transport)
+}
+
+// impersonation 返回实际生效的模拟浏览器，用于记录在结果里
+func (st *SpeedTester) impersonation() string {
+	if browserProfiles[st.config.Impersonate] == nil {
+		return ""
+	}
+	return st.config.Impersonate
+}
@@ -0,0 +1,170 @@
+package speedtester
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tlsC "github.com/metacubex/mihomo/component/tls"
+)
+
+func TestBrowserProfiles(t *testing.T) {
+	required := []string{"User-Agent", "Accept", "Accept-Language", "Sec-Fetch-Site", "Sec-Fetch-Mode", "Sec-Fetch-Dest"}
+	for name, profile := range browserProfiles {
+		t.Run(name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for _, header := range profile.headers {
+				key := http.CanonicalHeaderKey(header[0])
+				if seen[key] {
+					t.Errorf("header %s listed twice", key)
+				}
+				seen[key] = true
+				if strings.TrimSpace(header[1]) == "" {
+					t.Errorf("header %s is empty", key)
+				}
+			}
+			for _, key := range required {
+				if !seen[key] {
+					t.Errorf("missing header %s", key)
+				}
+			}
+			if _, ok := tlsC.GetFingerprint(profile.fingerprint); !ok {
+				t.Errorf("mihomo has no %q fingerprint", profile.fingerprint)
+			}
+		})
+	}
+
+	// 只有 Chromium 内核会发 client hints
+	chrome := make(map[string]bool)
+	for _, header := range browserProfiles[ImpersonateChrome].headers {
+		chrome[http.CanonicalHeaderKey(header[0])] = true
+	}
+	for _, key := range []string{"Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Sec-Ch-Ua-Platform"} {
+		if !chrome[key] {
+			t.Errorf("chrome profile missing %s", key)
+		}
+	}
+	for _, header := range browserProfiles[ImpersonateSafari].headers {
+		if strings.HasPrefix(strings.ToLower(header[0]), "sec-ch-") {
+			t.Errorf("safari profile sends client hint %s", header[0])
+		}
+	}
+}
+
+func TestCheckImpersonation(t *testing.T) {
+	for _, name := range []string{"", ImpersonateNone, ImpersonateChrome, ImpersonateSafari} {
+		if err := CheckImpersonation(name); err != nil {
+			t.Errorf("CheckImpersonation(%q) = %v", name, err)
+		}
+	}
+	if err := CheckImpersonation("firefox"); err == nil {
+		t.Error("CheckImpersonation accepted firefox")
+	}
+}
+
+func TestImpersonatingTransport(t *testing.T) {
+	var mu sync.Mutex
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = r.Header.Clone()
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	for _, name := range []string{ImpersonateChrome, ImpersonateSafari} {
+		st := New(&Config{Impersonate: name})
+		client := st.createClient(directProxy(t), 5*time.Second)
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("x"))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Accept", "*/*")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		mu.Lock()
+		for _, header := range browserProfiles[name].headers {
+			if header[0] == "Accept" {
+				continue
+			}
+			if v := got.Get(header[0]); v != header[1] {
+				t.Errorf("%s: %s = %q, want %q", name, header[0], v, header[1])
+			}
+		}
+		// 请求自己设置的头保持不变
+		if got.Get("Accept") != "*/*" || got.Get("Content-Type") != "application/octet-stream" {
+			t.Errorf("%s overrode request headers: %v", name, got)
+		}
+		mu.Unlock()
+		if req.Header.Get("User-Agent") != "" {
+			t.Errorf("%s modified the caller's request", name)
+		}
+		if st.impersonation() != name || httpTransport(client) == nil {
+			t.Errorf("%s: impersonation %q", name, st.impersonation())
+		}
+	}
+
+	st := New(&Config{Impersonate: ImpersonateNone})
+	resp, err := st.createClient(directProxy(t), 5*time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ua := got.Get("User-Agent"); !strings.HasPrefix(ua, "Go-http-client") || got.Get("Sec-Fetch-Mode") != "" {
+		t.Errorf("none sent User-Agent %q, headers %v", ua, got)
+	}
+	if st.impersonation() != "" {
+		t.Errorf("none recorded as impersonation %q", st.impersonation())
+	}
+}
+
+// isGREASE 判断是不是 RFC 8701 的 GREASE 取值，Chrome 的 client hello 里会带，Go 的不会
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func TestImpersonateTLSFingerprint(t *testing.T) {
+	hellos := make(chan *tls.ClientHelloInfo, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		hellos <- hello
+		return nil, nil
+	}}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	for _, tt := range []struct {
+		impersonate string
+		grease      bool
+	}{
+		{ImpersonateChrome, true},
+		{ImpersonateNone, false},
+	} {
+		client := New(&Config{Impersonate: tt.impersonate}).createClient(directProxy(t), 5*time.Second)
+		// 测试服务器是自签名证书，模拟浏览器时也要校验证书，握手会失败
+		if resp, err := client.Get(server.URL); err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: self-signed certificate accepted", tt.impersonate)
+		}
+		hello := <-hellos
+		grease := false
+		for _, suite := range hello.CipherSuites {
+			grease = grease || isGREASE(suite)
+		}
+		if grease != tt.grease {
+			t.Errorf("%s: client hello with GREASE %v, want %v (cipher suites %x)", tt.impersonate, grease, tt.grease, hello.CipherSuites)
+		}
+		if tt.impersonate == ImpersonateChrome && (len(hello.SupportedProtos) != 1 || hello.SupportedProtos[0] != "http/1.1") {
+			t.Errorf("chrome ALPN %v, want http/1.1 only", hello.SupportedProtos)
+		}
+	}
+}
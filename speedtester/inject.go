@@ -0,0 +1,149 @@
+package speedtester
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Injection 是一次作用在节点配置上的改写，例如补上订阅里缺失的 shadow-tls 插件
+type Injection struct {
+	Name  string
+	Patch map[string]any
+}
+
+type shadowTLSSpec struct {
+	Host     string `yaml:"host"`
+	Password string `yaml:"password"`
+	Version  int    `yaml:"version"`
+}
+
+type pluginSpec struct {
+	Plugin     string         `yaml:"plugin"`
+	PluginOpts map[string]any `yaml:"plugin-opts"`
+}
+
+type portSpec struct {
+	Port int `yaml:"port"`
+}
+
+type wsPathSpec struct {
+	Path string `yaml:"path"`
+	Host string `yaml:"host"`
+}
+
+// ParseInjection 解析形如 `shadow-tls:{"host":"cloud.tencent.com","password":"x","version":3}` 的改写规则，
+// 冒号后面的部分可以是 JSON 也可以是 yaml flow 格式。支持的规则：
+//
+//	shadow-tls  补上 shadow-tls 插件 (host, password, version)
+//	plugin      补上任意插件 (plugin, plugin-opts)
+//	port        覆盖端口 (port)
+//	ws-path     设置 websocket 传输的 path 和 Host (path, host)
+//	merge       把任意内容深度合并到节点配置上
+func ParseInjection(spec string) (*Injection, error) {
+	name, body, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("inject %q: missing ':' between name and spec", spec)
+	}
+	name = strings.TrimSpace(name)
+	decode := func(v any) error {
+		// yaml 是 JSON 的超集，两种写法都能解析
+		if err := yaml.Unmarshal([]byte(body), v); err != nil {
+			return fmt.Errorf("inject %s: %w", name, err)
+		}
+		return nil
+	}
+
+	injection := &Injection{Name: name}
+	switch name {
+	case "shadow-tls":
+		var s shadowTLSSpec
+		if err := decode(&s); err != nil {
+			return nil, err
+		}
+		if s.Host == "" {
+			return nil, fmt.Errorf("inject shadow-tls: host is required")
+		}
+		opts := map[string]any{"host": s.Host}
+		if s.Password != "" {
+			opts["password"] = s.Password
+		}
+		if s.Version != 0 {
+			opts["version"] = s.Version
+		}
+		injection.Patch = map[string]any{"plugin": "shadow-tls", "plugin-opts": opts}
+	case "plugin":
+		var s pluginSpec
+		if err := decode(&s); err != nil {
+			return nil, err
+		}
+		if s.Plugin == "" {
+			return nil, fmt.Errorf("inject plugin: plugin is required")
+		}
+		injection.Patch = map[string]any{"plugin": s.Plugin}
+		if len(s.PluginOpts) > 0 {
+			injection.Patch["plugin-opts"] = s.PluginOpts
+		}
+	case "port":
+		var s portSpec
+		if err := decode(&s); err != nil {
+			return nil, err
+		}
+		if s.Port <= 0 || s.Port > 65535 {
+			return nil, fmt.Errorf("inject port: invalid port %d", s.Port)
+		}
+		injection.Patch = map[string]any{"port": s.Port}
+	case "ws-path":
+		var s wsPathSpec
+		if err := decode(&s); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(s.Path, "/") {
+			return nil, fmt.Errorf("inject ws-path: path must start with '/'")
+		}
+		wsOpts := map[string]any{"path": s.Path}
+		if s.Host != "" {
+			wsOpts["headers"] = map[string]any{"Host": s.Host}
+		}
+		injection.Patch = map[string]any{"network": "ws", "ws-opts": wsOpts}
+	case "merge":
+		patch := make(map[string]any)
+		if err := decode(&patch); err != nil {
+			return nil, err
+		}
+		injection.Patch = patch
+	default:
+		return nil, fmt.Errorf("inject %q: unknown transform, supported: shadow-tls, plugin, port, ws-path, merge", name)
+	}
+	return injection, nil
+}
+
+// String 返回改写内容的 JSON 形式，用于日志
+func (inj *Injection) String() string {
+	data, _ := json.Marshal(inj.Patch)
+	return inj.Name + ":" + string(data)
+}
+
+// DeepMerge 把 patch 合并到 dst 的副本上并返回：两边都是 map 时递归合并，其余情况 patch 覆盖 dst。
+// dst 本身不会被修改，方便保留原始配置
+func DeepMerge(dst, patch map[string]any) map[string]any {
+	merged := make(map[string]any, len(dst)+len(patch))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		patchMap, ok := v.(map[string]any)
+		if !ok {
+			merged[k] = v
+			continue
+		}
+		if dstMap, ok := merged[k].(map[string]any); ok {
+			merged[k] = DeepMerge(dstMap, patchMap)
+		} else {
+			merged[k] = DeepMerge(nil, patchMap)
+		}
+	}
+	return merged
+}
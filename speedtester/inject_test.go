@@ -0,0 +1,125 @@
+package speedtester
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInjection(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want map[string]any
+	}{
+		{`shadow-tls:{"host":"cloud.tencent.com","password":"x","version":3}`,
+			map[string]any{"plugin": "shadow-tls", "plugin-opts": map[string]any{"host": "cloud.tencent.com", "password": "x", "version": 3}}},
+		{`shadow-tls:{host: cloud.tencent.com}`,
+			map[string]any{"plugin": "shadow-tls", "plugin-opts": map[string]any{"host": "cloud.tencent.com"}}},
+		{`plugin:{plugin: obfs, plugin-opts: {mode: tls, host: bing.com}}`,
+			map[string]any{"plugin": "obfs", "plugin-opts": map[string]any{"mode": "tls", "host": "bing.com"}}},
+		{`port:{"port": 8443}`, map[string]any{"port": 8443}},
+		{`ws-path:{path: /ray, host: cdn.example.com}`,
+			map[string]any{"network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "cdn.example.com"}}}},
+		{`merge:{udp: true, ws-opts: {headers: {Host: a.com}}}`,
+			map[string]any{"udp": true, "ws-opts": map[string]any{"headers": map[string]any{"Host": "a.com"}}}},
+	} {
+		injection, err := ParseInjection(tc.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tc.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(injection.Patch, tc.want) {
+			t.Errorf("%s: patch %v, want %v", tc.spec, injection.Patch, tc.want)
+		}
+	}
+}
+
+func TestParseInjectionErrors(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{`shadow-tls`, "missing ':'"},
+		{`shadow-tls:{password: x}`, "host is required"},
+		{`plugin:{plugin-opts: {mode: tls}}`, "plugin is required"},
+		{`port:{port: 70000}`, "invalid port"},
+		{`ws-path:{path: ray}`, "path must start with '/'"},
+		{`merge:[1, 2]`, "inject merge"},
+		{`obfs:{}`, "unknown transform"},
+	} {
+		if _, err := ParseInjection(tc.spec); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error %v, want %q", tc.spec, err, tc.want)
+		}
+	}
+}
+
+func TestDeepMerge(t *testing.T) {
+	dst := map[string]any{
+		"name":    "a",
+		"port":    443,
+		"ws-opts": map[string]any{"path": "/old", "headers": map[string]any{"Host": "old.com", "User-Agent": "ua"}},
+		"alpn":    []any{"h2"},
+	}
+	patch := map[string]any{
+		"port":        8443,
+		"ws-opts":     map[string]any{"headers": map[string]any{"Host": "new.com"}},
+		"alpn":        []any{"http/1.1"},
+		"plugin-opts": map[string]any{"mode": "tls"},
+	}
+	want := map[string]any{
+		"name":        "a",
+		"port":        8443,
+		"ws-opts":     map[string]any{"path": "/old", "headers": map[string]any{"Host": "new.com", "User-Agent": "ua"}},
+		"alpn":        []any{"http/1.1"},
+		"plugin-opts": map[string]any{"mode": "tls"},
+	}
+	if got := DeepMerge(dst, patch); !reflect.DeepEqual(got, want) {
+		t.Errorf("DeepMerge = %v, want %v", got, want)
+	}
+	if dst["port"] != 443 || dst["ws-opts"].(map[string]any)["headers"].(map[string]any)["Host"] != "old.com" {
+		t.Errorf("DeepMerge modified dst: %v", dst)
+	}
+
+	// 后面的 map 覆盖非 map 的值，合并进去的 map 是副本
+	got := DeepMerge(map[string]any{"ws-opts": "broken"}, patch)
+	got["ws-opts"].(map[string]any)["path"] = "/x"
+	if _, ok := patch["ws-opts"].(map[string]any)["path"]; ok {
+		t.Error("DeepMerge shares nested maps with patch")
+	}
+}
+
+// writeTestConfig 把 yaml 写到临时目录，返回路径
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProxiesInjection(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: HK 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: JP 01, type: ss, server: 2.2.2.2, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	injection, err := ParseInjection(`plugin:{plugin: obfs, plugin-opts: {mode: tls, host: bing.com}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, saveOriginal := range []bool{false, true} {
+		st := New(&Config{ConfigPaths: path, Injections: []*Injection{injection}, InjectFilter: "^HK", SaveOriginalConfig: saveOriginal})
+		report, err := st.LoadProxies(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := report.Proxies["HK 01"].Config["plugin"]; (got == "obfs") == saveOriginal {
+			t.Errorf("save original %v: HK 01 saved with plugin %v", saveOriginal, got)
+		}
+		if _, ok := report.Proxies["JP 01"].Config["plugin"]; ok {
+			t.Errorf("inject filter ignored, JP 01: %v", report.Proxies["JP 01"].Config)
+		}
+	}
+}
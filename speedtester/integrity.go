@@ -0,0 +1,74 @@
+package speedtester
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+const (
+	IntegrityOK          = "ok"
+	IntegrityMismatch    = "mismatch"
+	IntegrityFailed      = "failed"
+	IntegrityUnsupported = "unsupported"
+)
+
+// integritySeed 固定种子，保证每次上传的内容一致，便于排查
+var integritySeed = [32]byte{'c', 'l', 'a', 's', 'h', '-', 's', 'p', 'e', 'e', 'd', 't', 'e', 's', 't'}
+
+// NewPatternReader 返回 size 字节确定性的伪随机数据，
+// 和全零数据不同，它能暴露出隧道对分片、压缩处理不当造成的数据损坏
+func NewPatternReader(size int) io.Reader {
+	return io.LimitReader(rand.NewChaCha8(integritySeed), int64(size))
+}
+
+// testUploadIntegrity 上传一段伪随机数据到 /__hash，比较服务端返回的 sha256。
+// 只有自建的 download-server 支持该接口，其他服务器记为 unsupported
+func (st *SpeedTester) testUploadIntegrity(ctx context.Context, proxy constant.Proxy, result *Result) {
+	client := st.createClient(proxy, st.config.Timeout)
+	hasher := sha256.New()
+	body := io.TeeReader(NewPatternReader(st.config.UploadIntegritySize), hasher)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, st.config.UploadServerURL+"/__hash", body)
+	if err != nil {
+		result.UploadIntegrityStatus = IntegrityFailed
+		return
+	}
+	req.ContentLength = int64(st.config.UploadIntegritySize)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		result.UploadIntegrityStatus = IntegrityFailed
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		result.UploadIntegrityStatus = IntegrityUnsupported
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		result.UploadIntegrityStatus = IntegrityFailed
+		return
+	}
+	remote := strings.TrimSpace(string(data))
+	if _, err := hex.DecodeString(remote); err != nil || len(remote) != sha256.Size*2 {
+		result.UploadIntegrityStatus = IntegrityUnsupported
+		return
+	}
+	if remote != hex.EncodeToString(hasher.Sum(nil)) {
+		result.UploadIntegrityStatus = IntegrityMismatch
+		result.Error = fmt.Sprintf("upload integrity mismatch: %d bytes payload corrupted", st.config.UploadIntegritySize)
+		return
+	}
+	result.UploadIntegrity = true
+	result.UploadIntegrityStatus = IntegrityOK
+}
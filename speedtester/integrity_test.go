@@ -0,0 +1,118 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPatternReader(t *testing.T) {
+	a, err := io.ReadAll(NewPatternReader(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(NewPatternReader(4096))
+	if len(a) != 4096 || !bytes.Equal(a, b) {
+		t.Fatalf("pattern is %d bytes, stable %v", len(a), bytes.Equal(a, b))
+	}
+	if bytes.Count(a, []byte{0}) > 64 {
+		t.Error("pattern looks like zero data")
+	}
+	// 短的数据是长数据的前缀
+	short, _ := io.ReadAll(NewPatternReader(100))
+	if !bytes.Equal(short, a[:100]) {
+		t.Error("pattern depends on the size")
+	}
+}
+
+// hashServer 启动一个上传服务器
+func hashServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// hashHandler 模拟 download-server 的 /__hash，corrupt 为 true 时模拟隧道损坏了第 1000 个字节
+func hashHandler(corrupt bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/__hash" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if corrupt && len(body) > 1000 {
+			body[1000] ^= 0xff
+		}
+		sum := sha256.Sum256(body)
+		w.Write([]byte(hex.EncodeToString(sum[:]) + "\n"))
+	}
+}
+
+func TestUploadIntegrity(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  string
+		ok      bool
+	}{
+		{"intact", hashHandler(false), IntegrityOK, true},
+		{"corrupted", hashHandler(true), IntegrityMismatch, false},
+		{"not found", http.NotFound, IntegrityUnsupported, false},
+		// 其他测速服务器可能对任意路径都返回 200 和一个页面
+		{"not a hash", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>ok</html>")) }, IntegrityUnsupported, false},
+		{"server error", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) }, IntegrityFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := hashServer(t, tt.handler)
+			st := New(&Config{ServerURL: server.URL, Timeout: 5 * time.Second, UploadIntegritySize: 1 << 20})
+			result := &Result{}
+			st.testUploadIntegrity(context.Background(), directProxy(t), result)
+			if result.UploadIntegrityStatus != tt.status || result.UploadIntegrity != tt.ok {
+				t.Errorf("status %q, integrity %v, want %q, %v", result.UploadIntegrityStatus, result.UploadIntegrity, tt.status, tt.ok)
+			}
+			if (tt.status == IntegrityMismatch) != (result.Error != "") {
+				t.Errorf("error %q", result.Error)
+			}
+		})
+	}
+
+	st := New(&Config{ServerURL: "http://127.0.0.1:1", Timeout: 5 * time.Second, UploadIntegritySize: 1024})
+	result := &Result{}
+	st.testUploadIntegrity(context.Background(), directProxy(t), result)
+	if result.UploadIntegrityStatus != IntegrityFailed {
+		t.Errorf("unreachable server: status %q", result.UploadIntegrityStatus)
+	}
+}
+
+// Ctrl-C 取消运行时不等到 Timeout 才结束
+func TestUploadIntegrityCanceled(t *testing.T) {
+	server := hashServer(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	})
+	st := New(&Config{ServerURL: server.URL, Timeout: 30 * time.Second, UploadIntegritySize: 1024})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := &Result{}
+	st.testUploadIntegrity(ctx, directProxy(t), result)
+	if elapsed := time.Since(start); elapsed > 5*time.Second || result.UploadIntegrityStatus != IntegrityFailed {
+		t.Errorf("status %q after %s, want failed right after the cancel", result.UploadIntegrityStatus, elapsed)
+	}
+}
@@ -0,0 +1,121 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// 固定了测速服务器地址之后能连通的协议族，记录在 Result.IPFamilyUsed
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// dualStackCache 缓存测速服务器域名的一个 IPv4 和一个 IPv6 地址，缺少任意一种时为 nil
+type dualStackCache struct {
+	mu    sync.Mutex
+	addrs map[string][]netip.Addr
+}
+
+// familyPinnedProxy 拨号到 host 时改成固定的 IP，不再交给节点解析，其他地址照常拨号
+type familyPinnedProxy struct {
+	constant.Proxy
+	host string
+	ip   netip.Addr
+}
+
+func (p *familyPinnedProxy) DialContext(ctx context.Context, metadata *constant.Metadata) (constant.Conn, error) {
+	if metadata.Host == p.host {
+		pinned := *metadata
+		pinned.Host = ""
+		pinned.DstIP = p.ip
+		metadata = &pinned
+	}
+	return p.Proxy.DialContext(ctx, metadata)
+}
+
+// isFamilyFailure 判断延迟探测的失败是否可能是节点出口解析到了隧道不支持的协议族：
+// 超时或网络不可达，拿到了响应的失败不算
+func isFamilyFailure(err error) bool {
+	var statusErr *StatusError
+	if err == nil || errors.As(err, &statusErr) {
+		return false
+	}
+	switch ClassifyError(err) {
+	case ErrorClassDialTimeout, ErrorClassTargetBlocked:
+		return true
+	}
+	return false
+}
+
+// dualStackAddrs 在本地解析 host，同时有 A 和 AAAA 记录时按 IPv4、IPv6 的顺序各返回一个地址
+func (st *SpeedTester) dualStackAddrs(host string) []netip.Addr {
+	st.dualStack.mu.Lock()
+	addrs, ok := st.dualStack.addrs[host]
+	st.dualStack.mu.Unlock()
+	if ok {
+		return addrs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	v4, err4 := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+	v6, err6 := net.DefaultResolver.LookupNetIP(ctx, "ip6", host)
+	if err4 == nil && err6 == nil && len(v4) > 0 && len(v6) > 0 {
+		addrs = []netip.Addr{v4[0].Unmap(), v6[0]}
+	}
+
+	st.dualStack.mu.Lock()
+	st.dualStack.addrs[host] = addrs
+	st.dualStack.mu.Unlock()
+	return addrs
+}
+
+// familyFallback 在延迟测试全部失败时，把测速服务器的域名依次固定成 IPv4 和 IPv6 地址各探测一次，
+// 返回第一个能连通的协议族和固定了地址的节点，都不通或者服务器不是双栈时返回 nil。
+// 有些节点在出口把测速服务器解析成 IPv6，但隧道只转发 IPv4，表现为莫名其妙的超时
+func (st *SpeedTester) familyFallback(ctx context.Context, proxy *CProxy, err error) (*CProxy, string) {
+	if !isFamilyFailure(err) {
+		return nil, ""
+	}
+	u, perr := url.Parse(st.config.DownloadServerURL)
+	if perr != nil {
+		return nil, ""
+	}
+	host := u.Hostname()
+	if _, perr := netip.ParseAddr(host); perr == nil {
+		return nil, ""
+	}
+	for _, ip := range st.dualStackAddrs(host) {
+		pinned := *proxy
+		pinned.Proxy = &familyPinnedProxy{Proxy: proxy.Proxy, host: host, ip: ip}
+		if st.probeOnce(ctx, &pinned) {
+			if ip.Is4() {
+				return &pinned, IPFamilyIPv4
+			}
+			return &pinned, IPFamilyIPv6
+		}
+	}
+	return nil, ""
+}
+
+// probeOnce 发一次延迟探测，拿到 200 响应时返回 true
+func (st *SpeedTester) probeOnce(ctx context.Context, proxy constant.Proxy) bool {
+	client := st.createClient(proxy, st.config.MaxLatency)
+	defer client.CloseIdleConnections()
+	resp, err := getContext(ctx, client, fmt.Sprintf("%s/__down?bytes=0", st.config.DownloadServerURL))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
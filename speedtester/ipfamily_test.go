@@ -0,0 +1,107 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// recordingProxy 记录最后一次拨号的目标，不真正连接
+type recordingProxy struct {
+	constant.Proxy
+	dialed *constant.Metadata
+}
+
+func (p *recordingProxy) DialContext(ctx context.Context, metadata *constant.Metadata) (constant.Conn, error) {
+	p.dialed = metadata
+	return nil, errors.New("not connected")
+}
+
+func TestFamilyPinnedProxyDialContext(t *testing.T) {
+	ip := netip.MustParseAddr("2001:db8::1")
+	inner := &recordingProxy{}
+	proxy := &familyPinnedProxy{Proxy: inner, host: "speed.example.com", ip: ip}
+
+	metadata := &constant.Metadata{Host: "speed.example.com", DstPort: 443}
+	proxy.DialContext(t.Context(), metadata)
+	if inner.dialed.Host != "" || inner.dialed.DstIP != ip || inner.dialed.DstPort != 443 {
+		t.Errorf("dialed %+v, want %s:443 without host", inner.dialed, ip)
+	}
+	if metadata.Host != "speed.example.com" {
+		t.Errorf("caller metadata modified: %+v", metadata)
+	}
+
+	other := &constant.Metadata{Host: "other.example.com", DstPort: 443}
+	proxy.DialContext(t.Context(), other)
+	if inner.dialed != other {
+		t.Errorf("other hosts should be dialed unchanged, got %+v", inner.dialed)
+	}
+}
+
+func TestIsFamilyFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("dial: %w", errors.New("network unreachable")), true},
+		{newStatusError("503 Service Unavailable"), false},
+		{syscall.ECONNREFUSED, false},
+		{errors.New("tls: handshake failure"), false},
+	} {
+		if got := isFamilyFailure(tc.err); got != tc.want {
+			t.Errorf("isFamilyFailure(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestFamilyFallbackWithTypeOverride 在只监听 IPv6 的本地服务器上测试回退，
+// 同时检查 -type-overrides 复制出来的 SpeedTester 共用了地址缓存
+func TestFamilyFallbackWithTypeOverride(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("ipv6 loopback is not available: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	// mihomo 默认不拨 IPv6，真实节点由远端拨号不受影响，测试里的直连节点需要打开
+	allowIPv6(t)
+
+	// -type-overrides 不接受 direct，这里直接构造测试用的直连节点的覆盖
+	timeout := 2 * time.Second
+	overrides := map[constant.AdapterType]*TypeOverride{constant.Direct: {Timeout: &timeout}}
+	port := listener.Addr().(*net.TCPAddr).Port
+	st := New(&Config{
+		ServerURL:     fmt.Sprintf("http://speed.invalid:%d", port),
+		MaxLatency:    2 * time.Second,
+		TypeOverrides: overrides,
+	})
+	// 本地 IPv4 地址上没有监听，只有固定成 IPv6 时能连通
+	st.dualStack.addrs["speed.invalid"] = []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}
+
+	proxy := &CProxy{Proxy: directProxy(t)}
+	tester := st.testerFor(proxy)
+	if tester == st {
+		t.Fatal("type override not applied")
+	}
+	pinned, family := tester.familyFallback(t.Context(), proxy, context.DeadlineExceeded)
+	if pinned == nil || family != IPFamilyIPv6 {
+		t.Fatalf("fallback returned %v %q, want ipv6", pinned, family)
+	}
+
+	if pinned, _ := tester.familyFallback(t.Context(), proxy, newStatusError("404 Not Found")); pinned != nil {
+		t.Error("fallback tried after a status error")
+	}
+}
@@ -0,0 +1,40 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// DetectLocalIP 不经过任何代理获取本机的公网 IP，用来判断节点是否实际上走了直连
+func DetectLocalIP(serverURL string, timeout time.Duration) (string, error) {
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Proxy: nil},
+	}
+	ip, _, err := fetchExitIP(context.Background(), client, serverURL)
+	return ip, err
+}
+
+// checkDirectLeak 比较节点出口 IP 和本机公网 IP，两者相同说明节点没有真正转发流量，
+// 测出来的是本机直连的结果。还没有检测过出口 IP 时会先检测一次
+func (st *SpeedTester) checkDirectLeak(ctx context.Context, proxy constant.Proxy, result *Result) {
+	if result.ExitIP == "" {
+		ip, trace, err := st.detectExitIP(ctx, proxy)
+		if err != nil {
+			return
+		}
+		result.ExitIP = ip
+		result.ForwardedChain, result.ExtraHops = ParseForwardedHops(trace)
+	}
+	result.DirectLeak = sameIP(result.ExitIP, st.config.LocalIP)
+}
+
+func sameIP(a, b string) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	return errA == nil && errB == nil && addrA.Unmap() == addrB.Unmap()
+}
@@ -0,0 +1,63 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSameIP(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"203.0.113.7", "203.0.113.7", true},
+		{"203.0.113.7", "::ffff:203.0.113.7", true},
+		{"2001:db8::1", "2001:0db8:0:0::1", true},
+		{"203.0.113.7", "203.0.113.8", false},
+		{"203.0.113.7", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := sameIP(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameIP(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDetectLocalIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fl=1\nip=198.51.100.4\n"))
+	}))
+	t.Cleanup(server.Close)
+	ip, err := DetectLocalIP(server.URL, 5*time.Second)
+	if err != nil || ip != "198.51.100.4" {
+		t.Errorf("DetectLocalIP = %q, %v", ip, err)
+	}
+}
+
+// 出口 IP 和本机相同的节点标记为直连泄漏，已经测过出口 IP 的节点不再请求 trace
+func TestCheckDirectLeak(t *testing.T) {
+	var traces atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traces.Add(1)
+		w.Write([]byte("ip=127.0.0.1\n"))
+	}))
+	t.Cleanup(server.Close)
+	st := New(&Config{DownloadServerURL: server.URL, Timeout: 5 * time.Second, LocalIP: "::ffff:127.0.0.1"})
+
+	result := &Result{}
+	st.checkDirectLeak(context.Background(), directProxy(t), result)
+	if !result.DirectLeak || result.ExitIP != "127.0.0.1" {
+		t.Errorf("leak %v, exit ip %q", result.DirectLeak, result.ExitIP)
+	}
+
+	result = &Result{ExitIP: "203.0.113.7"}
+	st.checkDirectLeak(context.Background(), directProxy(t), result)
+	if result.DirectLeak || traces.Load() != 1 {
+		t.Errorf("known exit ip: leak %v, %d trace requests", result.DirectLeak, traces.Load())
+	}
+}
@@ -0,0 +1,70 @@
+package speedtester
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lenientProxies 在整个文件解析失败时逐个解析 proxies 下的节点，返回能解析的节点和其余节点的错误（带行号）。
+// 只认块格式的 proxies 列表，proxies 之外的内容出错不影响节点
+func lenientProxies(body []byte) ([]map[string]any, []error) {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	start := -1
+	for i, line := range lines {
+		// proxies: 后面可能跟着注释
+		key, _, _ := strings.Cut(line, " #")
+		if strings.TrimRight(key, " \t") == "proxies:" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return nil, nil
+	}
+
+	// 列表项的缩进以第一项为准，直到下一个顶格的 key 为止
+	itemIndent := ""
+	var items [][2]int
+	for i := start; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := line[:len(line)-len(trimmed)]
+		if indent == "" && !strings.HasPrefix(trimmed, "- ") {
+			break
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if len(items) == 0 {
+				itemIndent = indent
+			}
+			if indent == itemIndent {
+				items = append(items, [2]int{i, i + 1})
+				continue
+			}
+		}
+		if len(items) > 0 {
+			items[len(items)-1][1] = i + 1
+		}
+	}
+
+	var proxies []map[string]any
+	var errs []error
+	for _, item := range items {
+		chunk := strings.Join(lines[item[0]:item[1]], "\n")
+		var decoded []map[string]any
+		if err := yaml.Unmarshal([]byte(chunk), &decoded); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", item[0]+1, err))
+			continue
+		}
+		if len(decoded) != 1 || decoded[0] == nil {
+			errs = append(errs, fmt.Errorf("line %d: not a proxy mapping", item[0]+1))
+			continue
+		}
+		proxies = append(proxies, decoded[0])
+	}
+	return proxies, errs
+}
@@ -0,0 +1,105 @@
+package speedtester
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func proxyNames(proxies []map[string]any) []string {
+	var names []string
+	for _, proxy := range proxies {
+		names = append(names, toString(proxy["name"]))
+	}
+	return names
+}
+
+func errorLines(errs []error) []string {
+	var lines []string
+	for _, err := range errs {
+		line, _, _ := strings.Cut(err.Error(), ":")
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestLenientProxiesFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		names   []string
+		broken  []string
+	}{
+		{"corrupted-entry.yaml", []string{"A", "B", "D"}, []string{"line 12", "line 22"}},
+		{"bad-indent-after.yaml", []string{"A", "B"}, nil},
+		{"crlf.yaml", []string{"A", "C"}, []string{"line 8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "lenient", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 这些文件整体解析都会失败
+			if err := yaml.Unmarshal(body, &RawConfig{}); err == nil {
+				t.Fatal("fixture parses as a whole")
+			}
+			proxies, errs := lenientProxies(body)
+			if got := proxyNames(proxies); !slices.Equal(got, tt.names) {
+				t.Errorf("recovered %v, want %v", got, tt.names)
+			}
+			if got := errorLines(errs); !slices.Equal(got, tt.broken) {
+				t.Errorf("broken entries at %v, want %v (%v)", got, tt.broken, errs)
+			}
+			for _, proxy := range proxies {
+				if server := toString(proxy["server"]); strings.ContainsAny(server, "\r\n") || server == "" {
+					t.Errorf("%s recovered with server %q", proxy["name"], server)
+				}
+			}
+		})
+	}
+}
+
+func TestLenientProxiesComment(t *testing.T) {
+	body := "proxies:  # 机场节点\n  - {name: A, type: ss}\n  - name: B\n\ttype: ss\n"
+	proxies, errs := lenientProxies([]byte(body))
+	if got := proxyNames(proxies); !slices.Equal(got, []string{"A"}) || len(errs) != 1 {
+		t.Errorf("recovered %v, errors %v", got, errs)
+	}
+}
+
+func TestLenientProxiesNoProxies(t *testing.T) {
+	for _, body := range []string{"", "mixed-port: 7890\n\tmode: rule\n", "proxies: [\n", "proxies:\nrules: []\n"} {
+		if proxies, errs := lenientProxies([]byte(body)); len(proxies) != 0 || len(errs) != 0 {
+			t.Errorf("lenientProxies(%q) = %v, %v", body, proxies, errs)
+		}
+	}
+}
+
+func TestLoadProxiesLenient(t *testing.T) {
+	path := filepath.Join("testdata", "lenient", "corrupted-entry.yaml")
+	report, err := New(&Config{ConfigPaths: path}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range report.Proxies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if want := []string{"A", "B", "D"}; !slices.Equal(names, want) {
+		t.Errorf("proxies %v, want %v", names, want)
+	}
+	if len(report.ParseErrors) != 2 || !strings.Contains(report.ParseErrors[0].Error(), path+": line 12") {
+		t.Errorf("parse errors %v", report.ParseErrors)
+	}
+
+	// 一个节点都恢复不了时返回原来的解析错误
+	broken := writeTestConfig(t, "proxies:\n\t- {name: A}\n")
+	if _, err := New(&Config{ConfigPaths: broken}).LoadProxies(false); err == nil {
+		t.Error("unrecoverable config loaded without error")
+	}
+}
@@ -0,0 +1,141 @@
+package speedtester
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 下载测试提前结束的原因，记录在 Result.DownloadStop
+const (
+	DownloadStopStable = "stable"
+	DownloadStopCap    = "cap"
+)
+
+const (
+	// meterInterval 是下载量的采样间隔
+	meterInterval = 250 * time.Millisecond
+	// meterRateWindow 是估算稳定速度用的滑动窗口，长于 TCP 慢启动，又足够反映当前速度
+	meterRateWindow = 3 * time.Second
+	// 滑动窗口速度在 meterStableSpan 内的相对变化小于 meterStableChange 时认为速度已经稳定
+	meterStableSpan   = time.Second
+	meterStableChange = 0.05
+)
+
+type meterSample struct {
+	at    time.Duration
+	bytes int64
+}
+
+// downloadMeter 统计一个节点所有下载连接的总字节数，速度稳定或达到 MaxDownloadBytes 时取消下载。
+// 开始后 MinSampleDuration 之内不会提前结束，避免很快的节点只测到 TCP 慢启动阶段
+type downloadMeter struct {
+	start       time.Time
+	maxBytes    int64
+	minDuration time.Duration
+	cancel      context.CancelFunc
+	bytes       atomic.Int64
+
+	mu      sync.Mutex
+	samples []meterSample
+	reason  string
+	steady  float64
+}
+
+// startDownloadMeter 在配置了 MaxDownloadBytes 时返回计量器和它控制的 ctx，否则原样返回 ctx 和 nil
+func (st *SpeedTester) startDownloadMeter(ctx context.Context) (context.Context, *downloadMeter) {
+	if st.config.MaxDownloadBytes <= 0 {
+		return ctx, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	m := &downloadMeter{
+		start:       time.Now(),
+		maxBytes:    int64(st.config.MaxDownloadBytes),
+		minDuration: st.config.MinSampleDuration,
+		cancel:      cancel,
+	}
+	go m.run(ctx)
+	return ctx, m
+}
+
+// Write 让计量器可以作为 readBody 的 sink
+func (m *downloadMeter) Write(p []byte) (int, error) {
+	if m.bytes.Add(int64(len(p))) >= m.maxBytes && time.Since(m.start) >= m.minDuration {
+		m.stop(DownloadStopCap)
+	}
+	return len(p), nil
+}
+
+func (m *downloadMeter) run(ctx context.Context) {
+	ticker := time.NewTicker(meterInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if reason := m.sample(); reason != "" {
+			m.stop(reason)
+			return
+		}
+	}
+}
+
+// sample 记录一次下载量并更新稳定速度，需要提前结束时返回原因
+func (m *downloadMeter) sample() string {
+	elapsed := time.Since(m.start)
+	total := m.bytes.Load()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, meterSample{at: elapsed, bytes: total})
+	last := len(m.samples) - 1
+	current, ok := m.rateAt(last)
+	m.steady = current
+	if elapsed < m.minDuration {
+		return ""
+	}
+	if total >= m.maxBytes {
+		return DownloadStopCap
+	}
+	previous, previousOK := m.rateAt(last - int(meterStableSpan/meterInterval))
+	if ok && previousOK && current > 0 && math.Abs(current-previous)/current < meterStableChange {
+		return DownloadStopStable
+	}
+	return ""
+}
+
+// rateAt 返回截至第 i 个采样的 meterRateWindow 内的平均速度。
+// 采样时间还不够一个窗口时返回从开始算起的平均速度和 false
+func (m *downloadMeter) rateAt(i int) (float64, bool) {
+	if i < 0 {
+		return 0, false
+	}
+	window := int(meterRateWindow / meterInterval)
+	var from meterSample
+	if i >= window {
+		from = m.samples[i-window]
+	}
+	to := m.samples[i]
+	return float64(to.bytes-from.bytes) / (to.at - from.at).Seconds(), i >= window
+}
+
+func (m *downloadMeter) stop(reason string) {
+	m.mu.Lock()
+	if m.reason == "" {
+		m.reason = reason
+	}
+	m.mu.Unlock()
+	m.cancel()
+}
+
+// finish 结束计量，把稳定速度和提前结束的原因写入 result
+func (m *downloadMeter) finish(result *Result) {
+	m.cancel()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result.SteadySpeed = m.steady
+	result.DownloadStop = m.reason
+}
@@ -0,0 +1,75 @@
+package speedtester
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDownloadMeterRate(t *testing.T) {
+	m := &downloadMeter{}
+	// 每个采样间隔下载 1000 字节，即 4000 字节/秒
+	for i := 1; i <= 20; i++ {
+		m.samples = append(m.samples, meterSample{at: time.Duration(i) * meterInterval, bytes: int64(i) * 1000})
+	}
+	window := int(meterRateWindow / meterInterval)
+	if rate, ok := m.rateAt(window - 1); ok || rate != 4000 {
+		t.Errorf("rateAt before a full window = %g, %v", rate, ok)
+	}
+	if rate, ok := m.rateAt(19); !ok || rate != 4000 {
+		t.Errorf("rateAt after a full window = %g, %v", rate, ok)
+	}
+	if _, ok := m.rateAt(-1); ok {
+		t.Error("rateAt(-1) reported a full window")
+	}
+}
+
+func TestDownloadMeterCap(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		minDuration time.Duration
+		want        string
+	}{
+		{"cap reached", 0, DownloadStopCap},
+		// 最短采样时间之内不提前结束
+		{"within min duration", time.Hour, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m := &downloadMeter{start: time.Now(), maxBytes: 100, minDuration: tc.minDuration, cancel: cancel}
+			m.Write(make([]byte, 60))
+			m.Write(make([]byte, 60))
+			result := &Result{}
+			if tc.want == "" && ctx.Err() != nil {
+				t.Error("download cancelled within -min-sample-duration")
+			}
+			m.finish(result)
+			if result.DownloadStop != tc.want {
+				t.Errorf("DownloadStop = %q, want %q", result.DownloadStop, tc.want)
+			}
+		})
+	}
+}
+
+// 达到 MaxDownloadBytes 后下载提前结束，已经下载的部分照常计算速度
+func TestMaxDownloadBytes(t *testing.T) {
+	server, _ := downloadServer(t)
+	st := New(&Config{
+		DownloadServerURL: server.URL,
+		UploadServerURL:   "http://127.0.0.1:1",
+		DownloadSize:      256 << 20,
+		MaxDownloadBytes:  1 << 20,
+		Timeout:           5 * time.Second,
+		DownloadTimeout:   10 * time.Second,
+		MaxLatency:        5 * time.Second,
+		Concurrent:        1,
+	})
+	result := st.testProxy(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.DownloadStop != DownloadStopCap {
+		t.Errorf("DownloadStop = %q, want %q", result.DownloadStop, DownloadStopCap)
+	}
+	if result.DownloadSpeed <= 0 || result.DownloadSize < 1<<20 || result.DownloadSize >= 256<<20 {
+		t.Errorf("download speed %v, size %v, error %q", result.DownloadSpeed, result.DownloadSize, result.Error)
+	}
+}
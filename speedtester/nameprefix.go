@@ -0,0 +1,88 @@
+package speedtester
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+)
+
+// Config.NamePrefix 的特殊取值，其余取值作为字面前缀加在节点名称前面
+const (
+	// NamePrefixFile 在名称前加上配置文件名和下划线，例如 sub1_香港 01，是默认行为
+	NamePrefixFile = "file"
+	// NamePrefixNone 保留原来的名称
+	NamePrefixNone = "none"
+)
+
+// NameProxy 返回节点在结果里的名称和要保存的配置。加了前缀时配置是名称替换后的副本，
+// 这样输出文件里的 name 和表格里显示的名称一致
+func (st *SpeedTester) NameProxy(name string, proxy *CProxy) (string, map[string]any) {
+	switch prefix := st.config.NamePrefix; prefix {
+	case NamePrefixNone:
+	case "", NamePrefixFile:
+		fileName, _ := getFileNameWithoutExt(st.sourceOf(proxy))
+		name = fileName + "_" + name
+	default:
+		name = prefix + name
+	}
+	config := proxy.Config
+	if original, ok := config["name"].(string); ok && original != name {
+		config = maps.Clone(config)
+		config["name"] = name
+	}
+	return name, config
+}
+
+// SourceConfig 返回名称换回 SourceName 的节点配置，用于和加前缀之前（包括旧版本）保存的配置比较。
+// 没有加前缀时原样返回 ProxyConfig
+func (r *Result) SourceConfig() map[string]any {
+	config := r.ProxyConfig
+	if name, ok := config["name"].(string); ok && r.SourceName != "" && name != r.SourceName {
+		config = maps.Clone(config)
+		config["name"] = r.SourceName
+	}
+	return config
+}
+
+// RenameDuplicates 给和前面的来源重名的节点加上序号（例如 香港 01 2），返回改名后的来源和改名的节点数。
+// 不加文件名前缀时，不同文件里的同名节点会在输出文件里重名，clash 要求节点名唯一
+func RenameDuplicates(sources []map[string]*CProxy) ([]map[string]*CProxy, int) {
+	used := make(map[string]bool)
+	for _, proxies := range sources {
+		for name := range proxies {
+			used[name] = true
+		}
+	}
+	seen := make(map[string]bool)
+	renamed := 0
+	result := make([]map[string]*CProxy, 0, len(sources))
+	for _, proxies := range sources {
+		names := make([]string, 0, len(proxies))
+		for name := range proxies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		kept := make(map[string]*CProxy, len(proxies))
+		for _, name := range names {
+			proxy := proxies[name]
+			if seen[name] {
+				base := name
+				for i := 2; used[name]; i++ {
+					name = fmt.Sprintf("%s %d", base, i)
+				}
+				used[name] = true
+				renamed++
+				renamedProxy := *proxy
+				if proxy.Config != nil {
+					renamedProxy.Config = maps.Clone(proxy.Config)
+					renamedProxy.Config["name"] = name
+				}
+				proxy = &renamedProxy
+			}
+			seen[name] = true
+			kept[name] = proxy
+		}
+		result = append(result, kept)
+	}
+	return result, renamed
+}
@@ -0,0 +1,73 @@
+package speedtester
+
+import (
+	"testing"
+)
+
+func TestNameProxy(t *testing.T) {
+	proxy := &CProxy{Config: map[string]any{"name": "HK 01", "type": "ss"}, Source: "/tmp/sub1.yaml"}
+	for _, tc := range []struct {
+		prefix string
+		want   string
+	}{
+		{NamePrefixFile, "sub1_HK 01"},
+		{"", "sub1_HK 01"},
+		{NamePrefixNone, "HK 01"},
+		{"[A] ", "[A] HK 01"},
+	} {
+		st := New(&Config{NamePrefix: tc.prefix})
+		name, config := st.NameProxy("HK 01", proxy)
+		if name != tc.want || config["name"] != tc.want {
+			t.Errorf("prefix %q: got %q with config name %v, want %q", tc.prefix, name, config["name"], tc.want)
+		}
+	}
+	if proxy.Config["name"] != "HK 01" {
+		t.Errorf("NameProxy modified the source config: %v", proxy.Config)
+	}
+}
+
+func TestResultSourceConfig(t *testing.T) {
+	st := New(&Config{NamePrefix: NamePrefixFile})
+	proxy := &CProxy{Proxy: directProxy(t), Config: map[string]any{"name": "HK 01", "type": "ss"}, Source: "sub1.yaml"}
+	result := st.newResult("HK 01", proxy)
+	if result.ProxyConfig["name"] != "sub1_HK 01" {
+		t.Fatalf("output name %v, want the prefixed name", result.ProxyConfig["name"])
+	}
+	if got := result.SourceConfig(); got["name"] != "HK 01" || got["type"] != "ss" {
+		t.Errorf("SourceConfig() = %v, want the original name", got)
+	}
+	if result.ProxyConfig["name"] != "sub1_HK 01" {
+		t.Errorf("SourceConfig modified ProxyConfig: %v", result.ProxyConfig)
+	}
+
+	legacy := &Result{ProxyConfig: map[string]any{"name": "HK 01"}}
+	if got := legacy.SourceConfig(); got["name"] != "HK 01" {
+		t.Errorf("result without SourceName: SourceConfig() = %v", got)
+	}
+}
+
+func TestRenameDuplicates(t *testing.T) {
+	node := func(name string) *CProxy {
+		return &CProxy{Config: map[string]any{"name": name}}
+	}
+	sources := []map[string]*CProxy{
+		{"HK 01": node("HK 01"), "HK 01 2": node("HK 01 2")},
+		{"HK 01": node("HK 01"), "JP 01": node("JP 01")},
+	}
+	renamedSources, renamed := RenameDuplicates(sources)
+	if renamed != 1 {
+		t.Fatalf("renamed %d nodes, want 1", renamed)
+	}
+	second := renamedSources[1]
+	// "HK 01 2" 已经被第一个文件占用
+	proxy := second["HK 01 3"]
+	if proxy == nil || proxy.Config["name"] != "HK 01 3" {
+		t.Fatalf("duplicate not renamed to HK 01 3: %v", second)
+	}
+	if second["JP 01"] == nil {
+		t.Errorf("unique node dropped: %v", second)
+	}
+	if sources[1]["HK 01"].Config["name"] != "HK 01" {
+		t.Errorf("RenameDuplicates modified the input config")
+	}
+}
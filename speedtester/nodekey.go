@@ -0,0 +1,43 @@
+package speedtester
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// nodeKeyFields 是决定一个物理节点的字段，节点名称不参与计算，
+// 这样同一个节点在不同订阅里改名后仍然能对应上
+var nodeKeyFields = []string{"type", "server", "port", "uuid", "password", "username", "network"}
+
+// NodeKey 根据节点配置生成稳定的标识，用于跨文件、跨运行比较节点
+func NodeKey(config map[string]any) string {
+	if config == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, field := range nodeKeyFields {
+		value := strings.TrimSpace(toString(config[field]))
+		if field == "type" || field == "server" || field == "network" {
+			value = strings.ToLower(value)
+		}
+		sb.WriteString(field)
+		sb.WriteByte('=')
+		sb.WriteString(value)
+		sb.WriteByte(';')
+	}
+	sum := sha1.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ConfigHash 对完整的节点配置求哈希，NodeKey 相同但参数（名称、插件等）被修改过的节点哈希不同
+func ConfigHash(config map[string]any) string {
+	// json 编码时 map 的 key 是有序的，结果稳定
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:8])
+}
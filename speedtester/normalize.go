@@ -0,0 +1,123 @@
+package speedtester
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 订阅里常见的几类问题，NormalizeProxy 返回的修正项就是这些名字，用于按类统计
+const (
+	FixTrimSpace   = "trim-space"
+	FixType        = "type-case"
+	FixInteger     = "int-string"
+	FixBool        = "bool-string"
+	FixWSPath      = "ws-path-slash"
+	FixEmptyField  = "empty-field"
+	FixVmessCipher = "vmess-cipher"
+)
+
+// normalizeTrimKeys 是去掉首尾空白的字段。name 不在其中，改名会让保存的配置和原订阅对不上
+var normalizeTrimKeys = []string{
+	"type", "server", "uuid", "password", "cipher", "network", "sni", "servername",
+	"client-fingerprint", "flow", "obfs", "obfs-password", "protocol", "username",
+}
+
+// normalizeIntKeys 是 mihomo 要求为整数、订阅里却经常写成字符串的字段
+var normalizeIntKeys = []string{"port", "alterId", "version"}
+
+// normalizeBoolKeys 是经常写成 "true"/"false" 字符串的布尔字段
+var normalizeBoolKeys = []string{"tls", "udp", "skip-cert-verify", "tfo", "xudp"}
+
+// normalizeEmptyKeys 是值为空字符串时应当删掉的字段，留着空值有的协议会真的用空 SNI 握手
+var normalizeEmptyKeys = []string{"sni", "servername", "client-fingerprint", "flow", "network"}
+
+// NormalizeProxy 修正节点配置里 mihomo 处理得不一致的常见问题，返回修正后的副本和按顺序列出的修正项：
+//
+//	trim-space     字符串字段首尾的空白
+//	type-case      大小写不对的 type，例如 VMess
+//	int-string     写成字符串的 port、alterId、version，例如 " 443 "、"0"
+//	bool-string    写成字符串的 tls、udp、skip-cert-verify 等
+//	ws-path-slash  ws-opts.path 缺少开头的 /
+//	empty-field    值为空字符串的 sni、servername、client-fingerprint 等
+//	vmess-cipher   vmess 节点没有 cipher 时补上 auto
+//
+// config 本身不会被修改，没有需要修正的地方时返回的副本与原配置相同
+func NormalizeProxy(config map[string]any) (map[string]any, []string) {
+	fixed := make(map[string]any, len(config))
+	for k, v := range config {
+		fixed[k] = v
+	}
+	var fixes []string
+	fix := func(name string) {
+		fixes = append(fixes, name)
+	}
+
+	for _, key := range normalizeTrimKeys {
+		if s, ok := fixed[key].(string); ok && strings.TrimSpace(s) != s {
+			fixed[key] = strings.TrimSpace(s)
+			fix(FixTrimSpace)
+		}
+	}
+	if s, ok := fixed["type"].(string); ok && strings.ToLower(s) != s {
+		fixed["type"] = strings.ToLower(s)
+		fix(FixType)
+	}
+	for _, key := range normalizeIntKeys {
+		s, ok := fixed[key].(string)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			fixed[key] = n
+			fix(FixInteger)
+		}
+	}
+	for _, key := range normalizeBoolKeys {
+		s, ok := fixed[key].(string)
+		if !ok {
+			continue
+		}
+		if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			fixed[key] = b
+			fix(FixBool)
+		}
+	}
+	for _, key := range normalizeEmptyKeys {
+		if s, ok := fixed[key].(string); ok && s == "" {
+			delete(fixed, key)
+			fix(FixEmptyField)
+		}
+	}
+	if wsOpts, ok := fixed["ws-opts"].(map[string]any); ok {
+		if path, ok := wsOpts["path"].(string); ok {
+			trimmed := strings.TrimSpace(path)
+			if trimmed == "" || !strings.HasPrefix(trimmed, "/") {
+				trimmed = "/" + trimmed
+			}
+			if trimmed != path {
+				fixed["ws-opts"] = DeepMerge(wsOpts, map[string]any{"path": trimmed})
+				fix(FixWSPath)
+			}
+		}
+	}
+	if fixed["type"] == "vmess" {
+		if cipher, _ := fixed["cipher"].(string); cipher == "" {
+			fixed["cipher"] = "auto"
+			fix(FixVmessCipher)
+		}
+	}
+	return fixed, fixes
+}
+
+// normalizeProxies 对一组节点配置做 NormalizeProxy，修正次数按修正项累加到 counts
+func normalizeProxies(configs []map[string]any, counts map[string]int) []map[string]any {
+	normalized := make([]map[string]any, len(configs))
+	for i, config := range configs {
+		var fixes []string
+		normalized[i], fixes = NormalizeProxy(config)
+		for _, name := range fixes {
+			counts[name]++
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,136 @@
+package speedtester
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/metacubex/mihomo/adapter"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNormalizeProxy(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		want  string
+		fixes []string
+	}{
+		{
+			"clean",
+			`{name: a, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}`,
+			`{name: a, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}`,
+			nil,
+		},
+		{
+			"port with spaces",
+			`{name: a, type: ss, server: 1.1.1.1, port: " 443 ", cipher: aes-128-gcm, password: p}`,
+			`{name: a, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}`,
+			[]string{FixInteger},
+		},
+		{
+			"vmess from a converter",
+			`{name: "🇭🇰 香港 01 ", type: VMess, server: " hk.example.com", port: "8080", uuid: "00000000-0000-0000-0000-000000000000 ", alterId: "0", cipher: "", tls: "false", network: ws, ws-opts: {path: "ws?ed=2048", headers: {Host: hk.example.com}}}`,
+			`{name: "🇭🇰 香港 01 ", type: vmess, server: hk.example.com, port: 8080, uuid: 00000000-0000-0000-0000-000000000000, alterId: 0, cipher: auto, tls: false, network: ws, ws-opts: {path: "/ws?ed=2048", headers: {Host: hk.example.com}}}`,
+			[]string{FixTrimSpace, FixTrimSpace, FixType, FixInteger, FixInteger, FixBool, FixWSPath, FixVmessCipher},
+		},
+		{
+			"empty sni and fingerprint",
+			`{name: a, type: trojan, server: t.example.com, port: 443, password: p, sni: "", client-fingerprint: "", udp: "TRUE", skip-cert-verify: "0"}`,
+			`{name: a, type: trojan, server: t.example.com, port: 443, password: p, udp: true, skip-cert-verify: false}`,
+			[]string{FixBool, FixBool, FixEmptyField, FixEmptyField},
+		},
+		{
+			"vless empty flow and network",
+			`{name: a, type: vless, server: v.example.com, port: 443, uuid: u, flow: "", network: "", servername: " v.example.com "}`,
+			`{name: a, type: vless, server: v.example.com, port: 443, uuid: u, servername: v.example.com}`,
+			[]string{FixTrimSpace, FixEmptyField, FixEmptyField},
+		},
+		{
+			"empty ws path",
+			`{name: a, type: vmess, server: v.example.com, port: 443, uuid: u, alterId: 0, cipher: auto, network: ws, ws-opts: {path: " "}}`,
+			`{name: a, type: vmess, server: v.example.com, port: 443, uuid: u, alterId: 0, cipher: auto, network: ws, ws-opts: {path: /}}`,
+			[]string{FixWSPath},
+		},
+		{
+			"values left for mihomo to reject",
+			`{name: a, type: ss, server: 1.1.1.1, port: "auto", tls: "maybe", cipher: aes-128-gcm, password: p}`,
+			`{name: a, type: ss, server: 1.1.1.1, port: "auto", tls: "maybe", cipher: aes-128-gcm, password: p}`,
+			nil,
+		},
+		{
+			"cipher only defaulted for vmess",
+			`{name: a, type: trojan, server: t.example.com, port: 443, password: p, cipher: ""}`,
+			`{name: a, type: trojan, server: t.example.com, port: 443, password: p, cipher: ""}`,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw, want map[string]any
+			if err := yaml.Unmarshal([]byte(tt.raw), &raw); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			original := DeepMerge(raw, nil)
+			got, fixes := NormalizeProxy(raw)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("NormalizeProxy =\n%v\nwant\n%v", got, want)
+			}
+			if !slices.Equal(fixes, tt.fixes) {
+				t.Errorf("fixes %v, want %v", fixes, tt.fixes)
+			}
+			if !reflect.DeepEqual(raw, original) {
+				t.Errorf("NormalizeProxy modified its input: %v", raw)
+			}
+			// 再修正一次不会有变化
+			if again, fixes := NormalizeProxy(got); len(fixes) != 0 || !reflect.DeepEqual(again, got) {
+				t.Errorf("second pass fixed %v", fixes)
+			}
+		})
+	}
+}
+
+func TestNormalizeProxyParses(t *testing.T) {
+	// 修正后的配置 mihomo 能直接解析
+	var raw map[string]any
+	yaml.Unmarshal([]byte(`{name: a, type: VMess, server: " 1.1.1.1", port: " 443", uuid: 00000000-0000-0000-0000-000000000000, alterId: "0", network: ws, ws-opts: {path: ws}}`), &raw)
+	fixed, _ := NormalizeProxy(raw)
+	if _, err := adapter.ParseProxy(fixed); err != nil {
+		t.Errorf("normalized config does not parse: %v", err)
+	}
+}
+
+func TestLoadProxiesNormalize(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: A, type: ss, server: " 1.1.1.1", port: "443", cipher: aes-128-gcm, password: p, udp: "true"}
+  - {name: B, type: Trojan, server: 2.2.2.2, port: 443, password: p, sni: ""}
+  - {name: C, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	report, err := New(&Config{ConfigPaths: path}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{FixTrimSpace: 1, FixInteger: 1, FixBool: 1, FixType: 1, FixEmptyField: 1}
+	if !reflect.DeepEqual(report.Normalized, want) {
+		t.Errorf("normalized %v, want %v", report.Normalized, want)
+	}
+	// 保存的是修正后的配置
+	if a := report.Proxies["A"].Config; a["port"] != 443 || a["server"] != "1.1.1.1" || a["udp"] != true {
+		t.Errorf("A saved as %v", a)
+	}
+	if _, ok := report.Proxies["B"].Config["sni"]; ok || report.Proxies["B"].Config["type"] != "trojan" {
+		t.Errorf("B saved as %v", report.Proxies["B"].Config)
+	}
+
+	report, err = New(&Config{ConfigPaths: path, StrictParse: true}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不修正时只有本来就正确的 C 能用
+	if len(report.Normalized) != 0 || len(report.Proxies) != 1 || report.Proxies["C"] == nil {
+		t.Errorf("-strict-parse normalized %v, loaded %d proxies", report.Normalized, len(report.Proxies))
+	}
+}
@@ -0,0 +1,80 @@
+package speedtester
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImplausibleDownload(t *testing.T) {
+	st := New(&Config{MaxPlausibleSpeed: 100 * 1024 * 1024, MinDownloadDuration: 300 * time.Millisecond})
+	tests := []struct {
+		name   string
+		result *Result
+		want   string
+	}{
+		{"normal", &Result{DownloadSize: 1 << 20, DownloadSpeed: 10 * 1024 * 1024, DownloadTime: time.Second}, ""},
+		{"not tested", &Result{DownloadSpeed: 1 << 40}, ""},
+		{"too fast", &Result{DownloadSize: 1 << 30, DownloadSpeed: 200 * 1024 * 1024, DownloadTime: 5 * time.Second}, "download speed 200.00MB/s exceeds 100.00MB/s"},
+		{"too short", &Result{DownloadSize: 1 << 20, DownloadSpeed: 10 * 1024 * 1024, DownloadTime: 120 * time.Millisecond}, "download finished in 120ms, shorter than 300ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := st.implausibleDownload(tt.result); got != tt.want {
+				t.Errorf("implausibleDownload = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 默认上限是 10Gbps，MinDownloadDuration 为 0 时不检查耗时
+	st = New(&Config{})
+	if got := st.implausibleDownload(&Result{DownloadSize: 1, DownloadSpeed: 1e9, DownloadTime: time.Nanosecond}); got != "" {
+		t.Errorf("default limits: %q", got)
+	}
+}
+
+// 结果不可信时加倍下载量重测一次，重测后仍然不可信的记为 Suspect
+func TestSuspectRetest(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		requests []int
+		suspect  string
+	}{
+		{"plausible", Config{}, []int{64 << 10}, ""},
+		{"too short", Config{MinDownloadDuration: time.Hour}, []int{64 << 10, 128 << 10}, "shorter than 1h0m0s"},
+		{"too fast", Config{MaxPlausibleSpeed: 1}, []int{64 << 10, 128 << 10}, "exceeds 1.00B/s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requested := downloadServer(t)
+			config := tt.config
+			config.DownloadServerURL = server.URL
+			config.UploadServerURL = "http://127.0.0.1:1"
+			config.DownloadSize = 64 << 10
+			config.Timeout = 5 * time.Second
+			config.DownloadTimeout = 5 * time.Second
+			config.MaxLatency = 5 * time.Second
+			config.Concurrent = 1
+			result := New(&config).testProxy(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+
+			var downloads []int
+			for _, n := range requested() {
+				if n > 0 {
+					downloads = append(downloads, n)
+				}
+			}
+			if !slices.Equal(downloads, tt.requests) {
+				t.Errorf("download requests %v, want %v", downloads, tt.requests)
+			}
+			if tt.suspect == "" && result.Suspect != "" || !strings.Contains(result.Suspect, tt.suspect) {
+				t.Errorf("Suspect = %q, want %q", result.Suspect, tt.suspect)
+			}
+			if result.DownloadSize != float64(tt.requests[len(tt.requests)-1]) {
+				t.Errorf("DownloadSize = %v, want the retest size", result.DownloadSize)
+			}
+		})
+	}
+}
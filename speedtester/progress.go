@@ -0,0 +1,21 @@
+package speedtester
+
+// Progress 接收节点开始、结束测试的事件，用于展示进度。
+// 每个节点的两个事件各只有一次，测试期间系统睡眠过的节点要等最后重测完才结束。
+// TestProxies 允许传入 nil，库的使用者不需要实现它
+type Progress interface {
+	NodeStarted(name string)
+	NodeFinished(name string, result *Result)
+}
+
+func notifyStarted(progress Progress, name string) {
+	if progress != nil {
+		progress.NodeStarted(name)
+	}
+}
+
+func notifyFinished(progress Progress, name string, result *Result) {
+	if progress != nil {
+		progress.NodeFinished(name, result)
+	}
+}
@@ -0,0 +1,187 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// progressRecorder 记录 Progress 事件，检查每个节点恰好开始、结束一次且同时在测的节点数不超过 NodeConcurrent
+type progressRecorder struct {
+	mu          sync.Mutex
+	started     map[string]int
+	finished    map[string]*Result
+	inFlight    int
+	maxInFlight int
+	errors      []string
+}
+
+func (r *progressRecorder) NodeStarted(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started[name]++
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+}
+
+func (r *progressRecorder) NodeFinished(name string, result *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started[name] == 0 {
+		r.errors = append(r.errors, name+" finished before it started")
+	}
+	if _, ok := r.finished[name]; ok {
+		r.errors = append(r.errors, name+" finished twice")
+	}
+	r.finished[name] = result
+	r.inFlight--
+}
+
+func TestTestProxiesProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	t.Cleanup(server.Close)
+
+	st := New(&Config{
+		ServerURL:      server.URL,
+		FastMode:       true,
+		Timeout:        5 * time.Second,
+		MaxLatency:     5 * time.Second,
+		Concurrent:     1,
+		NodeConcurrent: 2,
+	})
+	proxies := make(map[string]*CProxy)
+	for i := range 5 {
+		proxies[fmt.Sprintf("node %d", i)] = &CProxy{Proxy: directProxy(t)}
+	}
+	recorder := &progressRecorder{started: map[string]int{}, finished: map[string]*Result{}}
+	results := make(map[string]*Result)
+	st.TestProxies(context.Background(), proxies, recorder, func(result *Result) {
+		results[result.SourceName] = result
+	})
+
+	for _, err := range recorder.errors {
+		t.Error(err)
+	}
+	if len(results) != len(proxies) || len(recorder.finished) != len(proxies) {
+		t.Fatalf("%d results, %d finished events, want %d", len(results), len(recorder.finished), len(proxies))
+	}
+	for name := range proxies {
+		if recorder.started[name] != 1 {
+			t.Errorf("%s started %d times", name, recorder.started[name])
+		}
+		if recorder.finished[name] != results[name] {
+			t.Errorf("%s: NodeFinished got a different result than fn", name)
+		}
+	}
+	if recorder.maxInFlight < 1 || recorder.maxInFlight > 2 {
+		t.Errorf("%d nodes in flight at once, NodeConcurrent is 2", recorder.maxInFlight)
+	}
+}
+
+// TestTestProxiesStress 检查大量节点并发测试时每个结果恰好交给 fn 一次，fn 不会被并发调用，
+// 慢的 fn 只会让测试协程等待而不会丢结果
+func TestTestProxiesStress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(rand.IntN(5)) * time.Millisecond)
+	}))
+	t.Cleanup(server.Close)
+
+	st := New(&Config{
+		ServerURL:      server.URL,
+		FastMode:       true,
+		Timeout:        5 * time.Second,
+		MaxLatency:     5 * time.Second,
+		Concurrent:     1,
+		NodeConcurrent: 40,
+	})
+	const n = 200
+	proxies := make(map[string]*CProxy, n)
+	for i := range n {
+		proxies[fmt.Sprintf("node %d", i)] = &CProxy{Proxy: directProxy(t)}
+	}
+
+	var inCallback atomic.Int32
+	seen := make(map[string]int, n)
+	returned := false
+	st.TestProxies(context.Background(), proxies, nil, func(result *Result) {
+		if inCallback.Add(1) != 1 {
+			t.Error("fn called concurrently")
+		}
+		if returned {
+			t.Error("fn called after TestProxies returned")
+		}
+		seen[result.SourceName]++
+		// 比测试慢得多的回调，结果在队列里积压
+		time.Sleep(time.Millisecond)
+		inCallback.Add(-1)
+	})
+	returned = true
+
+	if len(seen) != n {
+		t.Errorf("%d of %d results delivered", len(seen), n)
+	}
+	for name, count := range seen {
+		if count != 1 {
+			t.Errorf("%s delivered %d times", name, count)
+		}
+	}
+}
+
+// ctx 取消后 TestProxies 很快返回，已经交出的结果没有重复，返回之后不再调用 fn
+func TestTestProxiesCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	st := New(&Config{
+		ServerURL:      server.URL,
+		FastMode:       true,
+		Timeout:        5 * time.Second,
+		MaxLatency:     5 * time.Second,
+		Concurrent:     1,
+		NodeConcurrent: 4,
+	})
+	proxies := make(map[string]*CProxy)
+	for i := range 100 {
+		proxies[fmt.Sprintf("node %d", i)] = &CProxy{Proxy: directProxy(t)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	returned := false
+	start := time.Now()
+	st.TestProxies(ctx, proxies, nil, func(result *Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		if returned {
+			t.Error("fn called after TestProxies returned")
+		}
+		seen[result.SourceName]++
+		if len(seen) == 8 {
+			cancel()
+		}
+	})
+	mu.Lock()
+	returned = true
+	mu.Unlock()
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("TestProxies took %s after cancel", elapsed)
+	}
+	if len(seen) < 8 || len(seen) >= len(proxies) {
+		t.Errorf("%d results delivered with cancel after 8", len(seen))
+	}
+	for name, count := range seen {
+		if count != 1 {
+			t.Errorf("%s delivered %d times", name, count)
+		}
+	}
+	cancel()
+}
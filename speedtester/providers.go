@@ -0,0 +1,221 @@
+package speedtester
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProviderFetcher 读取 proxy-provider 的内容，location 是 http 类型的 url 或 file 类型的 path
+type ProviderFetcher interface {
+	Fetch(location string) ([]byte, error)
+}
+
+// ProviderLimits 限制 proxy-provider 的展开。MaxDepth 是允许的嵌套层数，
+// 1 表示只展开配置里直接声明的 provider，0 表示完全不展开；MaxCount 是每个配置最多展开的 provider 数，0 表示不限制
+type ProviderLimits struct {
+	MaxDepth int
+	MaxCount int
+}
+
+// ProviderWarning 记录一个因为达到限制、重复引用或出错而没有展开的 provider
+type ProviderWarning struct {
+	Provider string
+	Location string
+	Reason   string
+}
+
+func (w ProviderWarning) String() string {
+	if w.Location == "" {
+		return fmt.Sprintf("%s: %s", w.Provider, w.Reason)
+	}
+	return fmt.Sprintf("%s (%s): %s", w.Provider, w.Location, w.Reason)
+}
+
+// providerNodes 是一个展开后的 provider，嵌套 provider 的名字用 / 连上上级的名字
+type providerNodes struct {
+	Name    string
+	Mapping map[string]any
+	Proxies []map[string]any
+}
+
+// inlineMapping 把 provider 配置改写成 inline 类型，这样 mihomo 解析时不会再下载一遍，
+// filter、override 等选项仍然生效
+func (p *providerNodes) inlineMapping() map[string]any {
+	mapping := make(map[string]any, len(p.Mapping)+1)
+	for k, v := range p.Mapping {
+		mapping[k] = v
+	}
+	delete(mapping, "url")
+	delete(mapping, "path")
+	mapping["type"] = "inline"
+	mapping["payload"] = p.Proxies
+	return mapping
+}
+
+type providerJob struct {
+	name    string
+	mapping map[string]any
+	depth   int
+}
+
+// expandProviders 按层展开 proxy-provider，provider 的内容里又声明了 provider 时在 MaxDepth 以内继续展开。
+// 同一次展开里已经读取过的地址不会再读，循环引用因此会在第二次出现时停下
+func expandProviders(fetcher ProviderFetcher, providers map[string]map[string]any, limits ProviderLimits) ([]*providerNodes, []ProviderWarning) {
+	var expanded []*providerNodes
+	var warnings []ProviderWarning
+	visited := make(map[string]bool)
+	queue := providerJobs("", providers, 1)
+	for len(queue) > 0 {
+		job := queue[0]
+		queue = queue[1:]
+		kind, _ := job.mapping["type"].(string)
+		location := toString(job.mapping["url"])
+		if kind == "file" {
+			location = toString(job.mapping["path"])
+		}
+		warn := func(format string, args ...any) {
+			warnings = append(warnings, ProviderWarning{Provider: job.name, Location: location, Reason: fmt.Sprintf(format, args...)})
+		}
+		if job.depth > limits.MaxDepth {
+			warn("depth %d exceeds the limit of %d, skipped", job.depth, limits.MaxDepth)
+			continue
+		}
+		if limits.MaxCount > 0 && len(expanded) >= limits.MaxCount {
+			warn("more than %d providers in this source, skipped", limits.MaxCount)
+			continue
+		}
+
+		var proxies []map[string]any
+		var nested map[string]map[string]any
+		switch kind {
+		case "inline":
+			payload, _ := job.mapping["payload"].([]any)
+			for _, item := range payload {
+				if proxy, ok := item.(map[string]any); ok {
+					proxies = append(proxies, proxy)
+				}
+			}
+		case "http", "file":
+			if location == "" {
+				warn("missing url or path")
+				continue
+			}
+			if visited[location] {
+				warn("already fetched in this run, probably a circular reference")
+				continue
+			}
+			visited[location] = true
+			body, err := fetcher.Fetch(location)
+			if err != nil {
+				warn("fetch failed: %v", err)
+				continue
+			}
+			rawCfg := &RawConfig{}
+			if err := yaml.Unmarshal(body, rawCfg); err != nil {
+				warn("parse failed: %v", err)
+				continue
+			}
+			proxies, nested = rawCfg.Proxies, rawCfg.Providers
+		default:
+			warn("unsupported provider type %q", kind)
+			continue
+		}
+		expanded = append(expanded, &providerNodes{Name: job.name, Mapping: job.mapping, Proxies: proxies})
+		queue = append(queue, providerJobs(job.name+"/", nested, job.depth+1)...)
+	}
+	return expanded, warnings
+}
+
+// providerJobs 按名称排序，保证限制生效时跳过的 provider 是确定的
+func providerJobs(prefix string, providers map[string]map[string]any, depth int) []providerJob {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	jobs := make([]providerJob, 0, len(names))
+	for _, name := range names {
+		jobs = append(jobs, providerJob{name: prefix + name, mapping: providers[name], depth: depth})
+	}
+	return jobs
+}
+
+// maxProviderSize 是一个 proxy-provider 内容的大小上限，http 和 file 类型都适用
+const maxProviderSize = 16 << 20
+
+// providerFetcher 是默认的 ProviderFetcher，不会把订阅的请求头带给 provider 的地址
+type providerFetcher struct {
+	client    *http.Client
+	userAgent string
+	// fileDir 是 file 类型的 provider 可以读取的目录，相对路径也相对它解析，和 mihomo 的 safe path 检查一样不能跳出这个目录。
+	// 为空时不读取任何本地文件，远程订阅里的 file provider 因此不会读到本机的文件
+	fileDir string
+}
+
+func newProviderFetcher(userAgent, fileDir string) *providerFetcher {
+	return &providerFetcher{client: &http.Client{Timeout: 30 * time.Second}, userAgent: userAgent, fileDir: fileDir}
+}
+
+func (f *providerFetcher) Fetch(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http") {
+		path, err := f.localPath(location)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return readProvider(file)
+	}
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return readProvider(resp.Body)
+}
+
+// localPath 把 file provider 的 path 解析到 fileDir 下，跳出 fileDir 的路径返回错误
+func (f *providerFetcher) localPath(location string) (string, error) {
+	if f.fileDir == "" {
+		return "", fmt.Errorf("file providers are only allowed in local configs")
+	}
+	path := location
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.fileDir, path)
+	}
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(f.fileDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside %s", location, f.fileDir)
+	}
+	return path, nil
+}
+
+func readProvider(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxProviderSize+1))
+	if err == nil && len(body) > maxProviderSize {
+		err = fmt.Errorf("larger than %d MB", maxProviderSize>>20)
+	}
+	return body, err
+}
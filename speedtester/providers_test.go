@@ -0,0 +1,309 @@
+package speedtester
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mapFetcher 是内存里的 ProviderFetcher，记录每个地址被读取的次数
+type mapFetcher struct {
+	contents map[string]string
+	fetched  map[string]int
+}
+
+func newMapFetcher(contents map[string]string) *mapFetcher {
+	return &mapFetcher{contents: contents, fetched: make(map[string]int)}
+}
+
+func (f *mapFetcher) Fetch(location string) ([]byte, error) {
+	f.fetched[location]++
+	content, ok := f.contents[location]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return []byte(content), nil
+}
+
+func httpProvider(url string) map[string]any {
+	return map[string]any{"type": "http", "url": url}
+}
+
+func expandedNames(expanded []*providerNodes) []string {
+	var names []string
+	for _, nodes := range expanded {
+		names = append(names, nodes.Name)
+	}
+	return names
+}
+
+func warningsContain(warnings []ProviderWarning, provider, reason string) bool {
+	for _, w := range warnings {
+		if w.Provider == provider && strings.Contains(w.Reason, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExpandProvidersCycle(t *testing.T) {
+	fetcher := newMapFetcher(map[string]string{
+		"https://a.example.com": `proxies:
+  - {name: A1, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+proxy-providers:
+  b: {type: http, url: "https://b.example.com"}
+`,
+		"https://b.example.com": `proxies:
+  - {name: B1, type: ss, server: 2.2.2.2, port: 443, cipher: aes-128-gcm, password: p}
+proxy-providers:
+  a: {type: http, url: "https://a.example.com"}
+`,
+	})
+	expanded, warnings := expandProviders(fetcher, map[string]map[string]any{"a": httpProvider("https://a.example.com")}, ProviderLimits{MaxDepth: 10})
+	if got, want := expandedNames(expanded), []string{"a", "a/b"}; !slices.Equal(got, want) {
+		t.Errorf("expanded %v, want %v", got, want)
+	}
+	if !warningsContain(warnings, "a/b/a", "circular reference") {
+		t.Errorf("no circular reference warning: %v", warnings)
+	}
+	for location, count := range fetcher.fetched {
+		if count != 1 {
+			t.Errorf("%s fetched %d times", location, count)
+		}
+	}
+	if len(expanded) == 2 && toString(expanded[1].Proxies[0]["name"]) != "B1" {
+		t.Errorf("a/b proxies = %v", expanded[1].Proxies)
+	}
+}
+
+func TestExpandProvidersDepth(t *testing.T) {
+	contents := map[string]string{
+		"https://1.example.com": "proxy-providers:\n  two: {type: http, url: \"https://2.example.com\"}\n",
+		"https://2.example.com": "proxy-providers:\n  three: {type: http, url: \"https://3.example.com\"}\n",
+		"https://3.example.com": "proxies: []\n",
+	}
+	providers := map[string]map[string]any{"one": httpProvider("https://1.example.com")}
+	tests := []struct {
+		depth   int
+		want    []string
+		skipped string
+	}{
+		{0, nil, "one"},
+		{1, []string{"one"}, "one/two"},
+		{2, []string{"one", "one/two"}, "one/two/three"},
+		{3, []string{"one", "one/two", "one/two/three"}, ""},
+	}
+	for _, tt := range tests {
+		fetcher := newMapFetcher(contents)
+		expanded, warnings := expandProviders(fetcher, providers, ProviderLimits{MaxDepth: tt.depth})
+		if got := expandedNames(expanded); !slices.Equal(got, tt.want) {
+			t.Errorf("depth %d: expanded %v, want %v", tt.depth, got, tt.want)
+		}
+		if tt.skipped != "" && !warningsContain(warnings, tt.skipped, "exceeds the limit") {
+			t.Errorf("depth %d: no depth warning for %s: %v", tt.depth, tt.skipped, warnings)
+		}
+		if tt.skipped == "" && len(warnings) != 0 {
+			t.Errorf("depth %d: unexpected warnings %v", tt.depth, warnings)
+		}
+		// 超过层数的 provider 不会被下载
+		if len(fetcher.fetched) != len(tt.want) {
+			t.Errorf("depth %d: fetched %v", tt.depth, fetcher.fetched)
+		}
+	}
+}
+
+func TestExpandProvidersCount(t *testing.T) {
+	fetcher := newMapFetcher(map[string]string{
+		"https://a.example.com": "proxies: []\n",
+		"https://b.example.com": "proxies: []\n",
+		"https://c.example.com": "proxies: []\n",
+	})
+	providers := map[string]map[string]any{
+		"c": httpProvider("https://c.example.com"),
+		"a": httpProvider("https://a.example.com"),
+		"b": httpProvider("https://b.example.com"),
+	}
+	expanded, warnings := expandProviders(fetcher, providers, ProviderLimits{MaxDepth: 1, MaxCount: 2})
+	// 按名称顺序展开，被跳过的总是排在后面的
+	if got, want := expandedNames(expanded), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("expanded %v, want %v", got, want)
+	}
+	if !warningsContain(warnings, "c", "more than 2 providers") || fetcher.fetched["https://c.example.com"] != 0 {
+		t.Errorf("c not skipped by the count limit: %v, fetched %v", warnings, fetcher.fetched)
+	}
+}
+
+func TestExpandProvidersErrors(t *testing.T) {
+	fetcher := newMapFetcher(map[string]string{
+		"https://bad.example.com": "proxies: [",
+		"./local.yaml":            "proxies:\n  - {name: L1, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}\n",
+	})
+	providers := map[string]map[string]any{
+		"missing":     httpProvider("https://missing.example.com"),
+		"bad":         httpProvider("https://bad.example.com"),
+		"no-url":      {"type": "http"},
+		"compatible":  {"type": "compatible"},
+		"file":        {"type": "file", "path": "./local.yaml"},
+		"inline":      {"type": "inline", "payload": []any{map[string]any{"name": "I1"}, "not a proxy"}},
+		"duplicate-a": httpProvider("https://dup.example.com"),
+		"duplicate-b": httpProvider("https://dup.example.com"),
+	}
+	fetcher.contents["https://dup.example.com"] = "proxies: []\n"
+	expanded, warnings := expandProviders(fetcher, providers, ProviderLimits{MaxDepth: 1})
+	if got, want := expandedNames(expanded), []string{"duplicate-a", "file", "inline"}; !slices.Equal(got, want) {
+		t.Errorf("expanded %v, want %v", got, want)
+	}
+	for provider, reason := range map[string]string{
+		"missing":     "fetch failed: not found",
+		"bad":         "parse failed",
+		"no-url":      "missing url or path",
+		"compatible":  `unsupported provider type "compatible"`,
+		"duplicate-b": "already fetched",
+	} {
+		if !warningsContain(warnings, provider, reason) {
+			t.Errorf("no %q warning for %s: %v", reason, provider, warnings)
+		}
+	}
+	for _, nodes := range expanded {
+		if nodes.Name == "inline" && len(nodes.Proxies) != 1 {
+			t.Errorf("inline proxies = %v", nodes.Proxies)
+		}
+	}
+}
+
+func TestProviderNodesInlineMapping(t *testing.T) {
+	mapping := map[string]any{"type": "http", "url": "https://a.example.com", "path": "./a.yaml", "filter": "HK"}
+	nodes := &providerNodes{Name: "a", Mapping: mapping, Proxies: []map[string]any{{"name": "A1"}}}
+	inline := nodes.inlineMapping()
+	if inline["type"] != "inline" || inline["url"] != nil || inline["path"] != nil || inline["filter"] != "HK" {
+		t.Errorf("inlineMapping = %v", inline)
+	}
+	if mapping["type"] != "http" || mapping["url"] == nil {
+		t.Errorf("inlineMapping modified the provider config: %v", mapping)
+	}
+}
+
+func TestLoadProxiesProviders(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: Local, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+proxy-providers:
+  sub:
+    type: http
+    url: https://sub.example.com
+    filter: HK
+`)
+	fetcher := newMapFetcher(map[string]string{
+		"https://sub.example.com": `proxies:
+  - {name: HK 01, type: ss, server: 2.2.2.2, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: JP 01, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}
+proxy-providers:
+  nested: {type: http, url: "https://nested.example.com"}
+`,
+	})
+	st := New(&Config{ConfigPaths: path, ProviderFetcher: fetcher, ProviderLimits: ProviderLimits{MaxDepth: 1}})
+	report, err := st.LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range report.Proxies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	// provider 的 filter 仍然生效，嵌套的 provider 超过层数没有展开
+	if want := []string{"Local", "[sub] HK 01"}; !slices.Equal(names, want) {
+		t.Errorf("proxies %v, want %v", names, want)
+	}
+	if !warningsContain(report.ProviderWarnings, "sub/nested", "exceeds the limit") {
+		t.Errorf("provider warnings = %v", report.ProviderWarnings)
+	}
+	if fetcher.fetched["https://sub.example.com"] != 1 {
+		t.Errorf("provider fetched %d times, want 1", fetcher.fetched["https://sub.example.com"])
+	}
+	if cfg := report.Proxies["[sub] HK 01"].Config; cfg == nil || cfg["server"] != "2.2.2.2" {
+		t.Errorf("[sub] HK 01 config = %v", cfg)
+	}
+}
+
+func TestProviderFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// 订阅的自定义请求头不会带给 provider，只有 User-Agent
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, r.Header.Get("User-Agent"))
+	}))
+	t.Cleanup(server.Close)
+
+	path := writeTestConfig(t, "proxies: []\n")
+	fetcher := newProviderFetcher("clash.meta", filepath.Dir(path))
+	body, err := fetcher.Fetch(server.URL + "/sub")
+	if err != nil || string(body) != "clash.meta" {
+		t.Errorf("Fetch = %q, %v", body, err)
+	}
+	if _, err := fetcher.Fetch(server.URL + "/gone"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Fetch of a 404 = %v", err)
+	}
+
+	if body, err := fetcher.Fetch(path); err != nil || string(body) != "proxies: []\n" {
+		t.Errorf("Fetch(%s) = %q, %v", path, body, err)
+	}
+	// 相对路径相对配置所在的目录
+	if body, err := fetcher.Fetch("./config.yaml"); err != nil || string(body) != "proxies: []\n" {
+		t.Errorf("Fetch(./config.yaml) = %q, %v", body, err)
+	}
+}
+
+func TestProviderFetcherLocalFiles(t *testing.T) {
+	path := writeTestConfig(t, "proxies: []\n")
+	dir := filepath.Dir(path)
+	fetcher := newProviderFetcher("", dir)
+	for _, location := range []string{"/etc/passwd", "../config.yaml", "sub/../../config.yaml"} {
+		if _, err := fetcher.Fetch(location); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("Fetch(%s) = %v, want outside the config directory", location, err)
+		}
+	}
+	// 远程订阅的 fetcher 不读取任何本地文件
+	if _, err := newProviderFetcher("", "").Fetch(path); err == nil || !strings.Contains(err.Error(), "only allowed in local configs") {
+		t.Errorf("Fetch from a remote config = %v", err)
+	}
+
+	big := filepath.Join(dir, "big.yaml")
+	if err := os.WriteFile(big, make([]byte, maxProviderSize+1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetcher.Fetch(big); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Fetch of an oversized provider = %v", err)
+	}
+}
+
+func TestLoadProxiesRemoteFileProvider(t *testing.T) {
+	secret := writeTestConfig(t, "proxies:\n  - {name: Secret, type: ss, server: 9.9.9.9, port: 443, cipher: aes-128-gcm, password: secret}\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "proxies: []\nproxy-providers:\n  local: {type: file, path: %s}\n", secret)
+	}))
+	t.Cleanup(server.Close)
+
+	report, err := New(&Config{ConfigPaths: server.URL, ProviderLimits: ProviderLimits{MaxDepth: 1}}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Proxies) != 0 {
+		t.Errorf("remote config read a local file provider: %v", report.Proxies)
+	}
+	if !warningsContain(report.ProviderWarnings, "local", "only allowed in local configs") {
+		t.Errorf("provider warnings = %v", report.ProviderWarnings)
+	}
+}
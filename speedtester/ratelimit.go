@@ -0,0 +1,128 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateUnits 是 ParseRate 支持的单位：bps 结尾的按比特、1000 进制，B/s 结尾的按字节、和 ParseByteSize 一样 1024 进制
+var rateUnits = map[string]float64{
+	"bps":  1.0 / 8,
+	"kbps": 1e3 / 8,
+	"mbps": 1e6 / 8,
+	"gbps": 1e9 / 8,
+	"b/s":  1,
+	"kb/s": 1 << 10,
+	"mb/s": 1 << 20,
+	"gb/s": 1 << 30,
+}
+
+// ParseRate 解析 "100Mbps"、"10MB/s" 之类的速率，返回每秒字节数。不带单位时和其他速度参数一样按 MB/s 处理
+func ParseRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := s, "mb/s"
+	if i >= 0 {
+		number, unit = s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	}
+	multiplier, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: unknown unit %q, use bps/Kbps/Mbps/Gbps or B/s/KB/s/MB/s/GB/s", s, unit)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return value * multiplier, nil
+}
+
+// RateLimiter 是所有节点、所有连接共享的令牌桶，限制测速本身占用的总带宽。
+// 令牌可以透支：WaitN 先扣除 n 个令牌，余额为负时等到补足为止，这样单次请求超过桶容量也不会卡住
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitBurst 是桶容量对应的时长，容量至少是一个读缓冲区
+const rateLimitBurst = 100 * time.Millisecond
+
+// NewRateLimiter 返回每秒最多通过 bytesPerSecond 字节的限速器
+func NewRateLimiter(bytesPerSecond float64) *RateLimiter {
+	burst := max(bytesPerSecond*rateLimitBurst.Seconds(), readBufferSize)
+	return &RateLimiter{
+		rate:   bytesPerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve 扣除 n 个令牌，返回需要等待的时间
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// WaitN 等到可以通过 n 字节为止。ctx 结束时提前返回，已经扣除的令牌不退还
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if wait := l.reserve(n); wait > 0 && !sleepContext(ctx, wait) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Write 让限速器可以作为 readBody 的 sink，在每次读到数据后等待
+func (l *RateLimiter) Write(p []byte) (int, error) {
+	if err := l.WaitN(context.Background(), len(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// rateLimitedReader 在每次读之后按读到的字节数等待，用于上传的请求体
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// 一次最多读一个桶的容量，避免 http.Transport 的大缓冲区一次透支很久
+	if limit := int(r.limiter.burst); len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// measureSinks 在 sinks 后面加上限速器，没有配置 -rate-limit 时原样返回
+func (st *SpeedTester) measureSinks(sinks ...io.Writer) []io.Writer {
+	if st.config.RateLimiter != nil {
+		sinks = append(sinks, st.config.RateLimiter)
+	}
+	return sinks
+}
@@ -0,0 +1,133 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"100Mbps", 100e6 / 8},
+		{"1.5 Gbps", 1.5e9 / 8},
+		{"800kbps", 800e3 / 8},
+		{"8bps", 1},
+		{"10MB/s", 10 << 20},
+		{"512KB/s", 512 << 10},
+		{"2mb/s", 2 << 20},
+		{"3", 3 << 20},
+	}
+	for _, tt := range tests {
+		if got, err := ParseRate(tt.s); err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "fast", "10Mbit", "-5Mbps", "0", "1.2.3MB/s"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q) accepted", s)
+		}
+	}
+}
+
+// TestRateLimiterAggregate 多个协程同时取令牌，总吞吐量不超过限速
+func TestRateLimiterAggregate(t *testing.T) {
+	const rate = 1 << 20
+	limiter := NewRateLimiter(rate)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var total atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for limiter.WaitN(ctx, 16*1024) == nil {
+				total.Add(16 * 1024)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	// 最多是桶容量加上这段时间补充的令牌
+	limit := limiter.burst + rate*elapsed.Seconds()
+	if got := float64(total.Load()); got > limit || got < 0.7*rate*elapsed.Seconds() {
+		t.Errorf("%.0f bytes passed in %s, want at most %.0f and close to %.0f B/s", got, elapsed, limit, float64(rate))
+	}
+}
+
+func TestRateLimiterOverdraft(t *testing.T) {
+	limiter := NewRateLimiter(1 << 20)
+	// 一次超过桶容量的请求先透支，等补足后通过，不会一直卡住
+	start := time.Now()
+	if err := limiter.WaitN(context.Background(), 1<<19); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Errorf("512KiB at 1MiB/s with a 100ms burst waited %s, want about 400ms", elapsed)
+	}
+
+	// ctx 结束时不再等待
+	limiter.WaitN(context.Background(), 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := limiter.WaitN(ctx, 10<<20); err != context.DeadlineExceeded {
+		t.Errorf("WaitN after the deadline = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled WaitN took %s", elapsed)
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	const rate = 1 << 20
+	limiter := NewRateLimiter(rate)
+	reader := &rateLimitedReader{ctx: context.Background(), r: NewZeroReader(512 * 1024), limiter: limiter}
+	start := time.Now()
+	var buf bytes.Buffer
+	// 和 http.Transport 一样用大缓冲区读，每次最多读一个桶的容量
+	n, err := io.CopyBuffer(&buf, reader, make([]byte, 4<<20))
+	if err != nil || n != 512*1024 {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+	elapsed := time.Since(start)
+	if want := time.Duration((512*1024 - limiter.burst) / rate * float64(time.Second)); elapsed < want*8/10 {
+		t.Errorf("512KiB read in %s, want at least about %s", elapsed, want)
+	}
+}
+
+// TestRateLimitDownloadStreams 多个下载连接共享限速，测出的总速度不超过限速，结果带有限速标记
+func TestRateLimitDownloadStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("downloads 2MiB at 1MiB/s")
+	}
+	const rate = 1 << 20
+	server, _ := downloadServer(t)
+	st := New(&Config{
+		DownloadServerURL: server.URL,
+		DownloadTimeout:   10 * time.Second,
+		Concurrent:        4,
+		RateLimiter:       NewRateLimiter(rate),
+	})
+	start := time.Now()
+	result := &Result{}
+	st.measureDownload(context.Background(), directProxy(t), 512*1024, result)
+	elapsed := time.Since(start)
+	if result.DownloadSize != 4*512*1024 {
+		t.Fatalf("downloaded %v bytes, want 2MiB", result.DownloadSize)
+	}
+	if aggregate := result.DownloadSize / elapsed.Seconds(); aggregate > rate*1.15 {
+		t.Errorf("aggregate throughput %.0f B/s over a %d B/s cap", aggregate, rate)
+	}
+	if !st.newResult("direct", &CProxy{Proxy: directProxy(t)}).RateLimited {
+		t.Error("result not marked rate-limited")
+	}
+}
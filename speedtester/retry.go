@@ -0,0 +1,67 @@
+package speedtester
+
+import (
+	"context"
+	"time"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+// retryBackoff 是第一次重试前的等待时间，之后每次翻倍
+const retryBackoff = time.Second
+
+// testProxyWithRetries 在节点连不上或下载速度低于 MinSpeed（按来源覆盖后）时最多再测 Config.Retries 次，
+// 返回其中最好的结果。认证失败、TLS 错误这类重测也不会变的错误不重试
+func (st *SpeedTester) testProxyWithRetries(ctx context.Context, name string, proxy *CProxy) *Result {
+	st = st.testerFor(proxy)
+	best := st.testProxy(ctx, name, proxy)
+	best.Attempts = 1
+	backoff := retryBackoff
+	for attempt := 2; attempt <= st.config.Retries+1 && st.shouldRetry(best); attempt++ {
+		if !sleepContext(ctx, backoff) {
+			break
+		}
+		backoff *= 2
+		log.Infoln("%s: retry %d/%d", best.ProxyName, attempt-1, st.config.Retries)
+		result := st.testProxy(ctx, name, proxy)
+		if ctx.Err() != nil {
+			break
+		}
+		result.Attempts = attempt
+		if betterResult(result, best) {
+			best = result
+		} else {
+			best.Attempts = attempt
+		}
+	}
+	return best
+}
+
+// shouldRetry 判断结果是不是可能因为偶发的网络问题失败
+func (st *SpeedTester) shouldRetry(result *Result) bool {
+	if result.Invalid != "" {
+		// 睡眠或时钟跳变的节点会在最后统一重测
+		return false
+	}
+	switch result.ErrorClass {
+	case ErrorClassAuthFailed, ErrorClassTLS, ErrorClassTargetBlocked, ErrorClassSourceBan:
+		return false
+	}
+	if result.Latency == 0 || result.PacketLoss == 100 {
+		return true
+	}
+	downloadTested := !st.config.FastMode && st.config.DownloadSize > 0 && result.Latency <= st.config.MaxLatency
+	return downloadTested && result.DownloadSpeed < st.config.MinSpeed
+}
+
+// betterResult 判断 a 是否比 b 好：连得上的优先，其次下载速度快的，最后延迟低的
+func betterResult(a, b *Result) bool {
+	aReachable, bReachable := a.Latency > 0 && a.PacketLoss < 100, b.Latency > 0 && b.PacketLoss < 100
+	if aReachable != bReachable {
+		return aReachable
+	}
+	if a.DownloadSpeed != b.DownloadSpeed {
+		return a.DownloadSpeed > b.DownloadSpeed
+	}
+	return a.Latency > 0 && (b.Latency == 0 || a.Latency < b.Latency)
+}
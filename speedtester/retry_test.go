@@ -0,0 +1,159 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	st := New(&Config{DownloadSize: 1 << 20, MaxLatency: time.Second, MinSpeed: 1 << 20})
+	fast := New(&Config{FastMode: true, DownloadSize: 1 << 20, MaxLatency: time.Second, MinSpeed: 1 << 20})
+	// -min-download-speed 只决定是否继续测上传，不是可用的标准，不会触发重试
+	strict := New(&Config{DownloadSize: 1 << 20, MaxLatency: time.Second, MinSpeed: 1 << 20, MinDownloadSpeed: 5 << 20})
+	// 来源的 min-speed 更低，按来源的阈值判断
+	lenient := New(&Config{DownloadSize: 1 << 20, MaxLatency: time.Second, MinSpeed: 1 << 20, SourceMinSpeed: map[string]float64{"slow-airport": 512 << 10}}).
+		testerFor(&CProxy{Proxy: directProxy(t), Source: "slow-airport"})
+	reachable := func(speed float64) *Result {
+		return &Result{Latency: 100 * time.Millisecond, DownloadSpeed: speed}
+	}
+	tests := []struct {
+		name   string
+		st     *SpeedTester
+		result *Result
+		want   bool
+	}{
+		{"unreachable", st, &Result{}, true},
+		{"all packets lost", st, &Result{Latency: 100 * time.Millisecond, PacketLoss: 100}, true},
+		{"slow download", st, reachable(1 << 10), true},
+		{"fast download", st, reachable(2 << 20), false},
+		{"below min-download-speed only", strict, reachable(2 << 20), false},
+		{"above the source's min-speed", lenient, reachable(600 << 10), false},
+		{"below the source's min-speed", lenient, reachable(256 << 10), true},
+		// 延迟超过上限时不测下载，速度为 0 不是失败
+		{"latency over limit", st, &Result{Latency: 2 * time.Second}, false},
+		{"fast mode", fast, reachable(0), false},
+		{"fast mode unreachable", fast, &Result{}, true},
+		{"auth failed", st, &Result{ErrorClass: ErrorClassAuthFailed}, false},
+		{"tls", st, &Result{ErrorClass: ErrorClassTLS}, false},
+		{"target blocked", st, &Result{ErrorClass: ErrorClassTargetBlocked}, false},
+		{"source ban", st, &Result{ErrorClass: ErrorClassSourceBan}, false},
+		{"dial timeout", st, &Result{ErrorClass: ErrorClassDialTimeout}, true},
+		{"reset", st, &Result{ErrorClass: ErrorClassReset}, true},
+		{"invalid", st, &Result{Invalid: "clock jump"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.st.shouldRetry(tt.result); got != tt.want {
+				t.Errorf("shouldRetry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetterResult(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		a, b *Result
+		want bool
+	}{
+		{"reachable beats unreachable", &Result{Latency: 900 * ms}, &Result{}, true},
+		{"unreachable loses", &Result{}, &Result{Latency: 900 * ms}, false},
+		{"all packets lost is unreachable", &Result{Latency: 100 * ms, PacketLoss: 100, DownloadSpeed: 9}, &Result{Latency: 900 * ms}, false},
+		{"faster download", &Result{Latency: 900 * ms, DownloadSpeed: 2}, &Result{Latency: 100 * ms, DownloadSpeed: 1}, true},
+		{"slower download", &Result{Latency: 100 * ms, DownloadSpeed: 1}, &Result{Latency: 900 * ms, DownloadSpeed: 2}, false},
+		{"lower latency", &Result{Latency: 100 * ms}, &Result{Latency: 200 * ms}, true},
+		{"equal", &Result{Latency: 100 * ms}, &Result{Latency: 100 * ms}, false},
+		{"both unreachable", &Result{}, &Result{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := betterResult(tt.a, tt.b); got != tt.want {
+				t.Errorf("betterResult = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// flakyServer 的前 failures 次延迟探测返回 503，之后正常，返回探测次数
+func flakyServer(t *testing.T, failures int64) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var probes atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("bytes") == "0" && probes.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &probes
+}
+
+func retryTester(serverURL string, retries int) *SpeedTester {
+	return New(&Config{
+		ServerURL:  serverURL,
+		FastMode:   true,
+		Timeout:    5 * time.Second,
+		MaxLatency: 5 * time.Second,
+		Retries:    retries,
+	})
+}
+
+// 第一次全部探测失败，重试一次后连上，Attempts 记录实际测了几次
+func TestTestProxyWithRetries(t *testing.T) {
+	server, probes := flakyServer(t, 6)
+	start := time.Now()
+	result := retryTester(server.URL, 3).testProxyWithRetries(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.Latency <= 0 || result.Attempts != 2 {
+		t.Errorf("latency %s, attempts %d, want reachable after 2 attempts", result.Latency, result.Attempts)
+	}
+	if probes.Load() != 12 {
+		t.Errorf("got %d probes, want 12", probes.Load())
+	}
+	if elapsed := time.Since(start); elapsed < retryBackoff {
+		t.Errorf("retried after %s, want a %s backoff", elapsed, retryBackoff)
+	}
+
+	// 没有 -retries 时只测一次
+	server, _ = flakyServer(t, 6)
+	if result := retryTester(server.URL, 0).testProxyWithRetries(context.Background(), "direct", &CProxy{Proxy: directProxy(t)}); result.Latency != 0 || result.Attempts != 1 {
+		t.Errorf("without retries: latency %s, attempts %d", result.Latency, result.Attempts)
+	}
+}
+
+// 每次都失败时测满次数，保留最好的结果
+func TestTestProxyWithRetriesExhausted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for two retry backoffs")
+	}
+	server, probes := flakyServer(t, 1000)
+	result := retryTester(server.URL, 2).testProxyWithRetries(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.Latency != 0 || result.Attempts != 3 || probes.Load() != 18 {
+		t.Errorf("latency %s, attempts %d, %d probes", result.Latency, result.Attempts, probes.Load())
+	}
+}
+
+// 等待重试时取消不会再测
+func TestTestProxyWithRetriesCancel(t *testing.T) {
+	server, probes := flakyServer(t, 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for probes.Load() < 6 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+	start := time.Now()
+	result := retryTester(server.URL, 5).testProxyWithRetries(ctx, "direct", &CProxy{Proxy: directProxy(t)})
+	if result.Attempts != 1 || probes.Load() != 6 {
+		t.Errorf("attempts %d, %d probes after cancel", result.Attempts, probes.Load())
+	}
+	if elapsed := time.Since(start); elapsed > retryBackoff {
+		t.Errorf("cancel took %s", elapsed)
+	}
+}
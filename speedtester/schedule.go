@@ -0,0 +1,44 @@
+package speedtester
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+)
+
+// pingInterval 是两次延迟探测之间的基准间隔
+const pingInterval = 100 * time.Millisecond
+
+// probeScheduler 为单个节点生成探测间隔。间隔在 pingInterval 的 ±jitter 范围内随机，
+// 避免并发测试时所有节点按同一节拍发包，互相抬高对方的抖动
+type probeScheduler struct {
+	rng    *rand.Rand
+	jitter float64
+}
+
+// newProbeScheduler 按节点名称生成种子，同一个节点每次运行的探测节奏相同，便于复现
+func (st *SpeedTester) newProbeScheduler(name string) *probeScheduler {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &probeScheduler{
+		rng:    rand.New(rand.NewPCG(h.Sum64(), 0)),
+		jitter: st.config.PingIntervalJitter,
+	}
+}
+
+// workerStartOffset 是第 i 个测试协程取第一个节点前等待的时间，在 pingInterval 内随机，
+// 错开同时开始的节点的探测相位。第一个协程不等待，PingIntervalJitter 为 0 时都不等待
+func (st *SpeedTester) workerStartOffset(i int) time.Duration {
+	if i == 0 || st.config.PingIntervalJitter <= 0 {
+		return 0
+	}
+	return rand.N(pingInterval)
+}
+
+func (s *probeScheduler) next() time.Duration {
+	if s.jitter <= 0 {
+		return pingInterval
+	}
+	factor := 1 + s.jitter*(2*s.rng.Float64()-1)
+	return time.Duration(float64(pingInterval) * factor)
+}
@@ -0,0 +1,111 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/constant"
+)
+
+func TestProbeSchedulerJitter(t *testing.T) {
+	st := New(&Config{PingIntervalJitter: 0.3})
+	a, again, b := st.newProbeScheduler("HK 01"), st.newProbeScheduler("HK 01"), st.newProbeScheduler("HK 02")
+	var seqA, seqAgain, seqB []time.Duration
+	for range 50 {
+		seqA, seqAgain, seqB = append(seqA, a.next()), append(seqAgain, again.next()), append(seqB, b.next())
+	}
+	if !slices.Equal(seqA, seqAgain) {
+		t.Errorf("same node name produced different schedules")
+	}
+	if slices.Equal(seqA, seqB) {
+		t.Errorf("different nodes share a schedule")
+	}
+	for _, d := range append(seqA, seqB...) {
+		if d < 70*time.Millisecond || d > 130*time.Millisecond {
+			t.Fatalf("interval %s outside ±30%% of %s", d, pingInterval)
+		}
+	}
+
+	strict := New(&Config{}).newProbeScheduler("HK 01")
+	for range 10 {
+		if d := strict.next(); d != pingInterval {
+			t.Fatalf("jitter 0 interval = %s", d)
+		}
+	}
+}
+
+func TestWorkerStartOffset(t *testing.T) {
+	st := New(&Config{PingIntervalJitter: 0.3})
+	if d := st.workerStartOffset(0); d != 0 {
+		t.Errorf("first worker waits %s", d)
+	}
+	offsets := make(map[time.Duration]bool)
+	for i := 1; i <= 20; i++ {
+		d := st.workerStartOffset(i)
+		if d < 0 || d >= pingInterval {
+			t.Fatalf("offset %s outside [0, %s)", d, pingInterval)
+		}
+		offsets[d] = true
+	}
+	if len(offsets) < 10 {
+		t.Errorf("only %d distinct offsets for 20 workers", len(offsets))
+	}
+	if d := New(&Config{}).workerStartOffset(3); d != 0 {
+		t.Errorf("jitter 0 offset = %s", d)
+	}
+}
+
+// TestProbesNotPhaseLocked 并发测试 8 个节点，记录服务器收到每个节点第 k 次探测的时间，
+// 同一轮探测在节点之间应当分散开，而不是在同一时刻一起到达
+func TestProbesNotPhaseLocked(t *testing.T) {
+	const nodes = 8
+	var mu sync.Mutex
+	arrivals := make(map[string][]time.Time)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals[r.URL.Path] = append(arrivals[r.URL.Path], time.Now())
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	var wg sync.WaitGroup
+	for i := range nodes {
+		proxy, err := adapter.ParseProxy(map[string]any{"name": fmt.Sprintf("node %d", i), "type": "direct"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("/node%d", i)
+		st := New(&Config{ExtraConnectURL: []string{server.URL + path}, PingIntervalJitter: 0.3})
+		wg.Add(1)
+		go func(proxy constant.Proxy) {
+			defer wg.Done()
+			st.testExtraLatencyAndSpeed(context.Background(), proxy, 5*time.Second)
+		}(proxy)
+	}
+	wg.Wait()
+
+	for round := 2; round < 6; round++ {
+		var first, last time.Time
+		for path, times := range arrivals {
+			if len(times) != 6 {
+				t.Fatalf("%s received %d probes, want 6", path, len(times))
+			}
+			if first.IsZero() || times[round].Before(first) {
+				first = times[round]
+			}
+			if times[round].After(last) {
+				last = times[round]
+			}
+		}
+		if spread := last.Sub(first); spread < 20*time.Millisecond {
+			t.Errorf("probe %d of %d nodes arrived within %s", round+1, nodes, spread)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package speedtester
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serverGeoConcurrency 限制同时进行的服务器地址解析和地理位置查询数量
+const serverGeoConcurrency = 4
+
+// serverCountryCache 缓存服务器地址对应的国家代码，空字符串表示无法解析或定位
+type serverCountryCache struct {
+	mu        sync.Mutex
+	countries map[string]string
+}
+
+// filterByServerCountry 在测试前按节点服务器地址所在的国家筛选节点。
+// 机场的入口和出口大多在同一个国家，这样可以省掉大量注定会被淘汰的测试
+func (st *SpeedTester) filterByServerCountry(proxies map[string]*CProxy, report *LoadReport) map[string]*CProxy {
+	allowed := make(map[string]bool, len(st.config.ServerCountries))
+	for _, country := range st.config.ServerCountries {
+		allowed[strings.ToUpper(strings.TrimSpace(country))] = true
+	}
+
+	servers := make(map[string]bool)
+	for _, proxy := range proxies {
+		servers[toString(proxy.Config["server"])] = true
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, serverGeoConcurrency)
+	for server := range servers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			st.serverCountry(server)
+		}()
+	}
+	wg.Wait()
+
+	kept := make(map[string]*CProxy, len(proxies))
+	for name, proxy := range proxies {
+		country := st.serverCountry(toString(proxy.Config["server"]))
+		switch {
+		case country == "":
+			if st.config.ServerCountriesStrict {
+				report.ServerCountryUnknown++
+				continue
+			}
+		case !allowed[country]:
+			report.ServerCountryFiltered++
+			continue
+		}
+		kept[name] = proxy
+	}
+	return kept
+}
+
+// serverCountry 解析服务器地址并查询其国家，结果会被缓存
+func (st *SpeedTester) serverCountry(server string) string {
+	st.serverCountries.mu.Lock()
+	country, ok := st.serverCountries.countries[server]
+	st.serverCountries.mu.Unlock()
+	if ok {
+		return country
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ip := normalizeServer(server)
+	if net.ParseIP(ip) == nil {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, ip)
+		if err != nil || len(addrs) == 0 {
+			ip = ""
+		} else {
+			ip = addrs[0]
+		}
+	}
+	if ip != "" && st.geoResolver != nil {
+		if info, err := st.geoResolver.Lookup(ctx, ip); err == nil {
+			country = info.CountryCode
+		}
+	}
+
+	st.serverCountries.mu.Lock()
+	st.serverCountries.countries[server] = country
+	st.serverCountries.mu.Unlock()
+	return country
+}
@@ -0,0 +1,70 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fakeGeoResolver 按 IP 返回固定的国家，记录每个 IP 被查询的次数
+type fakeGeoResolver struct {
+	mu        sync.Mutex
+	countries map[string]string
+	lookups   map[string]int
+}
+
+func (r *fakeGeoResolver) Lookup(_ context.Context, ip string) (*GeoInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lookups == nil {
+		r.lookups = make(map[string]int)
+	}
+	r.lookups[ip]++
+	country, ok := r.countries[ip]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &GeoInfo{CountryCode: country}, nil
+}
+
+func TestServerCountries(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: JP 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: JP 02, type: ss, server: 1.1.1.1, port: 8443, cipher: aes-128-gcm, password: p}
+  - {name: US 01, type: ss, server: 2.2.2.2, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: SG 01, type: ss, server: localhost, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: XX 01, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: XX 02, type: ss, server: unresolvable.invalid, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	for _, strict := range []bool{false, true} {
+		resolver := &fakeGeoResolver{countries: map[string]string{"1.1.1.1": "JP", "2.2.2.2": "US", "127.0.0.1": "SG"}}
+		st := New(&Config{ConfigPaths: path, ServerCountries: []string{"jp", " SG"}, ServerCountriesStrict: strict, GeoResolver: resolver})
+		report, err := st.LoadProxies(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"JP 01", "JP 02", "SG 01"}
+		if !strict {
+			want = append(want, "XX 01", "XX 02")
+		}
+		if len(report.Proxies) != len(want) {
+			t.Errorf("strict %v: kept %d proxies, want %v", strict, len(report.Proxies), want)
+		}
+		for _, name := range want {
+			if report.Proxies[name] == nil {
+				t.Errorf("strict %v: %s dropped", strict, name)
+			}
+		}
+		if report.ServerCountryFiltered != 1 {
+			t.Errorf("strict %v: ServerCountryFiltered = %d, want 1", strict, report.ServerCountryFiltered)
+		}
+		if wantUnknown := map[bool]int{false: 0, true: 2}[strict]; report.ServerCountryUnknown != wantUnknown {
+			t.Errorf("strict %v: ServerCountryUnknown = %d, want %d", strict, report.ServerCountryUnknown, wantUnknown)
+		}
+		// 两个节点共用 1.1.1.1，只查询一次
+		if n := resolver.lookups["1.1.1.1"]; n != 1 {
+			t.Errorf("strict %v: 1.1.1.1 looked up %d times", strict, n)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package speedtester
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+
+	"github.com/metacubex/mihomo/common/convert"
+)
+
+// shareLinkSchemes 是 convert.ConvertsV2Ray 能转换的分享链接协议
+var shareLinkSchemes = []string{
+	"vmess", "vless", "ss", "ssr", "trojan", "hysteria", "hysteria2", "hy2", "tuic",
+}
+
+// parseShareLinks 解析 base64 编码或者明文的分享链接订阅（每行一个 vmess://、ss:// 等链接），
+// 转换成和 clash 配置里一样的节点配置，这样保存的配置可以直接用。
+// 内容不是分享链接时 ok 为 false，skipped 是无法转换而被跳过的链接数
+func parseShareLinks(body []byte) (proxies []map[string]any, skipped int, ok bool) {
+	decoded := decodeSubscription(body)
+	links := countShareLinks(decoded)
+	if links == 0 {
+		return nil, 0, false
+	}
+	// 一个节点都转换不出来时 ConvertsV2Ray 返回错误，这时所有链接都算跳过
+	proxies, _ = convert.ConvertsV2Ray(decoded)
+	return proxies, max(links-len(proxies), 0), true
+}
+
+// decodeSubscription 解码 base64 订阅，明文原样返回。convert.DecodeBase64 只认标准字母表，
+// 有的机场用 URL 安全的 base64（- 和 _），这时去掉换行和补位后再按 URL 字母表试一次
+func decodeSubscription(body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	decoded := convert.DecodeBase64(body)
+	if countShareLinks(decoded) > 0 {
+		return decoded
+	}
+	compact := strings.TrimRight(strings.Join(strings.Fields(string(body)), ""), "=")
+	if urlDecoded, err := base64.RawURLEncoding.DecodeString(compact); err == nil {
+		return urlDecoded
+	}
+	return decoded
+}
+
+// countShareLinks 返回解码后的订阅里能识别的分享链接行数
+func countShareLinks(decoded []byte) int {
+	links := 0
+	for _, line := range strings.Split(string(decoded), "\n") {
+		scheme, _, found := strings.Cut(strings.TrimSpace(line), "://")
+		if found && isShareLinkScheme(scheme) {
+			links++
+		}
+	}
+	return links
+}
+
+func isShareLinkScheme(scheme string) bool {
+	scheme = strings.ToLower(scheme)
+	for _, s := range shareLinkSchemes {
+		if scheme == s {
+			return true
+		}
+	}
+	return false
+}
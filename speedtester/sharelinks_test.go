@@ -0,0 +1,114 @@
+package speedtester
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/metacubex/mihomo/adapter"
+)
+
+// shareLinksFixture 是一份分享链接订阅，每种协议一个节点，最后一行无法转换
+func shareLinksFixture() string {
+	vmess := base64.StdEncoding.EncodeToString([]byte(`{"v":"2","ps":"vm","add":"vm.example.com","port":"443","id":"b831381d-6324-4d53-ad4f-8cda48b30811","aid":"0","net":"ws","type":"none","host":"vm.example.com","path":"/ws","tls":"tls"}`))
+	ss := base64.RawURLEncoding.EncodeToString([]byte("aes-128-gcm:pass"))
+	return strings.Join([]string{
+		"vmess://" + vmess,
+		"vless://b831381d-6324-4d53-ad4f-8cda48b30811@vl.example.com:443?encryption=none&security=tls&type=tcp&sni=vl.example.com#vl",
+		"ss://" + ss + "@ss.example.com:8388#ss",
+		"trojan://pass@tj.example.com:443?sni=tj.example.com#tj",
+		"hysteria2://pass@hy.example.com:443?sni=hy.example.com#hy2",
+		"vless://not a link",
+	}, "\n")
+}
+
+func TestParseShareLinks(t *testing.T) {
+	plain := shareLinksFixture()
+	for name, body := range map[string]string{
+		"plain":       plain,
+		"base64":      base64.StdEncoding.EncodeToString([]byte(plain)),
+		"base64 crlf": base64.StdEncoding.EncodeToString([]byte(strings.ReplaceAll(plain, "\n", "\r\n"))) + "\n",
+		"url base64":  base64.RawURLEncoding.EncodeToString([]byte(plain)),
+		"blank lines": "\n\n" + strings.ReplaceAll(plain, "\n", "\n\n") + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			proxies, skipped, ok := parseShareLinks([]byte(body))
+			if !ok {
+				t.Fatal("share links not recognized")
+			}
+			if skipped != 1 {
+				t.Errorf("skipped %d links, want 1", skipped)
+			}
+			var names []string
+			for _, proxy := range proxies {
+				names = append(names, proxy["name"].(string))
+			}
+			if strings.Join(names, ",") != "vm,vl,ss,tj,hy2" {
+				t.Errorf("proxies %v", names)
+			}
+		})
+	}
+}
+
+// TestParseShareLinksRoundTrip 转换出来的配置能直接被 mihomo 解析，保存到输出文件后 clash 也能用
+func TestParseShareLinksRoundTrip(t *testing.T) {
+	proxies, _, _ := parseShareLinks([]byte(shareLinksFixture()))
+	for _, proxy := range proxies {
+		if _, err := adapter.ParseProxy(proxy); err != nil {
+			t.Errorf("%v: %v", proxy["name"], err)
+		}
+		if proxy["server"] == nil || proxy["port"] == nil || proxy["type"] == nil {
+			t.Errorf("incomplete config %v", proxy)
+		}
+	}
+}
+
+func TestParseShareLinksNotLinks(t *testing.T) {
+	for _, body := range []string{
+		"proxies:\n  - {name: a, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}\n",
+		// 节点的字段里出现链接不算分享链接订阅
+		"proxies:\n  - name: a\n    url: vmess://abc\n",
+		"",
+		base64.StdEncoding.EncodeToString([]byte("hello world")),
+		"http://example.com/sub",
+	} {
+		if _, _, ok := parseShareLinks([]byte(body)); ok {
+			t.Errorf("%q parsed as share links", body)
+		}
+	}
+}
+
+func TestLoadProxiesShareLinks(t *testing.T) {
+	path := writeTestConfig(t, base64.StdEncoding.EncodeToString([]byte(shareLinksFixture())))
+	report, err := New(&Config{ConfigPaths: path}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Proxies) != 5 {
+		t.Fatalf("loaded %d proxies, want 5", len(report.Proxies))
+	}
+	for name, proxy := range report.Proxies {
+		if proxy.Config == nil || proxy.Config["server"] == nil {
+			t.Errorf("%s: config %v", name, proxy.Config)
+		}
+	}
+	if len(report.ParseErrors) != 1 || !strings.Contains(report.ParseErrors[0].Error(), "1 malformed share links") {
+		t.Errorf("parse errors %v", report.ParseErrors)
+	}
+	if report.Raw != nil {
+		t.Error("share link subscription kept as raw content to preserve")
+	}
+}
+
+func TestIsShareLinkScheme(t *testing.T) {
+	for _, scheme := range []string{"vmess", "VLESS", "ss", "hy2", "tuic"} {
+		if !isShareLinkScheme(scheme) {
+			t.Errorf("%s not a share link scheme", scheme)
+		}
+	}
+	for _, scheme := range []string{"http", "https", "socks5", ""} {
+		if isShareLinkScheme(scheme) {
+			t.Errorf("%q is a share link scheme", scheme)
+		}
+	}
+}
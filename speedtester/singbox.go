@@ -0,0 +1,326 @@
+package speedtester
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// singBoxField 是 clash 节点字段和 sing-box outbound 字段的对应关系，number 表示 sing-box 里是数字
+type singBoxField struct {
+	clash   string
+	singBox string
+	number  bool
+}
+
+// singBoxTLS 是协议使用 TLS 的方式
+type singBoxTLS int
+
+const (
+	// tlsNone 表示协议本身不使用 TLS
+	tlsNone singBoxTLS = iota
+	// tlsOptional 表示 clash 里用 tls: true 开启
+	tlsOptional
+	// tlsAlways 表示协议总是使用 TLS
+	tlsAlways
+)
+
+// singBoxProtocol 描述一种协议在两边的写法，导出和导入共用，保证两个方向一致
+type singBoxProtocol struct {
+	clashType   string
+	singBoxType string
+	fields      []singBoxField
+	tls         singBoxTLS
+	// sniField 是 clash 里 TLS 服务器名的字段，为空表示不支持
+	sniField string
+	// transport 表示支持 ws、grpc 传输
+	transport bool
+}
+
+var singBoxProtocols = []singBoxProtocol{
+	{
+		clashType:   "ss",
+		singBoxType: "shadowsocks",
+		fields:      []singBoxField{{clash: "cipher", singBox: "method"}, {clash: "password", singBox: "password"}},
+	},
+	{
+		clashType:   "vmess",
+		singBoxType: "vmess",
+		fields:      []singBoxField{{clash: "uuid", singBox: "uuid"}, {clash: "alterId", singBox: "alter_id", number: true}, {clash: "cipher", singBox: "security"}},
+		tls:         tlsOptional,
+		sniField:    "servername",
+		transport:   true,
+	},
+	{
+		clashType:   "vless",
+		singBoxType: "vless",
+		fields:      []singBoxField{{clash: "uuid", singBox: "uuid"}, {clash: "flow", singBox: "flow"}},
+		tls:         tlsOptional,
+		sniField:    "servername",
+		transport:   true,
+	},
+	{
+		clashType:   "trojan",
+		singBoxType: "trojan",
+		fields:      []singBoxField{{clash: "password", singBox: "password"}},
+		tls:         tlsAlways,
+		sniField:    "sni",
+		transport:   true,
+	},
+	{
+		clashType:   "hysteria2",
+		singBoxType: "hysteria2",
+		fields:      []singBoxField{{clash: "password", singBox: "password"}},
+		tls:         tlsAlways,
+		sniField:    "sni",
+	},
+	{
+		clashType:   "tuic",
+		singBoxType: "tuic",
+		fields: []singBoxField{
+			{clash: "uuid", singBox: "uuid"},
+			{clash: "password", singBox: "password"},
+			{clash: "congestion-controller", singBox: "congestion_control"},
+			{clash: "udp-relay-mode", singBox: "udp_relay_mode"},
+		},
+		tls:      tlsAlways,
+		sniField: "sni",
+	},
+	{
+		clashType:   "socks5",
+		singBoxType: "socks",
+		fields:      []singBoxField{{clash: "username", singBox: "username"}, {clash: "password", singBox: "password"}},
+		tls:         tlsOptional,
+	},
+	{
+		clashType:   "http",
+		singBoxType: "http",
+		fields:      []singBoxField{{clash: "username", singBox: "username"}, {clash: "password", singBox: "password"}},
+		tls:         tlsOptional,
+		sniField:    "sni",
+	},
+}
+
+// singBoxIgnoredTypes 是 sing-box 里不是代理节点的 outbound，导入时直接跳过
+var singBoxIgnoredTypes = map[string]bool{
+	"selector": true,
+	"urltest":  true,
+	"direct":   true,
+	"block":    true,
+	"dns":      true,
+}
+
+func singBoxProtocolOf(match func(p *singBoxProtocol) bool) *singBoxProtocol {
+	for i := range singBoxProtocols {
+		if match(&singBoxProtocols[i]) {
+			return &singBoxProtocols[i]
+		}
+	}
+	return nil
+}
+
+func mapOf(m map[string]any, key string) map[string]any {
+	sub, _ := m[key].(map[string]any)
+	return sub
+}
+
+func boolValue(v any) bool {
+	b, _ := v.(bool)
+	return b
+}
+
+// SingBoxOutbound 把 clash 节点转换成 sing-box 的 outbound，不支持的类型和传输返回 false
+func SingBoxOutbound(config map[string]any) (map[string]any, bool) {
+	clashType := toString(config["type"])
+	protocol := singBoxProtocolOf(func(p *singBoxProtocol) bool { return p.clashType == clashType })
+	if protocol == nil {
+		return nil, false
+	}
+	// 带插件的 ss 节点两边的插件参数写法不同，不转换
+	if clashType == "ss" && toString(config["plugin"]) != "" {
+		return nil, false
+	}
+	port, _ := strconv.Atoi(toString(config["port"]))
+	outbound := map[string]any{
+		"type":        protocol.singBoxType,
+		"tag":         toString(config["name"]),
+		"server":      toString(config["server"]),
+		"server_port": port,
+	}
+	for _, field := range protocol.fields {
+		value := toString(config[field.clash])
+		if value == "" {
+			continue
+		}
+		if field.number {
+			n, _ := strconv.Atoi(value)
+			outbound[field.singBox] = n
+		} else {
+			outbound[field.singBox] = value
+		}
+	}
+	if obfs := toString(config["obfs"]); clashType == "hysteria2" && obfs != "" {
+		outbound["obfs"] = map[string]any{"type": obfs, "password": toString(config["obfs-password"])}
+	}
+
+	if protocol.tls == tlsAlways || protocol.tls == tlsOptional && (boolValue(config["tls"]) || mapOf(config, "reality-opts") != nil) {
+		tls := map[string]any{"enabled": true}
+		if protocol.sniField != "" {
+			if serverName := toString(config[protocol.sniField]); serverName != "" {
+				tls["server_name"] = serverName
+			}
+		}
+		if boolValue(config["skip-cert-verify"]) {
+			tls["insecure"] = true
+		}
+		if alpn, ok := config["alpn"].([]any); ok && len(alpn) > 0 {
+			tls["alpn"] = alpn
+		}
+		if fp := toString(config["client-fingerprint"]); fp != "" {
+			tls["utls"] = map[string]any{"enabled": true, "fingerprint": fp}
+		}
+		if reality := mapOf(config, "reality-opts"); reality != nil {
+			tls["reality"] = map[string]any{
+				"enabled":    true,
+				"public_key": toString(reality["public-key"]),
+				"short_id":   toString(reality["short-id"]),
+			}
+		}
+		outbound["tls"] = tls
+	}
+
+	switch network := toString(config["network"]); network {
+	case "", "tcp":
+	case "ws", "grpc":
+		if !protocol.transport {
+			return nil, false
+		}
+		if network == "ws" {
+			opts := mapOf(config, "ws-opts")
+			transport := map[string]any{"type": "ws", "path": toString(opts["path"])}
+			if host := toString(mapOf(opts, "headers")["Host"]); host != "" {
+				transport["headers"] = map[string]any{"Host": host}
+			}
+			outbound["transport"] = transport
+		} else {
+			outbound["transport"] = map[string]any{"type": "grpc", "service_name": toString(mapOf(config, "grpc-opts")["grpc-service-name"])}
+		}
+	default:
+		return nil, false
+	}
+	return outbound, true
+}
+
+// ClashProxyFromSingBox 是 SingBoxOutbound 的反向转换，把 sing-box 的 outbound 转换成 clash 节点配置
+func ClashProxyFromSingBox(outbound map[string]any) (map[string]any, bool) {
+	singBoxType := toString(outbound["type"])
+	protocol := singBoxProtocolOf(func(p *singBoxProtocol) bool { return p.singBoxType == singBoxType })
+	if protocol == nil {
+		return nil, false
+	}
+	if singBoxType == "shadowsocks" && toString(outbound["plugin"]) != "" {
+		return nil, false
+	}
+	port, err := strconv.Atoi(toString(outbound["server_port"]))
+	if err != nil {
+		return nil, false
+	}
+	config := map[string]any{
+		"name":   toString(outbound["tag"]),
+		"type":   protocol.clashType,
+		"server": toString(outbound["server"]),
+		"port":   port,
+	}
+	for _, field := range protocol.fields {
+		value := toString(outbound[field.singBox])
+		if value == "" {
+			continue
+		}
+		if field.number {
+			n, _ := strconv.Atoi(value)
+			config[field.clash] = n
+		} else {
+			config[field.clash] = value
+		}
+	}
+	// mihomo 要求 vmess 写明加密方式和 alterId，sing-box 里省略时分别是 auto 和 0
+	if singBoxType == "vmess" && config["cipher"] == nil {
+		config["cipher"] = "auto"
+	}
+	if singBoxType == "vmess" && config["alterId"] == nil {
+		config["alterId"] = 0
+	}
+	if obfs := mapOf(outbound, "obfs"); singBoxType == "hysteria2" && obfs != nil {
+		config["obfs"] = toString(obfs["type"])
+		config["obfs-password"] = toString(obfs["password"])
+	}
+
+	if tls := mapOf(outbound, "tls"); protocol.tls != tlsNone && boolValue(tls["enabled"]) {
+		if protocol.tls == tlsOptional {
+			config["tls"] = true
+		}
+		if serverName := toString(tls["server_name"]); serverName != "" && protocol.sniField != "" {
+			config[protocol.sniField] = serverName
+		}
+		if boolValue(tls["insecure"]) {
+			config["skip-cert-verify"] = true
+		}
+		if alpn, ok := tls["alpn"].([]any); ok && len(alpn) > 0 {
+			config["alpn"] = alpn
+		}
+		if utls := mapOf(tls, "utls"); boolValue(utls["enabled"]) {
+			if fp := toString(utls["fingerprint"]); fp != "" {
+				config["client-fingerprint"] = fp
+			}
+		}
+		if reality := mapOf(tls, "reality"); boolValue(reality["enabled"]) {
+			config["reality-opts"] = map[string]any{
+				"public-key": toString(reality["public_key"]),
+				"short-id":   toString(reality["short_id"]),
+			}
+		}
+	}
+
+	if transport := mapOf(outbound, "transport"); transport != nil {
+		if !protocol.transport {
+			return nil, false
+		}
+		switch toString(transport["type"]) {
+		case "ws":
+			opts := map[string]any{"path": toString(transport["path"])}
+			if host := toString(mapOf(transport, "headers")["Host"]); host != "" {
+				opts["headers"] = map[string]any{"Host": host}
+			}
+			config["network"] = "ws"
+			config["ws-opts"] = opts
+		case "grpc":
+			config["network"] = "grpc"
+			config["grpc-opts"] = map[string]any{"grpc-service-name": toString(transport["service_name"])}
+		default:
+			return nil, false
+		}
+	}
+	return config, true
+}
+
+// parseSingBoxConfig 解析顶层有 outbounds 数组的 sing-box JSON 配置。内容不是这种配置时 ok 为 false，
+// skipped 是不支持转换的 outbound 数，selector、urltest、direct 等不是节点的 outbound 不计入
+func parseSingBoxConfig(body []byte) (proxies []map[string]any, skipped int, ok bool) {
+	var doc struct {
+		Outbounds []map[string]any `json:"outbounds"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.Outbounds == nil {
+		return nil, 0, false
+	}
+	for _, outbound := range doc.Outbounds {
+		if singBoxIgnoredTypes[toString(outbound["type"])] {
+			continue
+		}
+		config, converted := ClashProxyFromSingBox(outbound)
+		if !converted {
+			skipped++
+			continue
+		}
+		proxies = append(proxies, config)
+	}
+	return proxies, skipped, true
+}
@@ -0,0 +1,132 @@
+package speedtester
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metacubex/mihomo/adapter"
+)
+
+// singBoxFixtures 每种支持的协议一个节点，覆盖 ws/grpc 传输、reality 和 hysteria2 obfs
+func singBoxFixtures() []map[string]any {
+	return []map[string]any{
+		{"name": "ss", "type": "ss", "server": "ss.example.com", "port": 8388, "cipher": "aes-128-gcm", "password": "p"},
+		{"name": "vmess", "type": "vmess", "server": "vm.example.com", "port": 443, "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811", "alterId": 0, "cipher": "auto",
+			"tls": true, "servername": "vm.example.com", "network": "ws", "ws-opts": map[string]any{"path": "/ws", "headers": map[string]any{"Host": "vm.example.com"}}},
+		{"name": "vless", "type": "vless", "server": "vl.example.com", "port": 443, "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811", "flow": "xtls-rprx-vision",
+			"tls": true, "servername": "www.microsoft.com", "client-fingerprint": "chrome",
+			"reality-opts": map[string]any{"public-key": "Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw", "short-id": "6ba85179"}},
+		{"name": "trojan", "type": "trojan", "server": "tj.example.com", "port": 443, "password": "p", "sni": "tj.example.com", "skip-cert-verify": true,
+			"alpn": []any{"h2"}, "network": "grpc", "grpc-opts": map[string]any{"grpc-service-name": "svc"}},
+		{"name": "hysteria2", "type": "hysteria2", "server": "hy.example.com", "port": 443, "password": "p", "sni": "hy.example.com", "obfs": "salamander", "obfs-password": "o"},
+		{"name": "tuic", "type": "tuic", "server": "tu.example.com", "port": 443, "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811", "password": "p",
+			"congestion-controller": "bbr", "udp-relay-mode": "native", "sni": "tu.example.com", "alpn": []any{"h3"}},
+		{"name": "socks5", "type": "socks5", "server": "so.example.com", "port": 1080, "username": "u", "password": "p"},
+		{"name": "http", "type": "http", "server": "ht.example.com", "port": 443, "username": "u", "password": "p", "tls": true, "sni": "ht.example.com"},
+	}
+}
+
+// TestSingBoxRoundTrip 导出成 sing-box 再导入，得到和原来完全相同、同样能测试的节点
+func TestSingBoxRoundTrip(t *testing.T) {
+	for _, config := range singBoxFixtures() {
+		t.Run(config["name"].(string), func(t *testing.T) {
+			outbound, ok := SingBoxOutbound(config)
+			if !ok {
+				t.Fatal("not exported")
+			}
+			// 经过一次 JSON，和从文件读到的一样，数字变成 float64
+			data, err := json.Marshal(map[string]any{"outbounds": []any{outbound}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxies, skipped, ok := parseSingBoxConfig(data)
+			if !ok || skipped != 0 || len(proxies) != 1 {
+				t.Fatalf("re-import: ok %v, skipped %d, %d proxies", ok, skipped, len(proxies))
+			}
+			if !reflect.DeepEqual(proxies[0], config) {
+				t.Errorf("round trip changed the config\n got %v\nwant %v", proxies[0], config)
+			}
+			if NodeKey(proxies[0]) != NodeKey(config) {
+				t.Error("round trip changed the node key")
+			}
+			if _, err := adapter.ParseProxy(proxies[0]); err != nil {
+				t.Errorf("re-imported config rejected by mihomo: %v", err)
+			}
+		})
+	}
+}
+
+func TestSingBoxOutboundUnsupported(t *testing.T) {
+	for _, config := range []map[string]any{
+		{"name": "a", "type": "ss", "server": "a", "port": 1, "cipher": "aes-128-gcm", "password": "p", "plugin": "obfs"},
+		{"name": "b", "type": "vmess", "server": "b", "port": 1, "uuid": "u", "network": "h2"},
+		{"name": "c", "type": "ssh", "server": "c", "port": 22},
+		{"name": "d", "type": "hysteria2", "server": "d", "port": 1, "password": "p", "network": "ws"},
+	} {
+		if outbound, ok := SingBoxOutbound(config); ok {
+			t.Errorf("%v exported as %v", config["name"], outbound)
+		}
+	}
+	for _, outbound := range []map[string]any{
+		{"type": "wireguard", "tag": "a", "server": "a", "server_port": 1},
+		{"type": "vmess", "tag": "b", "server": "b", "server_port": 1, "transport": map[string]any{"type": "httpupgrade"}},
+		{"type": "shadowsocks", "tag": "c", "server": "c", "server_port": 1, "plugin": "obfs-local"},
+		{"type": "trojan", "tag": "d", "server": "d", "server_port": "x"},
+	} {
+		if config, ok := ClashProxyFromSingBox(outbound); ok {
+			t.Errorf("%v imported as %v", outbound["tag"], config)
+		}
+	}
+}
+
+const singBoxConfig = `{
+  "log": {"level": "info"},
+  "outbounds": [
+    {"type": "selector", "tag": "proxy", "outbounds": ["a", "b"]},
+    {"type": "urltest", "tag": "auto", "outbounds": ["a", "b"]},
+    {"type": "shadowsocks", "tag": "a", "server": "1.1.1.1", "server_port": 8388, "method": "aes-128-gcm", "password": "p"},
+    {"type": "vmess", "tag": "b", "server": "2.2.2.2", "server_port": 443, "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811"},
+    {"type": "wireguard", "tag": "wg", "server": "3.3.3.3", "server_port": 51820},
+    {"type": "direct", "tag": "direct"},
+    {"type": "block", "tag": "block"},
+    {"type": "dns", "tag": "dns-out"}
+  ],
+  "route": {"final": "proxy"}
+}`
+
+func TestParseSingBoxConfig(t *testing.T) {
+	proxies, skipped, ok := parseSingBoxConfig([]byte(singBoxConfig))
+	if !ok {
+		t.Fatal("sing-box config not recognized")
+	}
+	// 分组、direct 等不是节点，不算跳过；wireguard 不支持
+	if len(proxies) != 2 || skipped != 1 {
+		t.Fatalf("%d proxies, %d skipped, want 2 and 1", len(proxies), skipped)
+	}
+	// sing-box 里省略的 vmess 加密方式和 alter_id 是 auto 和 0，mihomo 要求写明
+	if proxies[1]["cipher"] != "auto" || proxies[1]["alterId"] != 0 {
+		t.Errorf("vmess cipher %v, alterId %v", proxies[1]["cipher"], proxies[1]["alterId"])
+	}
+
+	for _, body := range []string{"proxies: []\n", `{"inbounds": []}`, "not json", `{"outbounds": "x"}`} {
+		if _, _, ok := parseSingBoxConfig([]byte(body)); ok {
+			t.Errorf("%q parsed as a sing-box config", body)
+		}
+	}
+}
+
+func TestLoadProxiesSingBox(t *testing.T) {
+	path := writeTestConfig(t, singBoxConfig)
+	report, err := New(&Config{ConfigPaths: path}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Proxies) != 2 || report.Proxies["a"] == nil || report.Proxies["b"] == nil {
+		t.Fatalf("loaded %d proxies", len(report.Proxies))
+	}
+	if len(report.ParseErrors) != 1 || !strings.Contains(report.ParseErrors[0].Error(), "1 unsupported sing-box outbounds") {
+		t.Errorf("parse errors %v", report.ParseErrors)
+	}
+}
@@ -0,0 +1,112 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// cloudflareMaxDownloadBytes 是 speed.cloudflare.com/__down 单次请求能返回的最大字节数
+const cloudflareMaxDownloadBytes = 100 * 1000 * 1000
+
+var byteSizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+// ParseByteSize 解析 "52428800"、"50MB"、"1.5GiB" 之类的大小。
+// 和速度的单位保持一致，KB/MB/GB 与 KiB/MiB/GiB 都按 1024 进制计算
+func ParseByteSize(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-'
+	})
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	}
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size := value * multiplier
+	if size > math.MaxInt {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int(size), nil
+}
+
+// FormatByteSize 把字节数格式化成带单位的字符串
+func FormatByteSize(size int) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2fGiB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2fMiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.2fKiB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// maxDownloadRequest 返回测速服务器单次下载请求支持的最大字节数，0 表示不限制
+func (st *SpeedTester) maxDownloadRequest() int {
+	if strings.Contains(st.config.DownloadServerURL, "speed.cloudflare.com") {
+		return cloudflareMaxDownloadBytes
+	}
+	return 0
+}
+
+// splitDownloadSize 把一次下载拆成不超过 max 字节的若干次请求
+func splitDownloadSize(size, max int) []int {
+	if max <= 0 || size <= max {
+		return []int{size}
+	}
+	parts := make([]int, 0, size/max+1)
+	for size > 0 {
+		n := min(size, max)
+		parts = append(parts, n)
+		size -= n
+	}
+	return parts
+}
+
+// downloadChunk 下载 size 字节，超过服务器单次上限时拆成多次顺序请求，字节数和耗时累加。
+// 中途失败时返回已经完成的部分，一个请求都没有成功时返回 nil
+func (st *SpeedTester) downloadChunk(ctx context.Context, proxy constant.Proxy, size int, sinks ...io.Writer) *downloadResult {
+	var total *downloadResult
+	for _, n := range splitDownloadSize(size, st.maxDownloadRequest()) {
+		dr := st.testDownload(ctx, proxy, st.config.DownloadTimeout, fmt.Sprintf("%s/__down?bytes=%d", st.config.DownloadServerURL, n), sinks...)
+		if dr == nil {
+			break
+		}
+		if total == nil {
+			total = &downloadResult{}
+		}
+		total.bytes += dr.bytes
+		total.duration += dr.duration
+		if dr.bytes < int64(n) {
+			// 超时截断了这个请求，剩下的请求多半也一样，用已经下载的部分计算速度
+			break
+		}
+	}
+	return total
+}
@@ -0,0 +1,124 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int
+		err  string
+	}{
+		{"52428800", 52428800, ""},
+		{"50MB", 50 << 20, ""},
+		{"50 mb", 50 << 20, ""},
+		{"1.5GiB", 3 << 29, ""},
+		{"512k", 512 << 10, ""},
+		{"100b", 100, ""},
+		{" 2G ", 2 << 30, ""},
+		{"0", 0, ""},
+		{"50TB", 0, `unknown unit "tb"`},
+		{"MB", 0, `invalid size "MB"`},
+		{"1.2.3MB", 0, `invalid size "1.2.3MB"`},
+		{"99999999999999999999GB", 0, "too large"},
+	} {
+		got, err := ParseByteSize(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("ParseByteSize(%q) error = %v, want %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	for size, want := range map[int]string{
+		512:            "512B",
+		1536:           "1.50KiB",
+		50 << 20:       "50.00MiB",
+		3 << 29:        "1.50GiB",
+		500000000000:   "465.66GiB",
+		(1 << 20) - 1:  "1024.00KiB",
+		1<<30 + 1<<20:  "1.00GiB",
+		(1 << 10) * 10: "10.00KiB",
+	} {
+		if got := FormatByteSize(size); got != want {
+			t.Errorf("FormatByteSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestSplitDownloadSize(t *testing.T) {
+	for _, tc := range []struct {
+		size, max int
+		want      []int
+	}{
+		{50, 0, []int{50}},
+		{50, 100, []int{50}},
+		{100, 100, []int{100}},
+		{250, 100, []int{100, 100, 50}},
+		{300, 100, []int{100, 100, 100}},
+	} {
+		if got := splitDownloadSize(tc.size, tc.max); !slices.Equal(got, tc.want) {
+			t.Errorf("splitDownloadSize(%d, %d) = %v, want %v", tc.size, tc.max, got, tc.want)
+		}
+	}
+	if got := New(&Config{DownloadServerURL: "https://speed.cloudflare.com"}).maxDownloadRequest(); got != cloudflareMaxDownloadBytes {
+		t.Errorf("cloudflare max = %d", got)
+	}
+	if got := New(&Config{DownloadServerURL: "http://127.0.0.1:8080"}).maxDownloadRequest(); got != 0 {
+		t.Errorf("self-hosted max = %d", got)
+	}
+}
+
+// downloadServer 按 /__down?bytes=N 返回 N 个字节，并记录每次请求的字节数
+func downloadServer(t *testing.T) (*httptest.Server, func() []int) {
+	t.Helper()
+	var mu sync.Mutex
+	var requested []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		mu.Lock()
+		requested = append(requested, n)
+		mu.Unlock()
+		w.Write(make([]byte, n))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requested)
+	}
+}
+
+func TestDownloadChunk(t *testing.T) {
+	server, requested := downloadServer(t)
+	st := New(&Config{DownloadServerURL: server.URL, DownloadTimeout: 5 * time.Second})
+	result := st.downloadChunk(context.Background(), directProxy(t), 1<<20)
+	if result == nil || result.bytes != 1<<20 || result.duration <= 0 {
+		t.Fatalf("result = %+v", result)
+	}
+	if got := requested(); !slices.Equal(got, []int{1 << 20}) {
+		t.Errorf("requests = %v", got)
+	}
+
+	failing, _ := downloadServer(t)
+	failing.Close()
+	st = New(&Config{DownloadServerURL: failing.URL, DownloadTimeout: 5 * time.Second})
+	if result := st.downloadChunk(context.Background(), directProxy(t), 1<<20); result != nil {
+		t.Errorf("failed download returned %+v", result)
+	}
+}
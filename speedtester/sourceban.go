@@ -0,0 +1,149 @@
+package speedtester
+
+import (
+	"time"
+
+	"github.com/metacubex/mihomo/log"
+)
+
+// ErrorClassSourceBan 是因为怀疑订阅来源封禁了本机 IP 而没有测试的节点的错误类别
+const ErrorClassSourceBan = "source-ban"
+
+// 来源在一次运行里的状态：正常测试、冷却中、正在用一个节点试探、判定为封禁
+type banPhase int
+
+const (
+	banActive banPhase = iota
+	banPaused
+	banProbing
+	banSuspected
+)
+
+// banAdmission 是调度器对某个来源下一个节点的处理方式
+type banAdmission int
+
+const (
+	admitRun banAdmission = iota
+	admitWait
+	admitSkip
+)
+
+type sourceState struct {
+	phase     banPhase
+	succeeded bool
+	failures  int
+	resumeAt  time.Time
+	// canary 是冷却结束后用来试探的节点，只有它的结果决定来源是否恢复
+	canary string
+}
+
+// sourceBans 按来源统计连续失败的节点数。来源之前有节点测通过、之后连续 streak 个节点都连不上时，
+// 多半是机场临时封了本机 IP：暂停这个来源 cooldown，再用一个节点试探，通了就继续，不通就跳过剩下的节点。
+// 只记录状态不做等待，时间由调用方传入，同样的结果序列总是得到同样的判定
+type sourceBans struct {
+	streak   int
+	cooldown time.Duration
+	sources  map[string]*sourceState
+}
+
+func newSourceBans(streak int, cooldown time.Duration) *sourceBans {
+	return &sourceBans{streak: streak, cooldown: cooldown, sources: make(map[string]*sourceState)}
+}
+
+func (b *sourceBans) state(source string) *sourceState {
+	s, ok := b.sources[source]
+	if !ok {
+		s = &sourceState{}
+		b.sources[source] = s
+	}
+	return s
+}
+
+// admit 返回 source 的下一个节点现在能否开始测试
+func (b *sourceBans) admit(source string, now time.Time) banAdmission {
+	if b.streak <= 0 {
+		return admitRun
+	}
+	s := b.state(source)
+	switch s.phase {
+	case banPaused:
+		if now.Before(s.resumeAt) {
+			return admitWait
+		}
+		return admitRun
+	case banProbing:
+		return admitWait
+	case banSuspected:
+		return admitSkip
+	}
+	return admitRun
+}
+
+// dispatched 记录 source 的节点 name 开始测试，冷却结束后的第一个节点就是试探节点
+func (b *sourceBans) dispatched(source, name string) {
+	if b.streak <= 0 {
+		return
+	}
+	if s := b.state(source); s.phase == banPaused {
+		s.phase = banProbing
+		s.canary = name
+		log.Warnln("source %s: cooldown over, probing with %s", source, name)
+	}
+}
+
+// observe 记录节点的测试结果。冷却和试探期间其他节点的结果是封禁前就开始测的，不参与判定
+func (b *sourceBans) observe(source, name string, failed bool, now time.Time) {
+	if b.streak <= 0 {
+		return
+	}
+	s := b.state(source)
+	switch s.phase {
+	case banActive:
+		if !failed {
+			s.succeeded = true
+			s.failures = 0
+			return
+		}
+		s.failures++
+		if s.succeeded && s.failures >= b.streak {
+			s.phase = banPaused
+			s.resumeAt = now.Add(b.cooldown)
+			log.Warnln("source %s: %d nodes failed in a row, ban suspected, pause for %s", source, s.failures, b.cooldown)
+		}
+	case banProbing:
+		if name != s.canary {
+			return
+		}
+		if failed {
+			s.phase = banSuspected
+			log.Warnln("source %s: probe %s failed, skip the remaining nodes", source, name)
+			return
+		}
+		s.phase = banActive
+		s.failures = 0
+	}
+}
+
+// nextResume 返回冷却中的来源里最早恢复的时间，没有冷却中的来源时返回零值
+func (b *sourceBans) nextResume() time.Time {
+	var next time.Time
+	for _, s := range b.sources {
+		if s.phase == banPaused && (next.IsZero() || s.resumeAt.Before(next)) {
+			next = s.resumeAt
+		}
+	}
+	return next
+}
+
+// banFailed 判断结果是否算作来源的一次失败：延迟测试一次都没有通
+func banFailed(result *Result) bool {
+	return result.Latency == 0
+}
+
+// skippedResult 是因为来源疑似封禁而没有测试的节点的结果
+func (st *SpeedTester) skippedResult(job testJob) *Result {
+	result := st.newResult(job.name, job.proxy)
+	result.Error = "skipped: source ban suspected"
+	result.ErrorClass = ErrorClassSourceBan
+	return result
+}
@@ -0,0 +1,189 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+)
+
+func TestSourceBansScripted(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newSourceBans(3, 5*time.Minute)
+	run := func(source, name string, failed bool, at time.Duration) {
+		t.Helper()
+		if got := b.admit(source, start.Add(at)); got != admitRun {
+			t.Fatalf("%s at %s: admit %d, want run", name, at, got)
+		}
+		b.dispatched(source, name)
+		b.observe(source, name, failed, start.Add(at))
+	}
+	phase := func() banPhase { return b.state("a").phase }
+
+	// 还没有节点测通时连续失败不算封禁，可能整个订阅本来就不能用
+	for i := range 5 {
+		run("a", "early", true, time.Duration(i)*time.Second)
+	}
+	if phase() != banActive {
+		t.Fatalf("source without successes paused")
+	}
+
+	// 测通一个之后失败重新计数，中间成功一次会清零
+	run("a", "ok 1", false, 10*time.Second)
+	run("a", "f1", true, 11*time.Second)
+	run("a", "f2", true, 12*time.Second)
+	run("a", "ok 2", false, 13*time.Second)
+	run("a", "f3", true, 14*time.Second)
+	run("a", "f4", true, 15*time.Second)
+	if phase() != banActive {
+		t.Fatal("paused after 2 failures")
+	}
+	run("a", "f5", true, 16*time.Second)
+	if phase() != banPaused || !b.nextResume().Equal(start.Add(16*time.Second+5*time.Minute)) {
+		t.Fatalf("phase %d, resume at %s", phase(), b.nextResume())
+	}
+
+	// 冷却期间等待，其他来源照常
+	if got := b.admit("a", start.Add(time.Minute)); got != admitWait {
+		t.Errorf("admit during cooldown = %d", got)
+	}
+	if got := b.admit("b", start.Add(time.Minute)); got != admitRun {
+		t.Errorf("other source admit = %d", got)
+	}
+	// 封禁前就开始测的节点结果不参与判定
+	b.observe("a", "in flight", false, start.Add(time.Minute))
+	if phase() != banPaused {
+		t.Error("in-flight result ended the cooldown")
+	}
+
+	// 冷却结束后第一个节点是试探节点，它测完之前同来源的其他节点等待
+	resume := 16*time.Second + 5*time.Minute
+	if got := b.admit("a", start.Add(resume)); got != admitRun {
+		t.Fatalf("admit after cooldown = %d", got)
+	}
+	b.dispatched("a", "canary")
+	if got := b.admit("a", start.Add(resume)); got != admitWait || phase() != banProbing {
+		t.Errorf("admit while probing = %d, phase %d", got, phase())
+	}
+	b.observe("a", "other", true, start.Add(resume))
+	if phase() != banProbing {
+		t.Error("non-canary result decided the probe")
+	}
+	if !b.nextResume().IsZero() {
+		t.Error("probing source still reported as paused")
+	}
+
+	// 试探通过后恢复，失败重新计数
+	b.observe("a", "canary", false, start.Add(resume+time.Second))
+	if phase() != banActive || b.state("a").failures != 0 {
+		t.Fatalf("after successful probe: phase %d, failures %d", phase(), b.state("a").failures)
+	}
+
+	// 再次封禁，这次试探失败，剩下的节点都跳过
+	for i := range 3 {
+		run("a", "g", true, resume+time.Duration(10+i)*time.Second)
+	}
+	later := resume + 20*time.Minute
+	run("a", "canary 2", true, later)
+	if phase() != banSuspected {
+		t.Fatalf("phase %d after failed probe", phase())
+	}
+	if got := b.admit("a", start.Add(later+time.Hour)); got != admitSkip {
+		t.Errorf("admit after failed probe = %d", got)
+	}
+}
+
+func TestSourceBansDisabled(t *testing.T) {
+	b := newSourceBans(0, time.Minute)
+	now := time.Now()
+	b.observe("a", "ok", false, now)
+	for range 100 {
+		b.observe("a", "f", true, now)
+	}
+	if b.admit("a", now) != admitRun || len(b.sources) != 0 {
+		t.Error("disabled tracker paused a source")
+	}
+}
+
+func TestSourceBansNextResume(t *testing.T) {
+	start := time.Now()
+	b := newSourceBans(1, time.Minute)
+	for i, source := range []string{"a", "b", "c"} {
+		b.observe(source, "ok", false, start)
+		if source != "c" {
+			b.observe(source, "f", true, start.Add(time.Duration(2-i)*time.Second))
+		}
+	}
+	if got := b.nextResume(); !got.Equal(start.Add(time.Minute + time.Second)) {
+		t.Errorf("nextResume = %s, want the earliest paused source", got.Sub(start))
+	}
+}
+
+// 按脚本顺序跑 runJobs：来源 a 测通一个后连续失败，冷却结束后试探失败，剩下的节点跳过；来源 b 不受影响
+func TestRunJobsSourceBan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	dead, err := adapter.ParseProxy(map[string]any{"name": "dead", "type": "socks5", "server": "127.0.0.1", "port": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		canary  bool
+		skipped []string
+	}{
+		{"probe fails", false, []string{"a6", "a7"}},
+		{"probe passes", true, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			st := New(&Config{
+				ServerURL:       server.URL,
+				FastMode:        true,
+				Timeout:         2 * time.Second,
+				MaxLatency:      2 * time.Second,
+				Concurrent:      1,
+				NodeConcurrent:  1,
+				SourceBanStreak: 3,
+				SourceCooldown:  50 * time.Millisecond,
+			})
+			job := func(name, source string, ok bool) testJob {
+				proxy := &CProxy{Proxy: dead, Source: source}
+				if ok {
+					proxy.Proxy = directProxy(t)
+				}
+				return testJob{name: name, proxy: proxy}
+			}
+			jobs := []testJob{
+				job("a1", "a", true), job("a2", "a", false), job("a3", "a", false), job("a4", "a", false),
+				job("a5", "a", tt.canary), job("a6", "a", false), job("a7", "a", false), job("b1", "b", true),
+			}
+			var order []string
+			skipped := map[string]bool{}
+			st.runJobs(context.Background(), jobs, nil, func(job testJob, result *Result) {
+				order = append(order, job.name)
+				if result.ErrorClass == ErrorClassSourceBan {
+					skipped[job.name] = true
+				}
+			})
+			if len(order) != len(jobs) {
+				t.Fatalf("got results for %v", order)
+			}
+			// 冷却期间 b1 先测
+			if order[4] != "b1" || order[5] != "a5" {
+				t.Errorf("result order %v, want b1 tested during the cooldown and a5 as the probe", order)
+			}
+			if len(skipped) != len(tt.skipped) {
+				t.Errorf("skipped %v, want %v", skipped, tt.skipped)
+			}
+			for _, name := range tt.skipped {
+				if !skipped[name] {
+					t.Errorf("%s not skipped", name)
+				}
+			}
+		})
+	}
+}
@@ -2,16 +2,18 @@ package speedtester
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
+	"net/netip"
 	"net/url"
 	"path/filepath"
 	"net/http"
 	"os"
 	"regexp"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -28,42 +30,190 @@ type Config struct {
 	FilterRegex      string
 	BlockRegex       string
 	ServerURL        string
+	// DownloadServerURL 和 UploadServerURL 为空时使用 ServerURL，延迟测试使用下载服务器
+	DownloadServerURL string
+	UploadServerURL   string
+	// UploadFallbackURL 是所有上传连接都失败时再试一次的上传服务器，为空表示不重试
+	UploadFallbackURL string
 	DownloadSize     int
 	UploadSize       int
+	// UploadChunkSize 是上传测试单个 POST 请求体的大小，UploadSize 分成多个请求依次上传，为 0 时使用 1MB
+	UploadChunkSize  int
 	Timeout          time.Duration
+	// DownloadTimeout 是下载和上传测试单个请求的超时，延迟探测仍然使用较短的 Timeout，为 0 时和 Timeout 相同
+	DownloadTimeout  time.Duration
 	Concurrent       int
+	// NodeConcurrent 是同时测试的节点数，Concurrent 仍然是单个节点下载测试的连接数
+	NodeConcurrent   int
+	// UploadConcurrent 是上传测试的连接数，为 0 时和 Concurrent 相同
+	UploadConcurrent int
+	// StreamStagger 是下载和上传测试中相邻两个连接开始的间隔，为 0 时同时开始
+	StreamStagger    time.Duration
+	// SourceBanStreak 大于 0 时，同一来源在有节点测通之后连续这么多个节点连不上，就认为来源封禁了本机 IP，
+	// 暂停这个来源 SourceCooldown 后用一个节点试探，仍然不通时跳过它剩下的节点
+	SourceBanStreak int
+	SourceCooldown  time.Duration
+	// Retries 是节点连不上或下载速度不达标时额外重测的次数，保留最好的一次结果
+	Retries int
 	MaxLatency       time.Duration
+	// SourceMaxLatency 按节点来源（Result.Source）覆盖 MaxLatency，TypeOverrides 的 max-latency 优先于它
+	SourceMaxLatency map[string]time.Duration
 	MinDownloadSpeed float64
+	// MinSpeed 是判定节点可用的最低下载速度（-min-speed），低于它的节点会按 Retries 重测
+	MinSpeed         float64
+	// SourceMinSpeed 按节点来源（Result.Source）覆盖 MinSpeed
+	SourceMinSpeed   map[string]float64
 	MinUploadSpeed   float64
 	FastMode         bool
 	ExtraConnectURL 	[]string
 	ExtraDownloadURL	string
+	SSHKnownHosts    string
+	DetectExitIP     bool
+	GeoResolver      GeoResolver
+	Injections         []*Injection
+	InjectFilter       string
+	SaveOriginalConfig bool
+	// StrictParse 为 true 时不做 NormalizeProxy 修正，按订阅原样解析
+	StrictParse bool
+	// UploadIntegritySize 大于 0 时额外上传一段伪随机数据校验节点是否损坏上传内容
+	UploadIntegritySize int
+	// TamperCheck 为 true 时通过测速通过的节点下载 TamperCheckURL（默认下载服务器的 /__known），
+	// 比较 sha256 检测节点是否篡改明文 HTTP 响应。TamperCheckSHA256 为空时和 KnownContent 比较
+	TamperCheck       bool
+	TamperCheckURL    string
+	TamperCheckSHA256 string
+	// 下载速度超过 MaxPlausibleSpeed 或下载耗时短于 MinDownloadDuration 的结果视为测量异常
+	MaxPlausibleSpeed   float64
+	// VantageName 是测试所在位置的名字，记录在每个结果里
+	VantageName string
+	// NamePrefix 决定结果里的节点名称：file（默认）加上配置文件名，none 保持原名，其他值作为前缀，见 NameProxy
+	NamePrefix string
+	// IPFamilyFallback 开启时，延迟测试因为超时或网络不可达全部失败后，固定测速服务器的 IPv4/IPv6 地址再试，见 familyFallback
+	IPFamilyFallback bool
+	// MaxDownloadBytes 大于 0 时下载测试在速度稳定或者所有连接合计下载这么多字节后提前结束，
+	// 开始后 MinSampleDuration 之内不会提前结束
+	MaxDownloadBytes  int
+	MinSampleDuration time.Duration
+	// RateLimiter 非空时限制所有节点下载和上传测试的总带宽，测出的速度不再代表节点的能力
+	RateLimiter         *RateLimiter
+	MinDownloadDuration time.Duration
+	// Filter 在 -f/-b 之后按规则进一步筛选节点
+	Filter *FilterSet
+	// ServerCountries 非空时在测试前按服务器地址的国家筛选节点，
+	// 无法定位的节点默认保留，ServerCountriesStrict 时丢弃
+	ServerCountries       []string
+	ServerCountriesStrict bool
+	// LatencyConnection 决定延迟探测是否复用连接：reuse（默认）、new 或 both
+	LatencyConnection string
+	// PingIntervalJitter 是延迟探测间隔的随机浮动比例，0 表示严格按固定间隔探测
+	PingIntervalJitter float64
+	// Clock 为空时使用系统时间
+	Clock Clock
+	// WebSocketURL 非空时通过节点连接这个 ws(s) echo 服务器测试 WebSocket 是否可用
+	WebSocketURL string
+	// ExplainFilter 非空时记录这个节点在每一步筛选中的去留原因
+	ExplainFilter string
+	// FetchHeaders 和 UserAgent 用于下载订阅，SourceName 非空时代替订阅地址作为节点的来源
+	FetchHeaders map[string]string
+	UserAgent    string
+	SourceName   string
+	// LocalIP 是本机直连时的公网 IP，非空时在下载测试后检查节点出口是否与它相同
+	LocalIP string
+	// SustainedDuration 大于 0 时对可用节点额外下载这么长时间，检测节点是否在初始突发后限速，
+	// 下载总量不超过 SustainedMaxSize
+	SustainedDuration time.Duration
+	SustainedMaxSize  int
+	// CloseLatency 为 true 时在延迟测试后测量连接关闭需要多长时间
+	CloseLatency bool
+	// ProviderLimits 限制 proxy-provider 的嵌套层数和数量，ProviderFetcher 为空时直接下载，或者读取本地配置所在目录下的文件
+	ProviderLimits  ProviderLimits
+	ProviderFetcher ProviderFetcher
+	// Impersonate 是测试请求模拟的浏览器（chrome、safari），空或 none 时使用 Go 默认的请求头和 TLS 指纹
+	Impersonate string
+	// ClashDelayURL 非空时额外用 mihomo 的 URLTest 测一次延迟，与 clash 客户端显示的延迟对照
+	ClashDelayURL string
+	// AutoConcurrent 为 true 时下载测试从 1 个流开始，吞吐量不再提高时停止增加，最多 Concurrent 个流
+	AutoConcurrent bool
+	// CCSweep 非空时把 tuic 和 hysteria2 节点按其中的每种拥塞控制算法各测一次
+	CCSweep []string
+	// MyRegion 是测试机所在的国家代码，非空时检查节点延迟是否低于到节点所在地区的物理下限
+	MyRegion string
+	// AllowedTypes 非空时只测试这些类型的节点，在 -f/-b 之前筛选，按 constant.AdapterType 的名称不区分大小写匹配
+	AllowedTypes []string
+	// TypeOverrides 按代理类型覆盖下载量、超时等测试参数，见 ParseTypeOverrides
+	TypeOverrides map[constant.AdapterType]*TypeOverride
 }
 
+const (
+	LatencyConnReuse = "reuse"
+	LatencyConnNew   = "new"
+	LatencyConnBoth  = "both"
+)
+
 type SpeedTester struct {
 	config           *Config
 	blockedNodes     []string
 	blockedNodeCount int
+	knownHosts       *KnownHosts
+	geoResolver      GeoResolver
+	// 缓存用指针，testerFor 复制出来的 SpeedTester 和原来的共用
+	serverCountries *serverCountryCache
+	dualStack       *dualStackCache
+	tamperBaseline  *tamperBaseline
 }
 
 func New(config *Config) *SpeedTester {
 	if config.Concurrent <= 0 {
 		config.Concurrent = 1
 	}
+	if config.NodeConcurrent <= 0 {
+		config.NodeConcurrent = 1
+	}
+	if config.DownloadTimeout <= 0 {
+		config.DownloadTimeout = config.Timeout
+	}
 	if config.DownloadSize < 0 {
 		config.DownloadSize = 100 * 1024 * 1024
 	}
 	if config.UploadSize < 0 {
 		config.UploadSize = 10 * 1024 * 1024
 	}
+	if config.UploadChunkSize <= 0 {
+		config.UploadChunkSize = 1024 * 1024
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	if config.DownloadServerURL == "" {
+		config.DownloadServerURL = config.ServerURL
+	}
+	if config.UploadServerURL == "" {
+		config.UploadServerURL = config.ServerURL
+	}
+	if config.MaxPlausibleSpeed <= 0 {
+		config.MaxPlausibleSpeed = 1.25 * 1024 * 1024 * 1024
+	}
+	geoResolver := config.GeoResolver
+	if geoResolver == nil && (config.DetectExitIP || len(config.ServerCountries) > 0) {
+		geoResolver = NewCachedResolver(NewIPAPIResolver())
+	}
 	return &SpeedTester{
-		config: config,
+		config:          config,
+		geoResolver:     geoResolver,
+		serverCountries: &serverCountryCache{countries: make(map[string]string)},
+		dualStack:       &dualStackCache{addrs: make(map[string][]netip.Addr)},
+		tamperBaseline:  &tamperBaseline{},
 	}
 }
 
 type CProxy struct {
 	constant.Proxy
-	Config map[string]any
+	Config      map[string]any
+	SSHVerified bool
+	// Source 是节点所在的配置文件路径或订阅地址
+	Source string
+	// CongestionControl 非空表示这是 Config.CCSweep 复制出来的变体
+	CongestionControl string
 }
 
 type RawConfig struct {
@@ -71,56 +221,196 @@ type RawConfig struct {
 	Proxies   []map[string]any          `yaml:"proxies"`
 }
 
-func (st *SpeedTester) LoadProxies(stashCompatible bool) (map[string]*CProxy, error) {
+// LoadReport 是 LoadProxies 的结果，除了可测试的节点外还记录了各个环节被丢弃的节点数
+type LoadReport struct {
+	Proxies map[string]*CProxy
+	// Total 是配置中解析出的节点总数
+	Total int
+	// Skipped 按类型统计不支持测试的节点
+	Skipped           map[string]int
+	StashIncompatible int
+	Blocked           int
+	FilteredOut       int
+	// TypeExcluded 是被 Config.AllowedTypes 排除的节点数
+	TypeExcluded int
+	// 被 Config.ServerCountries 筛掉的节点，以及因为无法定位在严格模式下被丢弃的节点
+	ServerCountryFiltered int
+	ServerCountryUnknown  int
+	ParseErrors       []error
+	// Normalized 按修正项统计 NormalizeProxy 修正的次数，保存的配置也是修正后的
+	Normalized map[string]int
+	// Explanations 是 Config.ExplainFilter 指定节点的筛选过程
+	Explanations []string
+	// SubscriptionUserinfo 是订阅地址返回的 subscription-userinfo 响应头，本地文件为空
+	SubscriptionUserinfo string
+	// Raw 是最后一个配置文件的原始内容，用于按来源输出时保留节点以外的配置
+	Raw []byte
+	// ProviderWarnings 是因为达到限制、重复引用或出错而没有展开的 proxy-provider
+	ProviderWarnings []ProviderWarning
+	// SourceError 非空表示配置没能下载或者内容不是 clash 配置（例如过期订阅返回的 HTML 页面）
+	SourceError string
+}
+
+func (st *SpeedTester) LoadProxies(stashCompatible bool) (*LoadReport, error) {
 	allProxies := make(map[string]*CProxy)
+	report := &LoadReport{Skipped: make(map[string]int), Normalized: make(map[string]int)}
 	st.blockedNodes = make([]string, 0)
 	st.blockedNodeCount = 0
+	if st.config.SSHKnownHosts != "" && st.knownHosts == nil {
+		knownHosts, err := LoadKnownHosts(st.config.SSHKnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("load ssh known hosts %s: %w", st.config.SSHKnownHosts, err)
+		}
+		st.knownHosts = knownHosts
+	}
+	var injectRegexp *regexp.Regexp
+	if len(st.config.Injections) > 0 && st.config.InjectFilter != "" {
+		var err error
+		if injectRegexp, err = regexp.Compile(st.config.InjectFilter); err != nil {
+			return nil, fmt.Errorf("invalid inject filter: %w", err)
+		}
+	}
 
 	for _, configPath := range strings.Split(st.config.ConfigPaths, ",") {
 		var body []byte
 		var err error
 		if strings.HasPrefix(configPath, "http") {
 			var resp *http.Response
-			resp, err = http.Get(configPath)
+			resp, err = st.fetchSubscription(configPath)
 			if err != nil {
 				log.Warnln("failed to fetch config: %s", err)
+				report.SourceError = err.Error()
 				continue
 			}
 			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if userinfo := resp.Header.Get("Subscription-Userinfo"); userinfo != "" {
+				report.SubscriptionUserinfo = userinfo
+			}
+			if err == nil {
+				err = checkConfigContent(resp.Header.Get("Content-Type"), body)
+			}
 		} else {
 			body, err = os.ReadFile(configPath)
+			if err == nil {
+				err = checkConfigContent("", body)
+			}
 		}
 		if err != nil {
 			log.Warnln("failed to read config: %s", err)
+			report.SourceError = err.Error()
 			continue
 		}
 
 		rawCfg := &RawConfig{
 			Proxies: []map[string]any{},
 		}
-		if err := yaml.Unmarshal(body, rawCfg); err != nil {
-			return nil, err
+		if outbounds, skipped, ok := parseSingBoxConfig(body); ok {
+			// sing-box 配置里只有节点会被转换，路由、分组等不保留
+			if skipped > 0 {
+				log.Warnln("%s: skipped %d sing-box outbounds of unsupported types", configPath, skipped)
+				report.ParseErrors = append(report.ParseErrors, fmt.Errorf("%s: %d unsupported sing-box outbounds", configPath, skipped))
+			}
+			rawCfg.Proxies = outbounds
+		} else if links, skipped, ok := parseShareLinks(body); ok {
+			// 很多机场只提供 base64 的分享链接订阅，没有节点以外的配置可以保留
+			if skipped > 0 {
+				log.Warnln("%s: skipped %d share links that could not be converted", configPath, skipped)
+				report.ParseErrors = append(report.ParseErrors, fmt.Errorf("%s: %d malformed share links", configPath, skipped))
+			}
+			rawCfg.Proxies = links
+		} else {
+			report.Raw = body
+			err = yaml.Unmarshal(body, rawCfg)
+		}
+		if err != nil {
+			// 一处缩进错误会导致整个文件解析失败，逐个解析节点，尽量保留能用的部分
+			recovered, itemErrs := lenientProxies(body)
+			if len(recovered) == 0 {
+				return nil, err
+			}
+			log.Warnln("parse %s failed: %v, recovered %d proxies, %d broken", configPath, err, len(recovered), len(itemErrs))
+			rawCfg = &RawConfig{Proxies: recovered}
+			for _, itemErr := range itemErrs {
+				report.ParseErrors = append(report.ParseErrors, fmt.Errorf("%s: %w", configPath, itemErr))
+			}
 		}
 		proxies := make(map[string]*CProxy)
 		proxiesConfig := rawCfg.Proxies
 		providersConfig := rawCfg.Providers
+		if !st.config.StrictParse {
+			proxiesConfig = normalizeProxies(proxiesConfig, report.Normalized)
+		}
 
+		report.Total += len(proxiesConfig)
 		for i, config := range proxiesConfig {
-			proxy, err := adapter.ParseProxy(config)
+			// 改写后的配置用于测试，默认也会保存改写后的配置，-save-original-config 时保存原始配置。
+			// 带方括号的 IPv6 地址 mihomo 无法解析，在改写和解析之前先规范化
+			parseConfig := withNormalizedServer(config)
+			if !st.config.SaveOriginalConfig {
+				config = parseConfig
+			}
+			if name, _ := config["name"].(string); len(st.config.Injections) > 0 && (injectRegexp == nil || injectRegexp.MatchString(name)) {
+				for _, injection := range st.config.Injections {
+					parseConfig = DeepMerge(parseConfig, injection.Patch)
+					log.Infoln("inject %s into %s", injection, name)
+				}
+				if !st.config.SaveOriginalConfig {
+					config = parseConfig
+				}
+			}
+			// ssh 节点没有 host-key 时用 known_hosts 补上，保存的配置仍然保持原样
+			sshVerified := false
+			if config["type"] == "ssh" {
+				parseConfig, sshVerified = injectSSHHostKey(parseConfig, st.knownHosts)
+			}
+			proxy, err := adapter.ParseProxy(parseConfig)
 			if err != nil {
-				return nil, fmt.Errorf("proxy %d: %w", i, err)
+				if strings.Contains(err.Error(), "unsupport proxy type") {
+					report.Skipped[toString(config["type"])]++
+				} else {
+					report.ParseErrors = append(report.ParseErrors, fmt.Errorf("proxy %d: %w", i, err))
+				}
+				continue
 			}
 
 			if _, exist := proxies[proxy.Name()]; exist {
-				return nil, fmt.Errorf("proxy %s is the duplicate name", proxy.Name())
+				report.ParseErrors = append(report.ParseErrors, fmt.Errorf("proxy %s is the duplicate name", proxy.Name()))
+				continue
 			}
-			proxies[proxy.Name()] = &CProxy{Proxy: proxy, Config: config}
+			proxies[proxy.Name()] = &CProxy{Proxy: proxy, Config: config, SSHVerified: sshVerified}
 		}
-		for name, config := range providersConfig {
-			if name == provider.ReservedName {
-				return nil, fmt.Errorf("can not defined a provider called `%s`", provider.ReservedName)
+		if _, ok := providersConfig[provider.ReservedName]; ok {
+			return nil, fmt.Errorf("can not defined a provider called `%s`", provider.ReservedName)
+		}
+		fetcher := st.config.ProviderFetcher
+		if fetcher == nil {
+			// 本地配置里的 file provider 只能读取配置文件所在的目录，远程订阅里的不能读取本地文件
+			fileDir := ""
+			if !strings.HasPrefix(configPath, "http") {
+				fileDir, _ = filepath.Abs(filepath.Dir(configPath))
+			}
+			fetcher = newProviderFetcher(st.config.UserAgent, fileDir)
+		}
+		expanded, warnings := expandProviders(fetcher, providersConfig, st.config.ProviderLimits)
+		for _, warning := range warnings {
+			log.Warnln("proxy provider %s", warning)
+		}
+		report.ProviderWarnings = append(report.ProviderWarnings, warnings...)
+		for _, nodes := range expanded {
+			name := nodes.Name
+			if !st.config.StrictParse {
+				nodes.Proxies = normalizeProxies(nodes.Proxies, report.Normalized)
 			}
-			pd, err := provider.ParseProxyProvider(name, config)
+			savedProxies := nodes.Proxies
+			nodes.Proxies = make([]map[string]any, len(savedProxies))
+			for i, pdProxy := range savedProxies {
+				nodes.Proxies[i] = withNormalizedServer(pdProxy)
+			}
+			if !st.config.SaveOriginalConfig {
+				savedProxies = nodes.Proxies
+			}
+			pd, err := provider.ParseProxyProvider(name, nodes.inlineMapping())
 			if err != nil {
 				return nil, fmt.Errorf("parse proxy provider %s error: %w", name, err)
 			}
@@ -128,29 +418,17 @@ func (st *SpeedTester) LoadProxies(stashCompatible bool) (map[string]*CProxy, er
 				return nil, fmt.Errorf("initial proxy provider %s error: %w", pd.Name(), err)
 			}
 
-			resp, err := http.Get(config["url"].(string))
-			if err != nil {
-				log.Warnln("failed to fetch config: %s", err)
-				continue
-			}
-			body, err = io.ReadAll(resp.Body)
-			if err != nil {
-				return nil, err
-			}
-			pdRawCfg := &RawConfig{
-				Proxies: []map[string]any{},
-			}
-			if err := yaml.Unmarshal(body, pdRawCfg); err != nil {
-				return nil, err
-			}
 			pdProxies := make(map[string]map[string]any)
-			for _, pdProxy := range pdRawCfg.Proxies {
-				pdProxies[pdProxy["name"].(string)] = pdProxy
+			for _, pdProxy := range savedProxies {
+				pdProxies[toString(pdProxy["name"])] = pdProxy
 			}
+			report.Total += len(pd.Proxies())
 			for _, proxy := range pd.Proxies() {
+				pdConfig := pdProxies[proxy.Name()]
 				proxies[fmt.Sprintf("[%s] %s", name, proxy.Name())] = &CProxy{
-					Proxy:  proxy,
-					Config: pdProxies[proxy.Name()],
+					Proxy:       proxy,
+					Config:      pdConfig,
+					SSHVerified: proxy.Type() == constant.Ssh && pdConfig != nil && hasSSHHostKey(pdConfig),
 				}
 			}
 		}
@@ -160,15 +438,26 @@ func (st *SpeedTester) LoadProxies(stashCompatible bool) (map[string]*CProxy, er
 				constant.Vmess, constant.Vless, constant.Trojan, constant.Hysteria, constant.Hysteria2,
 				constant.WireGuard, constant.Tuic, constant.Ssh, constant.Mieru, constant.AnyTLS:
 			default:
+				report.Skipped[strings.ToLower(p.Type().String())]++
 				continue
 			}
-			if server, ok := p.Config["server"]; ok {
-				p.Config["server"] = convertMappedIPv6ToIPv4(server.(string))
-			}
-			if stashCompatible && !isStashCompatible(p) {
-				continue
+			if stashCompatible {
+				// 先改写 Stash 能够等价理解的写法，再检查剩下的选项，保存的也是改写后的配置
+				var changes []string
+				if p.Config, changes = normalizeForStash(p.Config); len(changes) > 0 {
+					log.Debugln("stash: %s: %s", k, strings.Join(changes, ", "))
+				}
+				if reason := stashIncompatibleReason(p.Type(), p.Config); reason != "" {
+					log.Infoln("stash: skip %s: %s", k, reason)
+					report.StashIncompatible++
+					continue
+				}
 			}
 			if _, ok := allProxies[k]; !ok {
+				p.Source = configPath
+				if st.config.SourceName != "" {
+					p.Source = st.config.SourceName
+				}
 				allProxies[k] = p
 			}
 		}
@@ -186,7 +475,19 @@ func (st *SpeedTester) LoadProxies(stashCompatible bool) (map[string]*CProxy, er
 	}
 
 	filteredProxies := make(map[string]*CProxy)
-	for name := range allProxies {
+	for name, proxy := range allProxies {
+		explain := func(format string, args ...any) {
+			if st.config.ExplainFilter != "" && (name == st.config.ExplainFilter || toString(proxy.Config["name"]) == st.config.ExplainFilter) {
+				report.Explanations = append(report.Explanations, name+": "+fmt.Sprintf(format, args...))
+			}
+		}
+		if len(st.config.AllowedTypes) > 0 && !slices.ContainsFunc(st.config.AllowedTypes, func(t string) bool {
+			return strings.EqualFold(t, proxy.Type().String())
+		}) {
+			explain("exclude by -type, type is %s", proxy.Type())
+			report.TypeExcluded++
+			continue
+		}
 		shouldBlock := false
 		if len(blockKeywords) > 0 {
 			lowerName := strings.ToLower(name)
@@ -199,119 +500,214 @@ func (st *SpeedTester) LoadProxies(stashCompatible bool) (map[string]*CProxy, er
 		}
 
 		if shouldBlock {
+			explain("exclude by -b %q", st.config.BlockRegex)
+			report.Blocked++
 			continue
 		}
-		if filterRegexp.MatchString(name) {
-			filteredProxies[name] = allProxies[name]
+		if !filterRegexp.MatchString(name) {
+			explain("exclude by -f %q", st.config.FilterRegex)
+			report.FilteredOut++
+			continue
+		}
+		if st.config.Filter != nil {
+			if include, _ := st.config.Filter.Decide(name, proxy.Type().String(), proxy.Config); !include {
+				explain("%s", st.config.Filter.Explain(name, proxy.Type().String(), proxy.Config))
+				report.FilteredOut++
+				continue
+			}
+			explain("%s", st.config.Filter.Explain(name, proxy.Type().String(), proxy.Config))
+		} else {
+			explain("include")
 		}
+		filteredProxies[name] = proxy
+	}
+	if len(st.config.ServerCountries) > 0 {
+		filteredProxies = st.filterByServerCountry(filteredProxies, report)
 	}
-	return filteredProxies, nil
+	if len(st.config.CCSweep) > 0 {
+		filteredProxies = st.expandCCVariants(filteredProxies, report)
+	}
+	report.Proxies = filteredProxies
+	return report, nil
 }
 
-func isStashCompatible(proxy *CProxy) bool {
-	switch proxy.Type() {
-	case constant.Shadowsocks:
-		cipher, ok := proxy.Config["cipher"]
-		if ok {
-			switch cipher {
-			case "aes-128-gcm", "aes-192-gcm", "aes-256-gcm",
-				"aes-128-cfb", "aes-192-cfb", "aes-256-cfb",
-				"aes-128-ctr", "aes-192-ctr", "aes-256-ctr",
-				"rc4-md5", "chacha20", "chacha20-ietf", "xchacha20",
-				"chacha20-ietf-poly1305", "xchacha20-ietf-poly1305",
-				"2022-blake3-aes-128-gcm", "2022-blake3-aes-256-gcm":
-			default:
-				return false
-			}
+// resultQueueSize 是已经测完、等待 fn 处理的结果数上限。
+// fn 跟不上时测试会阻塞等待，结果不会被丢弃
+const resultQueueSize = 16
+
+// TestProxies 测试节点并把结果交给 fn，progress 可以为 nil。
+// 测试在单独的协程里进行，fn 只在调用 TestProxies 的协程里逐个调用，不会并发执行，
+// 每个结果恰好交给 fn 一次，fn 里追加切片、写文件都不需要加锁。
+// TestProxies 在 fn 处理完全部结果后才返回。progress 的方法在测试协程里调用，需要自己保证并发安全。
+// ctx 被取消时正在进行的请求立即中断，剩下的节点不再测试，被中断的节点没有结果，已经测完的结果照常交给 fn
+func (st *SpeedTester) TestProxies(ctx context.Context, proxies map[string]*CProxy, progress Progress, fn func(result *Result)) {
+	results := make(chan *Result, resultQueueSize)
+	go func() {
+		defer close(results)
+		st.runTests(ctx, proxies, progress, results)
+	}()
+	for result := range results {
+		fn(result)
+	}
+}
+
+// runTests 用 NodeConcurrent 个协程测试节点并把结果写入 results，
+// 测试期间系统睡眠过的节点会在全部节点测完后再重测一次
+func (st *SpeedTester) runTests(ctx context.Context, proxies map[string]*CProxy, progress Progress, results chan<- *Result) {
+	jobs := make([]testJob, 0, len(proxies))
+	for name, proxy := range proxies {
+		jobs = append(jobs, testJob{name: name, proxy: proxy})
+	}
+	var mu sync.Mutex
+	var retry []testJob
+	st.runJobs(ctx, jobs, progress, func(job testJob, result *Result) {
+		if result.Invalid != "" {
+			log.Warnln("%s: %s, retest at the end of the run", result.ProxyName, result.Invalid)
+			job.retest = true
+			mu.Lock()
+			retry = append(retry, job)
+			mu.Unlock()
+			return
 		}
-	case constant.ShadowsocksR:
-		if obfs, ok := proxy.Config["obfs"]; ok {
-			switch obfs {
-			case "plain", "http_simple", "http_post", "random_head",
-				"tls1.2_ticket_auth", "tls1.2_ticket_fastauth":
-			default:
-				return false
+		results <- result
+	})
+	st.runJobs(ctx, retry, progress, func(_ testJob, result *Result) {
+		results <- result
+	})
+}
+
+// runJobs 启动 NodeConcurrent 个协程测试节点，全部测完后返回。
+// 每个节点的请求都有各自的超时，慢节点只占住一个协程，不会拖住其他节点。
+// 派发节点时按 sourceBans 的判定暂缓或跳过疑似被封禁的来源，其他来源的节点照常测试。
+// ctx 取消后不再派发新的节点，被中断的节点结果不完整，不交给 done
+func (st *SpeedTester) runJobs(ctx context.Context, jobs []testJob, progress Progress, done func(job testJob, result *Result)) {
+	if len(jobs) == 0 || ctx.Err() != nil {
+		return
+	}
+	type finishedJob struct {
+		job    testJob
+		result *Result
+	}
+	queue := make(chan testJob)
+	finished := make(chan finishedJob)
+	var wg sync.WaitGroup
+	for i := range min(st.config.NodeConcurrent, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sleepContext(ctx, st.workerStartOffset(i))
+			for job := range queue {
+				// 要在最后重测的节点只报告一次开始和一次结束，中间一直算作正在测试
+				if !job.retest {
+					notifyStarted(progress, job.name)
+				}
+				result := st.testProxyWithRetries(ctx, job.name, job.proxy)
+				if result.Invalid == "" || job.retest {
+					notifyFinished(progress, job.name, result)
+				}
+				finished <- finishedJob{job: job, result: result}
 			}
+		}()
+	}
+
+	bans := newSourceBans(st.config.SourceBanStreak, st.config.SourceCooldown)
+	pending := slices.Clone(jobs)
+	inFlight := 0
+	for len(pending) > 0 || inFlight > 0 {
+		if ctx.Err() != nil {
+			pending = nil
 		}
-		if protocol, ok := proxy.Config["protocol"]; ok {
-			switch protocol {
-			case "origin", "auth_sha1_v4", "auth_aes128_md5",
-				"auth_aes128_sha1", "auth_chain_a", "auth_chain_b":
+		now := st.config.Clock.Now()
+		next := -1
+		for i := 0; i < len(pending) && next < 0; {
+			switch bans.admit(st.sourceOf(pending[i].proxy), now) {
+			case admitRun:
+				next = i
+			case admitSkip:
+				done(pending[i], st.skippedResult(pending[i]))
+				pending = slices.Delete(pending, i, i+1)
 			default:
-				return false
+				i++
 			}
 		}
-	case constant.Snell:
-		if obfsOpts, ok := proxy.Config["obfs-opts"]; ok {
-			if obfsOptsMap, ok := obfsOpts.(map[string]any); ok {
-				if mode, ok := obfsOptsMap["mode"]; ok {
-					switch mode {
-					case "http", "tls":
-					default:
-						return false
-					}
-				}
-			}
+		var send chan<- testJob
+		var job testJob
+		if next >= 0 {
+			send, job = queue, pending[next]
 		}
-	case constant.Socks5, constant.Http:
-	case constant.Vmess:
-		if cipher, ok := proxy.Config["cipher"]; ok {
-			switch cipher {
-			case "auto", "aes-128-gcm", "chacha20-poly1305", "none":
-			default:
-				return false
+		// 剩下的节点都在等来源冷却时，到最早的冷却结束时间再看一次
+		var wake <-chan time.Time
+		var timer *time.Timer
+		if next < 0 && len(pending) > 0 {
+			if resume := bans.nextResume(); !resume.IsZero() {
+				timer = time.NewTimer(resume.Sub(now))
+				wake = timer.C
 			}
 		}
-		if network, ok := proxy.Config["network"]; ok {
-			switch network {
-			case "ws", "h2", "http", "grpc":
-			default:
-				return false
-			}
+		var cancelled <-chan struct{}
+		if ctx.Err() == nil {
+			cancelled = ctx.Done()
 		}
-	case constant.Vless:
-		if flow, ok := proxy.Config["flow"]; ok {
-			switch flow {
-			case "xtls-rprx-origin", "xtls-rprx-direct", "xtls-rprx-splice", "xtls-rprx-vision":
-			default:
-				return false
-			}
+		if send == nil && wake == nil && inFlight == 0 {
+			break
 		}
-	case constant.Trojan:
-		if network, ok := proxy.Config["network"]; ok {
-			switch network {
-			case "ws", "grpc":
-			default:
-				return false
+		select {
+		case send <- job:
+			pending = slices.Delete(pending, next, next+1)
+			bans.dispatched(st.sourceOf(job.proxy), job.name)
+			inFlight++
+		case f := <-finished:
+			inFlight--
+			if ctx.Err() == nil {
+				bans.observe(st.sourceOf(f.job.proxy), f.job.name, banFailed(f.result), st.config.Clock.Now())
+				done(f.job, f.result)
 			}
+		case <-wake:
+		case <-cancelled:
+		}
+		if timer != nil {
+			timer.Stop()
 		}
-	case constant.Hysteria, constant.Hysteria2:
-	case constant.WireGuard:
-	case constant.Tuic:
-	case constant.Ssh:
-	default:
-		return false
 	}
-	return true
+	close(queue)
+	wg.Wait()
 }
 
-func (st *SpeedTester) TestProxies(proxies map[string]*CProxy, beforeFn func(name string), fn func(result *Result)) {
-	for name, proxy := range proxies {
-		beforeFn(name)
-		fn(st.testProxy(name, proxy))
-	}
+// Verdict 是节点在一个使用场景下的判定，Reason 是不可用的原因
+type Verdict struct {
+	Usable bool   `json:"usable"`
+	Good   bool   `json:"good"`
+	Reason string `json:"reason,omitempty"`
 }
 
 type testJob struct {
 	name  string
 	proxy *CProxy
+	// retest 表示这是系统睡眠之后的重测
+	retest bool
 }
 
 type Result struct {
 	ProxyName     			string         `json:"proxy_name"`
+	// SourceName 是节点在配置文件里的名称，-name-prefix 加前缀之前的 name
+	SourceName              string         `json:"source_name,omitempty"`
+	Source                  string         `json:"source"`
+	// DownloadServer 同时也是延迟测试使用的服务器
+	DownloadServer          string         `json:"download_server"`
+	// UploadServer 是上传测试使用的服务器，退回到 UploadFallbackURL 后成功时记录备用服务器
+	UploadServer            string         `json:"upload_server"`
 	ProxyType     			string         `json:"proxy_type"`
 	ProxyConfig  			map[string]any `json:"proxy_config"`
 	Latency       			time.Duration  `json:"latency"`
+	LatencyReused           time.Duration  `json:"latency_reused,omitempty"`
+	LatencyNewConn          time.Duration  `json:"latency_new_conn,omitempty"`
+	// Impersonation 是测试请求模拟的浏览器，为空表示没有模拟
+	Impersonation           string         `json:"impersonation,omitempty"`
+	// TypeOverride 是测试这个节点时按类型覆盖的参数，例如 "download-size=100MB,timeout=20s"
+	TypeOverride            string         `json:"type_override,omitempty"`
+	// ClashDelay 是 mihomo URLTest 测得的延迟，也就是 clash 客户端里看到的数字
+	ClashDelay              time.Duration  `json:"clash_delay,omitempty"`
+	ClashDelayError         string         `json:"clash_delay_error,omitempty"`
 	Jitter       			time.Duration  `json:"jitter"`
 	PacketLoss    			float64        `json:"packet_loss"`
 	DownloadSize  			float64        `json:"download_size"`
@@ -320,13 +716,77 @@ type Result struct {
 	UploadSize   			float64        `json:"upload_size"`
 	UploadTime   			time.Duration  `json:"upload_time"`
 	UploadSpeed   			float64        `json:"upload_speed"`
-	ExtraURLConnectivity	bool		   `json:extra_url_connectivity`
+	// UploadBlocked 表示下载正常，但上传服务器（和备用服务器）的所有上传连接都失败了
+	UploadBlocked           bool           `json:"upload_blocked,omitempty"`
+	// SteadySpeed 是 -max-download-bytes 开启时最后 3 秒的下载速度，不含 TCP 慢启动（下载不到 3 秒时是全程的平均速度）；
+	// DownloadStop 是下载提前结束的原因（stable 或 cap），实际下载的字节数见 DownloadSize
+	SteadySpeed             float64        `json:"steady_speed,omitempty"`
+	DownloadStop            string         `json:"download_stop,omitempty"`
+	// IPFamilyUsed 是 -ip-family-fallback 固定测速服务器地址后能连通的协议族（ipv4 或 ipv6），没有用到时为空
+	IPFamilyUsed            string         `json:"ip_family_used,omitempty"`
+	// RateLimited 表示测试时开了 -rate-limit，速度受限速影响，只能说明节点可用
+	RateLimited             bool           `json:"rate_limited,omitempty"`
+	// Vantage 是测试所在位置的名字（-vantage-name），合并多个位置的结果时用来区分
+	Vantage                 string         `json:"vantage,omitempty"`
+	ExtraURLConnectivity	bool		   `json:"extra_url_connectivity"`
 	ExtraURLOpenSpeed       float64        `json:"extra_url_open_speed"`
 	ExtraDownloadSpeed		float64        `json:"extra_download_speed"`
+	SSHVerified             bool           `json:"ssh_verified,omitempty"`
+	ExitIP                  string         `json:"exit_ip,omitempty"`
+	// ForwardedChain 是测速服务器在 X-Forwarded-For、Forwarded 等请求头里看到的出口之前的地址，
+	// ExtraHops 是据此推断出的出口之后还有几层代理，都只有测速服务器回显这些请求头时才有值
+	ForwardedChain          []string       `json:"forwarded_chain,omitempty"`
+	ExtraHops               int            `json:"extra_hops,omitempty"`
+	// ExitSubnetPeers 是出口 IP 在同一个 /24（IPv6 为 /48）里的其他节点数
+	ExitSubnetPeers         int            `json:"exit_subnet_peers"`
+	CountryCode             string         `json:"country_code,omitempty"`
+	City                    string         `json:"city,omitempty"`
+	ExitASN                 int            `json:"exit_asn,omitempty"`
+	ExitASOrg               string         `json:"exit_as_org,omitempty"`
+	UploadIntegrity         bool           `json:"upload_integrity"`
+	UploadIntegrityStatus   string         `json:"upload_integrity_status,omitempty"`
+	// ContentTampering 表示节点改写了 -tamper-check 下载的已知内容，TamperDiff 是差异摘要
+	ContentTampering        bool           `json:"content_tampering,omitempty"`
+	TamperDiff              string         `json:"tamper_diff,omitempty"`
+	Error                   string         `json:"error,omitempty"`
+	ErrorClass              string         `json:"error_class,omitempty"`
+	Suspect                 string         `json:"suspect,omitempty"`
+	// DiversityPick 表示节点没有达到优质阈值，是为了满足出口国家数量被选进优质输出的
+	DiversityPick           bool           `json:"diversity_pick,omitempty"`
+	// Invalid 非空表示测试过程中系统睡眠或时钟跳变，结果不可信
+	Invalid                 string         `json:"invalid,omitempty"`
+	WebSocketOK             bool           `json:"websocket_ok"`
+	WebSocketRTT            time.Duration  `json:"websocket_rtt,omitempty"`
+	WebSocketError          string         `json:"websocket_error,omitempty"`
+	TestedAt                time.Time      `json:"tested_at"`
+	// Attempts 是得到这个结果一共测了几次，-retries 为 0 时总是 1
+	Attempts                int            `json:"attempts,omitempty"`
+	// DirectLeak 表示节点出口 IP 与本机公网 IP 相同，流量实际上没有经过节点
+	DirectLeak              bool           `json:"direct_leak,omitempty"`
+	// Scenarios 是按 -scenarios 中每个场景的阈值分别得出的判定
+	Scenarios               map[string]Verdict `json:"scenarios,omitempty"`
+	// DownloadStreams 是自动调整并发时达到 DownloadSpeed 使用的流数
+	DownloadStreams         int            `json:"download_streams,omitempty"`
+	// CongestionControl 是 -cc-sweep 测试的拥塞控制算法，同一节点的各个变体只有最好的会被输出
+	CongestionControl       string         `json:"congestion_control,omitempty"`
+	// GeoSuspect 表示延迟比测试机到节点所在地区的理论下限还低，地区标注或出口不可信
+	GeoSuspect              bool           `json:"geo_suspect,omitempty"`
+	// SustainedSpeed 是持续下载最后一段时间的平均速度，ThrottleRatio 是它与常规下载速度之比
+	SustainedSpeed          float64        `json:"sustained_speed,omitempty"`
+	ThrottleRatio           float64        `json:"throttle_ratio,omitempty"`
+	// CloseLatency 是响应结束后到连接关闭的耗时，CloseTimedOut 表示超时仍未关闭
+	CloseLatency            time.Duration  `json:"close_latency,omitempty"`
+	CloseTimedOut           bool           `json:"close_timed_out,omitempty"`
+	// UploadEncoding 是上传测试成功时使用的请求体编码：content-length 或 chunked
+	UploadEncoding          string         `json:"upload_encoding,omitempty"`
+	// FirstSeen、LastSeen 和 SeenCount 来自历史文件，记录节点在订阅里存在了多久
+	FirstSeen               time.Time      `json:"first_seen,omitzero"`
+	LastSeen                time.Time      `json:"last_seen,omitzero"`
+	SeenCount               int            `json:"seen_count,omitempty"`
 }
 
 func (r *Result) FormatDownloadSpeed() string {
-	return formatSpeed(r.DownloadSpeed)
+	return FormatSpeed(r.DownloadSpeed)
 }
 
 func (r *Result) FormatLatency() string {
@@ -348,7 +808,7 @@ func (r *Result) FormatPacketLoss() string {
 }
 
 func (r *Result) FormatUploadSpeed() string {
-	return formatSpeed(r.UploadSpeed)
+	return FormatSpeed(r.UploadSpeed)
 }
 
 func (r *Result) FormatExtraURLConnectivity() string {
@@ -359,16 +819,17 @@ func (r *Result) FormatExtraURLConnectivity() string {
 }
 
 func (r *Result) FormatExtraURLOpenSpeed() string {
-	return formatSpeed(r.ExtraURLOpenSpeed)
+	return FormatSpeed(r.ExtraURLOpenSpeed)
 }
 
 
 func (r *Result) FormatExtraDownloadSpeed() string {
-	return formatSpeed(r.ExtraDownloadSpeed)
+	return FormatSpeed(r.ExtraDownloadSpeed)
 }
 
 
-func formatSpeed(bytesPerSecond float64) string {
+// FormatSpeed 把 B/s 转换成带单位的速度字符串
+func FormatSpeed(bytesPerSecond float64) string {
 	units := []string{"B/s", "KB/s", "MB/s", "GB/s", "TB/s"}
 	unit := 0
 	speed := bytesPerSecond
@@ -409,17 +870,87 @@ func existConnectivityProblem(latencyResultMap map[string]*latencyResult) bool {
 	return false
 }
 
-func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
-	fileName, _ := getFileNameWithoutExt(st.config.ConfigPaths)
-	result := &Result{
-		ProxyName:   fileName + "_" + name,
+// sourceOf 返回节点的来源，没有记录来源时是全部配置路径
+func (st *SpeedTester) sourceOf(proxy *CProxy) string {
+	if proxy.Source == "" {
+		return st.config.ConfigPaths
+	}
+	return proxy.Source
+}
+
+// newResult 返回还没有任何测试数据的结果
+func (st *SpeedTester) newResult(name string, proxy *CProxy) *Result {
+	source := st.sourceOf(proxy)
+	sourceName := name
+	name, config := st.NameProxy(name, proxy)
+	return &Result{
+		ProxyName:   name,
+		SourceName:  sourceName,
 		ProxyType:   proxy.Type().String(),
-		ProxyConfig: proxy.Config,
+		ProxyConfig: config,
+		SSHVerified: proxy.SSHVerified,
+		Source:      source,
+		CongestionControl: proxy.CongestionControl,
+		Impersonation: st.impersonation(),
+		RateLimited:   st.config.RateLimiter != nil,
+		Vantage:       st.config.VantageName,
+		TypeOverride:  st.typeOverrideSpec(proxy),
+		TestedAt:    st.config.Clock.Now(),
+		DownloadServer: st.config.DownloadServerURL,
+		UploadServer:   st.config.UploadServerURL,
 	}
+}
 
-	// 1. 首先进行延迟测试
-	latencyResult := st.testLatency(proxy, st.config.MaxLatency)
+func (st *SpeedTester) testProxy(ctx context.Context, name string, proxy *CProxy) *Result {
+	result := st.newResult(name, proxy)
+	testStart := st.config.Clock.Now()
+	defer func() {
+		if result.Invalid == "" {
+			result.Invalid = detectClockJump(testStart, st.config.Clock.Now(), 0)
+		}
+	}()
+
+	// 1. 首先进行延迟测试，new 模式下每次探测都新建连接，延迟包含完整的隧道建立开销
+	var latencyResult *latencyResult
+	switch st.config.LatencyConnection {
+	case LatencyConnNew:
+		latencyResult = st.testLatency(ctx, proxy, st.config.MaxLatency, true)
+		result.LatencyNewConn = latencyResult.avgLatency
+	case LatencyConnBoth:
+		latencyResult = st.testLatency(ctx, proxy, st.config.MaxLatency, false)
+		result.LatencyReused = latencyResult.avgLatency
+		if latencyResult.packetLoss < 100 {
+			result.LatencyNewConn = st.testLatency(ctx, proxy, st.config.MaxLatency, true).avgLatency
+		}
+	default:
+		latencyResult = st.testLatency(ctx, proxy, st.config.MaxLatency, false)
+		result.LatencyReused = latencyResult.avgLatency
+	}
 	result.Latency = latencyResult.avgLatency
+	if st.config.LatencyConnection != LatencyConnBoth {
+		result.Invalid = detectClockJump(testStart, st.config.Clock.Now(), st.latencyPhaseBound())
+	}
+	if st.config.IPFamilyFallback && latencyResult.packetLoss == 100 && ctx.Err() == nil {
+		if pinned, family := st.familyFallback(ctx, proxy, latencyResult.err); pinned != nil {
+			// 之后的测试都通过固定了地址的节点进行
+			log.Infoln("[ipfamily] %s: %s is only reachable over %s", result.ProxyName, st.config.DownloadServerURL, family)
+			proxy = pinned
+			result.IPFamilyUsed = family
+			latencyResult = st.testLatency(ctx, proxy, st.config.MaxLatency, st.config.LatencyConnection == LatencyConnNew)
+			result.Latency = latencyResult.avgLatency
+			if st.config.LatencyConnection == LatencyConnNew {
+				result.LatencyNewConn = latencyResult.avgLatency
+			} else {
+				result.LatencyReused = latencyResult.avgLatency
+			}
+			if st.config.LatencyConnection == LatencyConnBoth && latencyResult.packetLoss < 100 {
+				result.LatencyNewConn = st.testLatency(ctx, proxy, st.config.MaxLatency, true).avgLatency
+			}
+		}
+	}
+	if st.config.ClashDelayURL != "" {
+		st.testClashDelay(ctx, proxy, result)
+	}
 	if st.config.FastMode {
 		return result
 	} else {
@@ -427,12 +958,36 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 		result.PacketLoss = latencyResult.packetLoss
 	}
 
-	if result.PacketLoss == 100 || result.Latency > st.config.MaxLatency {
+	if latencyResult.packetLoss == 100 && latencyResult.err != nil {
+		result.Error = latencyResult.err.Error()
+		result.ErrorClass = ClassifyError(latencyResult.err)
+		if proxy.Type() == constant.Ssh {
+			result.Error = describeSSHError(latencyResult.err)
+		}
+	}
+
+	if result.PacketLoss == 100 || result.Latency > st.config.MaxLatency || ctx.Err() != nil {
 		return result
 	}
 
-	extraLatencyResult, extraOpenResult, extraDownloadResult := st.testExtraLatencyAndSpeed(proxy, st.config.MaxLatency)
-	if existConnectivityProblem(extraLatencyResult) {
+	if st.config.CloseLatency {
+		st.testCloseLatency(ctx, proxy, result)
+	}
+	if st.config.DetectExitIP {
+		st.resolveExitGeo(ctx, proxy, result)
+	}
+	if st.config.MyRegion != "" {
+		st.checkGeoSuspect(name, result)
+	}
+	if st.config.WebSocketURL != "" {
+		st.testWebSocket(ctx, proxy, result)
+	}
+
+	if ctx.Err() != nil {
+		return result
+	}
+	extraLatencyResult, extraOpenResult, extraDownloadResult := st.testExtraLatencyAndSpeed(ctx, proxy, st.config.MaxLatency)
+	if existConnectivityProblem(extraLatencyResult) || ctx.Err() != nil {
 		result.ExtraURLConnectivity = false
 		return result
 	} else {
@@ -447,66 +1002,60 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 
 	// 2. 并发进行下载和上传测试
 
-	var wg sync.WaitGroup
-
-	var totalDownloadBytes, totalUploadBytes int64
-	var totalDownloadTime, totalUploadTime time.Duration
-	var downloadCount, uploadCount int
+	var totalUploadBytes int64
+	var totalUploadTime time.Duration
+	var uploadCount int
 
 	downloadChunkSize := st.config.DownloadSize / st.config.Concurrent
 	if downloadChunkSize > 0 {
-		downloadResults := make(chan *downloadResult, st.config.Concurrent)
-
-		for i := 0; i < st.config.Concurrent; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				downloadResults <- st.testDownload(proxy, st.config.Timeout, fmt.Sprintf("%s/__down?bytes=%d", st.config.ServerURL, downloadChunkSize))
-			}()
-		}
-		wg.Wait()
-
-		for range st.config.Concurrent {
-			if dr := <-downloadResults; dr != nil {
-				totalDownloadBytes += dr.bytes
-				totalDownloadTime += dr.duration
-				downloadCount++
-			}
+		st.measureDownload(ctx, proxy, downloadChunkSize, result)
+		if reason := st.implausibleDownload(result); reason != "" && ctx.Err() == nil {
+			// 多半是拿到了缓存的错误页或者计时出错，加大下载量重测一次
+			log.Warnln("[suspect] %s: %s, retest with %d bytes per connection", result.ProxyName, reason, downloadChunkSize*2)
+			st.measureDownload(ctx, proxy, downloadChunkSize*2, result)
+			result.Suspect = st.implausibleDownload(result)
 		}
-		close(downloadResults)
 
-		if downloadCount > 0 {
-			result.DownloadSize = float64(totalDownloadBytes)
-			result.DownloadTime = totalDownloadTime / time.Duration(downloadCount)
-			result.DownloadSpeed = float64(totalDownloadBytes) / result.DownloadTime.Seconds()
-		}
-
-		if result.DownloadSpeed < st.config.MinDownloadSpeed {
+		if result.DownloadSpeed < st.config.MinDownloadSpeed || ctx.Err() != nil {
 			return result
 		}
 	}
+	if st.config.LocalIP != "" {
+		st.checkDirectLeak(ctx, proxy, result)
+	}
+	if st.config.SustainedDuration > 0 && downloadChunkSize > 0 && st.sustainedEligible(result) {
+		st.testSustained(ctx, proxy, result)
+	}
 
-	uploadChunkSize := st.config.UploadSize / st.config.Concurrent
-	if uploadChunkSize > 0 {
-		uploadResults := make(chan *downloadResult, st.config.Concurrent)
-
-		for i := 0; i < st.config.Concurrent; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				uploadResults <- st.testUpload(proxy, uploadChunkSize, st.config.Timeout)
-			}()
+	uploadStreamSize := st.config.UploadSize / st.uploadConcurrent()
+	if uploadStreamSize > 0 {
+		uploadResults := st.uploadStreams(ctx, proxy, uploadStreamSize, st.config.UploadServerURL)
+		if !anyStreamSucceeded(uploadResults) && result.DownloadSpeed > 0 && ctx.Err() == nil {
+			// 下载正常而上传全部失败，通常是节点屏蔽了 POST 请求体，有备用服务器时换一个地址再试一次
+			result.UploadBlocked = true
+			if fallback := st.config.UploadFallbackURL; fallback != "" && fallback != st.config.UploadServerURL {
+				log.Warnln("[upload] %s: every upload to %s failed, retry with %s", result.ProxyName, st.config.UploadServerURL, fallback)
+				uploadResults = st.uploadStreams(ctx, proxy, uploadStreamSize, fallback)
+				if anyStreamSucceeded(uploadResults) {
+					result.UploadBlocked = false
+					result.UploadServer = fallback
+				}
+			}
+			if result.UploadBlocked {
+				result.ErrorClass = ErrorClassUploadBlocked
+			}
 		}
-		wg.Wait()
-
-		for i := 0; i < st.config.Concurrent; i++ {
-			if ur := <-uploadResults; ur != nil {
+		for _, ur := range uploadResults {
+			if ur != nil {
+				// 只要有一个连接需要退回 Content-Length 就记录 content-length
+				if result.UploadEncoding != UploadEncodingContentLength {
+					result.UploadEncoding = ur.encoding
+				}
 				totalUploadBytes += ur.bytes
 				totalUploadTime += ur.duration
 				uploadCount++
 			}
 		}
-		close(uploadResults)
 
 		if uploadCount > 0 {
 			result.UploadSize = float64(totalUploadBytes)
@@ -514,36 +1063,124 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 			result.UploadSpeed = float64(totalUploadBytes) / result.UploadTime.Seconds()
 		}
 
-		if result.UploadSpeed < st.config.MinUploadSpeed {
+		if result.UploadSpeed < st.config.MinUploadSpeed || ctx.Err() != nil {
 			return result
 		}
 	}
+
+	if st.config.UploadIntegritySize > 0 {
+		st.testUploadIntegrity(ctx, proxy, result)
+	}
+	if st.config.TamperCheck {
+		st.testContentTampering(ctx, proxy, result)
+	}
 	return result
 }
 
+// measureDownload 并发下载 chunkSize 字节并把结果写入 result
+func (st *SpeedTester) measureDownload(ctx context.Context, proxy constant.Proxy, chunkSize int, result *Result) {
+	// 按字节预算提前结束或限速的下载撑不满逐步加流的测量窗口，这时退回固定并发
+	if st.config.AutoConcurrent && st.config.MaxDownloadBytes == 0 && st.config.RateLimiter == nil {
+		st.measureDownloadAuto(ctx, proxy, chunkSize*st.config.Concurrent, result)
+		return
+	}
+	var totalDownloadBytes int64
+	var totalDownloadTime time.Duration
+	var downloadCount int
+
+	var sinks []io.Writer
+	ctx, meter := st.startDownloadMeter(ctx)
+	if meter != nil {
+		sinks = append(sinks, meter)
+	}
+	downloadResults := st.runStreams(ctx, st.config.Concurrent, func() *downloadResult {
+		return st.downloadChunk(ctx, proxy, chunkSize, sinks...)
+	})
+	if meter != nil {
+		meter.finish(result)
+	}
+	for _, dr := range downloadResults {
+		if dr != nil {
+			totalDownloadBytes += dr.bytes
+			totalDownloadTime += dr.duration
+			downloadCount++
+		}
+	}
+
+	result.DownloadSize, result.DownloadTime, result.DownloadSpeed = 0, 0, 0
+	if downloadCount > 0 {
+		result.DownloadSize = float64(totalDownloadBytes)
+		result.DownloadTime = totalDownloadTime / time.Duration(downloadCount)
+		result.DownloadSpeed = float64(totalDownloadBytes) / result.DownloadTime.Seconds()
+	}
+}
+
+// runStreams 启动 n 个连接并等待全部结束，第 i 个连接在 i*StreamStagger 之后才开始。
+// 结果按连接顺序返回，失败或者开始前就被取消的连接是 nil
+func (st *SpeedTester) runStreams(ctx context.Context, n int, stream func() *downloadResult) []*downloadResult {
+	results := make([]*downloadResult, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i > 0 && !sleepContext(ctx, time.Duration(i)*st.config.StreamStagger) {
+				return
+			}
+			results[i] = stream()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// implausibleDownload 检查下载结果是否超出物理上可能的范围，正常时返回空字符串
+func (st *SpeedTester) implausibleDownload(result *Result) string {
+	if result.DownloadSize == 0 {
+		return ""
+	}
+	if result.DownloadSpeed > st.config.MaxPlausibleSpeed {
+		return fmt.Sprintf("download speed %s exceeds %s", FormatSpeed(result.DownloadSpeed), FormatSpeed(st.config.MaxPlausibleSpeed))
+	}
+	if st.config.MinDownloadDuration > 0 && result.DownloadTime < st.config.MinDownloadDuration {
+		return fmt.Sprintf("download finished in %s, shorter than %s", result.DownloadTime.Round(time.Millisecond), st.config.MinDownloadDuration)
+	}
+	return ""
+}
+
 type latencyResult struct {
 	avgLatency time.Duration
 	jitter     time.Duration
 	packetLoss float64
+	err        error
 }
 
-func (st *SpeedTester) testLatency(proxy constant.Proxy, minLatency time.Duration) *latencyResult {
+func (st *SpeedTester) testLatency(ctx context.Context, proxy constant.Proxy, minLatency time.Duration, newConn bool) *latencyResult {
 	client := st.createClient(proxy, minLatency)
+	if newConn {
+		httpTransport(client).DisableKeepAlives = true
+	}
+	defer client.CloseIdleConnections()
 	latencies := make([]time.Duration, 0, 6)
 	failedPings := 0
 	continuousFailures := 0
+	var lastErr error
+	scheduler := st.newProbeScheduler(proxy.Name())
 	for i := 0; i < 6; i++ {
 		if continuousFailures >= 3 {
 			failedPings = 6;
 			break
 		}
-		time.Sleep(100 * time.Millisecond)
+		if !sleepContext(ctx, scheduler.next()) {
+			break
+		}
 
 		start := time.Now()
-		resp, err := client.Get(fmt.Sprintf("%s/__down?bytes=0", st.config.ServerURL))
+		resp, err := getContext(ctx, client, fmt.Sprintf("%s/__down?bytes=0", st.config.DownloadServerURL))
 		if err != nil {
 			failedPings++
 			continuousFailures++
+			lastErr = err
 			continue
 		} else {
 			continuousFailures = 0
@@ -553,14 +1190,64 @@ func (st *SpeedTester) testLatency(proxy constant.Proxy, minLatency time.Duratio
 			latencies = append(latencies, time.Since(start))
 		} else {
 			failedPings++
+			lastErr = newStatusError(resp.Status)
 		}
 	}
 
-	return calculateLatencyStats(latencies, failedPings)
+	result := calculateLatencyStats(latencies, failedPings)
+	result.err = lastErr
+	return result
+}
+
+// sleepContext 等待 d，ctx 先被取消时提前返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
-func (st *SpeedTester) testExtraLatencyAndSpeed(proxy constant.Proxy, timeout time.Duration) (map[string]*latencyResult, *downloadResult, *downloadResult) {
+// getContext 是带 ctx 的 client.Get，ctx 被取消时请求和正在读的响应体都会中断
+func getContext(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// maxDrainBytes 是非 200 响应最多读取的字节数，读完后连接才能被复用
+const maxDrainBytes = 64 * 1024
+
+// fetchExtraURL 请求一次自定义网站，无论成功与否都会读完（或读到上限）并关闭响应体。
+// latency 是收到响应头的时间，readDuration 只计读响应体的时间，打开速度用它计算，不包含建连和等待首包
+func fetchExtraURL(ctx context.Context, client *http.Client, url string, sinks ...io.Writer) (latency time.Duration, downloadBytes int64, readDuration time.Duration, ok bool, err error) {
+	start := time.Now()
+	resp, err := getContext(ctx, client, url)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		readBody(resp.Body, maxDrainBytes)
+		return 0, 0, 0, false, nil
+	}
+	latency = time.Since(start)
+	readStart := time.Now()
+	// 读到一半断开时已经读到的字节和花的时间仍然是有效的样本
+	downloadBytes, _ = readBody(resp.Body, 0, sinks...)
+	return latency, downloadBytes, time.Since(readStart), true, nil
+}
+
+func (st *SpeedTester) testExtraLatencyAndSpeed(ctx context.Context, proxy constant.Proxy, timeout time.Duration) (map[string]*latencyResult, *downloadResult, *downloadResult) {
+	// 所有请求共用一个 client，和浏览器一样复用 keep-alive 连接
 	client := st.createClient(proxy, timeout)
+	defer client.CloseIdleConnections()
 	testTimes := 6
 	var extraLatencyResult map[string]*latencyResult
 	var extraOpenResult *downloadResult
@@ -571,6 +1258,7 @@ func (st *SpeedTester) testExtraLatencyAndSpeed(proxy constant.Proxy, timeout ti
 	if len(st.config.ExtraConnectURL) > 0 {
 		extraLatencyResult = make(map[string]*latencyResult, len(st.config.ExtraConnectURL))
 		continuousFailedPings := 0
+		scheduler := st.newProbeScheduler(proxy.Name())
 		for _, url := range st.config.ExtraConnectURL {
 			latencies := make([]time.Duration, 0, testTimes)
 			failedPings := 0
@@ -582,30 +1270,24 @@ func (st *SpeedTester) testExtraLatencyAndSpeed(proxy constant.Proxy, timeout ti
 					}
 					return extraLatencyResult, nil, nil
 				}
-				time.Sleep(100 * time.Millisecond)
+				if !sleepContext(ctx, scheduler.next()) {
+					return extraLatencyResult, nil, nil
+				}
 	
-				start := time.Now()
-				resp, err := client.Get(url)
+				latency, downloadBytes, readDuration, ok, err := fetchExtraURL(ctx, client, url, st.measureSinks()...)
 				if err != nil {
 					failedPings++
 					continuousFailedPings++
 					continue
-				} else {
-					continuousFailedPings = 0
 				}
-				
-				if resp.StatusCode == http.StatusOK {
-					latencies = append(latencies, time.Since(start))
-				} else {
+				continuousFailedPings = 0
+				if !ok {
 					failedPings++
 					continue
 				}
-
-				downloadBytes, _ := io.Copy(io.Discard, resp.Body)
+				latencies = append(latencies, latency)
 				totalDownloadBytes += downloadBytes
-				totalDownloadDuration += time.Since(start)
-
-				resp.Body.Close()
+				totalDownloadDuration += readDuration
 			}
 			extraLatencyResult[url] = calculateLatencyStats(latencies, failedPings)
 			if extraLatencyResult[url].packetLoss == 100 {
@@ -613,7 +1295,7 @@ func (st *SpeedTester) testExtraLatencyAndSpeed(proxy constant.Proxy, timeout ti
 				return extraLatencyResult, nil, nil
 			}
 		}
-		if totalDownloadBytes > 0 {
+		if totalDownloadBytes > 0 && totalDownloadDuration > 0 {
 			extraOpenResult = &downloadResult{
 				bytes:    totalDownloadBytes,
 				duration: totalDownloadDuration,
@@ -621,7 +1303,7 @@ func (st *SpeedTester) testExtraLatencyAndSpeed(proxy constant.Proxy, timeout ti
 		}
 	}
 	if st.config.ExtraDownloadURL != "" {
-		extraDownloadResult = st.testDownload(proxy, st.config.Timeout, st.config.ExtraDownloadURL)
+		extraDownloadResult = st.testDownload(ctx, proxy, st.config.DownloadTimeout, st.config.ExtraDownloadURL)
 	}
 	
 
@@ -631,13 +1313,21 @@ func (st *SpeedTester) testExtraLatencyAndSpeed(proxy constant.Proxy, timeout ti
 type downloadResult struct {
 	bytes    int64
 	duration time.Duration
+	// encoding 是上传成功时使用的请求体编码，见 UploadEncodingContentLength
+	encoding string
 }
 
-func (st *SpeedTester) testDownload(proxy constant.Proxy, timeout time.Duration, url string) *downloadResult {
+// 上传请求体的编码方式
+const (
+	UploadEncodingContentLength = "content-length"
+	UploadEncodingChunked       = "chunked"
+)
+
+func (st *SpeedTester) testDownload(ctx context.Context, proxy constant.Proxy, timeout time.Duration, url string, sinks ...io.Writer) *downloadResult {
 	client := st.createClient(proxy, timeout)
 	start := time.Now()
 
-	resp, err := client.Get(url)
+	resp, err := getContext(ctx, client, url)
 	if err != nil {
 		return nil
 	}
@@ -647,7 +1337,8 @@ func (st *SpeedTester) testDownload(proxy constant.Proxy, timeout time.Duration,
 		return nil
 	}
 
-	downloadBytes, _ := io.Copy(io.Discard, resp.Body)
+	// 超时截断时 readBody 返回已经读到的字节数，按实际字节数和耗时计算出部分速度，而不是当作失败
+	downloadBytes, _ := readBody(resp.Body, 0, st.measureSinks(sinks...)...)
 
 	return &downloadResult{
 		bytes:    downloadBytes,
@@ -655,52 +1346,146 @@ func (st *SpeedTester) testDownload(proxy constant.Proxy, timeout time.Duration,
 	}
 }
 
-func (st *SpeedTester) testUpload(proxy constant.Proxy, size int, timeout time.Duration) *downloadResult {
+// uploadConcurrent 返回上传测试的连接数。UploadConcurrent 为 0 时跟随 Concurrent，
+// 不在 New 里填好是为了 -type-overrides 只改 concurrent 时上传也跟着变
+func (st *SpeedTester) uploadConcurrent() int {
+	if st.config.UploadConcurrent > 0 {
+		return st.config.UploadConcurrent
+	}
+	return st.config.Concurrent
+}
+
+// uploadStreams 用 uploadConcurrent 个连接向 serverURL 上传，失败的连接对应的结果为 nil
+func (st *SpeedTester) uploadStreams(ctx context.Context, proxy constant.Proxy, size int, serverURL string) []*downloadResult {
+	return st.runStreams(ctx, st.uploadConcurrent(), func() *downloadResult {
+		return st.testUpload(ctx, proxy, size, st.config.DownloadTimeout, serverURL)
+	})
+}
+
+func anyStreamSucceeded(results []*downloadResult) bool {
+	for _, r := range results {
+		if r != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// testUpload 把 size 字节分成 UploadChunkSize 大小的 chunked POST 依次上传，直到传完或 timeout 用完。
+// 每个请求从开始写请求体计时到收到响应，不包括建立连接和发送请求头，速度是总字节数除以各请求耗时之和。
+// 服务器或代理链返回 411 时换成带 Content-Length 的请求，返回 413 时把块大小减半重试一次
+func (st *SpeedTester) testUpload(ctx context.Context, proxy constant.Proxy, size int, timeout time.Duration, serverURL string) *downloadResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// 所有块共用一个 client，复用 keep-alive 连接，结束时关掉留下的空闲连接
 	client := st.createClient(proxy, timeout)
+	defer client.CloseIdleConnections()
+	chunkSize := st.config.UploadChunkSize
+	encoding := UploadEncodingChunked
+	shrunk := false
+	total := &downloadResult{}
+	for total.bytes < int64(size) && ctx.Err() == nil {
+		chunk, status := st.postUpload(ctx, client, min(chunkSize, size-int(total.bytes)), encoding, serverURL)
+		if chunk == nil {
+			// 411 表示服务器要求 Content-Length
+			if status == http.StatusLengthRequired && encoding == UploadEncodingChunked {
+				encoding = UploadEncodingContentLength
+				continue
+			}
+			if status == http.StatusRequestEntityTooLarge && !shrunk && chunkSize > 1 {
+				chunkSize /= 2
+				shrunk = true
+				log.Debugln("[upload] %s rejected the request body, retry with %s chunks", serverURL, FormatByteSize(chunkSize))
+				continue
+			}
+			break
+		}
+		total.bytes += chunk.bytes
+		total.duration += chunk.duration
+		total.encoding = chunk.encoding
+	}
+	if total.bytes == 0 || total.duration <= 0 {
+		return nil
+	}
+	return total
+}
+
+// postUpload 按指定编码上传一次，失败时返回 nil 和响应状态码（没有响应时为 0）
+func (st *SpeedTester) postUpload(ctx context.Context, client *http.Client, size int, encoding, serverURL string) (*downloadResult, int) {
 	reader := NewZeroReader(size)
+	var body io.Reader = reader
+	if st.config.RateLimiter != nil {
+		body = &rateLimitedReader{ctx: ctx, r: reader, limiter: st.config.RateLimiter}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/__up", serverURL), body)
+	if err != nil {
+		return nil, 0
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// 请求体不是 bytes.Reader 之类的类型，ContentLength 为 0 时 net/http 会使用 chunked 编码
+	if encoding == UploadEncodingContentLength {
+		req.ContentLength = int64(size)
+	}
 
-	start := time.Now()
-	resp, err := client.Post(
-		fmt.Sprintf("%s/__up", st.config.ServerURL),
-		"application/octet-stream",
-		reader,
-	)
+	resp, err := client.Do(req)
+	end := time.Now()
 	if err != nil {
-		return nil
+		// 超时前已经发出去的数据仍然是有效的测量，和下载一样按实际字节数和耗时计算
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && reader.WrittenBytes() > 0 {
+			return &downloadResult{bytes: reader.WrittenBytes(), duration: reader.WriteDuration(end), encoding: encoding}, 0
+		}
+		return nil, 0
 	}
-	defer resp.Body.Close()
+	// 读完响应体下一块才能复用这条连接
+	defer func() {
+		readBody(resp.Body, maxDrainBytes)
+		resp.Body.Close()
+	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil
+		return nil, resp.StatusCode
 	}
 
 	return &downloadResult{
 		bytes:    reader.WrittenBytes(),
-		duration: time.Since(start),
+		duration: reader.WriteDuration(end),
+		encoding: encoding,
+	}, resp.StatusCode
+}
+
+// fetchSubscription 下载订阅，带上配置的请求头和 User-Agent
+func (st *SpeedTester) fetchSubscription(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range st.config.FetchHeaders {
+		req.Header.Set(key, value)
+	}
+	if st.config.UserAgent != "" {
+		req.Header.Set("User-Agent", st.config.UserAgent)
 	}
+	return http.DefaultClient.Do(req)
 }
 
 func (st *SpeedTester) createClient(proxy constant.Proxy, timeout time.Duration) *http.Client {
-	return &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				host, port, err := net.SplitHostPort(addr)
-				if err != nil {
-					return nil, err
-				}
-				var u16Port uint16
-				if port, err := strconv.ParseUint(port, 10, 16); err == nil {
-					u16Port = uint16(port)
-				}
-				return proxy.DialContext(ctx, &constant.Metadata{
-					Host:    host,
-					DstPort: u16Port,
-				})
-			},
-			//DisableCompression: true,
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			metadata, err := dialMetadata(addr)
+			if err != nil {
+				return nil, err
+			}
+			return proxy.DialContext(ctx, metadata)
 		},
+		// 调用方忘了 CloseIdleConnections 时，经过代理的空闲连接也不会一直留着
+		IdleConnTimeout: timeout,
+		//DisableCompression: true,
 	}
+	if profile := browserProfiles[st.config.Impersonate]; profile != nil {
+		return &http.Client{Timeout: timeout, Transport: profile.impersonate(transport)}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
 }
 
 func calculateLatencyStats(latencies []time.Duration, failedPings int) *latencyResult {
@@ -731,13 +1516,4 @@ func calculateLatencyStats(latencies []time.Duration, failedPings int) *latencyR
 	return result
 }
 
-func convertMappedIPv6ToIPv4(server string) string {
-	ip := net.ParseIP(server)
-	if ip == nil {
-		return server
-	}
-	if ipv4 := ip.To4(); ipv4 != nil {
-		return ipv4.String()
-	}
-	return server
-}
+
@@ -0,0 +1,213 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadReport(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: HK 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: HK 02, type: vmess, server: 1.1.1.2, port: 443, uuid: b831381d-6324-4d53-ad4f-8cda48b30811, alterId: 0, cipher: auto}
+  - {name: HK 01, type: ss, server: 1.1.1.3, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: JP 01, type: ss, server: 2.2.2.2, port: 443, password: p}
+  - {name: JP 02, type: carrier-pigeon, server: 2.2.2.3, port: 443}
+  - {name: JP 03, type: carrier-pigeon, server: 2.2.2.4, port: 443}
+  - {name: 直连, type: direct}
+  - {name: 剩余流量 10G, type: ss, server: 3.3.3.3, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: US 01, type: ss, server: 4.4.4.4, port: 443, cipher: aes-128-gcm, password: p}
+`)
+	st := New(&Config{ConfigPaths: path, FilterRegex: "HK|JP|直连|流量", BlockRegex: "流量"})
+	report, err := st.LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 9 {
+		t.Errorf("Total = %d, want 9", report.Total)
+	}
+	var names []string
+	for name := range report.Proxies {
+		names = append(names, name)
+	}
+	if len(names) != 2 || report.Proxies["HK 01"] == nil || report.Proxies["HK 02"] == nil {
+		t.Errorf("Proxies = %v, want HK 01 and HK 02", names)
+	}
+	if want := map[string]int{"carrier-pigeon": 2, "direct": 1}; !reflect.DeepEqual(report.Skipped, want) {
+		t.Errorf("Skipped = %v, want %v", report.Skipped, want)
+	}
+	if report.Blocked != 1 || report.FilteredOut != 1 {
+		t.Errorf("Blocked = %d, FilteredOut = %d, want 1 and 1", report.Blocked, report.FilteredOut)
+	}
+	if len(report.ParseErrors) != 2 {
+		t.Fatalf("ParseErrors = %v, want the missing cipher and the duplicate name", report.ParseErrors)
+	}
+	if !strings.Contains(report.ParseErrors[0].Error(), "proxy HK 01 is the duplicate name") {
+		t.Errorf("duplicate name error = %v", report.ParseErrors[0])
+	}
+	if !strings.Contains(report.ParseErrors[1].Error(), "proxy 3") {
+		t.Errorf("parse error does not point at the node: %v", report.ParseErrors[1])
+	}
+	if report.SourceError != "" {
+		t.Errorf("SourceError = %q", report.SourceError)
+	}
+}
+
+func TestLoadReportNotAConfig(t *testing.T) {
+	path := writeTestConfig(t, "<html><body>subscription expired</body></html>\n")
+	report, err := New(&Config{ConfigPaths: path}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.SourceError, "got an html page instead of a config") || len(report.Proxies) != 0 {
+		t.Fatalf("SourceError = %q, %d proxies", report.SourceError, len(report.Proxies))
+	}
+}
+
+// TestLatencyConnection 检查 new 模式每次探测都新建连接，reuse 模式只建一个连接
+func TestLatencyConnection(t *testing.T) {
+	for _, tc := range []struct {
+		newConn bool
+		conns   int64
+	}{
+		{false, 1},
+		{true, 6},
+	} {
+		server, conns := countingServer(t, http.StatusOK, 0)
+		st := New(&Config{DownloadServerURL: server.URL})
+		result := st.testLatency(context.Background(), directProxy(t), 5*time.Second, tc.newConn)
+		if result.packetLoss != 0 || result.avgLatency <= 0 {
+			t.Fatalf("new conn %v: result %+v", tc.newConn, result)
+		}
+		if n := conns.Load(); n != tc.conns {
+			t.Errorf("new conn %v: server accepted %d connections, want %d", tc.newConn, n, tc.conns)
+		}
+	}
+}
+
+func TestLatencyConnectionBoth(t *testing.T) {
+	server, conns := countingServer(t, http.StatusOK, 0)
+	st := New(&Config{DownloadServerURL: server.URL, LatencyConnection: LatencyConnBoth, FastMode: true, Timeout: 5 * time.Second, MaxLatency: 5 * time.Second})
+	result := st.testProxy(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.LatencyReused <= 0 || result.LatencyNewConn <= 0 || result.Latency != result.LatencyReused {
+		t.Errorf("latency %s, reused %s, new conn %s", result.Latency, result.LatencyReused, result.LatencyNewConn)
+	}
+	if n := conns.Load(); n != 7 {
+		t.Errorf("server accepted %d connections, want 1 reused and 6 new", n)
+	}
+}
+
+func TestServerURLFallback(t *testing.T) {
+	st := New(&Config{ServerURL: "https://speed.cloudflare.com"})
+	if st.config.DownloadServerURL != "https://speed.cloudflare.com" || st.config.UploadServerURL != "https://speed.cloudflare.com" {
+		t.Errorf("download %q, upload %q", st.config.DownloadServerURL, st.config.UploadServerURL)
+	}
+	st = New(&Config{ServerURL: "https://speed.cloudflare.com", UploadServerURL: "http://vps.example.com:8080"})
+	if st.config.DownloadServerURL != "https://speed.cloudflare.com" || st.config.UploadServerURL != "http://vps.example.com:8080" {
+		t.Errorf("download %q, upload %q", st.config.DownloadServerURL, st.config.UploadServerURL)
+	}
+}
+
+// TestSeparateServers 检查延迟和下载请求发往下载服务器，上传请求发往上传服务器，结果里记录了两个地址
+func TestSeparateServers(t *testing.T) {
+	download, downloads := downloadServer(t)
+	upload, uploads := uploadServer(t, func(uploadRequest) int { return 0 })
+	st := New(&Config{
+		ServerURL:         "http://127.0.0.1:1",
+		DownloadServerURL: download.URL,
+		UploadServerURL:   upload.URL,
+		DownloadSize:      64 * 1024,
+		UploadSize:        32 * 1024,
+		UploadChunkSize:   32 * 1024,
+		Timeout:           5 * time.Second,
+		DownloadTimeout:   5 * time.Second,
+		MaxLatency:        5 * time.Second,
+		Concurrent:        1,
+	})
+	result := st.testProxy(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.DownloadServer != download.URL || result.UploadServer != upload.URL {
+		t.Errorf("result servers: download %q, upload %q", result.DownloadServer, result.UploadServer)
+	}
+	if result.Latency <= 0 || result.DownloadSpeed <= 0 || result.UploadSpeed <= 0 {
+		t.Fatalf("latency %s, download %v, upload %v, error %q", result.Latency, result.DownloadSpeed, result.UploadSpeed, result.Error)
+	}
+	latencyProbes, downloadRequests := 0, 0
+	for _, n := range downloads() {
+		if n == 0 {
+			latencyProbes++
+		} else {
+			downloadRequests++
+		}
+	}
+	if latencyProbes != 6 || downloadRequests == 0 {
+		t.Errorf("download server got %d latency probes and %d downloads", latencyProbes, downloadRequests)
+	}
+	if len(uploads()) == 0 {
+		t.Errorf("upload server got no requests")
+	}
+}
+
+func TestLoadProxiesSourceOptions(t *testing.T) {
+	var gotAuth, gotUA string
+	sub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotUA = r.Header.Get("Authorization"), r.Header.Get("User-Agent")
+		if gotAuth != "Bearer xxx" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("proxies:\n  - {name: HK 01, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}\n  - {name: JP 01, type: ss, server: 2.2.2.2, port: 443, cipher: aes-128-gcm, password: p}\n"))
+	}))
+	t.Cleanup(sub.Close)
+
+	st := New(&Config{
+		ConfigPaths:  sub.URL,
+		FilterRegex:  "HK",
+		FetchHeaders: map[string]string{"Authorization": "Bearer xxx"},
+		UserAgent:    "clash.meta",
+		SourceName:   "airport-a",
+	})
+	report, err := st.LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotUA != "clash.meta" {
+		t.Errorf("User-Agent %q", gotUA)
+	}
+	if len(report.Proxies) != 1 || report.Proxies["HK 01"] == nil {
+		t.Fatalf("loaded %d proxies, want HK 01 only", len(report.Proxies))
+	}
+	if source := report.Proxies["HK 01"].Source; source != "airport-a" {
+		t.Errorf("source %q, want the friendly name", source)
+	}
+}
+
+// ctx 取消后正在进行的下载和上传请求立即中断，不会等到超时
+func TestTestProxyCancelAbortsTransfers(t *testing.T) {
+	server := pacedServer(t, 0, 50*time.Millisecond, 1000, 1024)
+	st := New(&Config{
+		DownloadServerURL: server.URL,
+		UploadServerURL:   server.URL,
+		Timeout:           30 * time.Second,
+		DownloadTimeout:   30 * time.Second,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	st.testDownload(ctx, directProxy(t), st.config.DownloadTimeout, server.URL+"/__down?bytes=1048576")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("download returned %s after start, cancel was at 200ms", elapsed)
+	}
+
+	// 已经取消的 ctx 不再发起请求
+	start = time.Now()
+	if result := st.testUpload(ctx, directProxy(t), 1<<20, st.config.DownloadTimeout, server.URL); result != nil {
+		t.Errorf("upload with a cancelled ctx returned %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("upload with a cancelled ctx took %s", elapsed)
+	}
+}
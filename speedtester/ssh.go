@@ -0,0 +1,161 @@
+package speedtester
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// KnownHosts 是 OpenSSH known_hosts 文件的解析结果
+type KnownHosts struct {
+	plain  map[string][]string
+	hashed []hashedHostKey
+}
+
+type hashedHostKey struct {
+	salt []byte
+	hash []byte
+	key  string
+}
+
+// LoadKnownHosts 读取 OpenSSH 格式的 known_hosts 文件，支持哈希过的主机名
+func LoadKnownHosts(path string) (*KnownHosts, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	kh := &KnownHosts{plain: make(map[string][]string)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// @cert-authority / @revoked 这类标记不是普通的主机公钥，跳过
+		if strings.HasPrefix(fields[0], "@") || len(fields) < 3 {
+			continue
+		}
+		key := fields[1] + " " + fields[2]
+		for _, host := range strings.Split(fields[0], ",") {
+			if strings.HasPrefix(host, "|1|") {
+				parts := strings.Split(host[3:], "|")
+				if len(parts) != 2 {
+					continue
+				}
+				salt, err1 := base64.StdEncoding.DecodeString(parts[0])
+				hash, err2 := base64.StdEncoding.DecodeString(parts[1])
+				if err1 != nil || err2 != nil {
+					continue
+				}
+				kh.hashed = append(kh.hashed, hashedHostKey{salt: salt, hash: hash, key: key})
+				continue
+			}
+			kh.plain[host] = append(kh.plain[host], key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return kh, nil
+}
+
+// Lookup 返回 server:port 对应的主机公钥，格式与 mihomo ssh 节点的 host-key 字段一致
+func (kh *KnownHosts) Lookup(server string, port int) []string {
+	host := server
+	if port != 0 && port != 22 {
+		host = "[" + server + "]:" + strconv.Itoa(port)
+	}
+	keys := append([]string(nil), kh.plain[host]...)
+	for _, h := range kh.hashed {
+		mac := hmac.New(sha1.New, h.salt)
+		mac.Write([]byte(host))
+		if hmac.Equal(mac.Sum(nil), h.hash) {
+			keys = append(keys, h.key)
+		}
+	}
+	return keys
+}
+
+// injectSSHHostKey 给没有配置 host-key 的 ssh 节点补上 known_hosts 里的公钥，
+// 返回用于解析的配置副本以及该节点是否会校验主机公钥
+func injectSSHHostKey(config map[string]any, knownHosts *KnownHosts) (map[string]any, bool) {
+	if hasSSHHostKey(config) {
+		return config, true
+	}
+	if knownHosts == nil {
+		return config, false
+	}
+	server, _ := config["server"].(string)
+	port, _ := strconv.Atoi(strings.TrimSpace(toString(config["port"])))
+	keys := knownHosts.Lookup(strings.Trim(server, "[]"), port)
+	if len(keys) == 0 {
+		return config, false
+	}
+	injected := make(map[string]any, len(config)+1)
+	for k, v := range config {
+		injected[k] = v
+	}
+	hostKeys := make([]any, 0, len(keys))
+	for _, key := range keys {
+		hostKeys = append(hostKeys, key)
+	}
+	injected["host-key"] = hostKeys
+	return injected, true
+}
+
+func hasSSHHostKey(config map[string]any) bool {
+	switch v := config["host-key"].(type) {
+	case []any:
+		return len(v) > 0
+	case []string:
+		return len(v) > 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return ""
+}
+
+// describeSSHError 把 ssh 握手的错误归类，方便区分认证失败和主机公钥不匹配
+func describeSSHError(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "host key mismatch"):
+		return "ssh host key mismatch: " + msg
+	case strings.Contains(msg, "unable to authenticate"):
+		return "ssh auth failed: " + msg
+	case strings.Contains(msg, "parse host key"), strings.Contains(msg, "parse private key"):
+		return "ssh config error: " + msg
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return "ssh dial failed: " + msg
+	}
+	return msg
+}
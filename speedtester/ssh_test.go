@@ -0,0 +1,257 @@
+package speedtester
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hashKnownHost 按 OpenSSH HashKnownHosts 的格式哈希主机名
+func hashKnownHost(host string) string {
+	salt := make([]byte, sha1.Size)
+	rand.Read(salt)
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func writeKnownHosts(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKnownHosts(t *testing.T) {
+	path := writeKnownHosts(t, strings.Join([]string{
+		"# comment",
+		"",
+		"example.com,1.2.3.4 ssh-ed25519 AAAAplain",
+		"[example.com]:2222 ssh-rsa AAAAport",
+		hashKnownHost("hashed.example.com") + " ssh-ed25519 AAAAhashed",
+		"@cert-authority *.example.com ssh-rsa AAAAca",
+		"@revoked example.com ssh-rsa AAAArevoked",
+		"|1|broken ssh-rsa AAAAbroken",
+		"short.example.com",
+	}, "\n"))
+	kh, err := LoadKnownHosts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		server string
+		port   int
+		want   []string
+	}{
+		{"example.com", 22, []string{"ssh-ed25519 AAAAplain"}},
+		{"example.com", 0, []string{"ssh-ed25519 AAAAplain"}},
+		{"1.2.3.4", 22, []string{"ssh-ed25519 AAAAplain"}},
+		{"example.com", 2222, []string{"ssh-rsa AAAAport"}},
+		{"hashed.example.com", 22, []string{"ssh-ed25519 AAAAhashed"}},
+		{"other.example.com", 22, nil},
+		{"short.example.com", 22, nil},
+	} {
+		got := kh.Lookup(tc.server, tc.port)
+		if strings.Join(got, "|") != strings.Join(tc.want, "|") {
+			t.Errorf("Lookup(%s, %d) = %q, want %q", tc.server, tc.port, got, tc.want)
+		}
+	}
+	if _, err := LoadKnownHosts(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing known_hosts file loaded without error")
+	}
+}
+
+func TestInjectSSHHostKey(t *testing.T) {
+	kh, err := LoadKnownHosts(writeKnownHosts(t, "[10.0.0.1]:2222 ssh-ed25519 AAAAkey\n::1 ssh-ed25519 AAAAv6\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := map[string]any{"type": "ssh", "server": "10.0.0.1", "port": 2222}
+	injected, verified := injectSSHHostKey(config, kh)
+	if !verified || fmt.Sprint(injected["host-key"]) != "[ssh-ed25519 AAAAkey]" {
+		t.Errorf("host key not injected: %v %v", verified, injected)
+	}
+	if _, ok := config["host-key"]; ok {
+		t.Error("injectSSHHostKey modified the original config")
+	}
+
+	if _, verified := injectSSHHostKey(map[string]any{"server": "[::1]", "port": "22"}, kh); !verified {
+		t.Error("bracketed ipv6 server not matched")
+	}
+	if _, verified := injectSSHHostKey(map[string]any{"server": "10.0.0.2", "port": 22}, kh); verified {
+		t.Error("unknown host reported as verified")
+	}
+	if _, verified := injectSSHHostKey(map[string]any{"server": "10.0.0.2", "host-key": []any{"ssh-rsa AAAA"}}, nil); !verified {
+		t.Error("configured host-key not reported as verified")
+	}
+	if _, verified := injectSSHHostKey(map[string]any{"server": "10.0.0.1", "port": 2222}, nil); verified {
+		t.Error("verified without known_hosts")
+	}
+}
+
+// sshServer 启动一个接受 password 认证、转发 direct-tcpip 通道的 ssh 服务器，返回端口和主机公钥
+func sshServer(t *testing.T) (int, ssh.PublicKey) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "user" && string(password) == "pass" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, signer.PublicKey()
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+			newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+			continue
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			upstream.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channelRequests)
+		go func() {
+			defer channel.Close()
+			defer upstream.Close()
+			go io.Copy(upstream, channel)
+			io.Copy(channel, upstream)
+		}()
+	}
+}
+
+// TestSSHKnownHostsEndToEnd 通过本地 ssh 服务器验证 known_hosts 里的公钥被注入 mihomo 的 ssh 节点：
+// 公钥匹配时能通过节点访问，不匹配时握手失败并归类为主机公钥不匹配
+func TestSSHKnownHostsEndToEnd(t *testing.T) {
+	port, hostKey := sshServer(t)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+
+	for _, tc := range []struct {
+		name    string
+		key     ssh.PublicKey
+		wantErr string
+	}{
+		{"matching key", hostKey, ""},
+		{"mismatching key", otherSigner.PublicKey(), "ssh host key mismatch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			knownHosts := filepath.Join(dir, "known_hosts")
+			line := fmt.Sprintf("[127.0.0.1]:%d %s", port, ssh.MarshalAuthorizedKey(tc.key))
+			os.WriteFile(knownHosts, []byte(line), 0o600)
+			configPath := filepath.Join(dir, "config.yaml")
+			os.WriteFile(configPath, []byte(fmt.Sprintf(`proxies:
+  - {name: node, type: ssh, server: 127.0.0.1, port: %d, username: user, password: pass}
+`, port)), 0o600)
+
+			st := New(&Config{ConfigPaths: configPath, SSHKnownHosts: knownHosts, Timeout: 5 * time.Second})
+			report, err := st.LoadProxies(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy := report.Proxies["node"]
+			if proxy == nil || !proxy.SSHVerified {
+				t.Fatalf("ssh node not loaded as verified: %+v", report)
+			}
+			client := st.createClient(proxy, 5*time.Second)
+			resp, err := client.Get(target.URL)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("request through ssh node failed: %v", err)
+				}
+				resp.Body.Close()
+				return
+			}
+			if err == nil {
+				resp.Body.Close()
+				t.Fatal("request succeeded with a mismatching host key")
+			}
+			if got := describeSSHError(err); !strings.HasPrefix(got, tc.wantErr) {
+				t.Errorf("describeSSHError = %q, want prefix %q", got, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDescribeSSHError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("ssh: handshake failed: host key mismatch"), "ssh host key mismatch: "},
+		{fmt.Errorf("ssh: handshake failed: ssh: unable to authenticate"), "ssh auth failed: "},
+		{fmt.Errorf("ssh: parse private key: bad"), "ssh config error: "},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("refused")}, "ssh dial failed: "},
+		{fmt.Errorf("other"), "other"},
+	} {
+		if got := describeSSHError(tc.err); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("describeSSHError(%v) = %q, want prefix %q", tc.err, got, tc.want)
+		}
+	}
+}
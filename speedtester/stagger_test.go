@@ -0,0 +1,141 @@
+package speedtester
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunStreamsStagger(t *testing.T) {
+	const stagger = 60 * time.Millisecond
+	st := New(&Config{StreamStagger: stagger})
+	var mu sync.Mutex
+	var starts []time.Duration
+	var n atomic.Int64
+	begin := time.Now()
+	results := st.runStreams(context.Background(), 4, func() *downloadResult {
+		mu.Lock()
+		starts = append(starts, time.Since(begin))
+		mu.Unlock()
+		return &downloadResult{bytes: n.Add(1)}
+	})
+	if len(results) != 4 || slices.Contains(results, nil) {
+		t.Fatalf("results %v", results)
+	}
+	slices.Sort(starts)
+	for i, start := range starts {
+		want := time.Duration(i) * stagger
+		if start < want-5*time.Millisecond || start > want+stagger/2 {
+			t.Errorf("stream %d started at %s, want about %s", i, start, want)
+		}
+	}
+}
+
+func TestRunStreamsCancel(t *testing.T) {
+	st := New(&Config{StreamStagger: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results := st.runStreams(ctx, 3, func() *downloadResult { return &downloadResult{bytes: 1} })
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled runStreams took %s", elapsed)
+	}
+	// 第一个连接立即开始，后面的还没到开始时间就被取消了
+	if results[0] == nil || results[1] != nil || results[2] != nil {
+		t.Errorf("results %v, want only the first stream", results)
+	}
+}
+
+func TestUploadConcurrentDefault(t *testing.T) {
+	if got := New(&Config{Concurrent: 3}).uploadConcurrent(); got != 3 {
+		t.Errorf("uploadConcurrent = %d, want -concurrent 3", got)
+	}
+	if got := New(&Config{Concurrent: 3, UploadConcurrent: 1}).uploadConcurrent(); got != 1 {
+		t.Errorf("uploadConcurrent = %d, want 1", got)
+	}
+}
+
+// streamServer 是限速的测速服务器，记录每个下载和上传请求到达的时间。latency 探测（bytes=0）不记录
+type streamServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	begin     time.Time
+	downloads []time.Duration
+	uploads   []time.Duration
+}
+
+func newStreamServer(t *testing.T) *streamServer {
+	t.Helper()
+	s := &streamServer{begin: time.Now()}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			s.record(&s.uploads)
+			io.Copy(io.Discard, r.Body)
+			return
+		}
+		n, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		if n == 0 {
+			return
+		}
+		s.record(&s.downloads)
+		// 每 20ms 发 16KiB，每个连接持续一段时间，错开开始的连接之间会有重叠
+		chunk := make([]byte, 16*1024)
+		for sent := 0; sent < n; sent += len(chunk) {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := w.Write(chunk[:min(len(chunk), n-sent)]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *streamServer) record(times *[]time.Duration) {
+	s.mu.Lock()
+	*times = append(*times, time.Since(s.begin))
+	s.mu.Unlock()
+}
+
+// TestStreamCountsAndStagger 下载和上传按各自的连接数发起，相邻连接的开始时间间隔 StreamStagger
+func TestStreamCountsAndStagger(t *testing.T) {
+	const stagger = 80 * time.Millisecond
+	server := newStreamServer(t)
+	st := New(&Config{
+		ServerURL:        server.URL,
+		DownloadSize:     4 * 64 * 1024,
+		UploadSize:       64 * 1024,
+		UploadChunkSize:  64 * 1024,
+		Timeout:          5 * time.Second,
+		DownloadTimeout:  5 * time.Second,
+		MaxLatency:       5 * time.Second,
+		Concurrent:       4,
+		UploadConcurrent: 1,
+		StreamStagger:    stagger,
+	})
+	result := st.testProxy(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.DownloadSpeed <= 0 || result.UploadSpeed <= 0 {
+		t.Fatalf("download %v, upload %v, error %q", result.DownloadSpeed, result.UploadSpeed, result.Error)
+	}
+
+	server.mu.Lock()
+	downloads, uploads := slices.Clone(server.downloads), slices.Clone(server.uploads)
+	server.mu.Unlock()
+	if len(downloads) != 4 || len(uploads) != 1 {
+		t.Fatalf("got %d download and %d upload requests, want 4 and 1", len(downloads), len(uploads))
+	}
+	slices.Sort(downloads)
+	for i := 1; i < len(downloads); i++ {
+		if gap := downloads[i] - downloads[i-1]; gap < stagger-10*time.Millisecond || gap > 2*stagger {
+			t.Errorf("download %d started %s after the previous one, want about %s", i, gap, stagger)
+		}
+	}
+}
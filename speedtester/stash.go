@@ -0,0 +1,107 @@
+package speedtester
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// normalizeForStash 把 Stash 不认识、但有等价写法的选项改写成 Stash 的写法，返回改写后的副本和改写了哪些项：
+//   - flow 为空字符串时删除，空的 flow 和不写含义相同，但 Stash 会当成未知的 flow 报错
+//   - vmess、vless、trojan 的 network: tcp 删除，tcp 是默认传输方式，Stash 只接受 ws/h2/http/grpc
+//   - 旧版的 ws-path、ws-headers 合并成 ws-opts，Stash 只支持 ws-opts
+func normalizeForStash(config map[string]any) (map[string]any, []string) {
+	if config == nil {
+		return nil, nil
+	}
+	config = maps.Clone(config)
+	var changes []string
+	if flow, ok := config["flow"]; ok && toString(flow) == "" {
+		delete(config, "flow")
+		changes = append(changes, "drop empty flow")
+	}
+	switch config["type"] {
+	case "vmess", "vless", "trojan":
+		if config["network"] == "tcp" {
+			delete(config, "network")
+			changes = append(changes, "drop network: tcp")
+		}
+	}
+	path, hasPath := config["ws-path"]
+	headers, hasHeaders := config["ws-headers"]
+	if hasPath || hasHeaders {
+		if _, ok := config["ws-opts"]; !ok {
+			opts := make(map[string]any)
+			if hasPath {
+				opts["path"] = path
+			}
+			if hasHeaders {
+				opts["headers"] = headers
+			}
+			config["ws-opts"] = opts
+			changes = append(changes, "move ws-path/ws-headers to ws-opts")
+		}
+		delete(config, "ws-path")
+		delete(config, "ws-headers")
+	}
+	return config, changes
+}
+
+// stashIncompatibleReason 返回节点不能导入 Stash 的原因，可以导入时返回空字符串。
+// config 应该已经经过 normalizeForStash
+func stashIncompatibleReason(proxyType constant.AdapterType, config map[string]any) string {
+	// unsupported 在 key 存在且值不在 allowed 里时返回原因
+	unsupported := func(key string, allowed ...string) string {
+		value, ok := config[key]
+		if !ok {
+			return ""
+		}
+		for _, v := range allowed {
+			if value == v {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%s %v is not supported by stash", key, value)
+	}
+	switch proxyType {
+	case constant.Shadowsocks:
+		return unsupported("cipher",
+			"aes-128-gcm", "aes-192-gcm", "aes-256-gcm",
+			"aes-128-cfb", "aes-192-cfb", "aes-256-cfb",
+			"aes-128-ctr", "aes-192-ctr", "aes-256-ctr",
+			"rc4-md5", "chacha20", "chacha20-ietf", "xchacha20",
+			"chacha20-ietf-poly1305", "xchacha20-ietf-poly1305",
+			"2022-blake3-aes-128-gcm", "2022-blake3-aes-256-gcm")
+	case constant.ShadowsocksR:
+		if reason := unsupported("obfs", "plain", "http_simple", "http_post", "random_head",
+			"tls1.2_ticket_auth", "tls1.2_ticket_fastauth"); reason != "" {
+			return reason
+		}
+		return unsupported("protocol", "origin", "auth_sha1_v4", "auth_aes128_md5",
+			"auth_aes128_sha1", "auth_chain_a", "auth_chain_b")
+	case constant.Snell:
+		if opts, ok := config["obfs-opts"].(map[string]any); ok {
+			if mode, ok := opts["mode"]; ok && mode != "http" && mode != "tls" {
+				return fmt.Sprintf("obfs mode %v is not supported by stash", mode)
+			}
+		}
+		return ""
+	case constant.Vmess:
+		if reason := unsupported("cipher", "auto", "aes-128-gcm", "chacha20-poly1305", "none"); reason != "" {
+			return reason
+		}
+		return unsupported("network", "ws", "h2", "http", "grpc")
+	case constant.Vless:
+		if reason := unsupported("flow", "xtls-rprx-origin", "xtls-rprx-direct", "xtls-rprx-splice", "xtls-rprx-vision"); reason != "" {
+			return reason
+		}
+		return unsupported("network", "ws", "h2", "http", "grpc")
+	case constant.Trojan:
+		return unsupported("network", "ws", "grpc")
+	case constant.Socks5, constant.Http, constant.Hysteria, constant.Hysteria2,
+		constant.WireGuard, constant.Tuic, constant.Ssh:
+		return ""
+	}
+	return fmt.Sprintf("type %s is not supported by stash", proxyType)
+}
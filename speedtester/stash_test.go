@@ -0,0 +1,158 @@
+package speedtester
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+func TestNormalizeForStash(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]any
+		want    map[string]any
+		changes []string
+	}{
+		{
+			name:   "unchanged",
+			config: map[string]any{"type": "vless", "flow": "xtls-rprx-vision", "network": "ws"},
+			want:   map[string]any{"type": "vless", "flow": "xtls-rprx-vision", "network": "ws"},
+		},
+		{
+			name:    "empty flow",
+			config:  map[string]any{"type": "vless", "flow": ""},
+			want:    map[string]any{"type": "vless"},
+			changes: []string{"drop empty flow"},
+		},
+		{
+			name:    "network tcp",
+			config:  map[string]any{"type": "vmess", "network": "tcp"},
+			want:    map[string]any{"type": "vmess"},
+			changes: []string{"drop network: tcp"},
+		},
+		{
+			// 只有 vmess、vless、trojan 的 network 是传输方式
+			name:   "network tcp on ss",
+			config: map[string]any{"type": "ss", "network": "tcp"},
+			want:   map[string]any{"type": "ss", "network": "tcp"},
+		},
+		{
+			name:    "legacy ws options",
+			config:  map[string]any{"type": "vmess", "network": "ws", "ws-path": "/ray", "ws-headers": map[string]any{"Host": "example.com"}},
+			want:    map[string]any{"type": "vmess", "network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"Host": "example.com"}}},
+			changes: []string{"move ws-path/ws-headers to ws-opts"},
+		},
+		{
+			// 已经有 ws-opts 时以 ws-opts 为准，旧写法直接删除
+			name:   "legacy ws options with ws-opts",
+			config: map[string]any{"type": "vmess", "ws-path": "/old", "ws-opts": map[string]any{"path": "/new"}},
+			want:   map[string]any{"type": "vmess", "ws-opts": map[string]any{"path": "/new"}},
+		},
+		{
+			name:    "all",
+			config:  map[string]any{"type": "trojan", "flow": "", "network": "tcp", "ws-path": "/x"},
+			want:    map[string]any{"type": "trojan", "ws-opts": map[string]any{"path": "/x"}},
+			changes: []string{"drop empty flow", "drop network: tcp", "move ws-path/ws-headers to ws-opts"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := maps.Clone(tt.config)
+			got, changes := normalizeForStash(tt.config)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("changes = %q, want %q", changes, tt.changes)
+			}
+			if !reflect.DeepEqual(tt.config, original) {
+				t.Errorf("input modified: %v", tt.config)
+			}
+		})
+	}
+	if got, changes := normalizeForStash(nil); got != nil || changes != nil {
+		t.Errorf("normalizeForStash(nil) = %v, %v", got, changes)
+	}
+}
+
+func TestStashIncompatibleReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxyType constant.AdapterType
+		config    map[string]any
+		want      string
+	}{
+		{"ss gcm", constant.Shadowsocks, map[string]any{"cipher": "aes-128-gcm"}, ""},
+		{"ss 2022", constant.Shadowsocks, map[string]any{"cipher": "2022-blake3-aes-256-gcm"}, ""},
+		{"ss chacha20 2022", constant.Shadowsocks, map[string]any{"cipher": "2022-blake3-chacha20-poly1305"}, "cipher 2022-blake3-chacha20-poly1305 is not supported by stash"},
+		{"ssr", constant.ShadowsocksR, map[string]any{"obfs": "plain", "protocol": "auth_chain_a"}, ""},
+		{"ssr obfs", constant.ShadowsocksR, map[string]any{"obfs": "tls1.2_ticket_auth_compatible", "protocol": "origin"}, "obfs tls1.2_ticket_auth_compatible is not supported by stash"},
+		{"ssr protocol", constant.ShadowsocksR, map[string]any{"obfs": "plain", "protocol": "auth_chain_c"}, "protocol auth_chain_c is not supported by stash"},
+		{"snell tls", constant.Snell, map[string]any{"obfs-opts": map[string]any{"mode": "tls"}}, ""},
+		{"snell obfs", constant.Snell, map[string]any{"obfs-opts": map[string]any{"mode": "websocket"}}, "obfs mode websocket is not supported by stash"},
+		{"vmess ws", constant.Vmess, map[string]any{"cipher": "auto", "network": "ws"}, ""},
+		{"vmess cipher", constant.Vmess, map[string]any{"cipher": "zero"}, "cipher zero is not supported by stash"},
+		{"vmess httpupgrade", constant.Vmess, map[string]any{"cipher": "auto", "network": "httpupgrade"}, "network httpupgrade is not supported by stash"},
+		{"vless vision", constant.Vless, map[string]any{"flow": "xtls-rprx-vision"}, ""},
+		{"vless flow", constant.Vless, map[string]any{"flow": "xtls-rprx-vision-udp443"}, "flow xtls-rprx-vision-udp443 is not supported by stash"},
+		{"vless grpc", constant.Vless, map[string]any{"network": "grpc"}, ""},
+		{"vless xhttp", constant.Vless, map[string]any{"network": "xhttp"}, "network xhttp is not supported by stash"},
+		{"trojan grpc", constant.Trojan, map[string]any{"network": "grpc"}, ""},
+		{"trojan h2", constant.Trojan, map[string]any{"network": "h2"}, "network h2 is not supported by stash"},
+		{"hysteria2", constant.Hysteria2, map[string]any{}, ""},
+		{"tuic", constant.Tuic, map[string]any{}, ""},
+		{"wireguard", constant.WireGuard, map[string]any{}, ""},
+		{"mieru", constant.Mieru, map[string]any{}, "type Mieru is not supported by stash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stashIncompatibleReason(tt.proxyType, tt.config); got != tt.want {
+				t.Errorf("stashIncompatibleReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// LoadProxies(true) 丢弃 Stash 不能导入的节点，保留下来的节点配置是改写后的
+func TestLoadProxiesStashCompatible(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: SS, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: SS 2022, type: ss, server: 1.1.1.2, port: 443, cipher: 2022-blake3-chacha20-poly1305, password: AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+  - {name: VLESS, type: vless, server: 1.1.1.3, port: 443, uuid: b831381d-6324-4d53-ad4f-8cda48b30811, flow: "", network: tcp, tls: true}
+  - {name: VMESS, type: vmess, server: 1.1.1.4, port: 443, uuid: b831381d-6324-4d53-ad4f-8cda48b30811, alterId: 0, cipher: auto, network: ws, ws-path: /ray}
+`)
+	for _, stash := range []bool{false, true} {
+		report, err := New(&Config{ConfigPaths: path}).LoadProxies(stash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := slices.Sorted(maps.Keys(report.Proxies))
+		if !stash {
+			if len(names) != 4 || report.StashIncompatible != 0 {
+				t.Errorf("without stash: %v, %d incompatible", names, report.StashIncompatible)
+			}
+			// 空的 flow 由 NormalizeProxy 删除，network: tcp 只在 Stash 模式下删除
+			if report.Proxies["VLESS"].Config["network"] != "tcp" {
+				t.Error("config rewritten without stash")
+			}
+			continue
+		}
+		if !slices.Equal(names, []string{"SS", "VLESS", "VMESS"}) || report.StashIncompatible != 1 {
+			t.Fatalf("with stash: %v, %d incompatible", names, report.StashIncompatible)
+		}
+		vless := report.Proxies["VLESS"].Config
+		if _, ok := vless["flow"]; ok {
+			t.Errorf("vless keeps empty flow: %v", vless)
+		}
+		if _, ok := vless["network"]; ok {
+			t.Errorf("vless keeps network: tcp: %v", vless)
+		}
+		vmess := report.Proxies["VMESS"].Config
+		if opts, ok := vmess["ws-opts"].(map[string]any); !ok || opts["path"] != "/ray" || vmess["ws-path"] != nil {
+			t.Errorf("vmess ws options not moved: %v", vmess)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package speedtester
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// ExitSubnet 返回出口 IP 所在的 /24（IPv4）或 /48（IPv6）网段，IP 无效时返回空字符串
+func ExitSubnet(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// AssignSubnetPeers 统计每个节点的出口网段里还有多少个其他节点，结果写入 ExitSubnetPeers。
+// 没有出口 IP 的节点不参与统计
+func AssignSubnetPeers(results []*Result) {
+	groups := make(map[string]int)
+	for _, result := range results {
+		if subnet := ExitSubnet(result.ExitIP); subnet != "" {
+			groups[subnet]++
+		}
+	}
+	for _, result := range results {
+		result.ExitSubnetPeers = 0
+		if subnet := ExitSubnet(result.ExitIP); subnet != "" {
+			result.ExitSubnetPeers = groups[subnet] - 1
+		}
+	}
+}
+
+// LimitPerSubnet 每个出口网段最多保留 max 个下载速度最快的节点，keep 返回 true 的节点不受限制也不占名额。
+// 返回的节点保持 results 原来的顺序
+func LimitPerSubnet(results []*Result, max int, keep func(*Result) bool) []*Result {
+	if max <= 0 {
+		return results
+	}
+	bySpeed := append([]*Result(nil), results...)
+	sort.SliceStable(bySpeed, func(i, j int) bool {
+		if bySpeed[i].DownloadSpeed != bySpeed[j].DownloadSpeed {
+			return bySpeed[i].DownloadSpeed > bySpeed[j].DownloadSpeed
+		}
+		return NodeKey(bySpeed[i].ProxyConfig) < NodeKey(bySpeed[j].ProxyConfig)
+	})
+	counts := make(map[string]int)
+	dropped := make(map[*Result]bool)
+	for _, result := range bySpeed {
+		subnet := ExitSubnet(result.ExitIP)
+		if subnet == "" || (keep != nil && keep(result)) {
+			continue
+		}
+		if counts[subnet] >= max {
+			dropped[result] = true
+			continue
+		}
+		counts[subnet]++
+	}
+
+	limited := make([]*Result, 0, len(results)-len(dropped))
+	for _, result := range results {
+		if !dropped[result] {
+			limited = append(limited, result)
+		}
+	}
+	return limited
+}
@@ -0,0 +1,84 @@
+package speedtester
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExitSubnet(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":           "203.0.113.0/24",
+		"::ffff:203.0.113.7":    "203.0.113.0/24",
+		"2001:db8:1234:5678::1": "2001:db8:1234::/48",
+		"":                      "",
+		"not an ip":             "",
+	}
+	for ip, want := range tests {
+		if got := ExitSubnet(ip); got != want {
+			t.Errorf("ExitSubnet(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func subnetResult(name, exitIP string, speed float64) *Result {
+	return &Result{
+		ProxyName: name, ExitIP: exitIP, DownloadSpeed: speed,
+		ProxyConfig: map[string]any{"name": name, "type": "ss", "server": name + ".example.com", "port": 443},
+	}
+}
+
+func TestAssignSubnetPeers(t *testing.T) {
+	results := []*Result{
+		subnetResult("a", "203.0.113.1", 0),
+		subnetResult("b", "203.0.113.200", 0),
+		subnetResult("c", "::ffff:203.0.113.9", 0),
+		subnetResult("d", "198.51.100.1", 0),
+		subnetResult("e", "2001:db8:1::1", 0),
+		subnetResult("f", "2001:db8:1:ffff::2", 0),
+		subnetResult("g", "2001:db8:2::1", 0),
+		subnetResult("h", "", 0),
+	}
+	// 上一次统计留下的值会被覆盖
+	results[7].ExitSubnetPeers = 5
+	AssignSubnetPeers(results)
+	want := []int{2, 2, 2, 0, 1, 1, 0, 0}
+	var got []int
+	for _, result := range results {
+		got = append(got, result.ExitSubnetPeers)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ExitSubnetPeers = %v, want %v", got, want)
+	}
+}
+
+func TestLimitPerSubnet(t *testing.T) {
+	results := []*Result{
+		subnetResult("slow", "203.0.113.1", 1),
+		subnetResult("fast", "203.0.113.2", 3),
+		subnetResult("mid", "203.0.113.3", 2),
+		subnetResult("pinned", "203.0.113.4", 0),
+		subnetResult("v6a", "2001:db8:1::1", 1),
+		subnetResult("v6b", "2001:db8:1::2", 2),
+		subnetResult("noip", "", 0),
+		subnetResult("noip2", "", 0),
+	}
+	names := func(results []*Result) []string {
+		var names []string
+		for _, result := range results {
+			names = append(names, result.ProxyName)
+		}
+		return names
+	}
+	keep := func(result *Result) bool { return result.ProxyName == "pinned" }
+
+	// 保留每个网段最快的节点，顺序不变，固定的节点和没有出口 IP 的节点不受限制
+	if got, want := names(LimitPerSubnet(results, 1, keep)), []string{"fast", "pinned", "v6b", "noip", "noip2"}; !slices.Equal(got, want) {
+		t.Errorf("max 1: %v, want %v", got, want)
+	}
+	if got, want := names(LimitPerSubnet(results, 2, nil)), []string{"fast", "mid", "v6a", "v6b", "noip", "noip2"}; !slices.Equal(got, want) {
+		t.Errorf("max 2: %v, want %v", got, want)
+	}
+	if got := LimitPerSubnet(results, 0, nil); len(got) != len(results) {
+		t.Errorf("max 0 dropped nodes: %v", names(got))
+	}
+}
@@ -0,0 +1,93 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// sustainedTail 是计算持续速度时取的最后一段时间，测试时间较短时取后一半
+const sustainedTail = 10 * time.Second
+
+// sustainedEligible 只对可用的节点做持续下载测试：下载速度不低于 MinSpeed，也没有直连泄漏
+func (st *SpeedTester) sustainedEligible(result *Result) bool {
+	return result.DownloadSpeed > 0 && result.DownloadSpeed >= st.config.MinSpeed && !result.DirectLeak
+}
+
+// testSustained 在常规下载测试之后用 createClient 建的客户端继续下载 SustainedDuration，
+// 取最后一段时间的平均速度，用来发现先放行一段流量再限速的节点。
+// 下载总量不超过 SustainedMaxSize
+func (st *SpeedTester) testSustained(parent context.Context, proxy constant.Proxy, result *Result) {
+	ctx, cancel := context.WithTimeout(parent, st.config.SustainedDuration)
+	defer cancel()
+	client := st.createClient(proxy, st.config.SustainedDuration+st.config.Timeout)
+	meter := &rateMeter{start: time.Now()}
+	for remaining := st.config.SustainedMaxSize; remaining > 0 && ctx.Err() == nil; {
+		size := remaining
+		if max := st.maxDownloadRequest(); max > 0 && size > max {
+			size = max
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/__down?bytes=%d", st.config.DownloadServerURL, size), nil)
+		if err != nil {
+			break
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			break
+		}
+		written, _ := readBody(resp.Body, 0, st.measureSinks(meter)...)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || written == 0 {
+			break
+		}
+		remaining -= int(written)
+	}
+
+	result.SustainedSpeed = meter.tailSpeed(sustainedTail)
+	if result.DownloadSpeed > 0 {
+		result.ThrottleRatio = result.SustainedSpeed / result.DownloadSpeed
+	}
+}
+
+// rateMeter 按秒统计写入的字节数
+type rateMeter struct {
+	start   time.Time
+	buckets []int64
+}
+
+func (m *rateMeter) Write(p []byte) (int, error) {
+	second := int(time.Since(m.start) / time.Second)
+	for len(m.buckets) <= second {
+		m.buckets = append(m.buckets, 0)
+	}
+	m.buckets[second] += int64(len(p))
+	return len(p), nil
+}
+
+// tailSpeed 返回最后 tail 时间内的平均速度（字节/秒），不满一秒的最后一段不计入。
+// 总时长不到 2*tail 时取后一半，不到一秒时按全部数据计算
+func (m *rateMeter) tailSpeed(tail time.Duration) float64 {
+	full := len(m.buckets) - 1
+	if full < 1 {
+		elapsed := time.Since(m.start).Seconds()
+		if len(m.buckets) == 0 || elapsed <= 0 {
+			return 0
+		}
+		return float64(m.buckets[0]) / elapsed
+	}
+	window := int(tail / time.Second)
+	if window > full/2 {
+		window = full / 2
+	}
+	if window < 1 {
+		window = 1
+	}
+	var total int64
+	for _, n := range m.buckets[full-window : full] {
+		total += n
+	}
+	return float64(total) / float64(window)
+}
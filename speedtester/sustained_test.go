@@ -0,0 +1,101 @@
+package speedtester
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTailSpeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		buckets []int64
+		tail    time.Duration
+		want    float64
+	}{
+		// 最后一个桶不满一秒，不计入
+		{"last window", []int64{100, 100, 10, 10, 999}, 2 * time.Second, 10},
+		{"half of short run", []int64{100, 100, 10, 10, 999}, 10 * time.Second, 10},
+		{"single full second", []int64{50, 999}, 10 * time.Second, 50},
+		{"throttled after burst", []int64{800, 800, 800, 100, 100, 100, 100, 100, 100, 0}, 3 * time.Second, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &rateMeter{start: time.Now(), buckets: tt.buckets}
+			if got := m.tailSpeed(tt.tail); got != tt.want {
+				t.Errorf("tailSpeed = %g, want %g", got, tt.want)
+			}
+		})
+	}
+
+	if got := (&rateMeter{start: time.Now()}).tailSpeed(time.Second); got != 0 {
+		t.Errorf("tailSpeed without data = %g, want 0", got)
+	}
+	// 不到一秒时按实际时长计算
+	m := &rateMeter{start: time.Now().Add(-500 * time.Millisecond), buckets: []int64{1000}}
+	if got := m.tailSpeed(time.Second); got < 1500 || got > 2000 {
+		t.Errorf("tailSpeed of half a second = %g, want about 2000", got)
+	}
+}
+
+// 下载总量达到 SustainedMaxSize 就提前结束
+func TestTestSustainedMaxSize(t *testing.T) {
+	server, requested := downloadServer(t)
+	st := New(&Config{DownloadServerURL: server.URL, Timeout: 5 * time.Second, SustainedDuration: 10 * time.Second, SustainedMaxSize: 1 << 20})
+	result := &Result{DownloadSpeed: 1 << 40}
+	start := time.Now()
+	st.testSustained(context.Background(), directProxy(t), result)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sustained test took %s after reaching the size limit", elapsed)
+	}
+	if got := requested(); !slices.Equal(got, []int{1 << 20}) {
+		t.Errorf("requested %v", got)
+	}
+	if result.SustainedSpeed <= 0 || result.ThrottleRatio <= 0 || result.ThrottleRatio >= 1 {
+		t.Errorf("sustained %g, throttle ratio %g", result.SustainedSpeed, result.ThrottleRatio)
+	}
+}
+
+// 到了 SustainedDuration 就结束，速度取自最后一段时间
+func TestTestSustainedDuration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("paces a download for two seconds")
+	}
+	server := pacedServer(t, 0, 50*time.Millisecond, 1000, 10*1024)
+	st := New(&Config{DownloadServerURL: server.URL, Timeout: 5 * time.Second, SustainedDuration: 2500 * time.Millisecond, SustainedMaxSize: 1 << 30})
+	result := &Result{}
+	start := time.Now()
+	st.testSustained(context.Background(), directProxy(t), result)
+	if elapsed := time.Since(start); elapsed < 2*time.Second || elapsed > 4*time.Second {
+		t.Errorf("sustained test took %s, want about 2.5s", elapsed)
+	}
+	// 每秒 20 块，每块 10KB
+	if want := 200.0 * 1024; result.SustainedSpeed < want*0.7 || result.SustainedSpeed > want*1.3 {
+		t.Errorf("sustained speed %g, want about %g", result.SustainedSpeed, want)
+	}
+	if result.ThrottleRatio != 0 {
+		t.Errorf("throttle ratio %g without a download speed", result.ThrottleRatio)
+	}
+}
+
+func TestSustainedEligible(t *testing.T) {
+	st := New(&Config{MinSpeed: 1 << 20})
+	tests := []struct {
+		name   string
+		result *Result
+		want   bool
+	}{
+		{"usable", &Result{DownloadSpeed: 2 << 20}, true},
+		{"below min speed", &Result{DownloadSpeed: 1 << 10}, false},
+		{"download failed", &Result{}, false},
+		{"direct leak", &Result{DownloadSpeed: 2 << 20, DirectLeak: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := st.sustainedEligible(tt.result); got != tt.want {
+				t.Errorf("sustainedEligible = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
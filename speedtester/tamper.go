@@ -0,0 +1,144 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+)
+
+// KnownContent 是 download-server 在 /__known 返回的固定 HTML 页面，几 KB 大小。
+// 用 HTML 而不是二进制数据，是因为注入广告的节点通常只改写 text/html 的响应
+var KnownContent = buildKnownContent()
+
+// KnownContentSHA256 是 KnownContent 的 sha256，-tamper-check 没有指定 -tamper-check-sha256 时用它比较
+var KnownContentSHA256 = sha256Hex(KnownContent)
+
+// maxTamperBodySize 是篡改检测最多读取的响应大小，被注入的页面通常只比原文大几 KB
+const maxTamperBodySize = 1 << 20
+
+func buildKnownContent() []byte {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>clash-speedtest known content</title>\n</head>\n<body>\n")
+	for i := 1; i <= 64; i++ {
+		fmt.Fprintf(&b, "<p id=\"line-%02d\">line %02d: this page is served unchanged, a node that alters it tampers with plain http traffic.</p>\n", i, i)
+	}
+	b.WriteString("</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// tamperCheckURL 返回篡改检测使用的地址，没有指定时使用下载服务器的 /__known
+func (st *SpeedTester) tamperCheckURL() string {
+	if st.config.TamperCheckURL != "" {
+		return st.config.TamperCheckURL
+	}
+	return st.config.DownloadServerURL + "/__known"
+}
+
+// tamperBaseline 是不经过节点直接下载一次检测页面的结果，整个测试只下载一次。
+// 直连拿到的内容就和预期的 sha256 不一致时（地址返回的不是 /__known 页面、页面是动态的或者本地网络本身在篡改），
+// 逐个节点比较只会得到误报，检测整体停用
+type tamperBaseline struct {
+	once    sync.Once
+	enabled bool
+}
+
+// tamperCheckEnabled 在第一次调用时直连下载检测页面并和预期比较
+func (st *SpeedTester) tamperCheckEnabled(ctx context.Context) bool {
+	b := st.tamperBaseline
+	b.once.Do(func() {
+		url := st.tamperCheckURL()
+		body, err := fetchTamperPage(ctx, &http.Client{Timeout: st.config.Timeout}, url)
+		if err != nil {
+			log.Warnln("[tamper] fetch %s directly failed, tamper check disabled: %v", url, err)
+			return
+		}
+		if want, _ := st.tamperExpectation(); !strings.EqualFold(sha256Hex(body), want) {
+			log.Warnln("[tamper] %s does not match the expected sha256 without a proxy, tamper check disabled", url)
+			return
+		}
+		b.enabled = true
+	})
+	return b.enabled
+}
+
+// tamperExpectation 返回检测页面预期的 sha256 和原文，使用自定义地址时没有原文
+func (st *SpeedTester) tamperExpectation() (string, []byte) {
+	if st.config.TamperCheckSHA256 != "" {
+		return st.config.TamperCheckSHA256, nil
+	}
+	return KnownContentSHA256, KnownContent
+}
+
+// fetchTamperPage 下载检测页面，最多读取 maxTamperBodySize
+func fetchTamperPage(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 不接受压缩，避免节点正常的转码也被当成篡改
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxTamperBodySize))
+}
+
+// testContentTampering 通过节点下载已知内容并比较 sha256，不一致时记录 ContentTampering 和差异摘要。
+// 直连的基准不一致、请求失败或服务器不支持时不做判断
+func (st *SpeedTester) testContentTampering(ctx context.Context, proxy constant.Proxy, result *Result) {
+	if !st.tamperCheckEnabled(ctx) {
+		return
+	}
+	url := st.tamperCheckURL()
+	body, err := fetchTamperPage(ctx, st.createClient(proxy, st.config.Timeout), url)
+	if err != nil {
+		log.Debugln("[tamper] %s: fetch %s failed: %v", result.ProxyName, url, err)
+		return
+	}
+	want, original := st.tamperExpectation()
+	if strings.EqualFold(sha256Hex(body), want) {
+		return
+	}
+	result.ContentTampering = true
+	result.TamperDiff = describeTampering(original, body)
+	log.Warnln("[tamper] %s: response of %s was altered: %s", result.ProxyName, url, result.TamperDiff)
+}
+
+// describeTampering 返回篡改的摘要：收到的大小、与原文的差值和第一个不同字节的位置。
+// 使用自定义地址时没有原文，只能给出大小和 sha256
+func describeTampering(original, got []byte) string {
+	if original == nil {
+		return fmt.Sprintf("%d bytes, sha256 %s", len(got), sha256Hex(got))
+	}
+	offset := 0
+	for offset < len(original) && offset < len(got) && original[offset] == got[offset] {
+		offset++
+	}
+	summary := fmt.Sprintf("%d bytes (%+d), first difference at offset %d", len(got), len(got)-len(original), offset)
+	if offset < len(got) {
+		// 附上插入内容的开头，通常能直接看出是哪家的广告脚本
+		snippet := got[offset:min(len(got), offset+60)]
+		summary += fmt.Sprintf(": %q", bytes.TrimSpace(snippet))
+	}
+	return summary
+}
@@ -0,0 +1,214 @@
+package speedtester
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/constant"
+)
+
+const injectedScript = `<script src="http://ads.example.com/x.js"></script>`
+
+// tamperServers 启动源站和经过它的 HTTP 代理节点。源站在 /__known 返回 KnownContent，在 /page 返回 page，
+// requests 是源站收到的请求数；代理节点转发明文 HTTP 请求，inject 为 true 时在 </body> 前插入广告脚本，
+// 就像注入广告的节点那样，直连源站的请求不受影响
+func tamperServers(t *testing.T, page []byte, inject bool) (origin *httptest.Server, node constant.Proxy, requests *atomic.Int64) {
+	t.Helper()
+	requests = &atomic.Int64{}
+	origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("Accept-Encoding") != "identity" {
+			t.Errorf("Accept-Encoding %q, want identity", r.Header.Get("Accept-Encoding"))
+		}
+		switch r.URL.Path {
+		case "/__known":
+			w.Write(KnownContent)
+		case "/page":
+			w.Write(page)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(origin.Close)
+	// mihomo 的 http 节点对所有请求都用 CONNECT，中间人在隧道里读出明文请求转给源站再改写响应
+	middleman := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(buf.Reader)
+		if err != nil {
+			return
+		}
+		req.URL.Scheme, req.URL.Host, req.RequestURI = "http", r.Host, ""
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if inject {
+			body = bytes.Replace(body, []byte("</body>"), []byte(injectedScript+"</body>"), 1)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+		resp.Write(conn)
+	}))
+	t.Cleanup(middleman.Close)
+	_, port, _ := net.SplitHostPort(middleman.Listener.Addr().String())
+	node, err := adapter.ParseProxy(map[string]any{"name": "middleman", "type": "http", "server": "127.0.0.1", "port": port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return origin, node, requests
+}
+
+func TestContentTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		inject bool
+		want   bool
+	}{
+		{"unchanged", false, false},
+		{"injected", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin, node, _ := tamperServers(t, nil, tt.inject)
+			st := New(&Config{ServerURL: origin.URL, Timeout: 5 * time.Second, TamperCheck: true})
+			result := &Result{ProxyName: "node"}
+			st.testContentTampering(t.Context(), node, result)
+			if result.ContentTampering != tt.want {
+				t.Fatalf("ContentTampering = %v, want %v (%s)", result.ContentTampering, tt.want, result.TamperDiff)
+			}
+			if !tt.want {
+				if result.TamperDiff != "" {
+					t.Errorf("TamperDiff = %q on unchanged content", result.TamperDiff)
+				}
+				return
+			}
+			// 插入的 <script 和原文的 </body> 开头都是 <，第一个不同的字节在它后面
+			offset := bytes.Index(KnownContent, []byte("</body>")) + 1
+			for _, want := range []string{
+				"(+" + strconv.Itoa(len(injectedScript)) + ")",
+				"first difference at offset " + strconv.Itoa(offset),
+				`script src=\"http://ads.example.com/x.js\">`,
+			} {
+				if !strings.Contains(result.TamperDiff, want) {
+					t.Errorf("TamperDiff %q does not contain %q", result.TamperDiff, want)
+				}
+			}
+		})
+	}
+}
+
+// 自定义地址按 -tamper-check-sha256 比较，没有原文时摘要只有大小和 sha256
+func TestContentTamperingCustomURL(t *testing.T) {
+	page := []byte("<html><body>custom page</body></html>")
+	origin, node, _ := tamperServers(t, page, true)
+	st := New(&Config{Timeout: 5 * time.Second, TamperCheck: true, TamperCheckURL: origin.URL + "/page", TamperCheckSHA256: strings.ToUpper(sha256Hex(page))})
+	result := &Result{ProxyName: "node"}
+	st.testContentTampering(t.Context(), node, result)
+	tampered := bytes.Replace(page, []byte("</body>"), []byte(injectedScript+"</body>"), 1)
+	if want := strconv.Itoa(len(tampered)) + " bytes, sha256 " + sha256Hex(tampered); !result.ContentTampering || result.TamperDiff != want {
+		t.Errorf("ContentTampering %v, TamperDiff %q, want %q", result.ContentTampering, result.TamperDiff, want)
+	}
+
+	// sha256 不区分大小写，内容没变时不算篡改
+	origin, node, _ = tamperServers(t, page, false)
+	st = New(&Config{Timeout: 5 * time.Second, TamperCheck: true, TamperCheckURL: origin.URL + "/page", TamperCheckSHA256: strings.ToUpper(sha256Hex(page))})
+	result = &Result{ProxyName: "node"}
+	st.testContentTampering(t.Context(), node, result)
+	if result.ContentTampering {
+		t.Errorf("unchanged custom page reported as tampered: %s", result.TamperDiff)
+	}
+}
+
+// 直连下载的基准只取一次，和预期不一致时停用检测，不会把所有节点都判成篡改
+func TestContentTamperingBaseline(t *testing.T) {
+	page := []byte("<html><body>dynamic page</body></html>")
+	origin, node, requests := tamperServers(t, page, true)
+	st := New(&Config{Timeout: 5 * time.Second, TamperCheck: true, TamperCheckURL: origin.URL + "/page", TamperCheckSHA256: sha256Hex([]byte("stale page"))})
+	for range 2 {
+		result := &Result{ProxyName: "node"}
+		st.testContentTampering(t.Context(), node, result)
+		if result.ContentTampering || result.TamperDiff != "" {
+			t.Errorf("checked against a mismatched baseline: %s", result.TamperDiff)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("origin got %d requests, want only the direct baseline", got)
+	}
+
+	// 基准一致时每个节点照常检测，基准仍然只下载一次
+	st = New(&Config{ServerURL: origin.URL, Timeout: 5 * time.Second, TamperCheck: true})
+	requests.Store(0)
+	for range 2 {
+		result := &Result{ProxyName: "node"}
+		st.testContentTampering(t.Context(), node, result)
+		if !result.ContentTampering {
+			t.Error("tampering node not detected")
+		}
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("origin got %d requests, want the baseline and one per node", got)
+	}
+}
+
+// 请求失败或服务器不支持 /__known 时不做判断
+func TestContentTamperingUnsupported(t *testing.T) {
+	origin, node, _ := tamperServers(t, nil, false)
+	for _, url := range []string{origin.URL + "/missing", "http://127.0.0.1:1/__known"} {
+		st := New(&Config{Timeout: 5 * time.Second, TamperCheck: true, TamperCheckURL: url})
+		result := &Result{ProxyName: "node"}
+		st.testContentTampering(t.Context(), node, result)
+		if result.ContentTampering || result.TamperDiff != "" {
+			t.Errorf("%s: ContentTampering %v, TamperDiff %q", url, result.ContentTampering, result.TamperDiff)
+		}
+	}
+}
+
+func TestDescribeTampering(t *testing.T) {
+	original := []byte("hello world")
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"truncated", "hello", "5 bytes (-6), first difference at offset 5"},
+		{"replaced", "hello there", `11 bytes (+0), first difference at offset 6: "there"`},
+		{"appended", "hello world  <ad>", `17 bytes (+6), first difference at offset 11: "<ad>"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeTampering(original, []byte(tt.got)); got != tt.want {
+				t.Errorf("describeTampering = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownContent(t *testing.T) {
+	if n := len(KnownContent); n < 4<<10 || n > 16<<10 {
+		t.Errorf("KnownContent is %d bytes, want a few KB", n)
+	}
+	if KnownContentSHA256 != sha256Hex(buildKnownContent()) {
+		t.Error("KnownContent is not stable")
+	}
+}
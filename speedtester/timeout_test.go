@@ -0,0 +1,120 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowDownloadServer 每 50ms 发 16KiB，比任何测试里的超时都慢得多，返回服务器和收到的下载请求数
+func slowDownloadServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		chunk := make([]byte, 16*1024)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// TestTestDownloadTruncated 比超时慢的服务器仍然得到按已下载字节数计算的部分速度
+func TestTestDownloadTruncated(t *testing.T) {
+	server, _ := slowDownloadServer(t)
+	st := New(&Config{})
+	result := st.testDownload(context.Background(), directProxy(t), 400*time.Millisecond, server.URL+"/__down?bytes=104857600")
+	if result == nil {
+		t.Fatal("truncated download reported as a failure")
+	}
+	if result.bytes <= 0 || result.bytes >= 100*1024*1024 {
+		t.Errorf("truncated download read %d bytes", result.bytes)
+	}
+	if result.duration < 350*time.Millisecond || result.duration > time.Second {
+		t.Errorf("truncated download took %s, want about the 400ms timeout", result.duration)
+	}
+	// 大约每秒 320KiB，远低于无限快
+	if speed := float64(result.bytes) / result.duration.Seconds(); speed <= 0 || speed > 1024*1024 {
+		t.Errorf("partial speed %.0f B/s", speed)
+	}
+}
+
+// TestDownloadChunkStopsAfterTruncation 一个请求被超时截断后不再发后面的请求
+func TestDownloadChunkStopsAfterTruncation(t *testing.T) {
+	server, requests := slowDownloadServer(t)
+	// 假装是 cloudflare，让一次下载拆成多个请求
+	st := New(&Config{DownloadServerURL: server.URL + "/speed.cloudflare.com", DownloadTimeout: 300 * time.Millisecond})
+	if parts := splitDownloadSize(3*cloudflareMaxDownloadBytes, st.maxDownloadRequest()); len(parts) != 3 {
+		t.Fatalf("download split into %d requests, want 3", len(parts))
+	}
+	result := st.downloadChunk(context.Background(), directProxy(t), 3*cloudflareMaxDownloadBytes)
+	if result == nil || result.bytes <= 0 {
+		t.Fatalf("result = %+v", result)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests after the first one was truncated, want 1", n)
+	}
+}
+
+// TestDownloadTimeoutSeparate 下载用 DownloadTimeout，不受延迟探测用的短 Timeout 限制
+func TestDownloadTimeoutSeparate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for range 5 {
+			time.Sleep(60 * time.Millisecond)
+			w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	st := New(&Config{DownloadServerURL: server.URL, Timeout: 100 * time.Millisecond, DownloadTimeout: 5 * time.Second})
+	if result := st.downloadChunk(context.Background(), directProxy(t), 5*1024); result == nil || result.bytes != 5*1024 {
+		t.Errorf("download cut off by the latency timeout: %+v", result)
+	}
+
+	if got := New(&Config{Timeout: 3 * time.Second}).config.DownloadTimeout; got != 3*time.Second {
+		t.Errorf("DownloadTimeout defaults to %s, want Timeout", got)
+	}
+}
+
+// TestTestUploadTruncated 服务器读得比超时慢时，上传按超时前写出的字节数计算部分速度
+func TestTestUploadTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 客户端超时断开后 socket 缓冲区里还有几 MB，读超时让处理函数尽快结束
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 16*1024)
+		for {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := r.Body.Read(buf); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	const size = 256 * 1024 * 1024
+	st := New(&Config{UploadChunkSize: size})
+	result := st.testUpload(context.Background(), directProxy(t), size, 400*time.Millisecond, server.URL)
+	if result == nil {
+		t.Fatal("truncated upload reported as a failure")
+	}
+	if result.bytes <= 0 || result.bytes >= size || result.duration <= 0 {
+		t.Errorf("truncated upload: %d bytes in %s", result.bytes, result.duration)
+	}
+	if result.encoding != UploadEncodingChunked {
+		t.Errorf("encoding %q", result.encoding)
+	}
+}
@@ -0,0 +1,237 @@
+package speedtester
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// overrideTypes 是 -type-overrides 可以使用的类型名，和配置文件里的 type 一致
+var overrideTypes = map[string]constant.AdapterType{
+	"ss":          constant.Shadowsocks,
+	"shadowsocks": constant.Shadowsocks,
+	"ssr":         constant.ShadowsocksR,
+	"snell":       constant.Snell,
+	"socks5":      constant.Socks5,
+	"http":        constant.Http,
+	"vmess":       constant.Vmess,
+	"vless":       constant.Vless,
+	"trojan":      constant.Trojan,
+	"hysteria":    constant.Hysteria,
+	"hysteria2":   constant.Hysteria2,
+	"wireguard":   constant.WireGuard,
+	"tuic":        constant.Tuic,
+	"ssh":         constant.Ssh,
+	"mieru":       constant.Mieru,
+	"anytls":      constant.AnyTLS,
+}
+
+// TypeOverride 是一种代理类型的测试参数，只覆盖写了的项
+type TypeOverride struct {
+	DownloadSize     *int
+	UploadSize       *int
+	Timeout          *time.Duration
+	DownloadTimeout  *time.Duration
+	MaxLatency       *time.Duration
+	Concurrent       *int
+	UploadConcurrent *int
+	// Spec 是这一类型的原始写法，例如 "download-size=100MB,timeout=20s"，会记录在 Result.TypeOverride 里
+	Spec string
+}
+
+// ParseTypeOverrides 解析 "hysteria2:download-size=100MB,timeout=20s;ssh:latency-timeout=10s"。
+// 可以覆盖的项有 download-size、upload-size、timeout、download-timeout、max-latency（别名 latency-timeout，延迟探测的超时）、
+// concurrent 和 upload-concurrent，时间不带单位时按毫秒处理
+func ParseTypeOverrides(spec string) (map[constant.AdapterType]*TypeOverride, error) {
+	overrides := make(map[constant.AdapterType]*TypeOverride)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, options, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid %q, expected type:key=value,...", part)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		adapterType, ok := overrideTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown proxy type %q, supported: %s", name, strings.Join(overrideTypeNames(), ", "))
+		}
+		if _, exist := overrides[adapterType]; exist {
+			return nil, fmt.Errorf("proxy type %q is given more than once", name)
+		}
+		override, err := ParseTypeOverride(options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		overrides[adapterType] = override
+	}
+	return overrides, nil
+}
+
+// ParseTypeOverride 解析一种类型的覆盖项，也就是 Result.TypeOverride 记录的 "download-size=100MB,timeout=20s"
+func ParseTypeOverride(spec string) (*TypeOverride, error) {
+	override := &TypeOverride{Spec: strings.TrimSpace(spec)}
+	for _, option := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %q, expected key=value", option)
+		}
+		if err := override.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return nil, err
+		}
+	}
+	return override, nil
+}
+
+// ParseProxyTypes 解析 -type 的逗号分隔类型列表，返回 constant.AdapterType 的名称（例如 Vless、Hysteria2）。
+// 类型名不区分大小写，可以写配置文件里的 type（ss）或 mihomo 的类型名（shadowsocks）
+func ParseProxyTypes(spec string) ([]string, error) {
+	var types []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		adapterType, ok := overrideTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown proxy type %q, supported: %s", name, strings.Join(overrideTypeNames(), ", "))
+		}
+		types = append(types, adapterType.String())
+	}
+	return types, nil
+}
+
+func overrideTypeNames() []string {
+	names := make([]string, 0, len(overrideTypes))
+	for name := range overrideTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (o *TypeOverride) set(key, value string) error {
+	switch key {
+	case "download-size", "upload-size":
+		size, err := ParseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if key == "download-size" {
+			o.DownloadSize = &size
+		} else {
+			o.UploadSize = &size
+		}
+	case "timeout", "download-timeout", "max-latency", "latency-timeout":
+		d, err := parseOverrideDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		switch key {
+		case "timeout":
+			o.Timeout = &d
+		case "download-timeout":
+			o.DownloadTimeout = &d
+		default:
+			o.MaxLatency = &d
+		}
+	case "concurrent", "upload-concurrent":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%s: %q is not a positive integer", key, value)
+		}
+		if key == "concurrent" {
+			o.Concurrent = &n
+		} else {
+			o.UploadConcurrent = &n
+		}
+	default:
+		return fmt.Errorf("unknown key %q, supported: download-size, upload-size, timeout, download-timeout, max-latency, latency-timeout, concurrent, upload-concurrent", key)
+	}
+	return nil
+}
+
+// parseOverrideDuration 和命令行的时间参数一样，不带单位的数字按毫秒处理
+func parseOverrideDuration(value string) (time.Duration, error) {
+	if ms, err := strconv.Atoi(value); err == nil {
+		value = strconv.Itoa(ms) + "ms"
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be positive", value)
+	}
+	return d, nil
+}
+
+// apply 返回覆盖后的配置，config 本身不变
+func (o *TypeOverride) apply(config Config) Config {
+	if o.DownloadSize != nil {
+		config.DownloadSize = *o.DownloadSize
+	}
+	if o.UploadSize != nil {
+		config.UploadSize = *o.UploadSize
+	}
+	if o.Timeout != nil {
+		config.Timeout = *o.Timeout
+	}
+	if o.DownloadTimeout != nil {
+		config.DownloadTimeout = *o.DownloadTimeout
+	}
+	if o.MaxLatency != nil {
+		config.MaxLatency = *o.MaxLatency
+	}
+	if o.Concurrent != nil {
+		config.Concurrent = *o.Concurrent
+	}
+	if o.UploadConcurrent != nil {
+		config.UploadConcurrent = *o.UploadConcurrent
+	}
+	return config
+}
+
+// testerFor 返回按节点来源和类型覆盖了参数的 SpeedTester，都没有覆盖时返回 st 本身。
+// 返回的 SpeedTester 和 st 共用 known_hosts 和各种缓存
+func (st *SpeedTester) testerFor(proxy *CProxy) *SpeedTester {
+	override := st.config.TypeOverrides[proxy.Type()]
+	source := st.sourceOf(proxy)
+	sourceLatency, latencyBySource := st.config.SourceMaxLatency[source]
+	sourceSpeed, speedBySource := st.config.SourceMinSpeed[source]
+	if override == nil && !latencyBySource && !speedBySource {
+		return st
+	}
+	config := *st.config
+	if latencyBySource {
+		config.MaxLatency = sourceLatency
+	}
+	if speedBySource {
+		config.MinSpeed = sourceSpeed
+	}
+	if override != nil {
+		config = override.apply(config)
+	}
+	return &SpeedTester{
+		config:          &config,
+		knownHosts:      st.knownHosts,
+		geoResolver:     st.geoResolver,
+		serverCountries: st.serverCountries,
+		dualStack:       st.dualStack,
+		tamperBaseline:  st.tamperBaseline,
+	}
+}
+
+// typeOverrideSpec 返回节点类型的覆盖写法，没有覆盖时为空
+func (st *SpeedTester) typeOverrideSpec(proxy *CProxy) string {
+	if override := st.config.TypeOverrides[proxy.Type()]; override != nil {
+		return override.Spec
+	}
+	return ""
+}
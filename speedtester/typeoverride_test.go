@@ -0,0 +1,198 @@
+package speedtester
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/constant"
+)
+
+func TestParseTypeOverrides(t *testing.T) {
+	overrides, err := ParseTypeOverrides("Hysteria2: download-size=100MB, timeout=20s; ssh:latency-timeout=10000;socks5:concurrent=1,upload-concurrent=2,download-timeout=5s,upload-size=1MB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 3 {
+		t.Fatalf("got %d overrides", len(overrides))
+	}
+	hy2 := overrides[constant.Hysteria2]
+	if hy2 == nil || *hy2.DownloadSize != 100*1024*1024 || *hy2.Timeout != 20*time.Second || hy2.MaxLatency != nil {
+		t.Errorf("hysteria2 %+v", hy2)
+	}
+	if hy2.Spec != "download-size=100MB, timeout=20s" {
+		t.Errorf("hysteria2 spec %q", hy2.Spec)
+	}
+	// 不带单位的时间按毫秒处理
+	if ssh := overrides[constant.Ssh]; ssh == nil || *ssh.MaxLatency != 10*time.Second {
+		t.Errorf("ssh %+v", ssh)
+	}
+	socks := overrides[constant.Socks5]
+	if socks == nil || *socks.Concurrent != 1 || *socks.UploadConcurrent != 2 || *socks.DownloadTimeout != 5*time.Second || *socks.UploadSize != 1024*1024 {
+		t.Errorf("socks5 %+v", socks)
+	}
+
+	if overrides, err := ParseTypeOverrides(" ; "); err != nil || len(overrides) != 0 {
+		t.Errorf("empty spec: %v, %v", overrides, err)
+	}
+	if overrides, err := ParseTypeOverrides("ss:timeout=1s"); err != nil || overrides[constant.Shadowsocks] == nil {
+		t.Errorf("ss alias: %v, %v", overrides, err)
+	}
+}
+
+func TestParseTypeOverridesErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"hysteria2", `invalid "hysteria2", expected type:key=value`},
+		{"carrier-pigeon:timeout=1s", `unknown proxy type "carrier-pigeon"`},
+		{"direct:timeout=1s", `unknown proxy type "direct"`},
+		{"ssh:timeout=1s;SSH:concurrent=2", `proxy type "ssh" is given more than once`},
+		{"ssh:timeout", `ssh: invalid "timeout", expected key=value`},
+		{"ssh:latency=1s", `ssh: unknown key "latency"`},
+		{"ssh:timeout=fast", "ssh: timeout:"},
+		{"ssh:timeout=-1s", `ssh: timeout: "-1s" must be positive`},
+		{"ssh:download-size=huge", "ssh: download-size:"},
+		{"ssh:concurrent=0", `ssh: concurrent: "0" is not a positive integer`},
+	}
+	for _, tt := range tests {
+		_, err := ParseTypeOverrides(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseTypeOverrides(%q) = %v, want %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestParseProxyTypes(t *testing.T) {
+	types, err := ParseProxyTypes("ss, VLESS,,hysteria2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(types, ",") != "Shadowsocks,Vless,Hysteria2" {
+		t.Errorf("types %v", types)
+	}
+	if _, err := ParseProxyTypes("ss,pigeon"); err == nil || !strings.Contains(err.Error(), `unknown proxy type "pigeon"`) {
+		t.Errorf("unknown type: %v", err)
+	}
+}
+
+// -type 在名称过滤之前排除其他类型的节点，并计入 TypeExcluded
+func TestLoadProxiesAllowedTypes(t *testing.T) {
+	path := writeTestConfig(t, `proxies:
+  - {name: S, type: ss, server: 1.1.1.1, port: 443, cipher: aes-128-gcm, password: p}
+  - {name: H, type: hysteria2, server: 2.2.2.2, port: 443, password: p}
+  - {name: V, type: vless, server: 3.3.3.3, port: 443, uuid: 00000000-0000-0000-0000-000000000000}
+`)
+	types, err := ParseProxyTypes("VLESS,hysteria2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := New(&Config{ConfigPaths: path, AllowedTypes: types}).LoadProxies(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Proxies) != 2 || report.Proxies["H"] == nil || report.Proxies["V"] == nil || report.TypeExcluded != 1 {
+		t.Errorf("loaded %d proxies, %d excluded by type", len(report.Proxies), report.TypeExcluded)
+	}
+}
+
+func TestTypeOverrideApply(t *testing.T) {
+	overrides, err := ParseTypeOverrides("socks5:download-size=5MB,max-latency=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := Config{DownloadSize: 50 * 1024 * 1024, MaxLatency: 800 * time.Millisecond, Timeout: 5 * time.Second, Concurrent: 4}
+	patched := overrides[constant.Socks5].apply(base)
+	if patched.DownloadSize != 5*1024*1024 || patched.MaxLatency != 2*time.Second {
+		t.Errorf("patched %+v", patched)
+	}
+	// 没写的项保持原样，原来的配置不变
+	if patched.Timeout != 5*time.Second || patched.Concurrent != 4 {
+		t.Errorf("untouched options changed: timeout %s, concurrent %d", patched.Timeout, patched.Concurrent)
+	}
+	if base.DownloadSize != 50*1024*1024 || base.MaxLatency != 800*time.Millisecond {
+		t.Errorf("base config modified: %+v", base)
+	}
+}
+
+// deadSocks5 返回连不上的 socks5 节点
+func deadSocks5(t *testing.T) *CProxy {
+	t.Helper()
+	proxy, err := adapter.ParseProxy(map[string]any{"name": "dead", "type": "socks5", "server": "127.0.0.1", "port": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CProxy{Proxy: proxy}
+}
+
+func TestTesterFor(t *testing.T) {
+	overrides, err := ParseTypeOverrides("socks5:concurrent=2,timeout=3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New(&Config{Concurrent: 8, Timeout: 5 * time.Second, TypeOverrides: overrides})
+	socks := deadSocks5(t)
+	tester := st.testerFor(socks)
+	if tester == st {
+		t.Fatal("socks5 node tested without its override")
+	}
+	if tester.config.Concurrent != 2 || tester.config.Timeout != 3*time.Second || st.config.Concurrent != 8 {
+		t.Errorf("override concurrent %d timeout %s, base concurrent %d", tester.config.Concurrent, tester.config.Timeout, st.config.Concurrent)
+	}
+	if tester.serverCountries != st.serverCountries || tester.dualStack != st.dualStack {
+		t.Error("override tester does not share the caches")
+	}
+	// 没有单独设置 -upload-concurrent 时上传跟随覆盖后的 concurrent
+	if got := tester.uploadConcurrent(); got != 2 {
+		t.Errorf("upload concurrent %d, want 2 from the overridden concurrent", got)
+	}
+	st = New(&Config{Concurrent: 8, UploadConcurrent: 1, TypeOverrides: overrides})
+	if got := st.testerFor(socks).uploadConcurrent(); got != 1 {
+		t.Errorf("upload concurrent %d, want the explicit -upload-concurrent 1", got)
+	}
+
+	if tester := st.testerFor(&CProxy{Proxy: directProxy(t)}); tester != st {
+		t.Error("node without an override got a new tester")
+	}
+}
+
+func TestTesterForSourceMaxLatency(t *testing.T) {
+	overrides, err := ParseTypeOverrides("socks5:max-latency=3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New(&Config{MaxLatency: 800 * time.Millisecond, TypeOverrides: overrides,
+		SourceMaxLatency: map[string]time.Duration{"slow-airport": 2 * time.Second}})
+	direct := &CProxy{Proxy: directProxy(t), Source: "slow-airport"}
+	if got := st.testerFor(direct).config.MaxLatency; got != 2*time.Second {
+		t.Errorf("max latency %s, want the source's 2s", got)
+	}
+	// 类型覆盖优先于来源
+	socks := deadSocks5(t)
+	socks.Source = "slow-airport"
+	if got := st.testerFor(socks).config.MaxLatency; got != 3*time.Second {
+		t.Errorf("max latency %s, want the type override's 3s", got)
+	}
+	if tester := st.testerFor(&CProxy{Proxy: directProxy(t), Source: "other"}); tester != st {
+		t.Error("node from a source without overrides got a new tester")
+	}
+}
+
+func TestTypeOverrideRecorded(t *testing.T) {
+	overrides, err := ParseTypeOverrides("socks5:timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New(&Config{Timeout: 5 * time.Second, MaxLatency: 5 * time.Second, TypeOverrides: overrides})
+	result := st.testProxyWithRetries(context.Background(), "dead", deadSocks5(t))
+	if result.TypeOverride != "timeout=1s" {
+		t.Errorf("TypeOverride = %q, want the socks5 spec", result.TypeOverride)
+	}
+	result = st.testProxyWithRetries(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+	if result.TypeOverride != "" {
+		t.Errorf("direct node TypeOverride = %q", result.TypeOverride)
+	}
+}
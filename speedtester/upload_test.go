@@ -0,0 +1,277 @@
+package speedtester
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/constant"
+)
+
+// directProxy 返回直连的 mihomo 代理，测试里用它访问本地的 httptest 服务器
+func directProxy(t *testing.T) constant.Proxy {
+	t.Helper()
+	proxy, err := adapter.ParseProxy(map[string]any{"name": "direct", "type": "direct"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proxy
+}
+
+// uploadRequest 是上传服务器收到的一个请求
+type uploadRequest struct {
+	chunked bool
+	size    int64
+}
+
+// uploadServer 记录收到的请求，reject 返回非 0 状态码时拒绝这个请求
+func uploadServer(t *testing.T, reject func(r uploadRequest) int) (*httptest.Server, func() []uploadRequest) {
+	var mu sync.Mutex
+	var requests []uploadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		req := uploadRequest{chunked: len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked", size: n}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		if status := reject(req); status != 0 {
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []uploadRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]uploadRequest(nil), requests...)
+	}
+}
+
+func TestTestUploadEncoding(t *testing.T) {
+	const chunk = 1024
+	for _, tc := range []struct {
+		name         string
+		reject       func(r uploadRequest) int
+		wantEncoding string
+		// wantRejected 是成功之前被拒绝的请求
+		wantRejected []uploadRequest
+	}{
+		{
+			name:         "chunked accepted",
+			reject:       func(r uploadRequest) int { return 0 },
+			wantEncoding: UploadEncodingChunked,
+		},
+		{
+			name: "411 switches to content-length",
+			reject: func(r uploadRequest) int {
+				if r.chunked {
+					return http.StatusLengthRequired
+				}
+				return 0
+			},
+			wantEncoding: UploadEncodingContentLength,
+			wantRejected: []uploadRequest{{chunked: true, size: chunk}},
+		},
+		{
+			name: "413 halves the chunk",
+			reject: func(r uploadRequest) int {
+				if r.size > chunk/2 {
+					return http.StatusRequestEntityTooLarge
+				}
+				return 0
+			},
+			wantEncoding: UploadEncodingChunked,
+			wantRejected: []uploadRequest{{chunked: true, size: chunk}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, requests := uploadServer(t, tc.reject)
+			st := New(&Config{UploadChunkSize: chunk})
+			result := st.testUpload(t.Context(), directProxy(t), 4*chunk, 5*time.Second, server.URL)
+			if result == nil {
+				t.Fatal("upload failed")
+			}
+			if result.bytes != 4*chunk {
+				t.Errorf("uploaded %d bytes, want %d", result.bytes, 4*chunk)
+			}
+			if result.encoding != tc.wantEncoding {
+				t.Errorf("encoding %s, want %s", result.encoding, tc.wantEncoding)
+			}
+			got := requests()
+			if len(got) < len(tc.wantRejected) {
+				t.Fatalf("requests %+v, want rejected %+v first", got, tc.wantRejected)
+			}
+			for i, want := range tc.wantRejected {
+				if got[i] != want {
+					t.Errorf("request %d is %+v, want %+v", i, got[i], want)
+				}
+			}
+			for _, req := range got[len(tc.wantRejected):] {
+				if req.chunked != (tc.wantEncoding == UploadEncodingChunked) {
+					t.Errorf("accepted request %+v does not use %s", req, tc.wantEncoding)
+				}
+			}
+		})
+	}
+}
+
+// 不接受 chunked、要求 Content-Length 与请求体一致、Content-Type 明确的上传端点
+func TestTestUploadHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var lengths []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		if len(r.TransferEncoding) > 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/octet-stream" || r.ContentLength != n {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		lengths = append(lengths, r.ContentLength)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	st := New(&Config{UploadChunkSize: 1024})
+	result := st.testUpload(t.Context(), directProxy(t), 4096, 5*time.Second, server.URL)
+	if result == nil || result.encoding != UploadEncodingContentLength || result.bytes != 4096 {
+		t.Fatalf("upload result %+v", result)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, n := range lengths {
+		if n != 1024 {
+			t.Errorf("Content-Length %d, want the 1024 byte chunk size", n)
+		}
+	}
+}
+
+func TestTestUploadGivesUpAfterFallbacks(t *testing.T) {
+	server, requests := uploadServer(t, func(r uploadRequest) int { return http.StatusRequestEntityTooLarge })
+	st := New(&Config{UploadChunkSize: 1024})
+	if result := st.testUpload(t.Context(), directProxy(t), 4096, 5*time.Second, server.URL); result != nil {
+		t.Fatalf("upload succeeded against a server rejecting everything: %+v", result)
+	}
+	// 原来的块大小和减半后各一次
+	if got := requests(); len(got) != 2 {
+		t.Fatalf("got %d requests %+v, want 2", len(got), got)
+	}
+}
+
+// 耗时从开始写请求体算到收到响应，服务器读完请求体后迟迟不响应的时间也算在内
+func TestTestUploadTimesUntilResponse(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		time.Sleep(delay)
+	}))
+	t.Cleanup(server.Close)
+
+	st := New(&Config{UploadChunkSize: 1024})
+	result := st.testUpload(t.Context(), directProxy(t), 2048, 5*time.Second, server.URL)
+	if result == nil {
+		t.Fatal("upload failed")
+	}
+	if result.duration < 2*delay {
+		t.Errorf("upload took %s, want at least %s for two chunks", result.duration, 2*delay)
+	}
+}
+
+// 每块的响应体读完后复用同一条连接，结束后不留下空闲连接
+func TestTestUploadReusesConnection(t *testing.T) {
+	var opened, closed atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write(make([]byte, 16*1024))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			opened.Add(1)
+		case http.StateClosed:
+			closed.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	st := New(&Config{UploadChunkSize: 1024})
+	if result := st.testUpload(t.Context(), directProxy(t), 4096, 5*time.Second, server.URL); result == nil || result.bytes != 4096 {
+		t.Fatalf("upload result %+v", result)
+	}
+	if got := opened.Load(); got != 1 {
+		t.Errorf("opened %d connections for 4 chunks, want 1", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for closed.Load() < opened.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if closed.Load() != opened.Load() {
+		t.Errorf("%d of %d connections still open after the upload", opened.Load()-closed.Load(), opened.Load())
+	}
+}
+
+// 下载正常而上传全部失败时标记为上传被屏蔽，有备用上传服务器时换过去再试一次
+func TestUploadBlocked(t *testing.T) {
+	accept := func(uploadRequest) int { return 0 }
+	forbid := func(uploadRequest) int { return http.StatusForbidden }
+	for _, tc := range []struct {
+		name         string
+		primary      func(uploadRequest) int
+		fallback     func(uploadRequest) int
+		wantBlocked  bool
+		wantFallback bool
+	}{
+		{"upload accepted", accept, nil, false, false},
+		{"blocked without fallback", forbid, nil, true, false},
+		{"fallback accepts", forbid, accept, false, true},
+		{"fallback blocked too", forbid, forbid, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			download, _ := downloadServer(t)
+			primary, _ := uploadServer(t, tc.primary)
+			config := &Config{
+				DownloadServerURL: download.URL,
+				UploadServerURL:   primary.URL,
+				DownloadSize:      64 * 1024,
+				UploadSize:        32 * 1024,
+				UploadChunkSize:   32 * 1024,
+				Timeout:           5 * time.Second,
+				DownloadTimeout:   5 * time.Second,
+				MaxLatency:        5 * time.Second,
+				Concurrent:        1,
+			}
+			var fallbackRequests func() []uploadRequest
+			if tc.fallback != nil {
+				var fallback *httptest.Server
+				fallback, fallbackRequests = uploadServer(t, tc.fallback)
+				config.UploadFallbackURL = fallback.URL
+			}
+			result := New(config).testProxy(context.Background(), "direct", &CProxy{Proxy: directProxy(t)})
+			if result.DownloadSpeed <= 0 {
+				t.Fatalf("download speed %v, error %q", result.DownloadSpeed, result.Error)
+			}
+			if result.UploadBlocked != tc.wantBlocked || (result.ErrorClass == ErrorClassUploadBlocked) != tc.wantBlocked {
+				t.Errorf("upload blocked %v, error class %q", result.UploadBlocked, result.ErrorClass)
+			}
+			if !tc.wantBlocked && result.UploadSpeed <= 0 {
+				t.Errorf("upload speed %v", result.UploadSpeed)
+			}
+			if tc.wantFallback != (result.UploadServer == config.UploadFallbackURL) {
+				t.Errorf("upload server %q", result.UploadServer)
+			}
+			if fallbackRequests != nil && len(fallbackRequests()) == 0 {
+				t.Error("fallback upload server was not tried")
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package speedtester
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// volatileNameSuffixPrefix 开头的条目是节点名称末尾会变化的部分，其余条目是用 . 分隔的字段路径
+const volatileNameSuffixPrefix = "name-suffix-regex:"
+
+// defaultVolatileFields 是 -volatile-fields 里 default 展开的条目。
+// 只包含删除后不影响节点使用的部分，部分机场每次生成订阅都会在名称后面附上日期或 unix 时间戳
+var defaultVolatileFields = []string{
+	volatileNameSuffixPrefix + `[\s_-]*[(\[（【]?\d{4}[-/.]\d{1,2}[-/.]\d{1,2}(?:[ T]\d{1,2}:\d{2}(?::\d{2})?)?[)\]）】]?`,
+	volatileNameSuffixPrefix + `[\s_-]+\d{10}`,
+}
+
+// VolatileFields 是订阅每次生成都会变化、但不代表节点改变的字段，比较节点配置时忽略它们
+type VolatileFields struct {
+	paths        [][]string
+	nameSuffixes []*regexp.Regexp
+}
+
+// ParseVolatileFields 解析逗号分隔的条目，例如 "default,ws-opts.headers.X-Ts,name-suffix-regex:\s*\d+"。
+// default 展开为内置条目，正则里括号内的逗号不会被当作分隔符。
+// 决定 NodeKey 的字段和 name 本身不能作为路径，前者变化说明是另一个节点，后者应该用 name-suffix-regex
+func ParseVolatileFields(spec string) (*VolatileFields, error) {
+	v := &VolatileFields{}
+	entries := splitVolatileSpec(spec)
+	for i := 0; i < len(entries); i++ {
+		entry := strings.TrimSpace(entries[i])
+		switch {
+		case entry == "":
+			continue
+		case entry == "default":
+			entries = append(entries, defaultVolatileFields...)
+		case strings.HasPrefix(entry, volatileNameSuffixPrefix):
+			pattern := strings.TrimPrefix(entry, volatileNameSuffixPrefix)
+			if pattern == "" {
+				return nil, fmt.Errorf("empty name suffix regex")
+			}
+			re, err := regexp.Compile("(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid name suffix regex %q: %w", pattern, err)
+			}
+			v.nameSuffixes = append(v.nameSuffixes, re)
+		default:
+			path := strings.Split(entry, ".")
+			if slices.Contains(path, "") {
+				return nil, fmt.Errorf("invalid field path %q", entry)
+			}
+			if entry == "name" {
+				return nil, fmt.Errorf("name can not be dropped, use %s for changing name suffixes", volatileNameSuffixPrefix)
+			}
+			if len(path) == 1 && slices.Contains(nodeKeyFields, entry) {
+				return nil, fmt.Errorf("%s identifies the node and can not be volatile", entry)
+			}
+			v.paths = append(v.paths, path)
+		}
+	}
+	return v, nil
+}
+
+// splitVolatileSpec 按逗号分隔，跳过括号里和转义的逗号，例如 \d{1,2}
+func splitVolatileSpec(spec string) []string {
+	var entries []string
+	depth, start := 0, 0
+	for i := 0; i < len(spec); i++ {
+		switch spec[i] {
+		case '\\':
+			i++
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth = max(0, depth-1)
+		case ',':
+			if depth == 0 {
+				entries = append(entries, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(entries, spec[start:])
+}
+
+// Empty 在没有任何条目时返回 true，nil 也是空的
+func (v *VolatileFields) Empty() bool {
+	return v == nil || len(v.paths) == 0 && len(v.nameSuffixes) == 0
+}
+
+// TrimName 去掉名称末尾会变化的部分，多个后缀叠在一起时和顺序无关，去掉后为空时返回原名
+func (v *VolatileFields) TrimName(name string) string {
+	if v == nil {
+		return name
+	}
+	trimmed := name
+	for changed := true; changed; {
+		changed = false
+		for _, re := range v.nameSuffixes {
+			if next := strings.TrimSpace(re.ReplaceAllString(trimmed, "")); next != trimmed {
+				trimmed, changed = next, true
+			}
+		}
+	}
+	if trimmed == "" {
+		return name
+	}
+	return trimmed
+}
+
+// StripPaths 返回删掉所有路径后的配置副本，删空的上级 map 一起删掉。没有要删的字段时原样返回 config
+func (v *VolatileFields) StripPaths(config map[string]any) map[string]any {
+	if v == nil {
+		return config
+	}
+	for _, path := range v.paths {
+		config, _ = deletePath(config, path)
+	}
+	return config
+}
+
+// Strip 同时删掉路径并去掉名称后缀，用于比较两次运行的节点配置是否相同
+func (v *VolatileFields) Strip(config map[string]any) map[string]any {
+	config = v.StripPaths(config)
+	if name, ok := config["name"].(string); ok && v != nil {
+		if trimmed := v.TrimName(name); trimmed != name {
+			config = maps.Clone(config)
+			config["name"] = trimmed
+		}
+	}
+	return config
+}
+
+// Present 返回 config 里存在的条目，路径用 . 连接，名称后缀统一记为 name suffix
+func (v *VolatileFields) Present(config map[string]any) []string {
+	if v == nil {
+		return nil
+	}
+	var present []string
+	for _, path := range v.paths {
+		if hasPath(config, path) {
+			present = append(present, strings.Join(path, "."))
+		}
+	}
+	if name, ok := config["name"].(string); ok && v.TrimName(name) != name {
+		present = append(present, "name suffix")
+	}
+	return present
+}
+
+// deletePath 删除 path 指向的字段，只复制路径上经过的 map，不修改 m。字段不存在时返回 m 和 false
+func deletePath(m map[string]any, path []string) (map[string]any, bool) {
+	value, ok := m[path[0]]
+	if !ok {
+		return m, false
+	}
+	if len(path) > 1 {
+		child, isMap := value.(map[string]any)
+		if !isMap {
+			return m, false
+		}
+		stripped, deleted := deletePath(child, path[1:])
+		if !deleted {
+			return m, false
+		}
+		m = maps.Clone(m)
+		if len(stripped) == 0 {
+			delete(m, path[0])
+		} else {
+			m[path[0]] = stripped
+		}
+		return m, true
+	}
+	m = maps.Clone(m)
+	delete(m, path[0])
+	return m, true
+}
+
+func hasPath(m map[string]any, path []string) bool {
+	for i, key := range path {
+		value, ok := m[key]
+		if !ok {
+			return false
+		}
+		if i == len(path)-1 {
+			return true
+		}
+		if m, ok = value.(map[string]any); !ok {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package speedtester
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitVolatileSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"", []string{""}},
+		{"a.b,c", []string{"a.b", "c"}},
+		{`name-suffix-regex:\d{1,2},x`, []string{`name-suffix-regex:\d{1,2}`, "x"}},
+		{`name-suffix-regex:(a,b)[,]\,x,y`, []string{`name-suffix-regex:(a,b)[,]\,x`, "y"}},
+		// 多出来的右括号不会让后面的逗号失效
+		{"a),b", []string{"a)", "b"}},
+	}
+	for _, tt := range tests {
+		if got := splitVolatileSpec(tt.spec); !slices.Equal(got, tt.want) {
+			t.Errorf("splitVolatileSpec(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseVolatileFields(t *testing.T) {
+	tests := []struct {
+		spec     string
+		paths    int
+		suffixes int
+		err      string
+	}{
+		{"", 0, 0, ""},
+		{"default", 0, len(defaultVolatileFields), ""},
+		{"default, ws-opts.headers.X-Ts ,plugin-opts.host", 2, len(defaultVolatileFields), ""},
+		{`name-suffix-regex:\s*#\d+`, 0, 1, ""},
+		{"ws-opts..path", 0, 0, `invalid field path "ws-opts..path"`},
+		{"name", 0, 0, "name can not be dropped"},
+		{"server", 0, 0, "server identifies the node"},
+		// 只有顶层的 NodeKey 字段不能用，嵌套的同名字段可以
+		{"plugin-opts.password", 1, 0, ""},
+		{"name-suffix-regex:", 0, 0, "empty name suffix regex"},
+		{"name-suffix-regex:(", 0, 0, "invalid name suffix regex"},
+	}
+	for _, tt := range tests {
+		v, err := ParseVolatileFields(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseVolatileFields(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVolatileFields(%q): %v", tt.spec, err)
+			continue
+		}
+		if len(v.paths) != tt.paths || len(v.nameSuffixes) != tt.suffixes {
+			t.Errorf("ParseVolatileFields(%q): %d paths, %d suffixes, want %d, %d", tt.spec, len(v.paths), len(v.nameSuffixes), tt.paths, tt.suffixes)
+		}
+		if v.Empty() != (tt.paths+tt.suffixes == 0) {
+			t.Errorf("ParseVolatileFields(%q).Empty() = %v", tt.spec, v.Empty())
+		}
+	}
+	if !(*VolatileFields)(nil).Empty() {
+		t.Error("nil VolatileFields not empty")
+	}
+}
+
+func TestVolatileTrimName(t *testing.T) {
+	v, err := ParseVolatileFields(`default,name-suffix-regex:\s*#\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"HK 01", "HK 01"},
+		{"HK 01 2025-01-02", "HK 01"},
+		{"HK 01 (2025/1/2 08:30)", "HK 01"},
+		{"HK 01【2025.01.02】", "HK 01"},
+		{"HK 01_1735800000", "HK 01"},
+		// 不同后缀叠在一起时和顺序无关
+		{"HK 01 2025-01-02 #3", "HK 01"},
+		{"HK 01 #3 2025-01-02", "HK 01"},
+		// 时间戳前面需要分隔符，名称中间的日期不动
+		{"HK1735800000", "HK1735800000"},
+		{"2025-01-02 HK 01", "2025-01-02 HK 01"},
+		// 整个名称都是后缀时保留原名
+		{"2025-01-02", "2025-01-02"},
+	}
+	for _, tt := range tests {
+		if got := v.TrimName(tt.name); got != tt.want {
+			t.Errorf("TrimName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := (*VolatileFields)(nil).TrimName("HK 01 2025-01-02"); got != "HK 01 2025-01-02" {
+		t.Errorf("nil TrimName = %q", got)
+	}
+}
+
+func TestVolatileStrip(t *testing.T) {
+	v, err := ParseVolatileFields("default,ws-opts.headers.X-Ts,ws-opts.path,plugin-opts.host.x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]any{
+		"name": "HK 01 2025-01-02", "type": "vmess", "server": "hk.example.com", "port": 443,
+		"ws-opts":     map[string]any{"path": "/a1b2c3", "headers": map[string]any{"X-Ts": "1735800000"}},
+		"plugin-opts": map[string]any{"host": "bing.com"},
+	}
+	original := map[string]any{
+		"name": "HK 01 2025-01-02", "type": "vmess", "server": "hk.example.com", "port": 443,
+		"ws-opts":     map[string]any{"path": "/a1b2c3", "headers": map[string]any{"X-Ts": "1735800000"}},
+		"plugin-opts": map[string]any{"host": "bing.com"},
+	}
+
+	// 删空的 headers 和 ws-opts 一起删掉，路径经过非 map 的值时不删
+	want := map[string]any{"name": "HK 01 2025-01-02", "type": "vmess", "server": "hk.example.com", "port": 443, "plugin-opts": map[string]any{"host": "bing.com"}}
+	if got := v.StripPaths(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("StripPaths = %v, want %v", got, want)
+	}
+	want["name"] = "HK 01"
+	if got := v.Strip(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("Strip = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(config, original) {
+		t.Errorf("Strip modified the input: %v", config)
+	}
+	if got := v.Present(config); !slices.Equal(got, []string{"ws-opts.headers.X-Ts", "ws-opts.path", "name suffix"}) {
+		t.Errorf("Present = %q", got)
+	}
+
+	// 只删掉一部分时保留剩下的字段
+	config["ws-opts"] = map[string]any{"path": "/a1b2c3", "headers": map[string]any{"X-Ts": "1", "Host": "cdn.example.com"}}
+	stripped := v.StripPaths(config)
+	if want := map[string]any{"headers": map[string]any{"Host": "cdn.example.com"}}; !reflect.DeepEqual(stripped["ws-opts"], want) {
+		t.Errorf("ws-opts = %v, want %v", stripped["ws-opts"], want)
+	}
+
+	// 没有要删的字段时原样返回，不复制
+	plain := map[string]any{"name": "JP 01", "type": "ss"}
+	if got := v.Strip(plain); reflect.ValueOf(got).Pointer() != reflect.ValueOf(plain).Pointer() {
+		t.Error("Strip copied a config without volatile fields")
+	}
+	if got := (*VolatileFields)(nil).Strip(maps.Clone(config)); !reflect.DeepEqual(got, config) {
+		t.Errorf("nil Strip = %v", got)
+	}
+}
+
+// 易变字段不影响 NodeKey，去掉后两次订阅的同一个节点配置相同
+func TestVolatileNodeKey(t *testing.T) {
+	v, err := ParseVolatileFields("default,ws-opts.headers.X-Ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := map[string]any{"name": "HK 2025-01-02", "type": "vmess", "server": "hk.example.com", "port": 443, "uuid": "u", "ws-opts": map[string]any{"headers": map[string]any{"X-Ts": "1"}}}
+	second := map[string]any{"name": "HK 2025-01-03", "type": "vmess", "server": "hk.example.com", "port": 443, "uuid": "u", "ws-opts": map[string]any{"headers": map[string]any{"X-Ts": "2"}}}
+	if NodeKey(first) != NodeKey(second) {
+		t.Error("NodeKey depends on volatile fields")
+	}
+	if ConfigHash(first) == ConfigHash(second) {
+		t.Error("ConfigHash ignores volatile fields")
+	}
+	if !reflect.DeepEqual(v.Strip(first), v.Strip(second)) {
+		t.Errorf("stripped configs differ: %v, %v", v.Strip(first), v.Strip(second))
+	}
+}
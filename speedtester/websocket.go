@@ -0,0 +1,207 @@
+package speedtester
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// websocketGUID 是 RFC 6455 中用于计算 Sec-WebSocket-Accept 的固定值
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+)
+
+// testWebSocket 通过代理建立 WebSocket 连接，发送一帧数据并等待服务器原样返回。
+// 有的节点出口会拦截 Upgrade 请求，普通的 HTTP 测试发现不了
+func (st *SpeedTester) testWebSocket(ctx context.Context, proxy constant.Proxy, result *Result) {
+	start := time.Now()
+	if err := st.websocketEcho(ctx, proxy); err != nil {
+		result.WebSocketError = err.Error()
+		return
+	}
+	result.WebSocketOK = true
+	result.WebSocketRTT = time.Since(start)
+}
+
+func (st *SpeedTester) websocketEcho(ctx context.Context, proxy constant.Proxy) error {
+	u, err := url.Parse(st.config.WebSocketURL)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	metadata, err := dialMetadata(net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, st.config.Timeout)
+	defer cancel()
+	proxyConn, err := proxy.DialContext(ctx, metadata)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer proxyConn.Close()
+	var conn net.Conn = proxyConn
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	reader := bufio.NewReader(conn)
+	if err := websocketHandshake(conn, reader, u); err != nil {
+		return err
+	}
+
+	payload := make([]byte, 16)
+	rand.Read(payload)
+	message := []byte("clash-speedtest " + base64.RawURLEncoding.EncodeToString(payload))
+	if err := writeWebSocketFrame(conn, wsOpText, message); err != nil {
+		return fmt.Errorf("send frame: %w", err)
+	}
+	// 部分 echo 服务器会先推送一条欢迎消息，这里跳过不相关的帧
+	for range 5 {
+		opcode, data, err := readWebSocketFrame(reader)
+		if err != nil {
+			return fmt.Errorf("read frame: %w", err)
+		}
+		switch opcode {
+		case wsOpClose:
+			return fmt.Errorf("connection closed by server")
+		case wsOpText:
+			if string(data) == string(message) {
+				writeWebSocketFrame(conn, wsOpClose, nil)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("echo frame not received")
+}
+
+func websocketHandshake(conn net.Conn, reader *bufio.Reader, u *url.URL) error {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("send handshake: %w", err)
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return fmt.Errorf("read handshake: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return fmt.Errorf("upgrade rejected: %s", resp.Status)
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return fmt.Errorf("invalid Sec-WebSocket-Accept")
+	}
+	return nil
+}
+
+// writeWebSocketFrame 写一个完整的帧，客户端发出的帧必须加掩码
+func writeWebSocketFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	header = append(header, mask...)
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+	_, err := w.Write(append(header, masked...))
+	return err
+}
+
+// readWebSocketFrame 读取一个帧，不支持分片消息，测试用的短消息不会被分片
+func readWebSocketFrame(r io.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > 1<<20 {
+		return 0, nil, fmt.Errorf("frame too large: %d bytes", length)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,109 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte{'a'}, size)
+		var buf bytes.Buffer
+		if err := writeWebSocketFrame(&buf, wsOpText, payload); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Bytes()[1]&0x80 == 0 {
+			t.Errorf("size %d: client frame is not masked", size)
+		}
+		opcode, got, err := readWebSocketFrame(&buf)
+		if err != nil || opcode != wsOpText || !bytes.Equal(got, payload) {
+			t.Errorf("size %d: read opcode %d, %d bytes, err %v", size, opcode, len(got), err)
+		}
+	}
+	// 声明的长度超过上限时不分配内存
+	huge := []byte{0x80 | wsOpText, 127, 0, 0, 0, 0, 0x10, 0, 0, 0}
+	if _, _, err := readWebSocketFrame(bytes.NewReader(huge)); err == nil || !strings.Contains(err.Error(), "frame too large") {
+		t.Errorf("huge frame: %v", err)
+	}
+}
+
+// serverFrame 是服务器发出的不带掩码的帧
+func serverFrame(opcode byte, payload string) []byte {
+	return append([]byte{0x80 | opcode, byte(len(payload))}, payload...)
+}
+
+// websocketServer 完成握手后按 mode 回应第一帧：echo 原样返回，welcome 先推送一条欢迎消息，close 直接关闭，silent 不回应
+func websocketServer(t *testing.T, mode string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mode == "reject" || r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		accept := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + websocketGUID))
+		if mode == "bad-accept" {
+			accept = sha1.Sum([]byte("wrong"))
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+		rw.Flush()
+		_, message, err := readWebSocketFrame(rw)
+		if err != nil {
+			return
+		}
+		switch mode {
+		case "welcome":
+			conn.Write(serverFrame(wsOpText, "welcome"))
+			conn.Write(serverFrame(wsOpText, string(message)))
+		case "echo":
+			conn.Write(serverFrame(wsOpText, string(message)))
+		case "close":
+			conn.Write(serverFrame(wsOpClose, ""))
+		}
+		readWebSocketFrame(rw)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTestWebSocket(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		err  string
+	}{
+		{"echo", ""},
+		{"welcome", ""},
+		{"reject", "upgrade rejected: 403 Forbidden"},
+		{"bad-accept", "invalid Sec-WebSocket-Accept"},
+		{"close", "connection closed by server"},
+		{"silent", "read frame"},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			server := websocketServer(t, tc.mode)
+			st := New(&Config{WebSocketURL: "ws" + strings.TrimPrefix(server.URL, "http"), Timeout: time.Second})
+			result := &Result{}
+			st.testWebSocket(context.Background(), directProxy(t), result)
+			if tc.err == "" {
+				if !result.WebSocketOK || result.WebSocketRTT <= 0 || result.WebSocketError != "" {
+					t.Errorf("result = ok %v, rtt %s, error %q", result.WebSocketOK, result.WebSocketRTT, result.WebSocketError)
+				}
+				return
+			}
+			if result.WebSocketOK || !strings.Contains(result.WebSocketError, tc.err) {
+				t.Errorf("result = ok %v, error %q, want %q", result.WebSocketOK, result.WebSocketError, tc.err)
+			}
+		})
+	}
+}
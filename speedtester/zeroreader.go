@@ -2,23 +2,28 @@ package speedtester
 
 import (
 	"io"
+	"sync/atomic"
+	"time"
 )
 
 var zeroBytes = make([]byte, 1024*1024)
 
 type ZeroReader struct {
-	remainBytes  int64
-	writtenBytes int64
+	remainBytes int64
+	// writtenBytes 在上传超时后还会被 http.Transport 的写协程更新，需要原子读写
+	writtenBytes atomic.Int64
+	// firstRead 是第一次被读取的时间（UnixNano），即开始写请求体的时间
+	firstRead atomic.Int64
 }
 
 func NewZeroReader(size int) *ZeroReader {
 	return &ZeroReader{
-		remainBytes:  int64(size),
-		writtenBytes: 0,
+		remainBytes: int64(size),
 	}
 }
 
 func (r *ZeroReader) Read(p []byte) (n int, err error) {
+	r.firstRead.CompareAndSwap(0, time.Now().UnixNano())
 	if r.remainBytes <= 0 {
 		return 0, io.EOF
 	}
@@ -30,12 +35,22 @@ func (r *ZeroReader) Read(p []byte) (n int, err error) {
 		bytesWritten += chunk
 	}
 	r.remainBytes -= bytesWritten
-	r.writtenBytes += bytesWritten
+	r.writtenBytes.Add(bytesWritten)
 	return int(bytesWritten), nil
 }
 
 func (r *ZeroReader) WrittenBytes() int64 {
-	return r.writtenBytes
+	return r.writtenBytes.Load()
+}
+
+// WriteDuration 返回从开始写请求体到 end 的时间。end 取收到响应的时间，这样最后一块留在
+// 本地缓冲区、还没真正发出去的数据也算在内；不包括建立连接和发送请求头
+func (r *ZeroReader) WriteDuration(end time.Time) time.Duration {
+	first := r.firstRead.Load()
+	if first == 0 {
+		return 0
+	}
+	return end.Sub(time.Unix(0, first))
 }
 
 func (r *ZeroReader) RemainBytes() int64 {
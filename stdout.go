@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stdoutPath 作为输出文件路径时表示写到标准输出，方便接管道
+const stdoutPath = "-"
+
+// console 是表格、摘要等给人看的内容的输出位置。有输出文件占用了标准输出时改成标准错误，
+// 保证管道里只有这一份输出
+var console = os.Stdout
+
+// stdoutArtifacts 是可以写到标准输出的输出文件参数，同一时间只能有一个使用 "-"
+var stdoutArtifacts = []string{"output", "good-output", "bad-output", "output-txt", "scorecard", "csv", "results-json"}
+
+// claimStdout 在有输出文件写到标准输出时调用。mihomo 的日志默认也写到标准输出，一起改到标准错误
+func claimStdout() {
+	console = os.Stderr
+	logrus.SetOutput(os.Stderr)
+}
+
+// writeArtifact 写出一个输出文件，路径为 "-" 时写到标准输出，否则原子写入
+func writeArtifact(path string, data []byte, perm os.FileMode) error {
+	if path == stdoutPath {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+// artifactPath 返回输出文件的绝对路径，"-" 保持不变
+func artifactPath(path string) string {
+	if path == stdoutPath {
+		return path
+	}
+	absPath, _ := filepath.Abs(path)
+	return absPath
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metacubex/mihomo/log"
+	"github.com/sirupsen/logrus"
+)
+
+// captureStdout 把 f 执行期间写到标准输出的内容收集起来返回
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteArtifactToStdout(t *testing.T) {
+	var err error
+	got := captureStdout(t, func() {
+		err = writeArtifact(stdoutPath, []byte("proxies: []\n"), 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "proxies: []\n" {
+		t.Errorf("stdout = %q", got)
+	}
+	if _, err := os.Stat(stdoutPath); !os.IsNotExist(err) {
+		t.Errorf("a file named %q was created: %v", stdoutPath, err)
+	}
+}
+
+func TestWriteArtifactToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.yaml")
+	got := captureStdout(t, func() {
+		if err := writeArtifact(path, []byte("proxies: []\n"), 0o644); err != nil {
+			t.Error(err)
+		}
+	})
+	if got != "" {
+		t.Errorf("stdout = %q, want nothing", got)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "proxies: []\n" {
+		t.Errorf("file = %q", data)
+	}
+}
+
+func TestArtifactPath(t *testing.T) {
+	if got := artifactPath(stdoutPath); got != stdoutPath {
+		t.Errorf("artifactPath(%q) = %q", stdoutPath, got)
+	}
+	if got := artifactPath("out.yaml"); !filepath.IsAbs(got) || filepath.Base(got) != "out.yaml" {
+		t.Errorf("artifactPath(out.yaml) = %q", got)
+	}
+}
+
+func TestClaimStdout(t *testing.T) {
+	defer func() { console = os.Stdout }()
+	claimStdout()
+	if console != os.Stderr {
+		t.Error("console is not stderr after claimStdout")
+	}
+}
+
+// 占用标准输出之后，标准输出里只有输出文件，表格和 mihomo 的日志都在标准错误
+func TestClaimStdoutStreams(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, level := os.Stderr, log.Level()
+	os.Stderr = w
+	defer func() {
+		os.Stderr = stderr
+		console = os.Stdout
+		logrus.SetOutput(os.Stdout)
+		log.SetLevel(level)
+	}()
+	log.SetLevel(log.WARNING)
+
+	stdout := captureStdout(t, func() {
+		claimStdout()
+		fmt.Fprintln(console, "table")
+		log.Warnln("provider warning")
+		if err := writeArtifact(stdoutPath, []byte("proxies: []\n"), 0o644); err != nil {
+			t.Error(err)
+		}
+	})
+	w.Close()
+	errData, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "proxies: []\n" {
+		t.Errorf("stdout = %q, want only the output file", stdout)
+	}
+	if !strings.Contains(string(errData), "table") || !strings.Contains(string(errData), "provider warning") {
+		t.Errorf("stderr = %q, want the table and the log", errData)
+	}
+}
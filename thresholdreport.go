@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// thresholdRatios 是 -threshold-report 试算的放宽比例：最低速度乘以比例，最大延迟除以比例
+var thresholdRatios = []float64{0.75, 0.5}
+
+// thresholdKnob 是一个可以放宽的阈值。get、set 读写节点适用的阈值，已经包含 -sources 和 -type-overrides 的覆盖，
+// 值为 0 时 unusableReasonWith 不检查这一项
+type thresholdKnob struct {
+	flag string
+	// isMax 表示这是上限，放宽时除以比例
+	isMax  bool
+	get    func(t *thresholds) float64
+	set    func(t *thresholds, v float64)
+	format func(v float64) string
+	// checked 为空表示值不为 0 时总是检查，例如 -fast 不测下载，最低速度不起作用
+	checked func() bool
+}
+
+func (k thresholdKnob) relaxed(v, ratio float64) float64 {
+	if k.isMax {
+		return v / ratio
+	}
+	return v * ratio
+}
+
+var thresholdKnobs = []thresholdKnob{
+	{
+		flag:   "max-latency",
+		isMax:  true,
+		get:    func(t *thresholds) float64 { return float64(t.maxLatency) },
+		set:    func(t *thresholds, v float64) { t.maxLatency = time.Duration(v) },
+		format: formatLatencyThreshold,
+	},
+	{
+		flag:   "max-new-conn-latency",
+		isMax:  true,
+		get:    func(t *thresholds) float64 { return float64(t.maxNewConnLatency) },
+		set:    func(t *thresholds, v float64) { t.maxNewConnLatency = time.Duration(v) },
+		format: formatLatencyThreshold,
+	},
+	{
+		flag:    "min-speed",
+		get:     func(t *thresholds) float64 { return t.minSpeed },
+		set:     func(t *thresholds, v float64) { t.minSpeed = v },
+		format:  formatMBps,
+		checked: func() bool { return !*fastMode },
+	},
+	{
+		flag:    "min-upload-speed",
+		get:     func(t *thresholds) float64 { return t.minUploadSpeed },
+		set:     func(t *thresholds, v float64) { t.minUploadSpeed = v },
+		format:  formatMBps,
+		checked: func() bool { return uploadSize > 0 && !*fastMode },
+	},
+}
+
+func formatLatencyThreshold(v float64) string {
+	return time.Duration(v).Round(time.Millisecond).String()
+}
+
+func formatMBps(v float64) string {
+	return fmt.Sprintf("%gMB/s", v)
+}
+
+type relaxedCount struct {
+	Value  string `json:"value"`
+	Usable int    `json:"usable"`
+}
+
+type criterionSensitivity struct {
+	Flag  string `json:"flag"`
+	Value string `json:"value"`
+	// OnlyFailing 是只有这一项不达标、其他阈值都满足的节点数
+	OnlyFailing int            `json:"only_failing"`
+	Relaxed     []relaxedCount `json:"relaxed"`
+}
+
+type thresholdReport struct {
+	Tested   int                    `json:"tested"`
+	Usable   int                    `json:"usable"`
+	Criteria []criterionSensitivity `json:"criteria"`
+}
+
+// buildThresholdReport 对每个阈值分别统计只卡在这一项的节点数，以及把它放宽到 thresholdRatios 时的可用节点数，
+// 其他阈值保持不变。每次试算都用 unusableReasonWith 重新判定，结果和输出文件里的可用节点一致。
+// 放宽的是每个节点自己适用的阈值，-sources 让不同节点的阈值不同时，报告里的数值写成比例
+func buildThresholdReport(results []*speedtester.Result) *thresholdReport {
+	report := &thresholdReport{Tested: len(results)}
+	bases := make([]thresholds, len(results))
+	usable := make([]bool, len(results))
+	for i, result := range results {
+		bases[i] = thresholdsFor(result)
+		if usable[i] = unusableReasonWith(result, bases[i]) == ""; usable[i] {
+			report.Usable++
+		}
+	}
+	for _, knob := range thresholdKnobs {
+		criterion := criterionSensitivity{Flag: knob.flag}
+		relaxed := make([]int, len(thresholdRatios))
+		values := make(map[float64]bool)
+		for i, result := range results {
+			value := knob.get(&bases[i])
+			if value == 0 || (knob.checked != nil && !knob.checked()) {
+				// 这一项不检查的节点放宽后结果不变
+				for j := range relaxed {
+					if usable[i] {
+						relaxed[j]++
+					}
+				}
+				continue
+			}
+			values[value] = true
+			if !usable[i] {
+				without := bases[i]
+				knob.set(&without, 0)
+				if unusableReasonWith(result, without) == "" {
+					criterion.OnlyFailing++
+				}
+			}
+			for j, ratio := range thresholdRatios {
+				t := bases[i]
+				knob.set(&t, knob.relaxed(value, ratio))
+				if unusableReasonWith(result, t) == "" {
+					relaxed[j]++
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		var value float64
+		for v := range values {
+			value = v
+		}
+		criterion.Value = knob.format(value)
+		if len(values) > 1 {
+			criterion.Value = "per source"
+		}
+		for j, ratio := range thresholdRatios {
+			relaxedValue := knob.format(knob.relaxed(value, ratio))
+			if len(values) > 1 {
+				relaxedValue = fmt.Sprintf("%.0f%%", knob.relaxed(1, ratio)*100)
+			}
+			criterion.Relaxed = append(criterion.Relaxed, relaxedCount{Value: relaxedValue, Usable: relaxed[j]})
+		}
+		report.Criteria = append(report.Criteria, criterion)
+	}
+	return report
+}
+
+// printThresholdReport 输出 -threshold-report，例如
+//
+//	-min-upload-speed 2MB/s: 42 nodes fail only this check; relaxing it to 1.5MB/s would add 20 usable nodes, to 1MB/s 37
+func printThresholdReport(w io.Writer, report *thresholdReport) {
+	fmt.Fprintf(w, "threshold report: %d of %d tested nodes pass every threshold\n", report.Usable, report.Tested)
+	for _, c := range report.Criteria {
+		var relaxed []string
+		for i, r := range c.Relaxed {
+			if i == 0 {
+				relaxed = append(relaxed, fmt.Sprintf("relaxing it to %s would add %d usable nodes", r.Value, r.Usable-report.Usable))
+			} else {
+				relaxed = append(relaxed, fmt.Sprintf("to %s %d", r.Value, r.Usable-report.Usable))
+			}
+		}
+		fmt.Fprintf(w, "  -%s %s: %d nodes fail only this check; %s\n", c.Flag, c.Value, c.OnlyFailing, strings.Join(relaxed, ", "))
+	}
+}
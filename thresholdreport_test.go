@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestBuildThresholdReport(t *testing.T) {
+	setFlags(t, "min-speed", "4", "max-latency", "200ms", "min-upload-speed", "0")
+	slow := capResult("Slow", 2.5)
+	slower := capResult("Slower", 1.5)
+	laggy := capResult("Laggy", 10)
+	laggy.Latency = 300 * time.Millisecond
+	both := capResult("Both", 1)
+	both.Latency = 300 * time.Millisecond
+	results := []*speedtester.Result{capResult("Fast", 10), slow, slower, laggy, both}
+
+	report := buildThresholdReport(results)
+	if report.Tested != 5 || report.Usable != 1 {
+		t.Fatalf("tested %d, usable %d, want 5, 1", report.Tested, report.Usable)
+	}
+	want := map[string]criterionSensitivity{
+		"max-latency": {OnlyFailing: 1, Relaxed: []relaxedCount{{"267ms", 1}, {"400ms", 2}}},
+		"min-speed":   {OnlyFailing: 2, Relaxed: []relaxedCount{{"3MB/s", 1}, {"2MB/s", 2}}},
+	}
+	if len(report.Criteria) != len(want) {
+		t.Fatalf("criteria %+v", report.Criteria)
+	}
+	for _, c := range report.Criteria {
+		w := want[c.Flag]
+		if c.OnlyFailing != w.OnlyFailing || len(c.Relaxed) != len(w.Relaxed) {
+			t.Errorf("-%s: %+v, want %+v", c.Flag, c, w)
+			continue
+		}
+		for i := range w.Relaxed {
+			if c.Relaxed[i] != w.Relaxed[i] {
+				t.Errorf("-%s relaxed %d: %+v, want %+v", c.Flag, i, c.Relaxed[i], w.Relaxed[i])
+			}
+		}
+	}
+}
+
+func TestThresholdReportUsesUsabilityCheck(t *testing.T) {
+	setFlags(t, "min-speed", "4", "max-latency", "800ms", "min-upload-speed", "0")
+	// 额外 URL 不通的节点放宽速度也不可用，不能算作只卡在 -min-speed
+	blocked := capResult("Blocked", 3.5)
+	blocked.ExtraURLConnectivity = false
+	// 来源把最低速度降到了 2MB/s，按来源的阈值放宽
+	loose := 2.0
+	sourceOverrides = map[string]*sourceEntry{"loose": {MinSpeed: &loose}}
+	t.Cleanup(func() { sourceOverrides = nil })
+	sourced := capResult("Sourced", 1.2)
+	sourced.Source = "loose"
+	results := []*speedtester.Result{capResult("Slow", 3.5), blocked, sourced}
+
+	report := buildThresholdReport(results)
+	if report.Usable != 0 {
+		t.Fatalf("usable %d, want 0", report.Usable)
+	}
+	var speed *criterionSensitivity
+	for i := range report.Criteria {
+		if report.Criteria[i].Flag == "min-speed" {
+			speed = &report.Criteria[i]
+		}
+	}
+	if speed == nil {
+		t.Fatalf("no -min-speed in %+v", report.Criteria)
+	}
+	if speed.Value != "per source" || speed.OnlyFailing != 2 {
+		t.Errorf("-min-speed: %+v, want per source failing 2", speed)
+	}
+	// Slow 3.5 ≥ 4×0.75，Sourced 1.2 ≥ 2×0.5
+	want := []relaxedCount{{"75%", 1}, {"50%", 2}}
+	for i := range want {
+		if i >= len(speed.Relaxed) || speed.Relaxed[i] != want[i] {
+			t.Errorf("relaxed %+v, want %+v", speed.Relaxed, want)
+			break
+		}
+	}
+}
+
+func TestThresholdReportFast(t *testing.T) {
+	setFlags(t, "fast", "true", "min-speed", "4")
+	result := capResult("A", 0)
+	report := buildThresholdReport([]*speedtester.Result{result})
+	if report.Usable != 1 {
+		t.Errorf("-fast: %d usable, want 1", report.Usable)
+	}
+	for _, c := range report.Criteria {
+		if c.Flag == "min-speed" {
+			t.Errorf("-fast reports -min-speed: %+v", c)
+		}
+	}
+}
+
+func TestPrintThresholdReport(t *testing.T) {
+	report := &thresholdReport{Tested: 100, Usable: 10, Criteria: []criterionSensitivity{
+		{Flag: "min-upload-speed", Value: "2MB/s", OnlyFailing: 42, Relaxed: []relaxedCount{{"1.5MB/s", 30}, {"1MB/s", 47}}},
+	}}
+	var buf bytes.Buffer
+	printThresholdReport(&buf, report)
+	want := "-min-upload-speed 2MB/s: 42 nodes fail only this check; relaxing it to 1.5MB/s would add 20 usable nodes, to 1MB/s 37"
+	if !strings.Contains(buf.String(), want) || !strings.HasPrefix(buf.String(), "threshold report: 10 of 100") {
+		t.Errorf("report:\n%s", buf.String())
+	}
+}
+
+func TestResultsFileThresholdReport(t *testing.T) {
+	setFlags(t, "min-speed", "4", "max-latency", "200ms", "min-upload-speed", "0")
+	results := []*speedtester.Result{capResult("Fast", 10), capResult("Slow", 2.5)}
+	data, err := marshalResultsFile("", time.Now(), results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "threshold_report") {
+		t.Errorf("threshold_report written without -threshold-report:\n%s", data)
+	}
+
+	*printThresholds = true
+	data, err = marshalResultsFile("", time.Now(), results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		ThresholdReport *thresholdReport `json:"threshold_report"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	report := file.ThresholdReport
+	if report == nil || report.Tested != 2 || report.Usable != 1 {
+		t.Fatalf("threshold_report %+v in:\n%s", report, data)
+	}
+	for _, c := range report.Criteria {
+		if c.Flag == "min-speed" && (c.Value != "4MB/s" || c.OnlyFailing != 1 || len(c.Relaxed) != 2 || c.Relaxed[0] != (relaxedCount{"3MB/s", 1})) {
+			t.Errorf("-min-speed %+v", c)
+		}
+	}
+	if !strings.Contains(string(data), `"only_failing": 1`) {
+		t.Errorf("want snake_case keys:\n%s", data)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// txtFieldReplacer 把字段里的制表符和换行（包括 Unicode 的 NEL、行分隔符和段分隔符）替换成空格，
+// 保证一行一个节点、一列一个字段
+var txtFieldReplacer = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ", "\v", " ", "\f", " ",
+	"\u0085", " ", "\u2028", " ", "\u2029", " ")
+
+func txtField(s string) string {
+	s = strings.TrimSpace(txtFieldReplacer.Replace(s))
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatTxtOutput 生成 -output-txt 的内容：注释头说明列和生成时间，之后每行一个节点，
+// 列为 名称、出口 IP、出口国家、下载速度(MB/s)，未知的字段写 -
+func formatTxtOutput(now time.Time, results []*speedtester.Result) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# clash-speedtest %s\n", now.Format(time.RFC3339))
+	sb.WriteString("# name\texit_ip\tcountry\tdownload_mbps\n")
+	for _, result := range results {
+		speed := "-"
+		if result.DownloadSpeed > 0 {
+			speed = fmt.Sprintf("%.1f", result.DownloadSpeed/(1024*1024))
+		}
+		fmt.Fprintf(&sb, "%s\t%s\t%s\t%s\n",
+			txtField(result.ProxyName), txtField(result.ExitIP), txtField(result.CountryCode), speed)
+	}
+	return []byte(sb.String())
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestFormatTxtOutput(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	results := []*speedtester.Result{
+		{ProxyName: "JP 01", ExitIP: "203.0.113.7", CountryCode: "JP", DownloadSpeed: 12.44 * 1024 * 1024},
+		{ProxyName: "evil\tname\nwith\r\nbreaks", ExitIP: "2001:db8::1", CountryCode: "US", DownloadSpeed: 1024 * 1024},
+		{ProxyName: "unicode\u2028line\u2029para\u0085nel\vvt\fff"},
+		{ProxyName: " \t\n "},
+	}
+	got := string(formatTxtOutput(now, results))
+	want := "# clash-speedtest 2025-01-02T03:04:05Z\n" +
+		"# name\texit_ip\tcountry\tdownload_mbps\n" +
+		"JP 01\t203.0.113.7\tJP\t12.4\n" +
+		"evil name with breaks\t2001:db8::1\tUS\t1.0\n" +
+		"unicode line para nel vt ff\t-\t-\t-\n" +
+		"-\t-\t-\t-\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	for i, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n")[2:] {
+		if fields := strings.Split(line, "\t"); len(fields) != 4 {
+			t.Errorf("line %d has %d fields: %q", i, len(fields), line)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// optionWarning 表示可疑但不致命的参数组合，只打印警告不中止运行
+type optionWarning struct {
+	msg string
+}
+
+func (w *optionWarning) Error() string {
+	return w.msg
+}
+
+func warnf(format string, args ...any) error {
+	return &optionWarning{msg: fmt.Sprintf(format, args...)}
+}
+
+func isOptionWarning(err error) bool {
+	var w *optionWarning
+	return errors.As(err, &w)
+}
+
+// speedThresholdFlags 都以 MB/s 为单位，超过这个值基本可以确定是误填了 B/s
+var speedThresholdFlags = []string{
+	"min-speed", "min-download-speed", "min-upload-speed",
+	"good-download-speed-threshold", "open-speed-threshold", "max-plausible-speed", "min-sustained-speed",
+}
+
+const suspiciousSpeedMBps = 10000
+
+// impossibleSpeedMBps 超过这个值的 MB/s 阈值只可能是填了字节数，直接报错
+const impossibleSpeedMBps = 1e6
+
+// durationFlags 是不带单位时按毫秒处理的时间类 flag，见 durationFlag
+var durationFlags = []string{"timeout", "download-timeout", "max-latency", "max-new-conn-latency", "min-download-duration", "exec-timeout", "max-close-latency", "stream-stagger", "sustained"}
+
+// maxPerNodeTraffic 超过这个值的单节点流量基本是把字节数当成了 MB 之类的误填
+const maxPerNodeTraffic = 2 << 30
+
+// validateOptions 在 flag 解析、profile 合并之后检查参数之间的一致性，
+// 会顺带规范化 URL 类参数。返回的错误中 optionWarning 只需要提示，其余的应当中止运行
+func validateOptions(fs *flag.FlagSet) []error {
+	var errs []error
+	value := func(name string) string {
+		return fs.Lookup(name).Value.String()
+	}
+	isSet := func(name string) bool {
+		return optionSources[name] != "" && optionSources[name] != sourceDefault
+	}
+	float := func(name string) float64 {
+		v, _ := strconv.ParseFloat(value(name), 64)
+		return v
+	}
+
+	if value("fast") == "true" {
+		for _, name := range []string{"min-download-speed", "min-upload-speed", "min-speed", "extra-download-url", "good-download-speed-threshold"} {
+			if isSet(name) {
+				errs = append(errs, fmt.Errorf("-fast only tests latency, -%s has no effect; remove one of them", name))
+			}
+		}
+	}
+
+	output, goodOutput := value("output"), value("good-output")
+	if output != "" && goodOutput != "" {
+		absOutput, _ := filepath.Abs(output)
+		absGoodOutput, _ := filepath.Abs(goodOutput)
+		if absOutput == absGoodOutput {
+			errs = append(errs, fmt.Errorf("-output and -good-output both point to %s; use different files or set one of them to \"\"", absOutput))
+		}
+	}
+	if value("rename-city") == "true" && value("rename") != "true" {
+		errs = append(errs, warnf("-rename-city has no effect without -rename"))
+	}
+	if isSet("max-good-nodes") && goodOutput == "" {
+		errs = append(errs, warnf("-max-good-nodes has no effect without -good-output"))
+	}
+	if value("stream-output") == "true" {
+		if output == "" && goodOutput == "" {
+			errs = append(errs, warnf("-stream-output has no effect without -output or -good-output"))
+		} else if isSet("save-every") {
+			errs = append(errs, warnf("-save-every is ignored with -stream-output, the output files are written after every passing node"))
+		}
+	}
+
+	for _, name := range []string{"server-url", "download-server-url", "upload-server-url", "upload-fallback-server-url"} {
+		serverURL := value(name)
+		if serverURL == "" && name != "server-url" {
+			continue
+		}
+		normalized, err := normalizeServerURL(serverURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", name, err))
+		} else if normalized != serverURL {
+			fs.Set(name, normalized)
+			errs = append(errs, warnf("-%s normalized from %q to %q", name, serverURL, normalized))
+		}
+	}
+	uploadServer := value("upload-server-url")
+	if uploadServer == "" {
+		uploadServer = value("server-url")
+	}
+	if fallback := value("upload-fallback-server-url"); fallback != "" && fallback == uploadServer {
+		errs = append(errs, warnf("-upload-fallback-server-url is the upload server %s, the retry has no effect", fallback))
+	}
+
+	for _, name := range []string{"extra-connect-url", "extra-download-url"} {
+		for _, rawURL := range strings.Split(value(name), ",") {
+			if rawURL == "" {
+				continue
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("-%s: %q is not a valid http(s) url", name, rawURL))
+			}
+		}
+	}
+
+	for _, name := range speedThresholdFlags {
+		// 按用户输入的写法回显，float64 的默认格式会把 5242880 打印成 5.24288e+06
+		if v := float(name); v > impossibleSpeedMBps {
+			errs = append(errs, fmt.Errorf("-%s %s is in MB/s, did you mean %.2f (value given in B/s)?", name, strconv.FormatFloat(v, 'f', -1, 64), v/1024/1024))
+		} else if v > suspiciousSpeedMBps {
+			errs = append(errs, warnf("-%s %s is in MB/s, did you mean %.2f (value given in B/s)?", name, strconv.FormatFloat(v, 'f', -1, 64), v/1024/1024))
+		}
+		if float(name) < 0 {
+			errs = append(errs, fmt.Errorf("-%s must not be negative", name))
+		}
+	}
+	if goodThreshold, minSpeed := float("good-download-speed-threshold"), float("min-speed"); goodThreshold < minSpeed {
+		errs = append(errs, warnf("-good-download-speed-threshold %g is lower than -min-speed %g, every usable node will be good", goodThreshold, minSpeed))
+	}
+
+	if v, _ := strconv.Atoi(value("concurrent")); v <= 0 {
+		errs = append(errs, fmt.Errorf("-concurrent must be greater than 0"))
+	}
+	if v, _ := strconv.Atoi(value("node-concurrent")); v <= 0 {
+		errs = append(errs, fmt.Errorf("-node-concurrent must be greater than 0"))
+	}
+	if v, _ := strconv.Atoi(value("max-jobs")); v <= 0 {
+		errs = append(errs, fmt.Errorf("-max-jobs must be greater than 0"))
+	}
+	for _, name := range []string{"download-size", "upload-size", "provider-depth", "max-providers", "source-ban-streak", "retries", "upload-concurrent", "max-good-nodes"} {
+		if v, _ := strconv.Atoi(value(name)); v < 0 {
+			errs = append(errs, fmt.Errorf("-%s must not be negative", name))
+		}
+	}
+	// 每个节点最多下载两次（测量结果可疑时会加倍重测一次）再上传一次
+	downloadBytes, _ := strconv.Atoi(value("download-size"))
+	uploadBytes, _ := strconv.Atoi(value("upload-size"))
+	sustainedBytes := 0
+	if value("sustained") != "0s" {
+		sustainedBytes, _ = strconv.Atoi(value("sustained-max-size"))
+	}
+	if perNode := 3*downloadBytes + uploadBytes + sustainedBytes; perNode > maxPerNodeTraffic {
+		errs = append(errs, warnf("each node may transfer up to %s (-download-size %s, -upload-size %s), did you mean MB instead of bytes?",
+			speedtester.FormatByteSize(perNode), speedtester.FormatByteSize(downloadBytes), speedtester.FormatByteSize(uploadBytes)))
+	}
+	if v, _ := strconv.Atoi(value("upload-chunk-size")); v <= 0 {
+		errs = append(errs, fmt.Errorf("-upload-chunk-size must be greater than 0"))
+	}
+	v, _ := strconv.Atoi(value("max-download-bytes"))
+	if v == 0 && isSet("min-sample-duration") {
+		errs = append(errs, warnf("-min-sample-duration has no effect without -max-download-bytes"))
+	}
+	if value("auto-concurrent") == "true" {
+		if v > 0 {
+			errs = append(errs, warnf("-auto-concurrent has no effect with -max-download-bytes, the download test uses a fixed -concurrent"))
+		} else if value("rate-limit") != "" {
+			errs = append(errs, warnf("-auto-concurrent has no effect with -rate-limit, the download test uses a fixed -concurrent"))
+		}
+	}
+	for _, name := range durationFlags {
+		if strings.HasPrefix(value(name), "-") {
+			errs = append(errs, fmt.Errorf("-%s must not be negative", name))
+			continue
+		}
+		// 多半是 -timeout 5000ns 这类把毫秒数写成了纳秒的情况
+		d, _ := time.ParseDuration(value(name))
+		if d > 0 && d < time.Millisecond && value("allow-tiny-durations") != "true" {
+			errs = append(errs, fmt.Errorf("-%s %s is suspiciously small, did you mean %dms? (use -allow-tiny-durations to keep it)", name, value(name), d.Nanoseconds()))
+		}
+	}
+
+	if value("require-upload-integrity") == "true" {
+		if v, _ := strconv.Atoi(value("upload-integrity-size")); v <= 0 {
+			errs = append(errs, fmt.Errorf("-require-upload-integrity needs -upload-integrity-size greater than 0"))
+		}
+		if isCloudflareServer(uploadServer) {
+			errs = append(errs, fmt.Errorf("-require-upload-integrity: %s does not support /__hash, use a self-hosted download-server", uploadServer))
+		}
+	} else if v, _ := strconv.Atoi(value("upload-integrity-size")); v > 0 && isCloudflareServer(uploadServer) {
+		errs = append(errs, warnf("-upload-integrity-size: %s does not support /__hash, every node fails the integrity check", uploadServer))
+	}
+
+	if value("tamper-check") == "true" {
+		downloadServer := value("download-server-url")
+		if downloadServer == "" {
+			downloadServer = value("server-url")
+		}
+		tamperURL := value("tamper-check-url")
+		if tamperURL == "" && isCloudflareServer(downloadServer) {
+			errs = append(errs, fmt.Errorf("-tamper-check: %s does not serve /__known, use a self-hosted download-server or -tamper-check-url", downloadServer))
+		}
+		if tamperURL == "" {
+			tamperURL = downloadServer
+		}
+		if strings.HasPrefix(tamperURL, "https://") {
+			errs = append(errs, warnf("-tamper-check over https can not see content injected into plain http, use an http:// url"))
+		}
+	} else if value("tamper-check-url") != "" || value("tamper-check-sha256") != "" {
+		errs = append(errs, warnf("-tamper-check-url and -tamper-check-sha256 have no effect without -tamper-check"))
+	}
+	if value("ip-family-fallback") == "true" {
+		downloadServer := value("download-server-url")
+		if downloadServer == "" {
+			downloadServer = value("server-url")
+		}
+		if u, err := url.Parse(downloadServer); err == nil && net.ParseIP(u.Hostname()) != nil {
+			errs = append(errs, warnf("-ip-family-fallback has no effect when the download server is an ip address"))
+		}
+	}
+	if sum := value("tamper-check-sha256"); sum != "" {
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			errs = append(errs, fmt.Errorf("-tamper-check-sha256: %q is not a hex sha256", sum))
+		}
+	} else if u := value("tamper-check-url"); u != "" && !strings.HasSuffix(u, "/__known") {
+		errs = append(errs, warnf("-tamper-check-url without -tamper-check-sha256 is compared with the download-server /__known page"))
+	}
+
+	if peakHours := value("peak-hours"); peakHours != "" {
+		if _, err := parseHourRange(peakHours); err != nil {
+			errs = append(errs, fmt.Errorf("-peak-hours: %w", err))
+		}
+		if value("history-file") == "" {
+			errs = append(errs, fmt.Errorf("-peak-hours needs -history-file to collect samples across runs"))
+		}
+	}
+	if value("scenario-output") != "" && value("scenarios") == "" {
+		errs = append(errs, fmt.Errorf("-scenario-output needs -scenarios"))
+	}
+	if value("live") == "true" && value("oneline") == "true" {
+		errs = append(errs, fmt.Errorf("-live and -oneline both print results as nodes are tested, use one of them"))
+	}
+	if value("name-prefix") == "" {
+		errs = append(errs, fmt.Errorf("-name-prefix must not be empty, use none to keep the original names"))
+	}
+	switch value("console") {
+	case consoleFull:
+	case consoleDelta:
+		if value("history-file") == "" {
+			errs = append(errs, warnf("-console delta compares with the previous run in -history-file, without it the full table is printed"))
+		}
+		if value("interactive-save") == "true" || value("oneline") == "true" {
+			errs = append(errs, warnf("-console delta is ignored with -interactive-save and -oneline"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("-console must be full or delta"))
+	}
+	if float("delta-threshold") < 0 {
+		errs = append(errs, fmt.Errorf("-delta-threshold must not be negative"))
+	}
+	if value("interactive-save") == "true" && value("oneline") == "true" {
+		errs = append(errs, fmt.Errorf("-interactive-save picks nodes from the table, which -oneline does not print"))
+	}
+	if _, err := groupKeyFunc(value("group-by")); err != nil {
+		errs = append(errs, fmt.Errorf("-group-by: %w", err))
+	}
+	if s, err := parseResultSort(value("sort")); err != nil {
+		errs = append(errs, fmt.Errorf("-sort: %w", err))
+	} else if s.key == "peak-speed" && value("peak-hours") == "" {
+		errs = append(errs, fmt.Errorf("-sort peak-speed needs -peak-hours"))
+	}
+
+	if value("only-changed") == "true" && value("history-file") == "" {
+		errs = append(errs, fmt.Errorf("-only-changed needs -history-file to keep previous results"))
+	}
+	if strings.HasPrefix(value("max-result-age"), "-") {
+		errs = append(errs, fmt.Errorf("-max-result-age must not be negative"))
+	} else if isSet("max-result-age") && value("only-changed") != "true" {
+		errs = append(errs, warnf("-max-result-age has no effect without -only-changed"))
+	}
+
+	if doh := value("doh"); doh != "" {
+		if u, err := url.Parse(doh); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-doh: %q is not a valid https url", doh))
+		} else if net.ParseIP(u.Hostname()) == nil {
+			errs = append(errs, warnf("-doh endpoint %s is a hostname and will be resolved by the system resolver, use an ip address to avoid any local dns", u.Hostname()))
+		}
+	}
+	if limit := value("rate-limit"); limit != "" {
+		if rate, err := speedtester.ParseRate(limit); err != nil {
+			errs = append(errs, fmt.Errorf("-rate-limit: %w", err))
+		} else if minSpeed := float("min-speed"); minSpeed*1024*1024 >= rate/float("node-concurrent") {
+			errs = append(errs, warnf("-rate-limit %s is shared by -node-concurrent nodes, each gets less than -min-speed %gMB/s and will be filtered; lower -min-speed for availability runs", limit, minSpeed))
+		}
+	}
+	if v, err := speedtester.ParseVolatileFields(value("volatile-fields")); err != nil {
+		errs = append(errs, fmt.Errorf("-volatile-fields: %w", err))
+	} else if v.Empty() && value("strip-volatile") == "true" {
+		errs = append(errs, warnf("-strip-volatile has no effect with an empty -volatile-fields"))
+	}
+	if _, err := speedtester.ParseProxyTypes(value("type")); err != nil {
+		errs = append(errs, fmt.Errorf("-type: %w", err))
+	}
+	if _, err := speedtester.ParseTypeOverrides(value("type-overrides")); err != nil {
+		errs = append(errs, fmt.Errorf("-type-overrides: %w", err))
+	}
+	if err := speedtester.CheckImpersonation(value("impersonate")); err != nil {
+		errs = append(errs, fmt.Errorf("-impersonate: %w", err))
+	}
+	if u, err := url.Parse(value("clash-delay-url")); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("-clash-delay-url: %q is not a valid http(s) url", value("clash-delay-url")))
+	}
+	if wsURL := value("test-websocket"); wsURL != "" {
+		u, err := url.Parse(wsURL)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-test-websocket: %q is not a valid ws(s) url", wsURL))
+		}
+	} else if value("require-websocket") == "true" {
+		errs = append(errs, fmt.Errorf("-require-websocket needs -test-websocket"))
+	}
+
+	switch value("latency-connection") {
+	case "reuse":
+		if isSet("max-new-conn-latency") {
+			errs = append(errs, fmt.Errorf("-max-new-conn-latency needs -latency-connection new or both"))
+		}
+	case "new", "both":
+	default:
+		errs = append(errs, fmt.Errorf("-latency-connection: unknown value %q, supported: reuse, new, both", value("latency-connection")))
+	}
+
+	if v := float("ping-interval-jitter"); v < 0 || v >= 1 {
+		errs = append(errs, fmt.Errorf("-ping-interval-jitter must be in [0, 1)"))
+	}
+
+	for _, country := range strings.Split(value("server-countries"), ",") {
+		if country = strings.TrimSpace(country); country != "" && len(country) != 2 {
+			errs = append(errs, fmt.Errorf("-server-countries: %q is not a two-letter country code", country))
+		}
+	}
+	if value("server-countries-strict") == "true" && value("server-countries") == "" {
+		errs = append(errs, warnf("-server-countries-strict has no effect without -server-countries"))
+	}
+
+	if _, err := parseScoreWeights(value("score-weights")); err != nil {
+		errs = append(errs, fmt.Errorf("-score-weights: %w", err))
+	}
+
+	if v, _ := strconv.Atoi(value("min-usable")); v < 0 {
+		errs = append(errs, fmt.Errorf("-min-usable must not be negative"))
+	}
+	if v, _ := strconv.Atoi(value("min-countries")); v < 0 {
+		errs = append(errs, fmt.Errorf("-min-countries must not be negative"))
+	} else if v > 0 && (value("good-output") == "" || value("fast") == "true") {
+		errs = append(errs, warnf("-min-countries only affects -good-output"))
+	}
+
+	if shareURL := value("share-url"); shareURL != "" {
+		if u, err := url.Parse(shareURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-share-url: %q is not a valid http(s) url", shareURL))
+		} else if u.Scheme == "http" && value("share-token") != "" {
+			errs = append(errs, warnf("-share-token is sent in plain text over %s", shareURL))
+		}
+	}
+
+	if isSet("min-sustained-speed") && value("sustained") == "0s" {
+		errs = append(errs, fmt.Errorf("-min-sustained-speed needs -sustained"))
+	}
+	if strings.HasPrefix(value("sustained"), "-") {
+		errs = append(errs, fmt.Errorf("-sustained must not be negative"))
+	}
+
+	if _, err := parseCSVColumns(value("csv-columns")); err != nil {
+		errs = append(errs, fmt.Errorf("-csv-columns: %w", err))
+	}
+
+	var toStdout []string
+	for _, name := range stdoutArtifacts {
+		if value(name) == stdoutPath {
+			toStdout = append(toStdout, "-"+name)
+		}
+	}
+	if len(toStdout) > 1 {
+		errs = append(errs, fmt.Errorf("only one output can be written to stdout, got %s", strings.Join(toStdout, ", ")))
+	}
+
+	if _, err := speedtester.ParseGeoProviders(value("geo-provider")); err != nil {
+		errs = append(errs, fmt.Errorf("-geo-provider: %w", err))
+	}
+
+	if _, err := speedtester.ParseCCSweep(value("cc-sweep")); err != nil {
+		errs = append(errs, fmt.Errorf("-cc-sweep: %w", err))
+	}
+	if region := value("my-region"); region != "" && !speedtester.KnownRegion(region) {
+		errs = append(errs, fmt.Errorf("-my-region %q is not a supported country code", region))
+	}
+	if ip := value("my-ip"); ip != "" && net.ParseIP(ip) == nil {
+		errs = append(errs, fmt.Errorf("-my-ip %q is not a valid ip address", ip))
+	}
+
+	if v, _ := strconv.Atoi(value("min-age")); v < 0 {
+		errs = append(errs, fmt.Errorf("-min-age must not be negative"))
+	} else if v > 1 && value("history-file") == "" {
+		errs = append(errs, fmt.Errorf("-min-age needs -history-file to count runs"))
+	}
+
+	if isSet("exec-veto") && value("exec-per-result") == "" {
+		errs = append(errs, warnf("-exec-veto has no effect without -exec-per-result"))
+	}
+	if v, _ := strconv.Atoi(value("exec-concurrency")); v <= 0 {
+		errs = append(errs, fmt.Errorf("-exec-concurrency must be greater than 0"))
+	}
+
+	if v, _ := strconv.Atoi(value("max-per-subnet")); v < 0 {
+		errs = append(errs, fmt.Errorf("-max-per-subnet must not be negative"))
+	}
+
+	if v := float("hysteresis-margin"); v < 0 || v >= 1 {
+		errs = append(errs, fmt.Errorf("-hysteresis-margin must be in [0, 1)"))
+	}
+	if v, _ := strconv.Atoi(value("drop-after")); v < 1 {
+		errs = append(errs, fmt.Errorf("-drop-after must be at least 1"))
+	} else if v > 1 && value("history-file") == "" {
+		errs = append(errs, fmt.Errorf("-drop-after needs -history-file to count consecutive failures"))
+	}
+
+	if value("preserve-source-content") == "true" && value("output-per-source") == "" {
+		errs = append(errs, warnf("-preserve-source-content has no effect without -output-per-source"))
+	}
+
+	if listen := value("listen"); listen != "" {
+		host, _, err := net.SplitHostPort(listen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-listen: %w", err))
+		} else if value("sub-token") == "" && host != "127.0.0.1" && host != "localhost" && host != "::1" {
+			errs = append(errs, warnf("-listen %s without -sub-token exposes the subscription to anyone who can reach it", listen))
+		}
+	} else if value("sub-token") != "" {
+		errs = append(errs, warnf("-sub-token has no effect without -listen"))
+	}
+	if value("listen") != "" && value("c") == "" && value("sources") == "" && value("sub-token") == "" {
+		// 任务接口会下载调用方给的地址，结果里有节点的密码
+		errs = append(errs, fmt.Errorf("the job api (-listen without -c and -sources) needs -sub-token"))
+	}
+	if v, _ := strconv.Atoi(value("sub-update-interval")); v < 0 {
+		errs = append(errs, fmt.Errorf("-sub-update-interval must not be negative"))
+	}
+
+	if value("inject-filter") != "" && value("inject") == "" {
+		errs = append(errs, warnf("-inject-filter has no effect without -inject"))
+	}
+	for _, name := range []string{"exclude-asn", "asn-allowlist"} {
+		if _, err := parseASNList(value(name)); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", name, err))
+		}
+	}
+	if excluded, allowed := value("exclude-asn"), value("asn-allowlist"); excluded != "" && allowed != "" {
+		excludedASNs, _ := parseASNList(excluded)
+		allowedASNs, _ := parseASNList(allowed)
+		for asn := range excludedASNs {
+			if allowedASNs[asn] {
+				errs = append(errs, warnf("AS%d is in both -exclude-asn and -asn-allowlist, it will be excluded", asn))
+			}
+		}
+	}
+	return errs
+}
+
+// serverEndpoints 是测速时拼接在服务器地址后面的路径
+var serverEndpoints = []string{"__down", "__up", "__hash", "__known"}
+
+// isCloudflareServer Cloudflare 只提供 /__down 和 /__up，自建的 download-server 还提供 /__hash 和 /__known
+func isCloudflareServer(serverURL string) bool {
+	return strings.Contains(serverURL, "speed.cloudflare.com")
+}
+
+// normalizeServerURL 补全缺失的 scheme 并去掉末尾的 /。
+// 测速路径直接拼接在地址后面，带查询参数、指向具体接口或其他测速后端脚本（例如 LibreSpeed 的 garbage.php）的地址都不能用
+func normalizeServerURL(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", fmt.Errorf("must not be empty")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q, use http or https", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host in %q", rawURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q has a query or fragment, use the base url that /__down and /__up are appended to", rawURL)
+	}
+	_, last := path.Split(strings.TrimRight(u.Path, "/"))
+	if slices.Contains(serverEndpoints, last) {
+		return "", fmt.Errorf("%q points at the /%s endpoint, use the base url of the server", rawURL, last)
+	}
+	if strings.Contains(last, ".") {
+		return "", fmt.Errorf("%q looks like the script of another speed test backend, only servers serving /__down and /__up are supported", rawURL)
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
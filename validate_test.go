@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// resetFlags 把 flag.CommandLine 上的参数恢复成默认值，可重复的参数清空。go test 自己的 test.* 参数不动
+func resetFlags() {
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return
+		}
+		if list, ok := f.Value.(*stringList); ok {
+			*list = nil
+		} else if f.Value.String() != f.DefValue {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	clear(optionSources)
+}
+
+// setFlags 从默认值开始按 name, value 成对设置 flag.CommandLine 上的参数并记为命令行来源，测试结束后恢复默认值
+func setFlags(t *testing.T, pairs ...string) {
+	t.Helper()
+	resetFlags()
+	t.Cleanup(resetFlags)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if err := flag.Set(pairs[i], pairs[i+1]); err != nil {
+			t.Fatalf("-%s %s: %v", pairs[i], pairs[i+1], err)
+		}
+		optionSources[pairs[i]] = sourceFlag
+	}
+}
+
+// validate 返回 validateOptions 的错误和警告文本
+func validate(t *testing.T, pairs ...string) (errs, warnings []string) {
+	t.Helper()
+	setFlags(t, pairs...)
+	for _, err := range validateOptions(flag.CommandLine) {
+		if isOptionWarning(err) {
+			warnings = append(warnings, err.Error())
+		} else {
+			errs = append(errs, err.Error())
+		}
+	}
+	return errs, warnings
+}
+
+func containsMessage(messages []string, substr string) bool {
+	for _, message := range messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateDefaults(t *testing.T) {
+	errs, _ := validate(t)
+	if len(errs) > 0 {
+		t.Fatalf("default options are invalid: %v", errs)
+	}
+}
+
+func TestValidateStdoutArtifacts(t *testing.T) {
+	errs, _ := validate(t, "output", "-", "results-json", "-")
+	if !containsMessage(errs, "only one output can be written to stdout") {
+		t.Fatalf("two stdout artifacts accepted, errors: %v", errs)
+	}
+}
+
+// 标准输出被占用时 annotation 改写到标准错误，-gh-summary 和写到标准输出的文件可以同时使用
+func TestValidateGHSummaryWithStdoutArtifact(t *testing.T) {
+	errs, warnings := validate(t, "gh-summary", "summary.md", "csv", "-")
+	if len(errs) > 0 || len(warnings) > 0 {
+		t.Fatalf("unexpected errors %v, warnings %v", errs, warnings)
+	}
+}
+
+func TestValidateJobAPINeedsToken(t *testing.T) {
+	errs, _ := validate(t, "listen", "127.0.0.1:8080")
+	if !containsMessage(errs, "the job api (-listen without -c and -sources) needs -sub-token") {
+		t.Fatalf("job api without token accepted, errors: %v", errs)
+	}
+	if errs, _ := validate(t, "listen", "127.0.0.1:8080", "sub-token", "secret"); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if errs, _ := validate(t, "listen", "127.0.0.1:8080", "c", "config.yaml"); len(errs) > 0 {
+		t.Fatalf("subscription server without token rejected: %v", errs)
+	}
+}
+
+// TestValidateRules 每条规则一个用例，只设置触发它所需的参数
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		err   string
+		warn  string
+	}{
+		{"fast with speed threshold", []string{"fast", "true", "min-download-speed", "5"}, "-fast only tests latency, -min-download-speed has no effect", ""},
+		{"fast with extra download", []string{"fast", "true", "extra-download-url", "https://example.com/a"}, "-extra-download-url has no effect", ""},
+		{"same output files", []string{"output", "out.yaml", "good-output", "./out.yaml"}, "-output and -good-output both point to", ""},
+		{"rename city without rename", []string{"rename-city", "true"}, "", "-rename-city has no effect without -rename"},
+		{"max good nodes without good output", []string{"max-good-nodes", "3", "good-output", ""}, "", "-max-good-nodes has no effect without -good-output"},
+		{"stream output without outputs", []string{"stream-output", "true", "output", "", "good-output", ""}, "", "-stream-output has no effect"},
+		{"stream output with save every", []string{"stream-output", "true", "output", "out.yaml", "save-every", "10"}, "", "-save-every is ignored with -stream-output"},
+		{"server url scheme", []string{"server-url", "ftp://example.com"}, `-server-url: unsupported scheme "ftp"`, ""},
+		{"server url normalized", []string{"server-url", "example.com/"}, "", `-server-url normalized from "example.com/" to "https://example.com"`},
+		{"upload server without host", []string{"upload-server-url", "http://"}, "-upload-server-url: missing host", ""},
+		{"extra connect url", []string{"extra-connect-url", "example.com"}, `-extra-connect-url: "example.com" is not a valid http(s) url`, ""},
+		{"speed in bytes", []string{"min-download-speed", "5242880"}, "-min-download-speed 5242880 is in MB/s, did you mean 5.00", ""},
+		{"suspicious speed", []string{"max-plausible-speed", "20000"}, "", "-max-plausible-speed 20000 is in MB/s"},
+		{"negative speed", []string{"min-upload-speed", "-1"}, "-min-upload-speed must not be negative", ""},
+		{"good threshold below min speed", []string{"min-speed", "10", "good-download-speed-threshold", "5"}, "", "lower than -min-speed 10"},
+		{"zero concurrent", []string{"concurrent", "0"}, "-concurrent must be greater than 0", ""},
+		{"zero node concurrent", []string{"node-concurrent", "0"}, "-node-concurrent must be greater than 0", ""},
+		{"zero max jobs", []string{"max-jobs", "0"}, "-max-jobs must be greater than 0", ""},
+		{"negative retries", []string{"retries", "-1"}, "-retries must not be negative", ""},
+		{"negative upload concurrent", []string{"upload-concurrent", "-1"}, "-upload-concurrent must not be negative", ""},
+		{"huge per node traffic", []string{"download-size", "1073741824"}, "", "did you mean MB instead of bytes?"},
+		{"zero upload chunk", []string{"upload-chunk-size", "0"}, "-upload-chunk-size must be greater than 0", ""},
+		{"max download bytes with auto concurrent", []string{"max-download-bytes", "1048576", "auto-concurrent", "true"}, "", "-auto-concurrent has no effect with -max-download-bytes, the download test uses a fixed -concurrent"},
+		{"rate limit with auto concurrent", []string{"rate-limit", "10MB/s", "auto-concurrent", "true"}, "", "-auto-concurrent has no effect with -rate-limit, the download test uses a fixed -concurrent"},
+		{"min sample duration alone", []string{"min-sample-duration", "2s"}, "", "-min-sample-duration has no effect without -max-download-bytes"},
+		{"negative duration", []string{"timeout", "-5s"}, "-timeout must not be negative", ""},
+		{"tiny duration", []string{"timeout", "5000ns"}, "-timeout 5µs is suspiciously small, did you mean 5000ms?", ""},
+		{"integrity without size", []string{"require-upload-integrity", "true", "upload-integrity-size", "0", "server-url", "http://127.0.0.1:8080"}, "-require-upload-integrity needs -upload-integrity-size", ""},
+		{"integrity on cloudflare", []string{"require-upload-integrity", "true"}, "does not support /__hash", ""},
+		{"integrity size on cloudflare", []string{"upload-integrity-size", "1024"}, "", "every node fails the integrity check"},
+		{"integrity size on self-hosted upload server", []string{"upload-integrity-size", "1024", "upload-server-url", "http://127.0.0.1:8080"}, "", ""},
+		{"tamper check with self-hosted download server", []string{"tamper-check", "true", "download-server-url", "http://127.0.0.1:8080"}, "", ""},
+		{"upload fallback same as upload server", []string{"upload-server-url", "http://127.0.0.1:8080", "upload-fallback-server-url", "http://127.0.0.1:8080"}, "", "the retry has no effect"},
+		{"librespeed upload server", []string{"upload-server-url", "https://librespeed.example.com/backend/empty.php"}, "-upload-server-url: \"https://librespeed.example.com/backend/empty.php\" looks like the script of another speed test backend", ""},
+		{"download server endpoint", []string{"download-server-url", "https://speed.cloudflare.com/__down"}, "points at the /__down endpoint", ""},
+		{"tamper check on cloudflare", []string{"tamper-check", "true"}, "does not serve /__known", ""},
+		{"tamper check over https", []string{"tamper-check", "true", "server-url", "https://speed.example.com"}, "", "-tamper-check over https"},
+		{"tamper url alone", []string{"tamper-check-url", "http://example.com/__known"}, "", "have no effect without -tamper-check"},
+		{"tamper sha256", []string{"tamper-check", "true", "server-url", "http://example.com", "tamper-check-sha256", "abc"}, `-tamper-check-sha256: "abc" is not a hex sha256`, ""},
+		{"tamper url without sha256", []string{"tamper-check", "true", "tamper-check-url", "http://example.com/page"}, "", "-tamper-check-url without -tamper-check-sha256"},
+		{"ip family fallback with ip server", []string{"ip-family-fallback", "true", "server-url", "http://1.2.3.4"}, "", "-ip-family-fallback has no effect"},
+		{"peak hours invalid", []string{"peak-hours", "25-3", "history-file", "h.json"}, "-peak-hours:", ""},
+		{"peak hours without history", []string{"peak-hours", "20-23"}, "-peak-hours needs -history-file", ""},
+		{"scenario output alone", []string{"scenario-output", "s.json"}, "-scenario-output needs -scenarios", ""},
+		{"live and oneline", []string{"live", "true", "oneline", "true"}, "-live and -oneline both print results", ""},
+		{"empty name prefix", []string{"name-prefix", ""}, "-name-prefix must not be empty", ""},
+		{"console unknown", []string{"console", "short"}, "-console must be full or delta", ""},
+		{"console delta without history", []string{"console", "delta"}, "", "-console delta compares with the previous run"},
+		{"console delta with oneline", []string{"console", "delta", "history-file", "h.json", "oneline", "true"}, "", "-console delta is ignored"},
+		{"negative delta threshold", []string{"delta-threshold", "-1"}, "-delta-threshold must not be negative", ""},
+		{"interactive save with oneline", []string{"interactive-save", "true", "oneline", "true"}, "-interactive-save picks nodes from the table", ""},
+		{"group by unknown", []string{"group-by", "planet"}, "-group-by:", ""},
+		{"sort unknown", []string{"sort", "colour"}, "-sort:", ""},
+		{"sort peak speed without peak hours", []string{"sort", "peak-speed"}, "-sort peak-speed needs -peak-hours", ""},
+		{"only changed without history", []string{"only-changed", "true"}, "-only-changed needs -history-file", ""},
+		{"negative max result age", []string{"max-result-age", "-1h"}, "-max-result-age must not be negative", ""},
+		{"max result age alone", []string{"max-result-age", "2h"}, "", "-max-result-age has no effect without -only-changed"},
+		{"doh over http", []string{"doh", "http://1.1.1.1/dns-query"}, `-doh: "http://1.1.1.1/dns-query" is not a valid https url`, ""},
+		{"doh hostname", []string{"doh", "https://dns.google/dns-query"}, "", "-doh endpoint dns.google is a hostname"},
+		{"rate limit invalid", []string{"rate-limit", "fast"}, "-rate-limit:", ""},
+		{"rate limit below min speed", []string{"rate-limit", "10Mbps", "min-speed", "5"}, "", "-rate-limit 10Mbps is shared by -node-concurrent nodes"},
+		{"volatile fields invalid", []string{"volatile-fields", "name"}, "-volatile-fields: name can not be dropped", ""},
+		{"strip volatile without fields", []string{"strip-volatile", "true", "volatile-fields", ""}, "", "-strip-volatile has no effect"},
+		{"type unknown", []string{"type", "carrier-pigeon"}, "-type:", ""},
+		{"type overrides invalid", []string{"type-overrides", "vmess"}, "-type-overrides:", ""},
+		{"impersonate unknown", []string{"impersonate", "netscape"}, "-impersonate:", ""},
+		{"clash delay url", []string{"clash-delay-url", "example.com/generate_204"}, "-clash-delay-url:", ""},
+		{"websocket scheme", []string{"test-websocket", "http://example.com/ws"}, `-test-websocket: "http://example.com/ws" is not a valid ws(s) url`, ""},
+		{"require websocket alone", []string{"require-websocket", "true"}, "-require-websocket needs -test-websocket", ""},
+		{"new conn latency with reuse", []string{"latency-connection", "reuse", "max-new-conn-latency", "500ms"}, "-max-new-conn-latency needs -latency-connection new or both", ""},
+		{"latency connection unknown", []string{"latency-connection", "pooled"}, `-latency-connection: unknown value "pooled"`, ""},
+		{"ping jitter out of range", []string{"ping-interval-jitter", "1"}, "-ping-interval-jitter must be in [0, 1)", ""},
+		{"server country code", []string{"server-countries", "HK,USA"}, `-server-countries: "USA" is not a two-letter country code`, ""},
+		{"server countries strict alone", []string{"server-countries-strict", "true"}, "", "-server-countries-strict has no effect"},
+		{"score weights invalid", []string{"score-weights", "speed=x"}, "-score-weights:", ""},
+		{"negative min usable", []string{"min-usable", "-1"}, "-min-usable must not be negative", ""},
+		{"negative min countries", []string{"min-countries", "-1"}, "-min-countries must not be negative", ""},
+		{"min countries with fast", []string{"min-countries", "3", "fast", "true"}, "", "-min-countries only affects -good-output"},
+		{"share url scheme", []string{"share-url", "ftp://example.com"}, `-share-url: "ftp://example.com" is not a valid http(s) url`, ""},
+		{"share token over http", []string{"share-url", "http://example.com", "share-token", "secret"}, "", "-share-token is sent in plain text"},
+		{"sustained speed alone", []string{"min-sustained-speed", "1"}, "-min-sustained-speed needs -sustained", ""},
+		{"negative sustained", []string{"sustained", "-1s"}, "-sustained must not be negative", ""},
+		{"csv columns unknown", []string{"csv-columns", "name,colour"}, "-csv-columns:", ""},
+		{"geo provider unknown", []string{"geo-provider", "crystal-ball"}, "-geo-provider:", ""},
+		{"cc sweep invalid", []string{"cc-sweep", "warp-speed"}, "-cc-sweep:", ""},
+		{"unknown region", []string{"my-region", "XX"}, `-my-region "XX" is not a supported country code`, ""},
+		{"invalid my ip", []string{"my-ip", "1.2.3"}, `-my-ip "1.2.3" is not a valid ip address`, ""},
+		{"negative min age", []string{"min-age", "-1"}, "-min-age must not be negative", ""},
+		{"min age without history", []string{"min-age", "3"}, "-min-age needs -history-file", ""},
+		{"exec veto alone", []string{"exec-veto", "true"}, "", "-exec-veto has no effect without -exec-per-result"},
+		{"zero exec concurrency", []string{"exec-concurrency", "0"}, "-exec-concurrency must be greater than 0", ""},
+		{"negative max per subnet", []string{"max-per-subnet", "-1"}, "-max-per-subnet must not be negative", ""},
+		{"hysteresis margin out of range", []string{"hysteresis-margin", "1.5"}, "-hysteresis-margin must be in [0, 1)", ""},
+		{"zero drop after", []string{"drop-after", "0"}, "-drop-after must be at least 1", ""},
+		{"drop after without history", []string{"drop-after", "3"}, "-drop-after needs -history-file", ""},
+		{"preserve source content alone", []string{"preserve-source-content", "true"}, "", "-preserve-source-content has no effect"},
+		{"listen invalid", []string{"listen", "8090", "c", "config.yaml"}, "-listen:", ""},
+		{"listen public without token", []string{"listen", ":8090", "c", "config.yaml"}, "", "-listen :8090 without -sub-token exposes the subscription"},
+		{"sub token without listen", []string{"sub-token", "secret"}, "", "-sub-token has no effect without -listen"},
+		{"negative sub update interval", []string{"sub-update-interval", "-1"}, "-sub-update-interval must not be negative", ""},
+		{"inject filter alone", []string{"inject-filter", "HK"}, "", "-inject-filter has no effect without -inject"},
+		{"exclude asn invalid", []string{"exclude-asn", "AS13335,cloudflare"}, `-exclude-asn: invalid ASN "cloudflare"`, ""},
+		{"asn allowlist invalid", []string{"asn-allowlist", "AS0"}, `-asn-allowlist: invalid ASN "0"`, ""},
+		{"asn in both lists", []string{"exclude-asn", "AS13335", "asn-allowlist", "13335"}, "", "AS13335 is in both -exclude-asn and -asn-allowlist"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errs, warnings := validate(t, tc.flags...)
+			if tc.err != "" && !containsMessage(errs, tc.err) {
+				t.Errorf("missing error %q, errors: %v", tc.err, errs)
+			}
+			if tc.warn != "" && !containsMessage(warnings, tc.warn) {
+				t.Errorf("missing warning %q, warnings: %v", tc.warn, warnings)
+			}
+			if tc.err == "" && len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+		})
+	}
+}
+
+func TestNormalizeServerURL(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		ok       bool
+	}{
+		{"https://speed.cloudflare.com", "https://speed.cloudflare.com", true},
+		{"speed.example.com/", "https://speed.example.com", true},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080", true},
+		{"", "", false},
+		{"ws://example.com", "", false},
+		{"https:///path", "", false},
+		{"https://speed.example.com/speed/", "https://speed.example.com/speed", true},
+		{"https://speed.cloudflare.com/__down?bytes=0", "", false},
+		{"https://speed.example.com/__up", "", false},
+		{"https://librespeed.example.com/backend/garbage.php", "", false},
+	} {
+		got, err := normalizeServerURL(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("normalizeServerURL(%q) = %q, %v; want %q, ok %v", tc.in, got, err, tc.want, tc.ok)
+		}
+	}
+}
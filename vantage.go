@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// resultsVersion 在 -results-json 的格式出现不兼容变化时递增
+const resultsVersion = 1
+
+// resultsFile 是 -results-json 写出的全部测试结果，merge 子命令读取它
+type resultsFile struct {
+	Version  int            `json:"version"`
+	Vantage  string         `json:"vantage,omitempty"`
+	TestedAt time.Time      `json:"tested_at"`
+	Results  []*resultEntry `json:"results"`
+	// ByType、ByCountry、BySource 是 -group-by 选中的那种分组的汇总，和表格后面的分组汇总相同
+	ByType    []*groupStats `json:"by_type,omitempty"`
+	ByCountry []*groupStats `json:"by_country,omitempty"`
+	BySource  []*groupStats `json:"by_source,omitempty"`
+	// ThresholdReport 只在设置了 -threshold-report 时写入
+	ThresholdReport *thresholdReport `json:"threshold_report,omitempty"`
+}
+
+// resultEntry 是一个节点的测试结果，附带这次运行按阈值得出的判定
+type resultEntry struct {
+	*speedtester.Result
+	Usable bool `json:"usable"`
+	Good   bool `json:"good"`
+	// DemotedByCap 表示节点达到优质标准，但因为 -max-good-nodes 没有写进优质输出
+	DemotedByCap bool `json:"demoted_by_cap,omitempty"`
+}
+
+// marshalResultsFile 把本次测试的全部节点（包括不可用的）写成 JSON，设置了 -group-by、-threshold-report 时附带分组汇总和阈值报告
+func marshalResultsFile(vantage string, testedAt time.Time, allResults []*speedtester.Result) ([]byte, error) {
+	file := &resultsFile{
+		Version:  resultsVersion,
+		Vantage:  vantage,
+		TestedAt: testedAt,
+		Results:  make([]*resultEntry, 0, len(allResults)),
+	}
+	for _, result := range allResults {
+		file.Results = append(file.Results, &resultEntry{
+			Result:       result,
+			Usable:       isProxyUsable(result),
+			Good:         isProxyGood(result),
+			DemotedByCap: demotedNodes[result],
+		})
+	}
+	if groupKey, _ := groupKeyFunc(*groupBy); groupKey != nil {
+		groups := buildGroupStats(allResults, groupKey)
+		switch *groupBy {
+		case groupByType:
+			file.ByType = groups
+		case groupByCountry:
+			file.ByCountry = groups
+		case groupBySource:
+			file.BySource = groups
+		}
+	}
+	if *printThresholds {
+		file.ThresholdReport = buildThresholdReport(allResults)
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// loadResultsFile 读取 -results-json 写出的文件，没有记录位置名时用文件名代替
+func loadResultsFile(path string) (*resultsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &resultsFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if file.Version > resultsVersion {
+		return nil, fmt.Errorf("%s is written by a newer version (%d > %d)", path, file.Version, resultsVersion)
+	}
+	if file.Vantage == "" {
+		file.Vantage = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return file, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// useVolatileFields 在测试期间使用 spec 解析出的 volatileFields
+func useVolatileFields(t *testing.T, spec string) {
+	t.Helper()
+	v, err := speedtester.ParseVolatileFields(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := volatileFields
+	volatileFields = v
+	t.Cleanup(func() { volatileFields = previous })
+}
+
+func volatileResult(name, server string) *speedtester.Result {
+	return &speedtester.Result{
+		ProxyName: name,
+		ProxyConfig: map[string]any{
+			"name": name, "type": "vmess", "server": server, "port": 443, "uuid": "u", "alterId": 0, "cipher": "auto",
+			"network": "ws", "ws-opts": map[string]any{"path": "/ray", "headers": map[string]any{"X-Ts": "1735800000"}},
+		},
+	}
+}
+
+// outputProxies 解析 marshalResults 的输出
+func outputProxies(t *testing.T, results []*speedtester.Result) []map[string]any {
+	t.Helper()
+	data, err := marshalResults(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config struct {
+		Proxies []map[string]any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	return config.Proxies
+}
+
+func proxyNames(proxies []map[string]any) []string {
+	var names []string
+	for _, proxy := range proxies {
+		names = append(names, proxy["name"].(string))
+	}
+	return names
+}
+
+// -strip-volatile 去掉名称后缀时不和其它节点重名，重名的保留原名
+func TestStripVolatileNames(t *testing.T) {
+	useVolatileFields(t, "default,ws-opts.headers.X-Ts")
+	results := []*speedtester.Result{
+		volatileResult("HK 2025-01-02", "hk1.example.com"),
+		volatileResult("HK 2025-01-03", "hk2.example.com"),
+		volatileResult("JP 2025-01-02", "jp1.example.com"),
+		// 去掉后缀后和这个节点的原名相同，后面的节点不能占用
+		volatileResult("JP", "jp2.example.com"),
+	}
+
+	setFlags(t)
+	proxies := outputProxies(t, results)
+	if got := proxyNames(proxies); !slices.Equal(got, []string{"HK 2025-01-02", "HK 2025-01-03", "JP 2025-01-02", "JP"}) {
+		t.Errorf("names without -strip-volatile %q", got)
+	}
+	if _, ok := proxies[0]["ws-opts"].(map[string]any)["headers"]; !ok {
+		t.Error("volatile header removed without -strip-volatile")
+	}
+
+	setFlags(t, "strip-volatile", "true")
+	proxies = outputProxies(t, results)
+	if got := proxyNames(proxies); !slices.Equal(got, []string{"HK", "HK 2025-01-03", "JP 2025-01-02", "JP"}) {
+		t.Errorf("names with -strip-volatile %q", got)
+	}
+	for _, proxy := range proxies {
+		opts := proxy["ws-opts"].(map[string]any)
+		if _, ok := opts["headers"]; ok || opts["path"] != "/ray" {
+			t.Errorf("%s ws-opts %v", proxy["name"], opts)
+		}
+	}
+	// 输出是副本，结果里的配置不变
+	if results[0].ProxyConfig["name"] != "HK 2025-01-02" || results[0].ProxyConfig["ws-opts"].(map[string]any)["headers"] == nil {
+		t.Errorf("result config modified: %v", results[0].ProxyConfig)
+	}
+}
+
+// -rename 生成的名称不再去后缀，只删除字段
+func TestStripVolatileWithRename(t *testing.T) {
+	useVolatileFields(t, "default,ws-opts.headers.X-Ts")
+	setFlags(t, "strip-volatile", "true", "rename", "true")
+	results := []*speedtester.Result{volatileResult("HK 2025-01-02", "hk1.example.com"), volatileResult("HK 2025-01-03", "hk2.example.com")}
+	proxies := outputProxies(t, results)
+	names := proxyNames(proxies)
+	if len(names) != 2 || names[0] == names[1] {
+		t.Errorf("renamed names %q", names)
+	}
+	for _, proxy := range proxies {
+		if _, ok := proxy["ws-opts"].(map[string]any)["headers"]; ok {
+			t.Errorf("%s keeps the volatile header", proxy["name"])
+		}
+	}
+}
+
+// 两次运行之间只有易变字段变化的节点不算修改，上一次用 -strip-volatile 写出的输出也一样
+func TestDiffOutputVolatile(t *testing.T) {
+	useVolatileFields(t, "default,ws-opts.headers.X-Ts")
+	setFlags(t)
+	previous := volatileResult("HK 2025-01-02", "hk1.example.com").ProxyConfig
+	stripped := volatileFields.Strip(previous)
+	current := volatileResult("HK 2025-01-03", "hk1.example.com")
+	current.ProxyConfig["ws-opts"].(map[string]any)["headers"] = map[string]any{"X-Ts": "1735900000"}
+	for _, old := range []map[string]any{previous, stripped} {
+		diff := diffOutput([]map[string]any{old}, []*speedtester.Result{current})
+		if diff.Unchanged != 1 || len(diff.Changed) != 0 {
+			t.Errorf("previous %v: %+v", old, diff)
+		}
+	}
+
+	current.ProxyConfig["ws-opts"].(map[string]any)["path"] = "/other"
+	if diff := diffOutput([]map[string]any{previous}, []*speedtester.Result{current}); !slices.Equal(diff.Changed, []string{"HK 2025-01-03"}) {
+		t.Errorf("changed path not reported: %+v", diff)
+	}
+
+	useVolatileFields(t, "")
+	current.ProxyConfig["ws-opts"].(map[string]any)["path"] = "/ray"
+	if diff := diffOutput([]map[string]any{previous}, []*speedtester.Result{current}); len(diff.Changed) != 1 {
+		t.Errorf("volatile fields ignored with an empty -volatile-fields: %+v", diff)
+	}
+}